@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// agentOptions holds the flags of the "agent" command.
+type agentOptions struct {
+	logFlags
+	join     string
+	name     string
+	iface    string
+	sourceIP string
+	config   string
+}
+
+var agentDoc = &commandDoc{
+	synopsis: "pscanner agent --join coordinator:port [--name name] [--interface name | --source-ip address] [--config path]",
+	description: `Scan on behalf of a coordinator.
+
+An agent connects to a "pscanner scan --coordinate" process, takes a share
+of its targets and ports, probes them from this machine and sends the
+results back. Several agents split a large scan between them and scan it
+from their own vantage points. The agent keeps asking for work until it is
+stopped, so it serves the coordinator's later --watch runs and later
+scans on the same address too.
+
+The coordinator decides what is scanned and with which timing: an agent
+runs whatever its coordinator hands it, except targets outside the scope
+file of its own machine (see "pscanner scan --override-scope"). It refuses
+those shards, and the coordinator reports their probes as failed rather
+than handing them to another agent. Since agents run what they are handed,
+both sides need the same secret in $PSCANNER_AGENT_TOKEN, and the agent
+sends it with every request.
+Use an https:// coordinator URL, behind a TLS-terminating proxy, when the
+network between them is not trusted.`,
+	notes: map[string]string{
+		"join": `host:port is reached over plain HTTP; give a full http:// or https:// URL
+to choose.`,
+		"name": `Shown in the coordinator's log. Defaults to the host name; agents of one
+coordinator need distinct names.`,
+		"interface": `As for "pscanner scan --interface".`,
+		"config": `Only audit_log applies: every shard is recorded there as a scan of its
+own, under its lease ID.`,
+	},
+	examples: []string{
+		"PSCANNER_AGENT_TOKEN=$(cat token) pscanner agent --join scanhub.example.com:7070",
+		"pscanner agent --join https://scanhub.example.com/ --name dmz-1 --source-ip 10.50.0.7",
+	},
+}
+
+func (o *agentOptions) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("agent", flag.ExitOnError)
+	fs.StringVar(&o.join, "join", "", "Coordinator `address` (host:port or URL) to take work from (required)")
+	fs.StringVar(&o.name, "name", "", "Name of this agent (default: host name)")
+	fs.StringVar(&o.iface, "interface", "", "Send probes from this network interface `name`")
+	fs.StringVar(&o.sourceIP, "source-ip", "", "Send probes from this local `address`")
+	fs.StringVar(&o.config, "config", "", "Path to config file (default: user config dir)")
+	o.bindLog(fs)
+	fs.Usage = func() { writeCommandHelp(fs.Output(), "agent", fs, agentDoc, false) }
+	return fs
+}
+
+// runAgent implements the "agent" command.
+func runAgent(args []string) {
+	var o agentOptions
+	fs := o.flagSet()
+	_ = fs.Parse(args)
+	if err := o.startLog(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(2)
+	}
+	if o.join == "" {
+		fmt.Fprintln(os.Stderr, "error: --join is required")
+		fs.Usage()
+		os.Exit(2)
+	}
+	token := os.Getenv(agentTokenEnv)
+	if token == "" {
+		fmt.Fprintf(os.Stderr, "error: $%s is not set\n", agentTokenEnv)
+		os.Exit(2)
+	}
+	if o.name == "" {
+		o.name, _ = os.Hostname()
+	}
+	configPath := o.config
+	if configPath == "" {
+		configPath = defaultConfigPath()
+	}
+	cfg, err := loadConfig(configPath, o.config != "")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading config: %v\n", err)
+		os.Exit(2)
+	}
+	a := &scanAgent{
+		base:   agentURL(o.join),
+		name:   o.name,
+		token:  token,
+		client: &http.Client{Timeout: 30 * time.Second},
+		audit:  newAuditLog(cfg.AuditLog, fs),
+	}
+	if a.scope, err = loadScope(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(2)
+	}
+	if o.iface != "" || o.sourceIP != "" {
+		if a.source, err = newSourceDialer(o.iface, o.sourceIP, 0); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	slog.Info("agent taking work", "agent", a.name, "coordinator", a.base)
+	if err := a.run(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// agentURL turns the --join value into the coordinator's base URL.
+func agentURL(join string) string {
+	if !strings.Contains(join, "://") {
+		join = "http://" + join
+	}
+	return strings.TrimSuffix(join, "/")
+}
+
+// scanAgent takes shards from a coordinator and scans them.
+type scanAgent struct {
+	base   string
+	name   string
+	token  string
+	source *sourceDialer
+	scope  *scanScope // refuses shards outside the scope file
+	audit  *auditLog  // records every shard as a scan
+	client *http.Client
+}
+
+// probe runs the plan of a shard, recording it in the audit log. A shard
+// that cannot be recorded is not scanned.
+func (a *scanAgent) probe(ctx context.Context, p *scanPlan, audit *auditLog) ([]HostResult, error) {
+	params := p.params("")
+	if err := audit.record(auditEntry{Event: auditStart, Params: &params}); err != nil {
+		return nil, err
+	}
+	hosts := p.run(ctx, scanHooks{})
+	open := countOpen(hosts)
+	if err := audit.record(auditEntry{Event: auditFinish, OpenPorts: &open, Canceled: ctx.Err() != nil}); err != nil {
+		slog.Warn("audit log not written", "err", err)
+	}
+	return hosts, nil
+}
+
+// errBadToken ends the agent: asking again will not help.
+var errBadToken = errors.New("the coordinator refused the agent token")
+
+// run takes work until ctx is done. A coordinator that cannot be reached
+// is retried, as it may be between scans or restarting.
+func (a *scanAgent) run(ctx context.Context) error {
+	down := false
+	for ctx.Err() == nil {
+		var s shard
+		code, err := a.post(ctx, "/v1/lease", map[string]string{"agent": a.name}, &s)
+		switch {
+		case code == http.StatusUnauthorized:
+			return errBadToken
+		case err != nil:
+			if !down && ctx.Err() == nil {
+				slog.Warn("coordinator unavailable, retrying", "err", err)
+			}
+			down = true
+		case code == http.StatusOK:
+			down = false
+			a.scan(ctx, &s)
+			continue
+		default:
+			down = false
+		}
+		select {
+		case <-time.After(agentPoll):
+		case <-ctx.Done():
+		}
+	}
+	return nil
+}
+
+// scan probes a shard and reports the result. While it runs, heartbeats
+// keep the lease; if the coordinator has given the shard to another agent
+// in the meantime, the scan is abandoned.
+func (a *scanAgent) scan(ctx context.Context, s *shard) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		t := time.NewTicker(heartbeatEvery)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				if code, _ := a.post(ctx, "/v1/leases/"+s.Lease+"/heartbeat", nil, nil); code == http.StatusGone {
+					cancel()
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	res := shardResult{Agent: a.name}
+	audit := a.audit.job(s.Lease)
+	if p, err := s.plan(a.source); err != nil {
+		res.Error = err.Error()
+	} else if err := a.scope.check(p.targets, false, net.LookupHost); err != nil {
+		res.Refused = err.Error()
+		params := p.params("")
+		if err := audit.record(auditEntry{Event: auditRefused, Params: &params, Reason: res.Refused}); err != nil {
+			slog.Warn("audit log not written", "err", err)
+		}
+	} else if res.Hosts, err = a.probe(ctx, p, audit); err != nil {
+		// Another agent may be able to record the scan.
+		res.Error = err.Error()
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	// The result is worth a few attempts: the coordinator hands the shard
+	// out again if it never arrives.
+	for attempt := 1; ; attempt++ {
+		code, err := a.post(ctx, "/v1/leases/"+s.Lease+"/result", res, nil)
+		if err == nil || code == http.StatusGone || attempt == webhookAttempts {
+			if err != nil && code != http.StatusGone {
+				slog.Error("sending result failed", "lease", s.Lease, "err", err)
+			}
+			return
+		}
+		select {
+		case <-time.After(time.Duration(attempt) * time.Second):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// plan turns a shard into a scan of its hosts, each on its own ports.
+func (s *shard) plan(source *sourceDialer) (*scanPlan, error) {
+	if len(s.Targets) == 0 || s.Workers <= 0 || s.Workers > 10000 || s.Timeout <= 0 || s.HostTimeout < 0 || s.Delay < 0 || s.Retries < 0 || s.Retries > maxRetries || s.CalibratePort < 0 || s.CalibratePort > 65535 || s.CalibratePort != 0 && (s.MinTimeout <= 0 || s.MaxTimeout < s.MinTimeout) {
+		return nil, errors.New("invalid scan settings")
+	}
+	hosts := make([]string, 0, len(s.Targets))
+	hostPorts := make(map[string][]int, len(s.Targets))
+	var all []int
+	for _, t := range s.Targets {
+		ports, err := parsePorts(t.Ports, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", t.Host, err)
+		}
+		hosts = append(hosts, t.Host)
+		hostPorts[t.Host] = ports
+		all = append(all, ports...)
+	}
+	targets, err := parseTargets(strings.Join(hosts, ","))
+	if err != nil {
+		return nil, err
+	}
+	if err := targets.checkZones(); err != nil {
+		return nil, err
+	}
+	if source != nil {
+		if err := source.check(targets); err != nil {
+			return nil, err
+		}
+	}
+	var portTimeouts map[int]time.Duration
+	if s.PortTimeouts != "" {
+		if portTimeouts, err = parsePortTimeouts(s.PortTimeouts, nil); err != nil {
+			return nil, err
+		}
+	}
+	slices.Sort(all)
+	ports := slices.Compact(all)
+	p := &scanPlan{
+		targets:       targets,
+		numTargets:    targets.count(),
+		ports:         ports,
+		workers:       s.Workers,
+		timeout:       time.Duration(s.Timeout),
+		hostTimeout:   time.Duration(s.HostTimeout),
+		delay:         time.Duration(s.Delay),
+		retries:       s.Retries,
+		force:         s.Force,
+		portTimeouts:  portTimeouts,
+		calibratePort: s.CalibratePort,
+		minTimeout:    time.Duration(s.MinTimeout),
+		maxTimeout:    time.Duration(s.MaxTimeout),
+		source:        source,
+		hostPorts:     hostPorts,
+	}
+	if p.workers > p.probes() {
+		p.workers = p.probes()
+	}
+	return p, nil
+}
+
+// post sends body as JSON to the coordinator and decodes a JSON answer
+// into v. It returns the status code; answers other than 200 and 204 are
+// errors too.
+func (a *scanAgent) post(ctx context.Context, path string, body, v any) (int, error) {
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			return 0, err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.base+path, &buf)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	req.Header.Set("User-Agent", "pscanner/"+currentBuild().Version)
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if v != nil {
+			return resp.StatusCode, json.NewDecoder(resp.Body).Decode(v)
+		}
+		return resp.StatusCode, nil
+	case http.StatusNoContent:
+		return resp.StatusCode, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return resp.StatusCode, fmt.Errorf("coordinator answered %s: %s", resp.Status, bytes.TrimSpace(msg))
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShardPlan(t *testing.T) {
+	s := &shard{
+		Targets: []shardTarget{{"10.0.0.1", "22,80"}, {"10.0.0.2", "443-445"}},
+		Workers: 100,
+		Timeout: Duration(time.Second),
+	}
+	p, err := s.plan(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{22, 80, 443, 444, 445}; !reflect.DeepEqual(p.ports, want) {
+		t.Errorf("ports = %v, want the union %v", p.ports, want)
+	}
+	if got := p.portsFor("10.0.0.2"); !reflect.DeepEqual(got, []int{443, 444, 445}) {
+		t.Errorf("ports of 10.0.0.2 = %v", got)
+	}
+	// Five probes need no more than five workers.
+	if p.numTargets != 2 || p.probes() != 5 || p.workers != 5 || p.timeout != time.Second {
+		t.Errorf("plan = %d targets, %d probes, %d workers, timeout %s", p.numTargets, p.probes(), p.workers, p.timeout)
+	}
+
+	for _, bad := range []shard{
+		{Workers: 1, Timeout: Duration(time.Second)},
+		{Targets: s.Targets, Workers: 0, Timeout: Duration(time.Second)},
+		{Targets: s.Targets, Workers: 1},
+		{Targets: []shardTarget{{"10.0.0.1", "0"}}, Workers: 1, Timeout: Duration(time.Second)},
+		{Targets: []shardTarget{{"10.0.0.0/33", "22"}}, Workers: 1, Timeout: Duration(time.Second)},
+	} {
+		if _, err := bad.plan(nil); err == nil {
+			t.Errorf("plan of %+v succeeded, want error", bad)
+		}
+	}
+}
+
+func TestAgentURL(t *testing.T) {
+	for in, want := range map[string]string{
+		"hub:7070":                   "http://hub:7070",
+		"https://hub.example/":       "https://hub.example",
+		"http://10.0.0.1:7070/base/": "http://10.0.0.1:7070/base",
+	} {
+		if got := agentURL(in); got != want {
+			t.Errorf("agentURL(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// fakeCoordinator hands out the given shards in turn, then no more work,
+// and collects what the agent sends back.
+type fakeCoordinator struct {
+	mu      sync.Mutex
+	shards  []shard
+	results map[string]shardResult
+	done    chan struct{} // closed once every shard has a result
+}
+
+func (f *fakeCoordinator) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("Authorization") != "Bearer s3cret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case req.URL.Path == "/v1/lease":
+		if len(f.shards) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		json.NewEncoder(w).Encode(f.shards[0])
+		f.shards = f.shards[1:]
+	case strings.HasSuffix(req.URL.Path, "/result"):
+		var res shardResult
+		json.NewDecoder(req.Body).Decode(&res)
+		f.results[strings.Split(req.URL.Path, "/")[3]] = res
+		if len(f.shards) == 0 {
+			close(f.done)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestAgentLeaseLoop(t *testing.T) {
+	open := localPort(t)
+	closed := closedPort(t)
+	f := &fakeCoordinator{
+		shards: []shard{
+			{Lease: "l1", Targets: []shardTarget{{"127.0.0.1", strconv.Itoa(open) + "," + strconv.Itoa(closed)}}, Workers: 2, Timeout: Duration(time.Second)},
+			{Lease: "l2", Targets: []shardTarget{{"127.0.0.1", "22"}}, Workers: 0, Timeout: Duration(time.Second)},
+			{Lease: "l3", Targets: []shardTarget{{"10.9.9.9", "22"}}, Workers: 1, Timeout: Duration(time.Second)},
+		},
+		results: make(map[string]shardResult),
+		done:    make(chan struct{}),
+	}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	scope := &scanScope{path: "scope.yaml", allow: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}}
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	a := &scanAgent{base: srv.URL, name: "a1", token: "s3cret", client: http.DefaultClient, scope: scope,
+		audit: newAuditLog(auditPath, flag.NewFlagSet("agent", flag.ContinueOnError))}
+	errc := make(chan error, 1)
+	go func() { errc <- a.run(ctx) }()
+	select {
+	case <-f.done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the agent did not report every shard")
+	}
+	cancel()
+	if err := <-errc; err != nil {
+		t.Errorf("run = %v", err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r1 := f.results["l1"]
+	if r1.Agent != "a1" || r1.Error != "" || len(r1.Hosts) != 1 || len(r1.Hosts[0].Ports) != 1 || r1.Hosts[0].Ports[0].Port != open {
+		t.Errorf("result of l1 = %+v, want port %d open", r1, open)
+	}
+	// A shard with settings the agent cannot use goes back with an error.
+	if r2 := f.results["l2"]; r2.Error == "" || r2.Refused != "" || r2.Hosts != nil {
+		t.Errorf("result of l2 = %+v, want an error", r2)
+	}
+	// A shard outside the agent's scope is refused, not retried elsewhere.
+	if r3 := f.results["l3"]; r3.Refused == "" || r3.Error != "" || r3.Hosts != nil {
+		t.Errorf("result of l3 = %+v, want a refusal", r3)
+	}
+
+	// The audit log has the scan of l1 and the refusal of l3, under
+	// their lease IDs; l2 never became a scan.
+	var events []string
+	for _, e := range readAudit(t, auditPath) {
+		events = append(events, e.ID+" "+e.Event)
+	}
+	if want := []string{"l1 start", "l1 finish", "l3 refused"}; !reflect.DeepEqual(events, want) {
+		t.Errorf("audit records = %q, want %q", events, want)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+)
+
+// Audit log events.
+const (
+	auditDryRun  = "dry-run"
+	auditRefused = "refused"
+	auditStart   = "start"
+	auditFinish  = "finish"
+)
+
+// auditEntry is a line of the audit log. The records of one invocation
+// share its id; those of a scan run by "serve", "pipe" or "agent" share
+// the scan's.
+type auditEntry struct {
+	Time     time.Time         `json:"time"`
+	ID       string            `json:"id"`
+	Event    string            `json:"event"`
+	User     string            `json:"user"`
+	SudoUser string            `json:"sudo_user,omitempty"`
+	Machine  string            `json:"machine"`
+	Command  string            `json:"command"`
+	Args     []string          `json:"args,omitempty"`
+	Params   *scanParams       `json:"parameters,omitempty"`
+	Reason   string            `json:"reason,omitempty"`
+	Schedule string            `json:"schedule,omitempty"` // the schedule that started the scan
+	Tags     map[string]string `json:"tags,omitempty"`
+	// Set on finish.
+	OpenPorts    *int   `json:"open_ports,omitempty"`
+	Canceled     bool   `json:"canceled,omitempty"`
+	Output       string `json:"output,omitempty"` // file name, stdout or syslog
+	OutputSHA256 string `json:"output_sha256,omitempty"`
+}
+
+// auditLog appends the records of one invocation to the audit_log file.
+// A nil *auditLog records nothing.
+type auditLog struct {
+	path string
+	base auditEntry
+}
+
+// newAuditLog prepares the records of the command fs parsed. Only the
+// flags given on the command line are recorded, with secrets redacted.
+func newAuditLog(path string, fs *flag.FlagSet) *auditLog {
+	if path == "" {
+		return nil
+	}
+	e := auditEntry{ID: newJobID(), Command: fs.Name(), SudoUser: os.Getenv("SUDO_USER")}
+	if u, err := user.Current(); err == nil {
+		e.User = u.Username
+	}
+	e.Machine, _ = os.Hostname()
+	fs.Visit(func(f *flag.Flag) {
+		e.Args = append(e.Args, "--"+f.Name+"="+auditValue(f.Name, f.Value.String()))
+	})
+	return &auditLog{path: path, base: e}
+}
+
+// auditSecretFlags hold credentials as a whole.
+var auditSecretFlags = map[string]bool{
+	"shodan-key":      true,
+	"webhook":         true,
+	"slack-webhook":   true,
+	"discord-webhook": true,
+	"teams-webhook":   true,
+}
+
+// auditValue redacts the value of a flag for the audit log: secrets as a
+// whole, and passwords in URLs.
+func auditValue(name, value string) string {
+	if auditSecretFlags[name] && value != "" {
+		return "REDACTED"
+	}
+	if !strings.Contains(value, "://") {
+		return value
+	}
+	var parts []string
+	for _, v := range strings.Split(value, ",") {
+		if u, err := url.Parse(v); err == nil && u.User != nil {
+			v = u.Redacted()
+		}
+		parts = append(parts, v)
+	}
+	return strings.Join(parts, ",")
+}
+
+// countOpen returns the number of open ports found, for finish records.
+func countOpen(hosts []HostResult) int {
+	n := 0
+	for _, h := range hosts {
+		n += len(h.Ports)
+	}
+	return n
+}
+
+// job returns the log of one scan run by a long-running command, whose
+// records carry id instead of the command's own.
+func (a *auditLog) job(id string) *auditLog {
+	if a == nil {
+		return nil
+	}
+	j := *a
+	j.base.ID = id
+	return &j
+}
+
+// record appends e, filled in from the invocation, to the log. Each
+// record is a single write to a file opened for appending, so records of
+// concurrent invocations do not interleave.
+func (a *auditLog) record(e auditEntry) error {
+	if a == nil {
+		return nil
+	}
+	base := a.base
+	base.Time = time.Now().UTC()
+	base.Event = e.Event
+	base.Params = e.Params
+	base.Reason = e.Reason
+	base.Schedule = e.Schedule
+	base.Tags = e.Tags
+	base.OpenPorts = e.OpenPorts
+	base.Canceled = e.Canceled
+	base.Output = e.Output
+	base.OutputSHA256 = e.OutputSHA256
+	line, err := json.Marshal(base)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("audit log: %v", err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("audit log: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("audit log: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cadence decides when a scheduled scan runs next.
+type cadence interface {
+	// next returns the first run time strictly after t.
+	next(t time.Time) time.Time
+}
+
+// interval runs a scan at a fixed period, e.g. "every 6h".
+type interval time.Duration
+
+func (iv interval) next(t time.Time) time.Time { return t.Add(time.Duration(iv)) }
+
+// minInterval keeps a typo such as "every 6s" from hammering the targets.
+const minInterval = time.Minute
+
+// parseCadence accepts "every <duration>", the shortcuts @hourly, @daily
+// and @weekly, or a five-field cron expression.
+func parseCadence(s string) (cadence, error) {
+	s = strings.TrimSpace(s)
+	if rest, ok := strings.CutPrefix(s, "every "); ok {
+		d, err := time.ParseDuration(strings.TrimSpace(rest))
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval %q: %v", rest, err)
+		}
+		if d < minInterval {
+			return nil, fmt.Errorf("interval %s is shorter than %s", d, minInterval)
+		}
+		return interval(d), nil
+	}
+	switch s {
+	case "@hourly":
+		s = "0 * * * *"
+	case "@daily":
+		s = "0 0 * * *"
+	case "@weekly":
+		s = "0 0 * * 0"
+	}
+	return parseCron(s)
+}
+
+// cronSchedule is a parsed five-field cron expression: minute, hour, day
+// of month, month and day of week, each a set of allowed values.
+type cronSchedule struct {
+	minute, hour, dom, month, dow [61]bool
+	// domAny and dowAny record a "*" day field. As in cron, when both day
+	// fields are restricted a day matches if either of them does.
+	domAny, dowAny bool
+}
+
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7}, // 0 and 7 are both Sunday
+}
+
+// parseCron parses five space-separated fields, each a "*", a value, a
+// range "a-b" or a comma-separated list of those, optionally with a step
+// "/n".
+func parseCron(s string) (*cronSchedule, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q (want \"every <duration>\" or five cron fields)", s)
+	}
+	c := &cronSchedule{}
+	sets := []*[61]bool{&c.minute, &c.hour, &c.dom, &c.month, &c.dow}
+	for i, f := range fields {
+		if err := parseCronField(f, cronFields[i].min, cronFields[i].max, sets[i]); err != nil {
+			return nil, fmt.Errorf("cron %s field %q: %v", cronFields[i].name, f, err)
+		}
+	}
+	if c.dow[7] {
+		c.dow[0] = true
+	}
+	c.domAny = strings.HasPrefix(fields[2], "*")
+	c.dowAny = strings.HasPrefix(fields[4], "*")
+	return c, nil
+}
+
+func parseCronField(f string, min, max int, set *[61]bool) error {
+	for _, part := range strings.Split(f, ",") {
+		step := 1
+		if r, s, ok := strings.Cut(part, "/"); ok {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid step %q", s)
+			}
+			part, step = r, n
+		}
+		lo, hi := min, max
+		if part != "*" {
+			a, b, isRange := strings.Cut(part, "-")
+			var err error
+			if lo, err = strconv.Atoi(a); err != nil {
+				return fmt.Errorf("invalid value %q", a)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(b); err != nil {
+					return fmt.Errorf("invalid value %q", b)
+				}
+			}
+			if lo < min || hi > max || lo > hi {
+				return fmt.Errorf("%s out of range %d-%d", part, min, max)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return nil
+}
+
+func (c *cronSchedule) dayMatches(t time.Time) bool {
+	dom, dow := c.dom[t.Day()], c.dow[int(t.Weekday())]
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// next finds the next matching minute after t, in t's location. It gives
+// up after five years, which only happens for impossible dates such as
+// February 30th, and returns the zero time then.
+func (c *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case !c.month[int(t.Month())]:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case !c.hour[t.Hour()]:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case !c.minute[t.Minute()]:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCadenceErrors(t *testing.T) {
+	for _, s := range []string{
+		"", "every", "every 10s", "every banana", "* * * *", "60 * * * *", "* 24 * * *",
+		"* * 0 * *", "* * * 13 *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "a * * * *",
+	} {
+		if _, err := parseCadence(s); err == nil {
+			t.Errorf("parseCadence(%q) succeeded", s)
+		}
+	}
+}
+
+func TestCadenceNext(t *testing.T) {
+	at := func(s string) time.Time {
+		v, err := time.ParseInLocation("2006-01-02 15:04", s, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	tests := []struct {
+		when, from, want string
+	}{
+		{"every 6h", "2026-03-01 10:30", "2026-03-01 16:30"},
+		{"@hourly", "2026-03-01 10:30", "2026-03-01 11:00"},
+		{"@daily", "2026-03-01 10:30", "2026-03-02 00:00"},
+		{"@weekly", "2026-03-01 10:30", "2026-03-08 00:00"}, // 1 March 2026 is a Sunday
+		{"*/15 * * * *", "2026-03-01 10:30", "2026-03-01 10:45"},
+		{"0 */6 * * *", "2026-03-01 10:30", "2026-03-01 12:00"},
+		{"30 2 * * 1-5", "2026-03-06 03:00", "2026-03-09 02:30"}, // Friday after the run -> Monday
+		{"0 0 1,15 * *", "2026-03-02 00:00", "2026-03-15 00:00"},
+		{"0 9 * 12 *", "2026-03-01 00:00", "2026-12-01 09:00"},
+		{"0 0 * * 7", "2026-03-02 00:00", "2026-03-08 00:00"}, // 7 is Sunday too
+		// Both day fields restricted: either may match.
+		{"0 0 13 * 5", "2026-03-01 00:00", "2026-03-06 00:00"},
+		{"0 0 29 2 *", "2026-03-01 00:00", "2028-02-29 00:00"},
+	}
+	for _, tt := range tests {
+		c, err := parseCadence(tt.when)
+		if err != nil {
+			t.Errorf("parseCadence(%q): %v", tt.when, err)
+			continue
+		}
+		if got := c.next(at(tt.from)); !got.Equal(at(tt.want)) {
+			t.Errorf("%q after %s = %s, want %s", tt.when, tt.from, got.Format("2006-01-02 15:04"), tt.want)
+		}
+	}
+
+	c, _ := parseCadence("0 0 30 2 *")
+	if got := c.next(at("2026-01-01 00:00")); !got.IsZero() {
+		t.Errorf("February 30th matched %s", got)
+	}
+}
+
+func TestFirstRun(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	every6h := interval(6 * time.Hour)
+	recent := &Report{StartedAt: now.Add(-time.Hour)}
+	old := &Report{StartedAt: now.Add(-7 * time.Hour)}
+
+	if got := firstRun(every6h, nil, now); !got.Equal(now) {
+		t.Errorf("first ever run at %s, want now", got)
+	}
+	if got := firstRun(every6h, recent, now); !got.Equal(now.Add(5 * time.Hour)) {
+		t.Errorf("after a recent run: %s, want in 5h", got)
+	}
+	if got := firstRun(every6h, old, now); !got.Equal(now) {
+		t.Errorf("after an overdue run: %s, want now", got)
+	}
+	hourly, _ := parseCadence("@hourly")
+	if got := firstRun(hourly, nil, now); !got.Equal(now.Add(time.Hour)) {
+		t.Errorf("cron schedule: %s, want the next match", got)
+	}
+}
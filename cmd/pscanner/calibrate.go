@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// calibrationProbes is how many connects to --calibrate-port time a
+	// host.
+	calibrationProbes = 3
+	// calibrationFactor is how many round trips a host's timeout allows,
+	// for the answers that come slower than the calibration's.
+	calibrationFactor = 4
+)
+
+// Calibration is how --calibrate-port timed a host.
+type Calibration struct {
+	// RTT is the slowest of the calibration connects that were answered,
+	// whether accepted or refused; zero if none was.
+	RTT     Duration `json:"rtt,omitempty"`
+	Timeout Duration `json:"timeout"`
+}
+
+func (c *Calibration) String() string {
+	if c.RTT == 0 {
+		return fmt.Sprintf("%s (the calibration port did not answer)", time.Duration(c.Timeout))
+	}
+	return fmt.Sprintf("%s (round trip %s)", time.Duration(c.Timeout), time.Duration(c.RTT).Round(10*time.Microsecond))
+}
+
+// calibratedTimeout is the timeout for a host whose slowest calibration
+// connect took rtt, within the plan's --min-timeout and --max-timeout.
+func (p *scanPlan) calibratedTimeout(rtt time.Duration) time.Duration {
+	return min(max(calibrationFactor*rtt, p.minTimeout), p.maxTimeout)
+}
+
+// calibrator times a few connects to --calibrate-port of each host of a
+// scan, once, before its first probe, to pick the host's timeout.
+type calibrator struct {
+	plan *scanPlan
+	dns  *dnsCache
+	mu   sync.Mutex
+	// done holds, for each host and family, a channel closed when it is
+	// calibrated, and results how.
+	done    map[string]chan struct{}
+	results map[string]*Calibration
+}
+
+func newCalibrator(p *scanPlan, dns *dnsCache) *calibrator {
+	if p.calibratePort == 0 {
+		return nil
+	}
+	return &calibrator{plan: p, dns: dns, done: make(map[string]chan struct{}), results: make(map[string]*Calibration)}
+}
+
+// before calibrates the host of j unless another worker has, waits for
+// the calibration, and sets the timeout of j to the host's. A nil
+// calibrator does nothing.
+func (c *calibrator) before(ctx context.Context, j *job) {
+	if c == nil {
+		return
+	}
+	k := j.key()
+	c.mu.Lock()
+	done, started := c.done[k]
+	if !started {
+		done = make(chan struct{})
+		c.done[k] = done
+	}
+	c.mu.Unlock()
+	if !started {
+		cal := c.calibrate(ctx, *j)
+		c.mu.Lock()
+		c.results[k] = cal
+		c.mu.Unlock()
+		close(done)
+	}
+	select {
+	case <-done:
+		j.timeout = time.Duration(c.result(k).Timeout)
+	case <-ctx.Done():
+	}
+}
+
+// calibrate times calibrationProbes connects to --calibrate-port of the
+// host of j, one after the other, each bounded by --max-timeout.
+func (c *calibrator) calibrate(ctx context.Context, j job) *Calibration {
+	p := c.plan
+	var rtt time.Duration
+	for range calibrationProbes {
+		start := time.Now()
+		conn, err := p.probe(ctx, c.dns, job{host: j.host, port: p.calibratePort, family: j.family, timeout: p.maxTimeout})
+		took := time.Since(start)
+		if err == nil {
+			conn.Close()
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if f := classifyDial(err); f == dialOK || f == dialRefused {
+			rtt = max(rtt, took)
+		}
+	}
+	if rtt == 0 {
+		if ctx.Err() == nil {
+			slog.Warn("calibration port did not answer; using --timeout", "host", j.host, "family", j.family, "port", p.calibratePort)
+		}
+		return &Calibration{Timeout: Duration(p.timeout)}
+	}
+	return &Calibration{RTT: Duration(rtt), Timeout: Duration(p.calibratedTimeout(rtt))}
+}
+
+// result returns how the host of key was calibrated, or nil.
+func (c *calibrator) result(key string) *Calibration {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.results[key]
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunCalibration(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	port := ln.Addr().(*net.TCPAddr).Port
+	targets, _ := parseTargets("127.0.0.1")
+	p := &scanPlan{targets: targets, numTargets: 1, ports: []int{port}, workers: 1, timeout: time.Second,
+		calibratePort: port, minTimeout: 200 * time.Millisecond, maxTimeout: time.Second}
+	hosts := p.run(context.Background(), scanHooks{})
+	c := hosts[0].Calibration
+	if c == nil || c.RTT <= 0 || time.Duration(c.Timeout) != 200*time.Millisecond {
+		t.Fatalf("calibration = %+v, want a round trip and --min-timeout", c)
+	}
+	if len(hosts[0].Ports) != 1 {
+		t.Errorf("ports = %+v", hosts[0].Ports)
+	}
+
+	var b strings.Builder
+	if err := writeText(&b, newReport(p, "", time.Now(), hosts, false)); err != nil {
+		t.Fatal(err)
+	}
+	if out := b.String(); !strings.Contains(out, "Calibration: port") || !strings.Contains(out, "Timeout: 200ms (round trip") {
+		t.Errorf("text report:\n%s", out)
+	}
+}
+
+func TestRunCalibrationNoAnswer(t *testing.T) {
+	targets, _ := parseTargets("192.0.2.1")
+	d := &deadlineDialer{left: make(map[string]time.Duration)}
+	p := &scanPlan{targets: targets, numTargets: 1, ports: []int{22}, workers: 1, timeout: time.Second, proxy: d,
+		calibratePort: 80, minTimeout: 100 * time.Millisecond, maxTimeout: 2 * time.Second}
+	hosts := p.run(context.Background(), scanHooks{})
+	if c := hosts[0].Calibration; c == nil || c.RTT != 0 || time.Duration(c.Timeout) != time.Second {
+		t.Errorf("calibration = %+v, want --timeout", c)
+	}
+	if left := d.left["192.0.2.1:80"]; left > 2*time.Second || left <= time.Second {
+		t.Errorf("calibration connect had %s, want --max-timeout", left)
+	}
+	if left := d.left["192.0.2.1:22"]; left > time.Second || left < time.Second/2 {
+		t.Errorf("port 22 had %s, want --timeout", left)
+	}
+}
+
+func TestCalibratedTimeout(t *testing.T) {
+	p := &scanPlan{minTimeout: 100 * time.Millisecond, maxTimeout: time.Second}
+	for _, tt := range []struct{ rtt, want time.Duration }{
+		{time.Millisecond, 100 * time.Millisecond},
+		{50 * time.Millisecond, 200 * time.Millisecond},
+		{time.Second, time.Second},
+	} {
+		if got := p.calibratedTimeout(tt.rtt); got != tt.want {
+			t.Errorf("calibratedTimeout(%s) = %s, want %s", tt.rtt, got, tt.want)
+		}
+	}
+}
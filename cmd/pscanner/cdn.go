@@ -0,0 +1,135 @@
+package main
+
+import (
+	_ "embed"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+)
+
+//go:embed data/cdn-ranges
+var cdnRangesData string
+
+// cdnRange is a block of a CDN's edge.
+type cdnRange struct {
+	provider string
+	prefix   netip.Prefix
+}
+
+var (
+	cdnRangesOnce sync.Once
+	cdnRanges     []cdnRange
+)
+
+// parseCDNRanges reads the "<provider> <prefix>" lines of the embedded
+// table. Lines that do not parse are skipped.
+func parseCDNRanges(data string) []cdnRange {
+	var ranges []cdnRange
+	for _, line := range strings.Split(data, "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		p, err := netip.ParsePrefix(fields[1])
+		if err != nil {
+			continue
+		}
+		ranges = append(ranges, cdnRange{provider: fields[0], prefix: p.Masked()})
+	}
+	return ranges
+}
+
+func loadCDNRanges() []cdnRange {
+	cdnRangesOnce.Do(func() { cdnRanges = parseCDNRanges(cdnRangesData) })
+	return cdnRanges
+}
+
+// cdnProvider names the CDN whose edge a is in, or returns "".
+func cdnProvider(a netip.Addr) string {
+	a = a.Unmap()
+	for _, r := range loadCDNRanges() {
+		if r.prefix.Contains(a) {
+			return r.provider
+		}
+	}
+	return ""
+}
+
+// cdnOverlaps reports whether any address of p is in a CDN's edge.
+func cdnOverlaps(p netip.Prefix) bool {
+	for _, r := range loadCDNRanges() {
+		if r.prefix.Overlaps(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// cdnEdgePorts are the ports a CDN's edge serves for every site behind it;
+// --skip-cdn probes only these of its targets.
+var cdnEdgePorts = []int{80, 443}
+
+// frontCDN finds the hostnames among the targets that resolve into a CDN's
+// edge, so their results are marked as the edge's rather than the
+// origin's, as those of addresses in it are. With --skip-cdn it counts
+// the probes saved on both.
+func (p *scanPlan) frontCDN(resolve func(string) ([]string, error)) {
+	for _, t := range p.targets {
+		if t.name == "" {
+			continue
+		}
+		ips, err := resolve(t.name)
+		if err != nil {
+			continue
+		}
+		for _, ip := range ips {
+			a, err := netip.ParseAddr(ip)
+			if err != nil {
+				continue
+			}
+			if provider := cdnProvider(a); provider != "" {
+				if p.cdnNames == nil {
+					p.cdnNames = make(map[string]string)
+				}
+				p.cdnNames[t.name] = provider
+				break
+			}
+		}
+	}
+	if !p.skipCDN {
+		return
+	}
+	p.cdnSkipped = 0
+	for _, t := range p.targets {
+		if t.name == "" && !cdnOverlaps(t.prefix) {
+			continue
+		}
+		targetList{t}.each(func(host string) bool {
+			if p.cdnFor(host) != "" {
+				p.cdnSkipped += (len(p.allPortsFor(host)) - len(p.portsFor(host))) * len(p.families(host))
+			}
+			return true
+		})
+	}
+}
+
+// cdnFor names the CDN whose edge answers for host, or returns "".
+func (p *scanPlan) cdnFor(host string) string {
+	if a, err := netip.ParseAddr(host); err == nil {
+		return cdnProvider(a)
+	}
+	return p.cdnNames[host]
+}
+
+// edgePorts keeps those of ports a CDN's edge serves.
+func edgePorts(ports []int) []int {
+	var kept []int
+	for _, port := range ports {
+		if slices.Contains(cdnEdgePorts, port) {
+			kept = append(kept, port)
+		}
+	}
+	return kept
+}
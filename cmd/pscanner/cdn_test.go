@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCDNProvider(t *testing.T) {
+	for _, tt := range []struct{ addr, want string }{
+		{"104.16.1.1", "Cloudflare"},
+		{"2606:4700::6810:84e5", "Cloudflare"},
+		{"::ffff:104.16.1.1", "Cloudflare"},
+		{"151.101.65.140", "Fastly"},
+		{"23.40.1.1", "Akamai"},
+		{"10.0.0.1", ""},
+	} {
+		if got := cdnProvider(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("cdnProvider(%s) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestSkipCDN(t *testing.T) {
+	targets, err := parseTargets("104.16.0.0/30,10.0.0.1,shop.example,intranet.example,gone.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &scanPlan{targets: targets, numTargets: targets.count(), ports: []int{22, 80, 443, 8080}, skipCDN: true}
+	p.frontCDN(func(name string) ([]string, error) {
+		switch name {
+		case "shop.example":
+			return []string{"10.9.9.9", "151.101.65.140"}, nil
+		case "intranet.example":
+			return []string{"10.1.1.1"}, nil
+		}
+		return nil, errors.New("no such host")
+	})
+	if want := map[string]string{"shop.example": "Fastly"}; !reflect.DeepEqual(p.cdnNames, want) {
+		t.Errorf("cdnNames = %v, want %v", p.cdnNames, want)
+	}
+	// Four addresses of Cloudflare and one name on Fastly lose two ports each.
+	if got, want := p.probes(), 8*4-5*2; got != want {
+		t.Errorf("probes() = %d, want %d", got, want)
+	}
+	for _, tt := range []struct {
+		host string
+		want []int
+	}{
+		{"104.16.0.2", []int{80, 443}},
+		{"shop.example", []int{80, 443}},
+		{"10.0.0.1", []int{22, 80, 443, 8080}},
+		{"intranet.example", []int{22, 80, 443, 8080}},
+	} {
+		if got := p.portsFor(tt.host); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("portsFor(%s) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestCDNReport(t *testing.T) {
+	r := &Report{
+		Parameters: scanParams{TargetCount: 1},
+		Hosts:      []HostResult{{Host: "104.16.0.2", CDN: "Cloudflare", Ports: []PortResult{{Port: 443, Protocol: "tcp", State: "open"}}}},
+	}
+	var text bytes.Buffer
+	if err := writeText(&text, r); err != nil {
+		t.Fatal(err)
+	}
+	if want := "CDN: Cloudflare edge; its open ports are the CDN's, not the origin's\n"; !strings.Contains(text.String(), want) {
+		t.Errorf("text report lacks %q:\n%s", want, text.String())
+	}
+}
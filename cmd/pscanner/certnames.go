@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// certPorts are the TCP ports whose certificates --cert-names and --follow
+// read for the names of other hosts.
+var certPorts = map[int]bool{443: true, 465: true, 636: true, 853: true, 993: true, 995: true, 2376: true, 5986: true, 6443: true, 8443: true, 9443: true}
+
+// DiscoveredHostname is a name found in the certificates of the scanned
+// hosts that the scan was not given: often another asset of the same
+// organization.
+type DiscoveredHostname struct {
+	Name string `json:"name"`
+	// Hosts are those whose certificates name it.
+	Hosts []string `json:"hosts"`
+	// Scanned is set when --follow scanned it too.
+	Scanned bool `json:"scanned,omitempty"`
+}
+
+// readCertNames reads the names in the certificates of the open TLS ports
+// of hosts, for --cert-names and --follow.
+func (p *scanPlan) readCertNames(ctx context.Context, dns *dnsCache, hosts []HostResult) {
+	if !p.certNames && p.follow == 0 {
+		return
+	}
+	sem := make(chan struct{}, quicParallel)
+	var wg sync.WaitGroup
+	for i := range hosts {
+		h := &hosts[i]
+		sem <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			h.CertNames = p.hostCertNames(ctx, dns, *h)
+		})
+	}
+	wg.Wait()
+}
+
+// hostCertNames returns the names and addresses in the certificates of the
+// open TLS ports of h, lowercased, each once.
+func (p *scanPlan) hostCertNames(ctx context.Context, dns *dnsCache, h HostResult) []string {
+	var names []string
+	add := func(n string) {
+		if n != "" && !slices.Contains(names, n) {
+			names = append(names, n)
+		}
+	}
+	for _, pr := range h.Ports {
+		if pr.Protocol != "tcp" || pr.State != "open" || !certPorts[pr.Port] || ctx.Err() != nil {
+			continue
+		}
+		conn, err := p.probe(ctx, dns, job{host: h.Host, port: pr.Port, family: h.Family})
+		if err != nil {
+			continue
+		}
+		conn.SetDeadline(time.Now().Add(p.timeout))
+		tc, err := clientTLS(conn, h.Host)
+		if err != nil {
+			conn.Close()
+			continue
+		}
+		certs := tc.ConnectionState().PeerCertificates
+		tc.Close()
+		if len(certs) == 0 {
+			continue
+		}
+		for _, n := range certs[0].DNSNames {
+			add(strings.ToLower(strings.TrimSuffix(n, ".")))
+		}
+		for _, ip := range certs[0].IPAddresses {
+			if a, ok := netip.AddrFromSlice(ip); ok {
+				add(a.Unmap().String())
+			}
+		}
+	}
+	return names
+}
+
+// discoveredHostnames lists, by name, the hostnames in the certificates
+// of hosts other than the targets' own. Names that --follow scanned are
+// among the hosts too, learned by certificate.
+func discoveredHostnames(hosts []HostResult) []DiscoveredHostname {
+	targets := make(map[string]bool)
+	learned := make(map[string]bool)
+	for _, h := range hosts {
+		if h.LearnedBy == "" {
+			targets[h.Host] = true
+		} else {
+			learned[h.Host] = true
+		}
+	}
+	found := make(map[string]*DiscoveredHostname)
+	for _, h := range hosts {
+		for _, n := range h.CertNames {
+			if _, err := netip.ParseAddr(n); err == nil || targets[n] {
+				continue
+			}
+			d := found[n]
+			if d == nil {
+				d = &DiscoveredHostname{Name: n, Scanned: learned[n]}
+				found[n] = d
+			}
+			if !slices.Contains(d.Hosts, h.Host) {
+				d.Hosts = append(d.Hosts, h.Host)
+			}
+		}
+	}
+	var list []DiscoveredHostname
+	for _, d := range found {
+		list = append(list, *d)
+	}
+	slices.SortFunc(list, func(a, b DiscoveredHostname) int { return strings.Compare(a.Name, b.Name) })
+	return list
+}
+
+func writeDiscoveredHostnames(w io.Writer, list []DiscoveredHostname) {
+	if len(list) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "\nDiscovered hostnames:")
+	for _, d := range list {
+		scanned := ""
+		if d.Scanned {
+			scanned = ", scanned"
+		}
+		fmt.Fprintf(w, "  %s (certificate of %s%s)\n", d.Name, strings.Join(d.Hosts, ", "), scanned)
+	}
+}
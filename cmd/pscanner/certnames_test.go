@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"net"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestReadCertNames(t *testing.T) {
+	cert := sanCertificate(t, []string{"App.Internal.", "*.corp.internal", "app.internal"}, net.ParseIP("10.0.0.9"))
+	port := serveTCP(t, func(conn net.Conn) {
+		tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{cert}}).Handshake()
+	})
+	certPorts[port] = true
+	t.Cleanup(func() { delete(certPorts, port) })
+	hosts := []HostResult{
+		{Host: "127.0.0.1", Ports: []PortResult{{Port: port, Protocol: "tcp", State: "open"}}},
+		{Host: "127.0.0.2"},
+	}
+	p := &scanPlan{timeout: time.Second, certNames: true}
+	p.readCertNames(context.Background(), newDNSCache(""), hosts)
+	if want := []string{"app.internal", "*.corp.internal", "10.0.0.9"}; !slices.Equal(hosts[0].CertNames, want) {
+		t.Errorf("names = %q, want %q", hosts[0].CertNames, want)
+	}
+	if hosts[1].CertNames != nil {
+		t.Errorf("host without ports: names = %q", hosts[1].CertNames)
+	}
+}
+
+func TestDiscoveredHostnames(t *testing.T) {
+	hosts := []HostResult{
+		{Host: "www.example.com", CertNames: []string{"www.example.com", "mail.example.com", "10.0.0.9"}},
+		{Host: "10.0.0.2", CertNames: []string{"*.example.com", "mail.example.com", "vpn.example.com"}},
+		{Host: "vpn.example.com", LearnedFrom: "10.0.0.2", LearnedBy: "certificate"},
+	}
+	want := []DiscoveredHostname{
+		{Name: "*.example.com", Hosts: []string{"10.0.0.2"}},
+		{Name: "mail.example.com", Hosts: []string{"www.example.com", "10.0.0.2"}},
+		{Name: "vpn.example.com", Hosts: []string{"10.0.0.2"}, Scanned: true},
+	}
+	got := discoveredHostnames(hosts)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("discoveredHostnames = %+v, want %+v", got, want)
+	}
+	var b bytes.Buffer
+	writeDiscoveredHostnames(&b, got)
+	const text = `
+Discovered hostnames:
+  *.example.com (certificate of 10.0.0.2)
+  mail.example.com (certificate of www.example.com, 10.0.0.2)
+  vpn.example.com (certificate of 10.0.0.2, scanned)
+`
+	if b.String() != text {
+		t.Errorf("text = %q, want %q", b.String(), text)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// ChatNotifier is the incoming webhook of a chat channel, with the same
+// conditions as a Webhook.
+type ChatNotifier struct {
+	URL        string   `json:"url"`
+	On         []string `json:"on,omitempty"`
+	AlertPorts string   `json:"alert_ports,omitempty"`
+}
+
+// chatServices maps each supported chat service to the request body its
+// incoming webhooks expect for a message.
+var chatServices = map[string]func(title string, lines []string) any{
+	"slack": func(title string, lines []string) any {
+		return map[string]string{"text": "*" + title + "*\n" + strings.Join(lines, "\n")}
+	},
+	"discord": func(title string, lines []string) any {
+		// Discord rejects messages over 2000 characters.
+		return map[string]string{"content": truncate("**"+title+"**\n"+strings.Join(lines, "\n"), 2000)}
+	},
+	"teams": func(title string, lines []string) any {
+		// An Adaptive Card, as accepted by Teams workflow webhooks.
+		body := []map[string]any{{"type": "TextBlock", "text": title, "weight": "Bolder", "wrap": true}}
+		for _, l := range lines {
+			body = append(body, map[string]any{"type": "TextBlock", "text": l, "wrap": true, "spacing": "None"})
+		}
+		return map[string]any{
+			"type": "message",
+			"attachments": []map[string]any{{
+				"contentType": "application/vnd.microsoft.card.adaptive",
+				"content": map[string]any{
+					"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+					"type":    "AdaptiveCard",
+					"version": "1.4",
+					"body":    body,
+				},
+			}},
+		}
+	},
+}
+
+// chatServiceNames returns the supported chat services, sorted.
+func chatServiceNames() []string {
+	return slices.Sorted(maps.Keys(chatServices))
+}
+
+// newChatNotifier returns a webhook that posts a readable summary of each
+// run to the named chat service.
+func newChatNotifier(service string, c ChatNotifier, groups map[string]string) (*webhook, error) {
+	message, ok := chatServices[service]
+	if !ok {
+		return nil, fmt.Errorf("unknown notifier %q (want %s)", service, strings.Join(chatServiceNames(), ", "))
+	}
+	w, err := newWebhook(Webhook{URL: c.URL, On: c.On, AlertPorts: c.AlertPorts}, groups)
+	if err != nil {
+		return nil, fmt.Errorf("%s notifier: %v", service, err)
+	}
+	w.name = service + " notifier"
+	w.encode = func(p webhookPayload) ([]byte, error) {
+		title, lines := chatMessage(p)
+		return json.Marshal(message(title, lines))
+	}
+	return w, nil
+}
+
+// chatListLimit caps each port list in a chat message; the rest is counted.
+const chatListLimit = 20
+
+// chatMessage summarizes a delivery as a title and a few lines of text.
+func chatMessage(p webhookPayload) (title string, lines []string) {
+	r := p.Report
+	open, hosts := 0, 0
+	for _, h := range r.Hosts {
+		open += len(h.Ports)
+		if len(h.Ports) > 0 {
+			hosts++
+		}
+	}
+	target := strings.Join(r.Parameters.Targets, ", ")
+	if len(r.Parameters.Targets) > 3 {
+		target = fmt.Sprintf("%d targets", len(r.Parameters.Targets))
+	}
+	title = "pscanner: scan of " + target
+	if p.Schedule != "" {
+		title += " (" + p.Schedule + ")"
+	}
+	if r.Canceled {
+		title += " stopped early"
+	} else {
+		title += " finished"
+	}
+	lines = append(lines, fmt.Sprintf("%d open ports on %d of %d hosts, %d ports each", open, hosts, r.Parameters.TargetCount, r.Parameters.PortCount))
+	if len(r.Tags) > 0 {
+		lines = append(lines, "Tags: "+formatTags(r.Tags))
+	}
+	if p.Diff != nil {
+		if len(p.Diff.Opened) == 0 && len(p.Diff.Closed) == 0 {
+			lines = append(lines, "No changes since the previous run")
+		}
+		lines = appendPorts(lines, "Newly open", p.Diff.Opened)
+		lines = appendPorts(lines, "Closed", p.Diff.Closed)
+	}
+	lines = appendPorts(lines, "Alert ports open", p.Alerts)
+	return title, lines
+}
+
+func appendPorts(lines []string, heading string, ports []portChange) []string {
+	if len(ports) == 0 {
+		return lines
+	}
+	lines = append(lines, heading+":")
+	for i, c := range ports {
+		if i == chatListLimit {
+			return append(lines, fmt.Sprintf("  … and %d more", len(ports)-i))
+		}
+		lines = append(lines, "  "+portText(c))
+	}
+	return lines
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestChatMessage(t *testing.T) {
+	r := testReport("1-1024", hostWith("10.0.0.5", 22, 3389), hostWith("10.0.0.6"))
+	r.Parameters.Targets = []string{"10.0.0.5", "10.0.0.6"}
+	r.Parameters.TargetCount, r.Parameters.PortCount = 2, 1024
+	title, lines := chatMessage(webhookPayload{
+		Schedule: "office",
+		Report:   r,
+		Diff:     &reportDiff{Opened: changes("10.0.0.5", 3389), Closed: changes("10.0.0.6", 80)},
+		Alerts:   changes("10.0.0.5", 3389),
+	})
+	if want := "pscanner: scan of 10.0.0.5, 10.0.0.6 (office) finished"; title != want {
+		t.Errorf("title = %q, want %q", title, want)
+	}
+	want := []string{
+		"2 open ports on 1 of 2 hosts, 1024 ports each",
+		"Newly open:",
+		"  10.0.0.5:3389/tcp (ms-wbt-server)",
+		"Closed:",
+		"  10.0.0.6:80/tcp (http)",
+		"Alert ports open:",
+		"  10.0.0.5:3389/tcp (ms-wbt-server)",
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("lines =\n%s\nwant\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+
+	many := make([]portChange, chatListLimit+5)
+	for i := range many {
+		many[i] = portChange{Host: "h", Port: 10000 + i, Protocol: "tcp"}
+	}
+	_, lines = chatMessage(webhookPayload{Report: r, Diff: &reportDiff{Opened: many}})
+	if last := lines[len(lines)-1]; last != "  … and 5 more" {
+		t.Errorf("long list ends with %q", last)
+	}
+}
+
+func TestChatNotifierFormats(t *testing.T) {
+	bodies := make(chan map[string]any, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		var v map[string]any
+		if err := json.Unmarshal(b, &v); err != nil {
+			t.Error(err)
+		}
+		bodies <- v
+	}))
+	defer srv.Close()
+
+	run := scanRun{Report: testReport("22", hostWith("a", 22))}
+	for service, key := range map[string]string{"slack": "text", "discord": "content", "teams": "attachments"} {
+		w, err := newChatNotifier(service, ChatNotifier{URL: srv.URL}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.notify(run)
+		if v := <-bodies; v[key] == nil {
+			t.Errorf("%s: body without %q: %v", service, key, v)
+		}
+	}
+
+	if _, err := newChatNotifier("irc", ChatNotifier{URL: srv.URL}, nil); err == nil {
+		t.Error("unknown service accepted")
+	}
+}
+
+func TestScanNotifiers(t *testing.T) {
+	cfg := &Config{Notifiers: map[string]ChatNotifier{"slack": {URL: "https://hooks.example.com/x"}}}
+	tests := []struct {
+		o    scanOptions
+		n    int
+		fail bool
+	}{
+		{scanOptions{}, 0, false},
+		{scanOptions{notify: "slack"}, 1, false},
+		{scanOptions{notify: "slack", chatURLs: map[string]string{"teams": "https://t.example.com/"}}, 2, false},
+		{scanOptions{notify: "discord"}, 0, true},
+		{scanOptions{notify: "irc"}, 0, true},
+		{scanOptions{alertPorts: "445"}, 0, true},
+		{scanOptions{webhook: "https://example.com/", alertPorts: "445"}, 1, false},
+	}
+	for _, tt := range tests {
+		n, err := tt.o.notifiers(cfg)
+		if (err != nil) != tt.fail || len(n) != tt.n {
+			t.Errorf("%+v: %d notifiers, %v", tt.o, len(n), err)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !unix
+
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+// closedPort returns a port on 127.0.0.1 that nothing listens on. Another
+// listener may take the port once it is freed; unix systems keep it bound.
+func closedPort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
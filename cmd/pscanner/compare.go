@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+var compareDoc = &commandDoc{
+	synopsis: "pscanner compare [--output text|json] [label=]result.json [label=]result.json...",
+	description: `Compare scans of the same targets made from different vantage points.
+
+Each file is a JSON report ("pscanner scan --output json") of a scan run
+from another place: another office, a cloud region, the DMZ, or the same
+machine with another --source-ip. compare lists every port that one of
+them found open and another found not open, which exposes firewall rules
+and ACLs that depend on where a connection comes from.
+
+A port only counts as not open from a vantage point whose scan probed it:
+hosts and ports outside that scan, hosts that hit --host-timeout and
+canceled scans show "-" instead. A TCP connect scan cannot tell a refused
+connection from a filtered one, so both show as "closed".
+
+Each vantage point is named by its label, or by its file name without
+".json" when the argument has no "label=" in front.`,
+	notes: map[string]string{
+		"output": `json writes the vantage points and the ports that differ, with the state of each port from every vantage point.`,
+	},
+	examples: []string{
+		"pscanner compare office=office.json aws=aws-eu.json",
+		"pscanner compare --output json scan-*.json",
+	},
+}
+
+type compareOptions struct {
+	output string
+}
+
+func (o *compareOptions) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	fs.StringVar(&o.output, "output", "text", "Output format: text or json")
+	fs.Usage = func() { writeCommandHelp(fs.Output(), "compare", fs, compareDoc, false) }
+	return fs
+}
+
+// runCompare implements the "compare" command.
+func runCompare(args []string) {
+	var o compareOptions
+	fs := o.flagSet()
+	_ = fs.Parse(args)
+	if o.output != "text" && o.output != "json" {
+		fmt.Fprintf(os.Stderr, "error: unknown --output %q (want text, json)\n", o.output)
+		os.Exit(2)
+	}
+	if fs.NArg() < 2 {
+		fmt.Fprintln(os.Stderr, "error: compare needs at least two results")
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var labels []string
+	var reports []*Report
+	for _, arg := range fs.Args() {
+		label, name := vantageArg(arg)
+		if slices.Contains(labels, label) {
+			fmt.Fprintf(os.Stderr, "error: two vantage points are named %q; label them with label=file\n", label)
+			os.Exit(2)
+		}
+		r, err := readReport(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s: %v\n", name, err)
+			os.Exit(2)
+		}
+		labels = append(labels, label)
+		reports = append(reports, r)
+	}
+
+	c := compareVantages(labels, reports)
+	if o.output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(c)
+		return
+	}
+	writeComparison(os.Stdout, c)
+}
+
+// vantageArg splits a "label=file" argument. Without a label, or when the
+// whole argument names a file, the file name is the label.
+func vantageArg(arg string) (label, name string) {
+	if l, n, ok := strings.Cut(arg, "="); ok && l != "" {
+		if _, err := os.Stat(arg); err != nil {
+			return l, n
+		}
+	}
+	return strings.TrimSuffix(filepath.Base(arg), ".json"), arg
+}
+
+// readReport loads a JSON report written by --output json.
+func readReport(name string) (*Report, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var r Report
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("not a pscanner JSON report: %v", err)
+	}
+	if r.SchemaVersion == 0 {
+		return nil, fmt.Errorf("not a pscanner JSON report")
+	}
+	if r.SchemaVersion > schemaVersion {
+		return nil, fmt.Errorf("written by a newer pscanner (schema version %d)", r.SchemaVersion)
+	}
+	return &r, nil
+}
+
+// Port states in a vantage comparison.
+const (
+	vantageOpen    = "open"
+	vantageClosed  = "closed" // probed and not open: refused or filtered
+	vantageUnknown = "-"      // not probed from this vantage point
+)
+
+// vantageDiff is a port whose state depends on the vantage point.
+type vantageDiff struct {
+	Host     string   `json:"host"`
+	Family   string   `json:"family,omitempty"` // for --prefer both
+	Port     int      `json:"port"`
+	Protocol string   `json:"protocol"`
+	States   []string `json:"states"` // one per vantage point, in order
+}
+
+// vantageComparison lists the ports that vantage points disagree on.
+type vantageComparison struct {
+	Vantages    []string      `json:"vantages"`
+	Differences []vantageDiff `json:"differences"`
+}
+
+// compareVantages finds the ports that are open in some of reports and
+// probed but not open in others. labels name the reports. Hosts come in
+// the order the reports first list them, ports in ascending order.
+func compareVantages(labels []string, reports []*Report) vantageComparison {
+	c := vantageComparison{Vantages: labels, Differences: []vantageDiff{}}
+	var hosts []string
+	ports := make(map[string][]portChange)
+	open := make([]map[portChange]bool, len(reports))
+	probed := make([]func(portChange) bool, len(reports))
+	for i, r := range reports {
+		open[i] = openPorts(r)
+		probed[i] = probedBy(r)
+		for _, h := range r.Hosts {
+			if _, ok := ports[h.Host]; !ok {
+				hosts = append(hosts, h.Host)
+				ports[h.Host] = []portChange{}
+			}
+			for _, p := range h.Ports {
+				pc := portOf(h, p)
+				if !slices.Contains(ports[h.Host], pc) {
+					ports[h.Host] = append(ports[h.Host], pc)
+				}
+			}
+		}
+	}
+
+	for _, h := range hosts {
+		slices.SortFunc(ports[h], func(a, b portChange) int {
+			if a.Port != b.Port {
+				return a.Port - b.Port
+			}
+			if a.Protocol != b.Protocol {
+				return strings.Compare(a.Protocol, b.Protocol)
+			}
+			return strings.Compare(a.Family, b.Family)
+		})
+		for _, pc := range ports[h] {
+			d := vantageDiff{Host: pc.Host, Family: pc.Family, Port: pc.Port, Protocol: pc.Protocol, States: make([]string, len(reports))}
+			closed := false
+			for i := range reports {
+				switch {
+				case open[i][pc]:
+					d.States[i] = vantageOpen
+				case probed[i](pc):
+					d.States[i] = vantageClosed
+					closed = true
+				default:
+					d.States[i] = vantageUnknown
+				}
+			}
+			if closed {
+				c.Differences = append(c.Differences, d)
+			}
+		}
+	}
+	return c
+}
+
+func writeComparison(w io.Writer, c vantageComparison) {
+	if len(c.Differences) == 0 {
+		fmt.Fprintf(w, "No differences between %s.\n", strings.Join(c.Vantages, ", "))
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "HOST\tPORT\t%s\n", strings.Join(c.Vantages, "\t"))
+	for _, d := range c.Differences {
+		host := d.Host
+		if d.Family != "" {
+			host += " over " + d.Family
+		}
+		fmt.Fprintf(tw, "%s\t%d/%s\t%s\n", host, d.Port, d.Protocol, strings.Join(d.States, "\t"))
+	}
+	tw.Flush()
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestCompareVantages(t *testing.T) {
+	timedOut := hostWith("b")
+	timedOut.TimedOut = true
+	office := testReport("22,80,443", hostWith("a", 22, 80), hostWith("b", 443))
+	cloud := testReport("22,80,443", hostWith("a", 80), timedOut)
+	narrow := testReport("80", hostWith("a", 80), hostWith("b"))
+
+	c := compareVantages([]string{"office", "cloud", "narrow"}, []*Report{office, cloud, narrow})
+	want := []vantageDiff{
+		{Host: "a", Port: 22, Protocol: "tcp", States: []string{"open", "closed", "-"}},
+	}
+	if !reflect.DeepEqual(c.Differences, want) {
+		t.Errorf("differences = %+v, want %+v", c.Differences, want)
+	}
+
+	// b:443 is only unknown elsewhere until a scan probes it.
+	c = compareVantages([]string{"office", "dmz"}, []*Report{office, testReport("443", hostWith("b"))})
+	var buf bytes.Buffer
+	writeComparison(&buf, c)
+	if got := buf.String(); got != "HOST  PORT     office  dmz\nb     443/tcp  open    closed\n" {
+		t.Errorf("text output:\n%s", got)
+	}
+
+	// A hostname scanned over both families has a row for each.
+	v4, v6 := hostWith("example.com", 443), hostWith("example.com")
+	v4.Family, v6.Family = familyIPv4, familyIPv6
+	c = compareVantages([]string{"office", "dmz"}, []*Report{testReport("443", v4, v6), testReport("443", v4, v6)})
+	if len(c.Differences) != 0 {
+		t.Errorf("differences between families = %+v, want none", c.Differences)
+	}
+	closedV4 := hostWith("example.com")
+	closedV4.Family = familyIPv4
+	c = compareVantages([]string{"office", "dmz"}, []*Report{testReport("443", v4, v6), testReport("443", closedV4, v6)})
+	buf.Reset()
+	writeComparison(&buf, c)
+	if got := buf.String(); got != "HOST                   PORT     office  dmz\nexample.com over ipv4  443/tcp  open    closed\n" {
+		t.Errorf("text output:\n%s", got)
+	}
+}
+
+func TestVantageArg(t *testing.T) {
+	for arg, want := range map[string][2]string{
+		"aws=results/aws.json": {"aws", "results/aws.json"},
+		"results/office.json":  {"office", "results/office.json"},
+		"=x.json":              {"=x", "=x.json"},
+	} {
+		if l, n := vantageArg(arg); l != want[0] || n != want[1] {
+			t.Errorf("vantageArg(%q) = %q, %q", arg, l, n)
+		}
+	}
+}
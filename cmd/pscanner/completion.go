@@ -0,0 +1,231 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+var completionDoc = &commandDoc{
+	synopsis: "pscanner completion bash|zsh|fish",
+	description: `Generate a shell completion script.
+
+The script completes commands, flags, profile names, port groups, output
+formats and the other fixed values of flags, such as those of --state. Profiles and groups from the default config file are included as
+they are when the script is generated.`,
+	examples: []string{
+		"source <(pscanner completion bash)",
+		`pscanner completion zsh > "${fpath[1]}/_pscanner"`,
+		"pscanner completion fish > ~/.config/fish/completions/pscanner.fish",
+	},
+}
+
+// runCompletion implements the "completion" command.
+func runCompletion(args []string) {
+	if len(args) != 1 || strings.HasPrefix(args[0], "-") {
+		writeCommandHelp(os.Stderr, "completion", nil, completionDoc, false)
+		if len(args) == 1 && (args[0] == "-h" || args[0] == "-help" || args[0] == "--help") {
+			return
+		}
+		os.Exit(2)
+	}
+
+	// Values that depend on the config file (profiles, port groups) are
+	// taken from the default config at generation time.
+	cfg, err := loadConfig(defaultConfigPath(), false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading config: %v\n", err)
+		os.Exit(2)
+	}
+	values := completionValues(cfg)
+
+	switch args[0] {
+	case "bash":
+		writeBashCompletion(os.Stdout, values)
+	case "zsh":
+		writeZshCompletion(os.Stdout, values)
+	case "fish":
+		writeFishCompletion(os.Stdout, values)
+	default:
+		fmt.Fprintf(os.Stderr, "error: unsupported shell %q (want bash, zsh or fish)\n", args[0])
+		os.Exit(2)
+	}
+}
+
+// completionValues lists the candidate values of flags, keyed by flag name.
+func completionValues(cfg *Config) map[string][]string {
+	var groups []string
+	for name := range cfg.portGroups() {
+		groups = append(groups, "@"+name)
+	}
+	sort.Strings(groups)
+	return map[string][]string{
+		"profile":    cfg.profileNames(),
+		"ports":      groups,
+		"output":     outputFormats,
+		"udp":        append(udpProbeNames(), "all"),
+		"tcp":        append(tcpProbeNames(), "all"),
+		"state":      portStates,
+		"prefer":     {familyIPv4, familyIPv6, preferBoth},
+		"host-order": {"input", "ip", "risk"},
+		"log-format": {"text", "json"},
+	}
+}
+
+// listFlags are the flags of completionValues that take a comma-separated
+// list; the others take one value.
+var listFlags = map[string]bool{"ports": true, "udp": true, "tcp": true, "state": true}
+
+// completionCommandNames returns the names offered in the first position.
+func completionCommandNames() []string {
+	names := []string{"help"}
+	for _, c := range commands {
+		names = append(names, c.name)
+	}
+	return names
+}
+
+// visitFlags calls fn for every flag of c, in lexical order.
+func visitFlags(c command, fn func(f *flag.Flag, isBool bool)) {
+	if c.flags == nil {
+		return
+	}
+	c.flags().VisitAll(func(f *flag.Flag) {
+		b, ok := f.Value.(interface{ IsBoolFlag() bool })
+		fn(f, ok && b.IsBoolFlag())
+	})
+}
+
+func writeBashCompletion(w io.Writer, values map[string][]string) {
+	fmt.Fprintf(w, `# bash completion for pscanner
+_pscanner() {
+    local cur prev words
+    cur="${COMP_WORDS[COMP_CWORD]}"
+    prev="${COMP_WORDS[COMP_CWORD-1]}"
+
+    if [[ $COMP_CWORD -eq 1 ]]; then
+        COMPREPLY=( $(compgen -W "%s" -- "$cur") )
+        return
+    fi
+
+    case "${COMP_WORDS[1]}" in
+`, strings.Join(completionCommandNames(), " "))
+	for _, c := range commands {
+		fmt.Fprintf(w, "    %s)\n", c.name)
+		if c.name == "completion" {
+			fmt.Fprintf(w, "        COMPREPLY=( $(compgen -W \"bash zsh fish\" -- \"$cur\") )\n        ;;\n")
+			continue
+		}
+		var flags []string
+		fmt.Fprintf(w, "        case \"$prev\" in\n")
+		visitFlags(c, func(f *flag.Flag, isBool bool) {
+			flags = append(flags, "--"+f.Name)
+			vals, ok := values[f.Name]
+			if !ok {
+				return
+			}
+			fmt.Fprintf(w, "            -%[1]s|--%[1]s)\n", f.Name)
+			if listFlags[f.Name] {
+				// Comma-separated flags complete the item after the last comma.
+				fmt.Fprintf(w, "                local pre=\"\"\n")
+				fmt.Fprintf(w, "                [[ \"$cur\" == *,* ]] && pre=\"${cur%%,*},\"\n")
+				fmt.Fprintf(w, "                COMPREPLY=( $(compgen -P \"$pre\" -W \"%s\" -- \"${cur##*,}\") )\n", strings.Join(vals, " "))
+			} else {
+				fmt.Fprintf(w, "                COMPREPLY=( $(compgen -W \"%s\" -- \"$cur\") )\n", strings.Join(vals, " "))
+			}
+			fmt.Fprintf(w, "                return ;;\n")
+		})
+		fmt.Fprintf(w, "        esac\n")
+		fmt.Fprintf(w, "        COMPREPLY=( $(compgen -W \"%s\" -- \"$cur\") )\n        ;;\n", strings.Join(flags, " "))
+	}
+	fmt.Fprintf(w, `    help)
+        COMPREPLY=( $(compgen -W "%s" -- "$cur") )
+        ;;
+    esac
+}
+complete -F _pscanner pscanner
+`, strings.Join(completionCommandNames()[1:], " "))
+}
+
+// zshEscape escapes text for use inside an _arguments spec.
+func zshEscape(s string) string {
+	r := strings.NewReplacer("'", `'\''`, "[", `\[`, "]", `\]`, ":", `\:`)
+	return r.Replace(s)
+}
+
+func writeZshCompletion(w io.Writer, values map[string][]string) {
+	fmt.Fprintf(w, "#compdef pscanner\n\n_pscanner() {\n    local -a commands\n    commands=(\n")
+	fmt.Fprintf(w, "        'help:Show help for a command'\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "        '%s:%s'\n", c.name, zshEscape(c.short))
+	}
+	fmt.Fprintf(w, "    )\n\n    if (( CURRENT == 2 )); then\n        _describe 'command' commands\n        return\n    fi\n\n    case $words[2] in\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "    %s)\n", c.name)
+		if c.name == "completion" {
+			fmt.Fprintf(w, "        _arguments '2:shell:(bash zsh fish)'\n        ;;\n")
+			continue
+		}
+		fmt.Fprintf(w, "        _arguments \\\n")
+		visitFlags(c, func(f *flag.Flag, isBool bool) {
+			_, usage := flag.UnquoteUsage(f)
+			spec := fmt.Sprintf("--%s[%s]", f.Name, zshEscape(usage))
+			if !isBool {
+				action := ""
+				switch {
+				case values[f.Name] != nil && listFlags[f.Name]:
+					action = "_values -s , " + f.Name + " " + strings.Join(values[f.Name], " ")
+				case values[f.Name] != nil:
+					action = "(" + strings.Join(values[f.Name], " ") + ")"
+				case f.Name == "host":
+					action = "_hosts"
+				case f.Name == "config":
+					action = "_files"
+				}
+				spec += ":" + f.Name + ":" + action
+			}
+			fmt.Fprintf(w, "            '%s' \\\n", spec)
+		})
+		fmt.Fprintf(w, "            '--help[Show help]'\n        ;;\n")
+	}
+	fmt.Fprintf(w, "    help)\n        _describe 'command' commands\n        ;;\n    esac\n}\n\n")
+	fmt.Fprintf(w, "if [ \"$funcstack[1]\" = \"_pscanner\" ]; then\n    _pscanner \"$@\"\nelse\n    compdef _pscanner pscanner\nfi\n")
+}
+
+// fishEscape quotes text for a fish single-quoted string.
+func fishEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s)
+}
+
+func writeFishCompletion(w io.Writer, values map[string][]string) {
+	fmt.Fprintf(w, "# fish completion for pscanner\ncomplete -c pscanner -f\n")
+	fmt.Fprintf(w, "complete -c pscanner -n __fish_use_subcommand -a help -d 'Show help for a command'\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "complete -c pscanner -n __fish_use_subcommand -a %s -d '%s'\n", c.name, fishEscape(c.short))
+	}
+	for _, c := range commands {
+		cond := "__fish_seen_subcommand_from " + c.name
+		if c.name == "completion" {
+			fmt.Fprintf(w, "complete -c pscanner -n '%s' -a 'bash zsh fish'\n", cond)
+			continue
+		}
+		visitFlags(c, func(f *flag.Flag, isBool bool) {
+			_, usage := flag.UnquoteUsage(f)
+			line := fmt.Sprintf("complete -c pscanner -n '%s' -l %s -d '%s'", cond, f.Name, fishEscape(usage))
+			if !isBool {
+				if vals, ok := values[f.Name]; ok {
+					line += fmt.Sprintf(" -x -a '%s'", strings.Join(vals, " "))
+				} else if f.Name == "config" {
+					line += " -r -F"
+				} else {
+					line += " -x"
+				}
+			}
+			fmt.Fprintln(w, line)
+		})
+	}
+	fmt.Fprintf(w, "complete -c pscanner -n '__fish_seen_subcommand_from help' -a '%s'\n", strings.Join(completionCommandNames()[1:], " "))
+}
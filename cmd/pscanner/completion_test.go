@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestCompletionScripts(t *testing.T) {
+	values := completionValues(&Config{Profiles: map[string]Profile{"nightly": {Ports: "1-100"}}})
+	for _, tt := range []struct {
+		shell string
+		write func(io.Writer, map[string][]string)
+	}{
+		{"bash", writeBashCompletion},
+		{"zsh", writeZshCompletion},
+		{"fish", writeFishCompletion},
+	} {
+		var buf bytes.Buffer
+		tt.write(&buf, values)
+		script := buf.String()
+		for _, want := range []string{"scan", "completion", "ports", "nightly", "@web"} {
+			if !strings.Contains(script, want) {
+				t.Errorf("%s script lacks %q", tt.shell, want)
+			}
+		}
+		for _, want := range []string{"ipv6", "risk", "filtered"} {
+			if !strings.Contains(script, want) {
+				t.Errorf("%s script lacks %q", tt.shell, want)
+			}
+		}
+		if tt.shell != "bash" && strings.Contains(script, "`") {
+			t.Errorf("%s script has a backquote of a flag usage", tt.shell)
+		}
+		if tt.shell == "bash" {
+			// Single-valued flags complete the whole word, lists the item
+			// after the last comma.
+			for _, want := range []string{
+				"--output)\n                COMPREPLY=( $(compgen -W \"text json",
+				"--state)\n                local pre=\"\"\n",
+			} {
+				if !strings.Contains(script, want) {
+					t.Errorf("bash script lacks %q", want)
+				}
+			}
+		}
+		// Check the syntax with the shell itself where it is installed.
+		if path, err := exec.LookPath(tt.shell); err == nil {
+			cmd := exec.Command(path, "-n")
+			if tt.shell == "fish" {
+				cmd = exec.Command(path, "--no-execute")
+			}
+			cmd.Stdin = strings.NewReader(script)
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Errorf("%s rejects its completion script: %v\n%s", tt.shell, err, out)
+			}
+		}
+	}
+}
+
+func TestCompletionEscapes(t *testing.T) {
+	if got, want := zshEscape("a:b [c] it's"), `a\:b \[c\] it'\''s`; got != want {
+		t.Errorf("zshEscape = %q, want %q", got, want)
+	}
+	if got, want := fishEscape(`it's a\b`), `it\'s a\\b`; got != want {
+		t.Errorf("fishEscape = %q, want %q", got, want)
+	}
+}
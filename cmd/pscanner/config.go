@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Profile bundles scan settings under a name so they can be selected with
+// --profile. Zero values mean "not set" and leave the flag default alone.
+type Profile struct {
+	Ports       string   `json:"ports,omitempty"`
+	TopPorts    int      `json:"top_ports,omitempty"`
+	Workers     int      `json:"workers,omitempty"`
+	Timeout     Duration `json:"timeout,omitempty"`
+	HostTimeout Duration `json:"host_timeout,omitempty"`
+	Delay       Duration `json:"delay,omitempty"`
+	Output      string   `json:"output,omitempty"`
+}
+
+// Config is the on-disk configuration file.
+type Config struct {
+	Profiles map[string]Profile `json:"profiles,omitempty"`
+	Groups   map[string]string  `json:"groups,omitempty"`
+
+	// ConfirmProbes is the number of host:port probes above which a scan
+	// needs --yes or interactive confirmation. 0 means the default; a
+	// negative value disables the check.
+	ConfirmProbes int `json:"confirm_probes,omitempty"`
+
+	// Schedules are scans that "pscanner serve" runs on its own.
+	Schedules []Schedule `json:"schedules,omitempty"`
+
+	// Webhooks receive the results of scheduled scans.
+	Webhooks []Webhook `json:"webhooks,omitempty"`
+
+	// Notifiers are chat channels, keyed by service (slack, discord or
+	// teams). Scheduled scans post to all of them; on the command line
+	// --notify picks them by name.
+	Notifiers map[string]ChatNotifier `json:"notifiers,omitempty"`
+
+	// Email, when set, mails the reports of scheduled scans.
+	Email *Email `json:"email,omitempty"`
+
+	// Elasticsearch, when set, indexes the results of scheduled scans.
+	Elasticsearch *Elasticsearch `json:"elasticsearch,omitempty"`
+
+	// Publish lists brokers, as --publish URLs, that receive the results of
+	// scheduled scans.
+	Publish []string `json:"publish,omitempty"`
+
+	// Upload, when set, writes the reports of scheduled scans to object
+	// storage.
+	Upload *Upload `json:"upload,omitempty"`
+
+	// DB is a PostgreSQL URL that stores the results of scheduled scans and
+	// that history and query read by default.
+	DB string `json:"db,omitempty"`
+
+	// AllowPublicHosts exempts single IPs and hostnames from the public
+	// address confirmation. CIDR blocks covering public space still need it.
+	AllowPublicHosts bool `json:"allow_public_hosts,omitempty"`
+
+	// Routes send the probes of some targets through proxies or SSH jump
+	// hosts; other targets are dialed as --proxy and --via-ssh say.
+	Routes []Route `json:"routes,omitempty"`
+
+	// AuditLog is a file that every scan appends records to: who ran it,
+	// with which options and targets, and a hash of its output.
+	AuditLog string `json:"audit_log,omitempty"`
+}
+
+// Schedule is a recurring scan. The scan settings are those of a server
+// scan request; confirm must be set for scans that would otherwise ask for
+// confirmation.
+type Schedule struct {
+	Name string `json:"name"`
+	// When is "every <duration>", @hourly, @daily, @weekly or a five-field
+	// cron expression, evaluated in local time.
+	When string `json:"when"`
+	scanRequest
+}
+
+// builtinProfiles are always available; a profile with the same name in the
+// config file replaces the built-in one.
+var builtinProfiles = map[string]Profile{
+	"quick": {
+		TopPorts: 100,
+		Workers:  200,
+		Timeout:  Duration(300 * time.Millisecond),
+	},
+	"full": {
+		Ports:   "1-65535",
+		Workers: 1000,
+		Timeout: Duration(500 * time.Millisecond),
+	},
+	"stealth": {
+		Ports:   "1-1024",
+		Workers: 5,
+		Timeout: Duration(2 * time.Second),
+		Delay:   Duration(250 * time.Millisecond),
+	},
+}
+
+// defaultConfigPath returns the per-user config location, e.g.
+// ~/.config/pscanner/config.json on Linux.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "pscanner", "config.json")
+}
+
+// loadConfig reads the config file at path. A missing file is only an error
+// when the path was given explicitly.
+func loadConfig(path string, explicit bool) (*Config, error) {
+	cfg := &Config{}
+	if path == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && !explicit {
+			return cfg, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// profile looks up a profile by name, preferring the config file over the
+// built-in set.
+func (c *Config) profile(name string) (Profile, error) {
+	if p, ok := c.Profiles[name]; ok {
+		return p, nil
+	}
+	if p, ok := builtinProfiles[name]; ok {
+		return p, nil
+	}
+	return Profile{}, fmt.Errorf("unknown profile %q (available: %v)", name, c.profileNames())
+}
+
+func (c *Config) profileNames() []string {
+	seen := make(map[string]struct{})
+	for n := range builtinProfiles {
+		seen[n] = struct{}{}
+	}
+	for n := range c.Profiles {
+		seen[n] = struct{}{}
+	}
+	names := make([]string, 0, len(seen))
+	for n := range seen {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// portGroups merges the built-in port groups with those from the config file.
+func (c *Config) portGroups() map[string]string {
+	groups := make(map[string]string, len(builtinGroups)+len(c.Groups))
+	for n, g := range builtinGroups {
+		groups[n] = g
+	}
+	for n, g := range c.Groups {
+		groups[n] = g
+	}
+	return groups
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigMissing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	cfg, err := loadConfig(path, false)
+	if err != nil || cfg == nil {
+		t.Fatalf("loadConfig of a missing default file = %v, %v; want an empty config", cfg, err)
+	}
+	if _, err := loadConfig(path, true); err == nil {
+		t.Error("loadConfig of a missing --config file succeeded, want error")
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{"profiles": [}`), 0o644)
+	_, err := loadConfig(path, false)
+	if err == nil || !strings.Contains(err.Error(), path) {
+		t.Errorf("loadConfig of bad JSON = %v, want an error naming the file", err)
+	}
+}
+
+func TestConfigProfiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{"profiles": {
+		"quick": {"ports": "22,80", "workers": 10},
+		"web": {"ports": "80,443"}
+	}}`), 0o644)
+	cfg, err := loadConfig(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A config profile replaces the built-in one of the same name.
+	p, err := cfg.profile("quick")
+	if err != nil || p.Ports != "22,80" || p.Workers != 10 {
+		t.Errorf("profile(quick) = %+v, %v; want the config's", p, err)
+	}
+	if p, err := cfg.profile("full"); err != nil || p.Ports != builtinProfiles["full"].Ports {
+		t.Errorf("profile(full) = %+v, %v; want the built-in one", p, err)
+	}
+	if p, err := cfg.profile("web"); err != nil || p.Ports != "80,443" {
+		t.Errorf("profile(web) = %+v, %v", p, err)
+	}
+
+	_, err = cfg.profile("nope")
+	if err == nil || !strings.Contains(err.Error(), "stealth") || !strings.Contains(err.Error(), "web") {
+		t.Errorf("profile(nope) = %v, want an error listing the available profiles", err)
+	}
+	want := []string{"full", "quick", "stealth", "web"}
+	if got := cfg.profileNames(); !reflect.DeepEqual(got, want) {
+		t.Errorf("profileNames = %v, want %v", got, want)
+	}
+}
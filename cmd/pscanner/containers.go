@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+)
+
+// ContainerAPI is a Docker, Kubernetes or etcd endpoint that --containers
+// found, and whether it lets anyone in.
+type ContainerAPI struct {
+	Port int `json:"port"`
+	// Service is docker, kubelet, kube-apiserver or etcd.
+	Service string `json:"service"`
+	Version string `json:"version,omitempty"`
+	// Unauthenticated is set when the endpoint answers requests without
+	// credentials: the exposure --containers looks for.
+	Unauthenticated bool `json:"unauthenticated"`
+	// Details says what it showed, such as how many containers it runs,
+	// or how it asked for credentials.
+	Details string `json:"details,omitempty"`
+}
+
+func (c ContainerAPI) String() string {
+	s := fmt.Sprintf("tcp/%d %s", c.Port, c.Service)
+	if c.Version != "" {
+		s += " " + c.Version
+	}
+	switch {
+	case c.Unauthenticated && c.Details != "":
+		s += ": unauthenticated; " + c.Details
+	case c.Unauthenticated:
+		s += ": unauthenticated"
+	case c.Details != "":
+		s += ": " + c.Details
+	}
+	return s
+}
+
+// containerPorts are the ports --containers checks when the scan found
+// them open, with the service usually there and whether it speaks TLS.
+var containerPorts = map[int]struct {
+	service string
+	tls     bool
+}{
+	2375:  {"docker", false},
+	2376:  {"docker", true},
+	2379:  {"etcd", false},
+	6443:  {"kube-apiserver", true},
+	8080:  {"kube-apiserver", false}, // the insecure port of old clusters
+	8443:  {"kube-apiserver", true},
+	10250: {"kubelet", true},
+	10255: {"kubelet", false}, // read-only
+}
+
+// probeContainers checks the Docker, kubelet, Kubernetes API and etcd
+// ports the scan found open for access without credentials. It only reads:
+// versions, and lists of containers, pods, namespaces or keys, which it
+// counts.
+func (p *scanPlan) probeContainers(ctx context.Context, hosts []HostResult) {
+	if !p.containers {
+		return
+	}
+	dns := newDNSCache(p.dnsCache)
+	sem := make(chan struct{}, quicParallel)
+	var wg sync.WaitGroup
+	for i := range hosts {
+		h := &hosts[i]
+		sem <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			for _, pr := range h.Ports {
+				cp, ok := containerPorts[pr.Port]
+				if pr.Protocol == "udp" || !ok {
+					continue
+				}
+				c, err := p.containerProbe(ctx, dns, h, pr.Port, cp.service, cp.tls)
+				if err != nil {
+					slog.Warn("container probe failed", "host", h.Host, "port", pr.Port, "service", cp.service, "err", err)
+				}
+				if c != nil {
+					h.Containers = append(h.Containers, *c)
+				}
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// containerProbe checks the service on port of h over HTTPS if useTLS is
+// set, or plain HTTP, and over the other if that fails, as etcd and
+// misconfigured daemons speak either.
+func (p *scanPlan) containerProbe(ctx context.Context, dns *dnsCache, h *HostResult, port int, service string, useTLS bool) (*ContainerAPI, error) {
+	check := map[string]func(context.Context, *httpEndpoint) (*ContainerAPI, error){
+		"docker":         dockerCheck,
+		"kubelet":        kubeletCheck,
+		"kube-apiserver": kubeAPICheck,
+		"etcd":           etcdCheck,
+	}[service]
+	api, certRequired, err := httpCheck(ctx, p, dns, h, port, useTLS, check)
+	if certRequired {
+		return &ContainerAPI{Port: port, Service: service, Details: "client certificate required"}, nil
+	}
+	if api != nil {
+		api.Port, api.Service = port, service
+	}
+	return api, err
+}
+
+// dockerCheck asks a Docker daemon for its version and containers, which
+// it gives anyone unless TLS verifies clients: control of the daemon is
+// root on its host.
+func dockerCheck(ctx context.Context, c *httpEndpoint) (*ContainerAPI, error) {
+	var version struct {
+		Version    string
+		APIVersion string `json:"ApiVersion"`
+	}
+	status, err := c.do(ctx, "/version", nil, &version)
+	if msg := credentialsAsked(status); msg != "" {
+		return &ContainerAPI{Details: msg}, nil
+	}
+	if err != nil || status != http.StatusOK || version.APIVersion == "" {
+		return nil, ignoreNotJSON(err)
+	}
+	api := &ContainerAPI{Version: version.Version, Unauthenticated: true}
+	var containers []json.RawMessage
+	if status, err := c.do(ctx, "/containers/json?all=1", nil, &containers); err == nil && status == http.StatusOK {
+		api.Details = fmt.Sprintf("%d containers", len(containers))
+	}
+	return api, nil
+}
+
+// kubeletCheck lists a kubelet's pods, which with anonymous access also
+// lets anyone run commands in them.
+func kubeletCheck(ctx context.Context, c *httpEndpoint) (*ContainerAPI, error) {
+	var pods struct {
+		Kind  string
+		Items []json.RawMessage
+	}
+	status, err := c.do(ctx, "/pods", nil, &pods)
+	if msg := credentialsAsked(status); msg != "" {
+		return &ContainerAPI{Details: msg}, nil
+	}
+	if err != nil || status != http.StatusOK || pods.Kind != "PodList" {
+		return nil, ignoreNotJSON(err)
+	}
+	return &ContainerAPI{Unauthenticated: true, Details: fmt.Sprintf("%d pods", len(pods.Items))}, nil
+}
+
+// kubeAPICheck asks a Kubernetes API server for its version, which most
+// give anyone, then for its namespaces, which they should not.
+func kubeAPICheck(ctx context.Context, c *httpEndpoint) (*ContainerAPI, error) {
+	var version struct {
+		Kind       string `json:"kind"`
+		GitVersion string `json:"gitVersion"`
+	}
+	status, err := c.do(ctx, "/version", nil, &version)
+	switch {
+	case err != nil:
+		return nil, ignoreNotJSON(err)
+	case status == http.StatusOK && version.GitVersion != "":
+	case version.Kind == "Status" && credentialsAsked(status) != "":
+		return &ContainerAPI{Details: credentialsAsked(status)}, nil
+	default:
+		return nil, nil
+	}
+	api := &ContainerAPI{Version: version.GitVersion}
+	var namespaces struct {
+		Items []json.RawMessage `json:"items"`
+	}
+	status, err = c.do(ctx, "/api/v1/namespaces", nil, &namespaces)
+	switch {
+	case err == nil && status == http.StatusOK:
+		api.Unauthenticated = true
+		api.Details = fmt.Sprintf("%d namespaces", len(namespaces.Items))
+	case credentialsAsked(status) != "":
+		api.Details = credentialsAsked(status)
+	}
+	return api, nil
+}
+
+// etcdCheck asks etcd for its version, then counts its keys through the
+// v3 JSON gateway, which answers anyone unless authentication is on. The
+// keys of a Kubernetes cluster's etcd hold all of its secrets.
+func etcdCheck(ctx context.Context, c *httpEndpoint) (*ContainerAPI, error) {
+	var version struct {
+		Server string `json:"etcdserver"`
+	}
+	status, err := c.do(ctx, "/version", nil, &version)
+	if err != nil || status != http.StatusOK || version.Server == "" {
+		return nil, ignoreNotJSON(err)
+	}
+	api := &ContainerAPI{Version: version.Server}
+	// Every key, from "\x00" on, counted rather than read.
+	var keys struct {
+		Header json.RawMessage `json:"header"`
+		Count  string          `json:"count"`
+	}
+	status, err = c.do(ctx, "/v3/kv/range", map[string]any{"key": "AA==", "range_end": "AA==", "count_only": true}, &keys)
+	switch {
+	case err == nil && status == http.StatusOK && keys.Header != nil:
+		if keys.Count == "" {
+			keys.Count = "0"
+		}
+		api.Unauthenticated = true
+		api.Details = keys.Count + " keys"
+	case err == nil:
+		api.Details = "authentication required"
+	}
+	return api, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func serverPort(t *testing.T, s *httptest.Server) int {
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, _ := strconv.Atoi(u.Port())
+	return port
+}
+
+func TestContainerProbe(t *testing.T) {
+	docker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/version":
+			io.WriteString(w, `{"Version":"24.0.7","ApiVersion":"1.43","Os":"linux"}`)
+		case "/containers/json":
+			io.WriteString(w, `[{"Id":"a"},{"Id":"b"},{"Id":"c"}]`)
+		}
+	}))
+	defer docker.Close()
+	kubelet := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"kind":"PodList","apiVersion":"v1","items":[{},{}]}`)
+	}))
+	defer kubelet.Close()
+	kubeletAuth := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	}))
+	defer kubeletAuth.Close()
+	apiserver := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/version" {
+			io.WriteString(w, `{"major":"1","minor":"29","gitVersion":"v1.29.2"}`)
+			return
+		}
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, `{"kind":"Status","status":"Failure","reason":"Forbidden","code":403}`)
+	}))
+	defer apiserver.Close()
+	// etcd over plain HTTP on a port taken to speak TLS.
+	etcd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/version":
+			io.WriteString(w, `{"etcdserver":"3.5.9","etcdcluster":"3.5.0"}`)
+		case "/v3/kv/range":
+			var req map[string]any
+			if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&req) != nil || req["count_only"] != true {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+			io.WriteString(w, `{"header":{"cluster_id":"1","revision":"42"},"count":"312"}`)
+		}
+	}))
+	defer etcd.Close()
+	mtls := httptest.NewUnstartedServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	mtls.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	mtls.Config.ErrorLog = log.New(io.Discard, "", 0) // the refused handshake
+	mtls.StartTLS()
+	defer mtls.Close()
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "<html>hello</html>")
+	}))
+	defer web.Close()
+
+	p := &scanPlan{timeout: time.Second}
+	h := &HostResult{Host: "127.0.0.1"}
+	for _, tt := range []struct {
+		server  *httptest.Server
+		service string
+		tls     bool
+		want    *ContainerAPI
+	}{
+		{docker, "docker", false, &ContainerAPI{Service: "docker", Version: "24.0.7", Unauthenticated: true, Details: "3 containers"}},
+		{kubelet, "kubelet", true, &ContainerAPI{Service: "kubelet", Unauthenticated: true, Details: "2 pods"}},
+		{kubeletAuth, "kubelet", true, &ContainerAPI{Service: "kubelet", Details: "authentication required"}},
+		{apiserver, "kube-apiserver", true, &ContainerAPI{Service: "kube-apiserver", Version: "v1.29.2", Details: "anonymous requests allowed, but not authorized"}},
+		{etcd, "etcd", true, &ContainerAPI{Service: "etcd", Version: "3.5.9", Unauthenticated: true, Details: "312 keys"}},
+		{mtls, "docker", true, &ContainerAPI{Service: "docker", Details: "client certificate required"}},
+		{web, "kube-apiserver", false, nil},
+	} {
+		port := serverPort(t, tt.server)
+		if tt.want != nil {
+			tt.want.Port = port
+		}
+		got, err := p.containerProbe(context.Background(), nil, h, port, tt.service, tt.tls)
+		if err != nil || (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+			t.Errorf("containerProbe of %s = %+v, %v; want %+v", tt.service, got, err, tt.want)
+		}
+	}
+}
+
+func TestContainerAPIString(t *testing.T) {
+	for _, tt := range []struct {
+		c    ContainerAPI
+		want string
+	}{
+		{ContainerAPI{Port: 2375, Service: "docker", Version: "24.0.7", Unauthenticated: true, Details: "3 containers"}, "tcp/2375 docker 24.0.7: unauthenticated; 3 containers"},
+		{ContainerAPI{Port: 10250, Service: "kubelet", Details: "authentication required"}, "tcp/10250 kubelet: authentication required"},
+	} {
+		if got := tt.c.String(); got != tt.want {
+			t.Errorf("String = %q, want %q", got, tt.want)
+		}
+	}
+}
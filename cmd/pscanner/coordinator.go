@@ -0,0 +1,427 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"iter"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// agentTokenEnv names the secret that a coordinator and its agents share.
+// Agents run whatever scan the coordinator hands them, so both sides
+// refuse to start without it.
+const agentTokenEnv = "PSCANNER_AGENT_TOKEN"
+
+const (
+	// shardProbes is the number of host:port probes in one shard.
+	shardProbes = 4096
+	// leaseTime is how long an agent may go without a heartbeat before
+	// its shard is handed to another agent.
+	leaseTime = 30 * time.Second
+	// heartbeatEvery is how often agents report that they are still busy.
+	heartbeatEvery = 10 * time.Second
+	// agentPoll is how long an agent waits when there is no work.
+	agentPoll = 2 * time.Second
+)
+
+// shardTarget is a host of a shard with the ports to probe on it, written
+// as for --ports.
+type shardTarget struct {
+	Host  string `json:"host"`
+	Ports string `json:"ports"`
+}
+
+// shard is the part of a distributed scan leased to one agent, with the
+// settings to probe it with.
+type shard struct {
+	Lease       string        `json:"lease"`
+	Targets     []shardTarget `json:"targets"`
+	Workers     int           `json:"workers"`
+	Timeout     Duration      `json:"timeout"`
+	HostTimeout Duration      `json:"host_timeout"`
+	Delay       Duration      `json:"delay"`
+	Retries     int           `json:"retries,omitempty"`
+	Force       bool          `json:"force,omitempty"`
+	// PortTimeouts are the --port-timeout overrides, as "25:3s,1433:2s".
+	PortTimeouts string `json:"port_timeouts,omitempty"`
+	// CalibratePort, MinTimeout and MaxTimeout are --calibrate-port and
+	// the bounds of the timeouts it picks.
+	CalibratePort int      `json:"calibrate_port,omitempty"`
+	MinTimeout    Duration `json:"min_timeout,omitempty"`
+	MaxTimeout    Duration `json:"max_timeout,omitempty"`
+}
+
+// shardResult is what an agent sends back. A shard the agent could not
+// scan carries an error and is handed to another agent. A shard it will not
+// scan, because it reaches outside the agent's scope, is refused: it is not
+// handed out again, and its probes count as failed.
+type shardResult struct {
+	Agent   string       `json:"agent"`
+	Hosts   []HostResult `json:"hosts,omitempty"`
+	Error   string       `json:"error,omitempty"`
+	Refused string       `json:"refused,omitempty"`
+}
+
+// shards splits the plan into pieces of at most shardProbes probes, in
+// target order. A host with more ports than that is split across shards.
+func (p *scanPlan) shards() iter.Seq[[]shardTarget] {
+	return func(yield func([]shardTarget) bool) {
+		var cur []shardTarget
+		n := 0
+		stopped := false
+		p.targets.each(func(h string) bool {
+			ports := p.portsFor(h)
+			for len(ports) > 0 {
+				k := min(len(ports), shardProbes-n)
+				cur = append(cur, shardTarget{Host: h, Ports: formatPorts(ports[:k])})
+				n += k
+				ports = ports[k:]
+				if n == shardProbes {
+					if !yield(cur) {
+						stopped = true
+						return false
+					}
+					cur, n = nil, 0
+				}
+			}
+			return true
+		})
+		if !stopped && len(cur) > 0 {
+			yield(cur)
+		}
+	}
+}
+
+// coordinator hands the shards of a scan to the agents that poll it, and
+// collects their results. Shards are made as agents ask for them, so a
+// large scan is never held in memory at once.
+type coordinator struct {
+	token string
+
+	mu     sync.Mutex
+	cur    *distRun
+	agents map[string]bool // agents seen so far
+}
+
+// distRun is the state of the scan being distributed.
+type distRun struct {
+	plan     *scanPlan
+	next     func() ([]shardTarget, bool)
+	upcoming []shardTarget   // the next shard from next; nil when drained
+	retry    [][]shardTarget // shards given back by failed agents
+	leases   map[string]*lease
+	pending  int // leased shards without a merged result
+	results  chan shardDelivery
+	stopped  chan struct{} // closed when run returns
+}
+
+// advance fetches the shard after r.upcoming.
+func (r *distRun) advance() {
+	var ok bool
+	if r.upcoming, ok = r.next(); !ok {
+		r.upcoming = nil
+	}
+}
+
+// lease is a shard handed to an agent.
+type lease struct {
+	targets []shardTarget
+	agent   string
+	expires time.Time
+	done    bool
+}
+
+// shardDelivery is a result passed from the HTTP handlers to run.
+type shardDelivery struct {
+	targets []shardTarget
+	hosts   []HostResult
+	refused bool
+}
+
+func newCoordinator(token string) *coordinator {
+	return &coordinator{token: token, agents: make(map[string]bool)}
+}
+
+// handler serves the agent API.
+func (c *coordinator) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/lease", c.lease)
+	mux.HandleFunc("POST /v1/leases/{id}/heartbeat", c.heartbeat)
+	mux.HandleFunc("POST /v1/leases/{id}/result", c.result)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(c.token)) != 1 {
+			httpError(w, http.StatusUnauthorized, "bad agent token")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// run distributes p and returns its results as scanPlan.run does. It
+// waits for as long as it takes agents to join and finish; cancelling ctx
+// stops it with the results received so far.
+func (c *coordinator) run(ctx context.Context, p *scanPlan, hooks scanHooks) []HostResult {
+	next, stop := iter.Pull(p.shards())
+	defer stop()
+	r := &distRun{plan: p, next: next, leases: make(map[string]*lease), results: make(chan shardDelivery), stopped: make(chan struct{})}
+	defer close(r.stopped)
+	r.advance()
+	c.mu.Lock()
+	c.cur = r
+	if len(c.agents) == 0 {
+		slog.Info("waiting for agents to join")
+	}
+	c.mu.Unlock()
+
+	open := make(map[string]map[int]int)
+	failed := make(map[string]int)
+	timedOut := make(map[string]bool)
+	dialErrs := make(map[string]*DialErrors)
+	down := make(map[string]bool)
+	calibrations := make(map[string]*Calibration)
+	lookupErrs := make(map[string]string)
+	results := func() []HostResult {
+		hosts := p.results(open, failed, func(h string) bool { return timedOut[h] })
+		for i := range hosts {
+			hosts[i].DialErrors = dialErrs[hosts[i].Host]
+			hosts[i].Down = down[hosts[i].Host]
+			hosts[i].Calibration = calibrations[hosts[i].Host]
+			hosts[i].LookupError = lookupErrs[hosts[i].Host]
+		}
+		return hosts
+	}
+	done := make(chan struct{})
+	go func() {
+		// Lease expiry is checked on every request, but also needs a
+		// clock for when all agents have gone away.
+		t := time.NewTicker(leaseTime / 3)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				c.mu.Lock()
+				c.reap(r)
+				c.mu.Unlock()
+			case <-done:
+				return
+			}
+		}
+	}()
+	defer close(done)
+
+	defer func() {
+		c.mu.Lock()
+		c.cur = nil
+		c.mu.Unlock()
+	}()
+	for !c.finished(r) {
+		var d shardDelivery
+		select {
+		case d = <-r.results:
+		case <-ctx.Done():
+			return results()
+		}
+		for _, h := range d.hosts {
+			for _, pr := range h.Ports {
+				if open[h.Host] == nil {
+					open[h.Host] = make(map[int]int)
+				}
+				if open[h.Host][pr.Port] > 0 {
+					continue // a shard handed out again
+				}
+				if hooks.found != nil {
+					hooks.found(h.Host, pr)
+				}
+				open[h.Host][pr.Port] = max(pr.Attempts, 1)
+			}
+			failed[h.Host] += h.ProbeErrors
+			timedOut[h.Host] = timedOut[h.Host] || h.TimedOut
+			down[h.Host] = down[h.Host] || h.Down
+			if calibrations[h.Host] == nil {
+				// Each shard of a host calibrates it; the first says.
+				calibrations[h.Host] = h.Calibration
+			}
+			if h.LookupError != "" {
+				lookupErrs[h.Host] = h.LookupError
+			}
+			if h.DialErrors != nil {
+				if dialErrs[h.Host] == nil {
+					dialErrs[h.Host] = new(DialErrors)
+				}
+				dialErrs[h.Host].merge(h.DialErrors)
+			}
+		}
+		if d.refused {
+			for _, t := range d.targets {
+				ports, _ := parsePorts(t.Ports, nil)
+				failed[t.Host] += len(ports)
+			}
+		}
+		if hooks.probed != nil {
+			for _, t := range d.targets {
+				ports, _ := parsePorts(t.Ports, nil)
+				for range ports {
+					hooks.probed()
+				}
+			}
+		}
+		c.mu.Lock()
+		r.pending--
+		c.mu.Unlock()
+	}
+	return results()
+}
+
+// finished reports whether every shard of r has a result.
+func (c *coordinator) finished(r *distRun) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return r.upcoming == nil && len(r.retry) == 0 && r.pending == 0
+}
+
+// reap hands out again the shards of agents that stopped sending
+// heartbeats. c.mu must be held.
+func (c *coordinator) reap(r *distRun) {
+	now := time.Now()
+	for id, l := range r.leases {
+		if !l.done && now.After(l.expires) {
+			slog.Warn("agent stopped responding; handing its shard to another agent", "agent", l.agent)
+			c.giveBack(r, id, l)
+		}
+	}
+}
+
+// giveBack puts a leased shard back in the queue. c.mu must be held.
+func (c *coordinator) giveBack(r *distRun, id string, l *lease) {
+	l.done = true
+	r.pending--
+	r.retry = append(r.retry, l.targets)
+	delete(r.leases, id)
+}
+
+func (c *coordinator) lease(w http.ResponseWriter, req *http.Request) {
+	var body struct {
+		Agent string `json:"agent"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, 1<<16)).Decode(&body); err != nil || body.Agent == "" {
+		httpError(w, http.StatusBadRequest, "want {\"agent\": name}")
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.agents[body.Agent] {
+		c.agents[body.Agent] = true
+		slog.Info("agent joined", "agent", body.Agent, "remote", req.RemoteAddr)
+	}
+	r := c.cur
+	if r == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	c.reap(r)
+	var targets []shardTarget
+	switch {
+	case len(r.retry) > 0:
+		targets, r.retry = r.retry[0], r.retry[1:]
+	case r.upcoming != nil:
+		targets = r.upcoming
+		r.advance()
+	}
+	if targets == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	id := newJobID()
+	r.leases[id] = &lease{targets: targets, agent: body.Agent, expires: time.Now().Add(leaseTime)}
+	r.pending++
+	p := r.plan
+	writeJSON(w, http.StatusOK, shard{
+		Lease:         id,
+		Targets:       targets,
+		Workers:       p.workers,
+		Timeout:       Duration(p.timeout),
+		HostTimeout:   Duration(p.hostTimeout),
+		Delay:         Duration(p.delay),
+		Retries:       p.retries,
+		Force:         p.force,
+		PortTimeouts:  formatPortTimeouts(p.portTimeouts),
+		CalibratePort: p.calibratePort,
+		MinTimeout:    Duration(p.minTimeout),
+		MaxTimeout:    Duration(p.maxTimeout),
+	})
+}
+
+func (c *coordinator) heartbeat(w http.ResponseWriter, req *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l := c.activeLease(req.PathValue("id"))
+	if l == nil {
+		// The shard was given to someone else; the agent should drop it.
+		httpError(w, http.StatusGone, "lease expired")
+		return
+	}
+	l.expires = time.Now().Add(leaseTime)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *coordinator) result(w http.ResponseWriter, req *http.Request) {
+	var res shardResult
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, 64<<20)).Decode(&res); err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	id := req.PathValue("id")
+	c.mu.Lock()
+	l := c.activeLease(id)
+	if l == nil {
+		c.mu.Unlock()
+		httpError(w, http.StatusGone, "lease expired")
+		return
+	}
+	r := c.cur
+	if res.Error != "" {
+		slog.Warn("agent could not scan its shard", "agent", l.agent, "err", res.Error)
+		c.giveBack(r, id, l)
+		c.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if res.Refused != "" {
+		slog.Warn("agent refused its shard, whose probes count as failed", "agent", l.agent, "reason", res.Refused)
+	}
+	l.done = true
+	delete(r.leases, id)
+	c.mu.Unlock()
+
+	// run counts the shard as finished once it has merged the result.
+	select {
+	case r.results <- shardDelivery{targets: l.targets, hosts: res.Hosts, refused: res.Refused != ""}:
+		w.WriteHeader(http.StatusNoContent)
+	case <-r.stopped:
+		w.WriteHeader(http.StatusNoContent)
+	case <-req.Context().Done():
+		c.mu.Lock()
+		r.pending--
+		r.retry = append(r.retry, l.targets)
+		c.mu.Unlock()
+	}
+}
+
+// activeLease returns the unfinished lease id of the current scan, or nil.
+// c.mu must be held.
+func (c *coordinator) activeLease(id string) *lease {
+	if c.cur == nil {
+		return nil
+	}
+	l := c.cur.leases[id]
+	if l == nil || l.done {
+		return nil
+	}
+	return l
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestShards(t *testing.T) {
+	targets, _ := parseTargets("10.0.0.1,10.0.0.2")
+	ports := make([]int, 3000)
+	for i := range ports {
+		ports[i] = i + 1
+	}
+	p := &scanPlan{targets: targets, numTargets: 2, ports: ports}
+	var got [][]shardTarget
+	for s := range p.shards() {
+		got = append(got, s)
+	}
+	want := [][]shardTarget{
+		{{"10.0.0.1", "1-3000"}, {"10.0.0.2", "1-1096"}},
+		{{"10.0.0.2", "1097-3000"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("shards = %v, want %v", got, want)
+	}
+}
+
+func newTestCoordinator(t *testing.T, hosts string, ports ...int) (*coordinator, *scanPlan, string) {
+	t.Helper()
+	targets, err := parseTargets(hosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(ports)
+	c := newCoordinator("s3cret")
+	srv := httptest.NewServer(c.handler())
+	t.Cleanup(srv.Close)
+	p := &scanPlan{targets: targets, numTargets: targets.count(), ports: ports, workers: 2, timeout: time.Second, coord: c}
+	return c, p, srv.URL
+}
+
+func TestCoordinatorWithAgent(t *testing.T) {
+	open := localPort(t)
+	_, p, url := newTestCoordinator(t, "127.0.0.1", open, closedPort(t))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a := &scanAgent{base: url, name: "a1", token: "s3cret", client: http.DefaultClient}
+	go a.run(ctx)
+
+	var found []int
+	hosts := p.run(context.Background(), scanHooks{found: func(_ string, r PortResult) { found = append(found, r.Port) }})
+	if len(hosts) != 1 || len(hosts[0].Ports) != 1 || hosts[0].Ports[0].Port != open || len(found) != 1 {
+		t.Errorf("run = %+v, found %v", hosts, found)
+	}
+
+	bad := &scanAgent{base: url, name: "x", token: "guess", client: http.DefaultClient}
+	if err := bad.run(ctx); err != errBadToken {
+		t.Errorf("agent with wrong token: %v", err)
+	}
+}
+
+// agentPoster returns a function that posts to the coordinator at url as
+// an agent would, decoding an OK answer into v, and returns the status.
+func agentPoster(t *testing.T, url string) func(path string, body, v any) int {
+	return func(path string, body, v any) int {
+		t.Helper()
+		b, _ := json.Marshal(body)
+		req, _ := http.NewRequest(http.MethodPost, url+path, bytes.NewReader(b))
+		req.Header.Set("Authorization", "Bearer s3cret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if v != nil && resp.StatusCode == http.StatusOK {
+			json.NewDecoder(resp.Body).Decode(v)
+		}
+		return resp.StatusCode
+	}
+}
+
+func TestCoordinatorReassignsShards(t *testing.T) {
+	c, p, url := newTestCoordinator(t, "10.0.0.1", 22)
+	post := agentPoster(t, url)
+
+	done := make(chan []HostResult)
+	go func() { done <- p.run(context.Background(), scanHooks{}) }()
+
+	var first shard
+	for post("/v1/lease", map[string]string{"agent": "a"}, &first) != http.StatusOK {
+		time.Sleep(10 * time.Millisecond) // until run has started
+	}
+	// Agent a fails to scan it; the shard goes to b.
+	if code := post("/v1/leases/"+first.Lease+"/result", shardResult{Agent: "a", Error: "no route"}, nil); code != http.StatusNoContent {
+		t.Fatalf("error result: %d", code)
+	}
+	var second shard
+	if post("/v1/lease", map[string]string{"agent": "b"}, &second) != http.StatusOK || !reflect.DeepEqual(second.Targets, first.Targets) {
+		t.Fatalf("shard not handed out again: %+v", second)
+	}
+
+	// Agent b goes silent; the shard goes to c.
+	c.mu.Lock()
+	c.cur.leases[second.Lease].expires = time.Now().Add(-time.Second)
+	c.mu.Unlock()
+	var third shard
+	if post("/v1/lease", map[string]string{"agent": "c"}, &third) != http.StatusOK || !reflect.DeepEqual(third.Targets, first.Targets) {
+		t.Fatalf("shard of silent agent not handed out again: %+v", third)
+	}
+	if code := post("/v1/leases/"+second.Lease+"/heartbeat", nil, nil); code != http.StatusGone {
+		t.Errorf("heartbeat on expired lease: %d", code)
+	}
+
+	post("/v1/leases/"+third.Lease+"/result", shardResult{Agent: "c", Hosts: []HostResult{hostWith("10.0.0.1", 22)}}, nil)
+	hosts := <-done
+	if len(hosts) != 1 || len(hosts[0].Ports) != 1 {
+		t.Errorf("run = %+v", hosts)
+	}
+	if code := post("/v1/lease", map[string]string{"agent": "a"}, nil); code != http.StatusNoContent {
+		t.Errorf("lease after the scan: %d", code)
+	}
+}
+
+func TestCoordinatorRecordsRefusedShards(t *testing.T) {
+	_, p, url := newTestCoordinator(t, "10.0.0.1", 22, 80)
+	post := agentPoster(t, url)
+
+	done := make(chan []HostResult)
+	go func() { done <- p.run(context.Background(), scanHooks{}) }()
+
+	var s shard
+	for post("/v1/lease", map[string]string{"agent": "a"}, &s) != http.StatusOK {
+		time.Sleep(10 * time.Millisecond) // until run has started
+	}
+	// A refusal ends the shard: it is not handed to the next agent.
+	if code := post("/v1/leases/"+s.Lease+"/result", shardResult{Agent: "a", Refused: "outside the scope"}, nil); code != http.StatusNoContent {
+		t.Fatalf("refused result: %d", code)
+	}
+	select {
+	case hosts := <-done:
+		if len(hosts) != 1 || len(hosts[0].Ports) != 0 || hosts[0].ProbeErrors != 2 {
+			t.Errorf("run = %+v, want both probes failed", hosts)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the scan did not finish after the refusal")
+	}
+	if code := post("/v1/lease", map[string]string{"agent": "b"}, nil); code != http.StatusNoContent {
+		t.Errorf("lease after the refusal: %d", code)
+	}
+}
@@ -0,0 +1,568 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/netip"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// migrations holds the database schema as numbered SQL files. A file is
+// never changed once released; schema changes get a new file.
+//
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// dbLockKey is the advisory lock that keeps two pscanner processes from
+// migrating the same database at once.
+const dbLockKey = 0x7073636e // "pscn"
+
+// scanDB stores reports in PostgreSQL.
+type scanDB struct {
+	dsn string
+
+	mu   sync.Mutex // a pgx.Conn serves one query at a time
+	conn *pgx.Conn
+}
+
+// openDB connects to dsn and brings the schema up to date.
+func openDB(ctx context.Context, dsn string) (*scanDB, error) {
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		return nil, err
+	}
+	db := &scanDB{dsn: dsn, conn: conn}
+	if err := db.migrate(ctx); err != nil {
+		conn.Close(ctx)
+		return nil, fmt.Errorf("migrating database: %v", err)
+	}
+	return db, nil
+}
+
+func (db *scanDB) Close() error {
+	return db.conn.Close(context.Background())
+}
+
+// connLocked returns the connection, reconnecting if the server went away
+// since the last use; db.mu must be held.
+func (db *scanDB) connLocked(ctx context.Context) (*pgx.Conn, error) {
+	if db.conn.IsClosed() || db.conn.Ping(ctx) != nil {
+		conn, err := pgx.Connect(ctx, db.dsn)
+		if err != nil {
+			return nil, err
+		}
+		db.conn.Close(ctx)
+		db.conn = conn
+	}
+	return db.conn, nil
+}
+
+type migration struct {
+	version int
+	sql     string
+}
+
+// migrationList returns the embedded migrations in version order.
+func migrationList() ([]migration, error) {
+	entries, err := fs.ReadDir(migrations, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	var list []migration
+	for _, e := range entries {
+		prefix, _, _ := strings.Cut(e.Name(), "_")
+		v, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: name must start with a version number", e.Name())
+		}
+		b, err := migrations.ReadFile("migrations/" + e.Name())
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, migration{v, string(b)})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].version < list[j].version })
+	return list, nil
+}
+
+// migrate applies the migrations the database has not seen yet, all in one
+// transaction.
+func (db *scanDB) migrate(ctx context.Context) error {
+	list, err := migrationList()
+	if err != nil {
+		return err
+	}
+	tx, err := db.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", dbLockKey); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    integer PRIMARY KEY,
+		applied_at timestamptz NOT NULL DEFAULT now())`); err != nil {
+		return err
+	}
+	var current int
+	if err := tx.QueryRow(ctx, "SELECT coalesce(max(version), 0) FROM schema_migrations").Scan(&current); err != nil {
+		return err
+	}
+	if newest := list[len(list)-1].version; current > newest {
+		return fmt.Errorf("database schema version %d is newer than this pscanner supports (%d)", current, newest)
+	}
+	for _, m := range list {
+		if m.version <= current {
+			continue
+		}
+		if _, err := tx.Exec(ctx, m.sql); err != nil {
+			return fmt.Errorf("migration %d: %v", m.version, err)
+		}
+		if _, err := tx.Exec(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", m.version); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// assetUpsert records that a scan finished at $5 found a host port open.
+// Reports need not be saved in the order the scans ran, so the first and
+// last sightings each only move outward.
+const assetUpsert = `INSERT INTO assets (address, port, protocol, service, first_seen, last_seen, first_scan, last_scan)
+	VALUES ($1, $2, $3, NULLIF($4, ''), $5, $5, $6, $6)
+	ON CONFLICT (address, port, protocol) DO UPDATE SET
+		service = coalesce(EXCLUDED.service, assets.service),
+		first_seen = least(assets.first_seen, EXCLUDED.first_seen),
+		first_scan = CASE WHEN EXCLUDED.first_seen < assets.first_seen THEN EXCLUDED.first_scan ELSE assets.first_scan END,
+		last_seen = greatest(assets.last_seen, EXCLUDED.last_seen),
+		last_scan = CASE WHEN EXCLUDED.last_seen >= assets.last_seen THEN EXCLUDED.last_scan ELSE assets.last_scan END,
+		scans = assets.scans + 1`
+
+// save stores a report. Scans run from the command line have no ID, so one
+// is made up; it is returned either way.
+func (db *scanDB) save(ctx context.Context, r *Report) (string, error) {
+	id := r.ID
+	if id == "" {
+		id = newJobID()
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	conn, err := db.connLocked(ctx)
+	if err != nil {
+		return "", err
+	}
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(ctx)
+
+	p := r.Parameters
+	tags := r.Tags
+	if tags == nil {
+		tags = map[string]string{}
+	}
+	var scan int64
+	err = tx.QueryRow(ctx, `INSERT INTO scans (scan_id, schedule, started_at, finished_at, canceled,
+			targets, target_count, ports, port_count, workers, timeout_ms, profile, scanner_version,
+			schema_version, host_timeout_ms, delay_ms, proxy, source, prefer, routes, quic, dtls, vpn, udp_probes, ot, ot_safe, containers, tcp_probes, open_instances, endpoints, honeypots, skip_cdn, knock, knock_delay_ms, payloads, scripts, plugins, tags, states, stats, retries, force, port_timeouts, calibrate_port, min_timeout_ms, max_timeout_ms, snmp_harvest, follow, cert_names, vhosts, screenshots, http_paths, safe_checks, severity)
+		VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6, $7, $8, $9, $10, $11, NULLIF($12, ''), $13,
+			$14, $15, $16, NULLIF($17, ''), NULLIF($18, ''), NULLIF($19, ''), $20, $21, $22, $23, $24, $25, $26, $27, $28, $29, $30, $31, $32, NULLIF($33, ''), $34, $35, $36, $37, $38, $39, $40, $41, $42, NULLIF($43, ''), NULLIF($44, 0), NULLIF($45, 0), NULLIF($46, 0), $47, NULLIF($48, 0), $49, NULLIF($50, ''), NULLIF($51, ''), $52, $53, NULLIF($54, ''))
+		RETURNING id`,
+		id, r.Schedule, r.StartedAt, r.FinishedAt, r.Canceled,
+		p.Targets, p.TargetCount, p.Ports, p.PortCount, p.Workers, time.Duration(p.Timeout).Milliseconds(), p.Profile, r.Scanner.Version,
+		r.SchemaVersion, time.Duration(p.HostTimeout).Milliseconds(), time.Duration(p.Delay).Milliseconds(), p.Proxy, p.Source, p.Prefer, p.Routes, p.QUIC, p.DTLS, p.VPN, p.UDPProbes, p.OT, p.OTSafe, p.Containers, p.TCPProbes, p.Instances, p.Endpoints, p.Honeypots, p.SkipCDN, p.Knock, time.Duration(p.KnockDelay).Milliseconds(), p.Payloads, p.Scripts, p.Plugins, tags, p.States, p.Stats, p.Retries, p.Force, p.PortTimeouts, p.CalibratePort, time.Duration(p.MinTimeout).Milliseconds(), time.Duration(p.MaxTimeout).Milliseconds(), p.SNMPHarvest, p.Follow, p.CertNames, p.VHosts, p.Screenshots, p.HTTPPaths, p.SafeChecks, p.Severity,
+	).Scan(&scan)
+	if err != nil {
+		return "", err
+	}
+	open := make(map[string][]PortResult)
+	for _, h := range r.Hosts {
+		open[h.Host] = append(open[h.Host], h.Ports...)
+	}
+	for _, h := range r.Hosts {
+		if len(h.Ports) == 0 && !h.TimedOut && h.Closed == "" && h.Filtered == "" && h.Stats == nil {
+			continue
+		}
+		var host int64
+		if err := tx.QueryRow(ctx, `INSERT INTO hosts (scan, address, family, timed_out, closed_ports, filtered_ports, stats, same_as)
+			VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), $7, $8) RETURNING id`,
+			scan, h.Host, h.Family, h.TimedOut, h.Closed, h.Filtered, h.Stats, h.SameAs).Scan(&host); err != nil {
+			return "", err
+		}
+		b := &pgx.Batch{}
+		for _, pr := range h.Ports {
+			b.Queue("INSERT INTO ports (host, port, protocol, state, attempts, severity) VALUES ($1, $2, $3, $4, NULLIF($5, 0), NULLIF($6, ''))", host, pr.Port, pr.Protocol, pr.State, pr.Attempts, pr.Severity)
+			if name := pr.service(); name != "" {
+				b.Queue("INSERT INTO services (port, protocol, name) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING", pr.Port, pr.Protocol, name)
+			}
+			if !sameAsset(h, pr, open) {
+				b.Queue(assetUpsert, h.Host, pr.Port, pr.Protocol, pr.service(), r.FinishedAt, id)
+			}
+		}
+		if err := tx.SendBatch(ctx, b).Close(); err != nil {
+			return "", err
+		}
+	}
+	return id, tx.Commit(ctx)
+}
+
+// sameAsset says whether port pr of hostname h is open on an address of
+// its SameAs as well, among the open ports of the report by host: the
+// address then stands for both in the assets, which would otherwise count
+// the one service twice.
+func sameAsset(h HostResult, pr PortResult, open map[string][]PortResult) bool {
+	if _, err := netip.ParseAddr(h.Host); err == nil {
+		return false
+	}
+	for _, a := range h.SameAs {
+		for _, other := range open[a] {
+			if other.Port == pr.Port && other.Protocol == pr.Protocol {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// notify stores each finished run. Failures are logged and do not stop the
+// scans.
+func (db *scanDB) notify(run scanRun) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if _, err := db.save(ctx, run.Report); err != nil {
+		slog.Error("storing scan in the database failed", "scan", run.Report.ID, "err", err)
+	}
+}
+
+// historyEntry is one stored scan, as listed by "pscanner history".
+type historyEntry struct {
+	ID         string            `json:"id"`
+	Schedule   string            `json:"schedule,omitempty"`
+	StartedAt  time.Time         `json:"started_at"`
+	FinishedAt time.Time         `json:"finished_at"`
+	Canceled   bool              `json:"canceled,omitempty"`
+	Tags       map[string]string `json:"tags,omitempty"`
+	Targets    []string          `json:"targets"`
+	Ports      string            `json:"ports"`
+	OpenPorts  int               `json:"open_ports"`
+}
+
+// history lists the newest scans, optionally only those that covered host;
+// OpenPorts then counts that host's ports only. Only scans with all of
+// tags are listed.
+func (db *scanDB) history(ctx context.Context, host string, tags map[string]string, limit int) ([]historyEntry, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	conn, err := db.connLocked(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if tags == nil {
+		tags = map[string]string{}
+	}
+	rows, err := conn.Query(ctx, `SELECT s.scan_id, coalesce(s.schedule, ''), s.started_at, s.finished_at, s.canceled,
+			s.tags, s.targets, s.ports,
+			(SELECT count(*) FROM hosts h JOIN ports p ON p.host = h.id
+			 WHERE h.scan = s.id AND ($1 = '' OR h.address = $1))
+		FROM scans s
+		WHERE ($1 = '' OR $1 = ANY (s.targets)
+			OR EXISTS (SELECT 1 FROM hosts h WHERE h.scan = s.id AND h.address = $1))
+			AND s.tags @> $3
+		ORDER BY s.started_at DESC
+		LIMIT $2`, host, limit, tags)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (historyEntry, error) {
+		var e historyEntry
+		err := row.Scan(&e.ID, &e.Schedule, &e.StartedAt, &e.FinishedAt, &e.Canceled, &e.Tags, &e.Targets, &e.Ports, &e.OpenPorts)
+		return e, err
+	})
+}
+
+// portFilter selects the open ports "pscanner query" lists. Zero fields do
+// not filter.
+type portFilter struct {
+	host  string
+	ports []int
+	since time.Time
+	tags  map[string]string // all of them
+	limit int
+}
+
+// portEntry is one open port of one stored scan.
+type portEntry struct {
+	ScanID   string            `json:"scan_id"`
+	Time     time.Time         `json:"time"`
+	Host     string            `json:"host"`
+	Port     int               `json:"port"`
+	Protocol string            `json:"protocol"`
+	Service  string            `json:"service,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+}
+
+// portQuery builds the SQL for f, newest first.
+func portQuery(f portFilter) (string, []any) {
+	var where []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	if f.host != "" {
+		where = append(where, "h.address = "+arg(f.host))
+	}
+	if len(f.ports) > 0 {
+		where = append(where, "p.port = ANY ("+arg(f.ports)+")")
+	}
+	if !f.since.IsZero() {
+		where = append(where, "s.finished_at >= "+arg(f.since))
+	}
+	if len(f.tags) > 0 {
+		where = append(where, "s.tags @> "+arg(f.tags))
+	}
+	q := `SELECT s.scan_id, s.finished_at, h.address, p.port, p.protocol, coalesce(sv.name, ''), s.tags
+	FROM ports p
+	JOIN hosts h ON h.id = p.host
+	JOIN scans s ON s.id = h.scan
+	LEFT JOIN services sv ON sv.port = p.port AND sv.protocol = p.protocol`
+	if len(where) > 0 {
+		q += "\n\tWHERE " + strings.Join(where, " AND ")
+	}
+	q += "\n\tORDER BY s.finished_at DESC, h.address, p.port"
+	if f.limit > 0 {
+		q += "\n\tLIMIT " + arg(f.limit)
+	}
+	return q, args
+}
+
+func (db *scanDB) query(ctx context.Context, f portFilter) ([]portEntry, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	conn, err := db.connLocked(ctx)
+	if err != nil {
+		return nil, err
+	}
+	q, args := portQuery(f)
+	rows, err := conn.Query(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (portEntry, error) {
+		var e portEntry
+		err := row.Scan(&e.ScanID, &e.Time, &e.Host, &e.Port, &e.Protocol, &e.Service, &e.Tags)
+		return e, err
+	})
+}
+
+// assetFilter selects the host ports "pscanner assets" lists. Zero fields
+// do not filter.
+type assetFilter struct {
+	host  string
+	ports []int
+	// newSince keeps the ports first seen since then, and goneSince those
+	// last seen before then.
+	newSince  time.Time
+	goneSince time.Time
+	limit     int
+}
+
+// assetEntry is a host port as first and last seen open.
+type assetEntry struct {
+	Host      string    `json:"host"`
+	Port      int       `json:"port"`
+	Protocol  string    `json:"protocol"`
+	Service   string    `json:"service,omitempty"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	FirstScan string    `json:"first_scan"`
+	LastScan  string    `json:"last_scan"`
+	Scans     int       `json:"scans"`
+}
+
+// assetQuery builds the SQL for f, the newest first seen first.
+func assetQuery(f assetFilter) (string, []any) {
+	var where []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	if f.host != "" {
+		where = append(where, "address = "+arg(f.host))
+	}
+	if len(f.ports) > 0 {
+		where = append(where, "port = ANY ("+arg(f.ports)+")")
+	}
+	if !f.newSince.IsZero() {
+		where = append(where, "first_seen >= "+arg(f.newSince))
+	}
+	if !f.goneSince.IsZero() {
+		where = append(where, "last_seen < "+arg(f.goneSince))
+	}
+	q := `SELECT address, port, protocol, coalesce(service, ''), first_seen, last_seen, first_scan, last_scan, scans
+	FROM assets`
+	if len(where) > 0 {
+		q += "\n\tWHERE " + strings.Join(where, " AND ")
+	}
+	q += "\n\tORDER BY first_seen DESC, address, port"
+	if f.limit > 0 {
+		q += "\n\tLIMIT " + arg(f.limit)
+	}
+	return q, args
+}
+
+func (db *scanDB) assets(ctx context.Context, f assetFilter) ([]assetEntry, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	conn, err := db.connLocked(ctx)
+	if err != nil {
+		return nil, err
+	}
+	q, args := assetQuery(f)
+	rows, err := conn.Query(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (assetEntry, error) {
+		var e assetEntry
+		err := row.Scan(&e.Host, &e.Port, &e.Protocol, &e.Service, &e.FirstSeen, &e.LastSeen, &e.FirstScan, &e.LastScan, &e.Scans)
+		return e, err
+	})
+}
+
+// scan reads back the stored scan id as a report: the hosts with open,
+// closed or filtered ports or a timeout, and the settings the text report
+// shows. The findings of the probes made after the scan are not stored.
+func (db *scanDB) scan(ctx context.Context, id string) (*Report, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	conn, err := db.connLocked(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r := &Report{ID: id}
+	p := &r.Parameters
+	var timeout, hostTimeout, delay int64
+	err = conn.QueryRow(ctx, `SELECT coalesce(schedule, ''), tags, started_at, finished_at, canceled,
+			targets, target_count, ports, port_count, workers, timeout_ms, coalesce(profile, ''), scanner_version,
+			coalesce(schema_version, 0), host_timeout_ms, delay_ms, coalesce(proxy, ''), states, stats, retries
+		FROM scans WHERE scan_id = $1`, id).Scan(&r.Schedule, &r.Tags, &r.StartedAt, &r.FinishedAt, &r.Canceled,
+		&p.Targets, &p.TargetCount, &p.Ports, &p.PortCount, &p.Workers, &timeout, &p.Profile, &r.Scanner.Version,
+		&r.SchemaVersion, &hostTimeout, &delay, &p.Proxy, &p.States, &p.Stats, &p.Retries)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errNoScan
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.Timeout = Duration(time.Duration(timeout) * time.Millisecond)
+	p.HostTimeout = Duration(time.Duration(hostTimeout) * time.Millisecond)
+	p.Delay = Duration(time.Duration(delay) * time.Millisecond)
+
+	rows, err := conn.Query(ctx, `SELECT h.address, h.family, h.timed_out,
+			coalesce(h.closed_ports, ''), coalesce(h.filtered_ports, ''), h.stats, coalesce(p.port, 0), coalesce(p.protocol, ''), coalesce(p.state, ''), coalesce(p.attempts, 0)
+		FROM hosts h
+		JOIN scans s ON s.id = h.scan
+		LEFT JOIN ports p ON p.host = h.id
+		WHERE s.scan_id = $1
+		ORDER BY h.id, p.port, p.protocol`, id)
+	if err != nil {
+		return nil, err
+	}
+	var h HostResult
+	var pr PortResult
+	var stats []byte
+	_, err = pgx.ForEachRow(rows, []any{&h.Host, &h.Family, &h.TimedOut, &h.Closed, &h.Filtered, &stats, &pr.Port, &pr.Protocol, &pr.State, &pr.Attempts}, func() error {
+		if n := len(r.Hosts); n == 0 || r.Hosts[n-1].Host != h.Host || r.Hosts[n-1].Family != h.Family {
+			r.Hosts = append(r.Hosts, HostResult{Host: h.Host, Family: h.Family, TimedOut: h.TimedOut, Closed: h.Closed, Filtered: h.Filtered})
+			if stats != nil {
+				last := &r.Hosts[len(r.Hosts)-1]
+				last.Stats = new(HostStats)
+				if err := json.Unmarshal(stats, last.Stats); err != nil {
+					return err
+				}
+			}
+		}
+		if pr.Port != 0 {
+			last := &r.Hosts[len(r.Hosts)-1]
+			last.Ports = append(last.Ports, pr)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(r.Hosts) == 0 && p.TargetCount == 1 && len(p.Targets) == 1 {
+		// A host without open ports is not stored, but the text report
+		// names the one scanned.
+		r.Hosts = []HostResult{{Host: p.Targets[0]}}
+	}
+	return r, nil
+}
+
+// hostScan is a scan that covered a host, with the TCP ports it probed,
+// as a port list, and those it found open there.
+type hostScan struct {
+	ID        string
+	StartedAt time.Time
+	Probed    string
+	Ports     []PortResult
+}
+
+// hostScans lists the newest limit scans that covered host and ran to the
+// end, oldest first, with the ports each found open on it. A canceled scan
+// says nothing of the ports it did not reach.
+func (db *scanDB) hostScans(ctx context.Context, host string, limit int) ([]hostScan, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	conn, err := db.connLocked(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := conn.Query(ctx, `SELECT s.scan_id, s.started_at, s.ports,
+			coalesce(p.port, 0), coalesce(p.protocol, ''), coalesce(sv.name, '')
+		FROM (SELECT s.id, s.scan_id, s.started_at, s.ports FROM scans s
+			WHERE NOT s.canceled AND ($1 = ANY (s.targets)
+				OR EXISTS (SELECT 1 FROM hosts h WHERE h.scan = s.id AND h.address = $1))
+			ORDER BY s.started_at DESC
+			LIMIT $2) s
+		LEFT JOIN hosts h ON h.scan = s.id AND h.address = $1
+		LEFT JOIN ports p ON p.host = h.id
+		LEFT JOIN services sv ON sv.port = p.port AND sv.protocol = p.protocol
+		ORDER BY s.started_at, p.protocol, p.port`, host, limit)
+	if err != nil {
+		return nil, err
+	}
+	var scans []hostScan
+	var id string
+	var started time.Time
+	var probed string
+	var pr PortResult
+	_, err = pgx.ForEachRow(rows, []any{&id, &started, &probed, &pr.Port, &pr.Protocol, &pr.Service}, func() error {
+		if n := len(scans); n == 0 || scans[n-1].ID != id {
+			scans = append(scans, hostScan{ID: id, StartedAt: started, Probed: probed})
+		}
+		// With --prefer both a host has a row per address family.
+		last := &scans[len(scans)-1]
+		if pr.Port != 0 && !slices.ContainsFunc(last.Ports, func(o PortResult) bool { return o.Port == pr.Port && o.Protocol == pr.Protocol }) {
+			last.Ports = append(last.Ports, pr)
+		}
+		return nil
+	})
+	return scans, err
+}
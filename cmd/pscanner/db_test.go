@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMigrationList(t *testing.T) {
+	list, err := migrationList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) == 0 || list[0].version != 1 {
+		t.Fatalf("migrations = %v, want version 1 first", list)
+	}
+	for i := 1; i < len(list); i++ {
+		if list[i].version <= list[i-1].version {
+			t.Errorf("migration %d follows %d", list[i].version, list[i-1].version)
+		}
+	}
+	if !strings.Contains(list[0].sql, "CREATE TABLE scans") {
+		t.Error("first migration does not create the scans table")
+	}
+}
+
+// Every scan parameter in a report has a column, so that a stored scan says
+// how it was run.
+func TestMigrationsStoreParameters(t *testing.T) {
+	list, err := migrationList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sql strings.Builder
+	for _, m := range list {
+		sql.WriteString(m.sql)
+	}
+	columns := []string{"schema_version"}
+	st := reflect.TypeFor[scanParams]()
+	for i := range st.NumField() {
+		name, _, _ := strings.Cut(st.Field(i).Tag.Get("json"), ",")
+		if st.Field(i).Type == reflect.TypeFor[Duration]() {
+			name += "_ms"
+		}
+		columns = append(columns, name)
+	}
+	for _, c := range columns {
+		if !strings.Contains(sql.String(), " "+c+" ") {
+			t.Errorf("no migration adds a %s column to scans", c)
+		}
+	}
+}
+
+func TestPortQuery(t *testing.T) {
+	since := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	tags := map[string]string{"env": "prod"}
+	q, args := portQuery(portFilter{host: "10.0.0.7", ports: []int{22, 3389}, since: since, tags: tags, limit: 50})
+	for _, want := range []string{"h.address = $1", "p.port = ANY ($2)", "s.finished_at >= $3", "s.tags @> $4", "LIMIT $5"} {
+		if !strings.Contains(q, want) {
+			t.Errorf("query lacks %q:\n%s", want, q)
+		}
+	}
+	if want := []any{"10.0.0.7", []int{22, 3389}, since, tags, 50}; !reflect.DeepEqual(args, want) {
+		t.Errorf("args = %v, want %v", args, want)
+	}
+
+	q, args = portQuery(portFilter{})
+	if strings.Contains(q, "WHERE") || strings.Contains(q, "LIMIT") || len(args) != 0 {
+		t.Errorf("empty filter gave %q with %v", q, args)
+	}
+}
+
+func TestAssetQuery(t *testing.T) {
+	week := time.Date(2026, 10, 8, 0, 0, 0, 0, time.UTC)
+	q, args := assetQuery(assetFilter{ports: []int{443}, newSince: week, goneSince: week, limit: 10})
+	for _, want := range []string{"port = ANY ($1)", "first_seen >= $2", "last_seen < $3", "LIMIT $4"} {
+		if !strings.Contains(q, want) {
+			t.Errorf("query lacks %q:\n%s", want, q)
+		}
+	}
+	if want := []any{[]int{443}, week, week, 10}; !reflect.DeepEqual(args, want) {
+		t.Errorf("args = %v, want %v", args, want)
+	}
+
+	q, args = assetQuery(assetFilter{host: "10.0.0.7"})
+	if !strings.Contains(q, "address = $1") || strings.Contains(q, "LIMIT") || len(args) != 1 {
+		t.Errorf("host filter gave %q with %v", q, args)
+	}
+}
+
+func TestWriteAssets(t *testing.T) {
+	var buf bytes.Buffer
+	seen := time.Date(2026, 10, 14, 2, 0, 0, 0, time.Local)
+	writeAssets(&buf, []assetEntry{
+		{Host: "10.0.0.7", Port: 3389, Protocol: "tcp", Service: "ms-wbt-server", FirstSeen: seen, LastSeen: seen, FirstScan: "9a8b7c6d5e4f3a2b", Scans: 1},
+		{Host: "10.0.0.9", Port: 8080, Protocol: "tcp", FirstSeen: seen.AddDate(0, 0, -3), LastSeen: seen, FirstScan: "5d0e8a1f2b3c4d5e", Scans: 4},
+	})
+	out := buf.String()
+	for _, want := range []string{"FIRST SEEN", "2026-10-14 02:00", "3389/tcp", "ms-wbt-server", "9a8b7c6d5e4f3a2b", "8080/tcp  -"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("assets output lacks %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"7d", now.AddDate(0, 0, -7)},
+		{"36h", now.Add(-36 * time.Hour)},
+		{"2026-10-01", time.Date(2026, 10, 1, 0, 0, 0, 0, time.Local)},
+		{"2026-10-01T08:00:00Z", time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := parseSince(tt.in, now)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("parseSince(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"", "-3d", "week", "2026-13-01"} {
+		if _, err := parseSince(in, now); err == nil {
+			t.Errorf("parseSince(%q) succeeded", in)
+		}
+	}
+}
+
+func TestWriteHistory(t *testing.T) {
+	var buf bytes.Buffer
+	writeHistory(&buf, []historyEntry{
+		{ID: "a1b2c3d4e5f60718", Schedule: "nightly", StartedAt: time.Now(), Targets: []string{"10.0.0.0/24"}, Ports: "1-1024", OpenPorts: 12},
+		{ID: "0011223344556677", StartedAt: time.Now(), Canceled: true, Targets: []string{"example.com"}, Ports: "@web", OpenPorts: 1},
+	})
+	out := buf.String()
+	for _, want := range []string{"SCHEDULE", "nightly", "10.0.0.0/24", "12", "-  ", "1 (canceled)"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("history output lacks %q:\n%s", want, out)
+		}
+	}
+}
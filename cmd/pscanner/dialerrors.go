@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// dialFailure is why a probe's connection failed.
+type dialFailure int
+
+const (
+	dialOK          dialFailure = iota
+	dialTimeout                 // no answer before --timeout
+	dialRefused                 // a TCP reset: the port is closed
+	dialUnreachable             // an ICMP unreachable or prohibited
+	dialPermission              // denied on this machine, such as by its firewall
+	dialFDLimit                 // out of file descriptors
+	dialOther
+)
+
+// String names f as the fields of DialErrors do.
+func (f dialFailure) String() string {
+	switch f {
+	case dialOK:
+		return "ok"
+	case dialTimeout:
+		return "timeout"
+	case dialRefused:
+		return "refused"
+	case dialUnreachable:
+		return "unreachable"
+	case dialPermission:
+		return "permission"
+	case dialFDLimit:
+		return "fd_limit"
+	}
+	return "other"
+}
+
+// classifyDial maps a dial error to a dialFailure. Unreachable goes with
+// EACCES as it does for --infer-firewall: Linux reports an ICMP
+// "administratively prohibited" that way.
+func classifyDial(err error) dialFailure {
+	var ne net.Error
+	switch {
+	case err == nil:
+		return dialOK
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return dialRefused
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH), errors.Is(err, syscall.EACCES):
+		return dialUnreachable
+	case errors.Is(err, syscall.EPERM):
+		return dialPermission
+	case errors.Is(err, syscall.EMFILE), errors.Is(err, syscall.ENFILE):
+		return dialFDLimit
+	case errors.Is(err, os.ErrDeadlineExceeded), errors.As(err, &ne) && ne.Timeout():
+		return dialTimeout
+	}
+	return dialOther
+}
+
+// DialErrors counts the probes of a host whose connection failed, by why.
+// Refused and timed-out probes are what closed and filtered ports give; the
+// others mean the scan could not tell.
+type DialErrors struct {
+	Timeout     int `json:"timeout,omitempty"`
+	Refused     int `json:"refused,omitempty"`
+	Unreachable int `json:"unreachable,omitempty"`
+	Permission  int `json:"permission,omitempty"`
+	FDLimit     int `json:"fd_limit,omitempty"`
+	Other       int `json:"other,omitempty"`
+}
+
+func (e *DialErrors) add(f dialFailure) {
+	switch f {
+	case dialTimeout:
+		e.Timeout++
+	case dialRefused:
+		e.Refused++
+	case dialUnreachable:
+		e.Unreachable++
+	case dialPermission:
+		e.Permission++
+	case dialFDLimit:
+		e.FDLimit++
+	case dialOther:
+		e.Other++
+	}
+}
+
+// merge adds the counts of o to e.
+func (e *DialErrors) merge(o *DialErrors) {
+	e.Timeout += o.Timeout
+	e.Refused += o.Refused
+	e.Unreachable += o.Unreachable
+	e.Permission += o.Permission
+	e.FDLimit += o.FDLimit
+	e.Other += o.Other
+}
+
+// total counts the failed probes.
+func (e *DialErrors) total() int {
+	return e.Timeout + e.Refused + e.Unreachable + e.Permission + e.FDLimit + e.Other
+}
+
+// local counts the probes that failed on this machine, saying nothing of
+// the port.
+func (e *DialErrors) local() int {
+	return e.Permission + e.FDLimit + e.Other
+}
+
+// String lists the counts that are not zero, e.g. "1019 refused, 3 timed
+// out".
+func (e *DialErrors) String() string {
+	var parts []string
+	for _, c := range []struct {
+		n    int
+		what string
+	}{
+		{e.Refused, "refused"},
+		{e.Timeout, "timed out"},
+		{e.Unreachable, "unreachable"},
+		{e.Permission, "permission denied"},
+		{e.FDLimit, "out of file descriptors"},
+		{e.Other, "other errors"},
+	} {
+		if c.n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", c.n, c.what))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// sumDialErrors adds up the dial errors of hosts, or returns nil if none
+// has any.
+func sumDialErrors(hosts []HostResult) *DialErrors {
+	var sum *DialErrors
+	for _, h := range hosts {
+		if h.DialErrors != nil {
+			if sum == nil {
+				sum = new(DialErrors)
+			}
+			sum.merge(h.DialErrors)
+		}
+	}
+	return sum
+}
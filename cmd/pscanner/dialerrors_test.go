@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestClassifyDial(t *testing.T) {
+	opErr := func(err error) error {
+		return &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", err)}
+	}
+	for err, want := range map[error]dialFailure{
+		opErr(syscall.ECONNREFUSED):                        dialRefused,
+		opErr(syscall.EHOSTUNREACH):                        dialUnreachable,
+		opErr(syscall.EACCES):                              dialUnreachable,
+		opErr(syscall.EPERM):                               dialPermission,
+		opErr(syscall.EMFILE):                              dialFDLimit,
+		opErr(os.ErrDeadlineExceeded):                      dialTimeout,
+		fmt.Errorf("lookup example.invalid: no such host"): dialOther,
+		errors.New("something else"):                       dialOther,
+	} {
+		if got := classifyDial(err); got != want {
+			t.Errorf("classifyDial(%v) = %d, want %d", err, got, want)
+		}
+	}
+}
+
+func TestRunCountsDialErrors(t *testing.T) {
+	open, closed := localPort(t), closedPort(t)
+	targets, _ := parseTargets("127.0.0.1")
+	p := &scanPlan{targets: targets, numTargets: 1, ports: []int{open, closed}, workers: 2, timeout: time.Second}
+	hosts := p.run(context.Background(), scanHooks{})
+	if len(hosts[0].Ports) != 1 || hosts[0].DialErrors == nil || *hosts[0].DialErrors != (DialErrors{Refused: 1}) {
+		t.Errorf("host = %+v, dial errors %+v", hosts[0], hosts[0].DialErrors)
+	}
+
+	r := newReport(p, "", time.Now(), hosts, false)
+	r.Hosts = append(r.Hosts, HostResult{Host: "192.0.2.1", DialErrors: &DialErrors{Timeout: 2, FDLimit: 3}})
+	var b strings.Builder
+	if err := writeText(&b, r); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, want := range []string{
+		"Failed connects: 1 refused, 2 timed out, 3 out of file descriptors\n",
+		"Warning: 3 probes failed on this machine",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("text report lacks %q:\n%s", want, out)
+		}
+	}
+}
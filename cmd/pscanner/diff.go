@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+)
+
+var diffDoc = &commandDoc{
+	synopsis: "pscanner diff [--output text|json] old.json new.json",
+	description: `List the ports that opened and closed between two scans.
+
+Both files are JSON reports ("pscanner scan --output json") of scans of
+the same targets, the older one first. A port counts as closed only if the
+newer scan probed it: hosts and ports outside that scan, hosts that hit
+--host-timeout and canceled scans leave the older result alone.
+
+Like diff(1), the command exits with status 0 when nothing changed and 1
+when ports opened or closed, so scripts can act on changes.`,
+	notes: map[string]string{
+		"output": `json writes the IDs of both scans with the "opened" and "closed" ports, as "pscanner serve" answers /api/diff.`,
+	},
+	examples: []string{
+		"pscanner diff monday.json tuesday.json",
+		"pscanner diff --output json before.json after.json | jq '.opened[]'",
+	},
+}
+
+type diffOptions struct {
+	output string
+}
+
+func (o *diffOptions) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	fs.StringVar(&o.output, "output", "text", "Output format: text or json")
+	fs.Usage = func() { writeCommandHelp(fs.Output(), "diff", fs, diffDoc, false) }
+	return fs
+}
+
+// runDiff implements the "diff" command.
+func runDiff(args []string) {
+	var o diffOptions
+	fs := o.flagSet()
+	_ = fs.Parse(args)
+	if o.output != "text" && o.output != "json" {
+		fmt.Fprintf(os.Stderr, "error: unknown --output %q (want text, json)\n", o.output)
+		os.Exit(2)
+	}
+	if fs.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "error: diff needs two results, the older one first")
+		fs.Usage()
+		os.Exit(2)
+	}
+	var reports [2]*Report
+	for i, name := range fs.Args() {
+		r, err := readReport(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s: %v\n", name, err)
+			os.Exit(2)
+		}
+		reports[i] = r
+	}
+
+	d := diffReports(reports[0], reports[1])
+	if o.output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(d)
+	} else {
+		writeDiff(os.Stdout, d)
+	}
+	if len(d.Opened) > 0 || len(d.Closed) > 0 {
+		os.Exit(1)
+	}
+}
+
+// writeDiff prints a diff one port per line, opened ports first.
+func writeDiff(w io.Writer, d reportDiff) {
+	if len(d.Opened) == 0 && len(d.Closed) == 0 {
+		fmt.Fprintln(w, "No ports opened or closed")
+		return
+	}
+	for _, list := range []struct {
+		what  string
+		ports []portChange
+	}{{"opened", d.Opened}, {"closed", d.Closed}} {
+		for _, c := range list.ports {
+			line := fmt.Sprintf("%-6s  %s", list.what, c.addr())
+			if name := serviceName(c.Port); name != "" {
+				line += "  " + name
+			}
+			fmt.Fprintln(w, line)
+		}
+	}
+}
+
+// portChange is one port whose state differs between two reports. With
+// --prefer both, a hostname's ports over IPv4 and IPv6 are told apart by
+// Family.
+type portChange struct {
+	Host     string `json:"host"`
+	Family   string `json:"family,omitempty"`
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+}
+
+// portOf returns the port p of host h.
+func portOf(h HostResult, p PortResult) portChange {
+	return portChange{Host: h.Host, Family: h.Family, Port: p.Port, Protocol: p.Protocol}
+}
+
+// addr writes c as host:port/protocol, with the family it was probed over.
+func (c portChange) addr() string {
+	s := net.JoinHostPort(c.Host, strconv.Itoa(c.Port)) + "/" + c.Protocol
+	if c.Family != "" {
+		s += " over " + c.Family
+	}
+	return s
+}
+
+// reportDiff lists the ports that opened and closed between two scans.
+type reportDiff struct {
+	From   string       `json:"from"`
+	To     string       `json:"to"`
+	Opened []portChange `json:"opened"`
+	Closed []portChange `json:"closed"`
+}
+
+// diffReports compares two reports of the same targets. A port counts as
+// closed only if the newer scan actually probed it: hosts or ports outside
+// its scope, hosts that hit --host-timeout and canceled scans leave earlier
+// results alone, since a missing port there says nothing about its state.
+func diffReports(from, to *Report) reportDiff {
+	d := reportDiff{From: from.ID, To: to.ID, Opened: []portChange{}, Closed: []portChange{}}
+
+	was := openPorts(from)
+	for _, h := range to.Hosts {
+		for _, p := range h.Ports {
+			if c := portOf(h, p); !was[c] {
+				d.Opened = append(d.Opened, c)
+			}
+		}
+	}
+
+	probed := probedBy(to)
+	is := openPorts(to)
+	for _, h := range from.Hosts {
+		for _, p := range h.Ports {
+			c := portOf(h, p)
+			if probed(c) && !is[c] {
+				d.Closed = append(d.Closed, c)
+			}
+		}
+	}
+	return d
+}
+
+// probedBy returns whether the scan of r actually probed a port, over its
+// family, so that a port it does not list as open is known not to be. A
+// host that lists its own probed ports is judged by those, not the scan's.
+// UDP ports only count with --quic, --dtls, --vpn, --udp or --ot, which
+// probe the same UDP ports of every host; --ot only without proxies.
+func probedBy(r *Report) func(portChange) bool {
+	if r.Canceled {
+		return func(portChange) bool { return false }
+	}
+	scope := portSet(r.Parameters.Ports)
+	probed := make(map[string]map[int]bool)
+	for _, h := range r.Hosts {
+		k := hostKey(h.Host, h.Family)
+		switch {
+		case h.TimedOut:
+			probed[k] = nil
+		case h.Probed != "":
+			probed[k] = portSet(h.Probed)
+		default:
+			probed[k] = scope
+		}
+	}
+	return func(c portChange) bool {
+		ports, ok := probed[hostKey(c.Host, c.Family)]
+		if c.Protocol == "udp" {
+			return ok && (r.Parameters.QUIC && c.Port == quicPort ||
+				r.Parameters.DTLS && slices.Contains(dtlsPorts, c.Port) ||
+				r.Parameters.VPN && slices.Contains([]int{ikePort, ikeNATTPort, openVPNPort}, c.Port) ||
+				slices.ContainsFunc(r.Parameters.UDPProbes, func(name string) bool { return udpProbes[name] != nil && udpProbes[name].port == c.Port }) ||
+				r.Parameters.OT && r.Parameters.Proxy == "" && len(r.Parameters.Routes) == 0 && c.Port == bacnetPort)
+		}
+		return ports[c.Port]
+	}
+}
+
+// portSet parses a port list as reports write it. An invalid list is
+// empty, so that none of its ports counts as probed.
+func portSet(list string) map[int]bool {
+	ports, err := parsePorts(list, nil)
+	if err != nil {
+		return nil
+	}
+	set := make(map[int]bool, len(ports))
+	for _, p := range ports {
+		set[p] = true
+	}
+	return set
+}
+
+func openPorts(r *Report) map[portChange]bool {
+	open := make(map[portChange]bool)
+	for _, h := range r.Hosts {
+		for _, p := range h.Ports {
+			open[portOf(h, p)] = true
+		}
+	}
+	return open
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testReport(ports string, hosts ...HostResult) *Report {
+	return &Report{Parameters: scanParams{Ports: ports}, Hosts: hosts}
+}
+
+func hostWith(host string, ports ...int) HostResult {
+	h := HostResult{Host: host, Ports: []PortResult{}}
+	for _, p := range ports {
+		h.Ports = append(h.Ports, PortResult{Port: p, Protocol: "tcp", State: "open"})
+	}
+	return h
+}
+
+func changes(host string, ports ...int) []portChange {
+	c := []portChange{}
+	for _, p := range ports {
+		c = append(c, portChange{Host: host, Port: p, Protocol: "tcp"})
+	}
+	return c
+}
+
+func TestDiffReports(t *testing.T) {
+	timedOut := hostWith("a", 22)
+	timedOut.TimedOut = true
+	canceled := testReport("1-1024", hostWith("a"))
+	canceled.Canceled = true
+	// An import probes each host on its own ports; the scan's are the union.
+	imported := hostWith("a", 22)
+	imported.Probed = "22,80"
+
+	tests := []struct {
+		name           string
+		from, to       *Report
+		opened, closed []portChange
+	}{
+		{"no change", testReport("1-1024", hostWith("a", 22, 80)), testReport("1-1024", hostWith("a", 22, 80)), changes("a"), changes("a")},
+		{"opened and closed", testReport("1-1024", hostWith("a", 22, 80)), testReport("1-1024", hostWith("a", 80, 443)), changes("a", 443), changes("a", 22)},
+		{"port outside new scope", testReport("1-1024", hostWith("a", 22, 8080)), testReport("1-1000", hostWith("a", 22)), changes("a"), changes("a")},
+		{"host not rescanned", testReport("22", hostWith("a", 22), hostWith("b", 22)), testReport("22", hostWith("a", 22)), changes("a"), changes("a")},
+		{"host timed out", testReport("22,80", hostWith("a", 22, 80)), testReport("22,80", timedOut), changes("a"), changes("a")},
+		{"canceled scan", testReport("1-1024", hostWith("a", 22)), canceled, changes("a"), changes("a")},
+		{"port not probed on host", testReport("22,80,443", hostWith("a", 22, 443)), testReport("22,80,443", imported), changes("a"), changes("a")},
+		{"port probed on host", testReport("22,80", hostWith("a", 22, 80)), testReport("22,80,443", imported), changes("a"), changes("a", 80)},
+		{"new host", testReport("22", hostWith("a")), testReport("22", hostWith("a"), hostWith("b", 22)), changes("b", 22), changes("a")},
+	}
+	for _, tt := range tests {
+		d := diffReports(tt.from, tt.to)
+		if !reflect.DeepEqual(d.Opened, tt.opened) || !reflect.DeepEqual(d.Closed, tt.closed) {
+			t.Errorf("%s: opened %v closed %v, want %v and %v", tt.name, d.Opened, d.Closed, tt.opened, tt.closed)
+		}
+	}
+}
+
+// With --prefer both, a hostname's families are diffed apart.
+func TestDiffReportsFamilies(t *testing.T) {
+	over := func(family string, ports ...int) HostResult {
+		h := hostWith("example.com", ports...)
+		h.Family = family
+		return h
+	}
+	from := testReport("22,80", over(familyIPv4, 22), over(familyIPv6, 22, 80))
+	to := testReport("22,80", over(familyIPv4, 22, 80), over(familyIPv6, 22))
+	d := diffReports(from, to)
+	opened := []portChange{{Host: "example.com", Family: familyIPv4, Port: 80, Protocol: "tcp"}}
+	closed := []portChange{{Host: "example.com", Family: familyIPv6, Port: 80, Protocol: "tcp"}}
+	if !reflect.DeepEqual(d.Opened, opened) || !reflect.DeepEqual(d.Closed, closed) {
+		t.Errorf("opened %v closed %v, want %v and %v", d.Opened, d.Closed, opened, closed)
+	}
+
+	var b strings.Builder
+	writeDiff(&b, d)
+	if want := "opened  example.com:80/tcp over ipv4  http\nclosed  example.com:80/tcp over ipv6  http\n"; b.String() != want {
+		t.Errorf("writeDiff = %q, want %q", b.String(), want)
+	}
+}
+
+func TestWriteDiff(t *testing.T) {
+	var b strings.Builder
+	writeDiff(&b, reportDiff{Opened: changes("10.0.0.1", 8080), Closed: changes("2001:db8::1", 22)})
+	want := "opened  10.0.0.1:8080/tcp  http-alt\nclosed  [2001:db8::1]:22/tcp  ssh\n"
+	if b.String() != want {
+		t.Errorf("writeDiff = %q, want %q", b.String(), want)
+	}
+	b.Reset()
+	writeDiff(&b, reportDiff{})
+	if b.String() != "No ports opened or closed\n" {
+		t.Errorf("writeDiff of no changes = %q", b.String())
+	}
+}
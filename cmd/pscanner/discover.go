@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+// discoverOptions holds the flags of the "discover" command.
+type discoverOptions struct {
+	local     bool
+	multicast bool
+	recursive bool
+	iface     string
+	timeout   time.Duration
+	output    string
+	scan      bool
+}
+
+var discoverDoc = &commandDoc{
+	synopsis: "pscanner discover --local|--multicast|--recursive [--interface name] [--timeout 3s] [--output text|json] [--scan [-- scan options]]",
+	description: `Find the devices on the local network and their names.
+
+discover --local asks the local network who is there, the way file
+browsers and media players do, instead of probing addresses:
+
+  mDNS     multicast DNS (Bonjour, Avahi): host names and the services
+           devices announce, such as printers, Chromecasts and AirPlay
+  SSDP     UPnP discovery: routers, TVs, media servers and NAS boxes, with
+           the friendly name from their device description
+  WS-Discovery
+           Windows computers, printers and scanners, and ONVIF cameras
+           and video recorders, with the name ONVIF devices give
+  SLP      the service types of SLP agents, such as printers, storage
+           and management controllers, asked by multicast and by
+           broadcast to each attached network
+  NetBIOS  node status queries to every address of the attached IPv4
+           networks of up to 1024 addresses: Windows and Samba computer
+           names and workgroups
+
+discover --multicast asks with the multicast and broadcast protocols
+alone, leaving out the NetBIOS queries to every address.
+
+discover --recursive maps the attached networks in one command. To the
+multicast protocols (and NetBIOS, with --local) it adds two stages:
+
+  sweep    TCP connects to ports 80, 443, 22 and 445 of every address of
+           the attached networks of up to 1024 addresses; a host that
+           accepts or refuses one is alive
+  ARP      the complete entries of the kernel's ARP table when the
+           discovery ends, which the sweep fills with hosts that answer
+           over ARP alone
+
+It then scans what was found, and the hosts the results point to, as
+"scan --follow 3" does. Only devices inside the scope file, if there is
+one, are scanned.
+
+Devices that answer none of them, or are on another network, stay hidden:
+a port scan is still the way to find those. Answers count only from the
+address that sent them, so one device cannot speak for another.
+
+With --scan the devices found are then port scanned, with the scan options
+given after "--".`,
+	notes: map[string]string{
+		"multicast": `Sends one query per protocol and interface and nothing to individual
+addresses, for networks where a sweep of every address is unwelcome or
+too large for NetBIOS.`,
+		"interface": `Ask only on this interface. By default every interface that is up, is
+not a loopback interface and has an IPv4 address is asked.`,
+		"timeout": `How long to wait for answers. Devices answer mDNS, SSDP and WS-Discovery
+after a random delay of up to a second or two; slow ones need more.`,
+		"recursive": `Implies --scan. The sweep makes up to 4 connects per address, 256 at a
+time; give large networks a longer --timeout, such as 15s. A different
+number of rounds can follow "--", as in -- --follow 1.`,
+		"scan": `The device list goes to standard error, leaving standard output to
+the scan results. Everything after "--" is passed to "pscanner scan", such
+as --top-ports 100 or --output json.`,
+	},
+	examples: []string{
+		"pscanner discover --local",
+		"pscanner discover --local --interface eth0 --output json",
+		"pscanner discover --multicast",
+		"pscanner discover --local --scan -- --top-ports 100",
+		"pscanner discover --recursive --timeout 15s -- --top-ports 100",
+	},
+}
+
+func (o *discoverOptions) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("discover", flag.ExitOnError)
+	fs.BoolVar(&o.local, "local", false, "Discover devices on the local network with mDNS, SSDP, WS-Discovery, SLP and NetBIOS")
+	fs.BoolVar(&o.multicast, "multicast", false, "Discover devices with the multicast and broadcast protocols only: mDNS, SSDP, WS-Discovery and SLP")
+	fs.BoolVar(&o.recursive, "recursive", false, "Sweep the attached networks too, then scan what was found and the hosts the results point to")
+	fs.StringVar(&o.iface, "interface", "", "Only ask on the network interface `name`")
+	fs.DurationVar(&o.timeout, "timeout", 3*time.Second, "How long to wait for answers")
+	fs.StringVar(&o.output, "output", "text", "Output format: text or json")
+	fs.BoolVar(&o.scan, "scan", false, "Port scan the devices found")
+	fs.Usage = func() { writeCommandHelp(fs.Output(), "discover", fs, discoverDoc, false) }
+	return fs
+}
+
+// runDiscover implements the "discover" command.
+func runDiscover(args []string) {
+	var o discoverOptions
+	fs := o.flagSet()
+	_ = fs.Parse(args)
+	if !o.local && !o.multicast && !o.recursive {
+		fmt.Fprintln(os.Stderr, "error: only local network discovery is available; use --local, --multicast or --recursive")
+		os.Exit(2)
+	}
+	o.scan = o.scan || o.recursive
+	if o.output != "text" && o.output != "json" {
+		fmt.Fprintf(os.Stderr, "error: unknown --output %q (want text, json)\n", o.output)
+		os.Exit(2)
+	}
+	if fs.NArg() > 0 && !o.scan {
+		fmt.Fprintf(os.Stderr, "error: unexpected arguments %q (scan options need --scan)\n", fs.Args())
+		os.Exit(2)
+	}
+	if o.timeout <= 0 {
+		fmt.Fprintln(os.Stderr, "error: --timeout must be positive")
+		os.Exit(2)
+	}
+	ifaces, err := lanInterfaces(o.iface)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), o.timeout)
+	defer cancel()
+	asks := multicastAsks
+	if o.local {
+		asks = append(asks, askNetBIOS)
+	}
+	if o.recursive {
+		asks = append(asks, askSweep, askARP)
+	}
+	devices := discoverLAN(ctx, ifaces, asks)
+
+	out := io.Writer(os.Stdout)
+	if o.scan {
+		out = os.Stderr
+	}
+	if o.output == "json" && !o.scan {
+		writeJSONList(out, devices)
+	} else {
+		writeDevices(out, devices)
+	}
+	if !o.scan {
+		return
+	}
+	if len(devices) == 0 {
+		fmt.Fprintln(os.Stderr, "nothing to scan")
+		return
+	}
+	var hosts []string
+	for _, d := range devices {
+		hosts = append(hosts, d.Address)
+	}
+	scanArgs := []string{"--host", strings.Join(hosts, ",")}
+	if o.recursive {
+		if hosts, err = inScope(hosts); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(2)
+		}
+		if len(hosts) == 0 {
+			fmt.Fprintln(os.Stderr, "nothing to scan inside the scope")
+			return
+		}
+		scanArgs = []string{"--host", strings.Join(hosts, ","), "--follow", "3"}
+	}
+	runScan(append(scanArgs, fs.Args()...))
+}
+
+// lanDevice is a device that answered a discovery query.
+type lanDevice struct {
+	Address  string   `json:"address"`
+	Names    []string `json:"names,omitempty"`
+	Services []string `json:"services,omitempty"`
+	Sources  []string `json:"sources"` // mdns, ssdp, wsd, slp, netbios, sweep, arp
+}
+
+// lanFinds collects the answers of the discovery protocols by address.
+type lanFinds struct {
+	mu      sync.Mutex
+	devices map[netip.Addr]*lanDevice
+}
+
+// add records what source learnt about the device at addr.
+func (f *lanFinds) add(addr netip.Addr, source string, names, services []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.devices == nil {
+		f.devices = make(map[netip.Addr]*lanDevice)
+	}
+	d := f.devices[addr]
+	if d == nil {
+		d = &lanDevice{Address: addr.String()}
+		f.devices[addr] = d
+	}
+	for _, n := range names {
+		if n != "" && !slices.Contains(d.Names, n) {
+			d.Names = append(d.Names, n)
+		}
+	}
+	for _, s := range services {
+		if s != "" && !slices.Contains(d.Services, s) {
+			d.Services = append(d.Services, s)
+		}
+	}
+	if !slices.Contains(d.Sources, source) {
+		d.Sources = append(d.Sources, source)
+	}
+}
+
+// list returns the devices in address order.
+func (f *lanFinds) list() []lanDevice {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	addrs := make([]netip.Addr, 0, len(f.devices))
+	for a := range f.devices {
+		addrs = append(addrs, a)
+	}
+	slices.SortFunc(addrs, netip.Addr.Compare)
+	list := make([]lanDevice, len(addrs))
+	for i, a := range addrs {
+		d := *f.devices[a]
+		slices.Sort(d.Sources)
+		list[i] = d
+	}
+	return list
+}
+
+// lanInterface is an interface to ask on, with its IPv4 networks.
+type lanInterface struct {
+	ifi  *net.Interface
+	nets []netip.Prefix
+}
+
+// lanInterfaces returns the named interface, or all that can take part.
+func lanInterfaces(name string) ([]lanInterface, error) {
+	var ifis []net.Interface
+	if name != "" {
+		ifi, err := net.InterfaceByName(name)
+		if err != nil {
+			return nil, fmt.Errorf("no network interface %q", name)
+		}
+		ifis = []net.Interface{*ifi}
+	} else {
+		var err error
+		if ifis, err = net.Interfaces(); err != nil {
+			return nil, err
+		}
+	}
+	var list []lanInterface
+	for i := range ifis {
+		ifi := &ifis[i]
+		if ifi.Flags&net.FlagUp == 0 || ifi.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			continue
+		}
+		li := lanInterface{ifi: ifi}
+		for _, a := range addrs {
+			if n, ok := a.(*net.IPNet); ok {
+				ip, _ := netip.AddrFromSlice(n.IP)
+				bits, _ := n.Mask.Size()
+				if ip = ip.Unmap(); ip.Is4() {
+					li.nets = append(li.nets, netip.PrefixFrom(ip, bits))
+				}
+			}
+		}
+		if len(li.nets) > 0 {
+			list = append(list, li)
+		}
+	}
+	if len(list) == 0 {
+		if name != "" {
+			return nil, fmt.Errorf("interface %s is down or has no IPv4 address", name)
+		}
+		return nil, fmt.Errorf("no network interface with an IPv4 address is up")
+	}
+	return list, nil
+}
+
+// lanAsk asks on one interface with one discovery protocol until ctx is
+// done, recording the answers in f.
+type lanAsk func(ctx context.Context, li lanInterface, f *lanFinds) error
+
+// multicastAsks are the protocols that ask a whole network at once.
+var multicastAsks = []lanAsk{askMDNS, askSSDP, askWSD, askSLP}
+
+// discoverLAN asks on every interface with every protocol of asks until
+// ctx is done.
+func discoverLAN(ctx context.Context, ifaces []lanInterface, asks []lanAsk) []lanDevice {
+	var f lanFinds
+	var wg sync.WaitGroup
+	for _, li := range ifaces {
+		for _, ask := range asks {
+			wg.Go(func() {
+				if err := ask(ctx, li, &f); err != nil {
+					slog.Warn("discovery failed", "interface", li.ifi.Name, "err", err)
+				}
+			})
+		}
+	}
+	wg.Wait()
+	return f.list()
+}
+
+// listenLAN opens a UDP socket whose multicast goes out on li.
+func listenLAN(ctx context.Context, li lanInterface) (*net.UDPConn, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	if li.ifi.Flags&net.FlagMulticast != 0 {
+		if err := ipv4.NewPacketConn(conn).SetMulticastInterface(li.ifi); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	return conn, nil
+}
+
+// readAnswers passes every datagram received on conn to fn until the
+// deadline of conn.
+func readAnswers(conn *net.UDPConn, fn func(from netip.Addr, msg []byte)) {
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			return
+		}
+		fn(from.Addr().Unmap(), buf[:n])
+	}
+}
+
+func writeDevices(w io.Writer, devices []lanDevice) {
+	if len(devices) == 0 {
+		fmt.Fprintln(w, "No devices answered.")
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ADDRESS\tNAME\tSERVICES\tFOUND BY")
+	for _, d := range devices {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", d.Address, orDash(strings.Join(d.Names, ", ")),
+			orDash(truncate(strings.Join(d.Services, ","), 50)), strings.Join(d.Sources, ","))
+	}
+	tw.Flush()
+}
+
+// inScope returns the addresses of hosts inside the scope file, or all of
+// them if there is none.
+func inScope(hosts []string) ([]string, error) {
+	scope, err := loadScope()
+	if err != nil || scope == nil {
+		return hosts, err
+	}
+	var in []string
+	for _, h := range hosts {
+		if a, err := netip.ParseAddr(h); err == nil && scope.contains(netip.PrefixFrom(a, a.BitLen())) {
+			in = append(in, h)
+		}
+	}
+	return in, nil
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsTimeout bounds the lookup of a hostname. It is not part of --timeout,
+// which times the probes alone.
+const dnsTimeout = 5 * time.Second
+
+// dnsEntry is a cached lookup. Entries with an expiry came with a TTL and
+// are kept in the --dns-cache file; the others last for one run.
+type dnsEntry struct {
+	Addrs   []netip.Addr `json:"addrs"`
+	Expires time.Time    `json:"expires"`
+	err     error
+	ready   chan struct{} // closed once the lookup is done
+}
+
+func (e *dnsEntry) expired(now time.Time) bool {
+	return !e.Expires.IsZero() && now.After(e.Expires)
+}
+
+// dnsCache looks up each hostname of a scan once, instead of once for
+// every probe. With a file, lookups that carry a TTL outlive the run.
+type dnsCache struct {
+	file    string
+	lookup  func(ctx context.Context, host string) ([]netip.Addr, time.Duration, error)
+	mu      sync.Mutex
+	entries map[string]*dnsEntry
+}
+
+// newDNSCache returns an empty cache, or with file, the unexpired entries
+// stored there. A file that cannot be read only costs its entries.
+func newDNSCache(file string) *dnsCache {
+	c := &dnsCache{file: file, lookup: lookupSystem, entries: make(map[string]*dnsEntry)}
+	if file == "" {
+		return c
+	}
+	c.lookup = lookupTTL
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return c
+	}
+	var stored map[string]*dnsEntry
+	if err == nil {
+		err = json.Unmarshal(data, &stored)
+	}
+	if err != nil {
+		slog.Warn("ignoring DNS cache", "file", file, "err", err)
+		return c
+	}
+	now := time.Now()
+	for host, e := range stored {
+		if e != nil && len(e.Addrs) > 0 && !e.Expires.IsZero() && !e.expired(now) {
+			e.ready = make(chan struct{})
+			close(e.ready)
+			c.entries[host] = e
+		}
+	}
+	return c
+}
+
+// resolve returns the addresses of host, looking it up on first use and
+// again once its TTL has run out. Concurrent callers share one lookup,
+// and failures are remembered for the run as well.
+func (c *dnsCache) resolve(ctx context.Context, host string) ([]netip.Addr, error) {
+	c.mu.Lock()
+	e := c.entries[host]
+	if e != nil {
+		select {
+		case <-e.ready:
+			if e.expired(time.Now()) {
+				e = nil
+			}
+		default:
+		}
+	}
+	if e == nil {
+		e = &dnsEntry{ready: make(chan struct{})}
+		c.entries[host] = e
+		c.mu.Unlock()
+		ctx, cancel := context.WithTimeout(ctx, dnsTimeout)
+		addrs, ttl, err := c.lookup(ctx, host)
+		cancel()
+		e.Addrs, e.err = addrs, err
+		if err == nil && ttl > 0 {
+			e.Expires = time.Now().Add(ttl)
+		}
+		close(e.ready)
+	} else {
+		c.mu.Unlock()
+	}
+	select {
+	case <-e.ready:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return e.Addrs, e.err
+}
+
+// dnsWorkers bounds the lookups prefetch makes at once.
+const dnsWorkers = 32
+
+// prefetch looks up hosts, at most dnsWorkers at a time, and returns the
+// errors of those that failed. The answers stay in the cache for the
+// probes.
+func (c *dnsCache) prefetch(ctx context.Context, hosts []string) map[string]error {
+	var (
+		mu     sync.Mutex
+		failed = make(map[string]error)
+		wg     sync.WaitGroup
+		sem    = make(chan struct{}, dnsWorkers)
+	)
+	for _, h := range hosts {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return failed
+		}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			if _, err := c.resolve(ctx, h); err != nil && ctx.Err() == nil {
+				mu.Lock()
+				failed[h] = err
+				mu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+	return failed
+}
+
+// save writes the entries that carry a TTL to the cache file.
+func (c *dnsCache) save() error {
+	if c.file == "" {
+		return nil
+	}
+	c.mu.Lock()
+	stored := make(map[string]*dnsEntry)
+	now := time.Now()
+	for host, e := range c.entries {
+		select {
+		case <-e.ready:
+			if e.err == nil && !e.Expires.IsZero() && !e.expired(now) {
+				stored[host] = e
+			}
+		default:
+		}
+	}
+	data, err := json.MarshalIndent(stored, "", "  ")
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := c.file + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.file)
+}
+
+// lookupSystem asks the system resolver, which honours /etc/hosts and the
+// search domains but does not tell the TTL.
+func lookupSystem(ctx context.Context, host string) ([]netip.Addr, time.Duration, error) {
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	for i := range addrs {
+		addrs[i] = addrs[i].Unmap()
+	}
+	return addrs, 0, err
+}
+
+// lookupTTL asks the nameservers of /etc/resolv.conf directly, to learn
+// how long the answer may be kept. Names it cannot look up that way, such
+// as single-label names and those the system answers for before DNS, go
+// to the system resolver and are not kept beyond the run.
+func lookupTTL(ctx context.Context, host string) ([]netip.Addr, time.Duration, error) {
+	servers := resolvConfServers("/etc/resolv.conf")
+	if strings.Contains(strings.TrimSuffix(host, "."), ".") && len(servers) > 0 && !systemFirst(host, "/etc/nsswitch.conf", "/etc/hosts") {
+		if addrs, ttl, err := askNameservers(ctx, servers, host); err == nil {
+			return addrs, ttl, nil
+		}
+	}
+	return lookupSystem(ctx, host)
+}
+
+// systemFirst reports whether the system resolver may answer for host
+// from a source that nsswitch.conf lists before DNS, such as /etc/hosts,
+// so that asking a nameserver directly could give another answer.
+func systemFirst(host, nsswitchFile, hostsFile string) bool {
+	name := strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, source := range nsswitchHosts(nsswitchFile) {
+		switch {
+		case source == "dns":
+			return false
+		case source == "files":
+			if hostsFileHas(hostsFile, name) {
+				return true
+			}
+		case strings.HasPrefix(source, "mdns") && strings.HasSuffix(source, "_minimal"):
+			if strings.HasSuffix(name, ".local") {
+				return true
+			}
+		case source == "myhostname":
+			if hn, _ := os.Hostname(); name == "localhost" || strings.HasSuffix(name, ".localhost") || name == strings.ToLower(hn) {
+				return true
+			}
+		default:
+			// A source we cannot tell the names of, such as
+			// systemd-resolved or LDAP.
+			return true
+		}
+	}
+	// DNS is not consulted at all.
+	return true
+}
+
+// nsswitchHosts returns the sources of the "hosts" line of an
+// nsswitch.conf file, without their [action] items. Without one, the
+// system looks in /etc/hosts, then DNS.
+func nsswitchHosts(name string) []string {
+	sources := []string{"files", "dns"}
+	f, err := os.Open(name)
+	if err != nil {
+		return sources
+	}
+	defer f.Close()
+	for sc := bufio.NewScanner(f); sc.Scan(); {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		db, list, ok := strings.Cut(line, ":")
+		if !ok || strings.TrimSpace(db) != "hosts" {
+			continue
+		}
+		sources = nil
+		inAction := false
+		for _, field := range strings.Fields(list) {
+			switch {
+			case strings.HasPrefix(field, "["), inAction:
+				inAction = !strings.HasSuffix(field, "]")
+			default:
+				sources = append(sources, field)
+			}
+		}
+	}
+	return sources
+}
+
+// hostsFileHas reports whether a hosts file lists the lower-case name.
+func hostsFileHas(file, name string) bool {
+	f, err := os.Open(file)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	for sc := bufio.NewScanner(f); sc.Scan(); {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		fields := strings.Fields(line)
+		for i := 1; i < len(fields); i++ {
+			if strings.ToLower(strings.TrimSuffix(fields[i], ".")) == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// lookupHost resolves host through the cache, in the form of
+// net.LookupHost, so that checks of a scan's targets see the addresses
+// its probes will dial.
+func (c *dnsCache) lookupHost(host string) ([]string, error) {
+	addrs, err := c.resolve(context.Background(), host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]string, len(addrs))
+	for i, a := range addrs {
+		ips[i] = a.String()
+	}
+	return ips, nil
+}
+
+// resolvConfServers returns the nameservers listed in a resolv.conf file.
+func resolvConfServers(name string) []netip.Addr {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var servers []netip.Addr
+	for sc := bufio.NewScanner(f); sc.Scan(); {
+		fields := strings.Fields(sc.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			// A link-local server keeps its zone, as in fe80::1%eth0.
+			if a, err := netip.ParseAddr(fields[1]); err == nil {
+				servers = append(servers, a)
+			}
+		}
+	}
+	return servers
+}
+
+// askNameservers looks up the AAAA and A records of host, IPv6 first as
+// RFC 6724 orders them on a dual-stack host. The TTL is the smallest of
+// the records in the answers, CNAMEs included.
+func askNameservers(ctx context.Context, servers []netip.Addr, host string) ([]netip.Addr, time.Duration, error) {
+	name, err := dnsmessage.NewName(strings.TrimSuffix(host, ".") + ".")
+	if err != nil {
+		return nil, 0, err
+	}
+	var addrs []netip.Addr
+	ttl := uint32(math.MaxUint32)
+	for _, typ := range []dnsmessage.Type{dnsmessage.TypeAAAA, dnsmessage.TypeA} {
+		var answers []dnsmessage.Resource
+		for _, s := range servers {
+			if answers, err = askNameserver(ctx, s, name, typ); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, rr := range answers {
+			ttl = min(ttl, rr.Header.TTL)
+			switch b := rr.Body.(type) {
+			case *dnsmessage.AAAAResource:
+				addrs = append(addrs, netip.AddrFrom16(b.AAAA).Unmap())
+			case *dnsmessage.AResource:
+				addrs = append(addrs, netip.AddrFrom4(b.A))
+			}
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, 0, fmt.Errorf("no addresses for %s", host)
+	}
+	return addrs, time.Duration(ttl) * time.Second, nil
+}
+
+// askNameserver sends one query over UDP and returns the answer section.
+func askNameserver(ctx context.Context, server netip.Addr, name dnsmessage.Name, typ dnsmessage.Type) ([]dnsmessage.Resource, error) {
+	id := uint16(rand.Uint32())
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true})
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(dnsmessage.Question{Name: name, Type: typ, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	query, err := b.Finish()
+	if err != nil {
+		return nil, err
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", netip.AddrPortFrom(server, 53).String())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		var p dnsmessage.Parser
+		h, err := p.Start(buf[:n])
+		if err != nil || h.ID != id || !h.Response {
+			continue // not the answer to this query
+		}
+		if h.Truncated {
+			return nil, errors.New("truncated answer")
+		}
+		if h.RCode != dnsmessage.RCodeSuccess {
+			return nil, fmt.Errorf("nameserver answered %v", h.RCode)
+		}
+		if err := p.SkipAllQuestions(); err != nil {
+			return nil, err
+		}
+		return p.AllAnswers()
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestDNSCacheResolve(t *testing.T) {
+	c := newDNSCache("")
+	var lookups atomic.Int32
+	ttl := time.Hour
+	c.lookup = func(_ context.Context, host string) ([]netip.Addr, time.Duration, error) {
+		lookups.Add(1)
+		time.Sleep(10 * time.Millisecond)
+		return []netip.Addr{netip.MustParseAddr("192.0.2.1")}, ttl, nil
+	}
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() {
+			if addrs, err := c.resolve(context.Background(), "a.example"); err != nil || len(addrs) != 1 {
+				t.Errorf("resolve = %v, %v", addrs, err)
+			}
+		})
+	}
+	wg.Wait()
+	if n := lookups.Load(); n != 1 {
+		t.Errorf("%d lookups for one name, want 1", n)
+	}
+
+	// An answer whose TTL ran out is looked up again.
+	c.entries["a.example"].Expires = time.Now().Add(-time.Second)
+	c.resolve(context.Background(), "a.example")
+	if n := lookups.Load(); n != 2 {
+		t.Errorf("%d lookups after expiry, want 2", n)
+	}
+}
+
+func TestDNSCacheFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "dns.json")
+	c := newDNSCache(file)
+	c.lookup = func(_ context.Context, host string) ([]netip.Addr, time.Duration, error) {
+		switch host {
+		case "kept.example":
+			return []netip.Addr{netip.MustParseAddr("2001:db8::1"), netip.MustParseAddr("192.0.2.1")}, time.Hour, nil
+		case "hosts-file":
+			return []netip.Addr{netip.MustParseAddr("10.0.0.1")}, 0, nil // no TTL
+		}
+		return nil, 0, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	for _, h := range []string{"kept.example", "hosts-file", "missing.example"} {
+		c.resolve(context.Background(), h)
+	}
+	if err := c.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	again := newDNSCache(file)
+	again.lookup = func(context.Context, string) ([]netip.Addr, time.Duration, error) {
+		t.Error("looked up a name from the cache file")
+		return nil, 0, nil
+	}
+	addrs, err := again.resolve(context.Background(), "kept.example")
+	if err != nil || len(addrs) != 2 || addrs[0] != netip.MustParseAddr("2001:db8::1") {
+		t.Errorf("resolve from file = %v, %v", addrs, err)
+	}
+	if len(again.entries) != 1 {
+		t.Errorf("cache file holds %d names, want only the one with a TTL", len(again.entries))
+	}
+}
+
+func TestResolvConfServers(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "resolv.conf")
+	os.WriteFile(name, []byte("# comment\nsearch corp.example\nnameserver 10.0.0.53\nnameserver fe80::1%eth0\nnameserver 2001:db8::53\noptions ndots:2\n"), 0o644)
+	got := resolvConfServers(name)
+	want := []netip.Addr{netip.MustParseAddr("10.0.0.53"), netip.MustParseAddr("fe80::1%eth0"), netip.MustParseAddr("2001:db8::53")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("resolvConfServers = %v, want %v", got, want)
+	}
+}
+
+func TestSystemFirst(t *testing.T) {
+	dir := t.TempDir()
+	hosts := filepath.Join(dir, "hosts")
+	os.WriteFile(hosts, []byte("127.0.0.1 localhost\n10.0.0.9  build.corp.example  build # CI\n# 10.0.0.8 old.corp.example\n"), 0o644)
+	n := 0
+	nsswitch := func(line string) string {
+		n++
+		name := filepath.Join(dir, fmt.Sprintf("nsswitch%d.conf", n))
+		os.WriteFile(name, []byte("passwd: files\n"+line+"\n"), 0o644)
+		return name
+	}
+	tests := []struct {
+		nsswitch, host string
+		want           bool
+	}{
+		{filepath.Join(dir, "none"), "build.corp.example", true},
+		{filepath.Join(dir, "none"), "www.example.com", false},
+		{filepath.Join(dir, "none"), "old.corp.example", false},
+		{nsswitch("hosts: files dns"), "Build.Corp.Example.", true},
+		{nsswitch("hosts: dns files"), "build.corp.example", false},
+		{nsswitch("hosts: files mdns4_minimal [NOTFOUND=return] dns myhostname"), "printer.local", true},
+		{nsswitch("hosts: files mdns4_minimal [NOTFOUND=return] dns myhostname"), "www.example.com", false},
+		{nsswitch("hosts: files myhostname resolve [!UNAVAIL=return] dns"), "www.example.com", true},
+		{nsswitch("hosts: files"), "www.example.com", true},
+	}
+	for _, tt := range tests {
+		if got := systemFirst(tt.host, tt.nsswitch, hosts); got != tt.want {
+			t.Errorf("systemFirst(%q) with %s = %v, want %v", tt.host, tt.nsswitch, got, tt.want)
+		}
+	}
+	if got := nsswitchHosts(nsswitch("hosts: files [NOTFOUND = return] dns")); !reflect.DeepEqual(got, []string{"files", "dns"}) {
+		t.Errorf("nsswitchHosts = %q", got)
+	}
+}
+
+// Checks made before a scan see the addresses its probes will dial.
+func TestDNSCacheLookupHost(t *testing.T) {
+	c := newDNSCache("")
+	var lookups atomic.Int32
+	c.lookup = func(context.Context, string) ([]netip.Addr, time.Duration, error) {
+		lookups.Add(1)
+		return []netip.Addr{netip.MustParseAddr("10.0.0.7")}, time.Hour, nil
+	}
+	scope := &scanScope{path: "scope.yaml", allow: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/24")}}
+	targets, _ := parseTargets("app.corp.example")
+	if err := scope.check(targets, false, c.lookupHost); err != nil {
+		t.Fatal(err)
+	}
+	if addrs, err := c.resolve(context.Background(), "app.corp.example"); err != nil || len(addrs) != 1 || lookups.Load() != 1 {
+		t.Errorf("resolve after the check = %v, %v after %d lookups, want the checked address", addrs, err, lookups.Load())
+	}
+}
+
+func TestDNSCachePrefetch(t *testing.T) {
+	c := newDNSCache("")
+	var (
+		mu             sync.Mutex
+		inFlight, most int
+	)
+	c.lookup = func(_ context.Context, host string) ([]netip.Addr, time.Duration, error) {
+		mu.Lock()
+		inFlight++
+		most = max(most, inFlight)
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+		time.Sleep(10 * time.Millisecond)
+		if host == "h7.example" {
+			return nil, 0, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		}
+		return []netip.Addr{netip.MustParseAddr("192.0.2.1")}, 0, nil
+	}
+	var hosts []string
+	for i := range 100 {
+		hosts = append(hosts, fmt.Sprintf("h%d.example", i))
+	}
+	failed := c.prefetch(context.Background(), hosts)
+	if len(failed) != 1 || failed["h7.example"] == nil {
+		t.Errorf("failed = %v, want h7.example only", failed)
+	}
+	if most < 2 || most > dnsWorkers {
+		t.Errorf("%d lookups at once, want 2 to %d", most, dnsWorkers)
+	}
+	if len(c.entries) != len(hosts) {
+		t.Errorf("%d names cached, want %d", len(c.entries), len(hosts))
+	}
+}
+
+// Hostnames that do not resolve are reported and not probed.
+func TestRunSkipsUnresolvedHosts(t *testing.T) {
+	port := localPort(t)
+	dns := newDNSCache("")
+	dns.lookup = func(_ context.Context, host string) ([]netip.Addr, time.Duration, error) {
+		if host == "gone.example" {
+			return nil, 0, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		}
+		return []netip.Addr{netip.MustParseAddr("127.0.0.1")}, 0, nil
+	}
+	targets, _ := parseTargets("gone.example,app.example")
+	var probed atomic.Int32
+	p := &scanPlan{targets: targets, numTargets: 2, ports: []int{port}, workers: 2, timeout: time.Second, dns: dns}
+	hosts := p.run(context.Background(), scanHooks{probed: func() { probed.Add(1) }})
+	if hosts[0].LookupError == "" || hosts[0].DialErrors != nil || len(hosts[0].Ports) != 0 {
+		t.Errorf("unresolved host = %+v", hosts[0])
+	}
+	if hosts[1].LookupError != "" || len(hosts[1].Ports) != 1 {
+		t.Errorf("resolved host = %+v", hosts[1])
+	}
+	if n := probed.Load(); n != 2 {
+		t.Errorf("progress counted %d probes, want 2", n)
+	}
+}
+
+func TestAskNameservers(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:53")
+	if err != nil {
+		t.Skipf("cannot listen on port 53: %v", err)
+	}
+	defer pc.Close()
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var p dnsmessage.Parser
+			h, _ := p.Start(buf[:n])
+			q, _ := p.Question()
+			b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: h.ID, Response: true})
+			b.StartQuestions()
+			b.Question(q)
+			b.StartAnswers()
+			if q.Type == dnsmessage.TypeA {
+				target := dnsmessage.MustNewName("web.example.")
+				b.CNAMEResource(dnsmessage.ResourceHeader{Name: q.Name, Class: q.Class, TTL: 60}, dnsmessage.CNAMEResource{CNAME: target})
+				b.AResource(dnsmessage.ResourceHeader{Name: target, Class: q.Class, TTL: 300}, dnsmessage.AResource{A: [4]byte{192, 0, 2, 7}})
+			}
+			msg, _ := b.Finish()
+			pc.WriteTo(msg, from)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	addrs, ttl, err := askNameservers(ctx, []netip.Addr{netip.MustParseAddr("127.0.0.1")}, "www.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(addrs, []netip.Addr{netip.MustParseAddr("192.0.2.7")}) || ttl != time.Minute {
+		t.Errorf("askNameservers = %v, %v; want 192.0.2.7 for 1m0s", addrs, ttl)
+	}
+}
+
+func TestProbeFallsBackToOtherFamily(t *testing.T) {
+	port := localPort(t) // 127.0.0.1 only
+	dns := newDNSCache("")
+	dns.lookup = func(context.Context, string) ([]netip.Addr, time.Duration, error) {
+		// ::1 refuses or is unreachable; 127.0.0.1 answers.
+		return []netip.Addr{netip.MustParseAddr("::1"), netip.MustParseAddr("127.0.0.1")}, 0, nil
+	}
+	p := &scanPlan{timeout: time.Second}
+	conn, err := p.probe(context.Background(), dns, job{host: "dual.example", port: port})
+	if err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	conn.Close()
+	if _, err := p.probe(context.Background(), dns, job{host: "dual.example", port: port, family: familyIPv6}); err == nil {
+		t.Error("probe over IPv6 reached the IPv4 listener")
+	}
+}
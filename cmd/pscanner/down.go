@@ -0,0 +1,70 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+)
+
+// downProbes is how many probes of a host must all time out, before any
+// is answered, for the host to count as down.
+const downProbes = 100
+
+// downGuard notices hosts that appear down or fully filtered: those whose
+// first downProbes probes all timed out. Unless --force is given, the rest
+// of their ports are skipped.
+type downGuard struct {
+	force    bool
+	mu       sync.Mutex
+	timeouts map[string]int  // by host key, until the host answers
+	answered map[string]bool // hosts that answered a probe
+	down     map[string]bool
+}
+
+func newDownGuard(force bool) *downGuard {
+	return &downGuard{force: force, timeouts: make(map[string]int), answered: make(map[string]bool), down: make(map[string]bool)}
+}
+
+// allow reports whether the host of key may still be probed.
+func (g *downGuard) allow(key string) bool {
+	if g.force {
+		return true
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return !g.down[key]
+}
+
+// record notes how a probe of j ended, warning once when its host turns
+// out to be down.
+func (g *downGuard) record(j job, f dialFailure) {
+	k := j.key()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	switch {
+	case g.answered[k] || g.down[k]:
+		return
+	case f == dialOK, f == dialRefused, f == dialUnreachable:
+		g.answered[k] = true
+		delete(g.timeouts, k)
+		return
+	case f != dialTimeout:
+		return
+	}
+	if g.timeouts[k]++; g.timeouts[k] < downProbes {
+		return
+	}
+	g.down[k] = true
+	delete(g.timeouts, k)
+	if g.force {
+		slog.Warn("host appears down or fully filtered; consider an ICMP check before scanning it", "host", j.host, "family", j.family, "timeouts", downProbes)
+	} else {
+		slog.Warn("host appears down or fully filtered; skipping its other ports (--force probes them, as nmap -Pn would)", "host", j.host, "family", j.family, "timeouts", downProbes)
+	}
+}
+
+// isDown reports whether the host of key appeared down.
+func (g *downGuard) isDown(key string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.down[key]
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunSkipsDownHosts(t *testing.T) {
+	targets, _ := parseTargets("192.0.2.1")
+	ports, _ := parsePorts("1-150", nil)
+	for _, force := range []bool{false, true} {
+		d := &lossyDialer{drops: 1000, dials: make(map[string]int)}
+		p := &scanPlan{targets: targets, numTargets: 1, ports: ports, workers: 1, timeout: time.Second, proxy: d, force: force}
+		probed := 0
+		hosts := p.run(context.Background(), scanHooks{probed: func() { probed++ }})
+		if !hosts[0].Down {
+			t.Errorf("force=%v: host not down: %+v", force, hosts[0])
+		}
+		want := downProbes
+		if force {
+			want = len(ports)
+		}
+		if len(d.dials) != want || probed != len(ports) {
+			t.Errorf("force=%v: %d ports dialed, want %d; %d probed", force, len(d.dials), want, probed)
+		}
+
+		var b strings.Builder
+		if err := writeText(&b, newReport(p, "", time.Now(), hosts, false)); err != nil {
+			t.Fatal(err)
+		}
+		if out := b.String(); !strings.Contains(out, "Host appears down or fully filtered") || strings.Contains(out, "were skipped") == force {
+			t.Errorf("force=%v: text report:\n%s", force, out)
+		}
+	}
+}
+
+func TestDownGuardNeedsSilence(t *testing.T) {
+	g := newDownGuard(false)
+	j := job{host: "192.0.2.1", port: 1}
+	g.record(j, dialRefused)
+	for range downProbes {
+		g.record(j, dialTimeout)
+	}
+	if g.isDown(j.key()) || !g.allow(j.key()) {
+		t.Error("a host that answered once counts as down")
+	}
+
+	j.host = "192.0.2.2"
+	for range downProbes - 1 {
+		g.record(j, dialTimeout)
+	}
+	g.record(j, dialFDLimit) // says nothing of the host
+	if g.isDown(j.key()) {
+		t.Error("down before downProbes timeouts")
+	}
+	g.record(j, dialTimeout)
+	if !g.isDown(j.key()) || g.allow(j.key()) {
+		t.Error("host not down after downProbes timeouts")
+	}
+}
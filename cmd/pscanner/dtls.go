@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DTLSInfo is what --dtls found out about a UDP port that speaks DTLS.
+type DTLSInfo struct {
+	Port int `json:"port"`
+	// Version is the DTLS version of the server's answer, such as "1.2".
+	Version string `json:"version"`
+	// Cipher is the cipher suite the server picked.
+	Cipher string `json:"cipher,omitempty"`
+	// Certificate is the subject of the server's certificate.
+	Certificate string `json:"certificate,omitempty"`
+	// Alert is the alert the server ended the handshake with, such as
+	// "handshake failure" when it shares no cipher suite with the probe.
+	Alert string `json:"alert,omitempty"`
+}
+
+func (d DTLSInfo) String() string {
+	s := fmt.Sprintf("udp/%d DTLS %s", d.Port, d.Version)
+	if d.Cipher != "" {
+		s += ", " + d.Cipher
+	}
+	if d.Certificate != "" {
+		s += ", certificate " + d.Certificate
+	}
+	if d.Alert != "" {
+		s += " (alert: " + d.Alert + ")"
+	}
+	return s
+}
+
+// dtlsPorts are the UDP ports --dtls probes: VPN gateways (Cisco
+// AnyConnect, OpenConnect, Fortinet and Citrix on 443, Remote Desktop
+// Gateway on 3391), CAPWAP wireless controllers (5246), TURN servers that
+// relay WebRTC (5349) and CoAP over DTLS (5684). Tests point it at their
+// own server.
+var dtlsPorts = []int{443, 3391, 5246, 5349, 5684}
+
+// DTLS record content types and handshake message types (RFC 6347).
+const (
+	dtlsAlert     = 21
+	dtlsHandshake = 22
+
+	dtlsClientHello        = 1
+	dtlsServerHello        = 2
+	dtlsHelloVerifyRequest = 3
+	dtlsCertificate        = 11
+	dtlsServerHelloDone    = 14
+)
+
+// dtlsCipherSuites are the cipher suites the ClientHello offers: those of
+// TLS 1.2 that DTLS servers pick, and the CCM and PSK ones of CoAP (RFC
+// 7252 section 9.1.3).
+var dtlsCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+	0xc0ac, 0xc0ae, 0xc0a8, 0x00ae,
+}
+
+// dtlsCipherNames names the cipher suites crypto/tls does not know.
+var dtlsCipherNames = map[uint16]string{
+	0xc0ac: "TLS_ECDHE_ECDSA_WITH_AES_128_CCM",
+	0xc0ae: "TLS_ECDHE_ECDSA_WITH_AES_128_CCM_8",
+	0xc0a8: "TLS_PSK_WITH_AES_128_CCM_8",
+	0x00ae: "TLS_PSK_WITH_AES_128_CBC_SHA256",
+}
+
+// probeDTLS sends a DTLS ClientHello to the DTLS ports of every host and
+// records the ports that answer, as open UDP ports. It waits up to
+// --timeout for each answer, and a port that does not answer is left out:
+// UDP gives no way to tell a filtered port from one nothing listens on.
+func (p *scanPlan) probeDTLS(ctx context.Context, hosts []HostResult) {
+	if !p.dtls {
+		return
+	}
+	dns := newDNSCache(p.dnsCache)
+	sem := make(chan struct{}, quicParallel)
+	var wg sync.WaitGroup
+	for i := range hosts {
+		h := &hosts[i]
+		sem <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			addr, serverName, err := udpAddr(ctx, dns, h.Host, h.Family)
+			if err != nil {
+				slog.Warn("dtls probe failed", "host", h.Host, "err", err)
+				return
+			}
+			found := make([]*DTLSInfo, len(dtlsPorts))
+			var ports sync.WaitGroup
+			for j, port := range dtlsPorts {
+				ports.Go(func() {
+					info, err := p.dtlsProbe(ctx, addr, serverName, port)
+					if err != nil {
+						slog.Warn("dtls probe failed", "host", h.Host, "port", port, "err", err)
+					}
+					found[j] = info
+				})
+			}
+			ports.Wait()
+			for _, info := range found {
+				if info != nil {
+					h.DTLS = append(h.DTLS, *info)
+					addUDPPort(h, info.Port, "dtls")
+				}
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// dtlsProbe sends a ClientHello to port of addr and returns what the
+// server's answer shows, or nil when nothing answers. A HelloVerifyRequest,
+// which servers send to check the client's address, gets the ClientHello
+// again with its cookie. The probe stops at the ServerHelloDone and leaves
+// the handshake unfinished.
+func (p *scanPlan) dtlsProbe(ctx context.Context, addr netip.Addr, serverName string, port int) (*DTLSInfo, error) {
+	conn, err := p.dialUDP(addr, port)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	defer context.AfterFunc(ctx, func() { conn.Close() })()
+
+	random := make([]byte, 32)
+	rand.Read(random)
+	if _, err := conn.Write(dtlsClientHelloRecord(random, nil, serverName, 0)); err != nil {
+		return nil, err
+	}
+	var info *DTLSInfo
+	verified := false
+	messages := make(map[uint16]*dtlsMessage)
+	buf := make([]byte, 65536)
+	deadline := time.Now().Add(p.timeout)
+	for {
+		conn.SetReadDeadline(deadline)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return info, nil // what was learned before the server went quiet
+		}
+		for _, rec := range parseDTLSRecords(buf[:n]) {
+			if rec.epoch != 0 {
+				continue // encrypted, so not for this probe
+			}
+			if info == nil {
+				info = &DTLSInfo{Port: port, Version: dtlsVersionName(rec.version)}
+			}
+			if rec.typ == dtlsAlert {
+				if len(rec.body) == 2 {
+					info.Alert = strings.TrimPrefix(tls.AlertError(rec.body[1]).Error(), "tls: ")
+				}
+				return info, nil
+			}
+			for _, m := range parseDTLSHandshake(rec.body, messages) {
+				switch b := m.body; m.typ {
+				case dtlsHelloVerifyRequest:
+					if verified || len(b) < 3 || len(b) < 3+int(b[2]) {
+						continue
+					}
+					verified = true
+					deadline = time.Now().Add(p.timeout)
+					if _, err := conn.Write(dtlsClientHelloRecord(random, b[3:3+int(b[2])], serverName, 1)); err != nil {
+						return info, err
+					}
+				case dtlsServerHello:
+					// version, random, session ID, cipher suite
+					if len(b) < 35 || len(b) < 35+int(b[34])+2 {
+						continue
+					}
+					info.Version = dtlsVersionName(binary.BigEndian.Uint16(b))
+					info.Cipher = dtlsCipherName(binary.BigEndian.Uint16(b[35+int(b[34]):]))
+				case dtlsCertificate:
+					info.Certificate = dtlsCertificateSubject(b)
+				case dtlsServerHelloDone:
+					return info, nil
+				}
+			}
+		}
+	}
+}
+
+// dtlsClientHelloRecord builds a record with a DTLS 1.2 ClientHello, sent
+// again with the server's cookie as the second message of the handshake.
+func dtlsClientHelloRecord(random, cookie []byte, serverName string, seq uint16) []byte {
+	body := []byte{0xfe, 0xfd}
+	body = append(body, random...)
+	body = append(body, 0) // no session ID
+	body = append(body, byte(len(cookie)))
+	body = append(body, cookie...)
+	body = binary.BigEndian.AppendUint16(body, uint16(2*len(dtlsCipherSuites)))
+	for _, c := range dtlsCipherSuites {
+		body = binary.BigEndian.AppendUint16(body, c)
+	}
+	body = append(body, 1, 0) // null compression
+
+	var ext []byte
+	extension := func(typ uint16, data []byte) {
+		ext = binary.BigEndian.AppendUint16(ext, typ)
+		ext = binary.BigEndian.AppendUint16(ext, uint16(len(data)))
+		ext = append(ext, data...)
+	}
+	if serverName != "" {
+		sni := binary.BigEndian.AppendUint16(nil, uint16(3+len(serverName)))
+		sni = append(sni, 0) // host_name
+		sni = binary.BigEndian.AppendUint16(sni, uint16(len(serverName)))
+		extension(0, append(sni, serverName...))
+	}
+	extension(10, []byte{0, 6, 0x00, 0x1d, 0x00, 0x17, 0x00, 0x18}) // x25519, P-256, P-384
+	extension(11, []byte{1, 0})                                     // uncompressed points
+	extension(13, []byte{0, 14, 0x04, 0x03, 0x08, 0x04, 0x04, 0x01, 0x05, 0x03, 0x05, 0x01, 0x02, 0x01, 0x02, 0x03})
+	extension(14, []byte{0, 2, 0x00, 0x01, 0}) // use_srtp, which WebRTC servers insist on
+	extension(23, nil)                         // extended_master_secret
+	extension(0xff01, []byte{0})               // renegotiation_info
+	body = binary.BigEndian.AppendUint16(body, uint16(len(ext)))
+	body = append(body, ext...)
+
+	// One unfragmented handshake message in one record, the record and
+	// the message numbered alike. Records say DTLS 1.0 until the server
+	// has picked a version.
+	rec := []byte{dtlsHandshake, 0xfe, 0xff, 0, 0, 0, 0, 0, 0, 0, byte(seq)}
+	rec = binary.BigEndian.AppendUint16(rec, uint16(12+len(body)))
+	rec = append(rec, dtlsClientHello)
+	rec = appendUint24(rec, len(body))
+	rec = binary.BigEndian.AppendUint16(rec, seq)
+	rec = appendUint24(rec, 0)
+	rec = appendUint24(rec, len(body))
+	return append(rec, body...)
+}
+
+func appendUint24(b []byte, v int) []byte {
+	return append(b, byte(v>>16), byte(v>>8), byte(v))
+}
+
+func uint24(b []byte) int {
+	return int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+}
+
+// dtlsRecord is a DTLS record of a datagram.
+type dtlsRecord struct {
+	typ     byte
+	version uint16
+	epoch   uint16
+	body    []byte
+}
+
+// parseDTLSRecords splits a datagram into its DTLS records. It stops at
+// anything that is not one.
+func parseDTLSRecords(d []byte) []dtlsRecord {
+	var recs []dtlsRecord
+	for len(d) >= 13 && (d[0] == dtlsAlert || d[0] == dtlsHandshake) && d[1] == 0xfe {
+		n := int(binary.BigEndian.Uint16(d[11:]))
+		if len(d) < 13+n {
+			break
+		}
+		recs = append(recs, dtlsRecord{
+			typ:     d[0],
+			version: binary.BigEndian.Uint16(d[1:]),
+			epoch:   binary.BigEndian.Uint16(d[3:]),
+			body:    d[13 : 13+n],
+		})
+		d = d[13+n:]
+	}
+	return recs
+}
+
+// dtlsMessage is a handshake message, put together from its fragments.
+type dtlsMessage struct {
+	typ    byte
+	length int
+	stream cryptoStream
+	body   []byte
+	done   bool
+}
+
+// parseDTLSHandshake adds the handshake fragments of a record body to
+// messages, by message sequence number, and returns the messages they
+// complete.
+func parseDTLSHandshake(b []byte, messages map[uint16]*dtlsMessage) []*dtlsMessage {
+	var complete []*dtlsMessage
+	for len(b) >= 12 {
+		length, seq, off, n := uint24(b[1:]), binary.BigEndian.Uint16(b[4:]), uint24(b[6:]), uint24(b[9:])
+		if len(b) < 12+n || off+n > length {
+			break
+		}
+		m := messages[seq]
+		if m == nil {
+			m = &dtlsMessage{typ: b[0], length: length}
+			messages[seq] = m
+		}
+		if !m.done && m.typ == b[0] && m.length == length {
+			m.body = append(m.body, m.stream.add(uint64(off), b[12:12+n])...)
+			if len(m.body) == m.length {
+				m.done = true
+				complete = append(complete, m)
+			}
+		}
+		b = b[12+n:]
+	}
+	return complete
+}
+
+// dtlsCertificateSubject returns the subject of the first certificate of
+// a Certificate message, or "" if there is none to parse, as when the
+// server sends a raw public key (RFC 7250).
+func dtlsCertificateSubject(b []byte) string {
+	if len(b) < 6 || len(b) < 6+uint24(b[3:]) {
+		return ""
+	}
+	cert, err := x509.ParseCertificate(b[6 : 6+uint24(b[3:])])
+	if err != nil {
+		return ""
+	}
+	return cert.Subject.String()
+}
+
+// dtlsVersionName names a DTLS version as it is usually written.
+func dtlsVersionName(v uint16) string {
+	switch v {
+	case 0xfeff:
+		return "1.0"
+	case 0xfefd:
+		return "1.2"
+	case 0xfefc:
+		return "1.3"
+	}
+	return fmt.Sprintf("0x%04x", v)
+}
+
+func dtlsCipherName(id uint16) string {
+	if name, ok := dtlsCipherNames[id]; ok {
+		return name
+	}
+	return tls.CipherSuiteName(id)
+}
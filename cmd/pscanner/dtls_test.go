@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// dtlsTestServer answers DTLS ClientHellos on a local UDP port with the
+// first flight of a DTLS 1.2 handshake, or with an alert.
+type dtlsTestServer struct {
+	pc     *net.UDPConn
+	cookie bool // check the client's address with a HelloVerifyRequest first
+	alert  byte // refuse the handshake with this alert
+	cert   []byte
+}
+
+func startDTLSServer(t *testing.T, cookie bool, alert byte) int {
+	pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &dtlsTestServer{pc: pc, cookie: cookie, alert: alert, cert: testCertificate(t).Certificate[0]}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.serve(t)
+	}()
+	t.Cleanup(func() {
+		pc.Close()
+		<-done
+	})
+	return pc.LocalAddr().(*net.UDPAddr).Port
+}
+
+func dtlsTestRecord(typ byte, seq int, body []byte) []byte {
+	rec := []byte{typ, 0xfe, 0xfd, 0, 0, 0, 0, 0, 0, 0, byte(seq)}
+	rec = binary.BigEndian.AppendUint16(rec, uint16(len(body)))
+	return append(rec, body...)
+}
+
+func dtlsTestFragment(typ byte, seq uint16, length, off int, data []byte) []byte {
+	f := appendUint24([]byte{typ}, length)
+	f = binary.BigEndian.AppendUint16(f, seq)
+	f = appendUint24(f, off)
+	f = appendUint24(f, len(data))
+	return append(f, data...)
+}
+
+func (s *dtlsTestServer) serve(t *testing.T) {
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := s.pc.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			return
+		}
+		recs := parseDTLSRecords(buf[:n])
+		if len(recs) != 1 {
+			t.Errorf("ClientHello datagram has %d records", len(recs))
+			return
+		}
+		msgs := parseDTLSHandshake(recs[0].body, make(map[uint16]*dtlsMessage))
+		if len(msgs) != 1 || msgs[0].typ != dtlsClientHello {
+			t.Errorf("ClientHello record holds %+v", msgs)
+			return
+		}
+		hello := msgs[0].body
+		cookie := hello[36 : 36+int(hello[35])] // after the version, random and empty session ID
+		send := func(b []byte) { s.pc.WriteToUDPAddrPort(b, from) }
+
+		switch {
+		case s.alert != 0:
+			send(dtlsTestRecord(dtlsAlert, 0, []byte{2, s.alert}))
+		case s.cookie && len(cookie) == 0:
+			hvr := append([]byte{0xfe, 0xfd, 8}, "cookie-1"...)
+			send(dtlsTestRecord(dtlsHandshake, 0, dtlsTestFragment(dtlsHelloVerifyRequest, 0, len(hvr), 0, hvr)))
+		default:
+			if s.cookie && string(cookie) != "cookie-1" {
+				t.Errorf("second ClientHello has cookie %q", cookie)
+			}
+			seq := uint16(0)
+			if s.cookie {
+				seq = 1
+			}
+			hello := append([]byte{0xfe, 0xfd}, make([]byte, 32)...)
+			hello = append(hello, 0, 0xc0, 0xae, 0)
+			send(dtlsTestRecord(dtlsHandshake, 1, dtlsTestFragment(dtlsServerHello, seq, len(hello), 0, hello)))
+
+			// The certificate in two fragments, the second sent first.
+			cert := appendUint24(nil, 3+len(s.cert))
+			cert = appendUint24(cert, len(s.cert))
+			cert = append(cert, s.cert...)
+			half := len(cert) / 2
+			send(dtlsTestRecord(dtlsHandshake, 3, dtlsTestFragment(dtlsCertificate, seq+1, len(cert), half, cert[half:])))
+			send(dtlsTestRecord(dtlsHandshake, 2, dtlsTestFragment(dtlsCertificate, seq+1, len(cert), 0, cert[:half])))
+			send(dtlsTestRecord(dtlsHandshake, 4, dtlsTestFragment(dtlsServerHelloDone, seq+2, 0, 0, nil)))
+		}
+	}
+}
+
+func TestProbeDTLS(t *testing.T) {
+	tests := []struct {
+		name   string
+		cookie bool
+		alert  byte
+		want   DTLSInfo
+	}{
+		{"handshake", false, 0, DTLSInfo{Version: "1.2", Cipher: "TLS_ECDHE_ECDSA_WITH_AES_128_CCM_8", Certificate: "CN=localhost"}},
+		{"cookie", true, 0, DTLSInfo{Version: "1.2", Cipher: "TLS_ECDHE_ECDSA_WITH_AES_128_CCM_8", Certificate: "CN=localhost"}},
+		{"alert", false, 40, DTLSInfo{Version: "1.2", Alert: "handshake failure"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			port := startDTLSServer(t, tt.cookie, tt.alert)
+			// A bound socket that never answers, to be left out.
+			quiet, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer quiet.Close()
+			old := dtlsPorts
+			defer func() { dtlsPorts = old }()
+			dtlsPorts = []int{quiet.LocalAddr().(*net.UDPAddr).Port, port}
+
+			p := &scanPlan{dtls: true, timeout: 500 * time.Millisecond}
+			hosts := []HostResult{{Host: "127.0.0.1", Ports: []PortResult{{Port: port, Protocol: "udp", State: "open"}}}}
+			p.probeDTLS(context.Background(), hosts)
+			tt.want.Port = port
+			if !reflect.DeepEqual(hosts[0].DTLS, []DTLSInfo{tt.want}) {
+				t.Errorf("DTLS = %+v, want %+v", hosts[0].DTLS, tt.want)
+			}
+			// The port another probe found already is not listed twice.
+			if len(hosts[0].Ports) != 1 {
+				t.Errorf("ports = %+v", hosts[0].Ports)
+			}
+		})
+	}
+}
+
+func TestDTLSInfoString(t *testing.T) {
+	for _, tt := range []struct {
+		d    DTLSInfo
+		want string
+	}{
+		{DTLSInfo{Port: 5684, Version: "1.2", Cipher: "TLS_PSK_WITH_AES_128_CCM_8"}, "udp/5684 DTLS 1.2, TLS_PSK_WITH_AES_128_CCM_8"},
+		{DTLSInfo{Port: 443, Version: "1.2", Cipher: "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", Certificate: "CN=vpn.example.com"}, "udp/443 DTLS 1.2, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, certificate CN=vpn.example.com"},
+		{DTLSInfo{Port: 5349, Version: "1.0", Alert: "handshake failure"}, "udp/5349 DTLS 1.0 (alert: handshake failure)"},
+	} {
+		if got := tt.d.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+// A hostname gets its name sent, so that virtual hosts answer.
+func TestDTLSClientHelloServerName(t *testing.T) {
+	rec := dtlsClientHelloRecord(make([]byte, 32), []byte("c"), "vpn.example.com", 1)
+	recs := parseDTLSRecords(rec)
+	if len(recs) != 1 || recs[0].version != 0xfeff {
+		t.Fatalf("records = %+v", recs)
+	}
+	msgs := parseDTLSHandshake(recs[0].body, make(map[uint16]*dtlsMessage))
+	if len(msgs) != 1 || msgs[0].typ != dtlsClientHello {
+		t.Fatalf("messages = %+v", msgs)
+	}
+	if want := append([]byte{0, 0, 0, 20, 0, 18, 0, 0, 15}, "vpn.example.com"...); !bytes.Contains(msgs[0].body, want) {
+		t.Error("ClientHello has no server_name extension for vpn.example.com")
+	}
+}
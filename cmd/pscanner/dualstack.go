@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// Address families, for --prefer and HostResult.Family.
+const (
+	familyIPv4 = "ipv4"
+	familyIPv6 = "ipv6"
+	preferBoth = "both"
+)
+
+// parsePrefer checks a --prefer value.
+func parsePrefer(s string) (string, error) {
+	switch s {
+	case "", familyIPv4, familyIPv6, preferBoth:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown --prefer %q (want ipv4, ipv6 or both)", s)
+}
+
+// families returns the address families host is probed over. Without
+// --prefer, and for IP addresses, that is one unnamed family: the dialer
+// picks the address of a hostname, racing IPv6 against IPv4 (RFC 8305).
+func (p *scanPlan) families(host string) []string {
+	if p.prefer == "" {
+		return []string{""}
+	}
+	if _, err := netip.ParseAddr(host); err == nil {
+		return []string{""}
+	}
+	if p.prefer == preferBoth {
+		return []string{familyIPv4, familyIPv6}
+	}
+	return []string{p.prefer}
+}
+
+// dialNetwork is the network to dial a host over family.
+func dialNetwork(family string) string {
+	switch family {
+	case familyIPv4:
+		return "tcp4"
+	case familyIPv6:
+		return "tcp6"
+	}
+	return "tcp"
+}
+
+// hostKey tells the results of host over family apart from those over the
+// other family.
+func hostKey(host, family string) string {
+	if family == "" {
+		return host
+	}
+	return host + " " + family
+}
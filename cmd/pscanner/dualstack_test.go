@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestFamilies(t *testing.T) {
+	for _, tt := range []struct {
+		prefer, host string
+		want         []string
+	}{
+		{"", "example.com", []string{""}},
+		{familyIPv4, "example.com", []string{familyIPv4}},
+		{familyIPv6, "example.com", []string{familyIPv6}},
+		{preferBoth, "example.com", []string{familyIPv4, familyIPv6}},
+		// IP addresses have one family already.
+		{preferBoth, "192.0.2.1", []string{""}},
+		{familyIPv4, "2001:db8::1", []string{""}},
+	} {
+		p := &scanPlan{prefer: tt.prefer}
+		if got := p.families(tt.host); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("--prefer %q: families(%s) = %q, want %q", tt.prefer, tt.host, got, tt.want)
+		}
+	}
+	if hostKey("a.example", "") != "a.example" || hostKey("a.example", familyIPv6) == hostKey("a.example", familyIPv4) {
+		t.Error("hostKey does not tell the families apart")
+	}
+	if _, err := parsePrefer("ipv5"); err == nil {
+		t.Error("parsePrefer(ipv5) succeeded")
+	}
+}
+
+// TestProbeOrder checks that a hostname is dialed over the family of its
+// first address, and over the one --prefer asks for, when both answer.
+func TestProbeOrder(t *testing.T) {
+	l4, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l4.Close()
+	port := l4.Addr().(*net.TCPAddr).Port
+	l6, err := net.Listen("tcp", net.JoinHostPort("::1", strconv.Itoa(port)))
+	if err != nil {
+		t.Skipf("no IPv6 loopback on port %d: %v", port, err)
+	}
+	defer l6.Close()
+
+	v4, v6 := netip.MustParseAddr("127.0.0.1"), netip.MustParseAddr("::1")
+	for _, tt := range []struct {
+		addrs  []netip.Addr
+		family string
+		want   netip.Addr
+	}{
+		{[]netip.Addr{v6, v4}, "", v6},
+		{[]netip.Addr{v4, v6}, "", v4},
+		{[]netip.Addr{v6, v4}, familyIPv4, v4},
+		{[]netip.Addr{v4, v6}, familyIPv6, v6},
+	} {
+		dns := newDNSCache("")
+		dns.lookup = func(context.Context, string) ([]netip.Addr, time.Duration, error) {
+			return tt.addrs, 0, nil
+		}
+		p := &scanPlan{timeout: time.Second}
+		conn, err := p.probe(context.Background(), dns, job{host: "dual.example", port: port, family: tt.family})
+		if err != nil {
+			t.Errorf("probe of %v over %q: %v", tt.addrs, tt.family, err)
+			continue
+		}
+		if got := conn.RemoteAddr().(*net.TCPAddr).AddrPort().Addr(); got != tt.want {
+			t.Errorf("probe of %v over %q connected to %s, want %s", tt.addrs, tt.family, got, tt.want)
+		}
+		conn.Close()
+	}
+}
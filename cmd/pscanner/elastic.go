@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Elasticsearch is a cluster that scan results are indexed into. The same
+// API works with OpenSearch.
+type Elasticsearch struct {
+	// URL is the cluster address; user:password in it selects basic auth.
+	URL string `json:"url"`
+	// Index is the prefix of the daily indices, "pscanner" by default.
+	Index string `json:"index,omitempty"`
+	// APIKey is sent as "Authorization: ApiKey ..." (Elasticsearch only).
+	APIKey string `json:"api_key,omitempty"`
+}
+
+// esDoc is one indexed document: a port found open, or a scan summary.
+// Field names follow the Elastic Common Schema where one fits, so the
+// documents line up with other network data in Kibana.
+type esDoc struct {
+	Timestamp   time.Time      `json:"@timestamp"`
+	Event       esEvent        `json:"event"`
+	Destination *esDestination `json:"destination,omitempty"`
+	Network     *esNetwork     `json:"network,omitempty"`
+	Service     *esService     `json:"service,omitempty"`
+	Pscanner    esScan         `json:"pscanner"`
+}
+
+type esEvent struct {
+	Kind     string   `json:"kind"`
+	Category []string `json:"category"`
+	Type     []string `json:"type"`
+	Dataset  string   `json:"dataset"`
+	Start    string   `json:"start,omitempty"`
+	End      string   `json:"end,omitempty"`
+}
+
+type esDestination struct {
+	Address string `json:"address"`
+	IP      string `json:"ip,omitempty"`
+	Port    int    `json:"port"`
+}
+
+type esNetwork struct {
+	Transport string `json:"transport"`
+}
+
+type esService struct {
+	Name string `json:"name"`
+}
+
+type esScan struct {
+	ScanID    string            `json:"scan_id"`
+	Schedule  string            `json:"schedule,omitempty"`
+	Tags      map[string]string `json:"tags,omitempty"`
+	Targets   []string          `json:"targets,omitempty"`
+	Ports     string            `json:"ports,omitempty"`
+	OpenPorts *int              `json:"open_ports,omitempty"`
+	Canceled  bool              `json:"canceled,omitempty"`
+	TimedOut  bool              `json:"host_timeout,omitempty"`
+}
+
+// esTemplate is installed as the index template for the daily indices
+// before the first bulk request.
+const esTemplate = `{
+  "index_patterns": [%q],
+  "priority": 100,
+  "template": {
+    "settings": {"number_of_shards": 1},
+    "mappings": {
+      "dynamic": false,
+      "dynamic_templates": [
+        {"tags": {"path_match": "pscanner.tags.*", "mapping": {"type": "keyword"}}}
+      ],
+      "properties": {
+        "@timestamp": {"type": "date"},
+        "event": {"properties": {
+          "kind": {"type": "keyword"},
+          "category": {"type": "keyword"},
+          "type": {"type": "keyword"},
+          "dataset": {"type": "keyword"},
+          "start": {"type": "date"},
+          "end": {"type": "date"}
+        }},
+        "destination": {"properties": {
+          "address": {"type": "keyword"},
+          "ip": {"type": "ip"},
+          "port": {"type": "integer"}
+        }},
+        "network": {"properties": {"transport": {"type": "keyword"}}},
+        "service": {"properties": {"name": {"type": "keyword"}}},
+        "pscanner": {"properties": {
+          "scan_id": {"type": "keyword"},
+          "schedule": {"type": "keyword"},
+          "tags": {"type": "object", "dynamic": true},
+          "targets": {"type": "keyword"},
+          "ports": {"type": "keyword"},
+          "open_ports": {"type": "integer"},
+          "canceled": {"type": "boolean"},
+          "host_timeout": {"type": "boolean"}
+        }}
+      }
+    }
+  }
+}`
+
+// esExporter bulk-indexes every finished run.
+type esExporter struct {
+	Elasticsearch
+	base     *url.URL // URL without credentials
+	user     *url.Userinfo
+	client   *http.Client
+	attempts int
+	backoff  time.Duration
+
+	templateOnce sync.Once
+}
+
+func newESExporter(e Elasticsearch) (*esExporter, error) {
+	u, err := url.Parse(e.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid Elasticsearch URL %q (want http:// or https://)", e.URL)
+	}
+	if e.Index == "" {
+		e.Index = "pscanner"
+	}
+	if e.Index != strings.ToLower(e.Index) || strings.ContainsAny(e.Index, `\/*?"<>| ,#:`) {
+		return nil, fmt.Errorf("invalid index prefix %q (lowercase, no special characters)", e.Index)
+	}
+	x := &esExporter{
+		Elasticsearch: e,
+		user:          u.User,
+		client:        &http.Client{Timeout: 30 * time.Second},
+		attempts:      webhookAttempts,
+		backoff:       webhookBackoff,
+	}
+	u.User = nil
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	x.base = u
+	return x, nil
+}
+
+// esDocs turns a report into one document per open port and a summary. Scans
+// run from the command line have no ID, so one is made up to group their
+// documents.
+func esDocs(r *Report) []esDoc {
+	id := r.ID
+	if id == "" {
+		id = newJobID()
+	}
+	event := func(dataset string) esEvent {
+		return esEvent{Kind: "event", Category: []string{"network"}, Type: []string{"info"}, Dataset: dataset}
+	}
+	var docs []esDoc
+	for _, h := range r.Hosts {
+		ip := ""
+		if a, err := netip.ParseAddr(h.Host); err == nil {
+			ip = a.String()
+		}
+		for _, p := range h.Ports {
+			d := esDoc{
+				Timestamp:   r.FinishedAt,
+				Event:       event("pscanner.port"),
+				Destination: &esDestination{Address: h.Host, IP: ip, Port: p.Port},
+				Network:     &esNetwork{Transport: p.Protocol},
+				Pscanner:    esScan{ScanID: id, Schedule: r.Schedule, Tags: r.Tags, TimedOut: h.TimedOut},
+			}
+			if name := p.service(); name != "" {
+				d.Service = &esService{Name: name}
+			}
+			docs = append(docs, d)
+		}
+	}
+	open := len(docs)
+	summary := esDoc{
+		Timestamp: r.FinishedAt,
+		Event:     event("pscanner.scan"),
+		Pscanner: esScan{
+			ScanID:    id,
+			Schedule:  r.Schedule,
+			Tags:      r.Tags,
+			Targets:   r.Parameters.Targets,
+			Ports:     r.Parameters.Ports,
+			OpenPorts: &open,
+			Canceled:  r.Canceled,
+		},
+	}
+	summary.Event.Start = r.StartedAt.Format(time.RFC3339Nano)
+	summary.Event.End = r.FinishedAt.Format(time.RFC3339Nano)
+	return append(docs, summary)
+}
+
+// notify indexes run into the day's index. Like webhook failures, indexing
+// failures are logged and do not stop the scans.
+func (x *esExporter) notify(run scanRun) {
+	x.templateOnce.Do(func() {
+		if err := x.putTemplate(); err != nil {
+			slog.Error("installing the elasticsearch index template failed", "err", err)
+		}
+	})
+	if err := x.index(run.Report); err != nil {
+		slog.Error("elasticsearch export failed", "scan", run.Report.ID, "err", err)
+	}
+}
+
+func (x *esExporter) putTemplate() error {
+	body := []byte(fmt.Sprintf(esTemplate, x.Index+"-*"))
+	_, err := x.do(http.MethodPut, "/_index_template/"+x.Index, "application/json", body)
+	return err
+}
+
+func (x *esExporter) index(r *Report) error {
+	index := x.Index + "-" + r.FinishedAt.UTC().Format("2006.01.02")
+	action, _ := json.Marshal(map[string]any{"index": map[string]string{"_index": index}})
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	for _, d := range esDocs(r) {
+		b.Write(action)
+		b.WriteByte('\n')
+		if err := enc.Encode(d); err != nil {
+			return err
+		}
+	}
+	resp, err := x.do(http.MethodPost, "/_bulk", "application/x-ndjson", b.Bytes())
+	if err != nil {
+		return err
+	}
+	return bulkErrors(resp)
+}
+
+// bulkErrors reports the documents a bulk request failed to index; the
+// request as a whole succeeds even when some of them do.
+func bulkErrors(resp []byte) error {
+	var result struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Error *struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return fmt.Errorf("unexpected bulk response: %v", err)
+	}
+	if !result.Errors {
+		return nil
+	}
+	failed, first := 0, ""
+	for _, item := range result.Items {
+		for _, op := range item {
+			if op.Error != nil {
+				failed++
+				if first == "" {
+					first = op.Error.Type + ": " + op.Error.Reason
+				}
+			}
+		}
+	}
+	return fmt.Errorf("%d of %d documents not indexed (%s)", failed, len(result.Items), first)
+}
+
+func (x *esExporter) do(method, path, contentType string, body []byte) ([]byte, error) {
+	return retryRequest(x.client, x.attempts, x.backoff, func() (*http.Request, error) {
+		req, err := http.NewRequest(method, x.base.String()+path, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", contentType)
+		switch {
+		case x.APIKey != "":
+			req.Header.Set("Authorization", "ApiKey "+x.APIKey)
+		case x.user != nil:
+			pw, _ := x.user.Password()
+			req.SetBasicAuth(x.user.Username(), pw)
+		}
+		return req, nil
+	})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestESDocs(t *testing.T) {
+	r := testReport("22,80", hostWith("10.0.0.5", 22), hostWith("example.com", 80))
+	r.ID = "0123456789abcdef"
+	docs := esDocs(r)
+	if len(docs) != 3 {
+		t.Fatalf("%d documents, want 2 ports and a summary", len(docs))
+	}
+	if d := docs[0]; d.Destination.IP != "10.0.0.5" || d.Destination.Port != 22 || d.Service.Name != "ssh" || d.Pscanner.ScanID != r.ID {
+		t.Errorf("port document = %+v", d)
+	}
+	if d := docs[1]; d.Destination.IP != "" || d.Destination.Address != "example.com" {
+		t.Errorf("hostname document = %+v", d.Destination)
+	}
+	if d := docs[2]; d.Event.Dataset != "pscanner.scan" || *d.Pscanner.OpenPorts != 2 || d.Destination != nil {
+		t.Errorf("summary document = %+v", d)
+	}
+
+	r.ID = ""
+	docs = esDocs(r)
+	if id := docs[0].Pscanner.ScanID; id == "" || docs[2].Pscanner.ScanID != id {
+		t.Errorf("documents of a command line scan not grouped: %q, %q", id, docs[2].Pscanner.ScanID)
+	}
+}
+
+func TestESExporter(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	var bulk []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		user, pw, _ := r.BasicAuth()
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+user+":"+pw)
+		if r.URL.Path == "/_bulk" {
+			bulk = body
+			io.WriteString(w, `{"errors": false, "items": []}`)
+			return
+		}
+		io.WriteString(w, `{"acknowledged": true}`)
+	}))
+	defer srv.Close()
+
+	x, err := newESExporter(Elasticsearch{URL: strings.Replace(srv.URL, "http://", "http://elastic:pw@", 1) + "/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := testReport("22", hostWith("10.0.0.5", 22))
+	r.FinishedAt = time.Date(2026, 10, 14, 23, 0, 0, 0, time.UTC)
+	x.notify(scanRun{Report: r})
+	x.notify(scanRun{Report: r})
+
+	want := []string{"PUT /_index_template/pscanner elastic:pw", "POST /_bulk elastic:pw", "POST /_bulk elastic:pw"}
+	if strings.Join(requests, "\n") != strings.Join(want, "\n") {
+		t.Errorf("requests:\n%s\nwant\n%s", strings.Join(requests, "\n"), strings.Join(want, "\n"))
+	}
+	lines := 0
+	for sc := bufio.NewScanner(bytes.NewReader(bulk)); sc.Scan(); lines++ {
+		var v map[string]any
+		if err := json.Unmarshal(sc.Bytes(), &v); err != nil {
+			t.Fatalf("bulk line %d: %v", lines, err)
+		}
+		if lines == 0 && v["index"].(map[string]any)["_index"] != "pscanner-2026.10.14" {
+			t.Errorf("first action = %v", v)
+		}
+	}
+	if lines != 4 {
+		t.Errorf("bulk body has %d lines, want 4", lines)
+	}
+
+	if _, err := newESExporter(Elasticsearch{URL: srv.URL, Index: "Scans"}); err == nil {
+		t.Error("upper-case index prefix accepted")
+	}
+}
+
+func TestBulkErrors(t *testing.T) {
+	resp := `{"errors": true, "items": [
+		{"index": {"status": 201}},
+		{"index": {"status": 400, "error": {"type": "mapper_parsing_exception", "reason": "bad port"}}}
+	]}`
+	err := bulkErrors([]byte(resp))
+	if err == nil || err.Error() != "1 of 2 documents not indexed (mapper_parsing_exception: bad port)" {
+		t.Errorf("bulkErrors = %v", err)
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	_ "embed"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Email sends the report of a run to a list of addresses, with the same
+// conditions as a Webhook.
+type Email struct {
+	// SMTP is smtp://[user:password@]host[:587], which upgrades with
+	// STARTTLS when the server offers it, or smtps://host[:465] for
+	// implicit TLS.
+	SMTP string   `json:"smtp"`
+	From string   `json:"from,omitempty"`
+	To   []string `json:"to"`
+	On   []string `json:"on,omitempty"`
+	// AlertPorts is a port list as for --ports.
+	AlertPorts string `json:"alert_ports,omitempty"`
+	// Attach lists the report formats attached to the mail, html and json
+	// by default.
+	Attach []string `json:"attach,omitempty"`
+}
+
+// emailAttachments are the values accepted in Email.Attach.
+var emailAttachments = []string{"html", "json"}
+
+type emailer struct {
+	Email
+	triggers
+	addr     string // host:port
+	host     string
+	implicit bool // TLS from the first byte (smtps)
+	user     *url.Userinfo
+	timeout  time.Duration
+}
+
+func newEmailer(e Email, groups map[string]string) (*emailer, error) {
+	u, err := url.Parse(e.SMTP)
+	if err != nil || (u.Scheme != "smtp" && u.Scheme != "smtps") || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid SMTP server %q (want smtp://host:587 or smtps://host:465)", e.SMTP)
+	}
+	if len(e.To) == 0 {
+		return nil, errors.New("email: no recipients")
+	}
+	for _, a := range append(slices.Clip(e.To), e.From) {
+		if a == "" {
+			continue
+		}
+		if _, err := mail.ParseAddress(a); err != nil {
+			return nil, fmt.Errorf("email: invalid address %q", a)
+		}
+	}
+	if e.From == "" {
+		host, _ := os.Hostname()
+		e.From = "pscanner@" + host
+	}
+	if len(e.Attach) == 0 {
+		e.Attach = emailAttachments
+	}
+	for _, a := range e.Attach {
+		if !slices.Contains(emailAttachments, a) {
+			return nil, fmt.Errorf("email: unknown attachment %q (want %s)", a, strings.Join(emailAttachments, ", "))
+		}
+	}
+	t, err := newTriggers(e.On, e.AlertPorts, groups)
+	if err != nil {
+		return nil, fmt.Errorf("email: %v", err)
+	}
+	x := &emailer{Email: e, triggers: t, host: u.Hostname(), implicit: u.Scheme == "smtps", user: u.User, timeout: time.Minute}
+	port := u.Port()
+	if port == "" {
+		port = "587"
+		if x.implicit {
+			port = "465"
+		}
+	}
+	x.addr = net.JoinHostPort(x.host, port)
+	if x.user != nil {
+		if _, ok := x.user.Password(); !ok {
+			x.user = url.UserPassword(x.user.Username(), os.Getenv("PSCANNER_SMTP_PASSWORD"))
+		}
+	}
+	return x, nil
+}
+
+// notify mails run if it meets a condition. Failures are logged and do not
+// stop the scans.
+func (x *emailer) notify(run scanRun) {
+	p := webhookPayload{Schedule: run.Schedule, Report: run.Report, Diff: run.Diff}
+	if p.Reasons, p.Alerts = x.check(run); len(p.Reasons) == 0 {
+		return
+	}
+	msg, err := x.message(p, time.Now())
+	if err == nil {
+		err = x.send(msg)
+	}
+	if err != nil {
+		slog.Error("email failed", "server", x.host, "err", err)
+	}
+}
+
+// message builds the MIME message: the chat summary as the text part, and
+// the report in each Attach format.
+func (x *emailer) message(p webhookPayload, now time.Time) ([]byte, error) {
+	title, lines := chatMessage(p)
+	var b bytes.Buffer
+	mw := multipart.NewWriter(&b)
+	id := make([]byte, 8)
+	rand.Read(id)
+	fmt.Fprintf(&b, "From: %s\r\n", x.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(x.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", title))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%d.%s@pscanner>\r\n", now.Unix(), hex.EncodeToString(id))
+	fmt.Fprintf(&b, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", mw.Boundary())
+
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	qp := quotedprintable.NewWriter(part)
+	fmt.Fprintf(qp, "%s\r\n\r\n%s\r\n", title, strings.Join(lines, "\r\n"))
+	qp.Close()
+
+	name := "pscanner-" + p.Report.StartedAt.UTC().Format("2006-01-02T15-04-05Z")
+	for _, a := range x.Attach {
+		var body bytes.Buffer
+		ct := "application/json"
+		if a == "html" {
+			ct = "text/html; charset=utf-8"
+			err = htmlReport.Execute(&body, p)
+		} else {
+			enc := json.NewEncoder(&body)
+			enc.SetIndent("", "  ")
+			err = enc.Encode(p.Report)
+		}
+		if err != nil {
+			return nil, err
+		}
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {ct},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": name + "." + a})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		writeBase64Lines(part, body.Bytes())
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// writeBase64Lines writes data base64-encoded in lines of 76 characters, the
+// limit for MIME bodies.
+func writeBase64Lines(w io.Writer, data []byte) {
+	enc := base64.StdEncoding.EncodeToString(data)
+	for len(enc) > 76 {
+		fmt.Fprintf(w, "%s\r\n", enc[:76])
+		enc = enc[76:]
+	}
+	fmt.Fprintf(w, "%s\r\n", enc)
+}
+
+// send delivers msg in one SMTP session. A password is only sent over TLS.
+func (x *emailer) send(msg []byte) error {
+	d := &net.Dialer{Timeout: 30 * time.Second}
+	tlsConfig := &tls.Config{ServerName: x.host}
+	var conn net.Conn
+	var err error
+	if x.implicit {
+		conn, err = tls.DialWithDialer(d, "tcp", x.addr, tlsConfig)
+	} else {
+		conn, err = d.Dial("tcp", x.addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(x.timeout))
+	c, err := smtp.NewClient(conn, x.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if !x.implicit {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(tlsConfig); err != nil {
+				return err
+			}
+		} else if x.user != nil {
+			return errors.New("server does not offer STARTTLS; not sending the password in the clear")
+		}
+	}
+	if x.user != nil {
+		pw, _ := x.user.Password()
+		if err := c.Auth(smtp.PlainAuth("", x.user.Username(), pw, x.host)); err != nil {
+			return err
+		}
+	}
+	for i, addr := range append([]string{x.From}, x.To...) {
+		a, err := mail.ParseAddress(addr)
+		if err != nil {
+			return fmt.Errorf("address %q: %v", addr, err)
+		}
+		if i == 0 {
+			err = c.Mail(a.Address)
+		} else {
+			err = c.Rcpt(a.Address)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+//go:embed data/report.html
+var htmlReportSource string
+
+// htmlReport renders a delivery as a standalone page that mail clients and
+// browsers show alike, so it uses inline styles only.
+var htmlReport = template.Must(template.New("report").Funcs(template.FuncMap{
+	"service": serviceName,
+	"port":    portText,
+	"time":    func(t time.Time) string { return t.UTC().Format("2006-01-02 15:04:05 UTC") },
+	"shots":   hasScreenshots,
+}).Parse(htmlReportSource))
+
+// hasScreenshots reports whether any of hosts has --screenshots to show.
+func hasScreenshots(hosts []HostResult) bool {
+	return slices.ContainsFunc(hosts, func(h HostResult) bool { return len(h.Screenshots) > 0 })
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewEmailerValidates(t *testing.T) {
+	for _, e := range []Email{
+		{SMTP: "mail.example.com:25", To: []string{"a@example.com"}},
+		{SMTP: "smtp://mail.example.com"},
+		{SMTP: "smtp://mail.example.com", To: []string{"not an address"}},
+		{SMTP: "smtp://mail.example.com", To: []string{"a@example.com"}, Attach: []string{"pdf"}},
+		{SMTP: "smtp://mail.example.com", To: []string{"a@example.com"}, On: []string{"ports"}},
+	} {
+		if _, err := newEmailer(e, nil); err == nil {
+			t.Errorf("newEmailer(%+v) succeeded", e)
+		}
+	}
+	x, err := newEmailer(Email{SMTP: "smtps://mail.example.com", To: []string{"a@example.com"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x.addr != "mail.example.com:465" || !x.implicit {
+		t.Errorf("smtps defaults to %s (implicit TLS %v)", x.addr, x.implicit)
+	}
+}
+
+func TestEmailMessage(t *testing.T) {
+	x, err := newEmailer(Email{SMTP: "smtp://mail.example.com", From: "scanner@example.com", To: []string{"soc@example.com"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := webhookPayload{
+		Schedule: "nightly",
+		Report:   testReport("22,80", hostWith("10.0.0.5", 22, 80)),
+		Diff:     &reportDiff{Opened: changes("10.0.0.5", 80), Closed: []portChange{}},
+	}
+	raw, err := x.message(p, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if !strings.Contains(subject, "(nightly) finished") {
+		t.Errorf("Subject = %q", subject)
+	}
+	_, params, _ := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	parts := map[string]string{}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(part)
+		if part.Header.Get("Content-Transfer-Encoding") == "base64" {
+			body, _ = base64.StdEncoding.DecodeString(strings.ReplaceAll(string(body), "\r\n", ""))
+		}
+		name := part.FileName()
+		if name == "" {
+			name = "text"
+		}
+		parts[name] = string(body)
+	}
+	name := "pscanner-" + p.Report.StartedAt.UTC().Format("2006-01-02T15-04-05Z")
+	if !strings.Contains(parts["text"], "Newly open:") {
+		t.Errorf("text part = %q", parts["text"])
+	}
+	if h := parts[name+".html"]; !strings.Contains(h, "opened 10.0.0.5:80/tcp (http)") || !strings.Contains(h, "<td style=\"padding: 0.3rem 0.75rem; border-bottom: 1px solid #eee;\">ssh</td>") {
+		t.Errorf("HTML attachment = %s", h)
+	}
+	if j := parts[name+".json"]; !strings.Contains(j, `"host": "10.0.0.5"`) {
+		t.Errorf("JSON attachment = %s", j)
+	}
+}
+
+// fakeSMTP accepts sessions without TLS and passes on the envelope and
+// the message.
+func fakeSMTP(t *testing.T) (addr string, got <-chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	ch := make(chan string, 1)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go smtpSession(c, ch)
+		}
+	}()
+	return l.Addr().String(), ch
+}
+
+func smtpSession(c net.Conn, ch chan<- string) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	var env []string
+	fmt.Fprintf(c, "220 test ESMTP\r\n")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.TrimSpace(line)
+		switch verb := strings.ToUpper(strings.Fields(cmd + " x")[0]); verb {
+		case "EHLO", "HELO":
+			fmt.Fprintf(c, "250 test\r\n")
+		case "MAIL", "RCPT":
+			env = append(env, cmd)
+			fmt.Fprintf(c, "250 ok\r\n")
+		case "DATA":
+			fmt.Fprintf(c, "354 go ahead\r\n")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil || l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			fmt.Fprintf(c, "250 queued\r\n")
+			ch <- strings.Join(env, "\n") + "\n\n" + data.String()
+		case "QUIT":
+			fmt.Fprintf(c, "221 bye\r\n")
+			return
+		default:
+			fmt.Fprintf(c, "502 unknown\r\n")
+		}
+	}
+}
+
+func TestEmailSend(t *testing.T) {
+	addr, got := fakeSMTP(t)
+	x, err := newEmailer(Email{SMTP: "smtp://" + addr, From: "pscanner <scanner@example.com>", To: []string{"soc@example.com"}, On: []string{"changed"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := scanRun{Report: testReport("22", hostWith("10.0.0.5", 22))}
+	x.notify(run) // no diff, so no mail
+	run.Diff = &reportDiff{Opened: changes("10.0.0.5", 22), Closed: []portChange{}}
+	x.notify(run)
+	select {
+	case m := <-got:
+		if !strings.HasPrefix(m, "MAIL FROM:<scanner@example.com>\nRCPT TO:<soc@example.com>\n") || !strings.Contains(m, "Subject: ") {
+			t.Errorf("session = %q", m)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no mail sent")
+	}
+
+	// A password never goes out without TLS.
+	x, _ = newEmailer(Email{SMTP: "smtp://scanner:pw@" + addr, To: []string{"soc@example.com"}}, nil)
+	if err := x.send([]byte("Subject: x\r\n\r\n")); err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Errorf("send without STARTTLS = %v", err)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	reflectionalphapb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+)
+
+// AppEndpoint is what --endpoints found an open web port to serve: gRPC,
+// WebSocket or plain HTTP.
+type AppEndpoint struct {
+	Port int `json:"port"`
+	// Protocol is grpc, websocket or http.
+	Protocol string `json:"protocol"`
+	TLS      bool   `json:"tls,omitempty"`
+	// Details says what it showed: the gRPC services and their health, the
+	// path of the WebSocket, or the HTTP status and server.
+	Details string `json:"details,omitempty"`
+}
+
+func (e AppEndpoint) String() string {
+	s := fmt.Sprintf("tcp/%d %s", e.Port, e.Protocol)
+	if e.TLS {
+		s += " over TLS"
+	}
+	if e.Details != "" {
+		s += ": " + e.Details
+	}
+	return s
+}
+
+// grpcPort is the usual port of gRPC services, asked along with @web.
+const grpcPort = 50051
+
+// websocketPaths are where WebSocket servers are usually found, asked in
+// turn for an upgrade.
+var websocketPaths = []string{"/", "/ws", "/websocket", "/socket.io/?EIO=4&transport=websocket"}
+
+// probeEndpoints classifies the open ports of @web and 50051 as gRPC,
+// WebSocket or plain HTTP endpoints: a gRPC health check and reflection
+// call first, then WebSocket upgrades, then a plain request.
+func (p *scanPlan) probeEndpoints(ctx context.Context, hosts []HostResult) {
+	if !p.endpoints {
+		return
+	}
+	ports, _ := parsePorts(builtinGroups["web"], nil)
+	ports = append(ports, grpcPort)
+	dns := newDNSCache(p.dnsCache)
+	sem := make(chan struct{}, quicParallel)
+	var wg sync.WaitGroup
+	for i := range hosts {
+		h := &hosts[i]
+		sem <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			for _, pr := range h.Ports {
+				if pr.Protocol == "udp" || !slices.Contains(ports, pr.Port) {
+					continue
+				}
+				e, err := p.endpointProbe(ctx, dns, h, pr.Port)
+				if err != nil {
+					slog.Warn("endpoint probe failed", "host", h.Host, "port", pr.Port, "err", err)
+				}
+				if e != nil {
+					h.Endpoints = append(h.Endpoints, *e)
+				}
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// endpointProbe classifies port of h, trying TLS first on ports of 443 and
+// x443, or nil if it does not speak HTTP.
+func (p *scanPlan) endpointProbe(ctx context.Context, dns *dnsCache, h *HostResult, port int) (*AppEndpoint, error) {
+	tlsFirst := port%1000 == 443
+	for _, useTLS := range []bool{tlsFirst, !tlsFirst} {
+		if details, ok := p.grpcProbe(ctx, dns, h, port, useTLS); ok {
+			return &AppEndpoint{Port: port, Protocol: "grpc", TLS: useTLS, Details: details}, nil
+		}
+	}
+	e, _, err := httpCheck(ctx, p, dns, h, port, tlsFirst, websocketCheck)
+	if e != nil {
+		e.Port = port
+	}
+	return e, err
+}
+
+// grpcProbe asks port of h for the health of its gRPC server and the
+// services reflection lists, and reports whether it speaks gRPC.
+func (p *scanPlan) grpcProbe(ctx context.Context, dns *dnsCache, h *HostResult, port int, useTLS bool) (string, bool) {
+	creds := insecure.NewCredentials()
+	if useTLS {
+		creds = credentials.NewTLS(&tls.Config{InsecureSkipVerify: true})
+	}
+	conn, err := grpc.NewClient("passthrough:///"+net.JoinHostPort(h.Host, fmt.Sprint(port)),
+		grpc.WithTransportCredentials(creds),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return p.probe(ctx, dns, job{host: h.Host, port: port, family: h.Family})
+		}))
+	if err != nil {
+		return "", false
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(ctx, httpRequestTimeout)
+	defer cancel()
+
+	var found []string
+	health, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	switch {
+	case err == nil:
+		found = append(found, "health "+health.GetStatus().String())
+	case grpcAnswered(err):
+		found = append(found, "no health service")
+	default:
+		return "", false
+	}
+	services, err := grpcServices(ctx, conn)
+	switch {
+	case err == nil && len(services) > 0:
+		found = append(found, "reflection lists "+strings.Join(services, ", "))
+	case err == nil || grpcAnswered(err):
+		found = append(found, "no reflection")
+	}
+	return strings.Join(found, ", "), true
+}
+
+// grpcAnswered reports whether err came from a gRPC server, rather than
+// from a connection that failed or an HTTP server that is not one, which
+// gRPC clients also report as some of the same codes.
+func grpcAnswered(err error) bool {
+	s, ok := status.FromError(err)
+	if !ok || s.Code() == codes.Unavailable || s.Code() == codes.DeadlineExceeded || s.Code() == codes.Canceled {
+		return false
+	}
+	msg := s.Message()
+	return !strings.Contains(msg, "unexpected HTTP status code") && !strings.Contains(msg, "content-type") && !strings.Contains(msg, "transport:")
+}
+
+// grpcServices lists the services of a gRPC server through reflection,
+// asking the older v1alpha service of servers without v1.
+func grpcServices(ctx context.Context, conn *grpc.ClientConn) ([]string, error) {
+	names, err := grpcReflect(ctx, reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo,
+		&reflectionpb.ServerReflectionRequest{MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{}},
+		func(resp *reflectionpb.ServerReflectionResponse) []*reflectionpb.ServiceResponse {
+			return resp.GetListServicesResponse().GetService()
+		})
+	if status.Code(err) != codes.Unimplemented {
+		return names, err
+	}
+	return grpcReflect(ctx, reflectionalphapb.NewServerReflectionClient(conn).ServerReflectionInfo,
+		&reflectionalphapb.ServerReflectionRequest{MessageRequest: &reflectionalphapb.ServerReflectionRequest_ListServices{}},
+		func(resp *reflectionalphapb.ServerReflectionResponse) []*reflectionalphapb.ServiceResponse {
+			return resp.GetListServicesResponse().GetService()
+		})
+}
+
+// grpcReflect sends req on a stream that open opens and returns the names
+// of the services in its answer.
+func grpcReflect[Req, Resp any, Service interface{ GetName() string }](ctx context.Context,
+	open func(context.Context, ...grpc.CallOption) (grpc.BidiStreamingClient[Req, Resp], error),
+	req *Req, services func(*Resp) []Service) ([]string, error) {
+	stream, err := open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.CloseSend()
+	if err := stream.Send(req); err != nil {
+		return nil, err
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, s := range services(resp) {
+		names = append(names, s.GetName())
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// websocketCheck asks for a WebSocket upgrade at the usual paths, and
+// describes a plain HTTP endpoint if none is granted.
+func websocketCheck(ctx context.Context, c *httpEndpoint) (*AppEndpoint, error) {
+	resp, err := c.get(ctx, "/", nil)
+	switch {
+	case err != nil && strings.Contains(err.Error(), "malformed HTTP response"):
+		// Something else, such as SSH on a port of its own choosing.
+		return nil, nil
+	case err != nil:
+		return nil, err
+	case c.wrongScheme:
+		return nil, nil
+	}
+	useTLS := strings.HasPrefix(c.base, "https:")
+	details := resp.Status
+	if server := resp.Header.Get("Server"); server != "" {
+		details += fmt.Sprintf(", server %q", server)
+	}
+	for _, path := range websocketPaths {
+		key := make([]byte, 16)
+		rand.Read(key)
+		header := http.Header{
+			"Connection":            {"Upgrade"},
+			"Upgrade":               {"websocket"},
+			"Sec-Websocket-Version": {"13"},
+			"Sec-Websocket-Key":     {base64.StdEncoding.EncodeToString(key)},
+		}
+		resp, err := c.get(ctx, path, header)
+		if err != nil {
+			break
+		}
+		if resp.StatusCode == http.StatusSwitchingProtocols && resp.Header.Get("Sec-Websocket-Accept") == websocketAccept(header.Get("Sec-Websocket-Key")) {
+			return &AppEndpoint{Protocol: "websocket", TLS: useTLS, Details: fmt.Sprintf("upgrade at %s; %s", path, details)}, nil
+		}
+	}
+	return &AppEndpoint{Protocol: "http", TLS: useTLS, Details: details}, nil
+}
+
+// websocketAccept is the Sec-WebSocket-Accept a server answers key with
+// (RFC 6455, section 4.2.2).
+func websocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+)
+
+// serveGRPC serves s on a port of the loopback address for the test.
+func serveGRPC(t *testing.T, s *grpc.Server) int {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	return lis.Addr().(*net.TCPAddr).Port
+}
+
+func TestEndpointProbe(t *testing.T) {
+	reflected := grpc.NewServer()
+	healthpb.RegisterHealthServer(reflected, health.NewServer())
+	reflection.Register(reflected)
+	bare := grpc.NewServer()
+	websocket := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ws" || r.Header.Get("Upgrade") != "websocket" {
+			w.Header().Set("Server", "chat")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+			"Sec-WebSocket-Accept: " + websocketAccept(r.Header.Get("Sec-WebSocket-Key")) + "\r\n\r\n")
+		rw.Flush()
+	}))
+	defer websocket.Close()
+	web := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "<html>hello</html>")
+	}))
+	defer web.Close()
+	ssh := serveTCP(t, func(conn net.Conn) {
+		conn.Write([]byte("SSH-2.0-OpenSSH_9.6\r\n"))
+		time.Sleep(100 * time.Millisecond)
+	})
+
+	p := &scanPlan{timeout: time.Second}
+	h := &HostResult{Host: "127.0.0.1"}
+	for _, tt := range []struct {
+		name string
+		port int
+		want *AppEndpoint
+	}{
+		{"gRPC with reflection", serveGRPC(t, reflected), &AppEndpoint{Protocol: "grpc",
+			Details: "health SERVING, reflection lists grpc.health.v1.Health, grpc.reflection.v1.ServerReflection, grpc.reflection.v1alpha.ServerReflection"}},
+		{"bare gRPC", serveGRPC(t, bare), &AppEndpoint{Protocol: "grpc", Details: "no health service, no reflection"}},
+		{"WebSocket", serverPort(t, websocket), &AppEndpoint{Protocol: "websocket", Details: `upgrade at /ws; 404 Not Found, server "chat"`}},
+		{"HTTPS", serverPort(t, web), &AppEndpoint{Protocol: "http", TLS: true, Details: "200 OK"}},
+		{"SSH", ssh, nil},
+	} {
+		if tt.want != nil {
+			tt.want.Port = tt.port
+		}
+		got, err := p.endpointProbe(context.Background(), nil, h, tt.port)
+		if err != nil || (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+			t.Errorf("endpointProbe of %s = %+v, %v; want %+v", tt.name, got, err, tt.want)
+		}
+	}
+}
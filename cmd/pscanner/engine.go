@@ -0,0 +1,839 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// PortResult is the outcome of probing one port.
+type PortResult struct {
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+	State    string `json:"state"`
+	// Service is what the probe that found a UDP port recognized on it.
+	Service string `json:"service,omitempty"`
+	// Attempts counts the probes it took to find a TCP port open, when
+	// --retries needed more than one.
+	Attempts int `json:"attempts,omitempty"`
+	// Severity is what the --severity rules make of the port being open.
+	Severity string `json:"severity,omitempty"`
+}
+
+// service names the service on the port: the one its probe recognized,
+// or for TCP the port's usual one.
+func (pr PortResult) service() string {
+	if pr.Service != "" || pr.Protocol != "tcp" {
+		return pr.Service
+	}
+	return serviceName(pr.Port)
+}
+
+// HostResult collects the results for one target.
+type HostResult struct {
+	Host     string       `json:"host"`
+	Ports    []PortResult `json:"ports"`
+	TimedOut bool         `json:"host_timeout,omitempty"`
+	// ProbeErrors counts probes that failed without telling whether the
+	// port is open, such as those a proxy could not carry out or an agent
+	// refused.
+	ProbeErrors int `json:"probe_errors,omitempty"`
+	// ThirdParty holds what --enrich services know about the host; it was
+	// not seen by this scan.
+	ThirdParty []ThirdPartyInfo `json:"third_party,omitempty"`
+	// Whois is the registration of the host's network, for --whois.
+	Whois *WhoisInfo `json:"whois,omitempty"`
+	// Route is the way to the host's first open port, for --traceroute.
+	Route []TraceHop `json:"route,omitempty"`
+	// Firewall sums up how the ports answered, for --infer-firewall.
+	Firewall *FirewallInference `json:"firewall,omitempty"`
+	// QUIC is the host's QUIC service, for --quic.
+	QUIC *QUICInfo `json:"quic,omitempty"`
+	// DTLS lists the host's DTLS services, for --dtls.
+	DTLS []DTLSInfo `json:"dtls,omitempty"`
+	// VPN lists the host's IKE and OpenVPN endpoints, for --vpn.
+	VPN []VPNInfo `json:"vpn,omitempty"`
+	// UDP lists the services the --udp probes found.
+	UDP []UDPService `json:"udp,omitempty"`
+	// TCP lists the services the --tcp probes identified.
+	TCP []TCPService `json:"tcp,omitempty"`
+	// OT lists the industrial devices --ot identified.
+	OT []OTDevice `json:"ot,omitempty"`
+	// Containers lists the Docker, Kubernetes and etcd endpoints
+	// --containers checked.
+	Containers []ContainerAPI `json:"containers,omitempty"`
+	// OpenInstances lists the Elasticsearch, Kibana and Prometheus
+	// instances --open-instances checked.
+	OpenInstances []OpenInstance `json:"open_instances,omitempty"`
+	// Endpoints classifies the web and gRPC ports --endpoints asked.
+	Endpoints []AppEndpoint `json:"endpoints,omitempty"`
+	// Payloads lists what open ports answered to their --payload.
+	Payloads []PayloadReply `json:"payloads,omitempty"`
+	// Scripts lists what the --script scripts reported about open ports.
+	Scripts []ScriptResult `json:"scripts,omitempty"`
+	// CDN names the CDN, such as Cloudflare, whose edge answered for the
+	// host: its open ports are the edge's, not those of the origin.
+	CDN string `json:"cdn,omitempty"`
+	// Honeypot is why --honeypots takes the host for a honeypot or tarpit.
+	Honeypot *HoneypotSigns `json:"honeypot,omitempty"`
+	// Family is the address family a hostname was probed over, for
+	// --prefer; with --prefer both a hostname has a result for each.
+	Family string `json:"family,omitempty"`
+	// Probed lists the ports probed on this host when they differ from
+	// host to host, as with "import"; otherwise they are the scan's ports.
+	Probed string `json:"probed_ports,omitempty"`
+	// Closed and Filtered list the ports that refused with a TCP reset
+	// and those that were rejected or dropped, for --state.
+	Closed   string `json:"closed_ports,omitempty"`
+	Filtered string `json:"filtered_ports,omitempty"`
+	// Stats sums up how the probes of the host ended, for --stats.
+	Stats *HostStats `json:"stats,omitempty"`
+	// DialErrors counts its probes whose connection failed, by why.
+	DialErrors *DialErrors `json:"dial_errors,omitempty"`
+	// Down is set when the first probes of the host all timed out. Its
+	// other ports were then skipped, unless --force.
+	Down bool `json:"appears_down,omitempty"`
+	// Calibration is how --calibrate-port timed the host.
+	Calibration *Calibration `json:"calibration,omitempty"`
+	// LookupError is why the hostname could not be looked up; the host
+	// was then not scanned.
+	LookupError string `json:"lookup_error,omitempty"`
+	// SNMP is what --snmp-harvest read from the host, a router or switch.
+	SNMP *SNMPTables `json:"snmp,omitempty"`
+	// LearnedFrom names the host whose results pointed to this one, which
+	// was not a target, and LearnedBy how: "snmp" for the neighbour tables
+	// --snmp-harvest read, "certificate" for a name in its certificate, or
+	// "arp" for the scanner's own ARP table, with no LearnedFrom.
+	LearnedFrom string `json:"learned_from,omitempty"`
+	LearnedBy   string `json:"learned_by,omitempty"`
+	// CertNames are the names and addresses in the certificates of the
+	// host's open TLS ports, for --cert-names and --follow.
+	CertNames []string `json:"cert_names,omitempty"`
+	// VHosts are the --vhosts names its web ports answer as sites of
+	// their own.
+	VHosts []VHost `json:"vhosts,omitempty"`
+	// Screenshots are the pictures of its web ports' pages, for
+	// --screenshots.
+	Screenshots []Screenshot `json:"screenshots,omitempty"`
+	// HTTPPaths are the answers of its web ports to --http-paths that
+	// differ from those to a path no site has.
+	HTTPPaths []HTTPPath `json:"http_paths,omitempty"`
+	// HTTPAuth are the authentication schemes its web ports asked the
+	// HTTP probes for.
+	HTTPAuth []HTTPAuth `json:"http_auth,omitempty"`
+	// Findings are the defaults --safe-checks found left in place.
+	Findings []Finding `json:"findings,omitempty"`
+	// SameAs lists the other results of the same machine: for a hostname
+	// the addresses it resolves to that were scanned as well, and for an
+	// address those hostnames.
+	SameAs []string `json:"same_as,omitempty"`
+}
+
+// scanPlan is a fully resolved scan: what to probe and how.
+type scanPlan struct {
+	targets     targetList
+	numTargets  int
+	ports       []int
+	workers     int // effective worker count, never more than the probes
+	timeout     time.Duration
+	hostTimeout time.Duration
+	delay       time.Duration
+	proxy       contextDialer // nil dials directly
+	proxyURL    string        // for display; credentials removed
+	source      *sourceDialer // --interface and --source-ip; nil for the defaults
+	// hostPorts, when set, holds the ports of each target for "import";
+	// ports is then their union.
+	hostPorts map[string][]int
+	// coord, when set, hands the probes out to agents instead of running
+	// them here.
+	coord      *coordinator
+	coordinate string     // the --coordinate listen address, for display
+	localNets  []localNet // the segments found for --local-net, for display
+	traceroute bool       // trace the route to hosts with open ports
+	quic       bool       // probe each host for QUIC on UDP 443
+	dtls       bool       // probe each host's DTLS ports
+	vpn        bool       // probe each host's IKE and OpenVPN ports
+	udpProbes  []string   // the --udp probes to make of each host
+	ot         bool       // identify industrial devices
+	otSafe     bool       // one host at a time, --delay between requests
+	containers bool       // check Docker, Kubernetes and etcd ports
+	tcpProbes  []string   // the --tcp probes to make of open ports
+	instances  bool       // check for open Elasticsearch, Kibana and Prometheus
+	endpoints  bool       // classify web ports as gRPC, WebSocket or HTTP
+	honeypots  bool       // look for the signs of honeypots and tarpits
+	// cdnNames holds the hostnames among the targets that resolve into a
+	// CDN's edge, and the CDN.
+	cdnNames map[string]string
+	// skipCDN probes targets behind a CDN on the edge's ports alone, for
+	// --skip-cdn; cdnSkipped counts the probes that saves.
+	skipCDN    bool
+	cdnSkipped int
+	// knock is the --knock sequence made on each host before it is
+	// probed, --knock-delay apart.
+	knock      []knock
+	knockDelay time.Duration
+	// payloads are the --payload bytes sent to open ports after the scan.
+	payloads []portPayload
+	// scripts are the --script scripts run against open ports after the
+	// scan.
+	scripts []*portScript
+	// plugins are the --plugin probes and output formats loaded.
+	plugins []*wasmPlugin
+	// tags are the --tag labels the reports carry.
+	tags map[string]string
+	// states are the --state port states the reports list.
+	states []string
+	// stats makes run tally how the probes of each host ended, for
+	// --stats.
+	stats bool
+	// retries is how many more times a probe that times out is made, for
+	// --retries.
+	retries int
+	// force keeps probing hosts that appear down, for --force.
+	force bool
+	// portTimeouts override timeout for connects to some ports, for
+	// --port-timeout.
+	portTimeouts map[int]time.Duration
+	// calibratePort, if set, is the port timed on each host to pick its
+	// timeout, between minTimeout and maxTimeout, for --calibrate-port.
+	calibratePort int
+	minTimeout    time.Duration
+	maxTimeout    time.Duration
+	// snmpCommunity, for --snmp-harvest, is the community routers and
+	// switches among the hosts are asked with; scope bounds the hosts
+	// learned from them.
+	snmpCommunity string
+	scope         *scanScope
+	// follow is how many rounds of the hosts the results point to are
+	// scanned, for --follow.
+	follow int
+	// certNames reads the names in the certificates of open TLS ports,
+	// for --cert-names.
+	certNames bool
+	// vhosts are the names of the --vhosts file.
+	vhosts     []string
+	vhostsPath string
+	// screenshots is the --screenshots directory, and screenshotCmd the
+	// command that takes one, with {url} and {file} placeholders.
+	screenshots   string
+	screenshotCmd []string
+	// httpPaths are the paths of --http-paths.
+	httpPaths []string
+	// safeChecks makes the --safe-checks of open web ports.
+	safeChecks bool
+	// errLog records the probes that did not find their port open, for
+	// --errors-file.
+	errLog *errorLog
+	// tracker records the probes done, for --checkpoint.
+	tracker *probeTracker
+	// severity are the rules of --severity, and severityPath where they
+	// came from.
+	severity     []severityRule
+	severityPath string
+	// hostOrder is the --host-order of the reports' hosts: "ip" sorts
+	// them by address, "risk" by their most severe port or finding, and
+	// anything else keeps the target order.
+	hostOrder string
+	// inferFirewall makes run tally how closed ports refused, for
+	// --infer-firewall.
+	inferFirewall bool
+	// prefer is the --prefer address family policy for hostnames: ipv4,
+	// ipv6, both, or empty to let the dialer pick.
+	prefer string
+	// dnsCache is the --dns-cache file that keeps lookups across runs.
+	dnsCache string
+	// dns, when set, is the cache the first run looks hostnames up in,
+	// shared with the checks made before the scan. Other runs, such as
+	// those of --watch, start from the file again.
+	dns    *dnsCache
+	routes []string // the config's routes when they apply, for display
+}
+
+func (p *scanPlan) probes() int {
+	if p.hostPorts == nil {
+		n := p.numTargets
+		if p.prefer == preferBoth {
+			// Hostnames are probed once over each family.
+			for _, t := range p.targets {
+				if t.name != "" {
+					n++
+				}
+			}
+		}
+		return n*len(p.ports) - p.cdnSkipped
+	}
+	n := 0
+	for _, ports := range p.hostPorts {
+		n += len(ports)
+	}
+	return n - p.cdnSkipped
+}
+
+// portsFor returns the sorted ports to probe on host.
+func (p *scanPlan) portsFor(host string) []int {
+	if p.skipCDN && p.cdnFor(host) != "" {
+		return edgePorts(p.allPortsFor(host))
+	}
+	return p.allPortsFor(host)
+}
+
+// allPortsFor returns the sorted ports of host the scan asks for, before
+// --skip-cdn leaves out those of a CDN's edge.
+func (p *scanPlan) allPortsFor(host string) []int {
+	if p.hostPorts == nil {
+		return p.ports
+	}
+	return p.hostPorts[host]
+}
+
+// job is a single host:port probe.
+type job struct {
+	host   string
+	port   int
+	family string // for --prefer; empty lets the dialer pick
+	failed bool   // set on results for probes that ended in a proxyError
+	// refusal, set on results for --infer-firewall, tells how a probe of
+	// a port that is not open ended.
+	refusal refusal
+	// latency and errored are set on results for --stats: how long the
+	// probe took, and whether it failed for neither a proxy's reason nor
+	// the network's, such as running out of file descriptors.
+	latency time.Duration
+	errored bool
+	// attempts counts the probes of the port, for --retries.
+	attempts int
+	// dialErr, set on results, is why the connection failed.
+	dialErr dialFailure
+	// timeout, if set, is the host's connect timeout from --calibrate-port.
+	timeout time.Duration
+}
+
+// key identifies the host and family of j in the results.
+func (j job) key() string { return hostKey(j.host, j.family) }
+
+// hostBudget enforces --host-timeout: the clock for a host starts at its
+// first probe, and later probes are skipped once the budget is spent.
+type hostBudget struct {
+	limit   time.Duration
+	mu      sync.Mutex
+	start   map[string]time.Time
+	expired map[string]bool
+}
+
+func newHostBudget(limit time.Duration) *hostBudget {
+	return &hostBudget{limit: limit, start: make(map[string]time.Time), expired: make(map[string]bool)}
+}
+
+// allow reports whether host may still be probed.
+func (b *hostBudget) allow(host string) bool {
+	if b.limit <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	start, ok := b.start[host]
+	if !ok {
+		b.start[host] = time.Now()
+		return true
+	}
+	if time.Since(start) > b.limit {
+		b.expired[host] = true
+		return false
+	}
+	return true
+}
+
+func (b *hostBudget) timedOut(host string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.expired[host]
+}
+
+// scanHooks observes and steers a running scan. Any field may be nil.
+type scanHooks struct {
+	// found is called from a single goroutine for each open port as soon as
+	// it is seen.
+	found func(host string, r PortResult)
+	// probed is called from the workers after each job, including jobs
+	// skipped because of --host-timeout or cancellation.
+	probed func()
+	// pause, when set, can hold the workers between probes.
+	pause *pauser
+	// failed is called from the workers for each probe that failed at the
+	// proxy, with the error.
+	failed func(host string, port int, err error)
+}
+
+// pauser lets a caller pause and resume the workers of a scan. In-flight
+// probes finish; no new ones start while paused.
+type pauser struct {
+	mu     sync.Mutex
+	paused bool
+	resume chan struct{} // closed when paused is cleared
+}
+
+func (p *pauser) set(paused bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if paused == p.paused {
+		return
+	}
+	p.paused = paused
+	if paused {
+		p.resume = make(chan struct{})
+	} else {
+		close(p.resume)
+	}
+}
+
+func (p *pauser) isPaused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused
+}
+
+// wait blocks while the scan is paused or until ctx is done.
+func (p *pauser) wait(ctx context.Context) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	paused, resume := p.paused, p.resume
+	p.mu.Unlock()
+	if paused {
+		select {
+		case <-resume:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// timeoutFor is how long the connect of j may take: its port's
+// --port-timeout, or else its host's calibrated timeout, or else --timeout.
+func (p *scanPlan) timeoutFor(j job) time.Duration {
+	if t, ok := p.portTimeouts[j.port]; ok {
+		return t
+	}
+	if j.timeout > 0 {
+		return j.timeout
+	}
+	return p.timeout
+}
+
+// dial probes addr once over network, bounded by timeout.
+func (p *scanPlan) dial(ctx context.Context, network, addr string, timeout time.Duration) (net.Conn, error) {
+	if p.proxy == nil && p.source == nil {
+		d := net.Dialer{Timeout: timeout}
+		return d.DialContext(ctx, network, addr)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if p.proxy == nil {
+		return p.source.DialContext(ctx, network, addr)
+	}
+	return p.proxy.DialContext(ctx, network, addr)
+}
+
+// fallbackDelay is how long a hostname's preferred address family gets
+// before the other is dialed as well (RFC 8305).
+const fallbackDelay = 300 * time.Millisecond
+
+// probe dials j once. A hostname is looked up in dns, unless a proxy
+// resolves it, and its first address of each family is dialed the way the
+// dialer would: the family of the first address, then the other as well
+// if that has not connected within fallbackDelay.
+func (p *scanPlan) probe(ctx context.Context, dns *dnsCache, j job) (net.Conn, error) {
+	port := uint16(j.port)
+	timeout := p.timeoutFor(j)
+	if _, err := netip.ParseAddr(j.host); err == nil || p.proxy != nil || dns == nil {
+		return p.dial(ctx, dialNetwork(j.family), net.JoinHostPort(j.host, strconv.Itoa(j.port)), timeout)
+	}
+	addrs, err := dns.resolve(ctx, j.host)
+	if err != nil {
+		// Not wrapped: a failed lookup says nothing about the port.
+		return nil, fmt.Errorf("lookup %s: %v", j.host, err)
+	}
+	var primary, fallback netip.Addr
+	for _, a := range addrs {
+		switch {
+		case j.family == familyIPv4 && !a.Is4(), j.family == familyIPv6 && !a.Is6():
+		case !primary.IsValid():
+			primary = a
+		case j.family == "" && !fallback.IsValid() && a.Is4() != primary.Is4():
+			fallback = a
+		}
+	}
+	if !primary.IsValid() {
+		return nil, fmt.Errorf("%s has no %s address", j.host, j.family)
+	}
+	if !fallback.IsValid() {
+		return p.dial(ctx, "tcp", netip.AddrPortFrom(primary, port).String(), timeout)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type dialed struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan dialed, 2)
+	start := func(a netip.Addr) {
+		go func() {
+			conn, err := p.dial(ctx, "tcp", netip.AddrPortFrom(a, port).String(), timeout)
+			results <- dialed{conn, err}
+		}()
+	}
+	start(primary)
+	timer := time.NewTimer(fallbackDelay)
+	defer timer.Stop()
+	pending, fellBack := 1, false
+	for {
+		select {
+		case <-timer.C:
+			if !fellBack {
+				start(fallback)
+				pending, fellBack = pending+1, true
+			}
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				if pending > 0 {
+					go func() {
+						if r := <-results; r.conn != nil {
+							r.conn.Close()
+						}
+					}()
+				}
+				return r.conn, nil
+			}
+			if !fellBack {
+				start(fallback)
+				pending, fellBack = pending+1, true
+			} else if pending == 0 {
+				// The later error is the one of the family that got
+				// further, such as a refusal after an unreachable route.
+				return nil, r.err
+			}
+		}
+	}
+}
+
+func (p *scanPlan) worker(ctx context.Context, jobs <-chan job, results chan<- job, budget *hostBudget, down *downGuard, dns *dnsCache, knocks *knocker, calib *calibrator, hooks scanHooks, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for j := range jobs {
+		hooks.pause.wait(ctx)
+		knocks.before(ctx, j)
+		calib.before(ctx, &j)
+		switch {
+		case ctx.Err() != nil:
+		case !budget.allow(j.key()):
+			p.errLog.skipped(j, probeErrSkipped, "host timeout reached")
+		case !down.allow(j.key()):
+			p.errLog.skipped(j, probeErrSkipped, "host appears down")
+		default:
+			conn, err := p.attempt(ctx, dns, budget, &j)
+			var perr *proxyError
+			if !errors.As(err, &perr) && ctx.Err() == nil {
+				down.record(j, classifyDial(err))
+			}
+			switch {
+			case err == nil:
+				if tc, ok := conn.(*net.TCPConn); ok && p.source != nil && p.source.port != 0 {
+					// A reset instead of FIN leaves no TIME_WAIT to block
+					// the next probe from the same source port.
+					tc.SetLinger(0)
+				}
+				_ = conn.Close()
+				results <- j // send only open ports and failures
+			case errors.As(err, &perr) && ctx.Err() == nil:
+				if hooks.failed != nil {
+					hooks.failed(j.host, j.port, err)
+				}
+				j.failed = true
+				results <- j
+			case ctx.Err() == nil:
+				j.dialErr = classifyDial(err)
+				if p.inferFirewall || p.stats || p.listsState("closed") || p.listsState("filtered") {
+					j.refusal = classifyRefusal(err)
+					j.errored = j.refusal == notRefused
+				}
+				results <- j
+			}
+			if p.delay > 0 {
+				select {
+				case <-time.After(p.delay):
+				case <-ctx.Done():
+				}
+			}
+		}
+		if ctx.Err() == nil {
+			p.tracker.add(j)
+		}
+		if hooks.probed != nil {
+			hooks.probed()
+		}
+	}
+}
+
+// maxRetries bounds --retries.
+const maxRetries = 10
+
+// attempt probes j, again up to --retries times while the probes time out,
+// so that a port is reported once, in the state of its last probe. It
+// records the probes made, and the time the last one took for --stats.
+func (p *scanPlan) attempt(ctx context.Context, dns *dnsCache, budget *hostBudget, j *job) (net.Conn, error) {
+	for {
+		j.attempts++
+		start := time.Now()
+		conn, err := p.probe(ctx, dns, *j)
+		if p.stats {
+			j.latency = time.Since(start)
+		}
+		if err != nil {
+			p.errLog.failed(ctx, *j, err)
+		}
+		var perr *proxyError
+		if err == nil || j.attempts > p.retries || ctx.Err() != nil || errors.As(err, &perr) || classifyRefusal(err) != refusedSilent {
+			return conn, err
+		}
+		if p.delay > 0 {
+			select {
+			case <-time.After(p.delay):
+			case <-ctx.Done():
+				return nil, err
+			}
+		}
+		if !budget.allow(j.key()) {
+			return nil, err
+		}
+	}
+}
+
+// run executes the plan and returns one HostResult per target, in target
+// order, with open ports sorted numerically. Cancelling ctx stops the scan
+// early; the results found so far are still returned.
+func (p *scanPlan) run(ctx context.Context, hooks scanHooks) []HostResult {
+	if p.coord != nil {
+		return p.coord.run(ctx, p, hooks)
+	}
+	jobsCh := make(chan job, p.workers)
+	resultsCh := make(chan job)
+	var wg sync.WaitGroup
+	budget := newHostBudget(p.hostTimeout)
+	down := newDownGuard(p.force)
+	dns := p.dns
+	if dns == nil {
+		dns = newDNSCache(p.dnsCache)
+	}
+	p.dns = nil
+	defer func() {
+		if err := dns.save(); err != nil {
+			slog.Warn("saving DNS cache failed", "err", err)
+		}
+	}()
+
+	unresolved := p.lookUpTargets(ctx, dns)
+	knocks := newKnocker(p, dns)
+	calib := newCalibrator(p, dns)
+	for i := 0; i < p.workers; i++ {
+		wg.Add(1)
+		go p.worker(ctx, jobsCh, resultsCh, budget, down, dns, knocks, calib, hooks, &wg)
+	}
+
+	go func() {
+		wg.Wait()
+		close(resultsCh)
+	}()
+
+	go func() {
+		defer close(jobsCh)
+		p.targets.each(func(h string) bool {
+			for _, family := range p.families(h) {
+				if err := unresolved[h]; err != nil {
+					for _, port := range p.portsFor(h) {
+						j := job{host: h, port: port, family: family}
+						p.errLog.skipped(j, probeErrLookup, err.Error())
+						p.tracker.add(j)
+						if hooks.probed != nil {
+							hooks.probed()
+						}
+					}
+					continue
+				}
+				for _, port := range p.portsFor(h) {
+					select {
+					case jobsCh <- job{host: h, port: port, family: family}:
+					case <-ctx.Done():
+						return false
+					}
+				}
+			}
+			return true
+		})
+	}()
+
+	open := make(map[string]map[int]int) // the probes it took, by host key and port
+	failed := make(map[string]int)
+	refusals := make(map[string]*refusalCounts)
+	notOpen := make(map[string]map[string][]int) // by host key and state
+	tallies := make(map[string]*hostTally)
+	dialErrs := make(map[string]*DialErrors)
+	for j := range resultsCh {
+		k := j.key()
+		if j.dialErr != dialOK {
+			if dialErrs[k] == nil {
+				dialErrs[k] = new(DialErrors)
+			}
+			dialErrs[k].add(j.dialErr)
+		}
+		if p.stats {
+			if tallies[k] == nil {
+				tallies[k] = new(hostTally)
+			}
+			tallies[k].add(j)
+		}
+		if j.errored {
+			continue
+		}
+		if j.refusal != notRefused {
+			if refusals[k] == nil {
+				refusals[k] = new(refusalCounts)
+			}
+			refusals[k].add(j.refusal)
+			if state := refusalState(j.refusal); p.listsState(state) {
+				if notOpen[k] == nil {
+					notOpen[k] = make(map[string][]int)
+				}
+				notOpen[k][state] = append(notOpen[k][state], j.port)
+			}
+			continue
+		}
+		if j.failed {
+			failed[k]++
+			continue
+		}
+		if j.dialErr != dialOK {
+			continue
+		}
+		if open[k] == nil {
+			open[k] = make(map[int]int)
+		}
+		open[k][j.port] = j.attempts
+		if hooks.found != nil {
+			hooks.found(j.host, tcpOpen(j.port, j.attempts))
+		}
+	}
+
+	hosts := p.results(open, failed, budget.timedOut)
+	if p.inferFirewall {
+		for i := range hosts {
+			var c refusalCounts
+			if r := refusals[hostKey(hosts[i].Host, hosts[i].Family)]; r != nil {
+				c = *r
+			}
+			hosts[i].Firewall = inferFirewall(c, hosts[i].Ports)
+		}
+	}
+	for i := range hosts {
+		byState := notOpen[hostKey(hosts[i].Host, hosts[i].Family)]
+		slices.Sort(byState["closed"])
+		slices.Sort(byState["filtered"])
+		hosts[i].Closed = formatPorts(byState["closed"])
+		hosts[i].Filtered = formatPorts(byState["filtered"])
+		hosts[i].DialErrors = dialErrs[hostKey(hosts[i].Host, hosts[i].Family)]
+		hosts[i].Down = down.isDown(hostKey(hosts[i].Host, hosts[i].Family))
+		hosts[i].Calibration = calib.result(hostKey(hosts[i].Host, hosts[i].Family))
+		if err := unresolved[hosts[i].Host]; err != nil {
+			hosts[i].LookupError = err.Error()
+		}
+		if p.stats {
+			t := tallies[hostKey(hosts[i].Host, hosts[i].Family)]
+			if t == nil {
+				t = new(hostTally)
+			}
+			hosts[i].Stats = t.stats()
+		}
+	}
+	p.readCertNames(ctx, dns, hosts)
+	hosts = p.expand(ctx, dns, hooks, hosts)
+	p.crossReference(ctx, dns, hosts)
+	return hosts
+}
+
+// lookUpTargets looks up the hostnames among the targets before the scan
+// starts, all at once, so that a slow or broken resolver does not hold up
+// the workers probe by probe. It warns of the names that fail, which are
+// then not scanned, and returns their errors. With a proxy the names are
+// its to resolve.
+func (p *scanPlan) lookUpTargets(ctx context.Context, dns *dnsCache) map[string]error {
+	if p.proxy != nil {
+		return nil
+	}
+	var names []string
+	p.targets.walk(func(name string, _ netip.Addr) bool {
+		if name != "" {
+			names = append(names, name)
+		}
+		return true
+	})
+	if len(names) == 0 {
+		return nil
+	}
+	failed := dns.prefetch(ctx, names)
+	for _, name := range names {
+		if err := failed[name]; err != nil {
+			slog.Warn("lookup failed; not scanning host", "host", name, "err", err)
+		}
+	}
+	return failed
+}
+
+// listsState says whether the reports list ports in state, for --state;
+// by default they list the open ones only.
+func (p *scanPlan) listsState(state string) bool {
+	if p.states == nil {
+		return state == "open"
+	}
+	return slices.Contains(p.states, state)
+}
+
+// tcpOpen is the result for an open TCP port found after attempts probes.
+func tcpOpen(port, attempts int) PortResult {
+	pr := PortResult{Port: port, Protocol: "tcp", State: "open"}
+	if attempts > 1 {
+		pr.Attempts = attempts
+	}
+	return pr
+}
+
+// results builds one HostResult per target and family, in target order,
+// from the open ports, with the probes each took, and probe error counts
+// keyed by hostKey.
+func (p *scanPlan) results(open map[string]map[int]int, failed map[string]int, timedOut func(string) bool) []HostResult {
+	hosts := make([]HostResult, 0, p.numTargets)
+	p.targets.each(func(h string) bool {
+		for _, family := range p.families(h) {
+			k := hostKey(h, family)
+			hr := HostResult{Host: h, Family: family, Ports: []PortResult{}, TimedOut: timedOut(k), ProbeErrors: failed[k], CDN: p.cdnFor(h)}
+			if p.hostPorts != nil || p.skipCDN && hr.CDN != "" {
+				hr.Probed = formatPorts(p.portsFor(h))
+			}
+			// The ports are sorted, so walking them keeps the output ordered.
+			for _, port := range p.portsFor(h) {
+				if n := open[k][port]; n > 0 {
+					hr.Ports = append(hr.Ports, tcpOpen(port, n))
+				}
+			}
+			hosts = append(hosts, hr)
+		}
+		return true
+	})
+	return hosts
+}
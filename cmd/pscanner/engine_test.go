@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPauser(t *testing.T) {
+	var p pauser
+	p.wait(context.Background()) // not paused: returns at once
+
+	p.set(true)
+	done := make(chan struct{})
+	go func() {
+		p.wait(context.Background())
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("wait returned while paused")
+	case <-time.After(20 * time.Millisecond):
+	}
+	p.set(false)
+	<-done
+
+	p.set(true)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p.wait(ctx) // a canceled scan must not stay paused
+}
+
+func TestRunReportsOpenPorts(t *testing.T) {
+	port := localPort(t)
+	targets, _ := parseTargets("127.0.0.1")
+	plan := &scanPlan{targets: targets, numTargets: 1, ports: []int{port}, workers: 1, timeout: time.Second}
+	var found []int
+	probed := 0
+	hosts := plan.run(context.Background(), scanHooks{
+		found:  func(_ string, r PortResult) { found = append(found, r.Port) },
+		probed: func() { probed++ },
+	})
+	if len(hosts) != 1 || len(hosts[0].Ports) != 1 || hosts[0].Ports[0].Port != port {
+		t.Errorf("run = %+v", hosts)
+	}
+	if len(found) != 1 || probed != 1 {
+		t.Errorf("hooks saw found=%v probed=%d", found, probed)
+	}
+}
+
+func TestRunPreferBoth(t *testing.T) {
+	port := localPort(t) // listens on 127.0.0.1 only
+	targets, _ := parseTargets("localhost,127.0.0.1")
+	plan := &scanPlan{targets: targets, numTargets: 2, ports: []int{port}, workers: 2, timeout: time.Second, prefer: preferBoth}
+	if n := plan.probes(); n != 3 {
+		t.Errorf("probes = %d, want 3", n)
+	}
+	hosts := plan.run(context.Background(), scanHooks{})
+	if len(hosts) != 3 {
+		t.Fatalf("run = %+v", hosts)
+	}
+	for i, want := range []struct {
+		family string
+		open   int
+	}{{familyIPv4, 1}, {familyIPv6, 0}, {"", 1}} {
+		if h := hosts[i]; h.Family != want.family || len(h.Ports) != want.open {
+			t.Errorf("hosts[%d] = %+v, want family %q with %d open", i, h, want.family, want.open)
+		}
+	}
+}
+
+// lossyDialer times out the first drops dials of each address, then
+// connects.
+type lossyDialer struct {
+	drops int
+	mu    sync.Mutex
+	dials map[string]int
+}
+
+func (d *lossyDialer) DialContext(_ context.Context, _, addr string) (net.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dials[addr]++
+	if d.dials[addr] <= d.drops {
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded}
+	}
+	c, _ := net.Pipe()
+	return c, nil
+}
+
+func TestRunRetries(t *testing.T) {
+	targets, _ := parseTargets("192.0.2.1")
+	d := &lossyDialer{drops: 1, dials: make(map[string]int)}
+	plan := &scanPlan{targets: targets, numTargets: 1, ports: []int{22, 80}, workers: 2, timeout: time.Second, proxy: d, retries: 2}
+	var found []PortResult
+	var mu sync.Mutex
+	hosts := plan.run(context.Background(), scanHooks{found: func(_ string, r PortResult) {
+		mu.Lock()
+		found = append(found, r)
+		mu.Unlock()
+	}})
+	want := []PortResult{{Port: 22, Protocol: "tcp", State: "open", Attempts: 2}, {Port: 80, Protocol: "tcp", State: "open", Attempts: 2}}
+	if len(hosts) != 1 || len(hosts[0].Ports) != 2 || hosts[0].Ports[0] != want[0] || hosts[0].Ports[1] != want[1] {
+		t.Errorf("run = %+v, want each port once after 2 attempts", hosts)
+	}
+	if len(found) != 2 {
+		t.Errorf("found hook saw %+v, want each port once", found)
+	}
+
+	r := newReport(plan, "", time.Now(), hosts, false)
+	var b strings.Builder
+	if err := writeText(&b, r); err != nil {
+		t.Fatal(err)
+	}
+	if out := b.String(); !strings.Contains(out, "Retries: 2\n") || !strings.Contains(out, "  22 (after 2 attempts)\n") {
+		t.Errorf("text report:\n%s", out)
+	}
+
+	// Without retries the timed-out probes find nothing.
+	d = &lossyDialer{drops: 1, dials: make(map[string]int)}
+	plan.proxy, plan.retries = d, 0
+	if hosts := plan.run(context.Background(), scanHooks{}); len(hosts[0].Ports) != 0 {
+		t.Errorf("run without retries = %+v", hosts)
+	}
+	// Nor with too few.
+	d = &lossyDialer{drops: 3, dials: make(map[string]int)}
+	plan.proxy, plan.retries = d, 2
+	if hosts := plan.run(context.Background(), scanHooks{}); len(hosts[0].Ports) != 0 || d.dials["192.0.2.1:22"] != 3 {
+		t.Errorf("run with 2 retries of 3 drops = %+v after %v", hosts, d.dials)
+	}
+}
+
+// deadlineDialer records how long each dial had, by address, and fails it.
+type deadlineDialer struct {
+	mu   sync.Mutex
+	left map[string]time.Duration
+}
+
+func (d *deadlineDialer) DialContext(ctx context.Context, _, addr string) (net.Conn, error) {
+	deadline, _ := ctx.Deadline()
+	d.mu.Lock()
+	d.left[addr] = time.Until(deadline)
+	d.mu.Unlock()
+	return nil, &net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded}
+}
+
+func TestRunPortTimeouts(t *testing.T) {
+	targets, _ := parseTargets("192.0.2.1")
+	d := &deadlineDialer{left: make(map[string]time.Duration)}
+	plan := &scanPlan{targets: targets, numTargets: 1, ports: []int{22, 25}, workers: 2, timeout: time.Second, proxy: d,
+		portTimeouts: map[int]time.Duration{25: 3 * time.Second}}
+	plan.run(context.Background(), scanHooks{})
+	if left := d.left["192.0.2.1:22"]; left > time.Second || left < time.Second/2 {
+		t.Errorf("port 22 had %s, want --timeout", left)
+	}
+	if left := d.left["192.0.2.1:25"]; left > 3*time.Second || left <= time.Second {
+		t.Errorf("port 25 had %s, want its --port-timeout", left)
+	}
+}
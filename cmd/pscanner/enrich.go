@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ThirdPartyInfo is what an external service such as Shodan already knows
+// about a host. It is not verified by the scan and is reported apart from
+// the scan's own findings.
+type ThirdPartyInfo struct {
+	Source    string         `json:"source"` // "shodan" or "censys"
+	Org       string         `json:"org,omitempty"`
+	ASN       string         `json:"asn,omitempty"`
+	Hostnames []string       `json:"hostnames,omitempty"`
+	Services  []KnownService `json:"services,omitempty"`
+	Tags      []string       `json:"tags,omitempty"`
+	Updated   string         `json:"updated,omitempty"` // when the source last saw the host, as it reports it
+}
+
+// KnownService is a port the third party has seen open.
+type KnownService struct {
+	Port      int    `json:"port"`
+	Transport string `json:"transport,omitempty"`
+	Name      string `json:"name,omitempty"`
+}
+
+// enrichSources are the values accepted by --enrich.
+var enrichSources = []string{"shodan", "censys"}
+
+// maxEnrichHosts bounds the lookups of one run, as the services meter
+// their APIs by the query.
+const maxEnrichHosts = 256
+
+// enricher looks hosts up in one external service. lookup returns nil and
+// no error when the service knows nothing about the address.
+type enricher interface {
+	source() string
+	lookup(ctx context.Context, a netip.Addr) (*ThirdPartyInfo, error)
+}
+
+// enrichment annotates the public addresses among the results with what
+// the --enrich services know about them and, for --whois, with their
+// network's registration. Answers are kept for the life of the process, so
+// --watch runs do not repeat the queries.
+type enrichment struct {
+	sources []enricher
+	cache   map[string]map[netip.Addr]*ThirdPartyInfo
+	whois   *rdapClient // nil without --whois
+}
+
+// newEnrichment builds the --enrich sources and the --whois client. The
+// keys come from the environment unless shodanKey is given.
+func newEnrichment(spec, shodanKey string, whois bool) (*enrichment, error) {
+	e := &enrichment{cache: make(map[string]map[netip.Addr]*ThirdPartyInfo)}
+	if whois {
+		e.whois = newRDAPClient()
+	}
+	for _, s := range strings.Split(spec, ",") {
+		switch s = strings.TrimSpace(s); s {
+		case "":
+			continue
+		case "shodan":
+			if shodanKey == "" {
+				shodanKey = os.Getenv("PSCANNER_SHODAN_KEY")
+			}
+			if shodanKey == "" {
+				return nil, errors.New("--enrich shodan needs --shodan-key or $PSCANNER_SHODAN_KEY")
+			}
+			e.sources = append(e.sources, &shodanClient{base: "https://api.shodan.io", key: shodanKey, client: &http.Client{Timeout: 30 * time.Second}})
+		case "censys":
+			id, secret := os.Getenv("CENSYS_API_ID"), os.Getenv("CENSYS_API_SECRET")
+			if id == "" || secret == "" {
+				return nil, errors.New("--enrich censys needs $CENSYS_API_ID and $CENSYS_API_SECRET")
+			}
+			e.sources = append(e.sources, &censysClient{base: "https://search.censys.io", id: id, secret: secret, client: &http.Client{Timeout: 30 * time.Second}})
+		default:
+			return nil, fmt.Errorf("unknown --enrich source %q (want %s)", s, strings.Join(enrichSources, ", "))
+		}
+	}
+	if len(e.sources) == 0 && e.whois == nil {
+		return nil, nil
+	}
+	return e, nil
+}
+
+// apply adds the registration and third-party data to every host that is
+// a public IP address. Failed lookups are reported on stderr and leave the
+// results as they are; a source that fails is not asked again during this
+// run.
+func (e *enrichment) apply(ctx context.Context, hosts []HostResult) {
+	if e == nil {
+		return
+	}
+	if e.whois != nil {
+		e.applyWhois(ctx, hosts)
+	}
+	failed := make(map[string]bool)
+	n := 0
+	for i := range hosts {
+		a, err := netip.ParseAddr(hosts[i].Host)
+		if err != nil || !isPublicAddr(a) {
+			continue
+		}
+		if n++; n > maxEnrichHosts {
+			slog.Warn("enrich: looked up the first public hosts only", "hosts", maxEnrichHosts)
+			return
+		}
+		for _, src := range e.sources {
+			name := src.source()
+			if failed[name] {
+				continue
+			}
+			info, ok := e.cache[name][a]
+			if !ok {
+				if info, err = src.lookup(ctx, a); err != nil {
+					slog.Warn("enrich lookup failed", "source", name, "err", err)
+					failed[name] = true
+					continue
+				}
+				if e.cache[name] == nil {
+					e.cache[name] = make(map[netip.Addr]*ThirdPartyInfo)
+				}
+				e.cache[name][a] = info
+			}
+			if info != nil {
+				hosts[i].ThirdParty = append(hosts[i].ThirdParty, *info)
+			}
+		}
+	}
+}
+
+func (e *enrichment) applyWhois(ctx context.Context, hosts []HostResult) {
+	e.whois.lookups = 0
+	for i := range hosts {
+		a, err := netip.ParseAddr(hosts[i].Host)
+		if err != nil || !isPublicAddr(a) {
+			continue
+		}
+		if _, ok := e.whois.cached(a); !ok && e.whois.lookups >= maxRDAPLookups {
+			slog.Warn("whois: stopped looking up", "lookups", maxRDAPLookups)
+			return
+		}
+		info, err := e.whois.lookup(ctx, a)
+		if err != nil {
+			slog.Warn("whois lookup failed", "address", a, "err", err)
+			return
+		}
+		hosts[i].Whois = info
+	}
+}
+
+// getJSON fetches u into v. A 404 answer, the services' way of saying they
+// have no data on a host, returns false and no error.
+func getJSON(ctx context.Context, client *http.Client, u string, auth func(*http.Request), v any) (bool, error) {
+	body, err := retryRequest(client, webhookAttempts, webhookBackoff, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/json")
+		if auth != nil {
+			auth(req)
+		}
+		return req, nil
+	})
+	var se *statusError
+	if errors.As(err, &se) && se.code == http.StatusNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return false, fmt.Errorf("unexpected response: %v", err)
+	}
+	return true, nil
+}
+
+// shodanClient queries the Shodan host API.
+type shodanClient struct {
+	base   string
+	key    string
+	client *http.Client
+}
+
+func (c *shodanClient) source() string { return "shodan" }
+
+func (c *shodanClient) lookup(ctx context.Context, a netip.Addr) (*ThirdPartyInfo, error) {
+	var host struct {
+		Org        string   `json:"org"`
+		ISP        string   `json:"isp"`
+		ASN        string   `json:"asn"`
+		Hostnames  []string `json:"hostnames"`
+		Ports      []int    `json:"ports"`
+		Tags       []string `json:"tags"`
+		LastUpdate string   `json:"last_update"`
+	}
+	u := c.base + "/shodan/host/" + url.PathEscape(a.String()) + "?minify=true&key=" + url.QueryEscape(c.key)
+	ok, err := getJSON(ctx, c.client, u, nil, &host)
+	if err != nil || !ok {
+		// The key is part of the URL, so keep it out of the message.
+		return nil, redactKey(err, c.key)
+	}
+	info := &ThirdPartyInfo{Source: "shodan", Org: host.Org, ASN: host.ASN, Hostnames: host.Hostnames, Tags: host.Tags, Updated: host.LastUpdate}
+	if info.Org == "" {
+		info.Org = host.ISP
+	}
+	slices.Sort(host.Ports)
+	for _, p := range host.Ports {
+		info.Services = append(info.Services, KnownService{Port: p})
+	}
+	return info, nil
+}
+
+// redactKey removes key from the text of err.
+func redactKey(err error, key string) error {
+	if err == nil || !strings.Contains(err.Error(), key) {
+		return err
+	}
+	return errors.New(strings.ReplaceAll(err.Error(), key, "REDACTED"))
+}
+
+// censysClient queries the Censys Search 2.0 host API.
+type censysClient struct {
+	base       string
+	id, secret string
+	client     *http.Client
+}
+
+func (c *censysClient) source() string { return "censys" }
+
+func (c *censysClient) lookup(ctx context.Context, a netip.Addr) (*ThirdPartyInfo, error) {
+	var resp struct {
+		Result struct {
+			Services []struct {
+				Port      int    `json:"port"`
+				Name      string `json:"service_name"`
+				Transport string `json:"transport_protocol"`
+			} `json:"services"`
+			AS struct {
+				ASN  int    `json:"asn"`
+				Name string `json:"name"`
+			} `json:"autonomous_system"`
+			DNS struct {
+				Names []string `json:"names"`
+			} `json:"dns"`
+			Labels  []string `json:"labels"`
+			Updated string   `json:"last_updated_at"`
+		} `json:"result"`
+	}
+	u := c.base + "/api/v2/hosts/" + url.PathEscape(a.String())
+	ok, err := getJSON(ctx, c.client, u, func(req *http.Request) { req.SetBasicAuth(c.id, c.secret) }, &resp)
+	if err != nil || !ok {
+		return nil, err
+	}
+	r := resp.Result
+	info := &ThirdPartyInfo{Source: "censys", Org: r.AS.Name, Hostnames: r.DNS.Names, Tags: r.Labels, Updated: r.Updated}
+	if r.AS.ASN != 0 {
+		info.ASN = fmt.Sprintf("AS%d", r.AS.ASN)
+	}
+	for _, s := range r.Services {
+		info.Services = append(info.Services, KnownService{Port: s.Port, Transport: strings.ToLower(s.Transport), Name: strings.ToLower(s.Name)})
+	}
+	return info, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestShodanLookup(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if r.URL.Query().Get("key") != "k3y" {
+			http.Error(w, `{"error": "invalid key"}`, http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/shodan/host/8.8.8.8" {
+			http.Error(w, `{"error": "No information available for that IP."}`, http.StatusNotFound)
+			return
+		}
+		io.WriteString(w, `{"ip_str": "8.8.8.8", "org": "Google LLC", "asn": "AS15169", "hostnames": ["dns.google"], "ports": [443, 53], "tags": ["cloud"], "last_update": "2026-10-01T00:00:00.000000"}`)
+	}))
+	defer srv.Close()
+
+	e := &enrichment{
+		sources: []enricher{&shodanClient{base: srv.URL, key: "k3y", client: srv.Client()}},
+		cache:   make(map[string]map[netip.Addr]*ThirdPartyInfo),
+	}
+	hosts := []HostResult{hostWith("8.8.8.8", 443), hostWith("1.1.1.1"), hostWith("10.0.0.1", 22), hostWith("example.com", 80), hostWith("8.8.8.8")}
+	e.apply(context.Background(), hosts)
+
+	tp := hosts[0].ThirdParty
+	if len(tp) != 1 || tp[0].Source != "shodan" || tp[0].Org != "Google LLC" || len(tp[0].Services) != 2 || tp[0].Services[0].Port != 53 {
+		t.Errorf("third party data = %+v", tp)
+	}
+	if len(hosts[1].ThirdParty) != 0 || len(hosts[2].ThirdParty) != 0 || len(hosts[3].ThirdParty) != 0 {
+		t.Errorf("unexpected data for unknown, private or named hosts: %+v", hosts[1:4])
+	}
+	if len(hosts[4].ThirdParty) != 1 {
+		t.Errorf("cached answer not applied: %+v", hosts[4])
+	}
+	// Private addresses and names are never sent, and 8.8.8.8 is asked once.
+	if strings.Join(paths, " ") != "/shodan/host/8.8.8.8 /shodan/host/1.1.1.1" {
+		t.Errorf("requests = %v", paths)
+	}
+
+	_, err := (&shodanClient{base: srv.URL, key: "wrong", client: srv.Client()}).lookup(context.Background(), netip.MustParseAddr("8.8.8.8"))
+	if err == nil || strings.Contains(err.Error(), "wrong") {
+		t.Errorf("bad key: err = %v", err)
+	}
+}
+
+func TestCensysLookup(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, _ := r.BasicAuth(); id != "id" || secret != "secret" || r.URL.Path != "/api/v2/hosts/2001:db8:1::1" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		io.WriteString(w, `{"code": 200, "status": "OK", "result": {"services": [{"port": 22, "service_name": "SSH", "transport_protocol": "TCP"}], "autonomous_system": {"asn": 64500, "name": "EXAMPLE-AS"}, "labels": ["remote-access"], "last_updated_at": "2026-10-02T10:00:00Z"}}`)
+	}))
+	defer srv.Close()
+
+	c := &censysClient{base: srv.URL, id: "id", secret: "secret", client: srv.Client()}
+	info, err := c.lookup(context.Background(), netip.MustParseAddr("2001:db8:1::1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ASN != "AS64500" || info.Org != "EXAMPLE-AS" || len(info.Services) != 1 || info.Services[0] != (KnownService{Port: 22, Transport: "tcp", Name: "ssh"}) {
+		t.Errorf("info = %+v", info)
+	}
+}
+
+func TestNewEnrichment(t *testing.T) {
+	t.Setenv("PSCANNER_SHODAN_KEY", "")
+	t.Setenv("CENSYS_API_ID", "")
+	if e, err := newEnrichment("", "", false); e != nil || err != nil {
+		t.Errorf("no sources: %v, %v", e, err)
+	}
+	for _, spec := range []string{"shodan", "censys", "greynoise"} {
+		if _, err := newEnrichment(spec, "", false); err == nil {
+			t.Errorf("newEnrichment(%q) succeeded", spec)
+		}
+	}
+	if e, err := newEnrichment("shodan", "k3y", false); err != nil || len(e.sources) != 1 {
+		t.Errorf("shodan with key: %v, %v", e, err)
+	}
+}
+
+func TestWriteThirdParty(t *testing.T) {
+	h := hostWith("8.8.8.8", 53)
+	h.ThirdParty = []ThirdPartyInfo{{Source: "censys", Org: "EXAMPLE-AS", ASN: "AS64500", Services: []KnownService{{Port: 53, Transport: "udp", Name: "dns"}}}}
+	r := testReport("53", h)
+	r.Parameters.TargetCount = 1
+	var buf bytes.Buffer
+	if err := writeText(&buf, r); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Third-party data from censys (not seen by this scan):", "Organization: EXAMPLE-AS AS64500", "Known ports: 53/udp (dns)"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("output lacks %q:\n%s", want, buf.String())
+		}
+	}
+}
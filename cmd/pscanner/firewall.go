@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// refusal is how a probe of a port that is not open ended, for
+// --infer-firewall.
+type refusal int
+
+const (
+	notRefused    refusal = iota
+	refusedRST            // connection refused: a TCP reset, the port is closed
+	refusedICMP           // an ICMP unreachable, typically a firewall's reject
+	refusedSilent         // no answer before --timeout: dropped somewhere
+)
+
+// classifyRefusal maps a dial error to a refusal. Errors that say nothing
+// about the network, such as running out of file descriptors, give
+// notRefused.
+func classifyRefusal(err error) refusal {
+	var ne net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return refusedRST
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH), errors.Is(err, syscall.EACCES):
+		return refusedICMP
+	case errors.Is(err, os.ErrDeadlineExceeded), errors.As(err, &ne) && ne.Timeout():
+		return refusedSilent
+	}
+	return notRefused
+}
+
+// portStates are the states --state can list, in the order reports give
+// them: open, closed (refused with a TCP reset) and filtered (answered by
+// an ICMP unreachable, or not at all).
+var portStates = []string{"open", "closed", "filtered"}
+
+// parseStates reads a --state list into the states it names, in the order
+// of portStates.
+func parseStates(s string) ([]string, error) {
+	want := make(map[string]bool)
+	for _, st := range strings.Split(s, ",") {
+		st = strings.TrimSpace(st)
+		if !slices.Contains(portStates, st) {
+			return nil, fmt.Errorf("unknown --state %q (want open, closed or filtered)", st)
+		}
+		want[st] = true
+	}
+	var states []string
+	for _, st := range portStates {
+		if want[st] {
+			states = append(states, st)
+		}
+	}
+	return states, nil
+}
+
+// refusalState is the --state of a port whose probe ended in r.
+func refusalState(r refusal) string {
+	if r == refusedRST {
+		return "closed"
+	}
+	return "filtered"
+}
+
+// refusalCounts tallies the refusals of one host.
+type refusalCounts struct {
+	closed, rejected, dropped int
+}
+
+func (c *refusalCounts) add(r refusal) {
+	switch r {
+	case refusedRST:
+		c.closed++
+	case refusedICMP:
+		c.rejected++
+	case refusedSilent:
+		c.dropped++
+	}
+}
+
+// FirewallInference sums up how a host's ports answered, for
+// --infer-firewall.
+type FirewallInference struct {
+	Verdict  string `json:"verdict"`
+	Closed   int    `json:"closed"`   // ports that refused with a TCP reset
+	Rejected int    `json:"rejected"` // ports answered by ICMP unreachable
+	Dropped  int    `json:"dropped"`  // ports that did not answer
+}
+
+// inferFirewall describes the filtering in front of a host from the way
+// its probed ports answered. The policy for ports nobody allowed is the
+// most common way closed ports ended; ports that answered otherwise are
+// the exceptions to it. A connect scan cannot tell a stateful filter from
+// a stateless one, so the verdict does not try.
+func inferFirewall(c refusalCounts, open []PortResult) *FirewallInference {
+	f := &FirewallInference{Closed: c.closed, Rejected: c.rejected, Dropped: c.dropped}
+	var permits []string
+	for _, p := range open {
+		permits = append(permits, strconv.Itoa(p.Port))
+	}
+	switch {
+	case c.closed+c.rejected+c.dropped == 0 && len(open) == 0:
+		f.Verdict = "no ports probed"
+		return f
+	case c.closed+c.rejected+c.dropped == 0:
+		f.Verdict = "no filtering seen: every probed port is open"
+		return f
+	case c.closed == 0 && c.rejected == 0 && len(open) == 0:
+		f.Verdict = "no answer at all: host down, or every port dropped by a filter"
+		return f
+	}
+
+	policy := refusedRST
+	switch {
+	case c.dropped >= c.closed && c.dropped >= c.rejected:
+		policy = refusedSilent
+	case c.rejected >= c.closed:
+		policy = refusedICMP
+	}
+	var parts []string
+	switch policy {
+	case refusedSilent:
+		parts = append(parts, "default deny, dropping packets")
+	case refusedICMP:
+		parts = append(parts, "default deny, rejecting with ICMP unreachable")
+	default:
+		parts = append(parts, "no default-deny filter: closed ports refuse connections")
+	}
+	if policy != refusedRST && len(permits) > 0 {
+		parts = append(parts, "permits "+strings.Join(permits, ", "))
+	}
+	if policy != refusedSilent && c.dropped > 0 {
+		parts = append(parts, fmt.Sprintf("%d ports dropped by a filter", c.dropped))
+	}
+	if policy != refusedICMP && c.rejected > 0 {
+		parts = append(parts, fmt.Sprintf("%d ports rejected with ICMP unreachable", c.rejected))
+	}
+	if policy != refusedRST && c.closed > 0 {
+		parts = append(parts, fmt.Sprintf("%d ports let through but closed", c.closed))
+	}
+	f.Verdict = strings.Join(parts, "; ")
+	return f
+}
+
+func (f *FirewallInference) String() string {
+	return fmt.Sprintf("%s (%d closed, %d rejected, %d dropped)", f.Verdict, f.Closed, f.Rejected, f.Dropped)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestInferFirewall(t *testing.T) {
+	open := hostWith("a", 22, 443).Ports
+	tests := []struct {
+		c    refusalCounts
+		open []PortResult
+		want string
+	}{
+		{refusalCounts{dropped: 998}, open, "default deny, dropping packets; permits 22, 443"},
+		{refusalCounts{rejected: 990, closed: 8}, open, "default deny, rejecting with ICMP unreachable; permits 22, 443; 8 ports let through but closed"},
+		{refusalCounts{closed: 990, dropped: 8}, open, "no default-deny filter: closed ports refuse connections; 8 ports dropped by a filter"},
+		{refusalCounts{dropped: 1000}, nil, "no answer at all: host down, or every port dropped by a filter"},
+		{refusalCounts{}, open, "no filtering seen: every probed port is open"},
+	}
+	for _, tt := range tests {
+		if got := inferFirewall(tt.c, tt.open).Verdict; got != tt.want {
+			t.Errorf("inferFirewall(%+v) = %q, want %q", tt.c, got, tt.want)
+		}
+	}
+}
+
+func TestClassifyRefusal(t *testing.T) {
+	for err, want := range map[error]refusal{
+		&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}: refusedRST,
+		&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.EHOSTUNREACH)}: refusedICMP,
+		&net.OpError{Op: "dial", Err: os.ErrDeadlineExceeded}:                              refusedSilent,
+		fmt.Errorf("socket: %w", syscall.EMFILE):                                           notRefused,
+	} {
+		if got := classifyRefusal(err); got != want {
+			t.Errorf("classifyRefusal(%v) = %d, want %d", err, got, want)
+		}
+	}
+}
+
+func TestRunInfersFirewall(t *testing.T) {
+	open := localPort(t)
+	targets, _ := parseTargets("127.0.0.1")
+	p := &scanPlan{targets: targets, numTargets: 1, ports: []int{open, closedPort(t)}, workers: 2, timeout: time.Second, inferFirewall: true}
+	hosts := p.run(context.Background(), scanHooks{})
+	f := hosts[0].Firewall
+	if f == nil || f.Closed != 1 || f.Dropped != 0 || f.Verdict != "no default-deny filter: closed ports refuse connections" {
+		t.Errorf("firewall = %+v", f)
+	}
+}
+
+func TestParseStates(t *testing.T) {
+	if got, err := parseStates("filtered, open,closed,open"); err != nil || !reflect.DeepEqual(got, portStates) {
+		t.Errorf("parseStates = %v, %v", got, err)
+	}
+	for _, in := range []string{"", "open,unfiltered"} {
+		if _, err := parseStates(in); err == nil {
+			t.Errorf("parseStates(%q) succeeded", in)
+		}
+	}
+}
+
+func TestRunListsClosedPorts(t *testing.T) {
+	open, closed := localPort(t), closedPort(t)
+	targets, _ := parseTargets("127.0.0.1")
+	p := &scanPlan{targets: targets, numTargets: 1, ports: []int{open, closed}, workers: 2, timeout: time.Second, states: []string{"closed"}}
+	hosts := p.run(context.Background(), scanHooks{})
+	if hosts[0].Closed != strconv.Itoa(closed) || hosts[0].Filtered != "" || hosts[0].Firewall != nil {
+		t.Errorf("host = %+v", hosts[0])
+	}
+	r := newReport(p, "", time.Now(), hosts, false)
+	if len(r.Hosts[0].Ports) != 0 || !reflect.DeepEqual(r.Parameters.States, []string{"closed"}) {
+		t.Errorf("report lists open ports %v with states %v", r.Hosts[0].Ports, r.Parameters.States)
+	}
+	var b strings.Builder
+	if err := writeText(&b, r); err != nil {
+		t.Fatal(err)
+	}
+	if out := b.String(); strings.Contains(out, "Open ports") || !strings.Contains(out, "Closed ports: "+strconv.Itoa(closed)+"\n") {
+		t.Errorf("text report:\n%s", out)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"500", 500 * time.Millisecond, false},
+		{"0", 0, false},
+		{"750ms", 750 * time.Millisecond, false},
+		{"2s", 2 * time.Second, false},
+		{"1m30s", 90 * time.Second, false},
+		{" 300 ", 300 * time.Millisecond, false},
+		{"-5", 0, true},
+		{"-1s", 0, true},
+		{"2 seconds", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseDuration(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseDuration(%q) = %v, %v; want %v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestDurationJSON(t *testing.T) {
+	var p Profile
+	if err := json.Unmarshal([]byte(`{"timeout": 300, "delay": "1.5s"}`), &p); err != nil {
+		t.Fatal(err)
+	}
+	if time.Duration(p.Timeout) != 300*time.Millisecond || time.Duration(p.Delay) != 1500*time.Millisecond {
+		t.Errorf("got timeout %v delay %v", time.Duration(p.Timeout), time.Duration(p.Delay))
+	}
+	if err := json.Unmarshal([]byte(`{"timeout": "soon"}`), &p); err == nil {
+		t.Error("invalid duration string accepted")
+	}
+}
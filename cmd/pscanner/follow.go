@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxLearned bounds the hosts one scan learns from its results and
+	// scans, over all rounds.
+	maxLearned = 4096
+	// maxFollow bounds the rounds of --follow.
+	maxFollow = 10
+)
+
+// arpTablePath is the kernel's IPv4 neighbour table; tests point it at
+// their own.
+var arpTablePath = "/proc/net/arp"
+
+// lead is a host that the results of a scan point to.
+type lead struct {
+	host string // an address or a name
+	// from is the host whose results named it, and by how: "snmp",
+	// "certificate", or "arp" for the scanner's own table, with no from.
+	from, by string
+}
+
+// expand scans the hosts that the results point to and were not targets,
+// for --follow and --snmp-harvest, and appends their results to hosts.
+// Each round follows the leads of the hosts the round before found, for
+// --follow rounds, or one with --snmp-harvest alone. A host is scanned
+// once, under whichever name or address first led to it, and only if the
+// scope allows it: with no scope file, internal addresses only.
+func (p *scanPlan) expand(ctx context.Context, dns *dnsCache, hooks scanHooks, hosts []HostResult) []HostResult {
+	if p.snmpCommunity == "" && p.follow == 0 {
+		return hosts
+	}
+	known := make(map[string]bool)
+	p.targets.each(func(h string) bool {
+		known[h] = true
+		return true
+	})
+	for _, h := range hosts {
+		if _, err := netip.ParseAddr(h.Host); err != nil && p.proxy == nil {
+			addrs, _ := dns.resolve(ctx, h.Host)
+			for _, a := range addrs {
+				known[a.String()] = true
+			}
+		}
+	}
+	learned := 0
+	batch := hosts
+	for round := 1; round <= max(p.follow, 1) && learned < maxLearned && ctx.Err() == nil; round++ {
+		p.harvestSNMP(ctx, dns, batch)
+		var targets []string
+		leads := make(map[string]lead)
+		for _, l := range p.leads(batch) {
+			if known[l.host] {
+				continue
+			}
+			known[l.host] = true
+			if !p.mayFollow(ctx, dns, l.host, known) {
+				continue
+			}
+			if learned == maxLearned {
+				slog.Warn("results point to too many hosts; scanning the first ones", "limit", maxLearned)
+				break
+			}
+			learned++
+			targets = append(targets, l.host)
+			leads[l.host] = l
+		}
+		if len(targets) == 0 || ctx.Err() != nil {
+			break
+		}
+		slog.Info("scanning hosts the results point to", "round", round, "hosts", len(targets))
+		list, err := parseTargets(strings.Join(targets, ","))
+		if err != nil {
+			slog.Warn("following results", "err", err)
+			break
+		}
+		more := *p
+		more.targets, more.numTargets, more.hostPorts = list, list.count(), nil
+		more.snmpCommunity, more.follow, more.dns = "", 0, dns
+		// The certificates of the hosts found are read for the next round.
+		more.certNames = true
+		// The progress of the scan counts the targets alone.
+		found := more.run(ctx, scanHooks{found: hooks.found, pause: hooks.pause, failed: hooks.failed})
+		for i := range found {
+			l := leads[found[i].Host]
+			found[i].LearnedFrom, found[i].LearnedBy = l.from, l.by
+		}
+		hosts = append(hosts, found...)
+		batch = hosts[len(hosts)-len(found):]
+	}
+	return hosts
+}
+
+// leads returns the hosts that the results of batch point to: the
+// neighbours read over SNMP, and for --follow the names in their
+// certificates and, scanning directly, the scanner's own ARP table.
+func (p *scanPlan) leads(batch []HostResult) []lead {
+	var leads []lead
+	for _, h := range batch {
+		if h.SNMP != nil {
+			for _, n := range h.SNMP.Neighbors {
+				leads = append(leads, lead{host: n, from: h.Host, by: "snmp"})
+			}
+		}
+	}
+	if p.follow == 0 {
+		return leads
+	}
+	for _, h := range batch {
+		for _, n := range h.CertNames {
+			if !strings.Contains(n, "*") {
+				leads = append(leads, lead{host: n, from: h.Host, by: "certificate"})
+			}
+		}
+	}
+	// Behind a proxy the scanner's neighbours are not the targets'.
+	if p.proxy == nil {
+		for _, a := range readARPTable() {
+			leads = append(leads, lead{host: a.String(), by: "arp"})
+		}
+	}
+	return leads
+}
+
+// mayFollow reports whether the host of a lead may be scanned. A name
+// must resolve, only to addresses the scope allows and that are not known
+// yet, which it then marks known.
+func (p *scanPlan) mayFollow(ctx context.Context, dns *dnsCache, host string, known map[string]bool) bool {
+	if a, err := netip.ParseAddr(host); err == nil {
+		return p.mayLearn(a)
+	}
+	addrs, err := dns.resolve(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return false
+	}
+	for _, a := range addrs {
+		if known[a.String()] || !p.mayLearn(a) {
+			return false
+		}
+	}
+	for _, a := range addrs {
+		known[a.String()] = true
+	}
+	return true
+}
+
+// mayLearn reports whether an address the results point to may be
+// scanned: it must be in the scope, or with no scope file, an internal
+// address.
+func (p *scanPlan) mayLearn(a netip.Addr) bool {
+	if p.scope != nil {
+		return p.scope.contains(netip.PrefixFrom(a, a.BitLen()))
+	}
+	return !isPublicAddr(a) && !a.IsLoopback()
+}
+
+// readARPTable returns the addresses of the complete entries of the
+// kernel's ARP table: the hosts on attached networks that answered the
+// scanner lately, even those that answer no probe. It is empty where
+// there is no /proc/net/arp.
+func readARPTable() []netip.Addr {
+	data, err := os.ReadFile(arpTablePath)
+	if err != nil {
+		return nil
+	}
+	var addrs []netip.Addr
+	lines := strings.Split(string(data), "\n")
+	for _, line := range lines[1:] {
+		// IP address, HW type, flags, HW address, mask, device.
+		f := strings.Fields(line)
+		if len(f) < 6 {
+			continue
+		}
+		const atfComplete = 0x2
+		if flags, err := strconv.ParseUint(f[2], 0, 32); err != nil || flags&atfComplete == 0 {
+			continue
+		}
+		if a, err := netip.ParseAddr(f[0]); err == nil {
+			addrs = append(addrs, a)
+		}
+	}
+	return addrs
+}
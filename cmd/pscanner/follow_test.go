@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMayLearn(t *testing.T) {
+	var p scanPlan
+	for a, want := range map[string]bool{"10.1.2.3": true, "fd00::1": true, "8.8.8.8": false, "127.0.0.2": false} {
+		if got := p.mayLearn(netip.MustParseAddr(a)); got != want {
+			t.Errorf("no scope: mayLearn(%s) = %v", a, got)
+		}
+	}
+	p.scope = &scanScope{allow: []netip.Prefix{netip.MustParsePrefix("10.1.0.0/16")}}
+	for a, want := range map[string]bool{"10.1.2.3": true, "10.2.0.1": false} {
+		if got := p.mayLearn(netip.MustParseAddr(a)); got != want {
+			t.Errorf("scope: mayLearn(%s) = %v", a, got)
+		}
+	}
+}
+
+// fakeARPTable points arpTablePath at a table listing the entries given
+// as address and flags.
+func fakeARPTable(t *testing.T, entries ...string) {
+	t.Helper()
+	table := "IP address       HW type     Flags       HW address            Mask     Device\n"
+	for i := 0; i < len(entries); i += 2 {
+		table += entries[i] + "  0x1  " + entries[i+1] + "  02:00:00:00:00:01  *  eth0\n"
+	}
+	path := filepath.Join(t.TempDir(), "arp")
+	if err := os.WriteFile(path, []byte(table), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := arpTablePath
+	arpTablePath = path
+	t.Cleanup(func() { arpTablePath = old })
+}
+
+func TestReadARPTable(t *testing.T) {
+	fakeARPTable(t, "10.0.0.1", "0x2", "10.0.0.2", "0x0", "10.0.0.3", "0x6")
+	got := readARPTable()
+	if want := []netip.Addr{netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.3")}; !slices.Equal(got, want) {
+		t.Errorf("readARPTable() = %v, want %v", got, want)
+	}
+	arpTablePath = filepath.Join(t.TempDir(), "missing")
+	if got := readARPTable(); got != nil {
+		t.Errorf("missing table: %v", got)
+	}
+}
+
+// sanCertificate makes a self-signed certificate for names and addrs.
+func sanCertificate(t *testing.T, names []string, addrs ...net.IP) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     names,
+		IPAddresses:  addrs,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestExpandFollowsResults(t *testing.T) {
+	cert := sanCertificate(t, []string{"app.internal", "*.wild.internal", "public.example"}, net.ParseIP("127.0.0.2"))
+	port := serveTCP(t, func(conn net.Conn) {
+		tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{cert}}).Handshake()
+	})
+	certPorts[port] = true
+	t.Cleanup(func() { delete(certPorts, port) })
+	fakeARPTable(t, "127.0.0.4", "0x2", "127.0.0.1", "0x2", "127.0.0.5", "0x0")
+	dns := newDNSCache("")
+	dns.lookup = func(_ context.Context, host string) ([]netip.Addr, time.Duration, error) {
+		if host == "public.example" {
+			return []netip.Addr{netip.MustParseAddr("198.51.100.1")}, 0, nil
+		}
+		return []netip.Addr{netip.MustParseAddr("127.0.0.3")}, 0, nil
+	}
+	targets, _ := parseTargets("127.0.0.1")
+	p := &scanPlan{
+		targets: targets, numTargets: 1, ports: []int{port}, workers: 2, timeout: time.Second, dns: dns, follow: 2,
+		scope: &scanScope{allow: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}},
+	}
+	hosts := p.run(context.Background(), scanHooks{})
+	type learned struct{ host, from, by string }
+	var got []learned
+	for _, h := range hosts[1:] {
+		got = append(got, learned{h.Host, h.LearnedFrom, h.LearnedBy})
+	}
+	want := []learned{
+		{"app.internal", "127.0.0.1", "certificate"},
+		{"127.0.0.2", "127.0.0.1", "certificate"},
+		{"127.0.0.4", "", "arp"},
+	}
+	if hosts[0].Host != "127.0.0.1" || !slices.Equal(got, want) {
+		t.Errorf("hosts = %s then %v, want %v", hosts[0].Host, got, want)
+	}
+}
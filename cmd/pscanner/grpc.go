@@ -0,0 +1,135 @@
+package main
+
+//go:generate protoc -I ../../proto --go_out=../../proto --go_opt=paths=source_relative --go-grpc_out=../../proto --go-grpc_opt=paths=source_relative pscanner/v1/scanner.proto
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/AlirezaNezami23/pscanner/proto/pscanner/v1"
+)
+
+// grpcServer implements the pscanner.v1.Scanner service on top of a
+// jobManager.
+type grpcServer struct {
+	pb.UnimplementedScannerServer
+	jobs *jobManager
+}
+
+func newGRPCServer(jobs *jobManager) *grpc.Server {
+	s := grpc.NewServer()
+	pb.RegisterScannerServer(s, &grpcServer{jobs: jobs})
+	return s
+}
+
+// durationPtr converts an optional protobuf duration.
+func durationPtr(d *durationpb.Duration) *Duration {
+	if d == nil {
+		return nil
+	}
+	v := Duration(d.AsDuration())
+	return &v
+}
+
+func (s *grpcServer) SubmitScan(ctx context.Context, req *pb.SubmitScanRequest) (*pb.SubmitScanResponse, error) {
+	j, err := s.jobs.submit(&scanRequest{
+		Hosts:       req.GetHosts(),
+		Ports:       req.GetPorts(),
+		TopPorts:    int(req.GetTopPorts()),
+		Profile:     req.GetProfile(),
+		Workers:     int(req.GetWorkers()),
+		Timeout:     durationPtr(req.Timeout),
+		HostTimeout: durationPtr(req.HostTimeout),
+		Delay:       durationPtr(req.Delay),
+		Confirm:     req.GetConfirm(),
+	})
+	var ce *confirmError
+	switch {
+	case errors.As(err, &ce):
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, errNotAudited):
+		return nil, status.Error(codes.Internal, err.Error())
+	case err != nil:
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &pb.SubmitScanResponse{ScanId: j.id, Parameters: paramsProto(j.plan.params(j.profile))}, nil
+}
+
+func (s *grpcServer) StreamResults(req *pb.StreamResultsRequest, stream pb.Scanner_StreamResultsServer) error {
+	j, ok := s.jobs.get(req.GetScanId())
+	if !ok {
+		return status.Errorf(codes.NotFound, "no scan with id %q", req.GetScanId())
+	}
+	open := 0
+	report, err := j.follow(stream.Context(), func(p openPort) error {
+		open++
+		return stream.Send(&pb.ScanEvent{Event: &pb.ScanEvent_Port{Port: &pb.PortResult{
+			Host:     p.Host,
+			Port:     int32(p.Port),
+			Protocol: p.Protocol,
+			State:    p.State,
+		}}})
+	})
+	if err != nil {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return status.FromContextError(err).Err()
+		}
+		return err
+	}
+	_, state, _ := j.snapshot(0)
+	return stream.Send(&pb.ScanEvent{Event: &pb.ScanEvent_Finished{Finished: &pb.ScanFinished{
+		State:         stateProto(state),
+		StartedAt:     timestamppb.New(report.StartedAt),
+		FinishedAt:    timestamppb.New(report.FinishedAt),
+		OpenPorts:     int32(open),
+		TimedOutHosts: timedOutHosts(report),
+	}}})
+}
+
+func (s *grpcServer) CancelScan(ctx context.Context, req *pb.CancelScanRequest) (*pb.CancelScanResponse, error) {
+	j, ok := s.jobs.get(req.GetScanId())
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "no scan with id %q", req.GetScanId())
+	}
+	j.cancel()
+	// Wait for the workers to wind down so the reported state is final.
+	_, err := j.follow(ctx, func(openPort) error { return nil })
+	if err != nil {
+		return nil, status.FromContextError(err).Err()
+	}
+	_, state, _ := j.snapshot(0)
+	return &pb.CancelScanResponse{State: stateProto(state)}, nil
+}
+
+func stateProto(state string) pb.ScanState {
+	switch state {
+	case jobRunning:
+		return pb.ScanState_SCAN_STATE_RUNNING
+	case jobCompleted:
+		return pb.ScanState_SCAN_STATE_COMPLETED
+	case jobCanceled:
+		return pb.ScanState_SCAN_STATE_CANCELED
+	}
+	return pb.ScanState_SCAN_STATE_UNSPECIFIED
+}
+
+func paramsProto(p scanParams) *pb.ScanParameters {
+	return &pb.ScanParameters{
+		Targets:     p.Targets,
+		TargetCount: int64(p.TargetCount),
+		Ports:       p.Ports,
+		PortCount:   int32(p.PortCount),
+		Workers:     int32(p.Workers),
+		Timeout:     durationpb.New(time.Duration(p.Timeout)),
+		HostTimeout: durationpb.New(time.Duration(p.HostTimeout)),
+		Delay:       durationpb.New(time.Duration(p.Delay)),
+		Profile:     p.Profile,
+	}
+}
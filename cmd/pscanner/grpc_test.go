@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	pb "github.com/AlirezaNezami23/pscanner/proto/pscanner/v1"
+)
+
+func newTestClient(t *testing.T) pb.ScannerClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	srv := newGRPCServer(newJobManager(&Config{}, nil, net.LookupHost))
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewScannerClient(conn)
+}
+
+// localPort returns a port on 127.0.0.1 that accepts connections.
+func localPort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestGRPCSubmitAndStream(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+	port := localPort(t)
+
+	resp, err := c.SubmitScan(ctx, &pb.SubmitScanRequest{Hosts: "127.0.0.1", Ports: strconv.Itoa(port), Timeout: durationpb.New(time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := resp.GetParameters(); p.GetPortCount() != 1 || p.GetTargetCount() != 1 {
+		t.Errorf("unexpected parameters: %v", p)
+	}
+
+	stream, err := c.StreamResults(ctx, &pb.StreamResultsRequest{ScanId: resp.GetScanId()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ports []int32
+	var finished *pb.ScanFinished
+	for finished == nil {
+		ev, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p := ev.GetPort(); p != nil {
+			ports = append(ports, p.GetPort())
+		}
+		finished = ev.GetFinished()
+	}
+	if len(ports) != 1 || ports[0] != int32(port) {
+		t.Errorf("streamed ports %v, want [%d]", ports, port)
+	}
+	if finished.GetState() != pb.ScanState_SCAN_STATE_COMPLETED || finished.GetOpenPorts() != 1 {
+		t.Errorf("unexpected finish event: %v", finished)
+	}
+}
+
+func TestGRPCSubmitErrors(t *testing.T) {
+	c := newTestClient(t)
+	tests := []struct {
+		req  *pb.SubmitScanRequest
+		code codes.Code
+	}{
+		{&pb.SubmitScanRequest{Ports: "80"}, codes.InvalidArgument},
+		{&pb.SubmitScanRequest{Hosts: "127.0.0.1", Ports: "nope-"}, codes.InvalidArgument},
+		{&pb.SubmitScanRequest{Hosts: "127.0.0.1", Ports: "80", TopPorts: 10}, codes.InvalidArgument},
+		{&pb.SubmitScanRequest{Hosts: "8.8.8.8", Ports: "53"}, codes.FailedPrecondition},
+	}
+	for _, tt := range tests {
+		_, err := c.SubmitScan(context.Background(), tt.req)
+		if status.Code(err) != tt.code {
+			t.Errorf("SubmitScan(%v) = %v, want code %v", tt.req, err, tt.code)
+		}
+	}
+}
+
+func TestGRPCCancel(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+	resp, err := c.SubmitScan(ctx, &pb.SubmitScanRequest{Hosts: "127.0.0.1", Ports: "1-1000", Workers: 1, Delay: durationpb.New(time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.CancelScan(ctx, &pb.CancelScanRequest{ScanId: resp.GetScanId()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.GetState() != pb.ScanState_SCAN_STATE_CANCELED {
+		t.Errorf("state after cancel = %v, want canceled", got.GetState())
+	}
+	if _, err := c.CancelScan(ctx, &pb.CancelScanRequest{ScanId: "missing"}); status.Code(err) != codes.NotFound {
+		t.Errorf("cancel of unknown scan = %v, want NotFound", err)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// defaultConfirmProbes is the probe count above which a scan needs --yes.
+const defaultConfirmProbes = 1_000_000
+
+// maxListedPublic caps how many public targets a warning names.
+const maxListedPublic = 5
+
+// nonPublicPrefixes are address blocks that never route on the public
+// internet. A target entirely inside one of them is considered internal.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("169.254.0.0/16"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("224.0.0.0/3"),
+	netip.MustParsePrefix("::/127"),
+	netip.MustParsePrefix("2001:db8::/32"),
+	netip.MustParsePrefix("fc00::/7"),
+	netip.MustParsePrefix("fe80::/10"),
+	netip.MustParsePrefix("ff00::/8"),
+}
+
+// isInternalPrefix reports whether every address in p is non-public.
+// IPv4-mapped IPv6 prefixes (::ffff:a.b.c.d/n with n >= 96) are judged as
+// the IPv4 prefix they map to; shorter ones reach outside the mapped range
+// and are judged as IPv6.
+func isInternalPrefix(p netip.Prefix) bool {
+	p = p.Masked()
+	if p.Addr().Is4In6() && p.Bits() >= 96 {
+		p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+	}
+	for _, b := range nonPublicPrefixes {
+		if b.Bits() <= p.Bits() && b.Contains(p.Addr()) {
+			return true
+		}
+	}
+	return false
+}
+
+func isPublicAddr(a netip.Addr) bool {
+	return !isInternalPrefix(netip.PrefixFrom(a, a.BitLen()))
+}
+
+// confirmLimit turns the confirm_probes setting into a probe limit: 0 picks
+// the default and a negative value disables the check, returned as 0.
+func confirmLimit(setting int) int {
+	switch {
+	case setting == 0:
+		return defaultConfirmProbes
+	case setting < 0:
+		return 0
+	}
+	return setting
+}
+
+// publicTargets returns the targets that reach public address space. CIDR
+// blocks are always checked; single IPs and hostnames are skipped when
+// allowHosts is set. Hostnames are judged by the addresses resolve returns,
+// and names that do not resolve are left out.
+func publicTargets(l targetList, allowHosts bool, resolve func(string) ([]string, error)) []string {
+	var public []string
+	for _, t := range l {
+		switch {
+		case t.isBlock():
+			if !isInternalPrefix(t.prefix) {
+				public = append(public, t.String())
+			}
+		case allowHosts:
+		case t.name == "":
+			if isPublicAddr(t.prefix.Addr()) {
+				public = append(public, t.String())
+			}
+		default:
+			ips, err := resolve(t.name)
+			if err != nil {
+				continue
+			}
+			var hit []string
+			for _, ip := range ips {
+				if a, err := netip.ParseAddr(ip); err == nil && isPublicAddr(a) {
+					hit = append(hit, ip)
+				}
+			}
+			if len(hit) > 0 {
+				public = append(public, fmt.Sprintf("%s (%s)", t.name, strings.Join(hit, ", ")))
+			}
+		}
+	}
+	return public
+}
+
+// scanWarnings lists the reasons a scan needs explicit confirmation. A limit
+// of 0 disables the probe count check.
+func scanWarnings(l targetList, probes, limit int, allowHosts bool, resolve func(string) ([]string, error)) []string {
+	var reasons []string
+	if limit > 0 && probes > limit {
+		reasons = append(reasons, fmt.Sprintf("scan has %d probes (limit %d)", probes, limit))
+	}
+	if public := publicTargets(l, allowHosts, resolve); len(public) > 0 {
+		listed := public
+		if len(listed) > maxListedPublic {
+			listed = append(listed[:maxListedPublic:maxListedPublic], fmt.Sprintf("and %d more", len(public)-maxListedPublic))
+		}
+		reasons = append(reasons, fmt.Sprintf("targets include public addresses: %s", strings.Join(listed, ", ")))
+	}
+	return reasons
+}
+
+// confirmScan asks the operator to approve a risky scan when in is an
+// interactive terminal. Otherwise the scan is refused; --yes skips the check.
+func confirmScan(in *os.File, out io.Writer, reasons []string) bool {
+	for _, r := range reasons {
+		fmt.Fprintf(out, "warning: %s\n", r)
+	}
+	if !isTerminal(in) {
+		fmt.Fprintln(out, "error: refusing to start without confirmation; re-run with --yes")
+		return false
+	}
+	fmt.Fprint(out, "Proceed with scan? [y/N] ")
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIsInternalPrefix(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   bool
+	}{
+		// IPv4
+		{"10.0.0.0/8", true},
+		{"10.20.30.0/24", true},
+		{"172.16.0.0/12", true},
+		{"172.31.255.0/24", true},
+		{"172.32.0.0/16", false},
+		{"192.168.1.0/24", true},
+		{"127.0.0.1/32", true},
+		{"100.64.0.0/10", true},
+		{"8.8.8.0/24", false},
+		{"1.2.3.4/32", false},
+		{"0.0.0.0/0", false},
+		{"8.0.0.0/7", false},
+		// A block that only partly overlaps private space is public.
+		{"10.0.0.0/7", false},
+		{"192.168.0.0/15", false},
+		// IPv4-mapped IPv6, judged as the IPv4 prefix they map to.
+		{"::ffff:10.0.0.0/104", true},
+		{"::ffff:192.168.1.1/128", true},
+		{"::ffff:8.8.8.8/128", false},
+		{"::ffff:0.0.0.0/96", false},
+		// Shorter than /96 leaves the mapped range and is judged as IPv6.
+		{"::ffff:10.0.0.0/95", false},
+		{"::ffff:10.0.0.0/80", false},
+		// IPv6
+		{"fd00::/8", true},
+		{"fc00::/7", true},
+		{"fe80::/64", true},
+		{"fe80::1/128", true},
+		{"::1/128", true},
+		{"2001:db8::/48", true},
+		{"2001:4860::/32", false},
+		{"2000::/3", false},
+		{"::/0", false},
+	}
+	for _, tt := range tests {
+		if got := isInternalPrefix(netip.MustParsePrefix(tt.prefix)); got != tt.want {
+			t.Errorf("isInternalPrefix(%s) = %v, want %v", tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestConfirmLimit(t *testing.T) {
+	tests := map[int]int{
+		0:      defaultConfirmProbes,
+		-1:     0,
+		-500:   0,
+		1:      1,
+		250000: 250000,
+	}
+	for in, want := range tests {
+		if got := confirmLimit(in); got != want {
+			t.Errorf("confirmLimit(%d) = %d, want %d", in, got, want)
+		}
+	}
+}
+
+func fakeResolver(names map[string][]string) func(string) ([]string, error) {
+	return func(name string) ([]string, error) {
+		if ips, ok := names[name]; ok {
+			return ips, nil
+		}
+		return nil, errors.New("no such host")
+	}
+}
+
+func TestPublicTargets(t *testing.T) {
+	resolve := fakeResolver(map[string][]string{
+		"public.example":   {"10.0.0.5", "93.184.216.34"},
+		"internal.example": {"10.0.0.6", "fd00::6"},
+	})
+	tests := []struct {
+		spec       string
+		allowHosts bool
+		want       []string
+	}{
+		{"10.0.0.0/24,192.168.0.1,fe80::1", false, nil},
+		{"1.2.3.4", false, []string{"1.2.3.4"}},
+		{"1.2.3.4,8.8.8.0/24", false, []string{"1.2.3.4", "8.8.8.0/24"}},
+		{"public.example,internal.example,unresolved.example", false, []string{"public.example (93.184.216.34)"}},
+		{"::ffff:8.8.8.8,::ffff:10.0.0.1", false, []string{"::ffff:8.8.8.8"}},
+		// allow_public_hosts only exempts single hosts, never blocks.
+		{"1.2.3.4,public.example,8.8.8.0/24", true, []string{"8.8.8.0/24"}},
+	}
+	for _, tt := range tests {
+		l, err := parseTargets(tt.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := publicTargets(l, tt.allowHosts, resolve)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("publicTargets(%q, %v) = %v, want %v", tt.spec, tt.allowHosts, got, tt.want)
+		}
+	}
+}
+
+func TestScanWarnings(t *testing.T) {
+	resolve := fakeResolver(nil)
+	private, _ := parseTargets("10.0.0.0/24")
+	public, _ := parseTargets("1.0.0.1,1.0.0.2,1.0.0.3,1.0.0.4,1.0.0.5,1.0.0.6,1.0.0.7")
+
+	if w := scanWarnings(private, 5000, 1000, false, resolve); len(w) != 1 || !strings.Contains(w[0], "5000 probes") {
+		t.Errorf("over limit: got %v", w)
+	}
+	if w := scanWarnings(private, 1000, 1000, false, resolve); len(w) != 0 {
+		t.Errorf("at limit: got %v, want none", w)
+	}
+	// A limit of 0 (confirm_probes < 0) disables the probe check.
+	if w := scanWarnings(private, 1<<30, 0, false, resolve); len(w) != 0 {
+		t.Errorf("disabled limit: got %v, want none", w)
+	}
+	w := scanWarnings(public, 7, 1000, false, resolve)
+	if len(w) != 1 || !strings.Contains(w[0], "1.0.0.5, and 2 more") || strings.Contains(w[0], "1.0.0.6") {
+		t.Errorf("public list: got %v", w)
+	}
+}
+
+func TestConfirmScanRefusesWithoutTerminal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stdin")
+	if err := os.WriteFile(path, []byte("yes\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{path, os.DevNull} {
+		in, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		if confirmScan(in, &out, []string{"scan has 2 probes (limit 1)"}) {
+			t.Errorf("confirmScan accepted non-terminal input from %s", name)
+		}
+		if !strings.Contains(out.String(), "warning: scan has 2 probes") || !strings.Contains(out.String(), "--yes") {
+			t.Errorf("unexpected output for %s: %q", name, out.String())
+		}
+		in.Close()
+	}
+}
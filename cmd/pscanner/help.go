@@ -0,0 +1,212 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// commandDoc is the long-form documentation of a command. Option
+// descriptions come from the flag definitions themselves; notes holds the
+// extra detail per flag that does not fit a one-line usage string and is
+// only shown by "pscanner help <command>" and the man page.
+type commandDoc struct {
+	synopsis    string
+	description string
+	notes       map[string]string
+	examples    []string
+}
+
+// helpWidth is the column at which long-form help text is wrapped.
+const helpWidth = 78
+
+// flagArg returns the placeholder shown after a flag name, or "" for
+// boolean flags.
+func flagArg(f *flag.Flag) string {
+	if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+		return ""
+	}
+	if _, ok := f.Value.(*durationValue); ok {
+		return "duration"
+	}
+	name, _ := flag.UnquoteUsage(f)
+	return name
+}
+
+// flagDefault returns the default value worth mentioning, or "" when the
+// default is the zero value.
+func flagDefault(f *flag.Flag) string {
+	switch f.DefValue {
+	case "", "0", "false", time.Duration(0).String():
+		return ""
+	}
+	return f.DefValue
+}
+
+// wrap fills text into lines of at most width columns, each prefixed by
+// indent. Blank lines in text separate paragraphs.
+func wrap(text, indent string, width int) string {
+	var b strings.Builder
+	for i, para := range strings.Split(text, "\n\n") {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		line := indent
+		for _, word := range strings.Fields(para) {
+			if len(line) > len(indent) && len(line)+1+len(word) > width {
+				b.WriteString(line + "\n")
+				line = indent
+			}
+			if len(line) > len(indent) {
+				line += " "
+			}
+			line += word
+		}
+		b.WriteString(line + "\n")
+	}
+	return b.String()
+}
+
+// writeCommandHelp prints the help of a command. The short form lists each
+// option with its one-line usage and is what "--help" shows; the long form
+// adds the description and the notes of every flag. fs may be nil for
+// commands without options.
+func writeCommandHelp(w io.Writer, name string, fs *flag.FlagSet, doc *commandDoc, long bool) {
+	fmt.Fprintf(w, "\n%s\n\nUsage:\n  %s\n", strings.TrimSpace(strings.SplitN(doc.description, "\n\n", 2)[0]), doc.synopsis)
+	if long {
+		if _, rest, ok := strings.Cut(doc.description, "\n\n"); ok {
+			fmt.Fprintf(w, "\n%s", wrap(rest, "", helpWidth))
+		}
+	}
+	if fs != nil {
+		fmt.Fprintf(w, "\nOptions:\n")
+		fs.VisitAll(func(f *flag.Flag) {
+			head := "  --" + f.Name
+			if arg := flagArg(f); arg != "" {
+				head += " " + arg
+			}
+			_, usage := flag.UnquoteUsage(f)
+			if def := flagDefault(f); def != "" {
+				usage += fmt.Sprintf(" (default %s)", def)
+			}
+			if long {
+				fmt.Fprintf(w, "%s\n%s", head, wrap(usage, "        ", helpWidth))
+				if note := doc.notes[f.Name]; note != "" {
+					fmt.Fprint(w, wrap(note, "        ", helpWidth))
+				}
+				return
+			}
+			if len(head) < 22 {
+				fmt.Fprintf(w, "%-22s%s\n", head, usage)
+			} else {
+				fmt.Fprintf(w, "%s\n%22s%s\n", head, "", usage)
+			}
+		})
+		if long {
+			fmt.Fprintf(w, "  --help\n        Show this help message\n")
+		} else {
+			fmt.Fprintf(w, "%-22s%s\n", "  --help", "Show this help message")
+		}
+	}
+	if len(doc.examples) > 0 {
+		fmt.Fprintf(w, "\nExamples:\n")
+		for _, ex := range doc.examples {
+			fmt.Fprintf(w, "  %s\n", ex)
+		}
+	}
+	if !long {
+		fmt.Fprintf(w, "\nRun \"pscanner help %s\" for the full documentation.\n", name)
+	}
+}
+
+// runMan implements the "man" command: it writes pscanner(1) in roff to
+// stdout, built from the same flag definitions and docs as the help output.
+func runMan(args []string) {
+	writeMan(os.Stdout)
+}
+
+// roffEscape escapes text for use in a roff document.
+func roffEscape(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\e`)
+	s = strings.ReplaceAll(s, "-", `\-`)
+	if strings.HasPrefix(s, ".") || strings.HasPrefix(s, "'") {
+		s = `\&` + s
+	}
+	return s
+}
+
+// roffParagraphs writes text as roff paragraphs, joining the lines of each
+// paragraph so the formatter can fill them.
+func roffParagraphs(w io.Writer, text string) {
+	for i, para := range strings.Split(text, "\n\n") {
+		if i > 0 {
+			fmt.Fprintln(w, ".PP")
+		}
+		fmt.Fprintln(w, roffEscape(strings.Join(strings.Fields(para), " ")))
+	}
+}
+
+func writeMan(w io.Writer) {
+	fmt.Fprintf(w, ".TH PSCANNER 1 \"\" \"pscanner %s\" \"User Commands\"\n", roffEscape(currentBuild().Version))
+	fmt.Fprintln(w, ".SH NAME")
+	fmt.Fprintln(w, `pscanner \- fast TCP port scanner`)
+	fmt.Fprintln(w, ".SH SYNOPSIS")
+	fmt.Fprintln(w, `.B pscanner`)
+	fmt.Fprintln(w, `.I command`)
+	fmt.Fprintln(w, `[\fIoptions\fR]`)
+	fmt.Fprintln(w, ".SH COMMANDS")
+	for _, c := range commands {
+		fmt.Fprintf(w, ".TP\n.B %s\n%s\n", roffEscape(c.name), roffEscape(c.short))
+	}
+	for _, c := range commands {
+		if c.doc == nil {
+			continue
+		}
+		fmt.Fprintf(w, ".SH %s\n", roffEscape(strings.ToUpper(c.name)))
+		fmt.Fprintf(w, ".nf\n%s\n.fi\n.PP\n", roffEscape(c.doc.synopsis))
+		roffParagraphs(w, c.doc.description)
+		if c.flags != nil {
+			fmt.Fprintln(w, ".SS Options")
+			c.flags().VisitAll(func(f *flag.Flag) {
+				fmt.Fprintf(w, ".TP\n.BR \\-\\-%s", roffEscape(f.Name))
+				if arg := flagArg(f); arg != "" {
+					fmt.Fprintf(w, " \" \\fI%s\\fR\"", roffEscape(arg))
+				}
+				fmt.Fprintln(w)
+				_, usage := flag.UnquoteUsage(f)
+				if def := flagDefault(f); def != "" {
+					usage += fmt.Sprintf(" (default %s)", def)
+				}
+				roffParagraphs(w, usage)
+				if note := c.doc.notes[f.Name]; note != "" {
+					fmt.Fprintln(w, ".IP")
+					roffParagraphs(w, note)
+				}
+			})
+		}
+		if len(c.doc.examples) > 0 {
+			fmt.Fprintln(w, ".SS Examples")
+			fmt.Fprintln(w, ".nf")
+			for _, ex := range c.doc.examples {
+				fmt.Fprintln(w, roffEscape(ex))
+			}
+			fmt.Fprintln(w, ".fi")
+		}
+	}
+	fmt.Fprintln(w, ".SH FILES")
+	fmt.Fprintln(w, ".TP")
+	fmt.Fprintln(w, `.I ~/.config/pscanner/config.json`)
+	fmt.Fprintln(w, roffEscape("Profiles, port groups and confirmation settings; see the --config option of scan."))
+}
+
+var manDoc = &commandDoc{
+	synopsis: "pscanner man > pscanner.1",
+	description: `Print the pscanner(1) manual page.
+
+The page is written in roff to stdout and is generated from the same flag
+definitions as the built-in help, so it always matches the binary.`,
+	examples: []string{"pscanner man | man -l -"},
+}
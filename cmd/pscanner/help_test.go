@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+)
+
+func TestWrap(t *testing.T) {
+	got := wrap("one two three four\n\nfive", "  ", 14)
+	want := "  one two\n  three four\n\n  five\n"
+	if got != want {
+		t.Errorf("wrap = %q, want %q", got, want)
+	}
+}
+
+// Notes are keyed by flag name; a renamed or removed flag must not leave
+// documentation behind that is never shown.
+func TestDocNotesMatchFlags(t *testing.T) {
+	for _, c := range commands {
+		if c.doc == nil {
+			continue
+		}
+		for name := range c.doc.notes {
+			if c.flags == nil || c.flags().Lookup(name) == nil {
+				t.Errorf("%s: note for unknown flag --%s", c.name, name)
+			}
+		}
+	}
+}
+
+func TestManPageListsEveryFlag(t *testing.T) {
+	var b strings.Builder
+	writeMan(&b)
+	man := b.String()
+	for _, c := range commands {
+		if !strings.Contains(man, ".B "+roffEscape(c.name)+"\n") {
+			t.Errorf("man page does not list command %s", c.name)
+		}
+		visitFlags(c, func(f *flag.Flag, _ bool) {
+			if !strings.Contains(man, `.BR \-\-`+roffEscape(f.Name)) {
+				t.Errorf("man page does not document %s --%s", c.name, f.Name)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HoneypotSigns is why --honeypots takes a host for a honeypot, which
+// pretends to run services, or a tarpit, which holds the connections of
+// scanners and worms.
+type HoneypotSigns struct {
+	// Verdict is honeypot or tarpit.
+	Verdict string   `json:"verdict"`
+	Reasons []string `json:"reasons"`
+}
+
+func (s HoneypotSigns) String() string {
+	return fmt.Sprintf("likely %s: %s", s.Verdict, strings.Join(s.Reasons, "; "))
+}
+
+const (
+	// honeypotMinProbed is how many ports a scan must probe before nearly
+	// all of them open says anything: a few open ports of a few probed
+	// are common.
+	honeypotMinProbed = 100
+	// honeypotMinOpen is how many open ports a host needs to be sampled.
+	honeypotMinOpen = 4
+	// honeypotSample is how many of a host's open ports are sampled.
+	honeypotSample = 8
+	// honeypotWait is how long a sampled port is given to greet, and then
+	// to answer a request.
+	honeypotWait = time.Second
+	// honeypotDialTimeout bounds the connections to sampled ports, past
+	// --timeout, to measure how long a tarpit takes to accept them.
+	honeypotDialTimeout = 5 * time.Second
+	// honeypotSlowAccept is how long most connections must take to be
+	// accepted for a host to be taken for a tarpit.
+	honeypotSlowAccept = time.Second
+	// honeypotTinyWindow is the largest TCP window, in bytes, taken for
+	// that of a tarpit such as LaBrea, which offers a few bytes to hold a
+	// connection with as little traffic as it can.
+	honeypotTinyWindow = 100
+)
+
+// portSample is what a sampled port showed.
+type portSample struct {
+	port    int
+	connect time.Duration
+	// greeting is what the port said before it was asked anything.
+	greeting string
+	// held is set when it neither answered a request nor closed.
+	held bool
+	// window is the TCP window it offered, or 0 if unknown.
+	window int
+}
+
+// probeHoneypots samples a few open ports of hosts with several, and
+// notes the signs of a honeypot or tarpit: nearly every probed port open,
+// the same greeting on every port, a TCP window of a few bytes, slow
+// accepts, and connections held without an answer.
+func (p *scanPlan) probeHoneypots(ctx context.Context, hosts []HostResult) {
+	if !p.honeypots {
+		return
+	}
+	sp := *p
+	sp.timeout = max(p.timeout, honeypotDialTimeout)
+	dns := newDNSCache(p.dnsCache)
+	sem := make(chan struct{}, quicParallel)
+	var wg sync.WaitGroup
+	for i := range hosts {
+		h := &hosts[i]
+		var open []int
+		for _, pr := range h.Ports {
+			if pr.Protocol == "tcp" {
+				open = append(open, pr.Port)
+			}
+		}
+		if len(open) < honeypotMinOpen {
+			continue
+		}
+		sem <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			samples := make([]portSample, 0, honeypotSample)
+			var mu sync.Mutex
+			var swg sync.WaitGroup
+			for _, port := range spreadPorts(open, honeypotSample) {
+				swg.Go(func() {
+					s, err := sp.samplePort(ctx, dns, h, port)
+					if err != nil {
+						return
+					}
+					mu.Lock()
+					samples = append(samples, s)
+					mu.Unlock()
+				})
+			}
+			swg.Wait()
+			slices.SortFunc(samples, func(a, b portSample) int { return a.port - b.port })
+			h.Honeypot = judgeHoneypot(len(p.portsFor(h.Host)), len(open), samples)
+		})
+	}
+	wg.Wait()
+}
+
+// spreadPorts picks n of ports, spread evenly across them.
+func spreadPorts(ports []int, n int) []int {
+	if len(ports) <= n {
+		return ports
+	}
+	picked := make([]int, n)
+	for i := range picked {
+		picked[i] = ports[i*len(ports)/n]
+	}
+	return picked
+}
+
+// samplePort connects to port of h, timing the connection, and waits for
+// a greeting, then sends a blank request and waits for an answer.
+func (p *scanPlan) samplePort(ctx context.Context, dns *dnsCache, h *HostResult, port int) (portSample, error) {
+	s := portSample{port: port}
+	start := time.Now()
+	conn, err := p.probe(ctx, dns, job{host: h.Host, port: port, family: h.Family})
+	if err != nil {
+		return s, err
+	}
+	defer conn.Close()
+	defer context.AfterFunc(ctx, func() { conn.Close() })()
+	s.connect = time.Since(start)
+	s.window = tcpPeerWindow(conn)
+
+	buf := make([]byte, 512)
+	conn.SetReadDeadline(time.Now().Add(honeypotWait))
+	n, err := io.ReadAtLeast(conn, buf, 1)
+	if n > 0 {
+		s.greeting = telnetBanner(buf[:n])
+		return s, nil
+	}
+	if !isTimeout(err) {
+		return s, nil
+	}
+	conn.SetDeadline(time.Now().Add(honeypotWait))
+	if _, err := conn.Write([]byte("\r\n\r\n")); err != nil {
+		return s, nil
+	}
+	if _, err := conn.Read(buf); isTimeout(err) {
+		s.held = true
+	}
+	return s, nil
+}
+
+// judgeHoneypot weighs the signs of a host with open of probed ports open
+// and the samples of some, or returns nil if there are none. Tarpit signs
+// win over those of a honeypot, which a tarpit shows as well.
+func judgeHoneypot(probed, open int, samples []portSample) *HoneypotSigns {
+	var honeypot, tarpit []string
+	nearlyAll := probed >= honeypotMinProbed && open*10 >= probed*9
+	if nearlyAll {
+		honeypot = append(honeypot, fmt.Sprintf("%d of %d probed ports open", open, probed))
+	}
+	if len(samples) >= honeypotMinOpen {
+		same := samples[0].greeting != ""
+		for _, s := range samples[1:] {
+			same = same && s.greeting == samples[0].greeting
+		}
+		if same {
+			honeypot = append(honeypot, fmt.Sprintf("same greeting %q on %d ports", samples[0].greeting, len(samples)))
+		}
+	}
+
+	var tiny, smallest, held int
+	var connects []time.Duration
+	for _, s := range samples {
+		if s.window > 0 && s.window <= honeypotTinyWindow {
+			tiny++
+			if smallest == 0 || s.window < smallest {
+				smallest = s.window
+			}
+		}
+		if s.held {
+			held++
+		}
+		connects = append(connects, s.connect)
+	}
+	if tiny > 0 && tiny*2 > len(samples) {
+		tarpit = append(tarpit, fmt.Sprintf("TCP window of %d bytes on %d of %d sampled ports", smallest, tiny, len(samples)))
+	}
+	if len(connects) > 0 {
+		slices.Sort(connects)
+		if median := connects[len(connects)/2]; median >= honeypotSlowAccept {
+			tarpit = append(tarpit, fmt.Sprintf("connections accepted after %s", median.Round(time.Millisecond)))
+		}
+	}
+	// Plenty of real services wait in silence for more than a blank line,
+	// so only a host with nearly every port open is a tarpit for it.
+	if nearlyAll && held > 0 && held == len(samples) {
+		tarpit = append(tarpit, fmt.Sprintf("connections held without an answer on %d sampled ports", held))
+	}
+
+	switch {
+	case len(tarpit) > 0:
+		return &HoneypotSigns{Verdict: "tarpit", Reasons: append(honeypot, tarpit...)}
+	case len(honeypot) > 0:
+		return &HoneypotSigns{Verdict: "honeypot", Reasons: honeypot}
+	}
+	return nil
+}
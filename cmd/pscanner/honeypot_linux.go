@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// tcpPeerWindow returns the TCP window the other end of conn offers, or 0
+// if it cannot be read: conn is not a direct TCP connection, or the kernel
+// is older than 5.4, which added it to TCP_INFO.
+func tcpPeerWindow(conn net.Conn) int {
+	tc, ok := conn.(*net.TCPConn)
+	if !ok {
+		return 0
+	}
+	raw, err := tc.SyscallConn()
+	if err != nil {
+		return 0
+	}
+	var window int
+	raw.Control(func(fd uintptr) {
+		if info, err := unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO); err == nil {
+			window = int(info.Snd_wnd)
+		}
+	})
+	return window
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "net"
+
+// tcpPeerWindow returns 0: only Linux tells the TCP window the other end
+// offers (TCP_INFO), so elsewhere --honeypots goes without that sign.
+func tcpPeerWindow(conn net.Conn) int { return 0 }
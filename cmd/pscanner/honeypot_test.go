@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJudgeHoneypot(t *testing.T) {
+	fast := 2 * time.Millisecond
+	quiet := func(window int, held bool) []portSample {
+		var samples []portSample
+		for port := 1; port <= 8; port++ {
+			samples = append(samples, portSample{port: port, connect: fast, window: window, held: held})
+		}
+		return samples
+	}
+	for _, tt := range []struct {
+		name         string
+		probed, open int
+		samples      []portSample
+		want         *HoneypotSigns
+	}{
+		{"LaBrea", 65535, 65535, quiet(10, true), &HoneypotSigns{Verdict: "tarpit", Reasons: []string{
+			"65535 of 65535 probed ports open",
+			"TCP window of 10 bytes on 8 of 8 sampled ports",
+			"connections held without an answer on 8 sampled ports",
+		}}},
+		{"every port open", 1000, 1000, quiet(65535, false), &HoneypotSigns{Verdict: "honeypot", Reasons: []string{"1000 of 1000 probed ports open"}}},
+		{"same greeting", 1000, 6, []portSample{
+			{port: 21, connect: fast, greeting: "220 Service ready"},
+			{port: 22, connect: fast, greeting: "220 Service ready"},
+			{port: 25, connect: fast, greeting: "220 Service ready"},
+			{port: 110, connect: fast, greeting: "220 Service ready"},
+		}, &HoneypotSigns{Verdict: "honeypot", Reasons: []string{`same greeting "220 Service ready" on 4 ports`}}},
+		{"slow accepts", 1000, 5, []portSample{
+			{port: 22, connect: 3 * time.Second}, {port: 80, connect: 3 * time.Second}, {port: 443, connect: fast},
+		}, &HoneypotSigns{Verdict: "tarpit", Reasons: []string{"connections accepted after 3s"}}},
+		// Silent TLS ports are no sign on their own.
+		{"web server", 1000, 4, quiet(65535, true)[:4], nil},
+		{"few ports probed", 10, 10, quiet(65535, false), nil},
+	} {
+		if got := judgeHoneypot(tt.probed, tt.open, tt.samples); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("judgeHoneypot of %s = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestProbeHoneypots(t *testing.T) {
+	greet := func(conn net.Conn) {
+		conn.Write([]byte("SSH-2.0-OpenSSH_7.4\r\n"))
+		time.Sleep(time.Second)
+	}
+	var ports []int
+	for range 4 {
+		ports = append(ports, serveTCP(t, greet))
+	}
+	h := HostResult{Host: "127.0.0.1"}
+	for _, port := range ports {
+		h.Ports = append(h.Ports, PortResult{Port: port, Protocol: "tcp", State: "open"})
+	}
+	hosts := []HostResult{h, {Host: "127.0.0.2", Ports: h.Ports[:1]}}
+	p := &scanPlan{timeout: time.Second, ports: ports, honeypots: true}
+	p.probeHoneypots(context.Background(), hosts)
+	want := &HoneypotSigns{Verdict: "honeypot", Reasons: []string{`same greeting "SSH-2.0-OpenSSH_7.4" on 4 ports`}}
+	if !reflect.DeepEqual(hosts[0].Honeypot, want) {
+		t.Errorf("honeypot signs = %+v, want %+v", hosts[0].Honeypot, want)
+	}
+	if hosts[1].Honeypot != nil {
+		t.Errorf("a host with one open port was taken for a %s", hosts[1].Honeypot.Verdict)
+	}
+}
+
+func TestHoneypotReport(t *testing.T) {
+	r := &Report{
+		Parameters: scanParams{TargetCount: 1, PortCount: 65535, Honeypots: true},
+		Hosts: []HostResult{{Host: "10.0.0.9", Ports: []PortResult{
+			{Port: 1, Protocol: "tcp", State: "open"},
+			{Port: 2, Protocol: "tcp", State: "open"},
+			{Port: 3, Protocol: "tcp", State: "open"},
+		}, Honeypot: &HoneypotSigns{Verdict: "tarpit", Reasons: []string{"TCP window of 10 bytes on 3 of 3 sampled ports"}}}},
+	}
+	var text bytes.Buffer
+	if err := writeText(&text, r); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Honeypot: likely tarpit: TCP window of 10 bytes on 3 of 3 sampled ports\n",
+		"Open ports: 3, not listed for a likely tarpit\n",
+	} {
+		if !strings.Contains(text.String(), want) {
+			t.Errorf("text report lacks %q:\n%s", want, text.String())
+		}
+	}
+	if strings.Contains(text.String(), "  2\n") {
+		t.Errorf("text report lists the ports of a tarpit:\n%s", text.String())
+	}
+}
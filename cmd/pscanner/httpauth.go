@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// HTTPAuth is an authentication scheme an open web port asked for in a
+// WWW-Authenticate header, in answer to the requests of the HTTP probes.
+type HTTPAuth struct {
+	Port int  `json:"port"`
+	TLS  bool `json:"tls,omitempty"`
+	// Path is the first path that was answered with it.
+	Path   string `json:"path"`
+	Scheme string `json:"scheme"`
+	Realm  string `json:"realm,omitempty"`
+	// Hint says what the scheme suggests the service is.
+	Hint string `json:"hint,omitempty"`
+	// NTLM is what the server told of itself when asked to start NTLM
+	// authentication, for the NTLM and Negotiate schemes.
+	NTLM *NTLMInfo `json:"ntlm,omitempty"`
+}
+
+func (a HTTPAuth) String() string {
+	s := fmt.Sprintf("tcp/%d %s: %s", a.Port, a.Path, a.Scheme)
+	if a.TLS {
+		s = fmt.Sprintf("tcp/%d %s over TLS: %s", a.Port, a.Path, a.Scheme)
+	}
+	if a.Realm != "" {
+		s += fmt.Sprintf(" realm %q", a.Realm)
+	}
+	if a.Hint != "" {
+		s += ", " + a.Hint
+	}
+	if a.NTLM != nil {
+		s += "; " + a.NTLM.String()
+	}
+	return s
+}
+
+// authHints are what the schemes that only some servers offer suggest.
+var authHints = map[string]string{
+	"ntlm":      "Windows integrated authentication, as IIS, Exchange and SharePoint use",
+	"negotiate": "Kerberos or NTLM, from a Windows or Active Directory joined server",
+	"kerberos":  "Kerberos, from a server joined to a realm",
+}
+
+// parseAuthenticate returns the challenges of the WWW-Authenticate fields
+// of header (RFC 9110, section 11.6.1), with only the scheme and realm
+// kept. A field may hold several challenges, separated by commas as their
+// parameters are.
+func parseAuthenticate(header http.Header) []HTTPAuth {
+	var found []HTTPAuth
+	for _, field := range header.Values("Www-Authenticate") {
+		for _, item := range splitQuoted(field) {
+			item = strings.TrimSpace(item)
+			name, _, _ := strings.Cut(item, "=")
+			switch {
+			case item == "":
+				continue
+			case !strings.ContainsAny(name, " \t"):
+				if strings.Contains(item, "=") {
+					// A parameter of the challenge before.
+					if len(found) > 0 && strings.EqualFold(strings.TrimSpace(name), "realm") {
+						found[len(found)-1].Realm = unquote(item[len(name)+1:])
+					}
+					continue
+				}
+			}
+			scheme, rest, _ := strings.Cut(item, " ")
+			a := HTTPAuth{Scheme: scheme, Hint: authHints[strings.ToLower(scheme)]}
+			rest = strings.TrimSpace(rest)
+			if name, value, ok := strings.Cut(rest, "="); ok && strings.EqualFold(strings.TrimSpace(name), "realm") {
+				a.Realm = unquote(value)
+			}
+			found = append(found, a)
+		}
+	}
+	return found
+}
+
+// splitQuoted splits s at the commas outside quoted strings.
+func splitQuoted(s string) []string {
+	var parts []string
+	quoted, escaped, start := false, false, 0
+	for i, c := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && quoted:
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// unquote returns the value of a parameter, with its quotes and escapes
+// removed if it is a quoted string.
+func unquote(v string) string {
+	v = strings.TrimSpace(v)
+	if len(v) < 2 || v[0] != '"' || v[len(v)-1] != '"' {
+		return v
+	}
+	var b strings.Builder
+	escaped := false
+	for _, c := range v[1 : len(v)-1] {
+		if c == '\\' && !escaped {
+			escaped = true
+			continue
+		}
+		escaped = false
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// askNTLM starts NTLM authentication at the path of the first NTLM or
+// Negotiate challenge of c, unless h has what that tells of port already,
+// and keeps what the server's challenge tells.
+func (c *httpEndpoint) askNTLM(ctx context.Context, h *HostResult, port int) {
+	if slices.ContainsFunc(h.HTTPAuth, func(a HTTPAuth) bool { return a.Port == port && a.NTLM != nil }) {
+		return
+	}
+	i := slices.IndexFunc(c.auth, func(a HTTPAuth) bool {
+		return strings.EqualFold(a.Scheme, "NTLM") || strings.EqualFold(a.Scheme, "Negotiate")
+	})
+	if i < 0 {
+		return
+	}
+	a := &c.auth[i]
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base+a.Path, nil)
+	if err != nil {
+		return
+	}
+	// Negotiate takes a bare NTLM message as well as SPNEGO.
+	req.Header.Set("Authorization", a.Scheme+" "+base64.StdEncoding.EncodeToString(ntlmNegotiate))
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+	for _, field := range resp.Header.Values("Www-Authenticate") {
+		scheme, token, _ := strings.Cut(strings.TrimSpace(field), " ")
+		if !strings.EqualFold(scheme, a.Scheme) {
+			continue
+		}
+		if b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(token)); err == nil {
+			if info, err := parseNTLMChallenge(b); err == nil {
+				a.NTLM = info
+				return
+			}
+		}
+	}
+}
+
+// addHTTPAuth adds the challenges of port to those of h, once for each
+// scheme and realm.
+func (h *HostResult) addHTTPAuth(port int, found []HTTPAuth) {
+	for _, a := range found {
+		a.Port = port
+		i := slices.IndexFunc(h.HTTPAuth, func(b HTTPAuth) bool {
+			return b.Port == a.Port && strings.EqualFold(b.Scheme, a.Scheme) && b.Realm == a.Realm
+		})
+		switch {
+		case i < 0:
+			h.HTTPAuth = append(h.HTTPAuth, a)
+		case h.HTTPAuth[i].NTLM == nil:
+			h.HTTPAuth[i].NTLM = a.NTLM
+		}
+	}
+}
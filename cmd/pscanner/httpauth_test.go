@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseAuthenticate(t *testing.T) {
+	for _, tt := range []struct {
+		fields []string
+		want   []HTTPAuth
+	}{
+		{[]string{`Basic realm="Router, admin"`}, []HTTPAuth{{Scheme: "Basic", Realm: "Router, admin"}}},
+		{[]string{"Negotiate", "NTLM"}, []HTTPAuth{
+			{Scheme: "Negotiate", Hint: authHints["negotiate"]},
+			{Scheme: "NTLM", Hint: authHints["ntlm"]},
+		}},
+		{[]string{`Digest algorithm=MD5, realm="a \"b\"", nonce="x,y", Bearer realm=api, NTLM TlRMTVNTUAACAAAA==`}, []HTTPAuth{
+			{Scheme: "Digest", Realm: `a "b"`},
+			{Scheme: "Bearer", Realm: "api"},
+			{Scheme: "NTLM", Hint: authHints["ntlm"]},
+		}},
+		{nil, nil},
+	} {
+		header := http.Header{"Www-Authenticate": tt.fields}
+		if got := parseAuthenticate(header); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseAuthenticate(%q) = %+v, want %+v", tt.fields, got, tt.want)
+		}
+	}
+}
+
+func TestHTTPCheckRecordsAuth(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "Negotiate "+base64.StdEncoding.EncodeToString(ntlmNegotiate) {
+			w.Header().Set("WWW-Authenticate", "Negotiate "+base64.StdEncoding.EncodeToString(ntlmChallenge(corpDC, 17763)))
+		} else {
+			w.Header().Add("WWW-Authenticate", "Negotiate")
+			w.Header().Add("WWW-Authenticate", "NTLM")
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer s.Close()
+	port := serverPort(t, s)
+	p := &scanPlan{timeout: time.Second}
+	h := &HostResult{Host: "127.0.0.1"}
+	for range 2 {
+		if _, _, err := httpCheck(context.Background(), p, nil, h, port, false, websocketCheck); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []HTTPAuth{
+		{Port: port, Path: "/", Scheme: "Negotiate", Hint: authHints["negotiate"], NTLM: &corpDC},
+		{Port: port, Path: "/", Scheme: "NTLM", Hint: authHints["ntlm"]},
+	}
+	if !reflect.DeepEqual(h.HTTPAuth, want) {
+		t.Errorf("auth = %+v, want %+v", h.HTTPAuth, want)
+	}
+}
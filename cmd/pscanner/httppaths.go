@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// maxHTTPPaths bounds the paths of --http-paths.
+const maxHTTPPaths = 50
+
+// defaultHTTPPaths are the paths "default" stands for in --http-paths,
+// with what their body holds when they are what they seem.
+var defaultHTTPPaths = []struct {
+	path, marker, what string
+}{
+	{"/robots.txt", "user-agent:", "robots rules"},
+	{"/.git/HEAD", "ref: refs/", "Git repository"},
+	{"/server-status", "server status", "Apache server status"},
+	{"/metrics", "# type ", "Prometheus metrics"},
+}
+
+// HTTPPath is the answer of an open web port to a --http-paths request.
+type HTTPPath struct {
+	Port   int    `json:"port"`
+	Path   string `json:"path"`
+	TLS    bool   `json:"tls,omitempty"`
+	Status int    `json:"status"`
+	// Location is where a redirect points.
+	Location string `json:"location,omitempty"`
+	Length   int    `json:"length"`
+	// Found names what a default path's body shows it to be.
+	Found string `json:"found,omitempty"`
+}
+
+func (a HTTPPath) String() string {
+	s := fmt.Sprintf("tcp/%d %s: %d", a.Port, a.Path, a.Status)
+	if a.TLS {
+		s = fmt.Sprintf("tcp/%d %s over TLS: %d", a.Port, a.Path, a.Status)
+	}
+	if a.Location != "" {
+		s += " to " + a.Location
+	}
+	s += fmt.Sprintf(", %d bytes", a.Length)
+	if a.Found != "" {
+		s += ", " + a.Found
+	}
+	return s
+}
+
+// parseHTTPPaths reads --http-paths: paths separated by commas, where
+// "default" stands for defaultHTTPPaths.
+func parseHTTPPaths(s string) ([]string, error) {
+	var paths []string
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		switch {
+		case f == "":
+			continue
+		case f == "default":
+			for _, d := range defaultHTTPPaths {
+				if !slices.Contains(paths, d.path) {
+					paths = append(paths, d.path)
+				}
+			}
+			continue
+		case !strings.HasPrefix(f, "/") || strings.ContainsAny(f, " \t#"):
+			return nil, fmt.Errorf("%q is not a path", f)
+		case slices.Contains(paths, f):
+			continue
+		}
+		paths = append(paths, f)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no paths in %q", s)
+	}
+	if len(paths) > maxHTTPPaths {
+		return nil, fmt.Errorf("more than %d paths", maxHTTPPaths)
+	}
+	return paths, nil
+}
+
+// probeHTTPPaths requests each --http-paths path of the open ports of
+// @web, and lists those answered otherwise than a path no site has.
+func (p *scanPlan) probeHTTPPaths(ctx context.Context, hosts []HostResult) {
+	if len(p.httpPaths) == 0 {
+		return
+	}
+	ports, _ := parsePorts(builtinGroups["web"], nil)
+	dns := newDNSCache(p.dnsCache)
+	sem := make(chan struct{}, quicParallel)
+	var wg sync.WaitGroup
+	for i := range hosts {
+		h := &hosts[i]
+		sem <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			for _, pr := range h.Ports {
+				if pr.Protocol == "udp" || !slices.Contains(ports, pr.Port) {
+					continue
+				}
+				found, _, err := httpCheck(ctx, p, dns, h, pr.Port, pr.Port%1000 == 443, p.httpPathsCheck)
+				if err != nil {
+					slog.Warn("http path probe failed", "host", h.Host, "port", pr.Port, "err", err)
+				}
+				if found != nil {
+					for _, a := range *found {
+						a.Port = pr.Port
+						h.HTTPPaths = append(h.HTTPPaths, a)
+					}
+				}
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// httpPathsCheck requests a path no site has, then each --http-paths path,
+// and keeps the answers that differ from it: a site that answers 200 to
+// anything has those left out too.
+func (p *scanPlan) httpPathsCheck(ctx context.Context, c *httpEndpoint) (*[]HTTPPath, error) {
+	var b [8]byte
+	rand.Read(b[:])
+	missing, _, err := c.pathAsk(ctx, "/pscanner-"+hex.EncodeToString(b[:]))
+	if err != nil || c.wrongScheme {
+		return nil, err
+	}
+	found := []HTTPPath{}
+	for _, path := range p.httpPaths {
+		if ctx.Err() != nil {
+			break
+		}
+		a, body, err := c.pathAsk(ctx, path)
+		if err != nil {
+			continue
+		}
+		if a.Status/100 == 2 {
+			a.Found = pathFound(path, body)
+		}
+		if a.Found == "" && a.Status == missing.Status && max(a.Length, missing.Length)-min(a.Length, missing.Length) <= max(a.Length, missing.Length)/10+32 {
+			continue
+		}
+		found = append(found, a)
+	}
+	return &found, nil
+}
+
+// pathAsk requests path and returns the answer and its body.
+func (c *httpEndpoint) pathAsk(ctx context.Context, path string) (HTTPPath, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base+path, nil)
+	if err != nil {
+		return HTTPPath{}, nil, err
+	}
+	resp, body, err := c.roundTrip(req)
+	if err != nil {
+		return HTTPPath{}, nil, err
+	}
+	return HTTPPath{
+		Path:     path,
+		TLS:      strings.HasPrefix(c.base, "https:"),
+		Status:   resp.StatusCode,
+		Location: resp.Header.Get("Location"),
+		Length:   len(body),
+	}, body, nil
+}
+
+// pathFound names what the body of a default path shows it to be, or "".
+func pathFound(path string, body []byte) string {
+	for _, d := range defaultHTTPPaths {
+		if d.path == path && bytes.Contains(bytes.ToLower(body), []byte(d.marker)) {
+			return d.what
+		}
+	}
+	return ""
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseHTTPPaths(t *testing.T) {
+	paths, err := parseHTTPPaths("/admin, default,/metrics,")
+	if want := []string{"/admin", "/robots.txt", "/.git/HEAD", "/server-status", "/metrics"}; err != nil || !slices.Equal(paths, want) {
+		t.Errorf("parseHTTPPaths = %q, %v; want %q", paths, err, want)
+	}
+	for _, bad := range []string{"", ",", "admin", "/a b", "/#x"} {
+		if _, err := parseHTTPPaths(bad); err == nil {
+			t.Errorf("parseHTTPPaths accepted %q", bad)
+		}
+	}
+}
+
+func TestHTTPPathsCheck(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.git/HEAD", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ref: refs/heads/main")
+	})
+	mux.HandleFunc("/admin", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/login", http.StatusFound)
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	})
+	// Everything else gets the same page, as single-page apps do.
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<html><title>App</title><div id=root></div></html>")
+	})
+	plain := httptest.NewServer(mux)
+	defer plain.Close()
+	secure := httptest.NewTLSServer(mux)
+	defer secure.Close()
+
+	p := &scanPlan{timeout: time.Second, httpPaths: []string{"/robots.txt", "/.git/HEAD", "/server-status", "/metrics", "/admin"}}
+	h := &HostResult{Host: "127.0.0.1"}
+	for _, tt := range []struct {
+		name   string
+		port   int
+		useTLS bool
+	}{
+		{"HTTP", serverPort(t, plain), false},
+		// Tried in plain HTTP first, which the server refuses.
+		{"HTTPS", serverPort(t, secure), true},
+	} {
+		found, _, err := httpCheck(context.Background(), p, nil, h, tt.port, false, p.httpPathsCheck)
+		want := []HTTPPath{
+			{Path: "/.git/HEAD", TLS: tt.useTLS, Status: 200, Length: 21, Found: "Git repository"},
+			{Path: "/metrics", TLS: tt.useTLS, Status: 403, Length: 10},
+			{Path: "/admin", TLS: tt.useTLS, Status: 302, Location: "/login", Length: 29},
+		}
+		if err != nil || found == nil || !reflect.DeepEqual(*found, want) {
+			t.Errorf("%s: httpPathsCheck = %+v, %v; want %+v", tt.name, found, err, want)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// httpRequestTimeout bounds each HTTP request of the probes that check
+// web APIs, such as --containers, past the --timeout of its connection.
+const httpRequestTimeout = 5 * time.Second
+
+// httpCheck runs check against port of h over HTTPS if useTLS is set, or
+// plain HTTP, and over the other if that fails, as many APIs are served
+// either way. Its connections are made the way the scan made them.
+// certRequired is set when the server wants a client certificate. The
+// authentication schemes the port asks for are added to h.
+func httpCheck[T any](ctx context.Context, p *scanPlan, dns *dnsCache, h *HostResult, port int, useTLS bool, check func(context.Context, *httpEndpoint) (*T, error)) (found *T, certRequired bool, err error) {
+	client := &http.Client{
+		Timeout: httpRequestTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return p.probe(ctx, dns, job{host: h.Host, port: port, family: h.Family})
+			},
+			// Their certificates are mostly self-signed or from a
+			// private CA, and only the answers matter.
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			DisableKeepAlives: true,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	schemes := []string{"http", "https"}
+	if useTLS {
+		schemes = []string{"https", "http"}
+	}
+	var firstErr error
+	for _, scheme := range schemes {
+		c := &httpEndpoint{client: client, base: fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(h.Host, fmt.Sprint(port)))}
+		found, err := check(ctx, c)
+		c.askNTLM(ctx, h, port)
+		h.addHTTPAuth(port, c.auth)
+		if clientCertRequired(err) {
+			return nil, true, nil
+		}
+		if found != nil {
+			return found, false, nil
+		}
+		if err == nil && !c.wrongScheme {
+			return nil, false, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if isTimeout(firstErr) || errors.Is(firstErr, io.EOF) {
+		return nil, false, nil
+	}
+	return nil, false, firstErr
+}
+
+// clientCertRequired reports whether err is a TLS server's alert that it
+// wants a client certificate: "certificate required" in TLS 1.3, "bad
+// certificate" before.
+func clientCertRequired(err error) bool {
+	var oe *net.OpError
+	if !errors.As(err, &oe) || oe.Op != "remote error" {
+		return false
+	}
+	return strings.HasSuffix(oe.Err.Error(), "certificate required") || strings.HasSuffix(oe.Err.Error(), "bad certificate")
+}
+
+// httpEndpoint makes the requests of a check to one port.
+type httpEndpoint struct {
+	client *http.Client
+	base   string
+	// wrongScheme is set when the server answered in the other scheme,
+	// such as a plain HTTP request to an HTTPS port.
+	wrongScheme bool
+	// header is that of the last answer.
+	header http.Header
+	// auth are the WWW-Authenticate challenges of the answers.
+	auth []HTTPAuth
+}
+
+// do sends a request for path, with a JSON body if body is set, and
+// decodes a JSON answer into v if it has one. It returns the status.
+func (c *httpEndpoint) do(ctx context.Context, path string, body any, v any) (int, error) {
+	method, r := http.MethodGet, io.Reader(nil)
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		method, r = http.MethodPost, bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, r)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, data, err := c.roundTrip(req)
+	if err != nil {
+		return 0, err
+	}
+	if v != nil && json.Unmarshal(data, v) != nil {
+		// Not JSON: not the service it was asked for, whatever the status.
+		return resp.StatusCode, errNotJSON
+	}
+	return resp.StatusCode, nil
+}
+
+// get sends a GET request for path with the fields of header, and returns
+// the answer, whose body has been read and closed.
+func (c *httpEndpoint) get(ctx context.Context, path string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, _, err := c.roundTrip(req)
+	return resp, err
+}
+
+// roundTrip sends req and reads the answer's body, noting whether the
+// server speaks the other scheme.
+func (c *httpEndpoint) roundTrip(req *http.Request) (*http.Response, []byte, error) {
+	resp, err := c.client.Do(req)
+	if err != nil {
+		if strings.Contains(err.Error(), "server gave HTTP response to HTTPS client") {
+			c.wrongScheme = true
+		}
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	c.header = resp.Header
+	for _, a := range parseAuthenticate(resp.Header) {
+		a.Path, a.TLS = req.URL.RequestURI(), req.URL.Scheme == "https"
+		c.auth = append(c.auth, a)
+	}
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		// The body is the connection, now in the new protocol.
+		return resp, nil, nil
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode == http.StatusBadRequest && bytes.Contains(data, []byte("HTTP request to an HTTPS server")) {
+		c.wrongScheme = true
+	}
+	return resp, data, nil
+}
+
+var errNotJSON = errors.New("answer is not JSON")
+
+// credentialsAsked describes an answer of status to a request without
+// credentials, or returns "" if the status is not about them.
+func credentialsAsked(status int) string {
+	switch status {
+	case http.StatusUnauthorized:
+		return "authentication required"
+	case http.StatusForbidden:
+		return "anonymous requests allowed, but not authorized"
+	}
+	return ""
+}
+
+// ignoreNotJSON drops errNotJSON, which only says a port runs something
+// else.
+func ignoreNotJSON(err error) error {
+	if errors.Is(err, errNotJSON) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+)
+
+var importDoc = &commandDoc{
+	synopsis: "pscanner import [options] <nmap.xml | masscan.json>...",
+	description: `Rescan the open ports found by nmap or masscan.
+
+Each file is an nmap XML report (nmap -oX) or a masscan JSON report
+(masscan -oJ, or -oD for one object per line); the format is recognised
+from the content. The open TCP ports in the files become the target set:
+every host is probed only on the ports another tool found open on it, for
+example to confirm them from another vantage point or to follow up on a
+fast masscan sweep. Closed and filtered ports, UDP ports and hosts without
+open ports are left out.
+
+All scan options other than --host, --local-net, --ports, --top-ports and
+--checkpoint apply, and the results are reported as for "pscanner scan".`,
+	notes: importNotes(),
+	examples: []string{
+		"pscanner import nmap.xml",
+		"pscanner import --output json masscan.json > confirmed.json",
+		"pscanner import --dry-run sweep-1.xml sweep-2.xml",
+	},
+}
+
+// importNotes returns the notes of the scan flags that import shares.
+func importNotes() map[string]string {
+	notes := maps.Clone(scanDoc.notes)
+	for _, name := range []string{"host", "local-net", "ports", "top-ports", "checkpoint"} {
+		delete(notes, name)
+	}
+	return notes
+}
+
+// runImport implements the "import" command.
+func runImport(args []string) {
+	var o scanOptions
+	fs := o.newFlagSet("import", importDoc)
+	_ = fs.Parse(args)
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "error: no files to import")
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var imported importedPorts
+	for _, name := range fs.Args() {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(2)
+		}
+		if err := imported.parse(data); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s: %v\n", name, err)
+			os.Exit(2)
+		}
+	}
+	if len(imported.hosts) == 0 {
+		fmt.Fprintln(os.Stderr, "no open ports to rescan")
+		os.Exit(0)
+	}
+
+	o.host = strings.Join(imported.hosts, ",")
+	o.ports = formatPorts(imported.union())
+	o.imported = imported.ports()
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	// The imported ports always win over a profile's.
+	set["ports"] = true
+	o.execute(fs, set)
+}
+
+// importedPorts collects the open ports of each host across the imported
+// files. hosts keeps the order in which hosts first appear.
+type importedPorts struct {
+	hosts []string
+	open  map[string]map[int]bool
+}
+
+// add records an open port. Addresses are normalised so that they match
+// the target strings the scan produces.
+func (ip *importedPorts) add(host string, port int) error {
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("invalid port %d for %s", port, host)
+	}
+	if a, err := netip.ParseAddr(host); err == nil {
+		host = a.String()
+	}
+	if ip.open == nil {
+		ip.open = make(map[string]map[int]bool)
+	}
+	if ip.open[host] == nil {
+		ip.open[host] = make(map[int]bool)
+		ip.hosts = append(ip.hosts, host)
+	}
+	ip.open[host][port] = true
+	return nil
+}
+
+// ports returns the sorted ports of each host.
+func (ip *importedPorts) ports() map[string][]int {
+	m := make(map[string][]int, len(ip.open))
+	for h, set := range ip.open {
+		for p := range set {
+			m[h] = append(m[h], p)
+		}
+		slices.Sort(m[h])
+	}
+	return m
+}
+
+// union returns every imported port, sorted.
+func (ip *importedPorts) union() []int {
+	seen := make(map[int]bool)
+	var all []int
+	for _, set := range ip.open {
+		for p := range set {
+			if !seen[p] {
+				seen[p] = true
+				all = append(all, p)
+			}
+		}
+	}
+	slices.Sort(all)
+	return all
+}
+
+// parse adds the open TCP ports of an nmap XML or masscan JSON report.
+func (ip *importedPorts) parse(data []byte) error {
+	data = bytes.TrimSpace(data)
+	switch {
+	case len(data) == 0:
+		return errors.New("empty file")
+	case data[0] == '<':
+		return ip.parseNmap(data)
+	case data[0] == '[' || data[0] == '{':
+		return ip.parseMasscan(data)
+	}
+	return errors.New("not an nmap XML or masscan JSON report")
+}
+
+// nmapRun is the part of an nmap XML report that import reads.
+type nmapRun struct {
+	XMLName xml.Name `xml:"nmaprun"`
+	Hosts   []struct {
+		Addresses []struct {
+			Addr     string `xml:"addr,attr"`
+			AddrType string `xml:"addrtype,attr"`
+		} `xml:"address"`
+		Ports []struct {
+			Protocol string `xml:"protocol,attr"`
+			PortID   int    `xml:"portid,attr"`
+			State    struct {
+				State string `xml:"state,attr"`
+			} `xml:"state"`
+		} `xml:"ports>port"`
+	} `xml:"host"`
+}
+
+func (ip *importedPorts) parseNmap(data []byte) error {
+	var run nmapRun
+	if err := xml.Unmarshal(data, &run); err != nil {
+		return fmt.Errorf("parsing nmap XML: %v", err)
+	}
+	for _, h := range run.Hosts {
+		// The IP address is what nmap probed; a MAC address cannot be
+		// dialed and a hostname may resolve elsewhere by now.
+		var addr string
+		for _, a := range h.Addresses {
+			if a.AddrType == "ipv4" || a.AddrType == "ipv6" {
+				addr = a.Addr
+				break
+			}
+		}
+		if addr == "" {
+			continue
+		}
+		for _, p := range h.Ports {
+			if p.Protocol != "tcp" || p.State.State != "open" {
+				continue
+			}
+			if err := ip.add(addr, p.PortID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// masscanRecord is one object of a masscan JSON report. Banner records
+// carry a service instead of a status and are skipped.
+type masscanRecord struct {
+	IP    string `json:"ip"`
+	Ports []struct {
+		Port   int    `json:"port"`
+		Proto  string `json:"proto"`
+		Status string `json:"status"`
+	} `json:"ports"`
+}
+
+func (ip *importedPorts) parseMasscan(data []byte) error {
+	var records []masscanRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		// Older masscan versions write a trailing comma before the closing
+		// bracket, and -oD writes one object per line, so fall back to
+		// reading the objects line by line.
+		records = records[:0]
+		sc := bufio.NewScanner(bytes.NewReader(data))
+		sc.Buffer(nil, 1<<20)
+		for n := 1; sc.Scan(); n++ {
+			line := strings.TrimSuffix(strings.TrimSpace(sc.Text()), ",")
+			// Versions before 1.0.4 also end the list with {finished: 1}.
+			if line == "" || line == "[" || line == "]" || strings.HasPrefix(line, "{finished") {
+				continue
+			}
+			var r masscanRecord
+			if err := json.Unmarshal([]byte(line), &r); err != nil {
+				return fmt.Errorf("parsing masscan JSON: line %d: %v", n, err)
+			}
+			records = append(records, r)
+		}
+		if err := sc.Err(); err != nil {
+			return err
+		}
+	}
+	for _, r := range records {
+		for _, p := range r.Ports {
+			if r.IP == "" || p.Proto != "tcp" || p.Status != "open" {
+				continue
+			}
+			if err := ip.add(r.IP, p.Port); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
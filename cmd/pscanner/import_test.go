@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const nmapXML = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE nmaprun>
+<nmaprun scanner="nmap" args="nmap -oX - 10.0.0.1-2" version="7.94">
+<host><status state="up"/>
+<address addr="10.0.0.2" addrtype="ipv4"/>
+<address addr="00:11:22:33:44:55" addrtype="mac"/>
+<hostnames><hostname name="db.example" type="PTR"/></hostnames>
+<ports>
+<port protocol="tcp" portid="5432"><state state="open" reason="syn-ack"/></port>
+<port protocol="tcp" portid="22"><state state="open" reason="syn-ack"/></port>
+<port protocol="tcp" portid="23"><state state="closed" reason="reset"/></port>
+<port protocol="udp" portid="53"><state state="open" reason="udp-response"/></port>
+</ports>
+</host>
+<host><status state="up"/>
+<address addr="2001:0db8::0001" addrtype="ipv6"/>
+<ports><port protocol="tcp" portid="443"><state state="filtered" reason="no-response"/></port></ports>
+</host>
+</nmaprun>`
+
+func TestImportNmap(t *testing.T) {
+	var ip importedPorts
+	if err := ip.parse([]byte(nmapXML)); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]int{"10.0.0.2": {22, 5432}}
+	if got := ip.ports(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ports = %v, want %v", got, want)
+	}
+}
+
+func TestImportMasscan(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"json", `[
+{"ip": "10.0.0.7", "timestamp": "1760000000", "ports": [{"port": 80, "proto": "tcp", "status": "open", "reason": "syn-ack", "ttl": 64}]},
+{"ip": "10.0.0.7", "timestamp": "1760000001", "ports": [{"port": 80, "proto": "tcp", "service": {"name": "http", "banner": "nginx"}}]},
+{"ip": "10.0.0.3", "timestamp": "1760000002", "ports": [{"port": 161, "proto": "udp", "status": "open"}]},
+{"ip": "10.0.0.7", "timestamp": "1760000003", "ports": [{"port": 22, "proto": "tcp", "status": "open"}]}
+]`},
+		{"old json", `[
+{   "ip": "10.0.0.7",   "timestamp": "1760000000", "ports": [ {"port": 80, "proto": "tcp", "status": "open", "reason": "syn-ack", "ttl": 64} ] },
+{   "ip": "10.0.0.7",   "timestamp": "1760000003", "ports": [ {"port": 22, "proto": "tcp", "status": "open", "reason": "syn-ack", "ttl": 64} ] },
+{finished: 1}
+]`},
+		{"ndjson", `{"ip":"10.0.0.7","timestamp":"1760000000","port":80,"ports":[{"port":80,"proto":"tcp","status":"open"}]}
+{"ip":"10.0.0.7","timestamp":"1760000003","port":22,"ports":[{"port":22,"proto":"tcp","status":"open"}]}
+`},
+	}
+	want := map[string][]int{"10.0.0.7": {22, 80}}
+	for _, tt := range tests {
+		var ip importedPorts
+		if err := ip.parse([]byte(tt.data)); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := ip.ports(); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: ports = %v, want %v", tt.name, got, want)
+		}
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	for _, data := range []string{"", "open tcp 80 10.0.0.1 1760000000", "[{\"ip\": \"10.0.0.1\",", `<nmaprun><host><address addr="10.0.0.1" addrtype="ipv4"/><ports><port protocol="tcp" portid="70000"><state state="open"/></port></ports></host></nmaprun>`} {
+		var ip importedPorts
+		if err := ip.parse([]byte(data)); err == nil {
+			t.Errorf("parse(%q) = nil, want error", data)
+		}
+	}
+}
+
+func TestImportHostOrder(t *testing.T) {
+	var ip importedPorts
+	ip.add("10.0.0.9", 443)
+	ip.add("10.0.0.1", 22)
+	ip.add("10.0.0.9", 80)
+	if want := []string{"10.0.0.9", "10.0.0.1"}; !reflect.DeepEqual(ip.hosts, want) {
+		t.Errorf("hosts = %v, want %v", ip.hosts, want)
+	}
+	if got, want := ip.union(), []int{22, 80, 443}; !reflect.DeepEqual(got, want) {
+		t.Errorf("union = %v, want %v", got, want)
+	}
+}
+
+func TestRunProbesHostPortsOnly(t *testing.T) {
+	open, other := localPort(t), localPort(t)
+	targets, _ := parseTargets("127.0.0.1")
+	plan := &scanPlan{
+		targets: targets, numTargets: 1, ports: []int{open, other}, workers: 1, timeout: time.Second,
+		hostPorts: map[string][]int{"127.0.0.1": {open}},
+	}
+	if n := plan.probes(); n != 1 {
+		t.Errorf("probes = %d, want 1", n)
+	}
+	hosts := plan.run(context.Background(), scanHooks{})
+	if len(hosts) != 1 || len(hosts[0].Ports) != 1 || hosts[0].Ports[0].Port != open {
+		t.Errorf("run = %+v", hosts)
+	}
+	// The report says which ports the host was probed on, for diffs.
+	if want := strconv.Itoa(open); len(hosts) == 1 && hosts[0].Probed != want {
+		t.Errorf("probed ports = %q, want %q", hosts[0].Probed, want)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// OpenInstance is an Elasticsearch, Kibana or Prometheus instance that
+// --open-instances found, and whether it answers anyone.
+type OpenInstance struct {
+	Port int `json:"port"`
+	// Service is elasticsearch, kibana or prometheus.
+	Service string `json:"service"`
+	Version string `json:"version,omitempty"`
+	// Unauthenticated is set when the instance answers requests without
+	// credentials: the exposure --open-instances looks for.
+	Unauthenticated bool `json:"unauthenticated"`
+	// Details says what it showed, such as how many indices it holds, or
+	// how it asked for credentials.
+	Details string `json:"details,omitempty"`
+}
+
+func (o OpenInstance) String() string {
+	s := fmt.Sprintf("tcp/%d %s", o.Port, o.Service)
+	if o.Version != "" {
+		s += " " + o.Version
+	}
+	switch {
+	case o.Unauthenticated && o.Details != "":
+		s += ": unauthenticated; " + o.Details
+	case o.Unauthenticated:
+		s += ": unauthenticated"
+	case o.Details != "":
+		s += ": " + o.Details
+	}
+	return s
+}
+
+// instanceChecks are the checks of --open-instances, with the port each
+// service usually listens on, in the order they are tried on other ports.
+var instanceChecks = []struct {
+	service string
+	port    int
+	check   func(context.Context, *httpEndpoint) (*OpenInstance, error)
+}{
+	{"elasticsearch", 9200, elasticsearchCheck},
+	{"kibana", 5601, kibanaCheck},
+	{"prometheus", 9090, prometheusCheck},
+}
+
+// probeOpenInstances checks the open ports of Elasticsearch, Kibana and
+// Prometheus for instances that answer without credentials, and the open
+// ports of @web for any of them, as they are often published on a port
+// of their own choosing or behind a proxy. It only reads: versions, and
+// lists of indices or scrape targets, which it counts.
+func (p *scanPlan) probeOpenInstances(ctx context.Context, hosts []HostResult) {
+	if !p.instances {
+		return
+	}
+	web, _ := parsePorts(builtinGroups["web"], nil)
+	dns := newDNSCache(p.dnsCache)
+	sem := make(chan struct{}, quicParallel)
+	var wg sync.WaitGroup
+	for i := range hosts {
+		h := &hosts[i]
+		sem <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			for _, pr := range h.Ports {
+				if pr.Protocol == "udp" {
+					continue
+				}
+				for _, c := range instanceChecks {
+					if c.port != pr.Port && (!slices.Contains(web, pr.Port) || isInstancePort(pr.Port)) {
+						continue
+					}
+					o, err := p.instanceProbe(ctx, dns, h, pr.Port, c.service, c.check)
+					if err != nil {
+						slog.Warn("open instance probe failed", "host", h.Host, "port", pr.Port, "service", c.service, "err", err)
+					}
+					if o != nil {
+						h.OpenInstances = append(h.OpenInstances, *o)
+						break
+					}
+				}
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// unauthenticatedInstances counts the instances of hosts that answer
+// anyone, which reports warn of first.
+func unauthenticatedInstances(hosts []HostResult) int {
+	n := 0
+	for _, h := range hosts {
+		for _, o := range h.OpenInstances {
+			if o.Unauthenticated {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// isInstancePort reports whether port is the usual port of one of the
+// services --open-instances checks.
+func isInstancePort(port int) bool {
+	for _, c := range instanceChecks {
+		if c.port == port {
+			return true
+		}
+	}
+	return false
+}
+
+// instanceProbe checks port of h for service over HTTP, and over HTTPS if
+// that fails; on ports of 443 and x443 HTTPS goes first.
+func (p *scanPlan) instanceProbe(ctx context.Context, dns *dnsCache, h *HostResult, port int, service string, check func(context.Context, *httpEndpoint) (*OpenInstance, error)) (*OpenInstance, error) {
+	o, certRequired, err := httpCheck(ctx, p, dns, h, port, port%1000 == 443, check)
+	if certRequired && isInstancePort(port) {
+		return &OpenInstance{Port: port, Service: service, Details: "client certificate required"}, nil
+	}
+	if o != nil {
+		o.Port, o.Service = port, service
+	}
+	return o, err
+}
+
+// elasticsearchCheck asks Elasticsearch (or OpenSearch) for its root
+// document, which names the cluster and version, then counts its indices.
+// Open to anyone, it gives anyone every document.
+func elasticsearchCheck(ctx context.Context, c *httpEndpoint) (*OpenInstance, error) {
+	var root struct {
+		ClusterName string `json:"cluster_name"`
+		Version     struct {
+			Number       string `json:"number"`
+			Distribution string `json:"distribution"`
+		} `json:"version"`
+		Error struct {
+			Type string `json:"type"`
+		} `json:"error"`
+	}
+	status, err := c.do(ctx, "/", nil, &root)
+	switch {
+	case err != nil:
+		return nil, ignoreNotJSON(err)
+	case status == http.StatusOK && root.ClusterName != "" && root.Version.Number != "":
+	case root.Error.Type == "security_exception" && credentialsAsked(status) != "":
+		return &OpenInstance{Details: credentialsAsked(status)}, nil
+	default:
+		return nil, nil
+	}
+	o := &OpenInstance{Version: root.Version.Number, Unauthenticated: true, Details: fmt.Sprintf("cluster %q", root.ClusterName)}
+	if root.Version.Distribution == "opensearch" {
+		o.Details = "OpenSearch " + o.Details
+	}
+	var indices []json.RawMessage
+	if status, err := c.do(ctx, "/_cat/indices?format=json", nil, &indices); err == nil && status == http.StatusOK {
+		o.Details += fmt.Sprintf(", %d indices", len(indices))
+	}
+	return o, nil
+}
+
+// kibanaCheck asks Kibana for its status, which it only gives anyone
+// when it has no security, and then anyone can read what it shows of
+// Elasticsearch.
+func kibanaCheck(ctx context.Context, c *httpEndpoint) (*OpenInstance, error) {
+	var st struct {
+		Name    string `json:"name"`
+		Version struct {
+			Number string `json:"number"`
+		} `json:"version"`
+		Status struct {
+			Overall struct {
+				Level string `json:"level"` // 8.x
+				State string `json:"state"` // 7.x
+			} `json:"overall"`
+		} `json:"status"`
+	}
+	status, err := c.do(ctx, "/api/status", nil, &st)
+	// Kibana names itself in a header of every answer.
+	kibana := c.header.Get("Kbn-Name") != ""
+	switch {
+	case err != nil && !kibana:
+		return nil, ignoreNotJSON(err)
+	case status == http.StatusOK && st.Version.Number != "" && (kibana || st.Name != ""):
+	case kibana && credentialsAsked(status) != "":
+		return &OpenInstance{Details: credentialsAsked(status)}, nil
+	default:
+		return nil, nil
+	}
+	o := &OpenInstance{Version: st.Version.Number, Unauthenticated: true, Details: fmt.Sprintf("name %q", st.Name)}
+	if state := strings.TrimSpace(st.Status.Overall.Level + st.Status.Overall.State); state != "" {
+		o.Details += ", status " + state
+	}
+	return o, nil
+}
+
+// prometheusCheck asks Prometheus for its build, then counts its scrape
+// targets, which map the network it monitors. It has no authentication
+// of its own unless configured with basic auth or behind a proxy.
+func prometheusCheck(ctx context.Context, c *httpEndpoint) (*OpenInstance, error) {
+	var build struct {
+		Status string `json:"status"`
+		Data   struct {
+			Version string `json:"version"`
+		} `json:"data"`
+	}
+	status, err := c.do(ctx, "/api/v1/status/buildinfo", nil, &build)
+	if err != nil || status != http.StatusOK || build.Status != "success" || build.Data.Version == "" {
+		return nil, ignoreNotJSON(err)
+	}
+	o := &OpenInstance{Version: build.Data.Version, Unauthenticated: true}
+	var targets struct {
+		Status string `json:"status"`
+		Data   struct {
+			ActiveTargets []json.RawMessage `json:"activeTargets"`
+		} `json:"data"`
+	}
+	if status, err := c.do(ctx, "/api/v1/targets?state=active", nil, &targets); err == nil && status == http.StatusOK && targets.Status == "success" {
+		o.Details = fmt.Sprintf("%d active targets", len(targets.Data.ActiveTargets))
+	}
+	return o, nil
+}
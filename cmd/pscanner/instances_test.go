@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInstanceProbe(t *testing.T) {
+	elasticsearch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			io.WriteString(w, `{"name":"es01","cluster_name":"logs","version":{"number":"8.11.1"},"tagline":"You Know, for Search"}`)
+		case "/_cat/indices":
+			io.WriteString(w, `[{"index":"a"},{"index":"b"}]`)
+		}
+	}))
+	defer elasticsearch.Close()
+	secured := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="security"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, `{"error":{"type":"security_exception","reason":"missing authentication credentials"},"status":401}`)
+	}))
+	defer secured.Close()
+	kibana := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("kbn-name", "kibana")
+		io.WriteString(w, `{"name":"kibana","version":{"number":"8.11.1"},"status":{"overall":{"level":"available"}}}`)
+	}))
+	defer kibana.Close()
+	kibanaAuth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("kbn-name", "kibana")
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, `{"statusCode":401,"error":"Unauthorized","message":"Unauthorized"}`)
+	}))
+	defer kibanaAuth.Close()
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/status/buildinfo":
+			io.WriteString(w, `{"status":"success","data":{"version":"2.48.0","revision":"x"}}`)
+		case "/api/v1/targets":
+			io.WriteString(w, `{"status":"success","data":{"activeTargets":[{},{},{}],"droppedTargets":[]}}`)
+		}
+	}))
+	defer prometheus.Close()
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "<html>hello</html>")
+	}))
+	defer web.Close()
+
+	p := &scanPlan{timeout: time.Second}
+	h := &HostResult{Host: "127.0.0.1"}
+	for _, tt := range []struct {
+		server *httptest.Server
+		check  int // of instanceChecks
+		want   *OpenInstance
+	}{
+		{elasticsearch, 0, &OpenInstance{Service: "elasticsearch", Version: "8.11.1", Unauthenticated: true, Details: `cluster "logs", 2 indices`}},
+		{secured, 0, &OpenInstance{Service: "elasticsearch", Details: "authentication required"}},
+		{kibana, 1, &OpenInstance{Service: "kibana", Version: "8.11.1", Unauthenticated: true, Details: `name "kibana", status available`}},
+		{kibanaAuth, 1, &OpenInstance{Service: "kibana", Details: "authentication required"}},
+		{prometheus, 2, &OpenInstance{Service: "prometheus", Version: "2.48.0", Unauthenticated: true, Details: "3 active targets"}},
+		{web, 0, nil},
+		{web, 1, nil},
+		{web, 2, nil},
+		// Elasticsearch is not Prometheus.
+		{elasticsearch, 2, nil},
+	} {
+		c := instanceChecks[tt.check]
+		port := serverPort(t, tt.server)
+		if tt.want != nil {
+			tt.want.Port = port
+		}
+		got, err := p.instanceProbe(context.Background(), nil, h, port, c.service, c.check)
+		if err != nil || (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+			t.Errorf("instanceProbe of %s at %s = %+v, %v; want %+v", c.service, tt.server.URL, got, err, tt.want)
+		}
+	}
+}
+
+func TestOpenInstanceWarnings(t *testing.T) {
+	r := &Report{
+		Parameters: scanParams{TargetCount: 1, Instances: true},
+		Hosts: []HostResult{{Host: "10.0.0.5", OpenInstances: []OpenInstance{
+			{Port: 9200, Service: "elasticsearch", Version: "8.11.1", Unauthenticated: true, Details: `cluster "logs", 2 indices`},
+			{Port: 5601, Service: "kibana", Details: "authentication required"},
+		}}},
+	}
+	var text bytes.Buffer
+	if err := writeText(&text, r); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Warning: 1 Elasticsearch, Kibana or Prometheus instances answer without credentials\n",
+		"Open instance: tcp/9200 elasticsearch 8.11.1: unauthenticated; cluster \"logs\", 2 indices\n",
+		"Open instance: tcp/5601 kibana: authentication required\n",
+	} {
+		if !strings.Contains(text.String(), want) {
+			t.Errorf("text report lacks %q:\n%s", want, text.String())
+		}
+	}
+
+	var syslog bytes.Buffer
+	if err := writeSyslog(&syslog, r); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(syslog.String()), "\n")
+	var warnings []string
+	for _, l := range lines {
+		if strings.HasPrefix(l, "<12>") {
+			warnings = append(warnings, l)
+		}
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], ` open-instance [`) ||
+		!strings.Contains(warnings[0], `service="elasticsearch" version="8.11.1"]`) ||
+		!strings.HasSuffix(warnings[0], " elasticsearch 10.0.0.5:9200 answers without credentials") {
+		t.Errorf("syslog warnings = %q", warnings)
+	}
+}
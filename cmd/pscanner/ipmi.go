@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// ipmiUsers are the user names the RAKP check asks a BMC for: none, and
+// the defaults of Supermicro, Dell, HP and IBM.
+var ipmiUsers = []string{"", "ADMIN", "admin", "root", "Administrator", "USERID"}
+
+// RMCP+ payload types and status codes (IPMI v2.0, 13.27).
+const (
+	ipmiOpenSessionRequest  = 0x10
+	ipmiOpenSessionResponse = 0x11
+	ipmiRAKP1               = 0x12
+	ipmiRAKP2               = 0x13
+
+	ipmiUnauthorizedName = 0x0d
+)
+
+// ipmiProbe asks the BMC at port of addr for its authentication
+// capabilities over RMCP and, if it speaks IPMI 2.0, checks for the two
+// flaws that give its administrator away to anyone who can reach it:
+// cipher zero, which lets a session in without a password, and RAKP,
+// whose second message carries an HMAC of the user's password that can be
+// cracked offline (CVE-2013-4786). The checks leave half-open sessions,
+// which the BMC drops after a minute or so.
+func (p *scanPlan) ipmiProbe(ctx context.Context, addr netip.Addr, port int) (*UDPService, error) {
+	conn, err := p.dialUDP(addr, port)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	defer context.AfterFunc(ctx, func() { conn.Close() })()
+
+	resp := ipmiExchange(conn, ipmiCapabilitiesRequest(), p.timeout, func(b []byte) bool {
+		_, ok := parseIPMICapabilities(b)
+		return ok
+	})
+	if resp == nil {
+		return nil, nil
+	}
+	caps, _ := parseIPMICapabilities(resp)
+	s := &UDPService{Details: caps.String()}
+	if caps.anonymous {
+		s.Issues = append(s.Issues, "anonymous login")
+	}
+	if !caps.v2 {
+		return s, nil
+	}
+
+	tag := byte(0)
+	openSession := func(auth, integrity, confidentiality byte) (managed uint32, ok bool) {
+		tag++
+		console := randUint32()
+		payload := ipmiExchange(conn, ipmiOpenSession(tag, console, auth, integrity, confidentiality), p.timeout, func(b []byte) bool {
+			payload, ok := parseRMCPPlus(b, ipmiOpenSessionResponse)
+			return ok && len(payload) >= 12 && payload[0] == tag
+		})
+		if payload == nil {
+			return 0, false
+		}
+		payload, _ = parseRMCPPlus(payload, ipmiOpenSessionResponse)
+		return binary.LittleEndian.Uint32(payload[8:]), payload[1] == 0
+	}
+
+	// Cipher suite 0: no authentication, integrity or confidentiality.
+	if _, ok := openSession(0, 0, 0); ok {
+		s.Issues = append(s.Issues, "cipher zero")
+	}
+	for _, user := range ipmiUsers {
+		// Cipher suite 1: RAKP-HMAC-SHA1, the one every BMC has.
+		managed, ok := openSession(1, 0, 0)
+		if !ok || ctx.Err() != nil {
+			break
+		}
+		tag++
+		random := make([]byte, 16)
+		rand.Read(random)
+		payload := ipmiExchange(conn, ipmiRAKPMessage1(tag, managed, random, user), p.timeout, func(b []byte) bool {
+			payload, ok := parseRMCPPlus(b, ipmiRAKP2)
+			return ok && len(payload) >= 2 && payload[0] == tag
+		})
+		if payload == nil {
+			break
+		}
+		payload, _ = parseRMCPPlus(payload, ipmiRAKP2)
+		if payload[1] == 0 && len(payload) > 40 {
+			s.Issues = append(s.Issues, fmt.Sprintf("RAKP discloses the password hash of %q", user))
+			break
+		}
+		if payload[1] != ipmiUnauthorizedName {
+			break
+		}
+	}
+	return s, nil
+}
+
+// ipmiExchange sends req on conn and returns the first answer that match
+// accepts, or nil if none comes before timeout.
+func ipmiExchange(conn *net.UDPConn, req []byte, timeout time.Duration, match func([]byte) bool) []byte {
+	if _, err := conn.Write(req); err != nil {
+		return nil
+	}
+	buf := make([]byte, 1024)
+	deadline := time.Now().Add(timeout)
+	for {
+		conn.SetReadDeadline(deadline)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil
+		}
+		if match(buf[:n]) {
+			return buf[:n]
+		}
+	}
+}
+
+// ipmiCapabilitiesRequest is a Get Channel Authentication Capabilities
+// request in an IPMI 1.5 session-less message, asking for the IPMI 2.0
+// capabilities too, as ipmitool and every scanner send it.
+func ipmiCapabilitiesRequest() []byte {
+	return []byte{
+		0x06, 0x00, 0xff, 0x07, // RMCP version 1.0, no ACK, class IPMI
+		0x00, 0, 0, 0, 0, 0, 0, 0, 0, // no authentication, sequence and session 0
+		0x09,             // message length
+		0x20, 0x18, 0xc8, // to the BMC, network function App, checksum
+		0x81, 0x00, 0x38, // from a remote console, sequence 0, the command
+		0x8e, 0x04, // this channel, with IPMI 2.0 data; administrator
+		0xb5, // checksum
+	}
+}
+
+// ipmiCapabilities is what a BMC's authentication capabilities say.
+type ipmiCapabilities struct {
+	v2        bool     // it takes IPMI 2.0 (RMCP+) sessions
+	auth      []string // its IPMI 1.5 authentication types
+	anonymous bool     // a session with a null user and password is let in
+}
+
+func (c ipmiCapabilities) String() string {
+	s := "IPMI 1.5"
+	if c.v2 {
+		s = "IPMI 2.0"
+	}
+	if len(c.auth) > 0 {
+		s += ", authentication " + strings.Join(c.auth, ", ")
+	}
+	return s
+}
+
+// ipmiAuthTypes name the bits of the authentication types a BMC supports.
+var ipmiAuthTypes = [...]string{0: "none", 1: "MD2", 2: "MD5", 4: "password", 5: "OEM"}
+
+// parseIPMICapabilities reads the answer to ipmiCapabilitiesRequest.
+func parseIPMICapabilities(b []byte) (ipmiCapabilities, bool) {
+	// RMCP, then the session header and the message: response addresses,
+	// command and completion code, then the capabilities.
+	if len(b) < 14 || b[0] != 0x06 || b[3]&0x1f != 0x07 || b[4] != 0 {
+		return ipmiCapabilities{}, false
+	}
+	msg := b[14:]
+	if n := int(b[13]); n < len(msg) {
+		msg = msg[:n]
+	}
+	if len(msg) < 11 || msg[1]>>2 != 0x07 || msg[5] != 0x38 || msg[6] != 0 {
+		return ipmiCapabilities{}, false
+	}
+	data := msg[7:]
+	var c ipmiCapabilities
+	for bit, name := range ipmiAuthTypes {
+		if name != "" && data[1]&(1<<bit) != 0 {
+			c.auth = append(c.auth, name)
+		}
+	}
+	c.anonymous = data[2]&0x01 != 0
+	c.v2 = data[1]&0x80 != 0 && data[3]&0x02 != 0
+	return c, true
+}
+
+// rmcpPlus wraps an RMCP+ payload of typ outside any session.
+func rmcpPlus(typ byte, payload []byte) []byte {
+	b := []byte{0x06, 0x00, 0xff, 0x07, 0x06, typ, 0, 0, 0, 0, 0, 0, 0, 0}
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(payload)))
+	return append(b, payload...)
+}
+
+// parseRMCPPlus returns the payload of an RMCP+ message of typ.
+func parseRMCPPlus(b []byte, typ byte) ([]byte, bool) {
+	if len(b) < 16 || b[0] != 0x06 || b[3]&0x1f != 0x07 || b[4] != 0x06 || b[5]&0x3f != typ {
+		return nil, false
+	}
+	n := int(binary.LittleEndian.Uint16(b[14:]))
+	if len(b) < 16+n {
+		return nil, false
+	}
+	return b[16 : 16+n], true
+}
+
+// ipmiOpenSession is an RMCP+ Open Session Request for the cipher suite of
+// the given algorithms, at the highest privilege they allow.
+func ipmiOpenSession(tag byte, console uint32, auth, integrity, confidentiality byte) []byte {
+	payload := []byte{tag, 0, 0, 0}
+	payload = binary.LittleEndian.AppendUint32(payload, console)
+	for i, alg := range []byte{auth, integrity, confidentiality} {
+		payload = append(payload, byte(i), 0, 0, 8, alg, 0, 0, 0)
+	}
+	return rmcpPlus(ipmiOpenSessionRequest, payload)
+}
+
+// ipmiRAKPMessage1 is the first message of the RAKP handshake of session
+// managed, for user at administrator privilege, looked up by name alone.
+func ipmiRAKPMessage1(tag byte, managed uint32, random []byte, user string) []byte {
+	payload := []byte{tag, 0, 0, 0}
+	payload = binary.LittleEndian.AppendUint32(payload, managed)
+	payload = append(payload, random...)
+	payload = append(payload, 0x14, 0, 0, byte(len(user)))
+	payload = append(payload, user...)
+	return rmcpPlus(ipmiRAKP1, payload)
+}
+
+func randUint32() uint32 {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return binary.LittleEndian.Uint32(b)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeBMC answers like a BMC whose ADMIN user RAKP gives away, taking
+// cipher suite 0 if cipherZero is set, and IPMI 2.0 sessions only if v2.
+func fakeBMC(cipherZero, v2 bool) func(req []byte) [][]byte {
+	return func(req []byte) [][]byte {
+		if len(req) < 16 {
+			return nil
+		}
+		if req[4] == 0 {
+			// Get Channel Authentication Capabilities: MD5 and password,
+			// anonymous login.
+			auth, ext := byte(0x14), byte(0x01)
+			if v2 {
+				auth, ext = auth|0x80, ext|0x02
+			}
+			msg := []byte{0x81, 0x1c, 0x63, 0x20, 0x00, 0x38, 0x00, 0x01, auth, 0x01, ext, 0, 0, 0, 0, 0}
+			resp := append([]byte{0x06, 0x00, 0xff, 0x07, 0, 0, 0, 0, 0, 0, 0, 0, 0, byte(len(msg))}, msg...)
+			return [][]byte{resp}
+		}
+		payload := req[16:]
+		switch req[5] {
+		case ipmiOpenSessionRequest:
+			status := byte(0)
+			if payload[12] == 0 && !cipherZero { // the authentication algorithm
+				status = 0x11 // invalid authentication algorithm
+			}
+			resp := []byte{payload[0], status, 4, 0}
+			resp = append(resp, payload[4:8]...)
+			resp = binary.LittleEndian.AppendUint32(resp, 0x1234)
+			resp = append(resp, payload[8:32]...)
+			return [][]byte{rmcpPlus(ipmiOpenSessionResponse, resp)}
+		case ipmiRAKP1:
+			user := string(payload[28:])
+			if binary.LittleEndian.Uint32(payload[4:]) != 0x1234 || user != "ADMIN" {
+				return [][]byte{rmcpPlus(ipmiRAKP2, []byte{payload[0], ipmiUnauthorizedName, 0, 0})}
+			}
+			resp := []byte{payload[0], 0, 0, 0}
+			resp = append(resp, make([]byte, 4+16+16+20)...)
+			return [][]byte{rmcpPlus(ipmiRAKP2, resp)}
+		}
+		return nil
+	}
+}
+
+func TestIPMIProbe(t *testing.T) {
+	p := &scanPlan{timeout: time.Second}
+	addr := netip.MustParseAddr("127.0.0.1")
+	for _, tt := range []struct {
+		cipherZero, v2 bool
+		want           *UDPService
+	}{
+		{true, true, &UDPService{Details: "IPMI 2.0, authentication MD5, password", Issues: []string{"anonymous login", "cipher zero", `RAKP discloses the password hash of "ADMIN"`}}},
+		{false, true, &UDPService{Details: "IPMI 2.0, authentication MD5, password", Issues: []string{"anonymous login", `RAKP discloses the password hash of "ADMIN"`}}},
+		{false, false, &UDPService{Details: "IPMI 1.5, authentication MD5, password", Issues: []string{"anonymous login"}}},
+	} {
+		port := serveUDP(t, fakeBMC(tt.cipherZero, tt.v2), false)
+		got, err := p.ipmiProbe(context.Background(), addr, port)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ipmiProbe(cipher zero %v, IPMI 2.0 %v) = %+v, %v; want %+v", tt.cipherZero, tt.v2, got, err, tt.want)
+		}
+	}
+
+	p.timeout = 200 * time.Millisecond
+	if got, err := p.ipmiProbe(context.Background(), addr, freeUDPPort(t)); got != nil || err != nil {
+		t.Errorf("ipmiProbe of a closed port = %+v, %v", got, err)
+	}
+}
+
+func TestUDPServiceString(t *testing.T) {
+	s := UDPService{Port: 623, Service: "ipmi", Details: "IPMI 2.0", Issues: []string{"cipher zero", "anonymous login"}}
+	if got, want := s.String(), "udp/623 ipmi: IPMI 2.0 [cipher zero; anonymous login]"; got != want {
+		t.Errorf("String = %q, want %q", got, want)
+	}
+}
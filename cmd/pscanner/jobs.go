@@ -0,0 +1,449 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Scan job states.
+const (
+	jobRunning   = "running"
+	jobCompleted = "completed"
+	jobCanceled  = "canceled"
+)
+
+// maxFinishedJobs bounds how many finished scans the server keeps in memory
+// for late StreamResults calls; the oldest are dropped first. Scans saved
+// to a result store remain available from there.
+const maxFinishedJobs = 100
+
+// scanRequest is a scan submitted to the server. It mirrors the flags of
+// "pscanner scan"; unset fields take the profile value, then the default.
+type scanRequest struct {
+	Hosts       string    `json:"hosts"`
+	Ports       string    `json:"ports,omitempty"`
+	TopPorts    int       `json:"top_ports,omitempty"`
+	Profile     string    `json:"profile,omitempty"`
+	Workers     int       `json:"workers,omitempty"`
+	Timeout     *Duration `json:"timeout,omitempty"`
+	HostTimeout *Duration `json:"host_timeout,omitempty"`
+	Delay       *Duration `json:"delay,omitempty"`
+	Retries     int       `json:"retries,omitempty"`
+	Force       bool      `json:"force,omitempty"`
+	PortTimeout string    `json:"port_timeout,omitempty"`
+	// CalibratePort, MinTimeout and MaxTimeout are as the flags.
+	CalibratePort int       `json:"calibrate_port,omitempty"`
+	MinTimeout    *Duration `json:"min_timeout,omitempty"`
+	MaxTimeout    *Duration `json:"max_timeout,omitempty"`
+	// SNMPHarvest is --snmp-harvest, with the server's community.
+	SNMPHarvest bool `json:"snmp_harvest,omitempty"`
+	// Follow and CertNames are --follow and --cert-names.
+	Follow    int  `json:"follow,omitempty"`
+	CertNames bool `json:"cert_names,omitempty"`
+	// Tags label the scan, as --tag does.
+	Tags map[string]string `json:"tags,omitempty"`
+	// Confirm stands in for --yes: without it, scans that would ask for
+	// confirmation on the command line are refused.
+	Confirm bool `json:"confirm,omitempty"`
+}
+
+// options converts r into scan options, marking the fields that are set so
+// that the profile does not override them.
+func (r *scanRequest) options() (*scanOptions, map[string]bool) {
+	o := new(scanOptions)
+	o.flagSet() // fills in the flag defaults
+	set := make(map[string]bool)
+	o.host = r.Hosts
+	o.profile = r.Profile
+	if r.Ports != "" {
+		o.ports, set["ports"] = r.Ports, true
+	}
+	if r.TopPorts != 0 {
+		o.topPorts, set["top-ports"] = r.TopPorts, true
+	}
+	if r.Workers != 0 {
+		o.workers, set["workers"] = r.Workers, true
+	}
+	if r.Timeout != nil {
+		o.timeout, set["timeout"] = time.Duration(*r.Timeout), true
+	}
+	if r.HostTimeout != nil {
+		o.hostTimeout, set["host-timeout"] = time.Duration(*r.HostTimeout), true
+	}
+	if r.Delay != nil {
+		o.delay, set["delay"] = time.Duration(*r.Delay), true
+	}
+	o.retries, o.force, o.portTimeout = r.Retries, r.Force, r.PortTimeout
+	o.calibratePort, o.snmpHarvest, o.follow, o.certNames = r.CalibratePort, r.SNMPHarvest, r.Follow, r.CertNames
+	if r.MinTimeout != nil {
+		o.minTimeout, set["min-timeout"] = time.Duration(*r.MinTimeout), true
+	}
+	if r.MaxTimeout != nil {
+		o.maxTimeout, set["max-timeout"] = time.Duration(*r.MaxTimeout), true
+	}
+	o.tags = r.Tags
+	return o, set
+}
+
+// confirmError is returned by submit for scans that need confirmation.
+type confirmError struct {
+	warnings []string
+}
+
+func (e *confirmError) Error() string {
+	return "scan needs confirmation: " + strings.Join(e.warnings, "; ")
+}
+
+// openPort is an open port found by a running scan.
+type openPort struct {
+	Host string `json:"host"`
+	PortResult
+}
+
+// scanJob is a scan running in the background of "pscanner serve".
+type scanJob struct {
+	id       string
+	profile  string
+	schedule string
+	plan     *scanPlan
+	cancel   context.CancelFunc
+	started  time.Time
+	probed   atomic.Int64
+
+	mu      sync.Mutex
+	state   string
+	found   []openPort
+	report  *Report
+	changed chan struct{} // closed and replaced whenever found or state changes
+}
+
+// snapshot returns the ports found after the first n, the job state and a
+// channel that is closed on the next change.
+func (j *scanJob) snapshot(n int) ([]openPort, string, <-chan struct{}) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if n > len(j.found) {
+		n = len(j.found)
+	}
+	return append([]openPort(nil), j.found[n:]...), j.state, j.changed
+}
+
+// follow calls fn for every open port of the job, including those found
+// before the call, until the job finishes or ctx is done. It returns the
+// final report, or nil if ctx ended first or fn failed.
+func (j *scanJob) follow(ctx context.Context, fn func(openPort) error) (*Report, error) {
+	n := 0
+	for {
+		found, state, changed := j.snapshot(n)
+		for _, p := range found {
+			if err := fn(p); err != nil {
+				return nil, err
+			}
+		}
+		n += len(found)
+		if state != jobRunning {
+			j.mu.Lock()
+			defer j.mu.Unlock()
+			return j.report, nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (j *scanJob) notifyLocked() {
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// summary describes the job for listings.
+func (j *scanJob) summary() scanSummary {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.report != nil {
+		return reportSummary(j.report)
+	}
+	params := j.plan.params(j.profile)
+	return scanSummary{
+		ID:        j.id,
+		Schedule:  j.schedule,
+		Tags:      j.plan.tags,
+		State:     j.state,
+		StartedAt: j.started.UTC(),
+		Targets:   params.Targets,
+		Ports:     params.Ports,
+		Probes:    j.plan.probes(),
+		Probed:    int(j.probed.Load()),
+		OpenPorts: len(j.found),
+	}
+}
+
+// scanSummary is the listing entry of a running or finished scan.
+type scanSummary struct {
+	ID         string            `json:"id"`
+	Schedule   string            `json:"schedule,omitempty"`
+	Tags       map[string]string `json:"tags,omitempty"`
+	State      string            `json:"state"`
+	StartedAt  time.Time         `json:"started_at"`
+	FinishedAt *time.Time        `json:"finished_at,omitempty"`
+	Targets    []string          `json:"targets"`
+	Ports      string            `json:"ports"`
+	Probes     int               `json:"probes"`
+	Probed     int               `json:"probed"`
+	OpenPorts  int               `json:"open_ports"`
+}
+
+func reportSummary(r *Report) scanSummary {
+	s := scanSummary{
+		ID:         r.ID,
+		Schedule:   r.Schedule,
+		Tags:       r.Tags,
+		State:      jobCompleted,
+		StartedAt:  r.StartedAt,
+		FinishedAt: &r.FinishedAt,
+		Targets:    r.Parameters.Targets,
+		Ports:      r.Parameters.Ports,
+		Probes:     r.Parameters.TargetCount * r.Parameters.PortCount,
+	}
+	s.Probed = s.Probes
+	if r.Canceled {
+		s.State = jobCanceled
+	}
+	for _, h := range r.Hosts {
+		s.OpenPorts += len(h.Ports)
+	}
+	return s
+}
+
+// jobManager runs scans submitted to the server and keeps their results.
+type jobManager struct {
+	cfg *Config
+	// store, if not nil, receives every finished report.
+	store *resultStore
+	// resolve looks up hostnames for the public address check.
+	resolve func(string) ([]string, error)
+	// scope, if not nil, refuses targets outside the scope file.
+	scope *scanScope
+	// audit, if not nil, records every scan as "pscanner scan" does.
+	audit *auditLog
+
+	mu   sync.Mutex
+	jobs map[string]*scanJob
+	done []string // finished job IDs, oldest first
+}
+
+func newJobManager(cfg *Config, store *resultStore, resolve func(string) ([]string, error)) *jobManager {
+	return &jobManager{cfg: cfg, store: store, resolve: resolve, jobs: make(map[string]*scanJob)}
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// submit validates req and starts the scan in the background. Invalid
+// requests return a plain error; scans that need confirmation return a
+// *confirmError.
+func (m *jobManager) submit(req *scanRequest) (*scanJob, error) {
+	return m.submitScheduled(req, "")
+}
+
+// submitScheduled is submit for scans started by the named schedule.
+func (m *jobManager) submitScheduled(req *scanRequest, schedule string) (*scanJob, error) {
+	plan, profile, err := m.prepare(req)
+	if err != nil {
+		if aerr := m.audit.job(newJobID()).record(auditEntry{Event: auditRefused, Reason: err.Error(), Schedule: schedule}); aerr != nil {
+			slog.Warn("audit log not written", "schedule", schedule, "err", aerr)
+		}
+		return nil, err
+	}
+	return m.start(plan, profile, schedule)
+}
+
+// prepare validates req and resolves it into a plan.
+func (m *jobManager) prepare(req *scanRequest) (*scanPlan, string, error) {
+	o, set := req.options()
+	if o.timeout < 0 || o.hostTimeout < 0 || o.delay < 0 {
+		return nil, "", errors.New("durations must not be negative")
+	}
+	plan, err := o.plan(m.cfg, set)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := m.scope.check(plan.targets, false, m.resolve); err != nil {
+		return nil, "", err
+	}
+	plan.scope = m.scope
+	// There is nobody to ask, so scans that would prompt on the command
+	// line are refused unless the client confirmed them up front.
+	warnings := scanWarnings(plan.targets, plan.probes(), confirmLimit(m.cfg.ConfirmProbes), m.cfg.AllowPublicHosts, m.resolve)
+	if len(warnings) > 0 && !req.Confirm {
+		return nil, "", &confirmError{warnings}
+	}
+	return plan, o.profile, nil
+}
+
+// start runs plan in the background and returns its job. A scan that
+// cannot be recorded in the audit log is not started.
+func (m *jobManager) start(plan *scanPlan, profile, schedule string) (*scanJob, error) {
+	id := newJobID()
+	audit := m.audit.job(id)
+	params := plan.params(profile)
+	if err := audit.record(auditEntry{Event: auditStart, Params: &params, Schedule: schedule, Tags: plan.tags}); err != nil {
+		return nil, fmt.Errorf("%w: %v", errNotAudited, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &scanJob{
+		id:       id,
+		profile:  profile,
+		schedule: schedule,
+		plan:     plan,
+		cancel:   cancel,
+		started:  time.Now(),
+		state:    jobRunning,
+		changed:  make(chan struct{}),
+	}
+	m.mu.Lock()
+	m.jobs[j.id] = j
+	m.mu.Unlock()
+
+	go func() {
+		hosts := plan.run(ctx, scanHooks{
+			found: func(host string, r PortResult) {
+				j.mu.Lock()
+				j.found = append(j.found, openPort{host, r})
+				j.notifyLocked()
+				j.mu.Unlock()
+			},
+			probed: func() { j.probed.Add(1) },
+		})
+		report := newReport(plan, profile, j.started, hosts, ctx.Err() != nil)
+		report.ID = j.id
+		report.Schedule = schedule
+		cancel()
+		open := countOpen(report.Hosts)
+		if err := audit.record(auditEntry{Event: auditFinish, OpenPorts: &open, Canceled: report.Canceled}); err != nil {
+			slog.Warn("audit log not written", "scan", j.id, "err", err)
+		}
+		if m.store != nil {
+			if err := m.store.save(report); err != nil {
+				slog.Error("saving scan failed", "scan", j.id, "err", err)
+			}
+		}
+		j.mu.Lock()
+		j.report = report
+		j.state = jobCompleted
+		if report.Canceled {
+			j.state = jobCanceled
+		}
+		j.notifyLocked()
+		j.mu.Unlock()
+		m.finished(j.id)
+	}()
+	return j, nil
+}
+
+// finished records that a job ended and drops the oldest finished jobs
+// beyond maxFinishedJobs.
+func (m *jobManager) finished(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.done = append(m.done, id)
+	for len(m.done) > maxFinishedJobs {
+		delete(m.jobs, m.done[0])
+		m.done = m.done[1:]
+	}
+}
+
+func (m *jobManager) get(id string) (*scanJob, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	return j, ok
+}
+
+var (
+	// errNotAudited is returned for scans that could not be recorded in
+	// the audit log, and were therefore not started.
+	errNotAudited = errors.New("scan not started")
+	// errNoScan is returned for scan IDs the server does not know.
+	errNoScan = errors.New("no such scan")
+	// errScanRunning is returned when a finished report is asked for too early.
+	errScanRunning = errors.New("scan is still running")
+)
+
+// report returns the final report of a finished scan, from memory or from
+// the result store.
+func (m *jobManager) report(id string) (*Report, error) {
+	if j, ok := m.get(id); ok {
+		j.mu.Lock()
+		r := j.report
+		j.mu.Unlock()
+		if r == nil {
+			return nil, errScanRunning
+		}
+		return r, nil
+	}
+	if m.store == nil {
+		return nil, errNoScan
+	}
+	return m.store.load(id)
+}
+
+// list returns the known scans, newest first: those in memory plus the
+// history held by the result store.
+func (m *jobManager) list() ([]scanSummary, error) {
+	m.mu.Lock()
+	jobs := make([]*scanJob, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		jobs = append(jobs, j)
+	}
+	m.mu.Unlock()
+
+	seen := make(map[string]bool)
+	list := []scanSummary{}
+	for _, j := range jobs {
+		list = append(list, j.summary())
+		seen[j.id] = true
+	}
+	if m.store != nil {
+		reports, err := m.store.list()
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range reports {
+			if !seen[r.ID] {
+				list = append(list, reportSummary(r))
+			}
+		}
+	}
+	sort.Slice(list, func(a, b int) bool { return list[a].StartedAt.After(list[b].StartedAt) })
+	return list, nil
+}
+
+// timedOutHosts lists the hosts of r abandoned because of --host-timeout,
+// in target order.
+func timedOutHosts(r *Report) []string {
+	var hosts []string
+	for _, h := range r.Hosts {
+		if h.TimedOut {
+			hosts = append(hosts, h.Host)
+		}
+	}
+	return hosts
+}
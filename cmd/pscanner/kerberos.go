@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// Kerberos messages and the errors that tell about a realm.
+const (
+	krbASReq    = 0x6a // [APPLICATION 10]
+	krbASRep    = 0x6b // [APPLICATION 11]
+	krbError    = 0x7e // [APPLICATION 30]
+	krbGenTime  = 0x18
+	krbString   = 0x1b // GeneralString
+	krbNTPrinc  = 1
+	krbNTSrvIns = 2
+
+	krbServerUnknown = 7
+	krbWrongRealm    = 68
+)
+
+// krbErrorNames name the errors a KDC gives a request for an unknown
+// client, or in an unknown realm.
+var krbErrorNames = map[int]string{
+	6:  "KDC_ERR_C_PRINCIPAL_UNKNOWN",
+	7:  "KDC_ERR_S_PRINCIPAL_UNKNOWN",
+	14: "KDC_ERR_ETYPE_NOSUPP",
+	18: "KDC_ERR_CLIENT_REVOKED",
+	24: "KDC_ERR_PREAUTH_FAILED",
+	25: "KDC_ERR_PREAUTH_REQUIRED",
+	60: "KRB_ERR_GENERIC",
+	68: "KDC_ERR_WRONG_REALM",
+}
+
+// krbPlaceholderRealm is asked for when no realm can be guessed, to see
+// whether a KDC answers at all.
+const krbPlaceholderRealm = "PSCANNER.INVALID"
+
+// krbField is an explicitly tagged field [n] of a Kerberos sequence.
+func krbField(n byte, contents ...[]byte) []byte {
+	return berTLV(0xa0|n, contents...)
+}
+
+// krbPrincipal encodes a PrincipalName of typ.
+func krbPrincipal(typ uint32, names ...string) []byte {
+	var list []byte
+	for _, n := range names {
+		list = append(list, berTLV(krbString, []byte(n))...)
+	}
+	return berTLV(0x30, krbField(0, berInt(0x02, typ)), krbField(1, berTLV(0x30, list)))
+}
+
+// krbASRequest is an AS-REQ for a ticket-granting ticket of client in
+// realm, without pre-authentication. A KDC of realm answers that the
+// client is unknown; one of another realm that the realm is.
+func krbASRequest(realm, client string) []byte {
+	body := berTLV(0x30,
+		krbField(0, berTLV(0x03, []byte{0, 0x50, 0x80, 0, 0})), // forwardable, proxiable, renewable
+		krbField(1, krbPrincipal(krbNTPrinc, client)),
+		krbField(2, berTLV(krbString, []byte(realm))),
+		krbField(3, krbPrincipal(krbNTSrvIns, "krbtgt", realm)),
+		krbField(5, berTLV(krbGenTime, []byte("20370913024805Z"))),
+		krbField(7, berInt(0x02, randUint32()&0x7fffffff)),
+		// AES256, AES128 and RC4.
+		krbField(8, berTLV(0x30, berInt(0x02, 18), berInt(0x02, 17), berInt(0x02, 23))))
+	return berTLV(krbASReq, berTLV(0x30,
+		krbField(1, berInt(0x02, 5)),
+		krbField(2, berInt(0x02, 10)),
+		krbField(4, body)))
+}
+
+// krbErrorReply is what a KRB-ERROR tells, or an AS-REP with code -1.
+type krbErrorReply struct {
+	code  int
+	stime time.Time
+}
+
+// parseKRBError reads a KRB-ERROR.
+func parseKRBError(b []byte) (*krbErrorReply, bool) {
+	tag, seq, _, ok := readBER(b)
+	if !ok || tag != krbError {
+		return nil, false
+	}
+	if tag, seq, _, ok = readBER(seq); !ok || tag != 0x30 {
+		return nil, false
+	}
+	e := &krbErrorReply{code: -1}
+	for len(seq) > 0 {
+		var field []byte
+		if tag, field, seq, ok = readBER(seq); !ok {
+			return nil, false
+		}
+		_, v, _, ok := readBER(field)
+		if !ok {
+			return nil, false
+		}
+		switch tag {
+		case 0xa4:
+			e.stime, _ = time.Parse("20060102150405Z", string(v))
+		case 0xa6:
+			if e.code, ok = berInteger(v); !ok {
+				return nil, false
+			}
+		}
+	}
+	return e, e.code >= 0
+}
+
+// kerberosRealms are the realms to ask a KDC on h about: the one an ldap
+// probe of h found, and those its name suggests.
+func kerberosRealms(h *HostResult) []string {
+	var realms []string
+	add := func(realm string) {
+		realm = strings.ToUpper(strings.TrimSuffix(realm, "."))
+		if strings.Contains(realm, ".") && !strings.Contains(realm, " ") {
+			for _, r := range realms {
+				if r == realm {
+					return
+				}
+			}
+			realms = append(realms, realm)
+		}
+	}
+	for _, s := range h.TCP {
+		add(s.realm)
+	}
+	if _, err := netip.ParseAddr(h.Host); err != nil {
+		// dc01.corp.example.com, or the domain itself, which resolves
+		// to its domain controllers.
+		if _, domain, ok := strings.Cut(h.Host, "."); ok {
+			add(domain)
+		}
+		add(h.Host)
+	}
+	return realms
+}
+
+// kerberosProbe asks a KDC for a ticket of a made-up client in each realm
+// the host suggests, until the error it gives confirms one: an unknown
+// client rather than an unknown realm.
+func (p *scanPlan) kerberosProbe(conn net.Conn, h *HostResult, port int) (*TCPService, error) {
+	conn.SetDeadline(time.Now().Add(tcpProbeTimeout))
+	r := bufio.NewReader(conn)
+	ask := func(realm string) (*krbErrorReply, bool) {
+		id := make([]byte, 4)
+		binary.BigEndian.PutUint32(id, randUint32())
+		req := krbASRequest(realm, "pscanner-"+hex.EncodeToString(id))
+		// Over TCP, each message is preceded by its length.
+		if _, err := conn.Write(append(binary.BigEndian.AppendUint32(nil, uint32(len(req))), req...)); err != nil {
+			return nil, false
+		}
+		head := make([]byte, 4)
+		if _, err := io.ReadFull(r, head); err != nil {
+			return nil, false
+		}
+		n := binary.BigEndian.Uint32(head)
+		if n > 64<<10 {
+			return nil, false
+		}
+		resp := make([]byte, n)
+		if _, err := io.ReadFull(r, resp); err != nil {
+			return nil, false
+		}
+		if len(resp) > 0 && resp[0] == krbASRep {
+			// A ticket for a made-up client: it cannot be, but the
+			// realm is right.
+			return &krbErrorReply{code: -1}, true
+		}
+		return parseKRBError(resp)
+	}
+
+	realms := kerberosRealms(h)
+	if len(realms) == 0 {
+		e, ok := ask(krbPlaceholderRealm)
+		if !ok {
+			return nil, nil
+		}
+		return &TCPService{Details: krbDetails("KDC; realm unknown", e)}, nil
+	}
+	var s *TCPService
+	for _, realm := range realms {
+		e, ok := ask(realm)
+		if !ok {
+			break
+		}
+		switch e.code {
+		case krbServerUnknown, krbWrongRealm:
+			if s == nil {
+				s = &TCPService{Details: krbDetails(fmt.Sprintf("KDC; realm %q not served (%s)", realm, krbErrorName(e.code)), e)}
+			}
+			continue
+		}
+		return &TCPService{Details: krbDetails(fmt.Sprintf("KDC for realm %q (%s)", realm, krbErrorName(e.code)), e)}, nil
+	}
+	return s, nil
+}
+
+// krbDetails adds the server time of a KDC's error to what it showed.
+func krbDetails(s string, e *krbErrorReply) string {
+	if !e.stime.IsZero() {
+		s += ", server time " + e.stime.Format(time.DateTime) + " UTC"
+	}
+	return s
+}
+
+// krbErrorName names a Kerberos error code.
+func krbErrorName(code int) string {
+	if name, ok := krbErrorNames[code]; ok {
+		return name
+	}
+	if code < 0 {
+		return "AS-REP"
+	}
+	return fmt.Sprintf("error %d", code)
+}
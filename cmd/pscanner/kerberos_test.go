@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// krbErrorMessage is a KRB-ERROR of code at stime.
+func krbErrorMessage(code uint32, realm string, stime time.Time) []byte {
+	return berTLV(krbError, berTLV(0x30,
+		krbField(0, berInt(0x02, 5)),
+		krbField(1, berInt(0x02, 30)),
+		krbField(4, berTLV(krbGenTime, []byte(stime.Format("20060102150405Z")))),
+		krbField(5, berInt(0x02, 0)),
+		krbField(6, berInt(0x02, code)),
+		krbField(9, berTLV(krbString, []byte(realm))),
+		krbField(10, krbPrincipal(krbNTSrvIns, "krbtgt", realm))))
+}
+
+// fakeKDC serves realm, answering AS-REQs in it that the client is
+// unknown and in others that the realm is wrong.
+func fakeKDC(realm string, stime time.Time) func(net.Conn) {
+	return func(conn net.Conn) {
+		for {
+			head := make([]byte, 4)
+			if _, err := io.ReadFull(conn, head); err != nil {
+				return
+			}
+			req := make([]byte, binary.BigEndian.Uint32(head))
+			if _, err := io.ReadFull(conn, req); err != nil {
+				return
+			}
+			// [APPLICATION 10] SEQUENCE { pvno, msg-type, req-body [4]
+			// SEQUENCE { kdc-options, cname, realm [2] ... } }
+			_, kdcReq, _, _ := readBER(req)
+			_, kdcReq, _, _ = readBER(kdcReq)
+			_, _, rest, _ := readBER(kdcReq)
+			_, _, rest, _ = readBER(rest)
+			_, body, _, _ := readBER(rest)
+			_, body, _, _ = readBER(body)
+			_, _, rest, _ = readBER(body)
+			_, _, rest, _ = readBER(rest)
+			_, field, _, _ := readBER(rest)
+			_, asked, _, _ := readBER(field)
+			code := uint32(krbWrongRealm)
+			if string(asked) == realm {
+				code = 6
+			}
+			resp := krbErrorMessage(code, string(asked), stime)
+			conn.Write(append(binary.BigEndian.AppendUint32(nil, uint32(len(resp))), resp...))
+		}
+	}
+}
+
+func TestKerberosProbe(t *testing.T) {
+	stime := time.Date(2026, 10, 15, 9, 30, 0, 0, time.UTC)
+	kdc := serveTCP(t, fakeKDC("CORP.EXAMPLE.COM", stime))
+	p := &scanPlan{timeout: time.Second}
+	for _, tt := range []struct {
+		name string
+		h    *HostResult
+		want string
+	}{
+		{"realm from ldap", &HostResult{Host: "127.0.0.1", TCP: []TCPService{{Service: "ldap", realm: "CORP.EXAMPLE.COM"}}},
+			`KDC for realm "CORP.EXAMPLE.COM" (KDC_ERR_C_PRINCIPAL_UNKNOWN), server time 2026-10-15 09:30:00 UTC`},
+		{"realm from the host name", &HostResult{Host: "localhost.corp.example.com"},
+			`KDC for realm "CORP.EXAMPLE.COM" (KDC_ERR_C_PRINCIPAL_UNKNOWN), server time 2026-10-15 09:30:00 UTC`},
+		{"wrong realm", &HostResult{Host: "localhost.lab.example.net"},
+			`KDC; realm "LAB.EXAMPLE.NET" not served (KDC_ERR_WRONG_REALM), server time 2026-10-15 09:30:00 UTC`},
+		{"no realm", &HostResult{Host: "127.0.0.1"},
+			`KDC; realm unknown, server time 2026-10-15 09:30:00 UTC`},
+	} {
+		conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(kdc)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := p.kerberosProbe(conn, tt.h, kdc)
+		conn.Close()
+		if err != nil || got == nil || got.Details != tt.want {
+			t.Errorf("kerberos probe with %s = %+v, %v; want %q", tt.name, got, err, tt.want)
+		}
+	}
+
+	// A web server is no KDC.
+	web := serveTCP(t, func(conn net.Conn) {
+		conn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+	})
+	if got, err := p.tcpProbe(context.Background(), nil, &HostResult{Host: "127.0.0.1"}, web, tcpProbes["kerberos"]); got != nil || err != nil {
+		t.Errorf("kerberos probe of a web server = %+v, %v", got, err)
+	}
+}
+
+func TestParseKRBError(t *testing.T) {
+	stime := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	e, ok := parseKRBError(krbErrorMessage(25, "CORP.EXAMPLE.COM", stime))
+	if !ok || !reflect.DeepEqual(e, &krbErrorReply{code: 25, stime: stime}) {
+		t.Errorf("parseKRBError = %+v, %v", e, ok)
+	}
+	if _, ok := parseKRBError(krbASRequest("CORP.EXAMPLE.COM", "someone")); ok {
+		t.Error("an AS-REQ parsed as a KRB-ERROR")
+	}
+}
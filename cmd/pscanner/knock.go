@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// knock is one step of a --knock sequence.
+type knock struct {
+	port int
+	udp  bool
+}
+
+func (k knock) String() string {
+	if k.udp {
+		return fmt.Sprintf("udp/%d", k.port)
+	}
+	return fmt.Sprintf("tcp/%d", k.port)
+}
+
+// parseKnock reads a --knock sequence: ports in order, each TCP unless
+// followed by ":udp", as in "7000,8000,9000:udp".
+func parseKnock(s string) ([]knock, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var seq []knock
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		port, proto, _ := strings.Cut(item, ":")
+		var k knock
+		switch proto {
+		case "", "tcp":
+		case "udp":
+			k.udp = true
+		default:
+			return nil, fmt.Errorf("knock %q: protocol must be tcp or udp", item)
+		}
+		n, err := strconv.Atoi(port)
+		if err != nil || n < 1 || n > 65535 {
+			return nil, fmt.Errorf("knock %q: invalid port", item)
+		}
+		k.port = n
+		seq = append(seq, k)
+	}
+	return seq, nil
+}
+
+// formatKnock writes a sequence back the way --knock takes it.
+func formatKnock(seq []knock) string {
+	items := make([]string, len(seq))
+	for i, k := range seq {
+		items[i] = strconv.Itoa(k.port)
+		if k.udp {
+			items[i] += ":udp"
+		}
+	}
+	return strings.Join(items, ",")
+}
+
+// hasUDPKnock reports whether seq knocks on a UDP port.
+func hasUDPKnock(seq []knock) bool {
+	for _, k := range seq {
+		if k.udp {
+			return true
+		}
+	}
+	return false
+}
+
+// minKnockWait is how long a TCP knock waits for its connection attempt at
+// the least, so that its SYN leaves even with no --knock-delay.
+const minKnockWait = 50 * time.Millisecond
+
+// knocker knocks on each host of a scan once, before its first probe.
+type knocker struct {
+	plan *scanPlan
+	dns  *dnsCache
+	mu   sync.Mutex
+	// done holds, for each host and family, a channel closed when its
+	// knocks are over.
+	done map[string]chan struct{}
+}
+
+func newKnocker(p *scanPlan, dns *dnsCache) *knocker {
+	if len(p.knock) == 0 {
+		return nil
+	}
+	return &knocker{plan: p, dns: dns, done: make(map[string]chan struct{})}
+}
+
+// before knocks on the host of j unless another worker has, and waits for
+// the knocks to be over. A nil knocker does nothing.
+func (k *knocker) before(ctx context.Context, j job) {
+	if k == nil {
+		return
+	}
+	k.mu.Lock()
+	done, started := k.done[j.key()]
+	if !started {
+		done = make(chan struct{})
+		k.done[j.key()] = done
+	}
+	k.mu.Unlock()
+	if started {
+		select {
+		case <-done:
+		case <-ctx.Done():
+		}
+		return
+	}
+	defer close(done)
+	for _, step := range k.plan.knock {
+		if err := k.plan.knockOnce(ctx, k.dns, j, step); err != nil && ctx.Err() == nil {
+			slog.Warn("knock failed", "host", j.host, "knock", step.String(), "err", err)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// knockOnce makes one knock on the host of j and waits out --knock-delay:
+// a connection attempt for TCP, whatever its outcome, as the knock is the
+// SYN, or an empty datagram for UDP.
+func (p *scanPlan) knockOnce(ctx context.Context, dns *dnsCache, j job, step knock) error {
+	start := time.Now()
+	var err error
+	if step.udp {
+		err = p.knockUDP(ctx, dns, j, step.port)
+	} else {
+		kctx, cancel := context.WithTimeout(ctx, max(p.knockDelay, minKnockWait))
+		conn, derr := p.probe(kctx, dns, job{host: j.host, port: step.port, family: j.family})
+		cancel()
+		if derr == nil {
+			conn.Close()
+		}
+	}
+	select {
+	case <-time.After(p.knockDelay - time.Since(start)):
+	case <-ctx.Done():
+	}
+	return err
+}
+
+// knockUDP sends an empty datagram to port of the host of j.
+func (p *scanPlan) knockUDP(ctx context.Context, dns *dnsCache, j job, port int) error {
+	addr, _, err := udpAddr(ctx, dns, j.host, j.family)
+	if err != nil {
+		return err
+	}
+	conn, err := p.dialUDP(addr, port)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write(nil)
+	return err
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseKnock(t *testing.T) {
+	seq, err := parseKnock("7000, 8000:tcp,9000:udp")
+	want := []knock{{port: 7000}, {port: 8000}, {port: 9000, udp: true}}
+	if err != nil || !reflect.DeepEqual(seq, want) {
+		t.Errorf("parseKnock = %v, %v; want %v", seq, err, want)
+	}
+	if s := formatKnock(seq); s != "7000,8000,9000:udp" {
+		t.Errorf("formatKnock = %q", s)
+	}
+	for _, bad := range []string{"7000:sctp", "0", "70000", "7000,,8000", "knock"} {
+		if _, err := parseKnock(bad); err == nil {
+			t.Errorf("parseKnock(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestKnockBeforeProbes(t *testing.T) {
+	var mu sync.Mutex
+	var events []string
+	record := func(e string) {
+		mu.Lock()
+		events = append(events, e)
+		mu.Unlock()
+	}
+	listen := func(event string) int {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { l.Close() })
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				record(event)
+				conn.Close()
+			}
+		}()
+		return l.Addr().(*net.TCPAddr).Port
+	}
+	first := listen("tcp knock")
+	udp, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { udp.Close() })
+	go func() {
+		buf := make([]byte, 16)
+		for {
+			if _, _, err := udp.ReadFromUDP(buf); err != nil {
+				return
+			}
+			record("udp knock")
+		}
+	}()
+	a, b := listen("probe"), listen("probe")
+
+	targets, _ := parseTargets("127.0.0.1")
+	plan := &scanPlan{targets: targets, numTargets: 1, ports: []int{a, b}, workers: 2, timeout: time.Second,
+		knock: []knock{{port: first}, {port: udp.LocalAddr().(*net.UDPAddr).Port, udp: true}}, knockDelay: 20 * time.Millisecond}
+	hosts := plan.run(context.Background(), scanHooks{})
+	if len(hosts) != 1 || len(hosts[0].Ports) != 2 {
+		t.Fatalf("hosts = %+v", hosts)
+	}
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"tcp knock", "udp knock", "probe", "probe"}; !reflect.DeepEqual(events, want) {
+		t.Errorf("events = %q, want %q", events, want)
+	}
+}
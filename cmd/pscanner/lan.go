@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// The discovery protocols of "discover --local".
+
+const (
+	mdnsAddr = "224.0.0.251:5353"
+	ssdpAddr = "239.255.255.250:1900"
+	// mdnsMaxTypes bounds the service types asked for, as every device
+	// on the network may announce a few of its own.
+	mdnsMaxTypes = 64
+	// netbiosMaxHosts is the largest network asked address by address.
+	netbiosMaxHosts = 1024
+	// upnpFetchTimeout bounds the fetch of a UPnP device description.
+	upnpFetchTimeout = 2 * time.Second
+)
+
+// mdnsServiceList is the DNS-SD meta-query for all announced service types.
+const mdnsServiceList = "_services._dns-sd._udp"
+
+// mdnsTypes are asked for from the start, as not every responder answers
+// the meta-query.
+var mdnsTypes = []string{
+	mdnsServiceList,
+	"_workstation._tcp", "_device-info._tcp", "_http._tcp", "_ipp._tcp", "_printer._tcp",
+	"_airplay._tcp", "_raop._tcp", "_googlecast._tcp", "_smb._tcp", "_ssh._tcp", "_hap._tcp",
+}
+
+// mdnsQuery asks for the instances of the service types. Asking from a port
+// other than 5353 makes it a "legacy" query, answered by unicast.
+func mdnsQuery(types []string) ([]byte, error) {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{})
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	for _, t := range types {
+		name, err := dnsmessage.NewName(t + ".local.")
+		if err != nil {
+			return nil, err
+		}
+		q := dnsmessage.Question{Name: name, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET}
+		if err := b.Question(q); err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
+
+// mdnsAnswer is what one mDNS response says about its sender.
+type mdnsAnswer struct {
+	names    []string // host and service instance names
+	services []string // service types of the instances, such as "ipp"
+	types    []string // service types from the meta-query, to ask for next
+}
+
+// parseMDNS reads an mDNS response sent by from. Address records only
+// count for from itself.
+func parseMDNS(from netip.Addr, msg []byte) (a mdnsAnswer, ok bool) {
+	var p dnsmessage.Parser
+	h, err := p.Start(msg)
+	if err != nil || !h.Response {
+		return a, false
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return a, false
+	}
+	answers, err := p.AllAnswers()
+	if err != nil {
+		return a, false
+	}
+	_ = p.SkipAllAuthorities()
+	// Responders add the records of their instances here; a truncated
+	// section still leaves the answers.
+	extra, _ := p.AllAdditionals()
+	for _, r := range append(answers, extra...) {
+		name := r.Header.Name.String()
+		switch body := r.Body.(type) {
+		case *dnsmessage.PTRResource:
+			if name == mdnsServiceList+".local." {
+				if t, ok := strings.CutSuffix(body.PTR.String(), ".local."); ok {
+					a.types = append(a.types, t)
+				}
+				continue
+			}
+			a.addInstance(body.PTR.String())
+		case *dnsmessage.SRVResource:
+			a.addInstance(name)
+		case *dnsmessage.AResource:
+			if netip.AddrFrom4(body.A) == from {
+				a.names = append(a.names, strings.TrimSuffix(name, "."))
+			}
+		}
+	}
+	return a, true
+}
+
+// addInstance records a service instance name such as
+// "Office Printer._ipp._tcp.local.".
+func (a *mdnsAnswer) addInstance(name string) {
+	rest, ok := strings.CutSuffix(name, ".local.")
+	if !ok {
+		return
+	}
+	proto := strings.LastIndex(rest, "._")
+	if proto < 0 {
+		return
+	}
+	typ := strings.LastIndex(rest[:proto], "._")
+	if typ < 0 {
+		return
+	}
+	a.names = append(a.names, rest[:typ])
+	a.services = append(a.services, rest[typ+2:proto])
+}
+
+// askMDNS asks for the common service types and then for those that the
+// devices say they announce.
+func askMDNS(ctx context.Context, li lanInterface, f *lanFinds) error {
+	if li.ifi.Flags&net.FlagMulticast == 0 {
+		return nil
+	}
+	conn, err := listenLAN(ctx, li)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	dst, _ := net.ResolveUDPAddr("udp4", mdnsAddr)
+	asked := make(map[string]bool)
+	ask := func(types []string) error {
+		var fresh []string
+		for _, t := range types {
+			if !asked[t] && len(asked) < mdnsMaxTypes {
+				asked[t] = true
+				fresh = append(fresh, t)
+			}
+		}
+		if len(fresh) == 0 {
+			return nil
+		}
+		q, err := mdnsQuery(fresh)
+		if err != nil {
+			return err
+		}
+		_, err = conn.WriteToUDP(q, dst)
+		return err
+	}
+	if err := ask(mdnsTypes); err != nil {
+		return err
+	}
+	readAnswers(conn, func(from netip.Addr, msg []byte) {
+		a, ok := parseMDNS(from, msg)
+		if !ok {
+			return
+		}
+		if len(a.names) > 0 || len(a.services) > 0 {
+			f.add(from, "mdns", a.names, a.services)
+		}
+		_ = ask(a.types)
+	})
+	return nil
+}
+
+// ssdpSearch asks every UPnP device and service to answer.
+const ssdpSearch = "M-SEARCH * HTTP/1.1\r\n" +
+	"HOST: " + ssdpAddr + "\r\n" +
+	"MAN: \"ssdp:discover\"\r\n" +
+	"MX: 2\r\n" +
+	"ST: ssdp:all\r\n\r\n"
+
+// ssdpAnswer is an SSDP search response.
+type ssdpAnswer struct {
+	location   string // URL of the device description
+	deviceType string // such as "MediaRenderer", for device answers
+}
+
+func parseSSDP(msg []byte) (a ssdpAnswer, ok bool) {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(msg)), nil)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return a, false
+	}
+	a.location = resp.Header.Get("Location")
+	// urn:schemas-upnp-org:device:MediaRenderer:1
+	if _, t, ok := strings.Cut(resp.Header.Get("ST"), ":device:"); ok {
+		a.deviceType, _, _ = strings.Cut(t, ":")
+	}
+	return a, true
+}
+
+// upnpDescription is the part of a UPnP device description that pscanner
+// reads.
+type upnpDescription struct {
+	Device struct {
+		FriendlyName string `xml:"friendlyName"`
+		Manufacturer string `xml:"manufacturer"`
+		ModelName    string `xml:"modelName"`
+	} `xml:"device"`
+}
+
+// fetchUPnPName returns the friendly name in the device description at
+// location, with the model when there is one.
+func fetchUPnPName(location string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), upnpFetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return ""
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+	var d upnpDescription
+	if resp.StatusCode != http.StatusOK || xml.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&d) != nil {
+		return ""
+	}
+	name := strings.TrimSpace(d.Device.FriendlyName)
+	model := strings.TrimSpace(strings.TrimSpace(d.Device.Manufacturer) + " " + strings.TrimSpace(d.Device.ModelName))
+	if name == "" || model == "" || strings.Contains(name, model) {
+		return name
+	}
+	return name + " (" + model + ")"
+}
+
+// askSSDP searches for UPnP devices and fetches the descriptions of those
+// that answer. A description is only fetched from the device itself.
+func askSSDP(ctx context.Context, li lanInterface, f *lanFinds) error {
+	if li.ifi.Flags&net.FlagMulticast == 0 {
+		return nil
+	}
+	conn, err := listenLAN(ctx, li)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	dst, _ := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if _, err := conn.WriteToUDP([]byte(ssdpSearch), dst); err != nil {
+		return err
+	}
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	fetched := make(map[string]bool)
+	readAnswers(conn, func(from netip.Addr, msg []byte) {
+		a, ok := parseSSDP(msg)
+		if !ok {
+			return
+		}
+		f.add(from, "ssdp", nil, []string{a.deviceType})
+		u, err := url.Parse(a.location)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || fetched[a.location] {
+			return
+		}
+		if ip, err := netip.ParseAddr(u.Hostname()); err != nil || ip != from {
+			return
+		}
+		fetched[a.location] = true
+		wg.Go(func() {
+			if name := fetchUPnPName(a.location); name != "" {
+				f.add(from, "ssdp", []string{name}, nil)
+			}
+		})
+	})
+	return nil
+}
+
+// netbiosWildcard is the encoded NetBIOS name "*", which asks a node
+// status query for all names of the node.
+const netbiosWildcard = "CKAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA."
+
+// netbiosTypeNBSTAT is the node status query type; it shares its number
+// with DNS SRV.
+const netbiosTypeNBSTAT = dnsmessage.Type(0x21)
+
+func netbiosQuery() ([]byte, error) {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 0x7073})
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	q := dnsmessage.Question{Name: dnsmessage.MustNewName(netbiosWildcard), Type: netbiosTypeNBSTAT, Class: dnsmessage.ClassINET}
+	if err := b.Question(q); err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
+
+// parseNetBIOS reads a node status response and returns the computer name,
+// as "WORKGROUP\NAME" when the node names its workgroup.
+func parseNetBIOS(msg []byte) (string, bool) {
+	var p dnsmessage.Parser
+	h, err := p.Start(msg)
+	if err != nil || !h.Response {
+		return "", false
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return "", false
+	}
+	rh, err := p.AnswerHeader()
+	if err != nil || rh.Type != netbiosTypeNBSTAT {
+		return "", false
+	}
+	body, err := p.UnknownResource()
+	if err != nil || len(body.Data) < 1 {
+		return "", false
+	}
+	data := body.Data
+	n := int(data[0])
+	data = data[1:]
+	var computer, group string
+	for i := 0; i < n && len(data) >= 18; i, data = i+1, data[18:] {
+		// 15 characters padded with spaces, a suffix byte and two flag
+		// bytes whose top bit marks group names.
+		name := strings.TrimRight(string(data[:15]), " \x00")
+		suffix, isGroup := data[15], data[16]&0x80 != 0
+		if suffix != 0x00 || name == "" {
+			continue
+		}
+		switch {
+		case isGroup && group == "":
+			group = name
+		case !isGroup && computer == "":
+			computer = name
+		}
+	}
+	if computer == "" {
+		return "", false
+	}
+	if group != "" {
+		return group + `\` + computer, true
+	}
+	return computer, true
+}
+
+// askNetBIOS sends a node status query to every address of the networks
+// of li that are small enough.
+func askNetBIOS(ctx context.Context, li lanInterface, f *lanFinds) error {
+	q, err := netbiosQuery()
+	if err != nil {
+		return err
+	}
+	conn, err := listenLAN(ctx, li)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	for _, n := range li.nets {
+		if 1<<(32-n.Bits()) > netbiosMaxHosts {
+			continue
+		}
+		network := n.Masked()
+		last := lastAddr(network)
+		for a := network.Addr().Next(); a.IsValid() && a.Less(last); a = a.Next() {
+			if a == n.Addr() {
+				continue
+			}
+			// An address that cannot be sent to has nobody to answer.
+			_, _ = conn.WriteToUDPAddrPort(q, netip.AddrPortFrom(a, 137))
+		}
+	}
+	readAnswers(conn, func(from netip.Addr, msg []byte) {
+		if name, ok := parseNetBIOS(msg); ok {
+			f.add(from, "netbios", []string{name}, nil)
+		}
+	})
+	return nil
+}
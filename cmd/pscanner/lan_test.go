@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestParseMDNS(t *testing.T) {
+	from := netip.MustParseAddr("192.168.1.20")
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{Response: true, Authoritative: true})
+	b.StartAnswers()
+	hdr := func(name string, typ dnsmessage.Type) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(name), Type: typ, Class: dnsmessage.ClassINET, TTL: 120}
+	}
+	b.PTRResource(hdr("_services._dns-sd._udp.local.", dnsmessage.TypePTR), dnsmessage.PTRResource{PTR: dnsmessage.MustNewName("_ipp._tcp.local.")})
+	b.PTRResource(hdr("_ipp._tcp.local.", dnsmessage.TypePTR), dnsmessage.PTRResource{PTR: dnsmessage.MustNewName("Office Printer._ipp._tcp.local.")})
+	b.StartAdditionals()
+	b.AResource(hdr("brother-hl.local.", dnsmessage.TypeA), dnsmessage.AResource{A: from.As4()})
+	b.AResource(hdr("other.local.", dnsmessage.TypeA), dnsmessage.AResource{A: [4]byte{192, 168, 1, 99}})
+	msg, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, ok := parseMDNS(from, msg)
+	want := mdnsAnswer{names: []string{"Office Printer", "brother-hl.local"}, services: []string{"ipp"}, types: []string{"_ipp._tcp"}}
+	if !ok || !reflect.DeepEqual(a, want) {
+		t.Errorf("parseMDNS = %+v, %v; want %+v", a, ok, want)
+	}
+
+	if q, err := mdnsQuery(mdnsTypes); err != nil {
+		t.Fatal(err)
+	} else if _, ok := parseMDNS(from, q); ok {
+		t.Error("parseMDNS accepted a query")
+	}
+}
+
+func TestParseSSDP(t *testing.T) {
+	msg := "HTTP/1.1 200 OK\r\nCACHE-CONTROL: max-age=1800\r\nLOCATION: http://192.168.1.30:49152/desc.xml\r\n" +
+		"ST: urn:schemas-upnp-org:device:MediaRenderer:1\r\nUSN: uuid:1234::urn:schemas-upnp-org:device:MediaRenderer:1\r\n\r\n"
+	a, ok := parseSSDP([]byte(msg))
+	if !ok || a.location != "http://192.168.1.30:49152/desc.xml" || a.deviceType != "MediaRenderer" {
+		t.Errorf("parseSSDP = %+v, %v", a, ok)
+	}
+	if _, ok := parseSSDP([]byte(ssdpSearch)); ok {
+		t.Error("parseSSDP accepted a search")
+	}
+}
+
+func TestFetchUPnPName(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0"><device>
+  <deviceType>urn:schemas-upnp-org:device:MediaRenderer:1</deviceType>
+  <friendlyName>Living Room TV</friendlyName>
+  <manufacturer>Samsung</manufacturer><modelName>QE55</modelName>
+</device></root>`))
+	}))
+	defer srv.Close()
+	if got := fetchUPnPName(srv.URL); got != "Living Room TV (Samsung QE55)" {
+		t.Errorf("fetchUPnPName = %q", got)
+	}
+}
+
+func TestParseNetBIOS(t *testing.T) {
+	name := func(s string, suffix byte, group bool) []byte {
+		b := []byte(s + strings.Repeat(" ", 15-len(s)))
+		flags := byte(0x04)
+		if group {
+			flags |= 0x80
+		}
+		return append(b, suffix, flags, 0)
+	}
+	data := []byte{4}
+	data = append(data, name("DESKTOP-7", 0x20, false)...)
+	data = append(data, name("WORKGROUP", 0x00, true)...)
+	data = append(data, name("DESKTOP-7", 0x00, false)...)
+	data = append(data, name("WORKGROUP", 0x1e, true)...)
+	data = append(data, make([]byte, 6)...) // MAC address
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 0x7073, Response: true, Authoritative: true})
+	b.StartAnswers()
+	b.UnknownResource(dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(netbiosWildcard), Type: netbiosTypeNBSTAT, Class: dnsmessage.ClassINET},
+		dnsmessage.UnknownResource{Type: netbiosTypeNBSTAT, Data: data})
+	msg, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := parseNetBIOS(msg); !ok || got != `WORKGROUP\DESKTOP-7` {
+		t.Errorf("parseNetBIOS = %q, %v", got, ok)
+	}
+	if q, _ := netbiosQuery(); q == nil {
+		t.Error("no NetBIOS query")
+	} else if _, ok := parseNetBIOS(q); ok {
+		t.Error("parseNetBIOS accepted a query")
+	}
+}
+
+func TestLANFinds(t *testing.T) {
+	var f lanFinds
+	f.add(netip.MustParseAddr("192.168.1.20"), "ssdp", nil, []string{"MediaRenderer"})
+	f.add(netip.MustParseAddr("192.168.1.3"), "netbios", []string{"NAS"}, nil)
+	f.add(netip.MustParseAddr("192.168.1.20"), "mdns", []string{"tv.local"}, []string{"googlecast", ""})
+	want := []lanDevice{
+		{Address: "192.168.1.3", Names: []string{"NAS"}, Sources: []string{"netbios"}},
+		{Address: "192.168.1.20", Names: []string{"tv.local"}, Services: []string{"MediaRenderer", "googlecast"}, Sources: []string{"mdns", "ssdp"}},
+	}
+	if got := f.list(); !reflect.DeepEqual(got, want) {
+		t.Errorf("list = %+v, want %+v", got, want)
+	}
+}
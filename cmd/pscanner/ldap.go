@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// berTLV encodes a BER element of tag with the concatenated contents, as
+// LDAP and Kerberos messages are made of.
+func berTLV(tag byte, contents ...[]byte) []byte {
+	var content []byte
+	for _, c := range contents {
+		content = append(content, c...)
+	}
+	b := []byte{tag}
+	switch n := len(content); {
+	case n < 0x80:
+		b = append(b, byte(n))
+	case n < 0x100:
+		b = append(b, 0x81, byte(n))
+	default:
+		b = append(b, 0x82, byte(n>>8), byte(n))
+	}
+	return append(b, content...)
+}
+
+// berInt encodes a non-negative integer with tag (0x02 for INTEGER, 0x0a
+// for ENUMERATED).
+func berInt(tag byte, v uint32) []byte {
+	b := []byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}
+	for len(b) > 1 && b[0] == 0 && b[1] < 0x80 {
+		b = b[1:]
+	}
+	if b[0] >= 0x80 {
+		b = append([]byte{0}, b...)
+	}
+	return berTLV(tag, b)
+}
+
+// readBER splits the first BER element off b. Servers such as Active
+// Directory write lengths in more bytes than needed, which DER parsers
+// refuse, so any definite length is taken.
+func readBER(b []byte) (tag byte, content, rest []byte, ok bool) {
+	if len(b) < 2 || b[0]&0x1f == 0x1f {
+		return 0, nil, nil, false
+	}
+	tag, n, b := b[0], int(b[1]), b[2:]
+	if n >= 0x80 {
+		size := n & 0x7f
+		if size == 0 || size > 4 || len(b) < size {
+			return 0, nil, nil, false
+		}
+		n = 0
+		for _, c := range b[:size] {
+			n = n<<8 | int(c)
+		}
+		b = b[size:]
+	}
+	if n < 0 || len(b) < n {
+		return 0, nil, nil, false
+	}
+	return tag, b[:n], b[n:], true
+}
+
+// berInteger reads the content of a BER INTEGER or ENUMERATED.
+func berInteger(content []byte) (int, bool) {
+	if len(content) == 0 || len(content) > 4 {
+		return 0, false
+	}
+	v := int(int8(content[0]))
+	for _, c := range content[1:] {
+		v = v<<8 | int(c)
+	}
+	return v, true
+}
+
+// readBERMessage reads one whole BER element from r, of at most 1 MiB.
+func readBERMessage(r *bufio.Reader) ([]byte, error) {
+	head, err := r.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	size := 2
+	if head[1] >= 0x80 {
+		size += int(head[1] & 0x7f)
+	}
+	head, err = r.Peek(size)
+	if err != nil {
+		return nil, err
+	}
+	n := int(head[1])
+	if n >= 0x80 {
+		if n&0x7f == 0 || n&0x7f > 4 {
+			return nil, fmt.Errorf("BER length of %d bytes", n&0x7f)
+		}
+		n = 0
+		for _, c := range head[2:] {
+			n = n<<8 | int(c)
+		}
+	}
+	if n > 1<<20 {
+		return nil, fmt.Errorf("BER element of %d bytes", n)
+	}
+	b := make([]byte, size+n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// ldapTLSPorts are the LDAP ports that speak TLS from the start: LDAPS
+// and the global catalog over TLS.
+var ldapTLSPorts = map[int]bool{636: true, 3269: true}
+
+// ldapRootDSEAttributes are the attributes of the root DSE that tell what
+// a directory serves, and whether it is an Active Directory domain
+// controller.
+var ldapRootDSEAttributes = []string{
+	"namingContexts", "defaultNamingContext", "dnsHostName", "ldapServiceName",
+	"domainControllerFunctionality", "isGlobalCatalogReady", "vendorName", "vendorVersion",
+}
+
+// LDAP protocol operations, and the result code of success.
+const (
+	ldapBindRequest   = 0x60
+	ldapBindResponse  = 0x61
+	ldapSearchRequest = 0x63
+	ldapSearchEntry   = 0x64
+	ldapSearchDone    = 0x65
+	ldapSuccess       = 0
+)
+
+// ldapMaxSearchItems bounds the messages read for the root DSE, as a
+// server might send references on and on.
+const ldapMaxSearchItems = 16
+
+// ldapMessage encodes an LDAP message of id carrying op.
+func ldapMessage(id uint32, op []byte) []byte {
+	return berTLV(0x30, berInt(0x02, id), op)
+}
+
+// ldapBind is an anonymous simple bind of LDAPv3.
+func ldapBind() []byte {
+	return ldapMessage(1, berTLV(ldapBindRequest, berInt(0x02, 3), berTLV(0x04), berTLV(0x80)))
+}
+
+// ldapRootDSESearch reads attrs of the root DSE: a base search of the
+// empty DN for any object.
+func ldapRootDSESearch(attrs []string) []byte {
+	var list []byte
+	for _, a := range attrs {
+		list = append(list, berTLV(0x04, []byte(a))...)
+	}
+	return ldapMessage(2, berTLV(ldapSearchRequest,
+		berTLV(0x04),                        // baseObject ""
+		berInt(0x0a, 0),                     // scope baseObject
+		berInt(0x0a, 0),                     // neverDerefAliases
+		berInt(0x02, 0),                     // no size limit
+		berInt(0x02, 0),                     // no time limit
+		berTLV(0x01, []byte{0}),             // typesOnly false
+		berTLV(0x87, []byte("objectClass")), // present
+		berTLV(0x30, list)))
+}
+
+// parseLDAPMessage reads the protocol operation of an LDAP message: its
+// tag and content.
+func parseLDAPMessage(b []byte) (op byte, content []byte, ok bool) {
+	tag, msg, _, ok := readBER(b)
+	if !ok || tag != 0x30 {
+		return 0, nil, false
+	}
+	if tag, _, msg, ok = readBER(msg); !ok || tag != 0x02 {
+		return 0, nil, false
+	}
+	op, content, _, ok = readBER(msg)
+	return op, content, ok
+}
+
+// parseLDAPResult reads the result code and diagnostic message of an
+// LDAPResult, the content of responses such as BindResponse.
+func parseLDAPResult(b []byte) (code int, message string, ok bool) {
+	tag, c, b, ok := readBER(b)
+	if !ok || tag != 0x0a {
+		return 0, "", false
+	}
+	if code, ok = berInteger(c); !ok {
+		return 0, "", false
+	}
+	if _, _, b, ok = readBER(b); !ok { // matchedDN
+		return code, "", true
+	}
+	if _, c, _, ok = readBER(b); ok {
+		message = string(c)
+	}
+	return code, message, true
+}
+
+// parseLDAPEntry reads the attributes of a SearchResultEntry.
+func parseLDAPEntry(b []byte) map[string][]string {
+	_, _, b, ok := readBER(b) // objectName
+	if !ok {
+		return nil
+	}
+	tag, list, _, ok := readBER(b)
+	if !ok || tag != 0x30 {
+		return nil
+	}
+	attrs := make(map[string][]string)
+	for len(list) > 0 {
+		var attr []byte
+		if tag, attr, list, ok = readBER(list); !ok || tag != 0x30 {
+			break
+		}
+		_, name, attr, ok := readBER(attr)
+		if !ok {
+			break
+		}
+		_, set, _, ok := readBER(attr)
+		if !ok {
+			break
+		}
+		for len(set) > 0 {
+			var v []byte
+			if _, v, set, ok = readBER(set); !ok {
+				break
+			}
+			attrs[strings.ToLower(string(name))] = append(attrs[strings.ToLower(string(name))], string(v))
+		}
+	}
+	return attrs
+}
+
+// ldapProbe binds to an LDAP server anonymously and reads its root DSE,
+// which directories give anyone: the naming contexts it serves and, for
+// Active Directory, that it is a domain controller and of which domain.
+func (p *scanPlan) ldapProbe(conn net.Conn, h *HostResult, port int) (*TCPService, error) {
+	conn.SetDeadline(time.Now().Add(tcpProbeTimeout))
+	s := &TCPService{}
+	var tlsInfo string
+	if ldapTLSPorts[port] {
+		tc, err := clientTLS(conn, h.Host)
+		if err != nil {
+			return nil, nil
+		}
+		tlsInfo, s.Issues = describeTLS(tc.ConnectionState(), time.Now())
+		conn = tc
+	}
+	c := &ldapConn{conn: conn, r: bufio.NewReader(conn)}
+	op, content, err := c.ask(ldapBind())
+	if err != nil || op != ldapBindResponse {
+		return nil, nil
+	}
+	var found []string
+	if code, msg, ok := parseLDAPResult(content); !ok {
+		return nil, nil
+	} else if code != ldapSuccess {
+		found = append(found, ldapRefusal("anonymous bind", code, msg))
+	} else {
+		found = c.rootDSE(s)
+	}
+	s.Details = strings.Join(found, ", ")
+	if tlsInfo != "" {
+		s.Details += "; " + tlsInfo
+	}
+	return s, nil
+}
+
+// ldapConn is a connection to an LDAP server.
+type ldapConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// ask sends req and reads the operation of the answer.
+func (c *ldapConn) ask(req []byte) (op byte, content []byte, err error) {
+	if _, err := c.conn.Write(req); err != nil {
+		return 0, nil, err
+	}
+	return c.read()
+}
+
+// read reads the operation of the next message.
+func (c *ldapConn) read() (op byte, content []byte, err error) {
+	b, err := readBERMessage(c.r)
+	if err != nil {
+		return 0, nil, err
+	}
+	op, content, ok := parseLDAPMessage(b)
+	if !ok {
+		return 0, nil, errors.New("not LDAP")
+	}
+	return op, content, nil
+}
+
+// rootDSE reads the root DSE, describes what it shows, and notes in s the
+// Kerberos realm of an Active Directory domain.
+func (c *ldapConn) rootDSE(s *TCPService) []string {
+	op, content, err := c.ask(ldapRootDSESearch(ldapRootDSEAttributes))
+	var attrs map[string][]string
+	for i := 0; err == nil && op != ldapSearchDone && i < ldapMaxSearchItems; i++ {
+		if op == ldapSearchEntry && attrs == nil {
+			attrs = parseLDAPEntry(content)
+		}
+		op, content, err = c.read()
+	}
+	if attrs == nil {
+		if code, msg, ok := parseLDAPResult(content); err == nil && ok && code != ldapSuccess {
+			return []string{ldapRefusal("root DSE", code, msg)}
+		}
+		return []string{"no root DSE"}
+	}
+	one := func(name string) string {
+		if v := attrs[strings.ToLower(name)]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+
+	var found []string
+	if _, ok := attrs["domaincontrollerfunctionality"]; ok {
+		dc := "Active Directory domain controller"
+		if strings.EqualFold(one("isGlobalCatalogReady"), "TRUE") {
+			dc += " and global catalog"
+		}
+		found = append(found, dc)
+		s.realm = adRealm(one("ldapServiceName"), one("defaultNamingContext"))
+	} else if vendor := strings.TrimSpace(one("vendorName") + " " + one("vendorVersion")); vendor != "" {
+		found = append(found, vendor)
+	}
+	if host := one("dnsHostName"); host != "" {
+		found = append(found, fmt.Sprintf("host %q", host))
+	}
+	if s.realm != "" {
+		found = append(found, fmt.Sprintf("realm %q", s.realm))
+	}
+	if contexts := attrs["namingcontexts"]; len(contexts) > 0 {
+		quoted := make([]string, len(contexts))
+		for i, c := range contexts {
+			quoted[i] = fmt.Sprintf("%q", c)
+		}
+		found = append(found, "naming contexts "+strings.Join(quoted, ", "))
+	}
+	return found
+}
+
+// ldapRefusal describes an LDAP result other than success.
+func ldapRefusal(what string, code int, msg string) string {
+	s := fmt.Sprintf("%s refused (result %d)", what, code)
+	if msg != "" {
+		s += fmt.Sprintf(": %q", msg)
+	}
+	return s
+}
+
+// adRealm is the Kerberos realm of an Active Directory domain: that of
+// its LDAP service principal, as in "corp.example.com:dc01$@CORP.EXAMPLE.COM",
+// or else its default naming context in upper case.
+func adRealm(serviceName, namingContext string) string {
+	if _, realm, ok := strings.Cut(serviceName, "@"); ok && realm != "" {
+		return realm
+	}
+	var labels []string
+	for _, rdn := range strings.Split(namingContext, ",") {
+		if dc, ok := strings.CutPrefix(strings.ToUpper(strings.TrimSpace(rdn)), "DC="); ok {
+			labels = append(labels, dc)
+		}
+	}
+	return strings.Join(labels, ".")
+}
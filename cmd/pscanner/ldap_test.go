@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// berLong encodes a BER element with a four-byte length, as Active
+// Directory does.
+func berLong(tag byte, contents ...[]byte) []byte {
+	var content []byte
+	for _, c := range contents {
+		content = append(content, c...)
+	}
+	b := binary.BigEndian.AppendUint32([]byte{tag, 0x84}, uint32(len(content)))
+	return append(b, content...)
+}
+
+func ldapResult(op byte, code uint32, msg string) []byte {
+	return berTLV(op, berInt(0x0a, code), berTLV(0x04), berTLV(0x04, []byte(msg)))
+}
+
+func ldapAttribute(name string, values ...string) []byte {
+	var set []byte
+	for _, v := range values {
+		set = append(set, berTLV(0x04, []byte(v))...)
+	}
+	return berLong(0x30, berTLV(0x04, []byte(name)), berLong(0x31, set))
+}
+
+// fakeLDAP answers a bind with bindCode and a root DSE search with attrs.
+func fakeLDAP(bindCode uint32, attrs ...[]byte) func(net.Conn) {
+	return func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		for {
+			b, err := readBERMessage(r)
+			if err != nil {
+				return
+			}
+			_, msg, _, _ := readBER(b)
+			_, id, _, _ := readBER(msg)
+			op, _, _ := parseLDAPMessage(b)
+			reply := func(op []byte) { conn.Write(berLong(0x30, berTLV(0x02, id), op)) }
+			switch op {
+			case ldapBindRequest:
+				reply(ldapResult(ldapBindResponse, bindCode, ""))
+			case ldapSearchRequest:
+				var list []byte
+				for _, a := range attrs {
+					list = append(list, a...)
+				}
+				reply(berLong(ldapSearchEntry, berTLV(0x04), berLong(0x30, list)))
+				reply(ldapResult(ldapSearchDone, ldapSuccess, ""))
+			}
+		}
+	}
+}
+
+func TestLDAPProbe(t *testing.T) {
+	p := &scanPlan{timeout: time.Second}
+	h := &HostResult{Host: "127.0.0.1"}
+	for _, tt := range []struct {
+		name   string
+		server func(net.Conn)
+		want   *TCPService
+	}{
+		{"domain controller", fakeLDAP(ldapSuccess,
+			ldapAttribute("namingContexts", "DC=corp,DC=example,DC=com", "CN=Configuration,DC=corp,DC=example,DC=com"),
+			ldapAttribute("defaultNamingContext", "DC=corp,DC=example,DC=com"),
+			ldapAttribute("dnsHostName", "dc01.corp.example.com"),
+			ldapAttribute("ldapServiceName", "corp.example.com:dc01$@CORP.EXAMPLE.COM"),
+			ldapAttribute("domainControllerFunctionality", "7"),
+			ldapAttribute("isGlobalCatalogReady", "TRUE"),
+		), &TCPService{
+			Details: `Active Directory domain controller and global catalog, host "dc01.corp.example.com", realm "CORP.EXAMPLE.COM", ` +
+				`naming contexts "DC=corp,DC=example,DC=com", "CN=Configuration,DC=corp,DC=example,DC=com"`,
+			realm: "CORP.EXAMPLE.COM",
+		}},
+		{"389 Directory Server", fakeLDAP(ldapSuccess,
+			ldapAttribute("vendorName", "389 Project"),
+			ldapAttribute("vendorVersion", "389-Directory/2.4.5"),
+			ldapAttribute("namingContexts", "dc=example,dc=org"),
+		), &TCPService{Details: `389 Project 389-Directory/2.4.5, naming contexts "dc=example,dc=org"`}},
+		{"bind refused", fakeLDAP(48), &TCPService{Details: "anonymous bind refused (result 48)"}},
+	} {
+		got, err := p.tcpProbe(context.Background(), nil, h, serveTCP(t, tt.server), tcpProbes["ldap"])
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ldap probe of %s = %+v, %v; want %+v", tt.name, got, err, tt.want)
+		}
+	}
+
+	// An HTTP server is not LDAP.
+	web := serveTCP(t, func(conn net.Conn) {
+		conn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+	})
+	if got, err := p.tcpProbe(context.Background(), nil, h, web, tcpProbes["ldap"]); got != nil || err != nil {
+		t.Errorf("ldap probe of a web server = %+v, %v", got, err)
+	}
+}
+
+func TestADRealm(t *testing.T) {
+	for _, tt := range []struct{ service, context, want string }{
+		{"corp.example.com:dc01$@CORP.EXAMPLE.COM", "DC=corp,DC=example,DC=com", "CORP.EXAMPLE.COM"},
+		{"", "DC=ad, DC=example,DC=net", "AD.EXAMPLE.NET"},
+	} {
+		if got := adRealm(tt.service, tt.context); got != tt.want {
+			t.Errorf("adRealm(%q, %q) = %q, want %q", tt.service, tt.context, got, tt.want)
+		}
+	}
+}
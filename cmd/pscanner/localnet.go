@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// localNet is a network segment this machine is attached to, found for
+// --local-net.
+type localNet struct {
+	iface   string
+	prefix  netip.Prefix
+	gateway netip.Addr // the default gateway on iface, if any
+}
+
+func (n localNet) String() string {
+	s := n.iface + " " + n.prefix.String()
+	if n.gateway.IsValid() {
+		s += " (gateway " + n.gateway.String() + ")"
+	}
+	return s
+}
+
+// findLocalNets returns the IPv4 segments of the interfaces that are up,
+// or of the named one.
+func findLocalNets(iface string) ([]localNet, error) {
+	ifaces, err := lanInterfaces(iface)
+	if err != nil {
+		return nil, err
+	}
+	nets := localSegments(ifaces, defaultGateways())
+	if len(nets) == 0 {
+		return nil, errors.New("--local-net: no attached network to scan")
+	}
+	return nets, nil
+}
+
+// localSegments turns interface addresses into the segments to scan.
+// Point-to-point links (/31 and /32) have nobody else on them, and a
+// segment shared by two interfaces is scanned once. A gateway outside
+// every segment of its interface becomes a segment of its own, so that
+// it is still scanned.
+func localSegments(ifaces []lanInterface, gateways map[string]netip.Addr) []localNet {
+	var nets []localNet
+	seen := make(map[netip.Prefix]bool)
+	for _, li := range ifaces {
+		gw := gateways[li.ifi.Name]
+		covered := false
+		for _, p := range li.nets {
+			if p.Bits() > 30 || seen[p.Masked()] {
+				continue
+			}
+			seen[p.Masked()] = true
+			n := localNet{iface: li.ifi.Name, prefix: p.Masked()}
+			if gw.IsValid() && p.Contains(gw) {
+				n.gateway = gw
+				covered = true
+			}
+			nets = append(nets, n)
+		}
+		if gw.IsValid() && !covered {
+			p := netip.PrefixFrom(gw, gw.BitLen())
+			if !seen[p] {
+				seen[p] = true
+				nets = append(nets, localNet{iface: li.ifi.Name, prefix: p, gateway: gw})
+			}
+		}
+	}
+	return nets
+}
+
+// localNetTargets is the --host value that scans nets.
+func localNetTargets(nets []localNet) string {
+	var hosts []string
+	for _, n := range nets {
+		if n.prefix.IsSingleIP() {
+			hosts = append(hosts, n.prefix.Addr().String())
+		} else {
+			hosts = append(hosts, n.prefix.String())
+		}
+	}
+	return strings.Join(hosts, ",")
+}
+
+// parseProcRoute reads the default gateways of the interfaces from the
+// Linux routing table in /proc/net/route, where addresses are hex in host
+// byte order.
+func parseProcRoute(r io.Reader) map[string]netip.Addr {
+	gateways := make(map[string]netip.Addr)
+	sc := bufio.NewScanner(r)
+	sc.Scan() // header
+	for sc.Scan() {
+		// Iface Destination Gateway Flags RefCnt Use Metric Mask ...
+		f := strings.Fields(sc.Text())
+		if len(f) < 8 || f[1] != "00000000" || f[7] != "00000000" {
+			continue
+		}
+		gw, err := strconv.ParseUint(f[2], 16, 32)
+		if err != nil || gw == 0 {
+			continue
+		}
+		var b [4]byte
+		binary.NativeEndian.PutUint32(b[:], uint32(gw))
+		if _, ok := gateways[f[0]]; !ok {
+			gateways[f[0]] = netip.AddrFrom4(b)
+		}
+	}
+	return gateways
+}
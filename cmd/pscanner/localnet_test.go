@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseProcRoute(t *testing.T) {
+	hex := func(a string) string {
+		b := netip.MustParseAddr(a).As4()
+		return fmt.Sprintf("%08X", binary.NativeEndian.Uint32(b[:]))
+	}
+	table := "Iface\tDestination\tGateway \tFlags\tRefCnt\tUse\tMetric\tMask\t\tMTU\tWindow\tIRTT\n" +
+		"eth0\t00000000\t" + hex("192.168.1.1") + "\t0003\t0\t0\t100\t00000000\t0\t0\t0\n" +
+		"eth0\t" + hex("192.168.1.0") + "\t00000000\t0001\t0\t0\t100\t" + hex("255.255.255.0") + "\t0\t0\t0\n" +
+		"wlan0\t00000000\t" + hex("10.0.0.1") + "\t0003\t0\t0\t600\t00000000\t0\t0\t0\n"
+	got := parseProcRoute(strings.NewReader(table))
+	want := map[string]netip.Addr{"eth0": netip.MustParseAddr("192.168.1.1"), "wlan0": netip.MustParseAddr("10.0.0.1")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseProcRoute = %v, want %v", got, want)
+	}
+}
+
+func TestLocalSegments(t *testing.T) {
+	pfx := netip.MustParsePrefix
+	ifaces := []lanInterface{
+		{ifi: &net.Interface{Name: "eth0"}, nets: []netip.Prefix{pfx("192.168.1.23/24")}},
+		{ifi: &net.Interface{Name: "tun0"}, nets: []netip.Prefix{pfx("10.8.0.6/32")}},
+		{ifi: &net.Interface{Name: "wlan0"}, nets: []netip.Prefix{pfx("172.20.5.9/28"), pfx("192.168.1.40/24")}},
+	}
+	gateways := map[string]netip.Addr{"eth0": netip.MustParseAddr("192.168.1.1"), "wlan0": netip.MustParseAddr("172.20.0.1")}
+	nets := localSegments(ifaces, gateways)
+	var got []string
+	for _, n := range nets {
+		got = append(got, n.String())
+	}
+	want := []string{
+		"eth0 192.168.1.0/24 (gateway 192.168.1.1)",
+		"wlan0 172.20.5.0/28",
+		"wlan0 172.20.0.1/32 (gateway 172.20.0.1)",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("localSegments = %q, want %q", got, want)
+	}
+	if h := localNetTargets(nets); h != "192.168.1.0/24,172.20.5.0/28,172.20.0.1" {
+		t.Errorf("localNetTargets = %q", h)
+	}
+}
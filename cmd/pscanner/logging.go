@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+)
+
+// logFlags are the options of the commands that log as they run, often
+// unattended: scan, import, pipe, agent and serve.
+type logFlags struct {
+	logFormat string
+	logFile   string
+}
+
+func (o *logFlags) bindLog(fs *flag.FlagSet) {
+	fs.StringVar(&o.logFormat, "log-format", "text", "Log format: text or json")
+	fs.StringVar(&o.logFile, "log-file", "", "Append the log to this `file` instead of stderr")
+}
+
+// logNotes documents the log flags, for the commands that have them.
+var logNotes = map[string]string{
+	"log-format": `The log reports what goes wrong along the way, such as failed probes,
+deliveries and database writes, and what servers and agents are doing.
+text writes key=value lines and json one JSON object per line, for log
+pipelines. Results and errors that stop the command are not logged; they go
+to the output and to stderr as before.`,
+	"log-file": `The file is created if need be, readable by its owner only, and appended
+to, so that it can be rotated by renaming it between runs.`,
+}
+
+func init() {
+	for _, doc := range []*commandDoc{scanDoc, importDoc, pipeDoc, agentDoc, serveDoc} {
+		maps.Copy(doc.notes, logNotes)
+	}
+}
+
+// newLogHandler returns a slog handler writing format, text or json, to w.
+func newLogHandler(w io.Writer, format string) (slog.Handler, error) {
+	switch format {
+	case "text":
+		return slog.NewTextHandler(w, nil), nil
+	case "json":
+		return slog.NewJSONHandler(w, nil), nil
+	}
+	return nil, fmt.Errorf("unknown --log-format %q (want text, json)", format)
+}
+
+// startLog makes the default logger write as the flags say. The log file,
+// if any, stays open until the process exits.
+func (o *logFlags) startLog() error {
+	var w io.Writer = os.Stderr
+	if o.logFile != "" {
+		f, err := os.OpenFile(o.logFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			return err
+		}
+		w = f
+	}
+	h, err := newLogHandler(w, o.logFormat)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStartLog(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	path := filepath.Join(t.TempDir(), "pscanner.log")
+	o := logFlags{logFormat: "json", logFile: path}
+	if err := o.startLog(); err != nil {
+		t.Fatal(err)
+	}
+	slog.Warn("tcp probe failed", "host", "192.0.2.1", "port", 22)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var line struct {
+		Level string `json:"level"`
+		Msg   string `json:"msg"`
+		Host  string `json:"host"`
+		Port  int    `json:"port"`
+	}
+	if err := json.Unmarshal(data, &line); err != nil {
+		t.Fatalf("log %q: %v", data, err)
+	}
+	if line.Level != "WARN" || line.Msg != "tcp probe failed" || line.Host != "192.0.2.1" || line.Port != 22 {
+		t.Errorf("logged %+v", line)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Errorf("log file mode %v, %v", fi.Mode(), err)
+	}
+
+	if err := (&logFlags{logFormat: "xml"}).startLog(); err == nil {
+		t.Error("--log-format xml accepted")
+	}
+}
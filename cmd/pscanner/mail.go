@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/textproto"
+	"slices"
+	"strings"
+	"time"
+)
+
+// mailTLSPorts are the mail ports that speak TLS from the start rather
+// than after STARTTLS: SMTP submission, IMAP and POP3 over TLS.
+var mailTLSPorts = map[int]bool{465: true, 993: true, 995: true}
+
+// mailSession is a conversation with a mail server, which switches to TLS
+// on its implicit TLS ports or after STARTTLS.
+type mailSession struct {
+	conn net.Conn
+	text *textproto.Conn
+	host string
+	// tls describes the TLS session once there is one.
+	tls string
+	s   *TCPService
+}
+
+// newMailSession starts a conversation on conn, connected to port of host,
+// with a TLS handshake first on the implicit TLS ports. It returns nil if
+// that fails, as then the port speaks something else.
+func newMailSession(conn net.Conn, host string, port int) *mailSession {
+	conn.SetDeadline(time.Now().Add(tcpProbeTimeout))
+	m := &mailSession{conn: conn, text: textproto.NewConn(conn), host: host, s: &TCPService{}}
+	if mailTLSPorts[port] && m.handshake() != nil {
+		return nil
+	}
+	return m
+}
+
+// handshake starts TLS on the connection and notes its version and the
+// server's certificate, and any weakness of them among the issues.
+func (m *mailSession) handshake() error {
+	tc, err := clientTLS(m.conn, m.host)
+	if err != nil {
+		return err
+	}
+	m.text = textproto.NewConn(tc)
+	var issues []string
+	m.tls, issues = describeTLS(tc.ConnectionState(), time.Now())
+	m.s.Issues = append(m.s.Issues, issues...)
+	return nil
+}
+
+// clientTLS makes a TLS handshake with host on conn, to see what the
+// server shows rather than to trust it.
+func clientTLS(conn net.Conn, host string) (*tls.Conn, error) {
+	config := &tls.Config{
+		// Certificates of mail and directory servers are often
+		// self-signed, and only what they show matters; TLS 1.0 and 1.1
+		// are allowed to find servers that offer nothing newer.
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS10,
+	}
+	if _, err := netip.ParseAddr(host); err != nil {
+		config.ServerName = host
+	}
+	tc := tls.Client(conn, config)
+	if err := tc.Handshake(); err != nil {
+		return nil, err
+	}
+	return tc, nil
+}
+
+// describeTLS describes the version and the server's certificate of a TLS
+// session, and lists their weaknesses at now.
+func describeTLS(state tls.ConnectionState, now time.Time) (string, []string) {
+	s := tls.VersionName(state.Version)
+	var issues []string
+	if state.Version < tls.VersionTLS12 {
+		issues = append(issues, s+" at most")
+	}
+	if len(state.PeerCertificates) == 0 {
+		return s, issues
+	}
+	cert := state.PeerCertificates[0]
+	s += fmt.Sprintf(", certificate %q", cert.Subject.String())
+	if bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil {
+		issues = append(issues, "self-signed certificate")
+	} else {
+		s += fmt.Sprintf(", issuer %q", cert.Issuer.String())
+	}
+	s += ", expires " + cert.NotAfter.Format(time.DateOnly)
+	if now.After(cert.NotAfter) {
+		issues = append(issues, "certificate expired")
+	}
+	return s, issues
+}
+
+// startTLS sends the command that starts TLS, if the server offered it as
+// verb, and reads the answer with ok before the handshake. What fails is
+// listed among the issues.
+func (m *mailSession) startTLS(offered bool, verb, command string, ok func() error) {
+	switch {
+	case m.tls != "":
+		return
+	case !offered:
+		m.s.Issues = append(m.s.Issues, "no "+verb)
+		return
+	}
+	if err := m.text.PrintfLine("%s", command); err != nil {
+		m.s.Issues = append(m.s.Issues, verb+" failed: "+err.Error())
+		return
+	}
+	if err := ok(); err != nil {
+		m.s.Issues = append(m.s.Issues, verb+" refused: "+err.Error())
+		return
+	}
+	if err := m.handshake(); err != nil {
+		m.s.Issues = append(m.s.Issues, verb+" handshake failed: "+err.Error())
+		return
+	}
+	m.tls = verb + ", " + m.tls
+}
+
+// result is what the conversation found.
+func (m *mailSession) result(greeting string) *TCPService {
+	m.s.Details = fmt.Sprintf("greeting %q", greeting)
+	if m.tls != "" {
+		m.s.Details += "; " + m.tls
+	}
+	return m.s
+}
+
+// plaintextLogin is the issue of a server that takes passwords before TLS.
+const plaintextLogin = "password login offered without TLS"
+
+// smtpProbe reads an SMTP server's greeting and EHLO extensions, then
+// starts TLS with STARTTLS and reports the certificate it shows. If the
+// server offers NTLM authentication, before or after STARTTLS, it starts
+// one for what the NTLM challenge tells of the server, as Exchange does.
+func (p *scanPlan) smtpProbe(conn net.Conn, h *HostResult, port int) (*TCPService, error) {
+	m := newMailSession(conn, h.Host, port)
+	if m == nil {
+		return nil, nil
+	}
+	// ReadResponse would read on through the lines of something else.
+	if b, err := m.text.R.Peek(4); err != nil || !smtpReplyStart(b) {
+		return nil, nil
+	}
+	code, msg, err := m.text.ReadResponse(0)
+	if err != nil && code == 0 {
+		return nil, nil
+	}
+	greeting, _, _ := strings.Cut(msg, "\n")
+	greeting = fmt.Sprintf("%d %s", code, greeting)
+	if code != 220 {
+		// Service not available, mostly: all it says.
+		return m.result(greeting), nil
+	}
+	var starttls, ntlm bool
+	for _, ext := range m.ehlo() {
+		keyword, params, _ := strings.Cut(strings.ToUpper(ext), " ")
+		switch keyword {
+		case "STARTTLS":
+			starttls = true
+		case "AUTH":
+			mechanisms := strings.Fields(params)
+			if m.tls == "" && (slices.Contains(mechanisms, "PLAIN") || slices.Contains(mechanisms, "LOGIN")) {
+				m.s.Issues = append(m.s.Issues, plaintextLogin)
+			}
+			ntlm = slices.Contains(mechanisms, "NTLM")
+		}
+	}
+	m.startTLS(starttls, "STARTTLS", "STARTTLS", func() error {
+		_, _, err := m.text.ReadResponse(220)
+		return err
+	})
+	if strings.HasPrefix(m.tls, "STARTTLS") {
+		// The extensions start over with TLS, and servers often offer
+		// authentication only then.
+		for _, ext := range m.ehlo() {
+			if keyword, params, _ := strings.Cut(strings.ToUpper(ext), " "); keyword == "AUTH" {
+				ntlm = slices.Contains(strings.Fields(params), "NTLM")
+			}
+		}
+	}
+	s := m.result(greeting)
+	if ntlm {
+		m.smtpNTLM()
+		if m.s.NTLM != nil {
+			s.Details += "; " + m.s.NTLM.String()
+		}
+	}
+	return s, nil
+}
+
+// ehlo sends EHLO and returns the extensions the server lists.
+func (m *mailSession) ehlo() []string {
+	if err := m.text.PrintfLine("EHLO pscanner.invalid"); err != nil {
+		return nil
+	}
+	_, msg, err := m.text.ReadResponse(250)
+	if err != nil {
+		return nil
+	}
+	// The first line greets the client back.
+	return strings.Split(msg, "\n")[1:]
+}
+
+// smtpNTLM starts NTLM authentication with AUTH NTLM, reads the server's
+// challenge, and gives up.
+func (m *mailSession) smtpNTLM() {
+	if err := m.text.PrintfLine("AUTH NTLM %s", base64.StdEncoding.EncodeToString(ntlmNegotiate)); err != nil {
+		return
+	}
+	_, msg, err := m.text.ReadResponse(334)
+	if err != nil {
+		return
+	}
+	challenge, err := base64.StdEncoding.DecodeString(strings.TrimSpace(msg))
+	if err != nil {
+		return
+	}
+	if info, err := parseNTLMChallenge(challenge); err == nil {
+		m.s.NTLM = info
+	}
+	// Cancel the exchange (RFC 4954).
+	if m.text.PrintfLine("*") == nil {
+		m.text.ReadResponse(0)
+	}
+}
+
+// smtpReplyStart reports whether b starts like an SMTP reply: a code of
+// three digits, then a space or, on all lines but the last, a dash.
+func smtpReplyStart(b []byte) bool {
+	return len(b) >= 4 && '1' <= b[0] && b[0] <= '5' && '0' <= b[1] && b[1] <= '9' && '0' <= b[2] && b[2] <= '9' && (b[3] == ' ' || b[3] == '-')
+}
+
+// imapProbe reads an IMAP server's greeting and capabilities, then starts
+// TLS with STARTTLS and reports the certificate it shows.
+func (p *scanPlan) imapProbe(conn net.Conn, h *HostResult, port int) (*TCPService, error) {
+	m := newMailSession(conn, h.Host, port)
+	if m == nil {
+		return nil, nil
+	}
+	greeting, err := m.text.ReadLine()
+	if err != nil || !strings.HasPrefix(greeting, "* OK") && !strings.HasPrefix(greeting, "* PREAUTH") {
+		return nil, nil
+	}
+	// answer reads the answer to the command tagged tag, and returns its
+	// untagged lines, or an error if it does not end with OK.
+	answer := func(tag string) ([]string, error) {
+		var untagged []string
+		for {
+			line, err := m.text.ReadLine()
+			if err != nil {
+				return nil, err
+			}
+			status, ok := strings.CutPrefix(line, tag+" ")
+			if !ok {
+				untagged = append(untagged, line)
+				continue
+			}
+			if !strings.HasPrefix(strings.ToUpper(status), "OK") {
+				return nil, fmt.Errorf("%q", status)
+			}
+			return untagged, nil
+		}
+	}
+	var capabilities []string
+	if m.text.PrintfLine("a1 CAPABILITY") == nil {
+		lines, _ := answer("a1")
+		for _, line := range lines {
+			if caps, ok := strings.CutPrefix(strings.ToUpper(line), "* CAPABILITY "); ok {
+				capabilities = strings.Fields(caps)
+			}
+		}
+	}
+	if m.tls == "" && capabilities != nil && !slices.Contains(capabilities, "LOGINDISABLED") {
+		m.s.Issues = append(m.s.Issues, plaintextLogin)
+	}
+	m.startTLS(slices.Contains(capabilities, "STARTTLS"), "STARTTLS", "a2 STARTTLS", func() error {
+		_, err := answer("a2")
+		return err
+	})
+	return m.result(greeting), nil
+}
+
+// pop3Probe reads a POP3 server's greeting and capabilities, then starts
+// TLS with STLS and reports the certificate it shows.
+func (p *scanPlan) pop3Probe(conn net.Conn, h *HostResult, port int) (*TCPService, error) {
+	m := newMailSession(conn, h.Host, port)
+	if m == nil {
+		return nil, nil
+	}
+	greeting, err := m.text.ReadLine()
+	if err != nil || !strings.HasPrefix(greeting, "+OK") {
+		return nil, nil
+	}
+	// ok reads a one-line answer, and fails unless it is +OK.
+	ok := func() error {
+		line, err := m.text.ReadLine()
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(line, "+OK") {
+			return fmt.Errorf("%q", line)
+		}
+		return nil
+	}
+	var capabilities []string
+	if m.text.PrintfLine("CAPA") == nil && ok() == nil {
+		lines, err := m.text.ReadDotLines()
+		if err == nil {
+			for _, line := range lines {
+				name, _, _ := strings.Cut(strings.ToUpper(line), " ")
+				capabilities = append(capabilities, name)
+			}
+		}
+	}
+	if m.tls == "" && slices.Contains(capabilities, "USER") {
+		m.s.Issues = append(m.s.Issues, plaintextLogin)
+	}
+	m.startTLS(slices.Contains(capabilities, "STLS"), "STLS", "STLS", ok)
+	return m.result(greeting), nil
+}
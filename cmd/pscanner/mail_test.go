@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mailCertificate makes a certificate for name valid until notAfter,
+// signed by parent, or self-signed if parent is nil.
+func mailCertificate(t *testing.T, name string, notAfter time.Time, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             notAfter.AddDate(-1, 0, 0),
+		NotAfter:              notAfter,
+		IsCA:                  parent == nil,
+		BasicConstraintsValid: true,
+	}
+	signer, signerKey := template, any(key)
+	if parent != nil {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// mailServer answers a mail client line by line: greeting first, then
+// what answer says to each command, switching to TLS with cert after a
+// command answer marks so.
+func mailServer(t *testing.T, greeting string, cert tls.Certificate, implicitTLS bool, answer func(cmd string) (resp string, starttls bool)) int {
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+	return serveTCP(t, func(conn net.Conn) {
+		if implicitTLS {
+			conn = tls.Server(conn, config)
+		}
+		io.WriteString(conn, greeting)
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			resp, starttls := answer(strings.TrimSpace(line))
+			io.WriteString(conn, resp)
+			if starttls {
+				conn = tls.Server(conn, config)
+				r = bufio.NewReader(conn)
+			}
+		}
+	})
+}
+
+func TestMailProbes(t *testing.T) {
+	p := &scanPlan{timeout: time.Second}
+	h := &HostResult{Host: "127.0.0.1"}
+	notAfter := time.Now().AddDate(0, 6, 0).UTC().Truncate(time.Second)
+	ca := mailCertificate(t, "Test CA", notAfter.AddDate(1, 0, 0), nil)
+	signed := mailCertificate(t, "mail.example.com", notAfter, &ca)
+	selfSigned := mailCertificate(t, "localhost", notAfter, nil)
+	expires := notAfter.Format(time.DateOnly)
+
+	smtp := mailServer(t, "220 mail.example.com ESMTP Postfix\r\n", selfSigned, false, func(cmd string) (string, bool) {
+		switch cmd {
+		case "EHLO pscanner.invalid":
+			return "250-mail.example.com\r\n250-PIPELINING\r\n250-AUTH PLAIN LOGIN\r\n250 STARTTLS\r\n", false
+		case "STARTTLS":
+			return "220 2.0.0 Ready to start TLS\r\n", true
+		}
+		return "502 5.5.2 Error\r\n", false
+	})
+	imaps := mailServer(t, "* OK [CAPABILITY IMAP4rev1] Dovecot ready.\r\n", signed, true, func(cmd string) (string, bool) {
+		if cmd == "a1 CAPABILITY" {
+			return "* CAPABILITY IMAP4rev1 SASL-IR AUTH=PLAIN\r\na1 OK Capability completed.\r\n", false
+		}
+		return "* BAD\r\n", false
+	})
+	imap := mailServer(t, "* OK IMAP ready\r\n", signed, false, func(cmd string) (string, bool) {
+		switch cmd {
+		case "a1 CAPABILITY":
+			return "* CAPABILITY IMAP4rev1 STARTTLS LOGINDISABLED\r\na1 OK done\r\n", false
+		case "a2 STARTTLS":
+			return "a2 OK Begin TLS negotiation now.\r\n", true
+		}
+		return "* BAD\r\n", false
+	})
+	pop3 := mailServer(t, "+OK POP3 ready\r\n", signed, false, func(cmd string) (string, bool) {
+		if cmd == "CAPA" {
+			return "+OK Capability list follows\r\nTOP\r\nUSER\r\nUIDL\r\n.\r\n", false
+		}
+		return "-ERR unknown command\r\n", false
+	})
+	mailTLSPorts[imaps] = true
+	defer delete(mailTLSPorts, imaps)
+
+	for _, tt := range []struct {
+		name string
+		port int
+		want *TCPService
+	}{
+		{"smtp", smtp, &TCPService{
+			Details: `greeting "220 mail.example.com ESMTP Postfix"; STARTTLS, TLS 1.3, certificate "CN=localhost", expires ` + expires,
+			Issues:  []string{plaintextLogin, "self-signed certificate"},
+		}},
+		{"imap", imaps, &TCPService{
+			Details: `greeting "* OK [CAPABILITY IMAP4rev1] Dovecot ready."; TLS 1.3, certificate "CN=mail.example.com", issuer "CN=Test CA", expires ` + expires,
+		}},
+		{"imap", imap, &TCPService{
+			Details: `greeting "* OK IMAP ready"; STARTTLS, TLS 1.3, certificate "CN=mail.example.com", issuer "CN=Test CA", expires ` + expires,
+		}},
+		{"pop3", pop3, &TCPService{
+			Details: `greeting "+OK POP3 ready"`,
+			Issues:  []string{plaintextLogin, "no STLS"},
+		}},
+	} {
+		got, err := p.tcpProbe(context.Background(), nil, h, tt.port, tcpProbes[tt.name])
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s probe = %+v, %v; want %+v", tt.name, got, err, tt.want)
+		}
+	}
+
+	// An SSH server is no mail server.
+	ssh := serveTCP(t, func(conn net.Conn) {
+		io.WriteString(conn, "SSH-2.0-OpenSSH_9.6\r\n")
+		io.Copy(io.Discard, conn)
+	})
+	for _, name := range []string{"smtp", "imap", "pop3"} {
+		if got, err := p.tcpProbe(context.Background(), nil, h, ssh, tcpProbes[name]); got != nil || err != nil {
+			t.Errorf("%s probe of an SSH server = %+v, %v", name, got, err)
+		}
+	}
+}
+
+func TestDescribeTLS(t *testing.T) {
+	notAfter := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	cert := mailCertificate(t, "old.example.com", notAfter, nil)
+	state := tls.ConnectionState{Version: tls.VersionTLS10, PeerCertificates: []*x509.Certificate{cert.Leaf}}
+	got, issues := describeTLS(state, notAfter.AddDate(0, 0, 1))
+	if want := `TLS 1.0, certificate "CN=old.example.com", expires 2024-03-01`; got != want {
+		t.Errorf("describeTLS = %q, want %q", got, want)
+	}
+	if want := []string{"TLS 1.0 at most", "self-signed certificate", "certificate expired"}; !reflect.DeepEqual(issues, want) {
+		t.Errorf("issues = %q, want %q", issues, want)
+	}
+}
+
+func TestSMTPNTLM(t *testing.T) {
+	cert := mailCertificate(t, "mail.corp.example.com", time.Now().AddDate(1, 0, 0), nil)
+	// Exchange offers NTLM only once the session is encrypted.
+	var encrypted bool
+	port := mailServer(t, "220 mail.corp.example.com Microsoft ESMTP MAIL Service ready\r\n", cert, false, func(cmd string) (string, bool) {
+		switch {
+		case cmd == "EHLO pscanner.invalid" && !encrypted:
+			return "250-mail.corp.example.com Hello\r\n250 STARTTLS\r\n", false
+		case cmd == "EHLO pscanner.invalid":
+			return "250-mail.corp.example.com Hello\r\n250 AUTH NTLM LOGIN\r\n", false
+		case cmd == "STARTTLS":
+			encrypted = true
+			return "220 2.0.0 SMTP server ready\r\n", true
+		case cmd == "AUTH NTLM "+base64.StdEncoding.EncodeToString(ntlmNegotiate):
+			return "334 " + base64.StdEncoding.EncodeToString(ntlmChallenge(corpDC, 17763)) + "\r\n", false
+		case cmd == "*":
+			return "501 5.7.3 Authentication canceled\r\n", false
+		}
+		return "502 5.5.2 Error\r\n", false
+	})
+	p := &scanPlan{timeout: time.Second}
+	got, err := p.tcpProbe(context.Background(), nil, &HostResult{Host: "127.0.0.1"}, port, tcpProbes["smtp"])
+	if err != nil || got == nil || !reflect.DeepEqual(got.NTLM, &corpDC) || !strings.HasSuffix(got.Details, "; "+corpDC.String()) {
+		t.Errorf("smtp probe = %+v, %v; want NTLM %+v", got, err, corpDC)
+	}
+}
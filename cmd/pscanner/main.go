@@ -3,173 +3,128 @@ package main
 import (
 	"flag"
 	"fmt"
-	"net"
+	"log/slog"
 	"os"
-	"sort"
-	"strconv"
 	"strings"
-	"sync"
-	"time"
 )
 
-func parsePorts(spec string) ([]int, error) {
-	// Accepts formats like: "80,443,8080,21-25"
-	set := make(map[int]struct{})
-	parts := strings.Split(spec, ",")
-	for _, p := range parts {
-		p = strings.TrimSpace(p)
-		if p == "" {
-			continue
-		}
-		if strings.Contains(p, "-") {
-			bounds := strings.SplitN(p, "-", 2)
-			if len(bounds) != 2 {
-				return nil, fmt.Errorf("invalid range: %s", p)
-			}
-			start, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
-			if err != nil {
-				return nil, fmt.Errorf("invalid port: %s", bounds[0])
-			}
-			end, err := strconv.Atoi(strings.TrimSpace(bounds[1]))
-			if err != nil {
-				return nil, fmt.Errorf("invalid port: %s", bounds[1])
-			}
-			if start < 1 || end < 1 || start > 65535 || end > 65535 || start > end {
-				return nil, fmt.Errorf("invalid range bounds: %s", p)
-			}
-			for i := start; i <= end; i++ {
-				set[i] = struct{}{}
-			}
-		} else {
-			n, err := strconv.Atoi(p)
-			if err != nil {
-				return nil, fmt.Errorf("invalid port: %s", p)
-			}
-			if n < 1 || n > 65535 {
-				return nil, fmt.Errorf("port out of range: %d", n)
-			}
-			set[n] = struct{}{}
-		}
-	}
-	ports := make([]int, 0, len(set))
-	for k := range set {
-		ports = append(ports, k)
-	}
-	sort.Ints(ports)
-	return ports, nil
+// command is a pscanner subcommand. run receives the arguments following the
+// command name and exits the process itself on error. flags, when set,
+// returns a fresh flag set so that tooling such as shell completion can
+// enumerate the command's options, and doc feeds "help <command>" and the
+// man page.
+type command struct {
+	name  string
+	short string
+	run   func(args []string)
+	flags func() *flag.FlagSet
+	doc   *commandDoc
 }
 
-func worker(host string, ports <-chan int, results chan<- int, timeout time.Duration, wg *sync.WaitGroup) {
-	defer wg.Done()
-	for p := range ports {
-		addr := fmt.Sprintf("%s:%d", host, p)
-		conn, err := net.DialTimeout("tcp", addr, timeout)
-		if err == nil {
-			_ = conn.Close()
-			results <- p // send only open ports
-		}
+// commands is filled in init because some commands (completion) walk the
+// table themselves.
+var commands []command
+
+func init() {
+	commands = []command{
+		{"scan", "Scan TCP ports on a host", runScan, func() *flag.FlagSet { return new(scanOptions).flagSet() }, scanDoc},
+		{"import", "Rescan the open ports found by nmap or masscan", runImport, func() *flag.FlagSet { return new(scanOptions).newFlagSet("import", importDoc) }, importDoc},
+		{"pipe", "Run scan jobs read from stdin, writing NDJSON results", runPipe, func() *flag.FlagSet { return new(pipeOptions).flagSet() }, pipeDoc},
+		{"agent", "Scan on behalf of a coordinator (scan --coordinate)", runAgent, func() *flag.FlagSet { return new(agentOptions).flagSet() }, agentDoc},
+		{"version", "Print version and build information", runVersion, nil, versionDoc},
+		{"completion", "Generate a shell completion script (bash, zsh, fish)", runCompletion, nil, completionDoc},
+		{"man", "Print the pscanner(1) manual page", runMan, nil, manDoc},
+		{"discover", "Find devices on the local network (mDNS, SSDP, WS-Discovery, SLP, NetBIOS, TCP sweep)", runDiscover, func() *flag.FlagSet { return new(discoverOptions).flagSet() }, discoverDoc},
+		{"resume", "Finish a scan interrupted with Ctrl-C (scan --checkpoint)", runResume, nil, resumeDoc},
+		{"diff", "List the ports that opened and closed between two scans", runDiff, func() *flag.FlagSet { return new(diffOptions).flagSet() }, diffDoc},
+		{"compare", "Compare scans made from different vantage points", runCompare, func() *flag.FlagSet { return new(compareOptions).flagSet() }, compareDoc},
+		{"serve", "Run the scan server (web dashboard, gRPC)", runServe, func() *flag.FlagSet { return new(serveOptions).flagSet() }, serveDoc},
+		{"history", "List scans stored in the database", runHistory, func() *flag.FlagSet { return new(historyOptions).flagSet() }, historyDoc},
+		{"show", "Print a scan stored in the database", runShow, func() *flag.FlagSet { return new(showOptions).flagSet() }, showDoc},
+		{"query", "Search open ports stored in the database", runQuery, func() *flag.FlagSet { return new(queryOptions).flagSet() }, queryDoc},
+		{"assets", "List when each stored host port was first and last seen", runAssets, func() *flag.FlagSet { return new(assetsOptions).flagSet() }, assetsDoc},
 	}
 }
 
-func main() {
-	var (
-		hostFlag    = flag.String("host", "", "Target host (name or IP), required")
-		portsFlag   = flag.String("ports", "1-1024", "Ports to scan (e.g. 80,443,8080,21-25 or 1-65535)")
-		workersFlag = flag.Int("workers", 100, "Number of concurrent workers (goroutines)")
-		timeoutFlag = flag.Int("timeout", 500, "Dial timeout in milliseconds")
-	)
-
-	// Custom help output
-
-	flag.Usage = func() {
-		fmt.Fprintf(flag.CommandLine.Output(), `
+func usage() {
+	fmt.Fprintf(os.Stderr, `
 pscanner - Fast TCP port scanner
 
 Usage:
-  pscanner --host <host> [--ports 1-1024] [--workers 100] [--timeout 500]
-
-Options:
-  --host     Target host (domain name or IP) [required]
-  --ports    Ports to scan, supports single ports and ranges (default: 1-1024)
-             Example: "80,443,8080,21-25"
-  --workers  Number of concurrent workers (default: 100)
-  --timeout  Dial timeout in milliseconds (default: 500)
-  --help     Show this help message
+  pscanner <command> [options]
 
-Example:
-  pscanner --host example.com --ports 80,443,8000-8100 --workers 200 --timeout 300
+Commands:
 `)
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", c.name, c.short)
 	}
+	fmt.Fprintf(os.Stderr, `  %-12s %s
 
-	flag.Parse()
+Run "pscanner <command> --help" for the options of a command, or
+"pscanner help <command>" for its full documentation.
+Run "pscanner --version" to print the version.
+`, "help", "Show help for a command")
+}
 
-	if *hostFlag == "" {
-		fmt.Fprintln(os.Stderr, "error: --host is required")
-		flag.Usage()
-		os.Exit(2)
+func lookupCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
 	}
+	return nil
+}
 
-	if *workersFlag <= 0 {
-		fmt.Fprintln(os.Stderr, "error: --workers must be > 0")
-		os.Exit(2)
-	}
-	if *workersFlag > 10000 {
-		fmt.Fprintln(os.Stderr, "error: --workers too large (max 10000)")
+func main() {
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, nil)))
+	args := os.Args[1:]
+	if len(args) == 0 {
+		usage()
 		os.Exit(2)
 	}
 
-	ports, err := parsePorts(*portsFlag)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error parsing ports: %v\n", err)
-		os.Exit(2)
+	switch args[0] {
+	case "-version", "--version":
+		runVersion(nil)
+		return
 	}
-	if len(ports) == 0 {
-		fmt.Fprintln(os.Stderr, "no ports to scan")
-		os.Exit(0)
-	}
-
-	portsCh := make(chan int, *workersFlag)
-	resultsCh := make(chan int)
-	var wg sync.WaitGroup
-	timeout := time.Duration(*timeoutFlag) * time.Millisecond
 
-	numWorkers := *workersFlag
-	if numWorkers > len(ports) {
-		numWorkers = len(ports)
+	// Bare flags ("pscanner --host x") predate subcommands and mean "scan".
+	if strings.HasPrefix(args[0], "-") && args[0] != "-h" && args[0] != "-help" && args[0] != "--help" {
+		runScan(args)
+		return
 	}
-	for i := 0; i < numWorkers; i++ {
-		wg.Add(1)
-		go worker(*hostFlag, portsCh, resultsCh, timeout, &wg)
-	}
-
-	go func() {
-		wg.Wait()
-		close(resultsCh)
-	}()
 
-	go func() {
-		for _, p := range ports {
-			portsCh <- p
+	switch args[0] {
+	case "-h", "-help", "--help":
+		usage()
+		return
+	case "help":
+		if len(args) > 1 {
+			if c := lookupCommand(args[1]); c != nil {
+				if c.doc == nil {
+					c.run([]string{"--help"})
+					return
+				}
+				var fs *flag.FlagSet
+				if c.flags != nil {
+					fs = c.flags()
+				}
+				writeCommandHelp(os.Stdout, c.name, fs, c.doc, true)
+				return
+			}
+			fmt.Fprintf(os.Stderr, "error: unknown command %q\n", args[1])
+			os.Exit(2)
 		}
-		close(portsCh)
-	}()
-
-	var open []int
-	for p := range resultsCh {
-		open = append(open, p)
+		usage()
+		return
 	}
 
-	sort.Ints(open)
-	fmt.Printf("Host: %s\n", *hostFlag)
-	fmt.Printf("Scanned ports: %d\n", len(ports))
-	fmt.Printf("Workers used: %d\n", numWorkers)
-	fmt.Printf("Timeout: %dms\n", *timeoutFlag)
-	fmt.Println("Open ports:")
-	if len(open) == 0 {
-		fmt.Println("  (none found)")
-	} else {
-		for _, p := range open {
-			fmt.Printf("  %d\n", p)
-		}
+	c := lookupCommand(args[0])
+	if c == nil {
+		fmt.Fprintf(os.Stderr, "error: unknown command %q\n", args[0])
+		usage()
+		os.Exit(2)
 	}
+	c.run(args[1:])
 }
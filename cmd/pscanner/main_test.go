@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestLookupCommand(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, c := range commands {
+		if seen[c.name] {
+			t.Errorf("command %q registered twice", c.name)
+		}
+		seen[c.name] = true
+		if c.short == "" || c.run == nil {
+			t.Errorf("command %q lacks a description or run function", c.name)
+		}
+		if got := lookupCommand(c.name); got == nil || got.name != c.name {
+			t.Errorf("lookupCommand(%q) = %v", c.name, got)
+		}
+	}
+	if !seen["scan"] {
+		t.Error("no scan command")
+	}
+	for _, name := range []string{"", "help", "--host", "Scan"} {
+		if c := lookupCommand(name); c != nil {
+			t.Errorf("lookupCommand(%q) = %q, want nil", name, c.name)
+		}
+	}
+}
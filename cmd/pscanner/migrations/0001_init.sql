@@ -0,0 +1,47 @@
+-- Scan results, normalized so that ports can be queried across scans.
+
+CREATE TABLE scans (
+    id              bigserial PRIMARY KEY,
+    scan_id         text NOT NULL UNIQUE,
+    schedule        text,
+    started_at      timestamptz NOT NULL,
+    finished_at     timestamptz NOT NULL,
+    canceled        boolean NOT NULL DEFAULT false,
+    targets         text[] NOT NULL,
+    target_count    integer NOT NULL,
+    ports           text NOT NULL,
+    port_count      integer NOT NULL,
+    workers         integer NOT NULL,
+    timeout_ms      bigint NOT NULL,
+    profile         text,
+    scanner_version text NOT NULL
+);
+CREATE INDEX scans_started_at ON scans (started_at DESC);
+
+-- Only hosts with open ports or a host timeout are stored; the scope of a
+-- scan is in scans.targets.
+CREATE TABLE hosts (
+    id        bigserial PRIMARY KEY,
+    scan      bigint NOT NULL REFERENCES scans (id) ON DELETE CASCADE,
+    address   text NOT NULL,
+    timed_out boolean NOT NULL DEFAULT false,
+    UNIQUE (scan, address)
+);
+CREATE INDEX hosts_address ON hosts (address);
+
+-- Service names as pscanner knew them when the port was first stored.
+CREATE TABLE services (
+    port     integer NOT NULL,
+    protocol text NOT NULL,
+    name     text NOT NULL,
+    PRIMARY KEY (port, protocol)
+);
+
+CREATE TABLE ports (
+    host     bigint NOT NULL REFERENCES hosts (id) ON DELETE CASCADE,
+    port     integer NOT NULL,
+    protocol text NOT NULL,
+    state    text NOT NULL,
+    PRIMARY KEY (host, port, protocol)
+);
+CREATE INDEX ports_port ON ports (port, protocol);
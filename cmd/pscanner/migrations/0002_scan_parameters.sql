@@ -0,0 +1,12 @@
+-- The rest of the scan parameters, and the report schema version the scan
+-- was written with. Scans stored before this migration have no schema
+-- version.
+
+ALTER TABLE scans
+    ADD COLUMN schema_version  integer,
+    ADD COLUMN host_timeout_ms bigint NOT NULL DEFAULT 0,
+    ADD COLUMN delay_ms        bigint NOT NULL DEFAULT 0,
+    ADD COLUMN proxy           text,
+    ADD COLUMN source          text,
+    ADD COLUMN prefer          text,
+    ADD COLUMN routes          text[];
@@ -0,0 +1,7 @@
+-- With --prefer both a hostname has a result for each address family, so
+-- a host is one address over one family. Family is empty for IP addresses
+-- and for hostnames probed over whichever family the dialer picked.
+
+ALTER TABLE hosts ADD COLUMN family text NOT NULL DEFAULT '';
+ALTER TABLE hosts DROP CONSTRAINT hosts_scan_address_key;
+ALTER TABLE hosts ADD UNIQUE (scan, address, family);
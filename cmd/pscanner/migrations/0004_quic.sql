@@ -0,0 +1,4 @@
+-- Whether the scan probed its hosts for QUIC (--quic), so that a missing
+-- udp port is known to be closed.
+
+ALTER TABLE scans ADD COLUMN quic boolean NOT NULL DEFAULT false;
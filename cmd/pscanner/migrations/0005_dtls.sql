@@ -0,0 +1,4 @@
+-- Whether the scan probed its hosts' DTLS ports (--dtls), so that a missing
+-- udp port is known to be closed.
+
+ALTER TABLE scans ADD COLUMN dtls boolean NOT NULL DEFAULT false;
@@ -0,0 +1,4 @@
+-- Whether the scan probed its hosts' IKE and OpenVPN ports (--vpn), so that
+-- a missing udp port is known to be closed.
+
+ALTER TABLE scans ADD COLUMN vpn boolean NOT NULL DEFAULT false;
@@ -0,0 +1,4 @@
+-- The --udp probes the scan made of its hosts, so that a missing udp port
+-- is known to be closed.
+
+ALTER TABLE scans ADD COLUMN udp_probes text[];
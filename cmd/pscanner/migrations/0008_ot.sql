@@ -0,0 +1,5 @@
+-- Whether the scan identified industrial devices with --ot, which also
+-- asks every host for BACnet, and whether it did so with --ot-safe.
+
+ALTER TABLE scans ADD COLUMN ot boolean NOT NULL DEFAULT false;
+ALTER TABLE scans ADD COLUMN ot_safe boolean NOT NULL DEFAULT false;
@@ -0,0 +1,4 @@
+-- Whether the scan checked its open Docker, Kubernetes and etcd ports for
+-- access without credentials (--containers).
+
+ALTER TABLE scans ADD COLUMN containers boolean NOT NULL DEFAULT false;
@@ -0,0 +1,3 @@
+-- The --tcp probes the scan made of its hosts' open ports.
+
+ALTER TABLE scans ADD COLUMN tcp_probes text[];
@@ -0,0 +1,4 @@
+-- Whether the scan checked for Elasticsearch, Kibana and Prometheus
+-- instances that answer without credentials (--open-instances).
+
+ALTER TABLE scans ADD COLUMN open_instances boolean NOT NULL DEFAULT false;
@@ -0,0 +1,4 @@
+-- Whether the scan classified web and gRPC ports by their application
+-- protocol (--endpoints).
+
+ALTER TABLE scans ADD COLUMN endpoints boolean NOT NULL DEFAULT false;
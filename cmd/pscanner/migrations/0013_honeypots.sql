@@ -0,0 +1,4 @@
+-- Whether the scan looked for the signs of honeypots and tarpits
+-- (--honeypots).
+
+ALTER TABLE scans ADD COLUMN honeypots boolean NOT NULL DEFAULT false;
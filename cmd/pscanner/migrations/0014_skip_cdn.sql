@@ -0,0 +1,4 @@
+-- Whether the scan probed targets behind a CDN on the edge's ports alone
+-- (--skip-cdn).
+
+ALTER TABLE scans ADD COLUMN skip_cdn boolean NOT NULL DEFAULT false;
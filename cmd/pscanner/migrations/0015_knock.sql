@@ -0,0 +1,5 @@
+-- The knock sequence made on each host before it was probed, and the pause
+-- after each knock (--knock, --knock-delay).
+
+ALTER TABLE scans ADD COLUMN knock text;
+ALTER TABLE scans ADD COLUMN knock_delay_ms bigint NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+-- The --payload options the scan sent to its hosts' open ports, as given.
+
+ALTER TABLE scans ADD COLUMN payloads text[];
@@ -0,0 +1,3 @@
+-- The names of the --script scripts the scan ran against open ports.
+
+ALTER TABLE scans ADD COLUMN scripts text[];
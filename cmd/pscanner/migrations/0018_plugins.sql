@@ -0,0 +1,3 @@
+-- The names of the --plugin WebAssembly plugins the scan loaded.
+
+ALTER TABLE scans ADD COLUMN plugins text[];
@@ -0,0 +1,30 @@
+-- Every open host port ever stored, with when and in which scans it was
+-- first and last seen open, as of the end of those scans. Assets outlive
+-- the scans they name, so scans can be deleted without losing when a port
+-- first appeared.
+
+CREATE TABLE assets (
+    address    text NOT NULL,
+    port       integer NOT NULL,
+    protocol   text NOT NULL,
+    service    text,
+    first_seen timestamptz NOT NULL,
+    last_seen  timestamptz NOT NULL,
+    first_scan text NOT NULL,
+    last_scan  text NOT NULL,
+    scans      integer NOT NULL DEFAULT 1,
+    PRIMARY KEY (address, port, protocol)
+);
+CREATE INDEX assets_first_seen ON assets (first_seen DESC);
+CREATE INDEX assets_last_seen ON assets (last_seen DESC);
+
+INSERT INTO assets (address, port, protocol, service, first_seen, last_seen, first_scan, last_scan, scans)
+SELECT h.address, p.port, p.protocol, min(sv.name), min(s.finished_at), max(s.finished_at),
+    (array_agg(s.scan_id ORDER BY s.finished_at))[1],
+    (array_agg(s.scan_id ORDER BY s.finished_at DESC))[1],
+    count(*)
+FROM ports p
+JOIN hosts h ON h.id = p.host
+JOIN scans s ON s.id = h.scan
+LEFT JOIN services sv ON sv.port = p.port AND sv.protocol = p.protocol
+GROUP BY h.address, p.port, p.protocol;
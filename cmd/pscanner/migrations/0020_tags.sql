@@ -0,0 +1,5 @@
+-- The --tag labels of the scan, as a JSON object of strings, so that
+-- scans can be selected by tag with @>.
+
+ALTER TABLE scans ADD COLUMN tags jsonb NOT NULL DEFAULT '{}';
+CREATE INDEX scans_tags ON scans USING gin (tags);
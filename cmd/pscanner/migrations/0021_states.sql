@@ -0,0 +1,6 @@
+-- The --state port states a scan listed, NULL for open alone, and the
+-- closed and filtered ports of its hosts as port ranges.
+
+ALTER TABLE scans ADD COLUMN states text[];
+ALTER TABLE hosts ADD COLUMN closed_ports text;
+ALTER TABLE hosts ADD COLUMN filtered_ports text;
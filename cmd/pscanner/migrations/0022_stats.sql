@@ -0,0 +1,5 @@
+-- Whether a scan summed up the probes of each host, with --stats, and the
+-- sums of its hosts.
+
+ALTER TABLE scans ADD COLUMN stats boolean NOT NULL DEFAULT false;
+ALTER TABLE hosts ADD COLUMN stats jsonb;
@@ -0,0 +1,5 @@
+-- The --retries of a scan, and the probes it took to find each port open
+-- when that was more than one.
+
+ALTER TABLE scans ADD COLUMN retries integer NOT NULL DEFAULT 0;
+ALTER TABLE ports ADD COLUMN attempts integer;
@@ -0,0 +1,4 @@
+-- Whether a scan probed every port of hosts that appeared down, with
+-- --force.
+
+ALTER TABLE scans ADD COLUMN force boolean NOT NULL DEFAULT false;
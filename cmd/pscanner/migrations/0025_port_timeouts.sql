@@ -0,0 +1,3 @@
+-- The --port-timeout overrides of a scan, as "25:3s,1433:2s".
+
+ALTER TABLE scans ADD COLUMN port_timeouts text;
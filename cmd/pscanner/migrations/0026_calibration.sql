@@ -0,0 +1,5 @@
+-- The --calibrate-port of a scan and the bounds of the timeouts it picked.
+
+ALTER TABLE scans ADD COLUMN calibrate_port integer;
+ALTER TABLE scans ADD COLUMN min_timeout_ms bigint;
+ALTER TABLE scans ADD COLUMN max_timeout_ms bigint;
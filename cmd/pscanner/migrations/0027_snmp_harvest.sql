@@ -0,0 +1,4 @@
+-- Whether a scan read the neighbour tables of routers and switches with
+-- --snmp-harvest and scanned the hosts in them.
+
+ALTER TABLE scans ADD COLUMN snmp_harvest boolean NOT NULL DEFAULT false;
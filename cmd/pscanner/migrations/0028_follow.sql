@@ -0,0 +1,4 @@
+-- The rounds of hosts the results pointed to that a scan followed, with
+-- --follow.
+
+ALTER TABLE scans ADD COLUMN follow integer;
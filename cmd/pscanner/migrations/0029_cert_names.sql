@@ -0,0 +1,4 @@
+-- Whether a scan read the names in the certificates of open TLS ports,
+-- with --cert-names.
+
+ALTER TABLE scans ADD COLUMN cert_names boolean NOT NULL DEFAULT false;
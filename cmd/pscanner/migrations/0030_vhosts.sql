@@ -0,0 +1,3 @@
+-- The --vhosts file of a scan, as given.
+
+ALTER TABLE scans ADD COLUMN vhosts text;
@@ -0,0 +1,3 @@
+-- The --screenshots directory of a scan.
+
+ALTER TABLE scans ADD COLUMN screenshots text;
@@ -0,0 +1,3 @@
+-- The --http-paths paths of a scan.
+
+ALTER TABLE scans ADD COLUMN http_paths text[];
@@ -0,0 +1,3 @@
+-- Whether a scan made the --safe-checks of open web ports.
+
+ALTER TABLE scans ADD COLUMN safe_checks boolean NOT NULL DEFAULT false;
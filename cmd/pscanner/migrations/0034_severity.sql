@@ -0,0 +1,4 @@
+-- The --severity rules of a scan, and the severity they gave each open port.
+
+ALTER TABLE scans ADD COLUMN severity text;
+ALTER TABLE ports ADD COLUMN severity text;
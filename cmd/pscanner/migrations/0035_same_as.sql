@@ -0,0 +1,4 @@
+-- The other results of the same machine: the scanned addresses of a
+-- hostname, or the hostnames of an address.
+
+ALTER TABLE hosts ADD COLUMN same_as text[];
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// TDS packet types and PRELOGIN options (MS-TDS 2.2.3, 2.2.6.5).
+const (
+	tdsPrelogin      = 0x12
+	tdsLogin7        = 0x10
+	tdsReply         = 0x04
+	tdsOptVersion    = 0x00
+	tdsOptEncryption = 0x01
+	tdsOptEnd        = 0xff
+	// tdsEncryptNotSup says the client cannot encrypt, which servers that
+	// do not insist on it take by encrypting nothing.
+	tdsEncryptNotSup = 0x02
+)
+
+// sqlServerVersions are the SQL Server releases by major version.
+var sqlServerVersions = map[byte]string{
+	9: "SQL Server 2005", 10: "SQL Server 2008", 11: "SQL Server 2012", 12: "SQL Server 2014",
+	13: "SQL Server 2016", 14: "SQL Server 2017", 15: "SQL Server 2019", 16: "SQL Server 2022",
+	17: "SQL Server 2025",
+}
+
+// mssqlProbe sends a TDS PRELOGIN for SQL Server's version, then, unless
+// the server insists on encryption, a LOGIN7 with integrated security,
+// whose NTLM challenge tells of the server.
+func (p *scanPlan) mssqlProbe(conn net.Conn, h *HostResult, port int) (*TCPService, error) {
+	conn.SetDeadline(time.Now().Add(tcpProbeTimeout))
+	// Two options, VERSION and ENCRYPTION, and the end mark.
+	prelogin := []byte{
+		tdsOptVersion, 0, 11, 0, 6,
+		tdsOptEncryption, 0, 17, 0, 1,
+		tdsOptEnd,
+		0, 0, 0, 0, 0, 0,
+		tdsEncryptNotSup,
+	}
+	resp, err := tdsAsk(conn, tdsPrelogin, prelogin)
+	if err != nil {
+		return nil, nil
+	}
+	options := tdsOptions(resp)
+	version, ok := options[tdsOptVersion]
+	if !ok || len(version) < 4 {
+		return nil, nil
+	}
+	s := &TCPService{Details: fmt.Sprintf("%d.%d.%d", version[0], version[1], binary.BigEndian.Uint16(version[2:]))}
+	if name := sqlServerVersions[version[0]]; name != "" {
+		s.Details = fmt.Sprintf("%s (%s)", name, s.Details)
+	}
+	if e := options[tdsOptEncryption]; len(e) == 1 && e[0] != 0 && e[0] != tdsEncryptNotSup {
+		s.Details += ", encryption required"
+		return s, nil
+	}
+	if resp, err := tdsAsk(conn, tdsLogin7, tdsLogin(ntlmNegotiate)); err == nil {
+		if info, err := parseNTLMChallenge(resp); err == nil {
+			s.NTLM = info
+			s.Details += "; " + info.String()
+		}
+	}
+	return s, nil
+}
+
+// tdsLogin is a LOGIN7 message (MS-TDS 2.2.6.4) that asks for integrated
+// security with sspi as its first token.
+func tdsLogin(sspi []byte) []byte {
+	const fixed = 94
+	name := utf16Bytes("pscanner")
+	b := binary.LittleEndian.AppendUint32(nil, uint32(fixed+2*len(name)+len(sspi)))
+	b = append(b, 0x04, 0x00, 0x00, 0x74) // TDS 7.4
+	b = binary.LittleEndian.AppendUint32(b, 4096)
+	b = append(b, make([]byte, 4+4+4)...) // client version, process, connection
+	// USE_DB and INIT_DB_FATAL and SET_LANG warnings; ODBC and
+	// integrated security; no type or other flags.
+	b = append(b, 0xe0, 0x83, 0, 0)
+	b = append(b, make([]byte, 4+4)...) // time zone, LCID
+	offset := uint16(fixed)
+	field := func(chars int, size int) {
+		b = binary.LittleEndian.AppendUint16(b, offset)
+		b = binary.LittleEndian.AppendUint16(b, uint16(chars))
+		offset += uint16(size)
+	}
+	n := len(name) / 2
+	field(n, len(name)) // host name
+	field(0, 0)         // user name
+	field(0, 0)         // password
+	field(n, len(name)) // application name
+	field(0, 0)         // server name
+	field(0, 0)         // extension
+	field(0, 0)         // client library
+	field(0, 0)         // language
+	field(0, 0)         // database
+	// The client ID, then the SSPI token.
+	b = append(b, make([]byte, 6)...)
+	field(len(sspi), len(sspi))
+	field(0, 0) // attach file
+	field(0, 0) // change password
+	b = binary.LittleEndian.AppendUint32(b, 0)
+	b = append(b, name...)
+	b = append(b, name...)
+	return append(b, sspi...)
+}
+
+// tdsOptions reads the options of a PRELOGIN answer by token.
+func tdsOptions(b []byte) map[byte][]byte {
+	options := make(map[byte][]byte)
+	for i := 0; i+5 <= len(b) && b[i] != tdsOptEnd; i += 5 {
+		off, n := int(binary.BigEndian.Uint16(b[i+1:])), int(binary.BigEndian.Uint16(b[i+3:]))
+		if off+n > len(b) {
+			break
+		}
+		options[b[i]] = b[off : off+n]
+	}
+	return options
+}
+
+// tdsAsk sends a TDS message of kind in one packet and reads the answer's
+// first packet.
+func tdsAsk(conn net.Conn, kind byte, payload []byte) ([]byte, error) {
+	msg := []byte{kind, 0x01, 0, 0, 0, 0, 1, 0} // end of message, packet 1
+	binary.BigEndian.PutUint16(msg[2:], uint16(8+len(payload)))
+	if _, err := conn.Write(append(msg, payload...)); err != nil {
+		return nil, err
+	}
+	var head [8]byte
+	if _, err := io.ReadFull(conn, head[:]); err != nil {
+		return nil, err
+	}
+	n := int(binary.BigEndian.Uint16(head[2:]))
+	if head[0] != tdsReply || n < 8 {
+		return nil, fmt.Errorf("not a TDS answer")
+	}
+	resp := make([]byte, n-8)
+	_, err := io.ReadFull(conn, resp)
+	return resp, err
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeSQLServer answers a PRELOGIN as SQL Server 2019 with the encryption
+// option given, and a LOGIN7 with integrated security with an SSPI token
+// holding the challenge of corpDC.
+func fakeSQLServer(encryption byte) func(net.Conn) {
+	return func(conn net.Conn) {
+		for {
+			var head [8]byte
+			if _, err := io.ReadFull(conn, head[:]); err != nil {
+				return
+			}
+			req := make([]byte, binary.BigEndian.Uint16(head[2:])-8)
+			if _, err := io.ReadFull(conn, req); err != nil {
+				return
+			}
+			var payload []byte
+			switch head[0] {
+			case tdsPrelogin:
+				payload = []byte{
+					tdsOptVersion, 0, 11, 0, 6,
+					tdsOptEncryption, 0, 17, 0, 1,
+					tdsOptEnd,
+					15, 0, 0x07, 0xd0, 0, 0, // 15.0.2000
+					encryption,
+				}
+			case tdsLogin7:
+				if !bytes.Contains(req, ntlmNegotiate) || req[25]&0x80 == 0 {
+					return
+				}
+				challenge := ntlmChallenge(corpDC, 17763)
+				payload = binary.LittleEndian.AppendUint16([]byte{0xed}, uint16(len(challenge)))
+				payload = append(payload, challenge...)
+			}
+			resp := []byte{tdsReply, 0x01, 0, 0, 0, 0, 1, 0}
+			binary.BigEndian.PutUint16(resp[2:], uint16(8+len(payload)))
+			conn.Write(append(resp, payload...))
+		}
+	}
+}
+
+func TestMSSQLProbe(t *testing.T) {
+	p := &scanPlan{timeout: time.Second}
+	h := &HostResult{Host: "127.0.0.1"}
+	for _, tt := range []struct {
+		name string
+		port int
+		want *TCPService
+	}{
+		{"encryption off", serveTCP(t, fakeSQLServer(0)), &TCPService{Details: "SQL Server 2019 (15.0.2000); " + corpDC.String(), NTLM: &corpDC}},
+		{"encryption required", serveTCP(t, fakeSQLServer(3)), &TCPService{Details: "SQL Server 2019 (15.0.2000), encryption required"}},
+	} {
+		got, err := p.tcpProbe(context.Background(), nil, h, tt.port, tcpProbes["mssql"])
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: mssql probe = %+v, %v; want %+v", tt.name, got, err, tt.want)
+		}
+	}
+
+	// An SSH server is no SQL Server.
+	ssh := serveTCP(t, func(conn net.Conn) {
+		io.WriteString(conn, "SSH-2.0-OpenSSH_9.6\r\n")
+		io.Copy(io.Discard, conn)
+	})
+	if got, err := p.tcpProbe(context.Background(), nil, h, ssh, tcpProbes["mssql"]); got != nil || err != nil {
+		t.Errorf("mssql probe of an SSH server = %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+)
+
+// WS-Discovery and SLP, which "discover --multicast" asks along with mDNS
+// and SSDP. Printers, IP cameras and appliances that announce nothing else
+// often answer one of them.
+
+const (
+	wsdAddr = "239.255.255.250:3702"
+	slpAddr = "239.255.255.253:427"
+	slpPort = 427
+)
+
+// wsdProbe is a WS-Discovery Probe with no types or scopes, which every
+// target service answers.
+const wsdProbe = `<?xml version="1.0" encoding="utf-8"?>` +
+	`<soap:Envelope xmlns:soap="http://www.w3.org/2003/05/soap-envelope" xmlns:wsa="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:wsd="http://schemas.xmlsoap.org/ws/2005/04/discovery">` +
+	`<soap:Header>` +
+	`<wsa:To>urn:schemas-xmlsoap-org:ws:2005:04:discovery</wsa:To>` +
+	`<wsa:Action>http://schemas.xmlsoap.org/ws/2005/04/discovery/Probe</wsa:Action>` +
+	`<wsa:MessageID>urn:uuid:%s</wsa:MessageID>` +
+	`</soap:Header>` +
+	`<soap:Body><wsd:Probe/></soap:Body>` +
+	`</soap:Envelope>`
+
+// wsdQuery returns a Probe with a fresh message ID, which answers refer
+// to.
+func wsdQuery() []byte {
+	var id [16]byte
+	rand.Read(id[:])
+	id[6] = id[6]&0x0f | 0x40 // version 4
+	id[8] = id[8]&0x3f | 0x80 // RFC 4122 variant
+	uuid := fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:])
+	return []byte(fmt.Sprintf(wsdProbe, uuid))
+}
+
+// wsdProbeMatches is the part of a ProbeMatches answer that pscanner
+// reads. Elements match by local name, whatever their namespace prefix.
+type wsdProbeMatches struct {
+	Matches []struct {
+		Types  string `xml:"Types"`
+		Scopes string `xml:"Scopes"`
+	} `xml:"Body>ProbeMatches>ProbeMatch"`
+}
+
+// parseWSD reads a ProbeMatches answer into the names and service types
+// of the device: the local part of its types, such as
+// "NetworkVideoTransmitter" for ONVIF cameras or "PrintDeviceType", and
+// the name an ONVIF scope gives it.
+func parseWSD(msg []byte) (names, services []string, ok bool) {
+	var m wsdProbeMatches
+	if xml.Unmarshal(msg, &m) != nil || len(m.Matches) == 0 {
+		return nil, nil, false
+	}
+	for _, pm := range m.Matches {
+		for _, t := range strings.Fields(pm.Types) {
+			if _, local, ok := strings.Cut(t, ":"); ok {
+				t = local
+			}
+			if t != "Device" {
+				services = append(services, t)
+			}
+		}
+		for _, s := range strings.Fields(pm.Scopes) {
+			if name, ok := strings.CutPrefix(s, "onvif://www.onvif.org/name/"); ok {
+				if n, err := url.PathUnescape(name); err == nil {
+					name = n
+				}
+				names = append(names, name)
+			}
+		}
+	}
+	return names, services, true
+}
+
+// askWSD probes for WS-Discovery target services: Windows computers,
+// printers and scanners, and ONVIF cameras and recorders.
+func askWSD(ctx context.Context, li lanInterface, f *lanFinds) error {
+	if li.ifi.Flags&net.FlagMulticast == 0 {
+		return nil
+	}
+	conn, err := listenLAN(ctx, li)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	dst, _ := net.ResolveUDPAddr("udp4", wsdAddr)
+	if _, err := conn.WriteToUDP(wsdQuery(), dst); err != nil {
+		return err
+	}
+	readAnswers(conn, func(from netip.Addr, msg []byte) {
+		if names, services, ok := parseWSD(msg); ok {
+			f.add(from, "wsd", names, services)
+		}
+	})
+	return nil
+}
+
+// SLPv2 (RFC 2608) function IDs and the request-multicast flag.
+const (
+	slpSrvTypeRqst   = 9
+	slpSrvTypeRply   = 10
+	slpFlagMulticast = 0x2000
+)
+
+// slpQuery is a SrvTypeRqst for the service types of every naming
+// authority in the default scope, sent to many agents at once.
+func slpQuery(xid uint16) []byte {
+	var body []byte
+	body = binary.BigEndian.AppendUint16(body, 0)      // previous responders
+	body = binary.BigEndian.AppendUint16(body, 0xffff) // all naming authorities
+	body = appendSLPString(body, "default")
+	return appendSLPHeader(nil, slpSrvTypeRqst, slpFlagMulticast, xid, body)
+}
+
+// appendSLPHeader appends an SLPv2 message with body to b.
+func appendSLPHeader(b []byte, function byte, flags, xid uint16, body []byte) []byte {
+	const lang = "en"
+	n := 14 + len(lang) + len(body)
+	b = append(b, 2, function, byte(n>>16), byte(n>>8), byte(n))
+	b = binary.BigEndian.AppendUint16(b, flags)
+	b = append(b, 0, 0, 0) // no extensions
+	b = binary.BigEndian.AppendUint16(b, xid)
+	b = appendSLPString(b, lang)
+	return append(b, body...)
+}
+
+func appendSLPString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// parseSLP reads a SrvTypeRply to the request xid and returns the service
+// types it lists, without their "service:" prefix, such as "printer:lpr".
+func parseSLP(msg []byte, xid uint16) ([]string, bool) {
+	if len(msg) < 14 || msg[0] != 2 || msg[1] != slpSrvTypeRply || binary.BigEndian.Uint16(msg[10:]) != xid {
+		return nil, false
+	}
+	rest := msg[12:]
+	_, rest, ok := cutSLPString(rest) // language tag
+	if !ok || len(rest) < 2 || binary.BigEndian.Uint16(rest) != 0 {
+		return nil, false
+	}
+	list, _, ok := cutSLPString(rest[2:])
+	if !ok {
+		return nil, false
+	}
+	var types []string
+	for _, t := range strings.Split(list, ",") {
+		if t = strings.TrimPrefix(strings.TrimSpace(t), "service:"); t != "" {
+			types = append(types, t)
+		}
+	}
+	return types, true
+}
+
+// cutSLPString reads a length-prefixed string off the front of b.
+func cutSLPString(b []byte) (string, []byte, bool) {
+	if len(b) < 2 {
+		return "", nil, false
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return "", nil, false
+	}
+	return string(b[2 : 2+n]), b[2+n:], true
+}
+
+// askSLP asks the SLP service agents for their service types, by
+// multicast, and by broadcast to each attached network for agents that do
+// not join the group, as RFC 2608 allows.
+func askSLP(ctx context.Context, li lanInterface, f *lanFinds) error {
+	conn, err := listenLAN(ctx, li)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var id [2]byte
+	rand.Read(id[:])
+	xid := binary.BigEndian.Uint16(id[:])
+	q := slpQuery(xid)
+	if li.ifi.Flags&net.FlagMulticast != 0 {
+		if _, err := conn.WriteToUDPAddrPort(q, netip.MustParseAddrPort(slpAddr)); err != nil {
+			return err
+		}
+	}
+	if li.ifi.Flags&net.FlagBroadcast != 0 {
+		for _, n := range li.nets {
+			if n.Bits() < 31 {
+				// Some networks refuse broadcasts; the group may still
+				// reach their agents.
+				_, _ = conn.WriteToUDPAddrPort(q, netip.AddrPortFrom(lastAddr(n.Masked()), slpPort))
+			}
+		}
+	}
+	readAnswers(conn, func(from netip.Addr, msg []byte) {
+		if types, ok := parseSLP(msg, xid); ok {
+			f.add(from, "slp", nil, types)
+		}
+	})
+	return nil
+}
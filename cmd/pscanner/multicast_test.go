@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestParseWSD(t *testing.T) {
+	msg := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<SOAP-ENV:Envelope xmlns:SOAP-ENV="http://www.w3.org/2003/05/soap-envelope" xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery" xmlns:dn="http://www.onvif.org/ver10/network/wsdl" xmlns:tds="http://www.onvif.org/ver10/device/wsdl">
+<SOAP-ENV:Header/>
+<SOAP-ENV:Body><d:ProbeMatches><d:ProbeMatch>
+<d:Types>dn:NetworkVideoTransmitter tds:Device</d:Types>
+<d:Scopes>onvif://www.onvif.org/type/video_encoder onvif://www.onvif.org/name/Front%20Door onvif://www.onvif.org/hardware/DS-2CD2043</d:Scopes>
+<d:XAddrs>http://192.168.1.64/onvif/device_service</d:XAddrs>
+</d:ProbeMatch></d:ProbeMatches></SOAP-ENV:Body>
+</SOAP-ENV:Envelope>`)
+	names, services, ok := parseWSD(msg)
+	if !ok || !reflect.DeepEqual(names, []string{"Front Door"}) || !reflect.DeepEqual(services, []string{"NetworkVideoTransmitter"}) {
+		t.Errorf("parseWSD = %q, %q, %v", names, services, ok)
+	}
+	if _, _, ok := parseWSD([]byte(`<Envelope><Body><Probe/></Body></Envelope>`)); ok {
+		t.Error("parseWSD accepted a Probe")
+	}
+	if q := wsdQuery(); !bytes.Contains(q, []byte("discovery/Probe")) || bytes.Equal(q, wsdQuery()) {
+		t.Errorf("wsdQuery = %s, want a Probe with a fresh message ID", q)
+	}
+}
+
+func TestParseSLP(t *testing.T) {
+	q := slpQuery(0x1234)
+	if len(q) != int(q[2])<<16|int(q[3])<<8|int(q[4]) || q[1] != slpSrvTypeRqst || binary.BigEndian.Uint16(q[5:]) != slpFlagMulticast {
+		t.Fatalf("slpQuery = %x", q)
+	}
+
+	body := binary.BigEndian.AppendUint16(nil, 0) // no error
+	body = appendSLPString(body, "service:printer:lpr,service:http, service:wbem")
+	reply := appendSLPHeader(nil, slpSrvTypeRply, 0, 0x1234, body)
+	types, ok := parseSLP(reply, 0x1234)
+	if want := []string{"printer:lpr", "http", "wbem"}; !ok || !reflect.DeepEqual(types, want) {
+		t.Errorf("parseSLP = %q, %v, want %q", types, ok, want)
+	}
+	if _, ok := parseSLP(reply, 0x4321); ok {
+		t.Error("parseSLP accepted a reply to another request")
+	}
+	if _, ok := parseSLP(reply[:len(reply)-3], 0x1234); ok {
+		t.Error("parseSLP accepted a truncated reply")
+	}
+}
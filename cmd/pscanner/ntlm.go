@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+// NTLMInfo is what a server tells about itself in the challenge of an
+// NTLM authentication: its names and domain, and its Windows version.
+// Windows servers answer anyone who starts one, before any credentials.
+type NTLMInfo struct {
+	// Computer and Domain are the NetBIOS names.
+	Computer    string `json:"computer,omitempty"`
+	Domain      string `json:"domain,omitempty"`
+	DNSComputer string `json:"dns_computer,omitempty"`
+	DNSDomain   string `json:"dns_domain,omitempty"`
+	// Forest is the DNS name of the Active Directory forest.
+	Forest string `json:"forest,omitempty"`
+	// Version is the Windows version, as major.minor.build.
+	Version string `json:"version,omitempty"`
+	// OS names the Windows releases of that build.
+	OS string `json:"os,omitempty"`
+}
+
+func (n NTLMInfo) String() string {
+	var parts []string
+	if n.Computer != "" || n.DNSComputer != "" {
+		parts = append(parts, "computer "+nameAndDNS(n.Computer, n.DNSComputer))
+	}
+	if n.Domain != "" || n.DNSDomain != "" {
+		parts = append(parts, "domain "+nameAndDNS(n.Domain, n.DNSDomain))
+	}
+	if n.Forest != "" && !strings.EqualFold(n.Forest, n.DNSDomain) {
+		parts = append(parts, "forest "+n.Forest)
+	}
+	switch {
+	case n.OS != "":
+		parts = append(parts, fmt.Sprintf("%s (%s)", n.OS, n.Version))
+	case n.Version != "":
+		parts = append(parts, "Windows "+n.Version)
+	}
+	return "NTLM " + strings.Join(parts, ", ")
+}
+
+// nameAndDNS joins a NetBIOS name and a DNS name, either of which may be
+// missing.
+func nameAndDNS(name, dns string) string {
+	switch {
+	case name == "":
+		return dns
+	case dns == "" || strings.EqualFold(name, dns):
+		return name
+	}
+	return fmt.Sprintf("%s (%s)", name, dns)
+}
+
+// windowsBuilds are the Windows releases of the builds a server reports,
+// for the ones still met.
+var windowsBuilds = map[uint16]string{
+	3790:  "Windows Server 2003",
+	6001:  "Windows Server 2008",
+	6002:  "Windows Server 2008",
+	6003:  "Windows Server 2008",
+	7600:  "Windows Server 2008 R2 or Windows 7",
+	7601:  "Windows Server 2008 R2 or Windows 7",
+	9200:  "Windows Server 2012 or Windows 8",
+	9600:  "Windows Server 2012 R2 or Windows 8.1",
+	14393: "Windows Server 2016 or Windows 10 1607",
+	17763: "Windows Server 2019 or Windows 10 1809",
+	19041: "Windows 10 2004",
+	19044: "Windows 10 21H2",
+	19045: "Windows 10 22H2",
+	20348: "Windows Server 2022",
+	22621: "Windows 11 22H2",
+	22631: "Windows 11 23H2",
+	26100: "Windows Server 2025 or Windows 11 24H2",
+}
+
+// ntlmSignature starts every NTLM message.
+var ntlmSignature = []byte("NTLMSSP\x00")
+
+// ntlmNegotiate is an NTLM NEGOTIATE_MESSAGE (MS-NLMP 2.2.1.1) asking for
+// the target's names and version, with no domain or workstation of its
+// own.
+var ntlmNegotiate = []byte{
+	'N', 'T', 'L', 'M', 'S', 'S', 'P', 0,
+	1, 0, 0, 0,
+	// UNICODE, OEM, REQUEST_TARGET, NTLM, ALWAYS_SIGN,
+	// EXTENDED_SESSIONSECURITY, TARGET_INFO, VERSION, 128, 56.
+	0x07, 0x82, 0x88, 0xa2,
+	0, 0, 0, 0, 0, 0, 0, 0, // DomainNameFields
+	0, 0, 0, 0, 0, 0, 0, 0, // WorkstationFields
+	10, 0, 0, 0, 0, 0, 0, 15, // Version: 10.0, NTLM revision 15
+}
+
+// spnegoNegotiate wraps ntlmNegotiate in a SPNEGO NegTokenInit (RFC 4178)
+// offering NTLM alone, for the protocols that want one.
+var spnegoNegotiate = berTLV(0x60,
+	[]byte{0x06, 0x06, 0x2b, 0x06, 0x01, 0x05, 0x05, 0x02}, // 1.3.6.1.5.5.2
+	berTLV(0xa0, berTLV(0x30,
+		berTLV(0xa0, berTLV(0x30, []byte{0x06, 0x0a, 0x2b, 0x06, 0x01, 0x04, 0x01, 0x82, 0x37, 0x02, 0x02, 0x0a})), // 1.3.6.1.4.1.311.2.2.10
+		berTLV(0xa2, berTLV(0x04, ntlmNegotiate)))))
+
+var errNoChallenge = errors.New("no NTLM challenge")
+
+// parseNTLMChallenge reads the CHALLENGE_MESSAGE (MS-NLMP 2.2.1.2) found
+// in b, which may wrap it in SPNEGO or a protocol's own fields.
+func parseNTLMChallenge(b []byte) (*NTLMInfo, error) {
+	i := bytes.Index(b, ntlmSignature)
+	if i < 0 {
+		return nil, errNoChallenge
+	}
+	msg := b[i:]
+	if len(msg) < 48 || binary.LittleEndian.Uint32(msg[8:]) != 2 {
+		return nil, errNoChallenge
+	}
+	flags := binary.LittleEndian.Uint32(msg[20:])
+	info := &NTLMInfo{}
+	if flags&0x02000000 != 0 && len(msg) >= 56 {
+		build := binary.LittleEndian.Uint16(msg[50:])
+		info.Version = fmt.Sprintf("%d.%d.%d", msg[48], msg[49], build)
+		info.OS = windowsBuilds[build]
+	}
+	n := int(binary.LittleEndian.Uint16(msg[40:]))
+	off := int(binary.LittleEndian.Uint32(msg[44:]))
+	if off > len(msg) || n > len(msg)-off {
+		return nil, errors.New("NTLM target info out of bounds")
+	}
+	for av := msg[off : off+n]; len(av) >= 4; {
+		id, size := binary.LittleEndian.Uint16(av), int(binary.LittleEndian.Uint16(av[2:]))
+		if id == 0 || size > len(av)-4 {
+			break
+		}
+		value := utf16String(av[4 : 4+size])
+		switch id {
+		case 1:
+			info.Computer = value
+		case 2:
+			info.Domain = value
+		case 3:
+			info.DNSComputer = value
+		case 4:
+			info.DNSDomain = value
+		case 5:
+			info.Forest = value
+		}
+		av = av[4+size:]
+	}
+	return info, nil
+}
+
+// utf16String decodes little-endian UTF-16.
+func utf16String(b []byte) string {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(u))
+}
+
+// utf16Bytes encodes s as little-endian UTF-16.
+func utf16Bytes(s string) []byte {
+	var b []byte
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = binary.LittleEndian.AppendUint16(b, u)
+	}
+	return b
+}
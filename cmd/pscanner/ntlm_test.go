@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// ntlmChallenge is the CHALLENGE_MESSAGE a Windows server of info and
+// build answers an NTLM negotiation with.
+func ntlmChallenge(info NTLMInfo, build uint16) []byte {
+	var av []byte
+	for _, f := range []struct {
+		id    uint16
+		value string
+	}{{1, info.Computer}, {2, info.Domain}, {3, info.DNSComputer}, {4, info.DNSDomain}, {5, info.Forest}} {
+		if f.value == "" {
+			continue
+		}
+		v := utf16Bytes(f.value)
+		av = binary.LittleEndian.AppendUint16(av, f.id)
+		av = binary.LittleEndian.AppendUint16(av, uint16(len(v)))
+		av = append(av, v...)
+	}
+	av = append(av, 7, 0, 8, 0, 1, 2, 3, 4, 5, 6, 7, 8) // timestamp
+	av = append(av, 0, 0, 0, 0)
+	target := utf16Bytes(info.Domain)
+
+	b := append([]byte{}, ntlmSignature...)
+	b = binary.LittleEndian.AppendUint32(b, 2)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(target)))
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(target)))
+	b = binary.LittleEndian.AppendUint32(b, 56)
+	b = binary.LittleEndian.AppendUint32(b, 0xa2898205)
+	b = append(b, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88) // server challenge
+	b = append(b, make([]byte, 8)...)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(av)))
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(av)))
+	b = binary.LittleEndian.AppendUint32(b, uint32(56+len(target)))
+	b = append(b, 10, 0)
+	b = binary.LittleEndian.AppendUint16(b, build)
+	b = append(b, 0, 0, 0, 15)
+	b = append(b, target...)
+	return append(b, av...)
+}
+
+// corpDC is what the domain controller of the tests tells of itself.
+var corpDC = NTLMInfo{
+	Computer:    "DC01",
+	Domain:      "CORP",
+	DNSComputer: "dc01.corp.example.com",
+	DNSDomain:   "corp.example.com",
+	Forest:      "example.com",
+	Version:     "10.0.17763",
+	OS:          "Windows Server 2019 or Windows 10 1809",
+}
+
+func TestParseNTLMChallenge(t *testing.T) {
+	// Wrapped in a SPNEGO answer, or anything else.
+	msg := append([]byte{0xa1, 0x81, 0xc0, 0x30}, ntlmChallenge(corpDC, 17763)...)
+	info, err := parseNTLMChallenge(msg)
+	if err != nil || !reflect.DeepEqual(*info, corpDC) {
+		t.Errorf("parseNTLMChallenge = %+v, %v; want %+v", info, err, corpDC)
+	}
+	const want = "NTLM computer DC01 (dc01.corp.example.com), domain CORP (corp.example.com), forest example.com, Windows Server 2019 or Windows 10 1809 (10.0.17763)"
+	if info != nil && info.String() != want {
+		t.Errorf("String = %q, want %q", info.String(), want)
+	}
+
+	unknown, _ := parseNTLMChallenge(ntlmChallenge(NTLMInfo{Computer: "WS7"}, 12345))
+	if unknown == nil || unknown.String() != "NTLM computer WS7, Windows 10.0.12345" {
+		t.Errorf("unknown build = %v", unknown)
+	}
+
+	for _, bad := range [][]byte{
+		nil,
+		ntlmNegotiate,
+		ntlmChallenge(corpDC, 17763)[:40],
+		append(bytes.Clone(ntlmChallenge(corpDC, 17763)[:44]), 0xff, 0xff, 0, 0),
+	} {
+		if info, err := parseNTLMChallenge(bad); err == nil {
+			t.Errorf("parseNTLMChallenge(%x) = %+v", bad, info)
+		}
+	}
+}
@@ -0,0 +1,555 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OTDevice is what an industrial device told --ot about itself.
+type OTDevice struct {
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+	// Service is the protocol it answered: modbus, s7, dnp3 or bacnet.
+	Service string `json:"service"`
+	Vendor  string `json:"vendor,omitempty"`
+	Product string `json:"product,omitempty"`
+	Version string `json:"version,omitempty"`
+	// Details is anything else it said, such as a serial number or a
+	// DNP3 address.
+	Details string `json:"details,omitempty"`
+}
+
+func (d OTDevice) String() string {
+	s := fmt.Sprintf("%s/%d %s", d.Protocol, d.Port, d.Service)
+	var id []string
+	for _, f := range []string{d.Vendor, d.Product, d.Version} {
+		if f != "" {
+			id = append(id, f)
+		}
+	}
+	if len(id) > 0 {
+		s += ": " + strings.Join(id, " ")
+	}
+	if d.Details != "" {
+		s += " (" + d.Details + ")"
+	}
+	return s
+}
+
+// The ports --ot identifies devices on. The TCP ones are only probed when
+// the scan found them open; BACnet, over UDP, is asked on every host.
+// Tests point bacnetPort at their own socket.
+const (
+	s7Port     = 102
+	modbusPort = 502
+	dnp3Port   = 20000
+)
+
+var bacnetPort = 47808
+
+// dnp3Addresses are the outstation addresses --ot asks for a DNP3 link
+// status, stopping at the first that answers: the usual defaults.
+var dnp3Addresses = []uint16{1, 10, 4, 0, 1024}
+
+// dnp3Master is the master address the link status requests come from.
+const dnp3Master = 3
+
+// probeOT reads the identity of the industrial devices among hosts, with
+// requests that only read: Modbus device identification, S7 system status
+// lists, a DNP3 link status and BACnet device properties. With --ot-safe
+// it asks one host at a time and waits --delay between requests, as some
+// controllers fall over when asked too much too fast.
+func (p *scanPlan) probeOT(ctx context.Context, hosts []HostResult) {
+	if !p.ot {
+		return
+	}
+	dns := newDNSCache(p.dnsCache)
+	parallel := quicParallel
+	if p.otSafe {
+		parallel = 1
+	}
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i := range hosts {
+		h := &hosts[i]
+		sem <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			for _, pr := range h.Ports {
+				if pr.Protocol == "udp" {
+					continue
+				}
+				var probe otProbeFunc
+				switch pr.Port {
+				case modbusPort:
+					probe = p.modbusProbe
+				case s7Port:
+					probe = p.s7Probe
+				case dnp3Port:
+					probe = p.dnp3Probe
+				default:
+					continue
+				}
+				d, err := p.otProbe(ctx, dns, h, pr.Port, probe)
+				if err != nil {
+					slog.Warn("ot probe failed", "host", h.Host, "port", pr.Port, "err", err)
+				}
+				if d != nil {
+					h.OT = append(h.OT, *d)
+				}
+			}
+			// UDP does not go through proxies.
+			if p.proxy != nil {
+				return
+			}
+			addr, _, err := udpAddr(ctx, dns, h.Host, h.Family)
+			if err != nil {
+				slog.Warn("ot probe failed", "host", h.Host, "err", err)
+				return
+			}
+			d, err := p.bacnetProbe(ctx, addr)
+			if err != nil {
+				slog.Warn("ot probe failed", "host", h.Host, "protocol", "bacnet", "err", err)
+			}
+			if d != nil {
+				h.OT = append(h.OT, *d)
+				addUDPPort(h, bacnetPort, "bacnet")
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// otProbe lets probe ask the device on port of h who it is, over
+// connections made the way the scan made them.
+func (p *scanPlan) otProbe(ctx context.Context, dns *dnsCache, h *HostResult, port int, probe otProbeFunc) (*OTDevice, error) {
+	d, err := probe(ctx, func() (net.Conn, error) {
+		return p.probe(ctx, dns, job{host: h.Host, port: port, family: h.Family})
+	})
+	if d != nil {
+		d.Port, d.Protocol = port, "tcp"
+	}
+	return d, err
+}
+
+// otProbeFunc identifies a device over TCP, connecting with dial as many
+// times as it needs. Every read and write has a deadline of --timeout.
+type otProbeFunc func(ctx context.Context, dial func() (net.Conn, error)) (*OTDevice, error)
+
+// otPause waits --delay before the next request to a device under
+// --ot-safe.
+func (p *scanPlan) otPause(ctx context.Context) {
+	if !p.otSafe || p.delay <= 0 {
+		return
+	}
+	t := time.NewTimer(p.delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
+
+// otExchange writes req to conn and reads the answer, framed by a header
+// of n bytes whose length field, at off, counts the bytes after it plus
+// extra.
+func (p *scanPlan) otExchange(conn net.Conn, req []byte, n, off, extra int) ([]byte, error) {
+	conn.SetDeadline(time.Now().Add(p.timeout))
+	if _, err := conn.Write(req); err != nil {
+		return nil, err
+	}
+	head := make([]byte, n)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return nil, err
+	}
+	size := int(binary.BigEndian.Uint16(head[off:])) + extra
+	if size < 0 || size > 4096 {
+		return nil, fmt.Errorf("answer of %d bytes", size)
+	}
+	resp := make([]byte, size)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return nil, err
+	}
+	return append(head, resp...), nil
+}
+
+// Modbus device identification objects (Modbus application protocol,
+// 6.21).
+var modbusObjects = map[byte]string{0: "vendor", 1: "product code", 2: "revision", 3: "vendor URL", 4: "product name", 5: "model name"}
+
+// modbusProbe reads the basic device identification of a Modbus TCP
+// device (function 43, MEI type 14). A device that only answers with an
+// exception still speaks Modbus.
+func (p *scanPlan) modbusProbe(ctx context.Context, dial func() (net.Conn, error)) (*OTDevice, error) {
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	req := []byte{0, 1, 0, 0, 0, 5, 0, 0x2b, 0x0e, 0x01, 0x00} // transaction 1, unit 0; basic identification from object 0
+	resp, err := p.otExchange(conn, req, 6, 4, 0)
+	if err != nil {
+		return nil, nil
+	}
+	return parseModbusIdentification(resp)
+}
+
+// parseModbusIdentification reads the answer to a Read Device
+// Identification request, MBAP header included.
+func parseModbusIdentification(b []byte) (*OTDevice, error) {
+	if len(b) < 9 || !bytes.Equal(b[:4], []byte{0, 1, 0, 0}) {
+		return nil, errors.New("not a Modbus answer")
+	}
+	d := &OTDevice{Service: "modbus"}
+	pdu := b[7:]
+	if pdu[0] == 0x2b|0x80 {
+		d.Details = fmt.Sprintf("exception %d", pdu[1])
+		return d, nil
+	}
+	if len(pdu) < 7 || pdu[0] != 0x2b || pdu[1] != 0x0e {
+		return nil, errors.New("not a device identification")
+	}
+	objects := pdu[7:]
+	for range pdu[6] {
+		if len(objects) < 2 || len(objects) < 2+int(objects[1]) {
+			break
+		}
+		id, value := objects[0], string(objects[2:2+int(objects[1])])
+		objects = objects[2+int(objects[1]):]
+		switch id {
+		case 0:
+			d.Vendor = value
+		case 1:
+			d.Product = value
+		case 2:
+			d.Version = value
+		default:
+			if name := modbusObjects[id]; name != "" {
+				d.Details = strings.TrimPrefix(d.Details+", "+name+" "+value, ", ")
+			}
+		}
+	}
+	return d, nil
+}
+
+// s7TSAPs are the destination TSAPs an S7 connection is asked for: rack 0,
+// slot 2 (S7-300/400), then slot 1 (S7-1200/1500).
+var s7TSAPs = []uint16{0x0102, 0x0101}
+
+// s7Probe opens an ISO-on-TCP connection to a Siemens S7 controller, sets
+// up S7 communication and reads its module and component identification
+// system status lists (SZL 0x0011 and 0x001c), as the engineering tools
+// do before anything else.
+func (p *scanPlan) s7Probe(ctx context.Context, dial func() (net.Conn, error)) (*OTDevice, error) {
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	connected := false
+	for i, tsap := range s7TSAPs {
+		if i > 0 {
+			// The controller closes a connection it refused.
+			conn.Close()
+			p.otPause(ctx)
+		}
+		var err error
+		if conn, err = dial(); err != nil {
+			conn = nil
+			return nil, err
+		}
+		// TPKT, then a COTP connection request from TSAP 0x0100.
+		req := []byte{3, 0, 0, 22, 17, 0xe0, 0, 0, 0, 1, 0, 0xc0, 1, 0x0a, 0xc1, 2, 1, 0, 0xc2, 2, byte(tsap >> 8), byte(tsap)}
+		resp, err := p.otExchange(conn, req, 4, 2, -4)
+		if err != nil {
+			return nil, nil
+		}
+		if len(resp) > 5 && resp[5]&0xf0 == 0xd0 { // connection confirm
+			connected = true
+			break
+		}
+	}
+	if !connected {
+		return nil, nil
+	}
+	p.otPause(ctx)
+	setup := []byte{3, 0, 0, 25, 2, 0xf0, 0x80, 0x32, 1, 0, 0, 0, 0, 0, 8, 0, 0, 0xf0, 0, 0, 1, 0, 1, 1, 0xe0}
+	resp, err := p.otExchange(conn, setup, 4, 2, -4)
+	if err != nil || len(resp) < 9 || resp[7] != 0x32 || resp[8] != 3 {
+		return nil, nil
+	}
+	d := &OTDevice{Service: "s7", Vendor: "Siemens"}
+	var details []string
+	for _, id := range []uint16{0x0011, 0x001c} {
+		p.otPause(ctx)
+		resp, err := p.otExchange(conn, s7ReadSZL(id), 4, 2, -4)
+		if err != nil {
+			break
+		}
+		records, ok := parseS7SZL(resp, id)
+		if !ok {
+			continue
+		}
+		for _, r := range records {
+			index := binary.BigEndian.Uint16(r)
+			text := strings.TrimSpace(string(bytes.TrimRight(r[2:], "\x00")))
+			switch {
+			case id == 0x0011 && index == 1 && len(r) >= 22:
+				details = append(details, "order number "+strings.TrimSpace(string(bytes.TrimRight(r[2:22], "\x00"))))
+			case id == 0x0011 && index == 7 && len(r) >= 28 && r[24] == 'V':
+				d.Version = fmt.Sprintf("V%d.%d.%d", r[25], r[26], r[27])
+			case id == 0x001c && index == 1 && text != "":
+				details = append(details, fmt.Sprintf("name %q", text))
+			case id == 0x001c && index == 5 && text != "":
+				details = append(details, "serial "+text)
+			case id == 0x001c && index == 7 && text != "":
+				d.Product = text
+			}
+		}
+	}
+	d.Details = strings.Join(details, ", ")
+	return d, nil
+}
+
+// s7ReadSZL is an S7 userdata request for the system status list id.
+func s7ReadSZL(id uint16) []byte {
+	req := []byte{3, 0, 0, 33, 2, 0xf0, 0x80, 0x32, 7, 0, 0, 0, 0, 0, 8, 0, 8, // header, 8 bytes of parameters and of data
+		0, 1, 0x12, 4, 0x11, 0x44, 1, 0, // CPU functions, read SZL, sequence 0
+		0xff, 9, 0, 4} // data: 4 bytes of octet string
+	req = binary.BigEndian.AppendUint16(req, id)
+	return append(req, 0, 1) // index 1
+}
+
+// parseS7SZL returns the records of the system status list id in an
+// answer to s7ReadSZL.
+func parseS7SZL(b []byte, id uint16) ([][]byte, bool) {
+	if len(b) < 17 || b[7] != 0x32 || b[8] != 7 {
+		return nil, false
+	}
+	s7 := b[7:]
+	params, size := int(binary.BigEndian.Uint16(s7[6:])), int(binary.BigEndian.Uint16(s7[8:]))
+	if len(s7) < 10+params+size || size < 12 {
+		return nil, false
+	}
+	data := s7[10+params : 10+params+size]
+	if data[0] != 0xff || binary.BigEndian.Uint16(data[4:]) != id {
+		return nil, false
+	}
+	length, count := int(binary.BigEndian.Uint16(data[8:])), int(binary.BigEndian.Uint16(data[10:]))
+	var records [][]byte
+	for rest := data[12:]; count > 0 && length >= 2 && len(rest) >= length; count-- {
+		records = append(records, rest[:length])
+		rest = rest[length:]
+	}
+	return records, true
+}
+
+// dnp3Probe asks for the link status of the usual outstation addresses,
+// which an outstation answers at its own address.
+func (p *scanPlan) dnp3Probe(ctx context.Context, dial func() (net.Conn, error)) (*OTDevice, error) {
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	for i, addr := range dnp3Addresses {
+		if i > 0 {
+			p.otPause(ctx)
+		}
+		conn.SetDeadline(time.Now().Add(p.timeout))
+		if _, err := conn.Write(dnp3LinkStatusRequest(addr, dnp3Master)); err != nil {
+			return nil, nil
+		}
+		resp := make([]byte, 10)
+		if _, err := io.ReadFull(conn, resp); err != nil {
+			if ctx.Err() != nil || !isTimeout(err) {
+				return nil, nil
+			}
+			continue
+		}
+		if src, ok := parseDNP3LinkStatus(resp); ok {
+			return &OTDevice{Service: "dnp3", Details: fmt.Sprintf("outstation address %d", src)}, nil
+		}
+	}
+	return nil, nil
+}
+
+// dnp3LinkStatusRequest is a DNP3 link layer Request Link Status frame from
+// the master at src to dst.
+func dnp3LinkStatusRequest(dst, src uint16) []byte {
+	b := []byte{0x05, 0x64, 5, 0xc9} // from a master, primary, function 9
+	b = binary.LittleEndian.AppendUint16(b, dst)
+	b = binary.LittleEndian.AppendUint16(b, src)
+	return binary.LittleEndian.AppendUint16(b, dnp3CRC(b))
+}
+
+// parseDNP3LinkStatus reads a Link Status frame and returns the address it
+// came from.
+func parseDNP3LinkStatus(b []byte) (uint16, bool) {
+	if len(b) < 10 || b[0] != 0x05 || b[1] != 0x64 || b[3]&0x4f != 0x0b || binary.LittleEndian.Uint16(b[8:]) != dnp3CRC(b[:8]) {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint16(b[6:]), true
+}
+
+// dnp3CRC is the CRC of DNP3 link frames: polynomial 0x3d65, reflected,
+// inverted.
+func dnp3CRC(b []byte) uint16 {
+	var crc uint16
+	for _, c := range b {
+		crc ^= uint16(c)
+		for range 8 {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xa6bc
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return ^crc
+}
+
+// BACnet device object properties (ASHRAE 135, 12.11).
+const (
+	bacnetFirmwareRevision = 44
+	bacnetModelName        = 70
+	bacnetObjectName       = 77
+	bacnetVendorName       = 121
+)
+
+// bacnetProbe reads the name, vendor, model and firmware of the BACnet/IP
+// device at addr, one ReadProperty of the device object at a time.
+func (p *scanPlan) bacnetProbe(ctx context.Context, addr netip.Addr) (*OTDevice, error) {
+	var d *OTDevice
+	for _, prop := range []byte{bacnetObjectName, bacnetVendorName, bacnetModelName, bacnetFirmwareRevision} {
+		if d != nil {
+			p.otPause(ctx)
+		}
+		pr := &udpProbe{port: bacnetPort, request: func() []byte { return bacnetReadProperty(prop) }, parse: parseBACnetProperty}
+		value, ok, err := p.udpProbe(ctx, addr, pr)
+		if err != nil {
+			return d, err
+		}
+		if !ok {
+			if d == nil {
+				return nil, nil
+			}
+			continue
+		}
+		if d == nil {
+			d = &OTDevice{Port: bacnetPort, Protocol: "udp", Service: "bacnet"}
+		}
+		switch prop {
+		case bacnetObjectName:
+			if value != "" {
+				d.Details = fmt.Sprintf("name %q", value)
+			}
+		case bacnetVendorName:
+			d.Vendor = value
+		case bacnetModelName:
+			d.Product = value
+		case bacnetFirmwareRevision:
+			d.Version = value
+		}
+	}
+	return d, nil
+}
+
+// bacnetReadProperty is a confirmed ReadProperty request for prop of the
+// device object, addressed by the wildcard instance every device answers
+// to.
+func bacnetReadProperty(prop byte) []byte {
+	id := make([]byte, 1)
+	rand.Read(id)
+	apdu := []byte{0x00, 0x05, id[0], 0x0c, // confirmed request, up to 1476 bytes; ReadProperty
+		0x0c, 0x02, 0x3f, 0xff, 0xff, // object: device 4194303
+		0x19, prop} // property
+	b := []byte{0x81, 0x0a, 0, 0, 0x01, 0x04} // BVLC original unicast; NPDU expecting a reply
+	b = append(b, apdu...)
+	binary.BigEndian.PutUint16(b[2:], uint16(len(b)))
+	return b
+}
+
+// parseBACnetProperty reads the answer to bacnetReadProperty: the value of
+// a string property, or "" for an error or another kind of value, which
+// still says a device is there.
+func parseBACnetProperty(req, resp []byte) (string, bool) {
+	if len(resp) < 6 || resp[0] != 0x81 || int(binary.BigEndian.Uint16(resp[2:])) != len(resp) || resp[4] != 0x01 {
+		return "", false
+	}
+	// Skip the NPDU: the destination and source specifiers if present.
+	control, npdu := resp[5], resp[6:]
+	if control&0x80 != 0 {
+		return "", false // a network layer message
+	}
+	if control&0x20 != 0 {
+		if len(npdu) < 3 || len(npdu) < 3+int(npdu[2]) {
+			return "", false
+		}
+		npdu = npdu[3+int(npdu[2]):]
+	}
+	if control&0x08 != 0 {
+		if len(npdu) < 3 || len(npdu) < 3+int(npdu[2]) {
+			return "", false
+		}
+		npdu = npdu[3+int(npdu[2]):]
+	}
+	if control&0x20 != 0 {
+		if len(npdu) < 1 {
+			return "", false
+		}
+		npdu = npdu[1:] // hop count
+	}
+	apdu := npdu
+	invoke := req[8]
+	if len(apdu) < 3 || apdu[1] != invoke || apdu[2] != 0x0c {
+		return "", false
+	}
+	switch apdu[0] >> 4 {
+	case 5, 6, 7: // error, reject, abort
+		return "", true
+	case 3: // complex acknowledgement
+	default:
+		return "", false
+	}
+	// The object, the property and any array index, then the value after
+	// opening tag 3: a character string is application tag 7.
+	value := apdu[3:]
+	if len(value) < 5 || value[0] != 0x0c {
+		return "", true
+	}
+	value = value[5:]
+	for _, tag := range []byte{0x18, 0x28} {
+		if len(value) > 0 && value[0]&0xf8 == tag {
+			n := 1 + int(value[0]&7)
+			if len(value) < n {
+				return "", true
+			}
+			value = value[n:]
+		}
+	}
+	if len(value) < 3 || value[0] != 0x3e || value[1]>>4 != 7 {
+		return "", true
+	}
+	value = value[1:]
+	n, value := int(value[0]&7), value[1:]
+	if n == 5 {
+		n, value = int(value[0]), value[1:]
+	}
+	if n < 1 || len(value) < n || value[0] != 0 { // UTF-8 only
+		return "", true
+	}
+	return string(value[1:n]), true
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// serveTCP runs handle for every connection to a local TCP port.
+func serveTCP(t *testing.T, handle func(conn net.Conn)) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(5 * time.Second))
+				handle(conn)
+			}()
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// readTPKT reads one TPKT packet of an S7 client.
+func readTPKT(conn net.Conn) []byte {
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return nil
+	}
+	b := make([]byte, int(binary.BigEndian.Uint16(head[2:]))-4)
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return nil
+	}
+	return append(head, b...)
+}
+
+func tpkt(b []byte) []byte {
+	return append([]byte{3, 0, byte((len(b) + 4) >> 8), byte(len(b) + 4)}, b...)
+}
+
+// s7SZLAnswer is the userdata answer with the records of SZL id.
+func s7SZLAnswer(id uint16, records ...[]byte) []byte {
+	data := []byte{0xff, 9, 0, 0}
+	data = binary.BigEndian.AppendUint16(data, id)
+	data = append(data, 0, 1)
+	data = binary.BigEndian.AppendUint16(data, uint16(len(records[0])))
+	data = binary.BigEndian.AppendUint16(data, uint16(len(records)))
+	for _, r := range records {
+		data = append(data, r...)
+	}
+	binary.BigEndian.PutUint16(data[2:], uint16(len(data)-4))
+	params := []byte{0, 1, 0x12, 8, 0x12, 0x84, 1, 0, 0, 0, 0, 0}
+	b := []byte{2, 0xf0, 0x80, 0x32, 7, 0, 0, 0, 0}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(params)))
+	b = binary.BigEndian.AppendUint16(b, uint16(len(data)))
+	return tpkt(append(append(b, params...), data...))
+}
+
+func s7Record(index uint16, text string, size int) []byte {
+	r := binary.BigEndian.AppendUint16(nil, index)
+	r = append(r, text...)
+	return append(r, make([]byte, size-len(r))...)
+}
+
+// fakeS7 is an S7-1200 on rack 0, slot 1, refusing slot 2.
+func fakeS7(conn net.Conn) {
+	req := readTPKT(conn)
+	if req == nil || req[len(req)-1] != 1 {
+		conn.Write(tpkt([]byte{6, 0x80, 0, 1, 0, 0, 0})) // disconnect request
+		return
+	}
+	conn.Write(tpkt([]byte{17, 0xd0, 0, 1, 0, 1, 0, 0xc0, 1, 0x0a, 0xc1, 2, 1, 0, 0xc2, 2, 1, 1}))
+	if readTPKT(conn) == nil {
+		return
+	}
+	conn.Write(tpkt([]byte{2, 0xf0, 0x80, 0x32, 3, 0, 0, 0, 0, 0, 8, 0, 0, 0, 0, 0xf0, 0, 0, 1, 0, 1, 0, 0xf0}))
+	for {
+		req := readTPKT(conn)
+		if req == nil {
+			return
+		}
+		switch binary.BigEndian.Uint16(req[len(req)-4:]) {
+		case 0x0011:
+			module := s7Record(1, "6ES7 214-1AG40-0XB0 ", 28)
+			firmware := s7Record(7, "", 28)
+			copy(firmware[24:], []byte{'V', 4, 2, 1})
+			conn.Write(s7SZLAnswer(0x0011, module, firmware))
+		case 0x001c:
+			conn.Write(s7SZLAnswer(0x001c,
+				s7Record(1, "PLC_1", 34),
+				s7Record(5, "S C-X4U421302016", 34),
+				s7Record(7, "CPU 1214C DC/DC/DC", 34)))
+		}
+	}
+}
+
+func TestOTProbes(t *testing.T) {
+	p := &scanPlan{timeout: time.Second}
+	h := &HostResult{Host: "127.0.0.1"}
+	modbus := serveTCP(t, func(conn net.Conn) {
+		req := make([]byte, 11)
+		if _, err := io.ReadFull(conn, req); err != nil || !bytes.Equal(req[7:], []byte{0x2b, 0x0e, 1, 0}) {
+			return
+		}
+		pdu := []byte{0x2b, 0x0e, 1, 0x81, 0, 0, 3}
+		for i, v := range []string{"Schneider Electric", "BMX P34 2020", "v2.70"} {
+			pdu = append(pdu, byte(i), byte(len(v)))
+			pdu = append(pdu, v...)
+		}
+		resp := append([]byte{0, 1, 0, 0, 0, byte(len(pdu) + 1), 0}, pdu...)
+		conn.Write(resp)
+	})
+	dnp3 := serveTCP(t, func(conn net.Conn) {
+		// An outstation at address 10, which ignores frames for others.
+		for {
+			req := make([]byte, 10)
+			if _, err := io.ReadFull(conn, req); err != nil {
+				return
+			}
+			if binary.LittleEndian.Uint16(req[4:]) == 10 {
+				resp := []byte{0x05, 0x64, 5, 0x0b, dnp3Master, 0, 10, 0}
+				conn.Write(binary.LittleEndian.AppendUint16(resp, dnp3CRC(resp)))
+			}
+		}
+	})
+	s7 := serveTCP(t, fakeS7)
+
+	for _, tt := range []struct {
+		port  int
+		probe otProbeFunc
+		want  *OTDevice
+	}{
+		{modbus, p.modbusProbe, &OTDevice{Port: modbus, Protocol: "tcp", Service: "modbus", Vendor: "Schneider Electric", Product: "BMX P34 2020", Version: "v2.70"}},
+		{dnp3, p.dnp3Probe, &OTDevice{Port: dnp3, Protocol: "tcp", Service: "dnp3", Details: "outstation address 10"}},
+		{s7, p.s7Probe, &OTDevice{Port: s7, Protocol: "tcp", Service: "s7", Vendor: "Siemens", Product: "CPU 1214C DC/DC/DC", Version: "V4.2.1",
+			Details: `order number 6ES7 214-1AG40-0XB0, name "PLC_1", serial S C-X4U421302016`}},
+	} {
+		got, err := p.otProbe(context.Background(), nil, h, tt.port, tt.probe)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("probe of %s = %+v, %v; want %+v", tt.want.Service, got, err, tt.want)
+		}
+	}
+
+	// A port that closes without answering is no device.
+	silent := serveTCP(t, func(net.Conn) {})
+	if got, err := p.otProbe(context.Background(), nil, h, silent, p.modbusProbe); got != nil || err != nil {
+		t.Errorf("modbus probe of a silent port = %+v, %v", got, err)
+	}
+}
+
+func TestBACnetProbe(t *testing.T) {
+	values := map[byte]string{bacnetObjectName: "AHU-3", bacnetVendorName: "Johnson Controls", bacnetModelName: "FEC2611"}
+	port := serveUDP(t, func(req []byte) [][]byte {
+		invoke, prop := req[8], req[len(req)-1]
+		value, ok := values[prop]
+		if !ok {
+			// Error: property, unknown property.
+			return [][]byte{{0x81, 0x0a, 0, 13, 0x01, 0x00, 0x50, invoke, 0x0c, 0x91, 0x02, 0x91, 0x20}}
+		}
+		apdu := []byte{0x30, invoke, 0x0c, 0x0c, 0x02, 0x00, 0x00, 0x3e, 0x19, prop, 0x3e, 0x75, byte(len(value) + 1), 0}
+		apdu = append(apdu, value...)
+		apdu = append(apdu, 0x3f)
+		// From behind a router: the source network and address.
+		b := []byte{0x81, 0x0a, 0, 0, 0x01, 0x08, 0x00, 0x05, 1, 0x07}
+		b = append(b, apdu...)
+		binary.BigEndian.PutUint16(b[2:], uint16(len(b)))
+		return [][]byte{b}
+	}, false)
+	defer func(port int) { bacnetPort = port }(bacnetPort)
+	bacnetPort = port
+
+	p := &scanPlan{timeout: time.Second}
+	got, err := p.bacnetProbe(context.Background(), netip.MustParseAddr("127.0.0.1"))
+	want := &OTDevice{Port: port, Protocol: "udp", Service: "bacnet", Vendor: "Johnson Controls", Product: "FEC2611", Details: `name "AHU-3"`}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("bacnetProbe = %+v, %v; want %+v", got, err, want)
+	}
+
+	bacnetPort = freeUDPPort(t)
+	p.timeout = 200 * time.Millisecond
+	if got, err := p.bacnetProbe(context.Background(), netip.MustParseAddr("127.0.0.1")); got != nil || err != nil {
+		t.Errorf("bacnetProbe of a closed port = %+v, %v", got, err)
+	}
+}
+
+func TestModbusException(t *testing.T) {
+	d, err := parseModbusIdentification([]byte{0, 1, 0, 0, 0, 3, 0, 0xab, 1})
+	if err != nil || d.Details != "exception 1" {
+		t.Errorf("exception = %+v, %v", d, err)
+	}
+}
+
+func TestDNP3CRC(t *testing.T) {
+	// The check value of CRC-16/DNP.
+	if got := dnp3CRC([]byte("123456789")); got != 0xea82 {
+		t.Errorf("dnp3CRC = %#x, want 0xea82", got)
+	}
+	req := dnp3LinkStatusRequest(1024, 1)
+	if want := []byte{0x05, 0x64, 0x05, 0xc9, 0x00, 0x04, 0x01, 0x00}; !bytes.Equal(req[:8], want) {
+		t.Fatalf("header = % x", req[:8])
+	}
+	if _, ok := parseDNP3LinkStatus(append([]byte{0x05, 0x64, 5, 0x0b, 1, 0, 0, 4}, req[8:]...)); ok {
+		t.Error("a frame with a wrong CRC accepted")
+	}
+}
+
+func TestOTDeviceString(t *testing.T) {
+	d := OTDevice{Port: 502, Protocol: "tcp", Service: "modbus", Vendor: "Schneider Electric", Product: "BMX P34 2020", Version: "v2.70", Details: "exception 2"}
+	if got, want := d.String(), "tcp/502 modbus: Schneider Electric BMX P34 2020 v2.70 (exception 2)"; got != want {
+		t.Errorf("String = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// portPayload is a --payload: bytes to send to a port once connected.
+type portPayload struct {
+	port int
+	data []byte
+	spec string // as given, for the report's parameters
+}
+
+// parsePayload reads a --payload of the form port:encoding:data, where the
+// encoding is hex, base64, or text with Go escapes such as \r\n.
+func parsePayload(s string) (portPayload, error) {
+	port, rest, ok := strings.Cut(s, ":")
+	enc, data, ok2 := strings.Cut(rest, ":")
+	if !ok || !ok2 {
+		return portPayload{}, fmt.Errorf("%q: expected port:encoding:data", s)
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return portPayload{}, fmt.Errorf("%q: invalid port", s)
+	}
+	pp := portPayload{port: n, spec: s}
+	switch enc {
+	case "hex":
+		pp.data, err = hex.DecodeString(data)
+	case "base64":
+		pp.data, err = base64.StdEncoding.DecodeString(data)
+	case "text":
+		var text string
+		text, err = strconv.Unquote(`"` + strings.ReplaceAll(data, `"`, `\"`) + `"`)
+		pp.data = []byte(text)
+	default:
+		return portPayload{}, fmt.Errorf("%q: encoding must be hex, base64 or text", s)
+	}
+	if err != nil {
+		return portPayload{}, fmt.Errorf("%q: invalid %s data", s, enc)
+	}
+	if len(pp.data) == 0 {
+		return portPayload{}, fmt.Errorf("%q: empty payload", s)
+	}
+	return pp, nil
+}
+
+// payloadList is the repeatable --payload flag.
+type payloadList []portPayload
+
+func (l *payloadList) Set(s string) error {
+	pp, err := parsePayload(s)
+	if err != nil {
+		return err
+	}
+	for _, other := range *l {
+		if other.port == pp.port {
+			return fmt.Errorf("%q: port %d already has a payload", s, pp.port)
+		}
+	}
+	*l = append(*l, pp)
+	return nil
+}
+
+func (l *payloadList) String() string {
+	specs := make([]string, len(*l))
+	for i, pp := range *l {
+		specs[i] = pp.spec
+	}
+	return strings.Join(specs, " ")
+}
+
+// PayloadReply is what an open port answered to its --payload.
+type PayloadReply struct {
+	Port int `json:"port"`
+	// Hex is the answer, up to payloadMaxReply bytes, and Text the same
+	// with what is not printable escaped, for reading.
+	Hex  string `json:"hex,omitempty"`
+	Text string `json:"text,omitempty"`
+	// Closed is set when the port closed the connection without a word.
+	Closed bool `json:"closed,omitempty"`
+}
+
+func (r PayloadReply) String() string {
+	switch {
+	case r.Hex != "":
+		return fmt.Sprintf("tcp/%d: %d bytes %s", r.Port, len(r.Hex)/2, r.Text)
+	case r.Closed:
+		return fmt.Sprintf("tcp/%d: closed without an answer", r.Port)
+	}
+	return fmt.Sprintf("tcp/%d: no answer", r.Port)
+}
+
+const (
+	// payloadMaxReply bounds what is kept of an answer.
+	payloadMaxReply = 4096
+	// payloadQuiet is how long an answer may pause before it is taken as
+	// whole.
+	payloadQuiet = 500 * time.Millisecond
+)
+
+// probePayloads sends each --payload to its port on the hosts where it is
+// open, over connections made the way the scan made them, and records the
+// answers.
+func (p *scanPlan) probePayloads(ctx context.Context, hosts []HostResult) {
+	if len(p.payloads) == 0 {
+		return
+	}
+	dns := newDNSCache(p.dnsCache)
+	sem := make(chan struct{}, quicParallel)
+	var wg sync.WaitGroup
+	for i := range hosts {
+		h := &hosts[i]
+		sem <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			for _, pp := range p.payloads {
+				if !hasOpenTCP(h, pp.port) {
+					continue
+				}
+				r, err := p.sendPayload(ctx, dns, h, pp)
+				if err != nil {
+					slog.Warn("payload failed", "host", h.Host, "port", pp.port, "err", err)
+					continue
+				}
+				h.Payloads = append(h.Payloads, *r)
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// hasOpenTCP reports whether port is among the open TCP ports of h.
+func hasOpenTCP(h *HostResult, port int) bool {
+	for _, pr := range h.Ports {
+		if pr.Protocol == "tcp" && pr.Port == port {
+			return true
+		}
+	}
+	return false
+}
+
+// sendPayload connects to the port of pp on h, sends its data, and reads
+// the answer until the port closes, pauses, or tcpProbeTimeout passes.
+func (p *scanPlan) sendPayload(ctx context.Context, dns *dnsCache, h *HostResult, pp portPayload) (*PayloadReply, error) {
+	conn, err := p.probe(ctx, dns, job{host: h.Host, port: pp.port, family: h.Family})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	defer context.AfterFunc(ctx, func() { conn.Close() })()
+	deadline := time.Now().Add(tcpProbeTimeout)
+	conn.SetWriteDeadline(deadline)
+	if _, err := conn.Write(pp.data); err != nil {
+		return nil, err
+	}
+	r := &PayloadReply{Port: pp.port}
+	reply, err := readReply(conn, payloadMaxReply, deadline)
+	// An end or a reset, rather than silence.
+	r.Closed = len(reply) == 0 && err != nil && !isTimeout(err)
+	if len(reply) > 0 {
+		r.Hex = hex.EncodeToString(reply)
+		r.Text = quoteBytes(reply)
+	}
+	return r, nil
+}
+
+// readReply reads what conn answers, up to max bytes, until it closes,
+// pauses for payloadQuiet once it has begun, or deadline passes. The error
+// is the one that ended the read, if not max.
+func readReply(conn net.Conn, max int, deadline time.Time) ([]byte, error) {
+	var reply []byte
+	buf := make([]byte, max)
+	for len(reply) < max {
+		until := deadline
+		if quiet := time.Now().Add(payloadQuiet); len(reply) > 0 && quiet.Before(deadline) {
+			until = quiet
+		}
+		conn.SetReadDeadline(until)
+		n, err := conn.Read(buf[:max-len(reply)])
+		reply = append(reply, buf[:n]...)
+		if err != nil {
+			return reply, err
+		}
+	}
+	return reply, nil
+}
+
+// quoteBytes quotes b as a Go string, escaping each byte that is not
+// printable ASCII on its own rather than reading runes out of binary.
+func quoteBytes(b []byte) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, c := range b {
+		switch {
+		case c == '"' || c == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c == '\r':
+			sb.WriteString(`\r`)
+		case c == '\n':
+			sb.WriteString(`\n`)
+		case c == '\t':
+			sb.WriteString(`\t`)
+		case c >= 0x20 && c < 0x7f:
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, `\x%02x`, c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
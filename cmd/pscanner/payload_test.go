@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParsePayload(t *testing.T) {
+	for _, tt := range []struct {
+		spec string
+		want []byte
+	}{
+		{"9999:hex:deadbeef", []byte{0xde, 0xad, 0xbe, 0xef}},
+		{"5000:base64:AAECAw==", []byte{0, 1, 2, 3}},
+		{`7000:text:HELLO "you"\r\n`, []byte("HELLO \"you\"\r\n")},
+		{"7001:text:a:b", []byte("a:b")},
+	} {
+		pp, err := parsePayload(tt.spec)
+		if err != nil || !bytes.Equal(pp.data, tt.want) {
+			t.Errorf("parsePayload(%q) = %q, %v; want %q", tt.spec, pp.data, err, tt.want)
+		}
+	}
+	for _, bad := range []string{"9999:hex:xyz", "9999:rot13:abc", "9999:hex:", "0:hex:00", "9999"} {
+		if _, err := parsePayload(bad); err == nil {
+			t.Errorf("parsePayload(%q) succeeded", bad)
+		}
+	}
+	var l payloadList
+	if err := l.Set("9999:hex:00"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Set("9999:text:x"); err == nil {
+		t.Error("a second payload for port 9999 was taken")
+	}
+}
+
+func TestProbePayloads(t *testing.T) {
+	echo := serveTCP(t, func(conn net.Conn) {
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return
+		}
+		conn.Write(append([]byte("OK\x00"), buf...))
+		time.Sleep(time.Second)
+	})
+	closer := serveTCP(t, func(conn net.Conn) {
+		conn.Read(make([]byte, 4))
+	})
+	hosts := []HostResult{{Host: "127.0.0.1", Ports: []PortResult{
+		{Port: echo, Protocol: "tcp", State: "open"},
+		{Port: closer, Protocol: "tcp", State: "open"},
+	}}}
+	var payloads payloadList
+	for _, spec := range []string{strconv.Itoa(echo) + ":hex:deadbeef", strconv.Itoa(closer) + ":text:PING", "1:hex:00"} {
+		if err := payloads.Set(spec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p := &scanPlan{timeout: time.Second, payloads: payloads}
+	p.probePayloads(context.Background(), hosts)
+	want := []PayloadReply{
+		{Port: echo, Hex: "4f4b00deadbeef", Text: `"OK\x00\xde\xad\xbe\xef"`},
+		{Port: closer, Closed: true},
+	}
+	if !reflect.DeepEqual(hosts[0].Payloads, want) {
+		t.Errorf("payload replies = %+v, want %+v", hosts[0].Payloads, want)
+	}
+	if s := want[0].String(); s != `tcp/`+strconv.Itoa(echo)+`: 7 bytes "OK\x00\xde\xad\xbe\xef"` {
+		t.Errorf("String() = %s", s)
+	}
+}
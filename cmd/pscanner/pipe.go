@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// pipeOptions holds the flags of the "pipe" command.
+type pipeOptions struct {
+	logFlags
+	parallel int
+	config   string
+}
+
+var pipeDoc = &commandDoc{
+	synopsis: "pscanner pipe [--parallel 4] [--config path] < jobs.ndjson",
+	description: `Run scan jobs read from standard input and stream their results.
+
+pipe lets a script or orchestration framework keep one pscanner process
+running instead of starting one per target. Every line of input is a JSON
+job with a host, its ports and further options:
+
+  {"id": "web-1", "host": "10.0.0.7", "ports": "80,443", "options": {"timeout": "500ms"}}
+
+host is required and takes what --host does. ports and the options
+top_ports, profile, workers, timeout, host_timeout and delay are optional
+and default as for "pscanner scan"; unknown fields are an error. id is
+copied to every line of output about the job and defaults to the input
+line number. The option tags, an object such as {"env": "prod"}, labels
+the job's report as --tag does. A job that would need confirmation on the
+command line is refused unless its options set "confirm": true.
+
+Every line of output is a JSON event with the job's id and an "event":
+
+  open    an open port as it is found, with host, port, protocol and state
+  done    the job finished; "report" holds the result as --output json
+          writes it
+  error   the job could not run; "error" says why
+
+Jobs run as they arrive, up to --parallel at once, so the events of
+different jobs interleave. pipe exits once its input ends and every job
+has finished; an interrupt cancels the running jobs, which still report
+what they found.`,
+	notes: map[string]string{
+		"parallel": `Further jobs wait for a running one to finish. Each job runs with its
+own workers, so the open connections add up.`,
+		"config": `Profiles, port groups, confirm_probes, allow_public_hosts and audit_log
+apply to the jobs.`,
+	},
+	examples: []string{
+		`echo '{"host": "10.0.0.7", "options": {"top_ports": 100}}' | pscanner pipe`,
+		"my-orchestrator | pscanner pipe --parallel 16 | jq -c 'select(.event == \"open\")'",
+	},
+}
+
+func (o *pipeOptions) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("pipe", flag.ExitOnError)
+	fs.IntVar(&o.parallel, "parallel", 4, "Number of jobs to run at once")
+	fs.StringVar(&o.config, "config", "", "Path to config file (default: user config dir)")
+	o.bindLog(fs)
+	fs.Usage = func() { writeCommandHelp(fs.Output(), "pipe", fs, pipeDoc, false) }
+	return fs
+}
+
+// runPipe implements the "pipe" command.
+func runPipe(args []string) {
+	var o pipeOptions
+	fs := o.flagSet()
+	_ = fs.Parse(args)
+	if err := o.startLog(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(2)
+	}
+	if o.parallel < 1 {
+		fmt.Fprintln(os.Stderr, "error: --parallel must be at least 1")
+		os.Exit(2)
+	}
+	configPath := o.config
+	if configPath == "" {
+		configPath = defaultConfigPath()
+	}
+	cfg, err := loadConfig(configPath, o.config != "")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading config: %v\n", err)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	jobs := newJobManager(cfg, nil, net.LookupHost)
+	jobs.audit = newAuditLog(cfg.AuditLog, fs)
+	if jobs.scope, err = loadScope(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(2)
+	}
+	if err := servePipe(ctx, os.Stdin, os.Stdout, jobs, o.parallel); err != nil {
+		fmt.Fprintf(os.Stderr, "error reading jobs: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// pipeJob is a line of input to "pscanner pipe".
+type pipeJob struct {
+	ID      string         `json:"id,omitempty"`
+	Host    string         `json:"host"`
+	Ports   string         `json:"ports,omitempty"`
+	Options pipeJobOptions `json:"options"`
+}
+
+// pipeJobOptions are the options of a pipe job, named as in a scan server
+// request.
+type pipeJobOptions struct {
+	TopPorts      int               `json:"top_ports,omitempty"`
+	Profile       string            `json:"profile,omitempty"`
+	Workers       int               `json:"workers,omitempty"`
+	Timeout       *Duration         `json:"timeout,omitempty"`
+	HostTimeout   *Duration         `json:"host_timeout,omitempty"`
+	Delay         *Duration         `json:"delay,omitempty"`
+	Retries       int               `json:"retries,omitempty"`
+	Force         bool              `json:"force,omitempty"`
+	PortTimeout   string            `json:"port_timeout,omitempty"`
+	CalibratePort int               `json:"calibrate_port,omitempty"`
+	MinTimeout    *Duration         `json:"min_timeout,omitempty"`
+	MaxTimeout    *Duration         `json:"max_timeout,omitempty"`
+	SNMPHarvest   bool              `json:"snmp_harvest,omitempty"`
+	Follow        int               `json:"follow,omitempty"`
+	CertNames     bool              `json:"cert_names,omitempty"`
+	Tags          map[string]string `json:"tags,omitempty"`
+	Confirm       bool              `json:"confirm,omitempty"`
+}
+
+// request returns the scan server request that runs the job.
+func (j *pipeJob) request() *scanRequest {
+	o := j.Options
+	return &scanRequest{
+		Hosts:         j.Host,
+		Ports:         j.Ports,
+		TopPorts:      o.TopPorts,
+		Profile:       o.Profile,
+		Workers:       o.Workers,
+		Timeout:       o.Timeout,
+		HostTimeout:   o.HostTimeout,
+		Delay:         o.Delay,
+		Retries:       o.Retries,
+		Force:         o.Force,
+		PortTimeout:   o.PortTimeout,
+		CalibratePort: o.CalibratePort,
+		MinTimeout:    o.MinTimeout,
+		MaxTimeout:    o.MaxTimeout,
+		SNMPHarvest:   o.SNMPHarvest,
+		Follow:        o.Follow,
+		CertNames:     o.CertNames,
+		Tags:          o.Tags,
+		Confirm:       o.Confirm,
+	}
+}
+
+// Pipe events.
+const (
+	pipeOpen  = "open"
+	pipeDone  = "done"
+	pipeError = "error"
+)
+
+// pipeEvent is a line of output of "pscanner pipe".
+type pipeEvent struct {
+	ID    string `json:"id"`
+	Event string `json:"event"`
+	*openPort
+	Report *Report `json:"report,omitempty"`
+	Error  string  `json:"error,omitempty"`
+}
+
+// maxJobLine bounds a line of pipe input.
+const maxJobLine = 1 << 20
+
+// servePipe runs the jobs read from in, at most parallel at once, and
+// writes their events to out. It returns when in ends and the jobs have
+// finished; cancelling ctx cancels the jobs and stops reading.
+func servePipe(ctx context.Context, in io.Reader, out io.Writer, m *jobManager, parallel int) error {
+	var mu sync.Mutex
+	enc := json.NewEncoder(out)
+	emit := func(e pipeEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		_ = enc.Encode(e)
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	slots := make(chan struct{}, parallel)
+	sc := bufio.NewScanner(in)
+	sc.Buffer(make([]byte, 0, 64<<10), maxJobLine)
+	for line := 1; sc.Scan(); line++ {
+		text := bytes.TrimSpace(sc.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		var job pipeJob
+		dec := json.NewDecoder(bytes.NewReader(text))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&job); err != nil {
+			emit(pipeEvent{ID: strconv.Itoa(line), Event: pipeError, Error: "invalid job: " + err.Error()})
+			continue
+		}
+		if job.ID == "" {
+			job.ID = strconv.Itoa(line)
+		}
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			runPipeJob(ctx, m, &job, emit)
+		}()
+	}
+	if errors.Is(sc.Err(), bufio.ErrTooLong) {
+		return fmt.Errorf("a job is longer than %d bytes", maxJobLine)
+	}
+	return sc.Err()
+}
+
+// runPipeJob runs one job and reports it through emit.
+func runPipeJob(ctx context.Context, m *jobManager, job *pipeJob, emit func(pipeEvent)) {
+	if ctx.Err() != nil {
+		return
+	}
+	j, err := m.submit(job.request())
+	if err != nil {
+		emit(pipeEvent{ID: job.ID, Event: pipeError, Error: err.Error()})
+		return
+	}
+	// The job ends early when ctx is cancelled, and still reports.
+	stop := context.AfterFunc(ctx, j.cancel)
+	defer stop()
+	report, _ := j.follow(context.Background(), func(p openPort) error {
+		emit(pipeEvent{ID: job.ID, Event: pipeOpen, openPort: &p})
+		return nil
+	})
+	emit(pipeEvent{ID: job.ID, Event: pipeDone, Report: report})
+}
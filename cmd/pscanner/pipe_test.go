@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestServePipe(t *testing.T) {
+	port := localPort(t)
+	in := strings.Join([]string{
+		fmt.Sprintf(`{"id": "web", "host": "127.0.0.1", "ports": "%d", "options": {"timeout": "1s"}}`, port),
+		``,
+		`{"host": "127.0.0.1", "ports": "70000"}`,
+		`not json`,
+		`{"hosts": "127.0.0.1", "ports": "22"}`,
+	}, "\n")
+	var out bytes.Buffer
+	if err := servePipe(context.Background(), strings.NewReader(in), &out, newJobManager(&Config{}, nil, net.LookupHost), 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// pipeEvent embeds a pointer to an unexported type, which only
+	// encodes; read the events back with the port fields spelt out.
+	type event struct {
+		ID     string  `json:"id"`
+		Event  string  `json:"event"`
+		Port   int     `json:"port"`
+		Report *Report `json:"report"`
+		Error  string  `json:"error"`
+	}
+	events := make(map[string][]event)
+	sc := bufio.NewScanner(&out)
+	for sc.Scan() {
+		var e event
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatalf("bad output line %q: %v", sc.Text(), err)
+		}
+		events[e.ID] = append(events[e.ID], e)
+	}
+	web := events["web"]
+	if len(web) != 2 || web[0].Event != pipeOpen || web[0].Port != port ||
+		web[1].Event != pipeDone || web[1].Report == nil || len(web[1].Report.Hosts[0].Ports) != 1 {
+		t.Errorf("events of job web: %+v", web)
+	}
+	if e := events["3"]; len(e) != 1 || e[0].Event != pipeError {
+		t.Errorf("events of invalid job: %+v", e)
+	}
+	if e := events["4"]; len(e) != 1 || e[0].Event != pipeError || !strings.Contains(e[0].Error, "invalid job") {
+		t.Errorf("events of malformed line: %+v", e)
+	}
+	if e := events["5"]; len(e) != 1 || e[0].Event != pipeError || !strings.Contains(e[0].Error, `"hosts"`) {
+		t.Errorf("events of job with an unknown field: %+v", e)
+	}
+}
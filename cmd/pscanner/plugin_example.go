@@ -0,0 +1,8 @@
+//go:build pscanner_example
+
+package main
+
+// Builds with -tags pscanner_example carry the example probes of package
+// example, registered with package probe; a build's own probes are linked
+// in the same way, by a file like this one under a tag of its own.
+import _ "github.com/AlirezaNezami23/pscanner/probe/example"
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/AlirezaNezami23/pscanner/probe"
+)
+
+// The probes registered with package probe by the packages a build links
+// in, such as those plugin_*.go files import, join the --tcp probes, and
+// their docs the --tcp note.
+func init() {
+	var docs []string
+	for _, pr := range probe.Probes() {
+		if _, ok := tcpProbes[pr.Name]; ok {
+			panic(fmt.Sprintf("probe %s is a built-in --tcp probe", pr.Name))
+		}
+		tcpProbes[pr.Name] = registeredTCPProbe(pr)
+		doc := fmt.Sprintf("  %s  TCP %s", pr.Name, strings.ReplaceAll(formatPorts(pr.Ports), ",", ", "))
+		if pr.Doc != "" {
+			doc += ": " + strings.ReplaceAll(pr.Doc, "\n", "\n        ")
+		}
+		docs = append(docs, doc)
+	}
+	if docs != nil {
+		scanDoc.notes["tcp"] += "\n\nThis build also has:\n" + strings.Join(docs, "\n")
+	}
+}
+
+// registeredTCPProbe makes a --tcp probe of a registered one.
+func registeredTCPProbe(pr probe.Probe) *tcpProbe {
+	return &tcpProbe{
+		ports: pr.Ports,
+		probe: func(_ *scanPlan, conn net.Conn, h *HostResult, port int) (*TCPService, error) {
+			conn.SetDeadline(time.Now().Add(tcpProbeTimeout))
+			s, err := pr.Probe(conn, probe.Target{Host: h.Host, Port: port})
+			if s == nil {
+				return nil, err
+			}
+			return &TCPService{Details: s.Details, Issues: s.Issues}, err
+		},
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/AlirezaNezami23/pscanner/probe"
+)
+
+func TestRegisteredTCPProbe(t *testing.T) {
+	port := serveTCP(t, func(conn net.Conn) {
+		conn.Write([]byte("ACME 2.1\n"))
+	})
+	var target probe.Target
+	tp := registeredTCPProbe(probe.Probe{
+		Name:  "acme",
+		Ports: []int{port},
+		Probe: func(conn net.Conn, t probe.Target) (*probe.Service, error) {
+			target = t
+			buf := make([]byte, 64)
+			n, err := conn.Read(buf)
+			if err != nil {
+				return nil, err
+			}
+			return &probe.Service{Details: string(buf[:n-1]), Issues: []string{"no login"}}, nil
+		},
+	})
+	h := &HostResult{Host: "127.0.0.1"}
+	p := &scanPlan{timeout: time.Second}
+	s, err := p.tcpProbe(context.Background(), nil, h, port, tp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (&TCPService{Details: "ACME 2.1", Issues: []string{"no login"}}); !reflect.DeepEqual(s, want) {
+		t.Errorf("service = %+v, want %+v", s, want)
+	}
+	if target != (probe.Target{Host: "127.0.0.1", Port: port}) {
+		t.Errorf("target = %+v", target)
+	}
+}
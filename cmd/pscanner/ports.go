@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed data/top-ports.txt
+var topPortsData string
+
+// builtinGroups are port aliases usable in --ports as "@name". Groups defined
+// in the config file take precedence.
+var builtinGroups = map[string]string{
+	"web":        "80,81,443,591,3000,5000,8000,8008,8080,8081,8443,8888,9000,9443",
+	"db":         "1433,1521,3306,5432,5984,6379,7474,9042,9200,11211,27017",
+	"mail":       "25,110,143,465,587,993,995",
+	"remote":     "22,23,3389,5800,5900,5985,5986",
+	"file":       "20,21,69,139,445,873,2049",
+	"windows":    "88,135,139,389,445,636,3268,3389,5985,5986",
+	"ot":         "102,502,789,1911,1962,2404,4840,9600,18245,20000,44818",
+	"containers": "2375,2376,2379,6443,8080,8443,10250,10255",
+}
+
+// parsePorts expands a port spec into a sorted, de-duplicated list. groups
+// maps names usable as "@name" to their own specs.
+func parsePorts(spec string, groups map[string]string) ([]int, error) {
+	// Accepts formats like: "80,443,8080,21-25,@web,ssh"
+	set := make(map[int]struct{})
+	if err := addPorts(set, spec, groups, nil); err != nil {
+		return nil, err
+	}
+	ports := make([]int, 0, len(set))
+	for k := range set {
+		ports = append(ports, k)
+	}
+	sort.Ints(ports)
+	return ports, nil
+}
+
+// addPorts adds every port in spec to set. stack holds the groups currently
+// being expanded so that self-referencing groups are reported, not looped.
+func addPorts(set map[int]struct{}, spec string, groups map[string]string, stack []string) error {
+	parts := strings.Split(spec, ",")
+	for _, p := range parts {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if strings.HasPrefix(p, "@") {
+			name := p[1:]
+			for _, s := range stack {
+				if s == name {
+					return fmt.Errorf("port group @%s references itself", name)
+				}
+			}
+			g, ok := groups[name]
+			if !ok {
+				return fmt.Errorf("unknown port group: %s", p)
+			}
+			if err := addPorts(set, g, groups, append(stack, name)); err != nil {
+				return err
+			}
+		} else if isServiceName(p) {
+			n, ok := serviceByName(p)
+			if !ok {
+				return fmt.Errorf("unknown service name: %s", p)
+			}
+			set[n] = struct{}{}
+		} else if strings.Contains(p, "-") {
+			bounds := strings.SplitN(p, "-", 2)
+			if len(bounds) != 2 {
+				return fmt.Errorf("invalid range: %s", p)
+			}
+			start, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+			if err != nil {
+				return fmt.Errorf("invalid port: %s", bounds[0])
+			}
+			end, err := strconv.Atoi(strings.TrimSpace(bounds[1]))
+			if err != nil {
+				return fmt.Errorf("invalid port: %s", bounds[1])
+			}
+			if start < 1 || end < 1 || start > 65535 || end > 65535 || start > end {
+				return fmt.Errorf("invalid range bounds: %s", p)
+			}
+			for i := start; i <= end; i++ {
+				set[i] = struct{}{}
+			}
+		} else {
+			n, err := strconv.Atoi(p)
+			if err != nil {
+				return fmt.Errorf("invalid port: %s", p)
+			}
+			if n < 1 || n > 65535 {
+				return fmt.Errorf("port out of range: %d", n)
+			}
+			set[n] = struct{}{}
+		}
+	}
+	return nil
+}
+
+// isServiceName reports whether a port spec item is a service name rather
+// than a number or range. Names may contain dashes (e.g. "ms-wbt-server"),
+// so anything starting with a letter is treated as a name.
+func isServiceName(p string) bool {
+	c := p[0]
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// topPorts returns the n highest-ranked TCP ports from the embedded ranking,
+// sorted numerically.
+func topPorts(n int) ([]int, error) {
+	var ranked []int
+	sc := bufio.NewScanner(strings.NewReader(topPortsData))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, fmt.Errorf("bad entry in top-ports table: %q", line)
+		}
+		ranked = append(ranked, p)
+	}
+	if n < 1 || n > len(ranked) {
+		return nil, fmt.Errorf("top-ports must be between 1 and %d", len(ranked))
+	}
+	ports := append([]int(nil), ranked[:n]...)
+	sort.Ints(ports)
+	return ports, nil
+}
+
+// formatPorts renders a sorted port list compactly, collapsing consecutive
+// ports into ranges (e.g. "21-25,80,443").
+func formatPorts(ports []int) string {
+	var b strings.Builder
+	for i := 0; i < len(ports); {
+		j := i
+		for j+1 < len(ports) && ports[j+1] == ports[j]+1 {
+			j++
+		}
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		if j > i {
+			fmt.Fprintf(&b, "%d-%d", ports[i], ports[j])
+		} else {
+			fmt.Fprintf(&b, "%d", ports[i])
+		}
+		i = j + 1
+	}
+	return b.String()
+}
+
+// parsePortTimeouts reads a --port-timeout list such as "25:3s,1433:2s"
+// into the connect timeout of each port. An entry's ports may be a port,
+// a range, a service name or an @group, as in --ports; a later entry wins.
+func parsePortTimeouts(spec string, groups map[string]string) (map[int]time.Duration, error) {
+	timeouts := make(map[int]time.Duration)
+	for _, entry := range strings.Split(spec, ",") {
+		ports, d, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok {
+			return nil, fmt.Errorf("%q: expected ports:timeout, e.g. 25:3s", entry)
+		}
+		timeout, err := parseDuration(d)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %v", entry, err)
+		}
+		if timeout == 0 {
+			return nil, fmt.Errorf("%q: timeout must be > 0", entry)
+		}
+		list, err := parsePorts(ports, groups)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %v", entry, err)
+		}
+		for _, port := range list {
+			timeouts[port] = timeout
+		}
+	}
+	return timeouts, nil
+}
+
+// formatPortTimeouts renders --port-timeout overrides by port, collapsing
+// consecutive ports with the same timeout into ranges ("25:3s,1433-1434:2s").
+func formatPortTimeouts(timeouts map[int]time.Duration) string {
+	ports := slices.Sorted(maps.Keys(timeouts))
+	var list []string
+	for i := 0; i < len(ports); {
+		j := i
+		for j+1 < len(ports) && ports[j+1] == ports[j]+1 && timeouts[ports[j+1]] == timeouts[ports[i]] {
+			j++
+		}
+		list = append(list, formatPorts(ports[i:j+1])+":"+timeouts[ports[i]].String())
+		i = j + 1
+	}
+	return strings.Join(list, ",")
+}
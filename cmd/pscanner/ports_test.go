@@ -0,0 +1,144 @@
+package main
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePorts(t *testing.T) {
+	groups := map[string]string{
+		"web":   "80,443",
+		"mixed": "@web,8080-8081",
+		"self":  "22,@self",
+		"a":     "@b",
+		"b":     "@a",
+		"bad":   "@missing",
+	}
+	tests := []struct {
+		spec    string
+		want    []int
+		wantErr string
+	}{
+		{spec: "80,443,8080,21-25", want: []int{21, 22, 23, 24, 25, 80, 443, 8080}},
+		{spec: " 443 , 80,,80 ", want: []int{80, 443}},
+		{spec: "80-90", want: []int{80, 81, 82, 83, 84, 85, 86, 87, 88, 89, 90}},
+		{spec: "@web", want: []int{80, 443}},
+		{spec: "@mixed,22", want: []int{22, 80, 443, 8080, 8081}},
+		{spec: "ms-wbt-server", want: []int{3389}},
+		{spec: "ssh,http,https,postgres", want: []int{22, 80, 443, 5432}},
+		{spec: "SSH,rdp,smb", want: []int{22, 445, 3389}},
+		{spec: "@self", wantErr: "@self references itself"},
+		{spec: "@a", wantErr: "@a references itself"},
+		{spec: "@bad", wantErr: "unknown port group: @missing"},
+		{spec: "@nope", wantErr: "unknown port group: @nope"},
+		{spec: "no-such-service", wantErr: "unknown service name: no-such-service"},
+		{spec: "0", wantErr: "port out of range"},
+		{spec: "65536", wantErr: "port out of range"},
+		{spec: "90-80", wantErr: "invalid range bounds"},
+		{spec: "1-70000", wantErr: "invalid range bounds"},
+		{spec: "8-x", wantErr: "invalid port"},
+	}
+	for _, tt := range tests {
+		got, err := parsePorts(tt.spec, groups)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parsePorts(%q) error = %v, want %q", tt.spec, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parsePorts(%q) unexpected error: %v", tt.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parsePorts(%q) = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestIsServiceName(t *testing.T) {
+	tests := map[string]bool{
+		"ssh":           true,
+		"ms-wbt-server": true,
+		"HTTP":          true,
+		"80":            false,
+		"80-90":         false,
+		"@web":          false,
+	}
+	for in, want := range tests {
+		if got := isServiceName(in); got != want {
+			t.Errorf("isServiceName(%q) = %v, want %v", in, got, want)
+		}
+	}
+}
+
+func TestFormatPorts(t *testing.T) {
+	tests := []struct {
+		ports []int
+		want  string
+	}{
+		{nil, ""},
+		{[]int{80}, "80"},
+		{[]int{21, 22, 23, 25, 80, 443, 444}, "21-23,25,80,443-444"},
+		{[]int{1, 3, 5}, "1,3,5"},
+		{[]int{65534, 65535}, "65534-65535"},
+	}
+	for _, tt := range tests {
+		if got := formatPorts(tt.ports); got != tt.want {
+			t.Errorf("formatPorts(%v) = %q, want %q", tt.ports, got, tt.want)
+		}
+	}
+}
+
+func TestTopPorts(t *testing.T) {
+	ports, err := topPorts(1059)
+	if err != nil {
+		t.Fatal(err)
+	}
+	top100, err := topPorts(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []int{21, 22, 80, 443, 3389, 49157} {
+		if !slices.Contains(top100, p) {
+			t.Errorf("top 100 ports lack %d", p)
+		}
+	}
+	if top1000, err := topPorts(1000); err != nil || slices.Contains(top1000, 6379) || !slices.Contains(top1000, 65389) {
+		t.Errorf("top 1000 ports = %v, %v; want Nmap's set, without 6379", top1000, err)
+	}
+	seen := make(map[int]bool)
+	for _, p := range ports {
+		if seen[p] {
+			t.Fatalf("duplicate port %d in top-ports table", p)
+		}
+		seen[p] = true
+	}
+	for _, n := range []int{0, 1060} {
+		if _, err := topPorts(n); err == nil {
+			t.Errorf("topPorts(%d) succeeded, want error", n)
+		}
+	}
+}
+
+func TestParsePortTimeouts(t *testing.T) {
+	got, err := parsePortTimeouts("25:3s, 1433-1434:2000,smtp:4s,@web:1s", map[string]string{"web": "80,443"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]time.Duration{25: 4 * time.Second, 1433: 2 * time.Second, 1434: 2 * time.Second, 80: time.Second, 443: time.Second}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parsePortTimeouts = %v, want %v", got, want)
+	}
+	if s := formatPortTimeouts(got); s != "25:4s,80:1s,443:1s,1433-1434:2s" {
+		t.Errorf("formatPortTimeouts = %q", s)
+	}
+	for _, bad := range []string{"25", "25:", "25:fast", "25:0s", "x:3s", "25:3s,"} {
+		if _, err := parsePortTimeouts(bad, nil); err == nil {
+			t.Errorf("parsePortTimeouts(%q) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// Categories of ProbeError beyond those of dialFailure.
+const (
+	probeErrProxy   = "proxy"   // the proxy could not carry out the probe
+	probeErrSkipped = "skipped" // not made, for --host-timeout or a host that appears down
+	probeErrLookup  = "lookup"  // not made, as the hostname could not be looked up
+)
+
+// ProbeError is a line of --errors-file: a probe that did not find its
+// port open, or one that was not made.
+type ProbeError struct {
+	Time     time.Time `json:"time"`
+	Host     string    `json:"host"`
+	Family   string    `json:"family,omitempty"`
+	Port     int       `json:"port"`
+	Category string    `json:"category"`
+	Message  string    `json:"message"`
+	// Attempt numbers the probes of the port from 1, for --retries; it is
+	// 0 for probes not made.
+	Attempt int `json:"attempt"`
+}
+
+// errorLog writes the ProbeErrors of a scan to --errors-file, one JSON
+// object per line. A nil *errorLog records nothing.
+type errorLog struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error // the first write error
+}
+
+func newErrorLog(w io.Writer) *errorLog {
+	return &errorLog{enc: json.NewEncoder(w)}
+}
+
+func (l *errorLog) add(e ProbeError) {
+	if l == nil {
+		return
+	}
+	e.Time = time.Now().UTC()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.enc.Encode(e); err != nil && l.err == nil {
+		l.err = err
+	}
+}
+
+// failed records attempt of j, which ended in err. Probes stopped by
+// cancelling the scan are not recorded.
+func (l *errorLog) failed(ctx context.Context, j job, err error) {
+	if l == nil || ctx.Err() != nil {
+		return
+	}
+	category := classifyDial(err).String()
+	var perr *proxyError
+	if errors.As(err, &perr) {
+		category = probeErrProxy
+	}
+	l.add(ProbeError{Host: j.host, Family: j.family, Port: j.port, Category: category, Message: err.Error(), Attempt: j.attempts})
+}
+
+// skipped records that j was not made, and why.
+func (l *errorLog) skipped(j job, category, why string) {
+	l.add(ProbeError{Host: j.host, Family: j.family, Port: j.port, Category: category, Message: why})
+}
+
+// writeErr returns the first error writing the log.
+func (l *errorLog) writeErr() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readErrorLog parses the lines of an --errors-file, in host and port
+// order.
+func readErrorLog(t *testing.T, b *bytes.Buffer) []ProbeError {
+	t.Helper()
+	var list []ProbeError
+	for _, line := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+		var e ProbeError
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("%q: %v", line, err)
+		}
+		if e.Time.IsZero() {
+			t.Errorf("%q has no time", line)
+		}
+		e.Time = time.Time{}
+		list = append(list, e)
+	}
+	slices.SortFunc(list, func(a, b ProbeError) int {
+		if c := strings.Compare(a.Host, b.Host); c != 0 {
+			return c
+		}
+		return a.Port - b.Port
+	})
+	return list
+}
+
+func TestErrorsFile(t *testing.T) {
+	open := serveTCP(t, func(conn net.Conn) {})
+	closed := closedPort(t)
+	dns := newDNSCache("")
+	dns.lookup = func(context.Context, string) ([]netip.Addr, time.Duration, error) {
+		return nil, 0, errors.New("no such host")
+	}
+	var b bytes.Buffer
+	targets, _ := parseTargets("127.0.0.1,gone.example")
+	p := &scanPlan{targets: targets, numTargets: 2, ports: []int{open, closed}, workers: 2, timeout: time.Second, dns: dns, errLog: newErrorLog(&b)}
+	p.run(context.Background(), scanHooks{})
+	got := readErrorLog(t, &b)
+	if len(got) != 3 {
+		t.Fatalf("errors = %+v, want 3", got)
+	}
+	refused := got[0]
+	if refused.Host != "127.0.0.1" || refused.Port != closed || refused.Category != "refused" || refused.Attempt != 1 || !strings.Contains(refused.Message, "connection refused") {
+		t.Errorf("closed port = %+v", refused)
+	}
+	for _, e := range got[1:] {
+		if e.Host != "gone.example" || e.Category != probeErrLookup || e.Message != "no such host" || e.Attempt != 0 {
+			t.Errorf("unresolved host = %+v", e)
+		}
+	}
+
+	// Once the host timeout runs out the other probes are skipped.
+	b.Reset()
+	targets, _ = parseTargets("127.0.0.1")
+	p = &scanPlan{targets: targets, numTargets: 1, ports: []int{closed, open}, workers: 1, timeout: time.Second, hostTimeout: time.Nanosecond, errLog: newErrorLog(&b)}
+	p.run(context.Background(), scanHooks{})
+	want := ProbeError{Host: "127.0.0.1", Port: open, Category: probeErrSkipped, Message: "host timeout reached"}
+	got = readErrorLog(t, &b)
+	if len(got) != 2 || !slices.Contains(got, want) || !slices.ContainsFunc(got, func(e ProbeError) bool { return e.Port == closed && e.Category == "refused" }) {
+		t.Errorf("errors with a host timeout = %+v, want the closed port refused and %+v", got, want)
+	}
+}
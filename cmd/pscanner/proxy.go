@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// contextDialer opens connections; net.Dialer and the proxies implement it.
+type contextDialer interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// proxyError is a probe that failed at the proxy rather than at the target,
+// so it says nothing about the state of the port.
+type proxyError struct {
+	proxy string
+	err   error
+}
+
+func (e *proxyError) Error() string { return "proxy " + e.proxy + ": " + e.err.Error() }
+func (e *proxyError) Unwrap() error { return e.err }
+
+// errPortNotOpen is returned by proxies that report the target connection
+// failed: refused, unreachable or timed out behind the proxy.
+var errPortNotOpen = errors.New("port not open")
+
+// parseProxy returns the dialer for a --proxy value: one proxy URL, or a
+// comma-separated chain in which each proxy is reached through the one
+// before it, as with proxychains. The first proxy is dialed with first, or
+// directly when it is nil.
+func parseProxy(spec string, first contextDialer) (contextDialer, error) {
+	d := first
+	if d == nil {
+		d = &net.Dialer{}
+	}
+	for _, hop := range strings.Split(spec, ",") {
+		u, err := url.Parse(strings.TrimSpace(hop))
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid proxy %q (want socks5:// or http://host:port)", hop)
+		}
+		switch u.Scheme {
+		case "socks5", "socks5h":
+			addr := u.Host
+			if u.Port() == "" {
+				addr = net.JoinHostPort(u.Hostname(), "1080")
+			}
+			d = &socks5Proxy{addr: addr, user: u.User, forward: d}
+		case "http", "https":
+			addr := u.Host
+			if u.Port() == "" {
+				addr = net.JoinHostPort(u.Hostname(), map[string]string{"http": "80", "https": "443"}[u.Scheme])
+			}
+			d = &httpProxy{addr: addr, tls: u.Scheme == "https", user: u.User, forward: d}
+		default:
+			return nil, fmt.Errorf("unsupported proxy %q (want socks5://, http:// or https://)", hop)
+		}
+	}
+	return d, nil
+}
+
+// redactProxy removes the passwords from a --proxy value for display.
+func redactProxy(spec string) string {
+	hops := strings.Split(spec, ",")
+	for i, hop := range hops {
+		if u, err := url.Parse(strings.TrimSpace(hop)); err == nil {
+			hops[i] = u.Redacted()
+		}
+	}
+	return strings.Join(hops, ",")
+}
+
+// socks5Proxy dials through a SOCKS5 server (RFC 1928), with optional
+// username/password authentication (RFC 1929). Host names are sent to the
+// proxy unresolved, so they resolve on the far side, as needed for Tor and
+// for internal names behind a pivot.
+type socks5Proxy struct {
+	addr    string
+	user    *url.Userinfo
+	forward contextDialer
+}
+
+// SOCKS5 CONNECT replies (RFC 1928 section 6) that mean the proxy reached
+// the target's network and the port is not open.
+var socks5NotOpen = map[byte]string{
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "TTL expired",
+}
+
+func (p *socks5Proxy) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	fail := func(err error) error { return &proxyError{p.addr, err} }
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, err
+	}
+	// Not reaching the proxy, even when an earlier hop of a chain reports
+	// its port as closed, is a proxy failure.
+	conn, err := p.forward.DialContext(ctx, "tcp", p.addr)
+	if err != nil {
+		return nil, fail(err)
+	}
+	if d, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(d)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+
+	if err := p.handshake(conn); err != nil {
+		conn.Close()
+		return nil, fail(err)
+	}
+
+	req := []byte{5, 1, 0}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		if ip.Is4() {
+			req = append(req, 1)
+		} else {
+			req = append(req, 4)
+		}
+		req = append(req, ip.AsSlice()...)
+	} else {
+		if len(host) > 255 {
+			conn.Close()
+			return nil, fmt.Errorf("host name %q too long for SOCKS5", host)
+		}
+		req = append(req, 3, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		conn.Close()
+		return nil, fail(err)
+	}
+
+	// Once the request is out, a timeout is the target not answering.
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		conn.Close()
+		if isTimeout(err) {
+			return nil, errPortNotOpen
+		}
+		return nil, fail(err)
+	}
+	if reply[0] != 5 {
+		conn.Close()
+		return nil, fail(fmt.Errorf("unexpected reply version %d", reply[0]))
+	}
+	if reply[1] != 0 {
+		conn.Close()
+		if reason, ok := socks5NotOpen[reply[1]]; ok {
+			return nil, fmt.Errorf("%w: %s", errPortNotOpen, reason)
+		}
+		return nil, fail(fmt.Errorf("CONNECT failed with code %d", reply[1]))
+	}
+	var skip int
+	switch reply[3] {
+	case 1:
+		skip = 4
+	case 4:
+		skip = 16
+	case 3:
+		var n [1]byte
+		if _, err := io.ReadFull(conn, n[:]); err != nil {
+			conn.Close()
+			return nil, fail(err)
+		}
+		skip = int(n[0])
+	default:
+		conn.Close()
+		return nil, fail(fmt.Errorf("unexpected address type %d", reply[3]))
+	}
+	if _, err := io.ReadFull(conn, make([]byte, skip+2)); err != nil {
+		conn.Close()
+		return nil, fail(err)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// handshake negotiates the authentication method.
+func (p *socks5Proxy) handshake(conn net.Conn) error {
+	methods := []byte{5, 1, 0}
+	if p.user != nil {
+		methods = []byte{5, 2, 0, 2}
+	}
+	if _, err := conn.Write(methods); err != nil {
+		return err
+	}
+	resp := make([]byte, 2)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return err
+	}
+	if resp[0] != 5 {
+		return fmt.Errorf("not a SOCKS5 server (version %d)", resp[0])
+	}
+	switch resp[1] {
+	case 0:
+		return nil
+	case 2:
+		if p.user == nil {
+			return errors.New("server wants a username and password")
+		}
+		name := p.user.Username()
+		pw, _ := p.user.Password()
+		if len(name) > 255 || len(pw) > 255 {
+			return errors.New("username or password too long")
+		}
+		auth := append([]byte{1, byte(len(name))}, name...)
+		auth = append(append(auth, byte(len(pw))), pw...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, resp); err != nil {
+			return err
+		}
+		if resp[1] != 0 {
+			return errors.New("authentication failed")
+		}
+		return nil
+	}
+	return errors.New("no acceptable authentication method")
+}
+
+// httpProxy dials through an HTTP proxy with the CONNECT method (RFC 9110
+// section 9.3.6), as allowed out of most corporate networks. https:// proxies
+// are spoken to over TLS. Credentials are sent as Basic
+// Proxy-Authorization.
+type httpProxy struct {
+	addr    string
+	tls     bool
+	user    *url.Userinfo
+	forward contextDialer
+}
+
+// HTTP CONNECT statuses that mean the proxy tried the target and could not
+// connect. Squid, for one, answers 503 for a refused connection and 504 when
+// it times out; other statuses, such as 403 for a port the proxy's rules
+// forbid, are failures of the proxy.
+var httpNotOpen = map[int]bool{
+	http.StatusBadGateway:         true,
+	http.StatusServiceUnavailable: true,
+	http.StatusGatewayTimeout:     true,
+}
+
+func (p *httpProxy) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	fail := func(err error) error { return &proxyError{p.addr, err} }
+	conn, err := p.forward.DialContext(ctx, "tcp", p.addr)
+	if err != nil {
+		return nil, fail(err)
+	}
+	if d, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(d)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+
+	if p.tls {
+		host, _, _ := net.SplitHostPort(p.addr)
+		tc := tls.Client(conn, &tls.Config{ServerName: host})
+		if err := tc.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fail(err)
+		}
+		conn = tc
+	}
+
+	req := "CONNECT " + addr + " HTTP/1.1\r\nHost: " + addr + "\r\n"
+	if p.user != nil {
+		pw, _ := p.user.Password()
+		req += "Proxy-Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(p.user.Username()+":"+pw)) + "\r\n"
+	}
+	if _, err := io.WriteString(conn, req+"\r\n"); err != nil {
+		conn.Close()
+		return nil, fail(err)
+	}
+
+	// Once the request is out, a timeout is the target not answering.
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		conn.Close()
+		if isTimeout(err) {
+			return nil, errPortNotOpen
+		}
+		return nil, fail(err)
+	}
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case httpNotOpen[resp.StatusCode]:
+		conn.Close()
+		return nil, fmt.Errorf("%w: %s", errPortNotOpen, resp.Status)
+	case resp.StatusCode == http.StatusProxyAuthRequired && p.user == nil:
+		conn.Close()
+		return nil, fail(errors.New("server wants a username and password"))
+	default:
+		conn.Close()
+		return nil, fail(fmt.Errorf("CONNECT failed: %s", resp.Status))
+	}
+	conn.SetDeadline(time.Time{})
+	if br.Buffered() > 0 {
+		// The target spoke first and its bytes were read with the response.
+		return &bufferedConn{conn, br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is a connection whose first bytes are in a bufio.Reader.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) { return c.r.Read(b) }
+
+// proxyFailures collects the probes a proxy failed, for a warning once the
+// scan is done; a dead proxy otherwise looks like a host with no open ports.
+type proxyFailures struct {
+	mu    sync.Mutex
+	n     int
+	first error
+}
+
+func (f *proxyFailures) add(host string, port int, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.n == 0 {
+		f.first = err
+	}
+	f.n++
+}
+
+func (f *proxyFailures) warn() {
+	if f.n > 0 {
+		slog.Warn("probes failed at the proxy; their ports' states are unknown", "probes", f.n, "first_err", f.first)
+	}
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
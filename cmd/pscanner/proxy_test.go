@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSOCKS5 is a SOCKS5 server that requires user "u", password "p" and
+// connects to the requested address itself. Requests for the host name
+// "denied.test" are refused by rule. It relays the connection and records
+// the hosts it was asked for.
+type fakeSOCKS5 struct {
+	addr string
+
+	mu    sync.Mutex
+	hosts []string
+}
+
+func newFakeSOCKS5(t *testing.T) *fakeSOCKS5 {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	s := &fakeSOCKS5{addr: l.Addr().String()}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSOCKS5) serve(c net.Conn) {
+	defer c.Close()
+	buf := make([]byte, 512)
+	if _, err := io.ReadFull(c, buf[:2]); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(c, buf[:buf[1]]); err != nil {
+		return
+	}
+	c.Write([]byte{5, 2})
+	io.ReadFull(c, buf[:2])
+	user := make([]byte, buf[1])
+	io.ReadFull(c, user)
+	io.ReadFull(c, buf[:1])
+	pw := make([]byte, buf[0])
+	io.ReadFull(c, pw)
+	if string(user) != "u" || string(pw) != "p" {
+		c.Write([]byte{1, 1})
+		return
+	}
+	c.Write([]byte{1, 0})
+
+	if _, err := io.ReadFull(c, buf[:4]); err != nil {
+		return
+	}
+	var host string
+	switch buf[3] {
+	case 1:
+		io.ReadFull(c, buf[:4])
+		host = netip.AddrFrom4([4]byte(buf[:4])).String()
+	case 3:
+		io.ReadFull(c, buf[:1])
+		name := make([]byte, buf[0])
+		io.ReadFull(c, name)
+		host = string(name)
+	}
+	io.ReadFull(c, buf[:2])
+	port := binary.BigEndian.Uint16(buf[:2])
+	s.mu.Lock()
+	s.hosts = append(s.hosts, host)
+	s.mu.Unlock()
+
+	reply := func(code byte) { c.Write([]byte{5, code, 0, 1, 0, 0, 0, 0, 0, 0}) }
+	if host == "denied.test" {
+		reply(2)
+		return
+	}
+	if host == "localhost" {
+		host = "127.0.0.1"
+	}
+	target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+	if err != nil {
+		reply(5)
+		return
+	}
+	defer target.Close()
+	reply(0)
+	go io.Copy(target, c)
+	io.Copy(c, target)
+}
+
+func TestSOCKS5Scan(t *testing.T) {
+	open := localPort(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	s := newFakeSOCKS5(t)
+	proxy, err := parseProxy("socks5://u:p@"+s.addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets, _ := parseTargets("127.0.0.1,localhost,denied.test")
+	plan := &scanPlan{targets: targets, numTargets: 3, ports: []int{min(open, closed), max(open, closed)}, workers: 2, timeout: 2 * time.Second, proxy: proxy}
+	var pf proxyFailures
+	hosts := plan.run(context.Background(), scanHooks{failed: pf.add})
+
+	for _, h := range hosts[:2] {
+		if len(h.Ports) != 1 || h.Ports[0].Port != open || h.ProbeErrors != 0 {
+			t.Errorf("%s: ports %v, %d probe errors; want only %d open", h.Host, h.Ports, h.ProbeErrors, open)
+		}
+	}
+	if h := hosts[2]; len(h.Ports) != 0 || h.ProbeErrors != 2 {
+		t.Errorf("denied host: ports %v, %d probe errors; want 2 errors", h.Ports, h.ProbeErrors)
+	}
+	if pf.n != 2 || !strings.Contains(pf.first.Error(), "code 2") {
+		t.Errorf("failures %d, first %v", pf.n, pf.first)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !strings.Contains(strings.Join(s.hosts, ","), "localhost") {
+		t.Errorf("proxy was asked for %v; host names should be sent unresolved", s.hosts)
+	}
+}
+
+func TestSOCKS5Failures(t *testing.T) {
+	s := newFakeSOCKS5(t)
+	targets, _ := parseTargets("127.0.0.1")
+	for _, spec := range []string{"socks5://u:wrong@" + s.addr, "socks5://" + s.addr, "socks5://127.0.0.1:1"} {
+		proxy, err := parseProxy(spec, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan := &scanPlan{targets: targets, numTargets: 1, ports: []int{22, 80}, workers: 2, timeout: time.Second, proxy: proxy}
+		if h := plan.run(context.Background(), scanHooks{})[0]; h.ProbeErrors != 2 {
+			t.Errorf("%s: %d probe errors, want 2", spec, h.ProbeErrors)
+		}
+	}
+
+	for _, spec := range []string{"127.0.0.1:9050", "ftp://proxy:21", "socks5://"} {
+		if _, err := parseProxy(spec, nil); err == nil {
+			t.Errorf("parseProxy(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestProxyChain(t *testing.T) {
+	open := localPort(t)
+	a, b := newFakeSOCKS5(t), newFakeSOCKS5(t)
+	s := "socks5://u:p@" + a.addr + ", socks5://u:p@" + b.addr
+	proxy, err := parseProxy(s, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := redactProxy(s); strings.Contains(got, ":p@") {
+		t.Errorf("redactProxy = %q", got)
+	}
+	targets, _ := parseTargets("localhost")
+	plan := &scanPlan{targets: targets, numTargets: 1, ports: []int{open}, workers: 1, timeout: 2 * time.Second, proxy: proxy}
+	if h := plan.run(context.Background(), scanHooks{})[0]; len(h.Ports) != 1 || h.ProbeErrors != 0 {
+		t.Errorf("through the chain: ports %v, %d probe errors", h.Ports, h.ProbeErrors)
+	}
+	a.mu.Lock()
+	b.mu.Lock()
+	if len(a.hosts) != 1 || a.hosts[0] != "127.0.0.1" || len(b.hosts) != 1 || b.hosts[0] != "localhost" {
+		t.Errorf("first hop asked for %v, second for %v", a.hosts, b.hosts)
+	}
+	a.mu.Unlock()
+	b.mu.Unlock()
+
+	// The second hop being down is a proxy failure, not a closed port.
+	proxy, _ = parseProxy("socks5://u:p@"+a.addr+",socks5://127.0.0.1:1", nil)
+	plan.proxy = proxy
+	if h := plan.run(context.Background(), scanHooks{})[0]; h.ProbeErrors != 1 {
+		t.Errorf("second hop down: %d probe errors, want 1", h.ProbeErrors)
+	}
+}
+
+// newFakeHTTPProxy starts an HTTP CONNECT proxy that requires user "u",
+// password "p". It answers 403 for "denied.test" and, like Squid, 503 when
+// the target refuses the connection.
+func newFakeHTTPProxy(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	auth := "Basic " + base64.StdEncoding.EncodeToString([]byte("u:p"))
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				req, err := http.ReadRequest(bufio.NewReader(c))
+				if err != nil {
+					return
+				}
+				reply := func(status string) { io.WriteString(c, "HTTP/1.1 "+status+"\r\n\r\n") }
+				switch {
+				case req.Method != http.MethodConnect:
+					reply("405 Method Not Allowed")
+					return
+				case req.Header.Get("Proxy-Authorization") != auth:
+					reply("407 Proxy Authentication Required")
+					return
+				case strings.HasPrefix(req.Host, "denied.test:"):
+					reply("403 Forbidden")
+					return
+				}
+				target, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					reply("503 Service Unavailable")
+					return
+				}
+				defer target.Close()
+				reply("200 Connection established")
+				go io.Copy(target, c)
+				io.Copy(c, target)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestHTTPProxyScan(t *testing.T) {
+	open := localPort(t)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	closed := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	addr := newFakeHTTPProxy(t)
+	proxy, err := parseProxy("http://u:p@"+addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets, _ := parseTargets("127.0.0.1,denied.test")
+	plan := &scanPlan{targets: targets, numTargets: 2, ports: []int{min(open, closed), max(open, closed)}, workers: 2, timeout: 2 * time.Second, proxy: proxy}
+	var pf proxyFailures
+	hosts := plan.run(context.Background(), scanHooks{failed: pf.add})
+	if h := hosts[0]; len(h.Ports) != 1 || h.Ports[0].Port != open || h.ProbeErrors != 0 {
+		t.Errorf("127.0.0.1: ports %v, %d probe errors; want only %d open", h.Ports, h.ProbeErrors, open)
+	}
+	if h := hosts[1]; len(h.Ports) != 0 || h.ProbeErrors != 2 || !strings.Contains(pf.first.Error(), "403") {
+		t.Errorf("denied host: ports %v, %d probe errors, first %v; want 2 errors", h.Ports, h.ProbeErrors, pf.first)
+	}
+
+	// Without credentials every probe fails at the proxy.
+	plan.proxy, _ = parseProxy("http://"+addr, nil)
+	plan.targets, _ = parseTargets("127.0.0.1")
+	plan.numTargets = 1
+	if h := plan.run(context.Background(), scanHooks{})[0]; len(h.Ports) != 0 || h.ProbeErrors != 2 {
+		t.Errorf("no credentials: ports %v, %d probe errors; want 2 errors", h.Ports, h.ProbeErrors)
+	}
+
+	// The HTTP proxy can be the hop before a SOCKS5 one.
+	s := newFakeSOCKS5(t)
+	plan.proxy, _ = parseProxy("http://u:p@"+addr+",socks5://u:p@"+s.addr, nil)
+	plan.ports = []int{open}
+	if h := plan.run(context.Background(), scanHooks{})[0]; len(h.Ports) != 1 || h.ProbeErrors != 0 {
+		t.Errorf("through the chain: ports %v, %d probe errors", h.Ports, h.ProbeErrors)
+	}
+}
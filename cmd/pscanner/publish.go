@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+)
+
+// resultMessage is one published message: a port found open, or the
+// summary that closes a scan. Consumers tell them apart by Type.
+type resultMessage struct {
+	Type     string            `json:"type"` // "port" or "summary"
+	ScanID   string            `json:"scan_id"`
+	Schedule string            `json:"schedule,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+	Time     time.Time         `json:"time"`
+	Host     string            `json:"host,omitempty"`
+	Port     *PortResult       `json:"result,omitempty"`
+	Service  string            `json:"service,omitempty"`
+	Summary  *scanSummary      `json:"summary,omitempty"`
+}
+
+// pubMessage is a message ready to send. Kafka partitions by key, so the
+// results of a host stay in order.
+type pubMessage struct {
+	key   string
+	value []byte
+}
+
+// resultMessages turns a report into one message per open port followed
+// by a summary. Scans run from the command line have no ID, so one is made
+// up to group their messages.
+func resultMessages(r *Report) ([]pubMessage, error) {
+	id := r.ID
+	if id == "" {
+		id = newJobID()
+	}
+	var msgs []pubMessage
+	add := func(key string, m resultMessage) error {
+		b, err := json.Marshal(m)
+		msgs = append(msgs, pubMessage{key, b})
+		return err
+	}
+	for _, h := range r.Hosts {
+		for _, p := range h.Ports {
+			m := resultMessage{Type: "port", ScanID: id, Schedule: r.Schedule, Tags: r.Tags, Time: r.FinishedAt, Host: h.Host, Port: &p, Service: p.service()}
+			if err := add(h.Host, m); err != nil {
+				return nil, err
+			}
+		}
+	}
+	s := reportSummary(r)
+	s.ID = id
+	if err := add(id, resultMessage{Type: "summary", ScanID: id, Schedule: r.Schedule, Tags: r.Tags, Time: r.FinishedAt, Summary: &s}); err != nil {
+		return nil, err
+	}
+	return msgs, nil
+}
+
+// broker is a message system results are published to.
+type broker interface {
+	publish(ctx context.Context, msgs []pubMessage) error
+}
+
+// publisher sends the results of every finished run to a broker.
+type publisher struct {
+	name string
+	broker
+}
+
+// newPublisher connects to the broker named by a --publish URL:
+// kafka://host:port[,host:port...]/topic, with kafka+tls:// for TLS and
+// user:password@ for SASL/PLAIN, or nats://[user:password@]host:port/subject.
+func newPublisher(raw string) (*publisher, error) {
+	scheme, rest, ok := strings.Cut(raw, "://")
+	if !ok {
+		return nil, fmt.Errorf("invalid --publish %q (want kafka://broker/topic or nats://server/subject)", raw)
+	}
+	userinfo := ""
+	if at := strings.LastIndex(rest, "@"); at >= 0 {
+		userinfo, rest = rest[:at], rest[at+1:]
+	}
+	hosts, dest, _ := strings.Cut(rest, "/")
+	if hosts == "" || dest == "" || strings.Contains(dest, "/") {
+		return nil, fmt.Errorf("invalid --publish %q: want a server and a single topic or subject", raw)
+	}
+	user, pw, _ := strings.Cut(userinfo, ":")
+
+	switch scheme {
+	case "kafka", "kafka+tls":
+		transport := &kafka.Transport{}
+		if scheme == "kafka+tls" {
+			transport.TLS = &tls.Config{}
+		}
+		if user != "" {
+			transport.SASL = plain.Mechanism{Username: user, Password: pw}
+		}
+		w := &kafka.Writer{
+			Addr:         kafka.TCP(strings.Split(hosts, ",")...),
+			Topic:        dest,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+			BatchTimeout: 10 * time.Millisecond,
+			Transport:    transport,
+		}
+		return &publisher{"kafka " + dest, kafkaBroker{w}}, nil
+	case "nats":
+		opts := []nats.Option{nats.Name("pscanner"), nats.MaxReconnects(-1)}
+		if user != "" {
+			opts = append(opts, nats.UserInfo(user, pw))
+		}
+		nc, err := nats.Connect("nats://"+hosts, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("nats %s: %v", hosts, err)
+		}
+		return &publisher{"nats " + dest, natsBroker{nc, dest}}, nil
+	}
+	return nil, fmt.Errorf("unknown --publish scheme %q (want kafka, kafka+tls or nats)", scheme)
+}
+
+// notify publishes run. Failures are logged and do not stop the scans.
+func (p *publisher) notify(run scanRun) {
+	msgs, err := resultMessages(run.Report)
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		err = p.publish(ctx, msgs)
+		cancel()
+	}
+	if err != nil {
+		slog.Error("publishing failed", "to", p.name, "err", err)
+	}
+}
+
+type kafkaBroker struct{ w *kafka.Writer }
+
+func (b kafkaBroker) publish(ctx context.Context, msgs []pubMessage) error {
+	km := make([]kafka.Message, len(msgs))
+	for i, m := range msgs {
+		km[i] = kafka.Message{Key: []byte(m.key), Value: m.value}
+	}
+	return b.w.WriteMessages(ctx, km...)
+}
+
+type natsBroker struct {
+	nc      *nats.Conn
+	subject string
+}
+
+// publish sends the messages and waits until the server has them; NATS
+// publishing is otherwise fire-and-forget from a local buffer.
+func (b natsBroker) publish(ctx context.Context, msgs []pubMessage) error {
+	for _, m := range msgs {
+		if err := b.nc.Publish(b.subject, m.value); err != nil {
+			return err
+		}
+	}
+	return b.nc.FlushWithContext(ctx)
+}
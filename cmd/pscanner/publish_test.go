@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func TestResultMessages(t *testing.T) {
+	r := testReport("22,80", hostWith("10.0.0.5", 22, 80))
+	msgs, err := resultMessages(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 3 {
+		t.Fatalf("%d messages, want 2 ports and a summary", len(msgs))
+	}
+	var port, summary resultMessage
+	if err := json.Unmarshal(msgs[0].value, &port); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(msgs[2].value, &summary); err != nil {
+		t.Fatal(err)
+	}
+	if msgs[0].key != "10.0.0.5" || port.Type != "port" || port.Port.Port != 22 || port.Service != "ssh" {
+		t.Errorf("port message = %s (key %q)", msgs[0].value, msgs[0].key)
+	}
+	if summary.Type != "summary" || summary.Summary.OpenPorts != 2 || summary.ScanID == "" || summary.ScanID != port.ScanID {
+		t.Errorf("summary message = %s", msgs[2].value)
+	}
+}
+
+func TestNewPublisherKafka(t *testing.T) {
+	p, err := newPublisher("kafka+tls://scanner:pw@k1:9092,k2:9092/scan-results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := p.broker.(kafkaBroker).w
+	if w.Topic != "scan-results" || w.Addr.String() != "k1:9092,k2:9092" {
+		t.Errorf("writer topic %q, brokers %q", w.Topic, w.Addr)
+	}
+	if tr := w.Transport.(*kafka.Transport); tr.TLS == nil || tr.SASL == nil {
+		t.Error("TLS or SASL not configured")
+	}
+
+	for _, u := range []string{"kafka://k1:9092", "kafka:///topic", "amqp://mq/results", "k1:9092/topic", "nats://n1/a/b"} {
+		if _, err := newPublisher(u); err == nil {
+			t.Errorf("newPublisher(%q) succeeded", u)
+		}
+	}
+}
+
+// fakeNATS speaks just enough of the NATS protocol to accept a client and
+// pass on the payloads it publishes.
+func fakeNATS(t *testing.T) (addr string, pubs <-chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	ch := make(chan string, 10)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		fmt.Fprintf(c, "INFO {\"server_id\":\"test\",\"version\":\"2.10.0\",\"proto\":1,\"max_payload\":1048576}\r\n")
+		r := bufio.NewReader(c)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch f := strings.Fields(line); {
+			case len(f) > 0 && f[0] == "PING":
+				io.WriteString(c, "PONG\r\n")
+			case len(f) == 3 && f[0] == "PUB":
+				var n int
+				fmt.Sscan(f[2], &n)
+				buf := make([]byte, n+2)
+				if _, err := io.ReadFull(r, buf); err != nil {
+					return
+				}
+				ch <- f[1] + " " + string(buf[:n])
+			}
+		}
+	}()
+	return l.Addr().String(), ch
+}
+
+func TestPublishNATS(t *testing.T) {
+	addr, pubs := fakeNATS(t)
+	p, err := newPublisher("nats://" + addr + "/scans.results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.notify(scanRun{Report: testReport("22", hostWith("10.0.0.5", 22))})
+	for _, want := range []string{`scans.results {"type":"port"`, `scans.results {"type":"summary"`} {
+		if got := <-pubs; !strings.HasPrefix(got, want) {
+			t.Errorf("published %q, want prefix %q", got, want)
+		}
+	}
+}
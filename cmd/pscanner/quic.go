@@ -0,0 +1,758 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// QUICInfo is what --quic found out about a host's QUIC service.
+type QUICInfo struct {
+	Port int `json:"port"`
+	// Versions are those the server offered in version negotiation.
+	Versions []string `json:"versions"`
+	// ALPN is the application protocol agreed in a QUIC version 1
+	// handshake, such as "h3" for HTTP/3.
+	ALPN string `json:"alpn,omitempty"`
+	// Error says why the handshake did not get as far as the ALPN.
+	Error string `json:"error,omitempty"`
+}
+
+func (q *QUICInfo) String() string {
+	s := fmt.Sprintf("udp/%d versions %s", q.Port, strings.Join(q.Versions, ", "))
+	switch {
+	case q.ALPN != "":
+		s += "; ALPN " + q.ALPN
+	case q.Error != "":
+		s += " (" + q.Error + ")"
+	}
+	return s
+}
+
+// quicPort is the UDP port --quic probes: HTTP/3 and most QUIC services
+// listen there. Tests point it at their own server.
+var quicPort = 443
+
+const (
+	// quicParallel is the number of hosts probed at once.
+	quicParallel = 64
+	// quicVersion1 is QUIC version 1 (RFC 9000), the only version whose
+	// handshake the probe speaks.
+	quicVersion1 = 0x00000001
+	// quicProbeVersion is a reserved version (RFC 9000 section 15) that
+	// makes a server answer with the versions it supports.
+	quicProbeVersion = 0x1a2a3a4a
+	// quicMinDatagram is the size a client's first datagrams are padded
+	// to; servers ignore smaller ones.
+	quicMinDatagram = 1200
+)
+
+// quicALPNs are the application protocols the handshake offers.
+var quicALPNs = []string{"h3", "h3-29", "hq-interop", "hq-29", "doq", "doq-i02"}
+
+// probeQUIC sends a QUIC probe to UDP port 443 of every host and records
+// those that answer, with an open udp/443 port. It waits up to --timeout
+// for each answer, and a host that does not answer is left as it is: UDP
+// gives no way to tell a filtered port from one nothing listens on.
+func (p *scanPlan) probeQUIC(ctx context.Context, hosts []HostResult) {
+	if !p.quic {
+		return
+	}
+	dns := newDNSCache(p.dnsCache)
+	sem := make(chan struct{}, quicParallel)
+	var wg sync.WaitGroup
+	for i := range hosts {
+		h := &hosts[i]
+		sem <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			info, err := p.quicProbe(ctx, dns, h.Host, h.Family)
+			if err != nil {
+				slog.Warn("quic probe failed", "host", h.Host, "err", err)
+				return
+			}
+			if info != nil {
+				h.QUIC = info
+				addUDPPort(h, quicPort, "quic")
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// quicProbe asks host for its QUIC versions and, if it speaks version 1,
+// makes a handshake to learn the ALPN. It returns nil when nothing
+// answers.
+func (p *scanPlan) quicProbe(ctx context.Context, dns *dnsCache, host, family string) (*QUICInfo, error) {
+	addr, serverName, err := udpAddr(ctx, dns, host, family)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := p.dialUDP(addr, quicPort)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	versions, err := quicVersions(conn, p.timeout)
+	if err != nil || versions == nil {
+		return nil, err
+	}
+	info := &QUICInfo{Port: quicPort}
+	for _, v := range versions {
+		info.Versions = append(info.Versions, quicVersionName(v))
+	}
+	if !slices.Contains(versions, quicVersion1) {
+		info.Error = "no handshake: version 1 not offered"
+		return info, nil
+	}
+	if info.ALPN, err = quicHandshake(ctx, conn, serverName, p.timeout); err != nil {
+		info.Error = err.Error()
+	}
+	return info, nil
+}
+
+// udpAddr is the address UDP probes of host go to: host itself if it is
+// an IP address, or else its first address of family in dns, with host
+// as the TLS server name.
+func udpAddr(ctx context.Context, dns *dnsCache, host, family string) (addr netip.Addr, serverName string, err error) {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return addr.Unmap(), "", nil
+	}
+	addrs, err := dns.resolve(ctx, host)
+	if err != nil {
+		return netip.Addr{}, "", err
+	}
+	for _, a := range addrs {
+		if family == "" || (family == familyIPv4) == a.Is4() {
+			return a.Unmap(), host, nil
+		}
+	}
+	return netip.Addr{}, "", fmt.Errorf("no %s address", family)
+}
+
+// dialUDP opens a UDP socket to port of addr, from the --interface or
+// --source-ip address if there is one.
+func (p *scanPlan) dialUDP(addr netip.Addr, port int) (*net.UDPConn, error) {
+	var src netip.Addr
+	if p.source != nil {
+		src = p.source.from(addr)
+	}
+	return net.DialUDP("udp", net.UDPAddrFromAddrPort(netip.AddrPortFrom(src, 0)), net.UDPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(port))))
+}
+
+// addUDPPort records port as an open UDP port of h with the service found
+// on it, unless another probe already did.
+func addUDPPort(h *HostResult, port int, service string) {
+	for _, pr := range h.Ports {
+		if pr.Protocol == "udp" && pr.Port == port {
+			return
+		}
+	}
+	h.Ports = append(h.Ports, PortResult{Port: port, Protocol: "udp", State: "open", Service: service})
+}
+
+// quicVersionName names a QUIC version as it is usually written.
+func quicVersionName(v uint32) string {
+	switch {
+	case v == quicVersion1:
+		return "1"
+	case v == 0x6b3343cf:
+		return "2"
+	case v>>8 == 0xff0000:
+		return fmt.Sprintf("draft-%d", v&0xff)
+	case v>>16 == 0x5130: // "Q0nn", Google QUIC
+		return string(binary.BigEndian.AppendUint32(nil, v))
+	case v&0xfffffff0 == 0xfaceb000:
+		return fmt.Sprintf("mvfst-%d", v&0xf)
+	}
+	return fmt.Sprintf("0x%08x", v)
+}
+
+// quicVersions sends a packet of a version no server supports and returns
+// the versions of the version negotiation packet that answers it, or nil
+// if none does before timeout.
+func quicVersions(conn *net.UDPConn, timeout time.Duration) ([]uint32, error) {
+	dcid, scid := make([]byte, 8), make([]byte, 8)
+	rand.Read(dcid)
+	rand.Read(scid)
+	pkt := []byte{0xc0}
+	pkt = binary.BigEndian.AppendUint32(pkt, quicProbeVersion)
+	pkt = append(pkt, byte(len(dcid)))
+	pkt = append(pkt, dcid...)
+	pkt = append(pkt, byte(len(scid)))
+	pkt = append(pkt, scid...)
+	pkt = append(pkt, make([]byte, quicMinDatagram-len(pkt))...)
+	if _, err := conn.Write(pkt); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 1500)
+	deadline := time.Now().Add(timeout)
+	for {
+		conn.SetReadDeadline(deadline)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, nil // nothing answered, or ICMP port unreachable
+		}
+		if versions, ok := parseVersionNegotiation(buf[:n], scid); ok {
+			return versions, nil
+		}
+	}
+}
+
+// parseVersionNegotiation reads a version negotiation packet addressed to
+// the connection ID dcid. Reserved versions sent to keep clients honest
+// are left out.
+func parseVersionNegotiation(b, dcid []byte) ([]uint32, bool) {
+	if len(b) < 7 || b[0]&0x80 == 0 || binary.BigEndian.Uint32(b[1:5]) != 0 {
+		return nil, false
+	}
+	b = b[5:]
+	if int(b[0]) != len(dcid) || len(b) < 1+len(dcid)+1 || string(b[1:1+len(dcid)]) != string(dcid) {
+		return nil, false
+	}
+	b = b[1+len(dcid):]
+	if len(b) < 1+int(b[0]) {
+		return nil, false
+	}
+	b = b[1+int(b[0]):]
+	versions := []uint32{}
+	for ; len(b) >= 4; b = b[4:] {
+		if v := binary.BigEndian.Uint32(b); v&0x0f0f0f0f != 0x0a0a0a0a {
+			versions = append(versions, v)
+		}
+	}
+	return versions, true
+}
+
+// QUIC long header packet types (RFC 9000 section 17.2).
+const (
+	quicTypeInitial   = 0
+	quicTypeHandshake = 2
+	quicTypeRetry     = 3
+)
+
+// quicInitialSalt derives the keys of version 1 Initial packets (RFC 9001
+// section 5.2).
+var quicInitialSalt = []byte{0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17, 0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a}
+
+// quicKeys protect the packets of one direction at one encryption level.
+type quicKeys struct {
+	aead cipher.AEAD
+	iv   []byte
+	mask func(sample []byte) []byte // header protection mask
+}
+
+// hkdfExpandLabel is HKDF-Expand-Label of TLS 1.3 (RFC 8446 section 7.1)
+// with an empty context.
+func hkdfExpandLabel(h func() hash.Hash, secret []byte, label string, n int) []byte {
+	label = "tls13 " + label
+	info := binary.BigEndian.AppendUint16(nil, uint16(n))
+	info = append(info, byte(len(label)))
+	info = append(info, label...)
+	info = append(info, 0)
+	out, err := hkdf.Expand(h, secret, string(info), n)
+	if err != nil {
+		panic(err) // only for lengths out of range
+	}
+	return out
+}
+
+// quicInitialKeys derives the client's and the server's Initial keys
+// from the connection ID the client first sent to.
+func quicInitialKeys(dcid []byte) (client, server *quicKeys) {
+	initial, err := hkdf.Extract(sha256.New, dcid, quicInitialSalt)
+	if err != nil {
+		panic(err)
+	}
+	client, _ = newQUICKeys(tls.TLS_AES_128_GCM_SHA256, hkdfExpandLabel(sha256.New, initial, "client in", 32))
+	server, _ = newQUICKeys(tls.TLS_AES_128_GCM_SHA256, hkdfExpandLabel(sha256.New, initial, "server in", 32))
+	return client, server
+}
+
+// newQUICKeys derives packet protection keys from a TLS secret (RFC 9001
+// section 5.1).
+func newQUICKeys(suite uint16, secret []byte) (*quicKeys, error) {
+	h, keyLen := sha256.New, 16
+	switch suite {
+	case tls.TLS_AES_128_GCM_SHA256:
+	case tls.TLS_AES_256_GCM_SHA384:
+		h, keyLen = sha512.New384, 32
+	case tls.TLS_CHACHA20_POLY1305_SHA256:
+		keyLen = 32
+	default:
+		return nil, fmt.Errorf("unknown cipher suite 0x%04x", suite)
+	}
+	key := hkdfExpandLabel(h, secret, "quic key", keyLen)
+	hp := hkdfExpandLabel(h, secret, "quic hp", keyLen)
+	k := &quicKeys{iv: hkdfExpandLabel(h, secret, "quic iv", 12)}
+	if suite == tls.TLS_CHACHA20_POLY1305_SHA256 {
+		var err error
+		if k.aead, err = chacha20poly1305.New(key); err != nil {
+			return nil, err
+		}
+		k.mask = func(sample []byte) []byte {
+			c, _ := chacha20.NewUnauthenticatedCipher(hp, sample[4:16])
+			c.SetCounter(binary.LittleEndian.Uint32(sample[:4]))
+			mask := make([]byte, 5)
+			c.XORKeyStream(mask, mask)
+			return mask
+		}
+		return k, nil
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if k.aead, err = cipher.NewGCM(block); err != nil {
+		return nil, err
+	}
+	hpBlock, err := aes.NewCipher(hp)
+	if err != nil {
+		return nil, err
+	}
+	k.mask = func(sample []byte) []byte {
+		mask := make([]byte, aes.BlockSize)
+		hpBlock.Encrypt(mask, sample)
+		return mask[:5]
+	}
+	return k, nil
+}
+
+func (k *quicKeys) nonce(pn uint64) []byte {
+	nonce := slices.Clone(k.iv)
+	for i := range 8 {
+		nonce[len(nonce)-1-i] ^= byte(pn >> (8 * i))
+	}
+	return nonce
+}
+
+// sealLong builds a protected long header packet with a 4-byte packet
+// number. token is only sent in Initial packets.
+func (k *quicKeys) sealLong(typ byte, dcid, scid, token []byte, pn uint32, payload []byte) []byte {
+	hdr := []byte{0xc0 | typ<<4 | 3}
+	hdr = binary.BigEndian.AppendUint32(hdr, quicVersion1)
+	hdr = append(hdr, byte(len(dcid)))
+	hdr = append(hdr, dcid...)
+	hdr = append(hdr, byte(len(scid)))
+	hdr = append(hdr, scid...)
+	if typ == quicTypeInitial {
+		hdr = appendVarint(hdr, uint64(len(token)))
+		hdr = append(hdr, token...)
+	}
+	hdr = binary.BigEndian.AppendUint16(hdr, 0x4000|uint16(4+len(payload)+k.aead.Overhead()))
+	pnOff := len(hdr)
+	hdr = binary.BigEndian.AppendUint32(hdr, pn)
+	pkt := k.aead.Seal(hdr, k.nonce(uint64(pn)), payload, hdr)
+	mask := k.mask(pkt[pnOff+4 : pnOff+20])
+	pkt[0] ^= mask[0] & 0x0f
+	for i := range 4 {
+		pkt[pnOff+i] ^= mask[1+i]
+	}
+	return pkt
+}
+
+// open removes the protection of a long header packet whose packet
+// number starts at pnOff, and returns its packet number and payload.
+func (k *quicKeys) open(pkt []byte, pnOff int) (uint64, []byte, error) {
+	if len(pkt) < pnOff+20 {
+		return 0, nil, errors.New("packet too short")
+	}
+	pkt = slices.Clone(pkt)
+	mask := k.mask(pkt[pnOff+4 : pnOff+20])
+	pkt[0] ^= mask[0] & 0x0f
+	pnLen := int(pkt[0]&3) + 1
+	var pn uint64
+	for i := range pnLen {
+		pkt[pnOff+i] ^= mask[1+i]
+		pn = pn<<8 | uint64(pkt[pnOff+i])
+	}
+	// The server's packet numbers start at 0 and the probe sees only a
+	// few, so the truncated number is the full one.
+	hdr := pkt[:pnOff+pnLen]
+	payload, err := k.aead.Open(nil, k.nonce(pn), pkt[pnOff+pnLen:], hdr)
+	return pn, payload, err
+}
+
+// appendVarint appends a QUIC variable-length integer (RFC 9000 section 16).
+func appendVarint(b []byte, v uint64) []byte {
+	switch {
+	case v < 1<<6:
+		return append(b, byte(v))
+	case v < 1<<14:
+		return binary.BigEndian.AppendUint16(b, 0x4000|uint16(v))
+	case v < 1<<30:
+		return binary.BigEndian.AppendUint32(b, 0x80000000|uint32(v))
+	}
+	return binary.BigEndian.AppendUint64(b, 0xc000000000000000|v)
+}
+
+// readVarint reads a variable-length integer; n is 0 if b is too short.
+func readVarint(b []byte) (v uint64, n int) {
+	if len(b) == 0 {
+		return 0, 0
+	}
+	n = 1 << (b[0] >> 6)
+	if len(b) < n {
+		return 0, 0
+	}
+	v = uint64(b[0] & 0x3f)
+	for _, c := range b[1:n] {
+		v = v<<8 | uint64(c)
+	}
+	return v, n
+}
+
+// quicPacket is a long header packet received from the server.
+type quicPacket struct {
+	typ   byte
+	scid  []byte
+	token []byte // of a Retry
+	raw   []byte // the whole packet
+	pnOff int
+}
+
+// parseLongPackets splits a datagram into its version 1 long header
+// packets. Short header packets, which only follow the handshake, end it.
+func parseLongPackets(d []byte) []quicPacket {
+	var pkts []quicPacket
+	for len(d) > 7 && d[0]&0x80 != 0 {
+		p := quicPacket{typ: d[0] >> 4 & 3}
+		if binary.BigEndian.Uint32(d[1:5]) != quicVersion1 {
+			break
+		}
+		off := 5
+		dcidLen := int(d[off])
+		off += 1 + dcidLen
+		if off >= len(d) {
+			break
+		}
+		scidLen := int(d[off])
+		if off+1+scidLen > len(d) {
+			break
+		}
+		p.scid = d[off+1 : off+1+scidLen]
+		off += 1 + scidLen
+		if p.typ == quicTypeRetry {
+			// The token runs up to the 16-byte integrity tag.
+			if len(d) < off+16 {
+				break
+			}
+			p.token, p.raw = d[off:len(d)-16], d
+			pkts = append(pkts, p)
+			break
+		}
+		if p.typ == quicTypeInitial {
+			tl, n := readVarint(d[off:])
+			if n == 0 || off+n+int(tl) > len(d) {
+				break
+			}
+			off += n + int(tl)
+		}
+		length, n := readVarint(d[off:])
+		if n == 0 || off+n+int(length) > len(d) {
+			break
+		}
+		p.pnOff = off + n
+		p.raw = d[:p.pnOff+int(length)]
+		d = d[p.pnOff+int(length):]
+		pkts = append(pkts, p)
+	}
+	return pkts
+}
+
+// quicCloseError is a CONNECTION_CLOSE from the server.
+type quicCloseError struct {
+	code   uint64
+	reason string
+}
+
+func (e *quicCloseError) Error() string {
+	switch {
+	case e.code == 0x100+120: // no_application_protocol alert
+		return "the server supports none of the offered ALPNs"
+	case e.code >= 0x100 && e.code < 0x200:
+		return fmt.Sprintf("TLS alert %d from the server", e.code-0x100)
+	case e.reason != "":
+		return fmt.Sprintf("connection closed by the server: %s (0x%x)", e.reason, e.code)
+	}
+	return fmt.Sprintf("connection closed by the server (0x%x)", e.code)
+}
+
+// parseQUICFrames reads the frames of an Initial or Handshake packet and
+// passes the CRYPTO data to crypto. A CONNECTION_CLOSE frame ends the
+// packet with a *quicCloseError.
+func parseQUICFrames(b []byte, crypto func(off uint64, data []byte)) error {
+	var v [4]uint64
+	// read reads count varints into v.
+	read := func(count int) bool {
+		for i := range count {
+			x, n := readVarint(b)
+			if n == 0 {
+				return false
+			}
+			v[i], b = x, b[n:]
+		}
+		return true
+	}
+	for len(b) > 0 {
+		typ := b[0]
+		b = b[1:]
+		switch typ {
+		case 0x00, 0x01: // PADDING, PING
+		case 0x02, 0x03: // ACK
+			if !read(4) {
+				return errors.New("bad ACK frame")
+			}
+			for range v[2] {
+				if !read(2) {
+					return errors.New("bad ACK frame")
+				}
+			}
+			if typ == 0x03 && !read(3) {
+				return errors.New("bad ACK frame")
+			}
+		case 0x06: // CRYPTO
+			if !read(2) || uint64(len(b)) < v[1] {
+				return errors.New("bad CRYPTO frame")
+			}
+			crypto(v[0], b[:v[1]])
+			b = b[v[1]:]
+		case 0x1c, 0x1d: // CONNECTION_CLOSE
+			count := 2
+			if typ == 0x1c {
+				count = 3 // with the type of the frame that caused it
+			}
+			if !read(count) || uint64(len(b)) < v[count-1] {
+				return errors.New("bad CONNECTION_CLOSE frame")
+			}
+			return &quicCloseError{code: v[0], reason: string(b[:v[count-1]])}
+		default:
+			return fmt.Errorf("unexpected frame type 0x%x", typ)
+		}
+	}
+	return nil
+}
+
+// cryptoStream puts the CRYPTO data of one encryption level in order.
+type cryptoStream struct {
+	next    uint64
+	pending map[uint64][]byte
+}
+
+// add takes the data at off and returns what now follows on from the
+// data returned before.
+func (s *cryptoStream) add(off uint64, data []byte) []byte {
+	if s.pending == nil {
+		s.pending = make(map[uint64][]byte)
+	}
+	if end := off + uint64(len(data)); end > s.next {
+		s.pending[off] = slices.Clone(data)
+	}
+	var out []byte
+	for progress := true; progress; {
+		progress = false
+		for off, data := range s.pending {
+			if off > s.next {
+				continue
+			}
+			delete(s.pending, off)
+			if end := off + uint64(len(data)); end > s.next {
+				out = append(out, data[s.next-off:]...)
+				s.next = end
+			}
+			progress = true
+		}
+	}
+	return out
+}
+
+// quicHandshake makes a QUIC version 1 handshake over conn, far enough to
+// learn the ALPN the server picks, and returns it. The server's
+// certificate is not checked. The probe does not finish the handshake:
+// the server gives up on the connection after its idle timeout.
+func quicHandshake(ctx context.Context, conn *net.UDPConn, serverName string, timeout time.Duration) (string, error) {
+	odcid, scid := make([]byte, 8), make([]byte, 8)
+	rand.Read(odcid)
+	rand.Read(scid)
+	dcid := odcid
+	clientKeys, serverKeys := quicInitialKeys(odcid)
+
+	// Transport parameters (RFC 9000 section 18.2): the initial source
+	// connection ID, which servers insist on, and an idle timeout.
+	var params []byte
+	params = appendVarint(params, 0x0f)
+	params = appendVarint(params, uint64(len(scid)))
+	params = append(params, scid...)
+	params = appendVarint(params, 0x01)
+	params = appendVarint(params, 2)
+	params = appendVarint(params, 10000)
+
+	tc := tls.QUICClient(&tls.QUICConfig{TLSConfig: &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: true,
+		NextProtos:         quicALPNs,
+		MinVersion:         tls.VersionTLS13,
+		// One key share keeps the ClientHello in a single packet.
+		CurvePreferences: []tls.CurveID{tls.X25519},
+	}})
+	tc.SetTransportParameters(params)
+	ctx, cancel := context.WithTimeout(ctx, 4*timeout+time.Second)
+	defer cancel()
+	if err := tc.Start(ctx); err != nil {
+		return "", err
+	}
+	defer tc.Close()
+
+	readKeys := map[tls.QUICEncryptionLevel]*quicKeys{tls.QUICEncryptionLevelInitial: serverKeys}
+	streams := make(map[tls.QUICEncryptionLevel]*cryptoStream)
+	var hello []byte
+	// events handles what crypto/tls has to say; done is set once the
+	// handshake is complete.
+	events := func() (done bool, err error) {
+		for {
+			e := tc.NextEvent()
+			switch e.Kind {
+			case tls.QUICNoEvent:
+				return false, nil
+			case tls.QUICWriteData:
+				if e.Level == tls.QUICEncryptionLevelInitial {
+					hello = append(hello, e.Data...)
+				}
+			case tls.QUICSetReadSecret:
+				if readKeys[e.Level], err = newQUICKeys(e.Suite, e.Data); err != nil {
+					return false, err
+				}
+			case tls.QUICTransportParametersRequired:
+				tc.SetTransportParameters(params)
+			case tls.QUICHandshakeDone:
+				return true, nil
+			}
+		}
+	}
+	if _, err := events(); err != nil {
+		return "", err
+	}
+
+	var pn uint32
+	var token []byte
+	largest := -1 // the largest Initial packet number received
+	send := func(frames []byte) error {
+		// The Initial packet fills the datagram up with PADDING.
+		overhead := 1 + 4 + 1 + len(dcid) + 1 + len(scid) + len(appendVarint(nil, uint64(len(token)))) + len(token) + 2 + 4 + 16
+		if pad := quicMinDatagram - overhead - len(frames); pad > 0 {
+			frames = append(frames, make([]byte, pad)...)
+		}
+		_, err := conn.Write(clientKeys.sealLong(quicTypeInitial, dcid, scid, token, pn, frames))
+		pn++
+		return err
+	}
+	cryptoFrame := func() []byte {
+		f := []byte{0x06, 0}
+		f = appendVarint(f, uint64(len(hello)))
+		return append(f, hello...)
+	}
+	if err := send(cryptoFrame()); err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, 65536)
+	retried := false
+	for {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		n, err := conn.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, os.ErrDeadlineExceeded) {
+				return "", errors.New("no handshake: the server stopped answering")
+			}
+			return "", err
+		}
+		for _, pkt := range parseLongPackets(buf[:n]) {
+			var level tls.QUICEncryptionLevel
+			switch pkt.typ {
+			case quicTypeRetry:
+				if retried || len(pkt.token) == 0 {
+					continue
+				}
+				// Start again with the token, sent to the connection ID
+				// the server picked and protected with keys for it.
+				retried, token, dcid = true, slices.Clone(pkt.token), slices.Clone(pkt.scid)
+				clientKeys, serverKeys = quicInitialKeys(dcid)
+				readKeys[tls.QUICEncryptionLevelInitial] = serverKeys
+				if err := send(cryptoFrame()); err != nil {
+					return "", err
+				}
+				continue
+			case quicTypeInitial:
+				level = tls.QUICEncryptionLevelInitial
+			case quicTypeHandshake:
+				level = tls.QUICEncryptionLevelHandshake
+			default:
+				continue
+			}
+			keys := readKeys[level]
+			if keys == nil {
+				continue
+			}
+			ppn, payload, err := keys.open(pkt.raw, pkt.pnOff)
+			if err != nil {
+				continue // not for this connection, or damaged
+			}
+			if level == tls.QUICEncryptionLevelInitial {
+				dcid = slices.Clone(pkt.scid) // the server's connection ID
+				largest = max(largest, int(ppn))
+			}
+			if streams[level] == nil {
+				streams[level] = new(cryptoStream)
+			}
+			var data []byte
+			err = parseQUICFrames(payload, func(off uint64, b []byte) {
+				data = append(data, streams[level].add(off, b)...)
+			})
+			if len(data) > 0 {
+				if herr := tc.HandleData(level, data); herr != nil {
+					return "", herr
+				}
+			}
+			if err != nil {
+				return "", err
+			}
+			if done, err := events(); err != nil {
+				return "", err
+			} else if done {
+				return tc.ConnectionState().NegotiatedProtocol, nil
+			}
+		}
+		// Acknowledging what arrived lets the server send more than three
+		// times what it received from an unvalidated address.
+		if largest >= 0 {
+			ack := []byte{0x02}
+			ack = appendVarint(ack, uint64(largest))
+			ack = append(ack, 0, 0)
+			ack = appendVarint(ack, uint64(largest))
+			if err := send(ack); err != nil {
+				return "", err
+			}
+		}
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// The keys and header protection of RFC 9001 Appendix A.
+func TestQUICKeys(t *testing.T) {
+	client, server := quicInitialKeys(unhex(t, "8394c8f03e515708"))
+	for _, tt := range []struct {
+		name         string
+		k            *quicKeys
+		iv           string
+		sample, mask string
+	}{
+		{"client Initial", client, "fa044b2f42a3fd3b46fb255c", "d1b1c98dd7689fb8ec11d242b123dc9b", "437b9aec36"},
+		{"server Initial", server, "0ac1493ca1905853b0bba03e", "2cd0991cd25b0aac406a5816b6394100", "2ec0d8356a"},
+	} {
+		if got := hex.EncodeToString(tt.k.iv); got != tt.iv {
+			t.Errorf("%s: iv %s, want %s", tt.name, got, tt.iv)
+		}
+		if got := hex.EncodeToString(tt.k.mask(unhex(t, tt.sample))); got != tt.mask {
+			t.Errorf("%s: mask %s, want %s", tt.name, got, tt.mask)
+		}
+	}
+
+	// A.5: a short header packet protected with ChaCha20-Poly1305.
+	k, err := newQUICKeys(tls.TLS_CHACHA20_POLY1305_SHA256, unhex(t, "9ac312a7f877468ebe69422748ad00a15443f18203a07d6060f688f30f21632b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(k.iv); got != "e0459b3474bdd0e44a41c144" {
+		t.Errorf("ChaCha20 iv %s", got)
+	}
+	sealed := k.aead.Seal(nil, k.nonce(654360564), []byte{0x01}, unhex(t, "4200bff4"))
+	if got := hex.EncodeToString(sealed); got != "655e5cd55c41f69080575d7999c25a5bfb" {
+		t.Errorf("ChaCha20 payload %s", got)
+	}
+	if got := hex.EncodeToString(k.mask(sealed[1:17])); got != "aefefe7d03" {
+		t.Errorf("ChaCha20 mask %s", got)
+	}
+}
+
+func TestQUICPacketRoundTrip(t *testing.T) {
+	client, _ := quicInitialKeys([]byte("dcid-123"))
+	payload := bytes.Repeat([]byte{0x01}, 40) // PINGs
+	pkt := client.sealLong(quicTypeInitial, []byte("dcid-123"), []byte("scid"), []byte("tok"), 7, payload)
+	pkts := parseLongPackets(append(pkt, 0x40, 0x00)) // a short header packet after it
+	if len(pkts) != 1 || pkts[0].typ != quicTypeInitial || string(pkts[0].scid) != "scid" {
+		t.Fatalf("parseLongPackets = %+v", pkts)
+	}
+	pn, got, err := client.open(pkts[0].raw, pkts[0].pnOff)
+	if err != nil || pn != 7 || !bytes.Equal(got, payload) {
+		t.Errorf("open = %d, %x, %v", pn, got, err)
+	}
+}
+
+func TestQUICVarint(t *testing.T) {
+	for _, v := range []uint64{0, 63, 64, 16383, 16384, 1<<30 - 1, 1 << 30, 1<<62 - 1} {
+		b := appendVarint(nil, v)
+		if got, n := readVarint(b); got != v || n != len(b) {
+			t.Errorf("varint %d: read %d from %d of %d bytes", v, got, n, len(b))
+		}
+	}
+	// RFC 9000 Appendix A.1.
+	if v, n := readVarint(unhex(t, "c2197c5eff14e88c")); v != 151288809941952652 || n != 8 {
+		t.Errorf("readVarint = %d, %d", v, n)
+	}
+	if _, n := readVarint([]byte{0x40}); n != 0 {
+		t.Error("short varint read")
+	}
+}
+
+func TestParseVersionNegotiation(t *testing.T) {
+	dcid := []byte("clientid")
+	vn := []byte{0x80 | 0x35, 0, 0, 0, 0, byte(len(dcid))}
+	vn = append(vn, dcid...)
+	vn = append(vn, 4, 's', 'r', 'v', '1')
+	for _, v := range []uint32{quicVersion1, 0x5a6a7a8a, 0xff00001d, 0x51303530} {
+		vn = binary.BigEndian.AppendUint32(vn, v)
+	}
+	versions, ok := parseVersionNegotiation(vn, dcid)
+	if !ok || !reflect.DeepEqual(versions, []uint32{quicVersion1, 0xff00001d, 0x51303530}) {
+		t.Errorf("versions = %x, %v", versions, ok)
+	}
+	var names []string
+	for _, v := range versions {
+		names = append(names, quicVersionName(v))
+	}
+	if want := []string{"1", "draft-29", "Q050"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("names = %q, want %q", names, want)
+	}
+	if _, ok := parseVersionNegotiation(vn, []byte("otherid!")); ok {
+		t.Error("version negotiation for another connection accepted")
+	}
+}
+
+func TestCryptoStream(t *testing.T) {
+	var s cryptoStream
+	if got := s.add(5, []byte("world")); len(got) != 0 {
+		t.Errorf("data after a gap = %q", got)
+	}
+	if got := s.add(0, []byte("hello")); string(got) != "helloworld" {
+		t.Errorf("data = %q", got)
+	}
+	if got := s.add(3, []byte("loworld!")); string(got) != "!" {
+		t.Errorf("overlapping data = %q", got)
+	}
+}
+
+// quicTestServer answers QUIC probes on a local UDP port: version
+// negotiation, and the server's first flight of a version 1 handshake,
+// made by crypto/tls with a self-signed certificate.
+type quicTestServer struct {
+	pc    *net.UDPConn
+	alpn  string
+	retry bool // answer the first Initial with a Retry
+	cert  tls.Certificate
+}
+
+// startQUICServer starts a server and points --quic at it.
+func startQUICServer(t *testing.T, alpn string, retry bool) {
+	pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &quicTestServer{pc: pc, alpn: alpn, retry: retry, cert: testCertificate(t)}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.serve(t)
+	}()
+	old := quicPort
+	quicPort = pc.LocalAddr().(*net.UDPAddr).Port
+	t.Cleanup(func() {
+		quicPort = old
+		pc.Close()
+		<-done
+	})
+}
+
+func testCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func (s *quicTestServer) serve(t *testing.T) {
+	ourID := []byte("server01")
+	buf := make([]byte, 2048)
+	retried, answered := false, false
+	for {
+		n, from, err := s.pc.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			return
+		}
+		d := buf[:n]
+		if len(d) < 7 || d[0]&0x80 == 0 {
+			continue
+		}
+		dcid := d[6 : 6+int(d[5])]
+		scid := d[7+len(dcid) : 7+len(dcid)+int(d[6+len(dcid)])]
+
+		if binary.BigEndian.Uint32(d[1:5]) != quicVersion1 {
+			// Version negotiation, with a reserved version to ignore.
+			vn := []byte{0x80 | 0x2a, 0, 0, 0, 0, byte(len(scid))}
+			vn = append(vn, scid...)
+			vn = append(vn, byte(len(dcid)))
+			vn = append(vn, dcid...)
+			for _, v := range []uint32{quicVersion1, 0x5a6a7a8a, 0xff00001d} {
+				vn = binary.BigEndian.AppendUint32(vn, v)
+			}
+			s.pc.WriteToUDPAddrPort(vn, from)
+			continue
+		}
+		if d[0]>>4&3 != quicTypeInitial || answered {
+			continue // ACKs of what was sent
+		}
+		if s.retry && !retried {
+			retried = true
+			r := []byte{0xf0, 0, 0, 0, 1, byte(len(scid))}
+			r = append(r, scid...)
+			r = append(r, byte(len(ourID)))
+			r = append(r, ourID...)
+			r = append(r, "retry-token"...)
+			r = append(r, make([]byte, 16)...) // the integrity tag, which the probe does not check
+			s.pc.WriteToUDPAddrPort(r, from)
+			continue
+		}
+		answered = true
+
+		// The client protects its Initial with keys from the connection
+		// ID it sent to, which after a Retry is ours.
+		clientKeys, serverKeys := quicInitialKeys(dcid)
+		pkts := parseLongPackets(d)
+		if len(pkts) == 0 {
+			t.Errorf("client Initial not parsed: %x", d)
+			return
+		}
+		_, payload, err := clientKeys.open(pkts[0].raw, pkts[0].pnOff)
+		if err != nil {
+			t.Errorf("opening the client Initial: %v", err)
+			return
+		}
+		var stream cryptoStream
+		var hello []byte
+		if err := parseQUICFrames(payload, func(off uint64, b []byte) { hello = append(hello, stream.add(off, b)...) }); err != nil {
+			t.Errorf("client Initial: %v", err)
+			return
+		}
+
+		tc := tls.QUICServer(&tls.QUICConfig{TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{s.cert},
+			NextProtos:   []string{s.alpn},
+			MinVersion:   tls.VersionTLS13,
+		}})
+		var params []byte
+		params = appendVarint(params, 0x0f)
+		params = appendVarint(params, uint64(len(ourID)))
+		params = append(params, ourID...)
+		tc.SetTransportParameters(params)
+		if err := tc.Start(context.Background()); err != nil {
+			t.Errorf("starting the server handshake: %v", err)
+			return
+		}
+		defer tc.Close()
+		var alert tls.AlertError
+		if err := tc.HandleData(tls.QUICEncryptionLevelInitial, hello); errors.As(err, &alert) {
+			// CONNECTION_CLOSE with the TLS alert, caused by a CRYPTO frame.
+			f := appendVarint([]byte{0x1c}, 0x100+uint64(alert))
+			f = append(f, 0x06, 0)
+			s.pc.WriteToUDPAddrPort(serverKeys.sealLong(quicTypeInitial, scid, ourID, nil, 0, f), from)
+			continue
+		} else if err != nil {
+			t.Errorf("server handshake: %v", err)
+			return
+		}
+		out := make(map[tls.QUICEncryptionLevel][]byte)
+		keys := map[tls.QUICEncryptionLevel]*quicKeys{tls.QUICEncryptionLevelInitial: serverKeys}
+		for e := tc.NextEvent(); e.Kind != tls.QUICNoEvent; e = tc.NextEvent() {
+			switch e.Kind {
+			case tls.QUICWriteData:
+				out[e.Level] = append(out[e.Level], e.Data...)
+			case tls.QUICSetWriteSecret:
+				keys[e.Level], _ = newQUICKeys(e.Suite, e.Data)
+			}
+		}
+
+		// The ServerHello goes in an Initial packet, the rest of the
+		// flight in Handshake packets of its own.
+		send := func(level tls.QUICEncryptionLevel, typ byte, pn uint32, off int, data []byte) {
+			f := appendVarint([]byte{0x06}, uint64(off))
+			f = appendVarint(f, uint64(len(data)))
+			f = append(f, data...)
+			s.pc.WriteToUDPAddrPort(keys[level].sealLong(typ, scid, ourID, nil, pn, f), from)
+		}
+		send(tls.QUICEncryptionLevelInitial, quicTypeInitial, 0, 0, out[tls.QUICEncryptionLevelInitial])
+		hs := out[tls.QUICEncryptionLevelHandshake]
+		for pn, off := uint32(0), 0; off < len(hs); pn, off = pn+1, off+1000 {
+			send(tls.QUICEncryptionLevelHandshake, quicTypeHandshake, pn, off, hs[off:min(off+1000, len(hs))])
+		}
+	}
+}
+
+func TestProbeQUIC(t *testing.T) {
+	tests := []struct {
+		name  string
+		alpn  string
+		retry bool
+		want  QUICInfo
+	}{
+		{"http3", "h3", false, QUICInfo{Versions: []string{"1", "draft-29"}, ALPN: "h3"}},
+		{"retry", "doq", true, QUICInfo{Versions: []string{"1", "draft-29"}, ALPN: "doq"}},
+		{"unknown ALPN", "x-private", false, QUICInfo{Versions: []string{"1", "draft-29"}, Error: "the server supports none of the offered ALPNs"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			startQUICServer(t, tt.alpn, tt.retry)
+			p := &scanPlan{quic: true, timeout: 2 * time.Second}
+			hosts := []HostResult{{Host: "127.0.0.1", Ports: []PortResult{}}}
+			p.probeQUIC(context.Background(), hosts)
+			h := hosts[0]
+			tt.want.Port = quicPort
+			if h.QUIC == nil || !reflect.DeepEqual(*h.QUIC, tt.want) {
+				t.Fatalf("QUIC = %+v, want %+v", h.QUIC, tt.want)
+			}
+			if want := []PortResult{{Port: quicPort, Protocol: "udp", State: "open", Service: "quic"}}; !reflect.DeepEqual(h.Ports, want) {
+				t.Errorf("ports = %+v, want %+v", h.Ports, want)
+			}
+		})
+	}
+}
+
+// A host where nothing answers keeps its results as they are.
+func TestProbeQUICNoAnswer(t *testing.T) {
+	pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close() // bound, so no one else answers, but never read
+	old := quicPort
+	defer func() { quicPort = old }()
+	quicPort = pc.LocalAddr().(*net.UDPAddr).Port
+
+	p := &scanPlan{quic: true, timeout: 200 * time.Millisecond}
+	hosts := []HostResult{{Host: "127.0.0.1", Ports: []PortResult{{Port: 22, Protocol: "tcp", State: "open"}}}}
+	p.probeQUIC(context.Background(), hosts)
+	if hosts[0].QUIC != nil || len(hosts[0].Ports) != 1 {
+		t.Errorf("host = %+v, want it unchanged", hosts[0])
+	}
+
+	// UDP ports count as probed only in scans with --quic.
+	r := testReport("22", hostWith("127.0.0.1"))
+	udp := portChange{Host: "127.0.0.1", Port: quicPort, Protocol: "udp"}
+	if probedBy(r)(udp) {
+		t.Error("udp port probed by a scan without --quic")
+	}
+	r.Parameters.QUIC = true
+	if !probedBy(r)(udp) {
+		t.Error("udp port not probed by a scan with --quic")
+	}
+}
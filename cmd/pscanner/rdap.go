@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// WhoisInfo is the registration of the network a host belongs to, from
+// the regional internet registry's RDAP service.
+type WhoisInfo struct {
+	Network string `json:"network"`           // the registered range, "first - last"
+	Name    string `json:"netname,omitempty"` // the registry's name for the network
+	Org     string `json:"org,omitempty"`
+	Country string `json:"country,omitempty"`
+	Abuse   string `json:"abuse,omitempty"` // e-mail address of the abuse contact
+}
+
+// maxRDAPLookups bounds the queries of one run; the registries rate-limit
+// RDAP and block clients that go on regardless.
+const maxRDAPLookups = 64
+
+// rdapClient looks up addresses through an RDAP redirector, which sends
+// each query on to the registry responsible for the address. Answers cover
+// whole networks and are kept for the life of the process, so the hosts of
+// a CIDR block usually cost one query.
+type rdapClient struct {
+	base    string
+	client  *http.Client
+	nets    []rdapNet
+	unknown map[netip.Addr]bool
+	lookups int // queries sent in the current run
+}
+
+type rdapNet struct {
+	first, last netip.Addr
+	info        *WhoisInfo
+}
+
+func newRDAPClient() *rdapClient {
+	return &rdapClient{base: "https://rdap.org", client: &http.Client{Timeout: 30 * time.Second}, unknown: make(map[netip.Addr]bool)}
+}
+
+// rdapNetwork is the part of an RDAP IP network object (RFC 9083) that
+// pscanner reads.
+type rdapNetwork struct {
+	StartAddress string       `json:"startAddress"`
+	EndAddress   string       `json:"endAddress"`
+	Name         string       `json:"name"`
+	Country      string       `json:"country"`
+	Entities     []rdapEntity `json:"entities"`
+}
+
+type rdapEntity struct {
+	Roles      []string     `json:"roles"`
+	VCardArray []any        `json:"vcardArray"`
+	Entities   []rdapEntity `json:"entities"`
+}
+
+// vcard returns the first value of the named jCard property, such as "fn"
+// or "email".
+func (e rdapEntity) vcard(name string) string {
+	if len(e.VCardArray) < 2 {
+		return ""
+	}
+	props, _ := e.VCardArray[1].([]any)
+	for _, p := range props {
+		// Each property is [name, parameters, type, value].
+		f, ok := p.([]any)
+		if !ok || len(f) < 4 || f[0] != name {
+			continue
+		}
+		if v, ok := f[3].(string); ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// findEntity returns the value of the jCard property of the first entity
+// with role, searching nested entities too: ARIN lists the abuse contact
+// under the registrant.
+func findEntity(entities []rdapEntity, role, prop string) string {
+	for _, e := range entities {
+		if slices.Contains(e.Roles, role) {
+			if v := e.vcard(prop); v != "" {
+				return v
+			}
+		}
+	}
+	for _, e := range entities {
+		if v := findEntity(e.Entities, role, prop); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// cached answers a from earlier lookups; ok is false when a needs a query.
+func (c *rdapClient) cached(a netip.Addr) (info *WhoisInfo, ok bool) {
+	for _, n := range c.nets {
+		if n.first.Compare(a) <= 0 && a.Compare(n.last) <= 0 {
+			return n.info, true
+		}
+	}
+	return nil, c.unknown[a]
+}
+
+// lookup returns the registration of the network a belongs to, or nil when
+// the registries have none.
+func (c *rdapClient) lookup(ctx context.Context, a netip.Addr) (*WhoisInfo, error) {
+	if info, ok := c.cached(a); ok {
+		return info, nil
+	}
+	c.lookups++
+	var nw rdapNetwork
+	ok, err := getJSON(ctx, c.client, c.base+"/ip/"+url.PathEscape(a.String()), nil, &nw)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		c.unknown[a] = true
+		return nil, nil
+	}
+	info := &WhoisInfo{
+		Network: nw.StartAddress + " - " + nw.EndAddress,
+		Name:    nw.Name,
+		Org:     findEntity(nw.Entities, "registrant", "fn"),
+		Country: nw.Country,
+		Abuse:   findEntity(nw.Entities, "abuse", "email"),
+	}
+	first, err1 := netip.ParseAddr(nw.StartAddress)
+	last, err2 := netip.ParseAddr(nw.EndAddress)
+	if err1 == nil && err2 == nil {
+		c.nets = append(c.nets, rdapNet{first, last, info})
+	} else {
+		info.Network = ""
+	}
+	return info, nil
+}
+
+// String formats w on one line, as text output and --dry-run show it.
+func (w *WhoisInfo) String() string {
+	var parts []string
+	for _, s := range []string{w.Name, w.Org, w.Country} {
+		if s != "" {
+			parts = append(parts, s)
+		}
+	}
+	s := strings.Join(parts, ", ")
+	if w.Network != "" {
+		s += " [" + w.Network + "]"
+	}
+	if w.Abuse != "" {
+		s += "; abuse contact " + w.Abuse
+	}
+	return strings.TrimSpace(s)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+// arinNetwork is shaped like ARIN's answers, which nest the abuse contact
+// under the registrant.
+const arinNetwork = `{
+  "objectClassName": "ip network",
+  "startAddress": "8.8.8.0",
+  "endAddress": "8.8.8.255",
+  "name": "GOGL",
+  "entities": [{
+    "roles": ["registrant"],
+    "vcardArray": ["vcard", [["version", {}, "text", "4.0"], ["fn", {}, "text", "Google LLC"], ["kind", {}, "text", "org"]]],
+    "entities": [{
+      "roles": ["abuse"],
+      "vcardArray": ["vcard", [["fn", {}, "text", "Abuse"], ["email", {}, "text", "network-abuse@google.com"]]]
+    }]
+  }]
+}`
+
+func TestRDAPLookup(t *testing.T) {
+	queries := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries++
+		if r.URL.Path != "/ip/8.8.8.8" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/rdap+json")
+		io.WriteString(w, arinNetwork)
+	}))
+	defer srv.Close()
+
+	e := &enrichment{whois: &rdapClient{base: srv.URL, client: srv.Client(), unknown: make(map[netip.Addr]bool)}}
+	hosts := []HostResult{hostWith("8.8.8.8", 53), hostWith("1.1.1.1"), hostWith("10.0.0.1", 22), hostWith("8.8.8.200")}
+	e.apply(context.Background(), hosts)
+
+	want := WhoisInfo{Network: "8.8.8.0 - 8.8.8.255", Name: "GOGL", Org: "Google LLC", Abuse: "network-abuse@google.com"}
+	if hosts[0].Whois == nil || *hosts[0].Whois != want {
+		t.Errorf("whois = %+v, want %+v", hosts[0].Whois, want)
+	}
+	if hosts[1].Whois != nil || hosts[2].Whois != nil {
+		t.Errorf("unexpected whois for unregistered or private hosts: %+v, %+v", hosts[1].Whois, hosts[2].Whois)
+	}
+	// 8.8.8.200 is inside the network already looked up.
+	if hosts[3].Whois == nil || hosts[3].Whois.Name != "GOGL" || queries != 2 {
+		t.Errorf("whois = %+v after %d queries", hosts[3].Whois, queries)
+	}
+	if s := hosts[0].Whois.String(); s != "GOGL, Google LLC [8.8.8.0 - 8.8.8.255]; abuse contact network-abuse@google.com" {
+		t.Errorf("String() = %q", s)
+	}
+}
@@ -0,0 +1,531 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schemaVersion is bumped whenever the structured report layout changes in
+// a way consumers need to know about.
+const schemaVersion = 1
+
+// outputFormats are the values accepted by --output.
+var outputFormats = []string{"text", "json", "syslog", "html"}
+
+func validOutput(format string) bool {
+	for _, f := range outputFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// Report is the structured result of one scan run. It records which build
+// produced it and the normalized parameters, so a result file stays
+// interpretable (and comparable with later runs) on its own.
+type Report struct {
+	SchemaVersion int               `json:"schema_version"`
+	ID            string            `json:"id,omitempty"`       // set for scans run by the server
+	Schedule      string            `json:"schedule,omitempty"` // the schedule that started the scan
+	Tags          map[string]string `json:"tags,omitempty"`
+	Scanner       buildInfo         `json:"scanner"`
+	Parameters    scanParams        `json:"parameters"`
+	StartedAt     time.Time         `json:"started_at"`
+	FinishedAt    time.Time         `json:"finished_at"`
+	Canceled      bool              `json:"canceled,omitempty"` // stopped before all probes ran
+	Hosts         []HostResult      `json:"hosts"`
+	// DiscoveredHostnames are the names the hosts' certificates gave that
+	// were not targets, for --cert-names and --follow.
+	DiscoveredHostnames []DiscoveredHostname `json:"discovered_hostnames,omitempty"`
+}
+
+// newReport wraps the results of a run of plan that began at started and
+// has just finished.
+func newReport(plan *scanPlan, profile string, started time.Time, hosts []HostResult, canceled bool) *Report {
+	if plan.severity != nil {
+		classify(hosts, plan.severity)
+	}
+	orderHosts(hosts, plan.hostOrder)
+	if !plan.listsState("open") {
+		for i := range hosts {
+			hosts[i].Ports = []PortResult{}
+		}
+	}
+	return &Report{
+		SchemaVersion: schemaVersion,
+		Tags:          plan.tags,
+		Scanner:       currentBuild(),
+		Parameters:    plan.params(profile),
+		StartedAt:     started.UTC(),
+		FinishedAt:    time.Now().UTC(),
+		Canceled:      canceled,
+		Hosts:         hosts,
+
+		DiscoveredHostnames: discoveredHostnames(hosts),
+	}
+}
+
+// orderHosts puts the open ports of each host in numeric order, TCP before
+// UDP, as the probes after the scan append the UDP ports they find; with
+// order "ip" it also sorts the hosts by address, IPv4 first, leaving
+// hostnames last in target order, and with "risk" by their most severe
+// port or finding, most severe first. The hosts come from the scan in
+// target order, and each probe lists its findings in an order of its own,
+// so identical scans give identical reports.
+func orderHosts(hosts []HostResult, order string) {
+	for i := range hosts {
+		slices.SortStableFunc(hosts[i].Ports, func(a, b PortResult) int {
+			return cmp.Or(cmp.Compare(a.Port, b.Port), strings.Compare(a.Protocol, b.Protocol))
+		})
+	}
+	switch order {
+	case "ip":
+		slices.SortStableFunc(hosts, func(a, b HostResult) int {
+			x, errX := netip.ParseAddr(a.Host)
+			y, errY := netip.ParseAddr(b.Host)
+			switch {
+			case errX != nil && errY != nil:
+				return 0
+			case errX != nil:
+				return 1
+			case errY != nil:
+				return -1
+			}
+			return x.Compare(y)
+		})
+	case "risk":
+		slices.SortStableFunc(hosts, func(a, b HostResult) int {
+			return cmp.Compare(hostRisk(b), hostRisk(a))
+		})
+	}
+}
+
+// scanParams are the effective scan settings after profiles and defaults
+// have been applied.
+type scanParams struct {
+	Targets     []string `json:"targets"`
+	TargetCount int      `json:"target_count"`
+	Ports       string   `json:"ports"`
+	PortCount   int      `json:"port_count"`
+	Workers     int      `json:"workers"`
+	Timeout     Duration `json:"timeout"`
+	HostTimeout Duration `json:"host_timeout"`
+	Delay       Duration `json:"delay"`
+	Profile     string   `json:"profile,omitempty"`
+	Proxy       string   `json:"proxy,omitempty"` // without credentials
+	Source      string   `json:"source,omitempty"`
+	Prefer      string   `json:"prefer,omitempty"` // address family policy
+	Routes      []string `json:"routes,omitempty"` // without passwords
+	QUIC        bool     `json:"quic,omitempty"`   // hosts were probed for QUIC
+	DTLS        bool     `json:"dtls,omitempty"`   // and for DTLS
+	VPN         bool     `json:"vpn,omitempty"`    // and for IKE and OpenVPN
+	UDPProbes   []string `json:"udp_probes,omitempty"`
+	OT          bool     `json:"ot,omitempty"`      // and for industrial devices
+	OTSafe      bool     `json:"ot_safe,omitempty"` // one at a time
+	Containers  bool     `json:"containers,omitempty"`
+	TCPProbes   []string `json:"tcp_probes,omitempty"`
+	Instances   bool     `json:"open_instances,omitempty"` // Elasticsearch, Kibana, Prometheus
+	Endpoints   bool     `json:"endpoints,omitempty"`
+	Honeypots   bool     `json:"honeypots,omitempty"`
+	SkipCDN     bool     `json:"skip_cdn,omitempty"`
+	Knock       string   `json:"knock,omitempty"`
+	KnockDelay  Duration `json:"knock_delay,omitempty"`
+	Payloads    []string `json:"payloads,omitempty"` // as given to --payload
+	Scripts     []string `json:"scripts,omitempty"`  // names of the --script scripts
+	Plugins     []string `json:"plugins,omitempty"`  // names of the --plugin plugins
+	States      []string `json:"states,omitempty"`   // the --state port states listed, unless open alone
+	Stats       bool     `json:"stats,omitempty"`
+	Retries     int      `json:"retries,omitempty"`
+	Force       bool     `json:"force,omitempty"`
+	// PortTimeouts are the --port-timeout overrides, as "25:3s,1433:2s".
+	PortTimeouts string `json:"port_timeouts,omitempty"`
+	// CalibratePort, MinTimeout and MaxTimeout are --calibrate-port and
+	// the bounds of the timeouts it picked.
+	CalibratePort int      `json:"calibrate_port,omitempty"`
+	MinTimeout    Duration `json:"min_timeout,omitempty"`
+	MaxTimeout    Duration `json:"max_timeout,omitempty"`
+	// SNMPHarvest is --snmp-harvest.
+	SNMPHarvest bool `json:"snmp_harvest,omitempty"`
+	// Follow is --follow.
+	Follow int `json:"follow,omitempty"`
+	// CertNames is --cert-names.
+	CertNames bool `json:"cert_names,omitempty"`
+	// VHosts is the --vhosts file.
+	VHosts string `json:"vhosts,omitempty"`
+	// Screenshots is the --screenshots directory.
+	Screenshots string `json:"screenshots,omitempty"`
+	// HTTPPaths are the --http-paths paths.
+	HTTPPaths []string `json:"http_paths,omitempty"`
+	// SafeChecks is --safe-checks.
+	SafeChecks bool `json:"safe_checks,omitempty"`
+	// Severity is --severity: "default", or the file of rules used with
+	// the embedded ones.
+	Severity string `json:"severity,omitempty"`
+}
+
+func (p *scanPlan) params(profile string) scanParams {
+	targets := make([]string, len(p.targets))
+	for i, t := range p.targets {
+		targets[i] = t.String()
+	}
+	var source string
+	if p.source != nil {
+		source = p.source.String()
+	}
+	var payloads []string
+	for _, pp := range p.payloads {
+		payloads = append(payloads, pp.spec)
+	}
+	var scripts []string
+	for _, s := range p.scripts {
+		scripts = append(scripts, s.name)
+	}
+	var plugins []string
+	for _, w := range p.plugins {
+		plugins = append(plugins, w.name)
+	}
+	var states []string
+	if !slices.Equal(p.states, []string{"open"}) {
+		states = p.states
+	}
+	var knockDelay Duration
+	if len(p.knock) > 0 {
+		knockDelay = Duration(p.knockDelay)
+	}
+	var minTimeout, maxTimeout Duration
+	if p.calibratePort != 0 {
+		minTimeout, maxTimeout = Duration(p.minTimeout), Duration(p.maxTimeout)
+	}
+	return scanParams{
+		Targets:       targets,
+		TargetCount:   p.numTargets,
+		Ports:         formatPorts(p.ports),
+		PortCount:     len(p.ports),
+		Workers:       p.workers,
+		Timeout:       Duration(p.timeout),
+		HostTimeout:   Duration(p.hostTimeout),
+		Delay:         Duration(p.delay),
+		Profile:       profile,
+		Proxy:         p.proxyURL,
+		Source:        source,
+		Prefer:        p.prefer,
+		Routes:        p.routes,
+		QUIC:          p.quic,
+		DTLS:          p.dtls,
+		VPN:           p.vpn,
+		UDPProbes:     p.udpProbes,
+		OT:            p.ot,
+		OTSafe:        p.otSafe,
+		Containers:    p.containers,
+		TCPProbes:     p.tcpProbes,
+		Instances:     p.instances,
+		Endpoints:     p.endpoints,
+		Honeypots:     p.honeypots,
+		SkipCDN:       p.skipCDN,
+		Knock:         formatKnock(p.knock),
+		KnockDelay:    knockDelay,
+		Payloads:      payloads,
+		Scripts:       scripts,
+		Plugins:       plugins,
+		States:        states,
+		Stats:         p.stats,
+		Retries:       p.retries,
+		Force:         p.force,
+		PortTimeouts:  formatPortTimeouts(p.portTimeouts),
+		CalibratePort: p.calibratePort,
+		MinTimeout:    minTimeout,
+		MaxTimeout:    maxTimeout,
+		SNMPHarvest:   p.snmpCommunity != "",
+		Follow:        p.follow,
+		CertNames:     p.certNames,
+		VHosts:        p.vhostsPath,
+		Screenshots:   p.screenshots,
+		HTTPPaths:     p.httpPaths,
+		SafeChecks:    p.safeChecks,
+		Severity:      p.severityPath,
+	}
+}
+
+// writeReport renders r in the given --output format.
+func writeReport(w io.Writer, format string, r *Report) error {
+	switch format {
+	case "text":
+		return writeText(w, r)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	case "syslog":
+		return writeSyslog(w, r)
+	case "html":
+		return htmlReport.Execute(w, webhookPayload{Report: r})
+	}
+	if plugin, ok := outputPlugins[format]; ok {
+		return plugin.writeOutput(w, r)
+	}
+	return fmt.Errorf("unknown output format %q", format)
+}
+
+func writeText(w io.Writer, r *Report) error {
+	p := r.Parameters
+	if p.TargetCount == 1 {
+		fmt.Fprintf(w, "Host: %s\n", r.Hosts[0].Host)
+	} else {
+		fmt.Fprintf(w, "Hosts: %d\n", p.TargetCount)
+	}
+	fmt.Fprintf(w, "Scanned ports: %d\n", p.PortCount)
+	fmt.Fprintf(w, "Workers used: %d\n", p.Workers)
+	fmt.Fprintf(w, "Timeout: %s\n", time.Duration(p.Timeout))
+	if p.PortTimeouts != "" {
+		fmt.Fprintf(w, "Port timeouts: %s\n", p.PortTimeouts)
+	}
+	if p.CalibratePort != 0 {
+		fmt.Fprintf(w, "Calibration: port %d, timeouts between %s and %s\n", p.CalibratePort, time.Duration(p.MinTimeout), time.Duration(p.MaxTimeout))
+	}
+	if p.Retries > 0 {
+		fmt.Fprintf(w, "Retries: %d\n", p.Retries)
+	}
+	if len(r.Tags) > 0 {
+		fmt.Fprintf(w, "Tags: %s\n", formatTags(r.Tags))
+	}
+	if r.Canceled {
+		fmt.Fprintln(w, "Scan stopped early; results are incomplete")
+	}
+	if e := sumDialErrors(r.Hosts); e != nil {
+		fmt.Fprintf(w, "Failed connects: %s\n", e)
+		if n := e.local(); n > 0 {
+			fmt.Fprintf(w, "Warning: %d probes failed on this machine, saying nothing of their ports; results are incomplete\n", n)
+		}
+	}
+	filtered, failing, unresolved := 0, 0, 0
+	for _, h := range r.Hosts {
+		if h.LookupError != "" {
+			unresolved++
+		}
+		if h.Stats != nil && h.Stats.AllFiltered() {
+			filtered++
+		}
+		if h.Stats != nil && h.Stats.ErrorRate() > statsErrorRate {
+			failing++
+		}
+	}
+	if unresolved > 0 {
+		fmt.Fprintf(w, "Warning: %d hostnames could not be looked up and were not scanned\n", unresolved)
+	}
+	if filtered > 0 {
+		fmt.Fprintf(w, "Warning: %d hosts filtered every probe\n", filtered)
+	}
+	if failing > 0 {
+		fmt.Fprintf(w, "Warning: more than %d%% of the probes failed on %d hosts\n", int(100*statsErrorRate), failing)
+	}
+	if n := unauthenticatedInstances(r.Hosts); n > 0 {
+		fmt.Fprintf(w, "Warning: %d Elasticsearch, Kibana or Prometheus instances answer without credentials\n", n)
+	}
+	if n := sameHostNames(r.Hosts); n > 0 {
+		fmt.Fprintf(w, "Warning: %d hostnames resolve to addresses scanned as well; the ports of each machine are listed under both\n", n)
+	}
+	if p.Severity != "" {
+		fmt.Fprintf(w, "Open ports by severity: %s\n", severityCounts(r.Hosts))
+	}
+	if n := highFindings(r.Hosts); n > 0 {
+		fmt.Fprintf(w, "Warning: %d findings of high severity\n", n)
+	}
+	for _, h := range r.Hosts {
+		switch {
+		case h.Family != "":
+			fmt.Fprintf(w, "\nHost: %s over %s\n", h.Host, h.Family)
+		case p.TargetCount > 1:
+			fmt.Fprintf(w, "\nHost: %s\n", h.Host)
+		}
+		if h.LookupError != "" {
+			fmt.Fprintf(w, "Lookup failed: %s; the host was not scanned\n", h.LookupError)
+		}
+		if len(h.SameAs) > 0 {
+			fmt.Fprintf(w, "Same machine as: %s\n", strings.Join(h.SameAs, ", "))
+		}
+		if h.TimedOut {
+			fmt.Fprintf(w, "Host timeout reached after %s; results are incomplete\n", time.Duration(p.HostTimeout))
+		}
+		if h.ProbeErrors > 0 {
+			fmt.Fprintf(w, "%d probes failed; results are incomplete\n", h.ProbeErrors)
+		}
+		switch {
+		case h.Down && p.Force:
+			fmt.Fprintf(w, "Host appears down or fully filtered: its first %d probes timed out\n", downProbes)
+		case h.Down:
+			fmt.Fprintf(w, "Host appears down or fully filtered: its first %d probes timed out, and its other ports were skipped (--force probes them)\n", downProbes)
+		}
+		if h.Whois != nil {
+			fmt.Fprintf(w, "Whois: %s\n", h.Whois)
+		}
+		if h.CDN != "" {
+			fmt.Fprintf(w, "CDN: %s edge; its open ports are the CDN's, not the origin's\n", h.CDN)
+		}
+		if h.Honeypot != nil {
+			fmt.Fprintf(w, "Honeypot: %s\n", h.Honeypot)
+		}
+		if h.Firewall != nil {
+			fmt.Fprintf(w, "Firewall: %s\n", h.Firewall)
+		}
+		switch h.LearnedBy {
+		case "snmp":
+			fmt.Fprintf(w, "Learned from the neighbour tables of %s over SNMP\n", h.LearnedFrom)
+		case "certificate":
+			fmt.Fprintf(w, "Learned from the certificate of %s\n", h.LearnedFrom)
+		case "arp":
+			fmt.Fprintln(w, "Learned from the scanner's ARP table")
+		}
+		if h.SNMP != nil {
+			fmt.Fprintf(w, "SNMP: %s\n", h.SNMP)
+		}
+		if h.Calibration != nil {
+			fmt.Fprintf(w, "Timeout: %s\n", h.Calibration)
+		}
+		if h.Stats != nil {
+			fmt.Fprintf(w, "Stats: %s\n", h.Stats)
+		}
+		if len(h.Route) > 0 {
+			fmt.Fprintf(w, "Route: %s\n", formatRoute(h.Route))
+		}
+		if h.QUIC != nil {
+			fmt.Fprintf(w, "QUIC: %s\n", h.QUIC)
+		}
+		for _, d := range h.DTLS {
+			fmt.Fprintf(w, "DTLS: %s\n", d)
+		}
+		for _, v := range h.VPN {
+			fmt.Fprintf(w, "VPN: %s\n", v)
+		}
+		for _, s := range h.UDP {
+			fmt.Fprintf(w, "UDP: %s\n", s)
+		}
+		for _, s := range h.TCP {
+			fmt.Fprintf(w, "TCP: %s\n", s)
+		}
+		for _, d := range h.OT {
+			fmt.Fprintf(w, "OT: %s\n", d)
+		}
+		for _, c := range h.Containers {
+			fmt.Fprintf(w, "Containers: %s\n", c)
+		}
+		for _, o := range h.OpenInstances {
+			fmt.Fprintf(w, "Open instance: %s\n", o)
+		}
+		for _, e := range h.Endpoints {
+			fmt.Fprintf(w, "Endpoint: %s\n", e)
+		}
+		for _, v := range h.VHosts {
+			fmt.Fprintf(w, "Virtual host: %s\n", v)
+		}
+		for _, f := range h.Findings {
+			fmt.Fprintf(w, "Finding: %s\n", f)
+		}
+		for _, a := range h.HTTPAuth {
+			fmt.Fprintf(w, "HTTP auth: %s\n", a)
+		}
+		for _, a := range h.HTTPPaths {
+			fmt.Fprintf(w, "HTTP path: %s\n", a)
+		}
+		for _, s := range h.Screenshots {
+			fmt.Fprintf(w, "Screenshot: %s\n", s)
+		}
+		for _, r := range h.Payloads {
+			fmt.Fprintf(w, "Payload: %s\n", r)
+		}
+		for _, r := range h.Scripts {
+			for _, line := range r.Output {
+				fmt.Fprintf(w, "Script: %s tcp/%d: %s\n", r.Script, r.Port, line)
+			}
+		}
+		switch {
+		case !p.lists("open"):
+		case h.Honeypot != nil:
+			// Listing every port a honeypot pretends to have open would
+			// bury the rest of the report.
+			fmt.Fprintf(w, "Open ports: %d, not listed for a likely %s\n", len(h.Ports), h.Honeypot.Verdict)
+		default:
+			fmt.Fprintln(w, "Open ports:")
+			if len(h.Ports) == 0 {
+				fmt.Fprintln(w, "  (none found)")
+			}
+			for _, pr := range h.Ports {
+				line := fmt.Sprintf("  %d", pr.Port)
+				switch {
+				case pr.Protocol != "tcp":
+					line = fmt.Sprintf("  %d/%s %s", pr.Port, pr.Protocol, pr.Service)
+				case pr.Attempts > 1:
+					line += fmt.Sprintf(" (after %d attempts)", pr.Attempts)
+				}
+				if pr.Severity != "" {
+					line += " [" + pr.Severity + "]"
+				}
+				fmt.Fprintln(w, line)
+			}
+		}
+		if p.lists("closed") {
+			fmt.Fprintf(w, "Closed ports: %s\n", orNone(h.Closed))
+		}
+		if p.lists("filtered") {
+			fmt.Fprintf(w, "Filtered ports: %s\n", orNone(h.Filtered))
+		}
+		for _, tp := range h.ThirdParty {
+			writeThirdParty(w, tp)
+		}
+	}
+	writeDiscoveredHostnames(w, r.DiscoveredHostnames)
+	return nil
+}
+
+// lists says whether the report lists ports in state, for --state.
+func (p scanParams) lists(state string) bool {
+	if p.States == nil {
+		return state == "open"
+	}
+	return slices.Contains(p.States, state)
+}
+
+func orNone(ports string) string {
+	if ports == "" {
+		return "none"
+	}
+	return ports
+}
+
+// writeThirdParty prints what an --enrich service knows about a host,
+// labelled so that it is not mistaken for scan results.
+func writeThirdParty(w io.Writer, tp ThirdPartyInfo) {
+	fmt.Fprintf(w, "Third-party data from %s (not seen by this scan):\n", tp.Source)
+	if tp.Org != "" || tp.ASN != "" {
+		fmt.Fprintf(w, "  Organization: %s\n", strings.TrimSpace(tp.Org+" "+tp.ASN))
+	}
+	if len(tp.Hostnames) > 0 {
+		fmt.Fprintf(w, "  Hostnames: %s\n", strings.Join(tp.Hostnames, ", "))
+	}
+	if len(tp.Services) > 0 {
+		known := make([]string, len(tp.Services))
+		for i, s := range tp.Services {
+			known[i] = strconv.Itoa(s.Port)
+			if s.Transport != "" && s.Transport != "tcp" {
+				known[i] += "/" + s.Transport
+			}
+			if s.Name != "" && s.Name != "unknown" {
+				known[i] += " (" + s.Name + ")"
+			}
+		}
+		fmt.Fprintf(w, "  Known ports: %s\n", strings.Join(known, ", "))
+	}
+	if len(tp.Tags) > 0 {
+		fmt.Fprintf(w, "  Tags: %s\n", strings.Join(tp.Tags, ", "))
+	}
+	if tp.Updated != "" {
+		fmt.Fprintf(w, "  Last seen: %s\n", tp.Updated)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONReportMetadata(t *testing.T) {
+	targets, err := parseTargets("10.0.0.0/31,example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := &scanPlan{targets: targets, numTargets: 3, ports: []int{22, 80, 81}, workers: 9, timeout: 750 * time.Millisecond}
+	r := &Report{
+		SchemaVersion: schemaVersion,
+		Scanner:       currentBuild(),
+		Parameters:    plan.params("quick"),
+		Hosts:         []HostResult{{Host: "10.0.0.0", Ports: []PortResult{{Port: 22, Protocol: "tcp", State: "open"}}}},
+	}
+	var buf bytes.Buffer
+	if err := writeReport(&buf, "json", r); err != nil {
+		t.Fatal(err)
+	}
+
+	var got Report
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("report does not round-trip: %v", err)
+	}
+	if got.SchemaVersion != schemaVersion || got.Scanner.Version == "" || got.Scanner.GoVersion == "" {
+		t.Errorf("missing build metadata: %+v", got)
+	}
+	p := got.Parameters
+	if strings.Join(p.Targets, " ") != "10.0.0.0/31 example.com" || p.Ports != "22,80-81" || p.PortCount != 3 ||
+		time.Duration(p.Timeout) != 750*time.Millisecond || p.Profile != "quick" {
+		t.Errorf("unexpected parameters: %+v", p)
+	}
+}
+
+func TestOrderHosts(t *testing.T) {
+	hosts := []HostResult{
+		{Host: "example.com", Family: "ipv6"},
+		{Host: "10.0.0.10", Ports: []PortResult{{Port: 80, Protocol: "tcp"}, {Port: 443, Protocol: "tcp"}, {Port: 500, Protocol: "udp"}, {Port: 443, Protocol: "udp"}, {Port: 53, Protocol: "udp"}}},
+		{Host: "2001:db8::1"},
+		{Host: "example.com", Family: "ipv4"},
+		{Host: "10.0.0.9"},
+		{Host: "example.org"},
+	}
+	orderHosts(hosts, "input")
+	var ports []string
+	for _, pr := range hosts[1].Ports {
+		ports = append(ports, strconv.Itoa(pr.Port)+"/"+pr.Protocol)
+	}
+	if got := strings.Join(ports, " "); got != "53/udp 80/tcp 443/tcp 443/udp 500/udp" {
+		t.Errorf("ports = %s", got)
+	}
+	if hosts[0].Host != "example.com" || hosts[1].Host != "10.0.0.10" {
+		t.Errorf("input order changed: %+v", hosts)
+	}
+
+	orderHosts(hosts, "ip")
+	var order []string
+	for _, h := range hosts {
+		order = append(order, h.Host+" "+h.Family)
+	}
+	want := "10.0.0.9 ,10.0.0.10 ,2001:db8::1 ,example.com ipv6,example.com ipv4,example.org "
+	if got := strings.Join(order, ","); got != want {
+		t.Errorf("ip order = %s\nwant %s", got, want)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var resumeDoc = &commandDoc{
+	synopsis: "pscanner resume <checkpoint>",
+	description: `Finish a scan interrupted with Ctrl-C.
+
+A scan run with --checkpoint saves, when it is interrupted, its options,
+the results found so far and the probes it had not yet made. resume runs
+those probes with the same options and reports the whole scan, as the scan
+would have. Interrupted again, it saves a new checkpoint in the same file;
+once it finishes, the file is removed.
+
+The options are read again as they were given, so relative paths and the
+config file are taken from where resume runs. Probes that were under way
+at the interruption are made again.`,
+	examples: []string{
+		"pscanner scan --host 10.0.0.0/16 --top-ports 1000 --checkpoint scan.ckpt",
+		"pscanner resume scan.ckpt",
+	},
+}
+
+// checkpointVersion is the format of --checkpoint files.
+const checkpointVersion = 1
+
+// checkpoint is an interrupted scan, as --checkpoint saves it.
+type checkpoint struct {
+	Version int `json:"version"`
+	// Args are the arguments "pscanner scan" was given.
+	Args    []string  `json:"args"`
+	Started time.Time `json:"started"`
+	// Pending holds the ports of each target still to probe.
+	Pending map[string]string `json:"pending"`
+	// Hosts are the results of the probes made.
+	Hosts []HostResult `json:"hosts"`
+}
+
+// probeTracker records the probes of a scan that are done, made or
+// skipped, for --checkpoint. A nil *probeTracker records nothing.
+type probeTracker struct {
+	mu   sync.Mutex
+	done map[string]map[int]bool // by host key and port
+}
+
+func newProbeTracker() *probeTracker {
+	return &probeTracker{done: make(map[string]map[int]bool)}
+}
+
+func (t *probeTracker) add(j job) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	k := j.key()
+	if t.done[k] == nil {
+		t.done[k] = make(map[int]bool)
+	}
+	t.done[k][j.port] = true
+}
+
+// pending lists the ports of each target of p that are not done over
+// every family, and counts them.
+func (t *probeTracker) pending(p *scanPlan) (map[string]string, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	pending := make(map[string]string)
+	n := 0
+	p.targets.each(func(h string) bool {
+		var ports []int
+		for _, port := range p.portsFor(h) {
+			for _, family := range p.families(h) {
+				if !t.done[hostKey(h, family)][port] {
+					ports = append(ports, port)
+					break
+				}
+			}
+		}
+		if len(ports) > 0 {
+			pending[h] = formatPorts(ports)
+			n += len(ports)
+		}
+		return true
+	})
+	return pending, n
+}
+
+// saveCheckpoint writes the state of the interrupted scan of p to path.
+func saveCheckpoint(path string, args []string, started time.Time, p *scanPlan, hosts []HostResult) (int, error) {
+	pending, n := p.tracker.pending(p)
+	b, err := json.MarshalIndent(checkpoint{Version: checkpointVersion, Args: args, Started: started, Pending: pending, Hosts: hosts}, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	return n, os.WriteFile(path, append(b, '\n'), 0o600)
+}
+
+func loadCheckpoint(path string) (*checkpoint, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c checkpoint
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if c.Version != checkpointVersion {
+		return nil, fmt.Errorf("%s: unsupported checkpoint version %d", path, c.Version)
+	}
+	return &c, nil
+}
+
+// pendingPorts returns the ports of each target left to probe, for
+// scanPlan.hostPorts.
+func (c *checkpoint) pendingPorts() (map[string][]int, error) {
+	ports := make(map[string][]int, len(c.Pending))
+	for h, spec := range c.Pending {
+		list, err := parsePorts(spec, nil)
+		if err != nil {
+			return nil, fmt.Errorf("pending ports of %s: %v", h, err)
+		}
+		ports[h] = list
+	}
+	return ports, nil
+}
+
+// merge adds the results found before the interruption to hosts, those
+// of the probes that were still pending.
+func (c *checkpoint) merge(hosts []HostResult) []HostResult {
+	earlier := make(map[string]HostResult, len(c.Hosts))
+	for _, h := range c.Hosts {
+		earlier[hostKey(h.Host, h.Family)] = h
+	}
+	for i := range hosts {
+		h := &hosts[i]
+		e, ok := earlier[hostKey(h.Host, h.Family)]
+		if !ok {
+			continue
+		}
+		for _, pr := range e.Ports {
+			if !slices.ContainsFunc(h.Ports, func(q PortResult) bool { return q.Port == pr.Port && q.Protocol == pr.Protocol }) {
+				h.Ports = append(h.Ports, pr)
+			}
+		}
+		slices.SortFunc(h.Ports, func(a, b PortResult) int { return a.Port - b.Port })
+		// Probed is the scan's, not the pending ports of the resumed run.
+		h.Probed = e.Probed
+		h.Closed = joinPorts(e.Closed, h.Closed)
+		h.Filtered = joinPorts(e.Filtered, h.Filtered)
+		h.TimedOut = h.TimedOut || e.TimedOut
+		h.Down = h.Down || e.Down
+		h.ProbeErrors += e.ProbeErrors
+		if e.DialErrors != nil {
+			if h.DialErrors == nil {
+				h.DialErrors = new(DialErrors)
+			}
+			h.DialErrors.merge(e.DialErrors)
+		}
+		if h.LookupError == "" {
+			h.LookupError = e.LookupError
+		}
+	}
+	return hosts
+}
+
+// joinPorts returns the union of two port lists as formatPorts writes them.
+func joinPorts(a, b string) string {
+	switch {
+	case a == "":
+		return b
+	case b == "":
+		return a
+	}
+	ports, err := parsePorts(a+","+b, nil)
+	if err != nil {
+		return a + "," + b
+	}
+	return formatPorts(ports)
+}
+
+// runResume implements the "resume" command.
+func runResume(args []string) {
+	if len(args) != 1 || strings.HasPrefix(args[0], "-") {
+		writeCommandHelp(os.Stderr, "resume", nil, resumeDoc, false)
+		if len(args) == 1 && (args[0] == "-h" || args[0] == "-help" || args[0] == "--help") {
+			return
+		}
+		os.Exit(2)
+	}
+	c, err := loadCheckpoint(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	var o scanOptions
+	fs := o.flagSet()
+	_ = fs.Parse(c.Args)
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	// The checkpoint is saved again where it was found.
+	o.args, o.checkpoint, o.resumed = c.Args, args[0], c
+	o.execute(fs, set)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCheckpoint(t *testing.T) {
+	open := serveTCP(t, func(conn net.Conn) {})
+	closed := closedPort(t)
+	targets, _ := parseTargets("127.0.0.1")
+	newPlan := func() *scanPlan {
+		return &scanPlan{targets: targets, numTargets: 1, ports: []int{open, closed}, workers: 2, timeout: time.Second, states: []string{"open", "closed"}, tracker: newProbeTracker()}
+	}
+
+	// Interrupted before any probe, the scan leaves them all pending.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p := newPlan()
+	p.run(ctx, scanHooks{})
+	earlier := []HostResult{{Host: "127.0.0.1", Ports: []PortResult{{Port: 7, Protocol: "tcp", State: "open"}}, Closed: "9", ProbeErrors: 1}}
+	path := filepath.Join(t.TempDir(), "scan.ckpt")
+	started := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+	n, err := saveCheckpoint(path, []string{"--host", "127.0.0.1"}, started, p, earlier)
+	if err != nil || n != 2 {
+		t.Fatalf("saveCheckpoint = %d, %v; want 2 probes left", n, err)
+	}
+	c, err := loadCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Started.Equal(started) || !slices.Equal(c.Args, []string{"--host", "127.0.0.1"}) {
+		t.Errorf("checkpoint = %+v", c)
+	}
+
+	// Resumed, it probes the pending ports and reports the earlier ones too.
+	p = newPlan()
+	if p.hostPorts, err = c.pendingPorts(); err != nil {
+		t.Fatal(err)
+	}
+	hosts := c.merge(p.run(context.Background(), scanHooks{}))
+	if len(hosts) != 1 {
+		t.Fatalf("hosts = %+v", hosts)
+	}
+	h := hosts[0]
+	if len(h.Ports) != 2 || h.Ports[0].Port != 7 || h.Ports[1].Port != open {
+		t.Errorf("ports = %+v, want 7 and %d", h.Ports, open)
+	}
+	if want := joinPorts("9", fmt.Sprint(closed)); h.Closed != want || h.ProbeErrors != 1 || h.Probed != "" {
+		t.Errorf("resumed host = %+v, want closed ports %s", h, want)
+	}
+	if pending, n := p.tracker.pending(p); n != 0 {
+		t.Errorf("finished scan left %v pending", pending)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"version":2}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadCheckpoint(path); err == nil {
+		t.Error("checkpoint of an unknown version loaded")
+	}
+}
+
+func TestJoinPorts(t *testing.T) {
+	for _, tt := range []struct{ a, b, want string }{
+		{"", "", ""},
+		{"22", "", "22"},
+		{"", "80", "80"},
+		{"21-23,80", "24,443", "21-24,80,443"},
+	} {
+		if got := joinPorts(tt.a, tt.b); got != tt.want {
+			t.Errorf("joinPorts(%q, %q) = %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"net/netip"
+	"os"
+)
+
+// defaultGateways returns the default gateway of each interface that has
+// one.
+func defaultGateways() map[string]netip.Addr {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	return parseProcRoute(f)
+}
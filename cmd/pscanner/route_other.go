@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "net/netip"
+
+// defaultGateways returns nil: reading the routing table is only done on
+// Linux, so --local-net elsewhere scans the attached segments alone.
+func defaultGateways() map[string]netip.Addr { return nil }
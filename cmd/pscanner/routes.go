@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// Route sends the probes of some targets through a proxy chain or an SSH
+// jump host, as configured under "routes".
+type Route struct {
+	// To is a CIDR block, an IP address or a domain, which covers its
+	// subdomains as well.
+	To string `json:"to"`
+	// Via is a --proxy URL or chain, an ssh://user@host[:port] jump host
+	// (possibly at the end of a chain), or "direct".
+	Via string `json:"via"`
+}
+
+// targetRoute is a parsed Route.
+type targetRoute struct {
+	prefix netip.Prefix // for CIDR blocks and IPs
+	domain string       // for domains, lower case without a leading dot
+	via    contextDialer
+	name   string // Via without passwords, for display
+}
+
+func (r *targetRoute) String() string {
+	to := r.domain
+	if to == "" {
+		to = r.prefix.String()
+	}
+	return to + " via " + r.name
+}
+
+// routeDialer dials each target the way the most specific route for it
+// says: the longest matching prefix, or the longest matching domain.
+// Targets no route covers go through fallback.
+type routeDialer struct {
+	routes   []*targetRoute
+	fallback contextDialer
+}
+
+// newRouteDialer parses routes. The first hop of every route, and direct
+// connections, leave through first; fallback is the dialer for other
+// targets.
+func newRouteDialer(routes []Route, first, fallback contextDialer) (*routeDialer, error) {
+	if first == nil {
+		first = &net.Dialer{}
+	}
+	d := &routeDialer{fallback: fallback}
+	for _, r := range routes {
+		tr := &targetRoute{name: "direct", via: first}
+		to := strings.TrimSpace(r.To)
+		if p, err := netip.ParsePrefix(to); err == nil {
+			tr.prefix = p.Masked()
+		} else if a, err := netip.ParseAddr(to); err == nil {
+			tr.prefix = netip.PrefixFrom(a, a.BitLen())
+		} else if to = strings.ToLower(strings.Trim(to, ".*")); to != "" && !strings.ContainsAny(to, "/:") {
+			tr.domain = to
+		} else {
+			return nil, fmt.Errorf("route to %q: want a CIDR block, an IP address or a domain", r.To)
+		}
+		if via := strings.TrimSpace(r.Via); via != "direct" {
+			var err error
+			if tr.via, err = parseVia(via, first); err != nil {
+				return nil, fmt.Errorf("route to %s: %v", r.To, err)
+			}
+			tr.name = redactProxy(via)
+		}
+		d.routes = append(d.routes, tr)
+	}
+	return d, nil
+}
+
+// parseVia parses a chain of proxies that may end in an SSH jump host.
+func parseVia(spec string, first contextDialer) (contextDialer, error) {
+	d := first
+	hops := strings.Split(spec, ",")
+	for i, hop := range hops {
+		hop = strings.TrimSpace(hop)
+		var err error
+		if strings.HasPrefix(hop, "ssh://") {
+			if i != len(hops)-1 {
+				return nil, fmt.Errorf("an ssh:// jump host must be the last hop of %q", spec)
+			}
+			d, err = parseSSHTarget(hop, d)
+		} else {
+			d, err = parseProxy(hop, d)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// route returns the route for host, or nil. IP addresses match prefixes
+// and hostnames match domains; hostnames are not resolved here, as the
+// names behind a pivot may only resolve on its far side.
+func (d *routeDialer) route(host string) *targetRoute {
+	var best *targetRoute
+	if a, err := netip.ParseAddr(host); err == nil {
+		a = a.Unmap()
+		for _, r := range d.routes {
+			if r.prefix.IsValid() && r.prefix.Contains(a) && (best == nil || r.prefix.Bits() > best.prefix.Bits()) {
+				best = r
+			}
+		}
+		return best
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, r := range d.routes {
+		if r.domain != "" && (host == r.domain || strings.HasSuffix(host, "."+r.domain)) && (best == nil || len(r.domain) > len(best.domain)) {
+			best = r
+		}
+	}
+	return best
+}
+
+// covers reports whether a route applies to any address or name of l.
+func (d *routeDialer) covers(l targetList) bool {
+	for _, t := range l {
+		for _, r := range d.routes {
+			if t.name == "" && r.prefix.IsValid() && r.prefix.Overlaps(t.prefix) || t.name != "" && d.route(t.name) != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (d *routeDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if r := d.route(host); r != nil {
+		return r.via.DialContext(ctx, network, addr)
+	}
+	return d.fallback.DialContext(ctx, network, addr)
+}
+
+// sshTunnels returns the SSH jump hosts d may dial through, to connect
+// them before the scan and close them after.
+func sshTunnels(d contextDialer) []*sshTunnel {
+	switch d := d.(type) {
+	case *sshTunnel:
+		return []*sshTunnel{d}
+	case *routeDialer:
+		tunnels := sshTunnels(d.fallback)
+		for _, r := range d.routes {
+			tunnels = append(tunnels, sshTunnels(r.via)...)
+		}
+		return tunnels
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Finding is a default left in place that --safe-checks found on an open
+// web port: an admin console or data that answers without credentials, or
+// a login page known for its default password.
+type Finding struct {
+	Port int `json:"port"`
+	// Check is the name of the check that found it.
+	Check string `json:"check"`
+	// Severity is high, medium or low.
+	Severity string `json:"severity"`
+	TLS      bool   `json:"tls,omitempty"`
+	Path     string `json:"path"`
+	Version  string `json:"version,omitempty"`
+	Details  string `json:"details"`
+}
+
+func (f Finding) String() string {
+	s := fmt.Sprintf("[%s] tcp/%d %s", f.Severity, f.Port, f.Check)
+	if f.Version != "" {
+		s += " " + f.Version
+	}
+	if f.TLS {
+		s += " over TLS"
+	}
+	return s + fmt.Sprintf(": %s %s", f.Path, f.Details)
+}
+
+// safeChecks are the checks of --safe-checks, in the order they are made.
+// Each makes a few GET requests and returns nil if the port runs
+// something else, or nothing worth reporting.
+var safeChecks = []struct {
+	name  string
+	check func(context.Context, *httpEndpoint) (*Finding, error)
+}{
+	{"jenkins", jenkinsCheck},
+	{"tomcat-manager", tomcatManagerCheck},
+	{"grafana", grafanaCheck},
+	{"spring-actuator", actuatorCheck},
+}
+
+// probeSafeChecks makes the --safe-checks of the open ports of @web. They
+// only read, and never send credentials.
+func (p *scanPlan) probeSafeChecks(ctx context.Context, hosts []HostResult) {
+	if !p.safeChecks {
+		return
+	}
+	ports, _ := parsePorts(builtinGroups["web"], nil)
+	dns := newDNSCache(p.dnsCache)
+	sem := make(chan struct{}, quicParallel)
+	var wg sync.WaitGroup
+	for i := range hosts {
+		h := &hosts[i]
+		sem <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			for _, pr := range h.Ports {
+				if pr.Protocol == "udp" || !slices.Contains(ports, pr.Port) {
+					continue
+				}
+				for _, c := range safeChecks {
+					f, _, err := httpCheck(ctx, p, dns, h, pr.Port, pr.Port%1000 == 443, c.check)
+					if err != nil {
+						slog.Warn("safe check failed", "host", h.Host, "port", pr.Port, "check", c.name, "err", err)
+						break
+					}
+					if f != nil {
+						f.Port, f.Check = pr.Port, c.name
+						h.Findings = append(h.Findings, *f)
+					}
+				}
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// highFindings counts the findings of hosts of high severity, which
+// reports warn of first.
+func highFindings(hosts []HostResult) int {
+	n := 0
+	for _, h := range hosts {
+		for _, f := range h.Findings {
+			if f.Severity == severityHigh {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// page requests path and returns the answer and its body.
+func (c *httpEndpoint) page(ctx context.Context, path string) (*http.Response, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base+path, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c.roundTrip(req)
+}
+
+// finding is a finding of c at path.
+func (c *httpEndpoint) finding(severity, path, version, details string) *Finding {
+	return &Finding{Severity: severity, TLS: strings.HasPrefix(c.base, "https:"), Path: path, Version: version, Details: details}
+}
+
+// jenkinsCheck finds a Jenkins controller by its X-Jenkins header, then
+// whether its script console, which runs Groovy on the controller, or its
+// jobs are open to anonymous users.
+func jenkinsCheck(ctx context.Context, c *httpEndpoint) (*Finding, error) {
+	resp, _, err := c.page(ctx, "/")
+	if err != nil || c.wrongScheme {
+		return nil, err
+	}
+	version := resp.Header.Get("X-Jenkins")
+	if version == "" {
+		return nil, nil
+	}
+	script, body, err := c.page(ctx, "/script")
+	if err == nil && script.StatusCode == http.StatusOK && bytes.Contains(body, []byte("Script Console")) {
+		return c.finding(severityHigh, "/script", version, "script console open to anonymous users; it runs any Groovy code on the controller"), nil
+	}
+	if resp.StatusCode == http.StatusOK {
+		return c.finding(severityMedium, "/", version, "jobs and builds readable by anonymous users"), nil
+	}
+	return nil, nil
+}
+
+// tomcatManagerCheck finds the Tomcat manager application, which deploys
+// WAR files and so runs code: open without credentials, or asking for the
+// credentials its users often leave at a default.
+func tomcatManagerCheck(ctx context.Context, c *httpEndpoint) (*Finding, error) {
+	const path = "/manager/html"
+	resp, body, err := c.page(ctx, path)
+	if err != nil || c.wrongScheme {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusOK && bytes.Contains(body, []byte("Tomcat Web Application Manager")):
+		return c.finding(severityHigh, path, "", "manager open without credentials; it deploys WAR files"), nil
+	case resp.StatusCode == http.StatusUnauthorized && strings.Contains(resp.Header.Get("WWW-Authenticate"), "Tomcat Manager"):
+		return c.finding(severityMedium, path, "", "manager asks for Basic credentials, often left at tomcat/tomcat or admin/admin"), nil
+	case resp.StatusCode == http.StatusForbidden && bytes.Contains(body, []byte("manager-gui")):
+		return c.finding(severityLow, path, "", "manager present, refused to this address"), nil
+	}
+	return nil, nil
+}
+
+// grafanaCheck finds Grafana by its health endpoint, then whether
+// anonymous users may search its dashboards, or else its login page, whose
+// admin password is admin until changed.
+func grafanaCheck(ctx context.Context, c *httpEndpoint) (*Finding, error) {
+	var health struct {
+		Database string `json:"database"`
+		Version  string `json:"version"`
+	}
+	status, err := c.do(ctx, "/api/health", nil, &health)
+	if err != nil || c.wrongScheme || status != http.StatusOK || health.Database == "" {
+		return nil, ignoreNotJSON(err)
+	}
+	var dashboards []json.RawMessage
+	if status, err := c.do(ctx, "/api/search?limit=100", nil, &dashboards); err == nil && status == http.StatusOK {
+		return c.finding(severityMedium, "/api/search", health.Version, fmt.Sprintf("anonymous access enabled; %d dashboards listed", len(dashboards))), nil
+	}
+	if resp, _, err := c.page(ctx, "/login"); err == nil && resp.StatusCode == http.StatusOK {
+		return c.finding(severityLow, "/login", health.Version, "login page exposed; the admin password is admin until changed"), nil
+	}
+	return nil, nil
+}
+
+// actuatorCheck finds the Spring Boot actuator, whose environment endpoint
+// holds the application's configuration and often its secrets.
+func actuatorCheck(ctx context.Context, c *httpEndpoint) (*Finding, error) {
+	var index struct {
+		Links map[string]json.RawMessage `json:"_links"`
+	}
+	status, err := c.do(ctx, "/actuator", nil, &index)
+	if err != nil || c.wrongScheme || status != http.StatusOK || len(index.Links) == 0 {
+		return nil, ignoreNotJSON(err)
+	}
+	if _, ok := index.Links["env"]; ok {
+		var env struct {
+			PropertySources []json.RawMessage `json:"propertySources"`
+		}
+		if status, err := c.do(ctx, "/actuator/env", nil, &env); err == nil && status == http.StatusOK && env.PropertySources != nil {
+			return c.finding(severityHigh, "/actuator/env", "", "environment readable without credentials; configuration and often secrets"), nil
+		}
+	}
+	names := make([]string, 0, len(index.Links))
+	for name := range index.Links {
+		if name != "self" {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return c.finding(severityMedium, "/actuator", "", "endpoints listed without credentials: "+strings.Join(names, ", ")), nil
+}
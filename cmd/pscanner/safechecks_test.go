@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSafeChecks(t *testing.T) {
+	jenkins := func(script bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Jenkins", "2.462.3")
+			switch {
+			case r.URL.Path == "/script" && script:
+				fmt.Fprint(w, "<html><title>Script Console [Jenkins]</title></html>")
+			case r.URL.Path == "/":
+				fmt.Fprint(w, "<html><title>Dashboard [Jenkins]</title></html>")
+			default:
+				http.Error(w, "Forbidden", http.StatusForbidden)
+			}
+		}
+	}
+	tomcat := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/manager/html" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="Tomcat Manager Application"`)
+			http.Error(w, "401 Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		http.NotFound(w, r)
+	})
+	grafana := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/health":
+			fmt.Fprint(w, `{"commit":"abc","database":"ok","version":"11.2.0"}`)
+		case "/api/search":
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"message":"Unauthorized"}`)
+		case "/login":
+			fmt.Fprint(w, "<html><title>Grafana</title></html>")
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	actuator := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/actuator":
+			fmt.Fprint(w, `{"_links":{"self":{"href":"/actuator"},"health":{"href":"/actuator/health"},"env":{"href":"/actuator/env"}}}`)
+		case "/actuator/env":
+			fmt.Fprint(w, `{"activeProfiles":[],"propertySources":[{"name":"server.ports"}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	p := &scanPlan{timeout: time.Second}
+	h := &HostResult{Host: "127.0.0.1"}
+	for _, tt := range []struct {
+		name    string
+		handler http.Handler
+		tls     bool
+		want    *Finding
+	}{
+		{"jenkins script console", jenkins(true), false, &Finding{Severity: severityHigh, Path: "/script", Version: "2.462.3",
+			Details: "script console open to anonymous users; it runs any Groovy code on the controller"}},
+		{"jenkins anonymous read", jenkins(false), true, &Finding{Severity: severityMedium, TLS: true, Path: "/", Version: "2.462.3",
+			Details: "jobs and builds readable by anonymous users"}},
+		{"tomcat manager", tomcat, false, &Finding{Severity: severityMedium, Path: "/manager/html",
+			Details: "manager asks for Basic credentials, often left at tomcat/tomcat or admin/admin"}},
+		{"grafana login", grafana, false, &Finding{Severity: severityLow, Path: "/login", Version: "11.2.0",
+			Details: "login page exposed; the admin password is admin until changed"}},
+		{"spring actuator", actuator, false, &Finding{Severity: severityHigh, Path: "/actuator/env",
+			Details: "environment readable without credentials; configuration and often secrets"}},
+		{"nothing", http.NotFoundHandler(), false, nil},
+	} {
+		s := httptest.NewUnstartedServer(tt.handler)
+		if tt.tls {
+			s.StartTLS()
+		} else {
+			s.Start()
+		}
+		h.Ports = []PortResult{{Port: serverPort(t, s), Protocol: "tcp", State: "open"}}
+		var got *Finding
+		for _, c := range safeChecks {
+			f, _, err := httpCheck(context.Background(), p, nil, h, h.Ports[0].Port, false, c.check)
+			if err != nil {
+				t.Errorf("%s: %s check: %v", tt.name, c.name, err)
+			}
+			if f != nil {
+				got = f
+				break
+			}
+		}
+		s.Close()
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: finding = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFindingsReported(t *testing.T) {
+	r := &Report{
+		Parameters: scanParams{Targets: []string{"10.0.0.5"}, TargetCount: 1, Ports: "8080"},
+		Hosts: []HostResult{{
+			Host:  "10.0.0.5",
+			Ports: []PortResult{{Port: 8080, Protocol: "tcp", State: "open"}},
+			Findings: []Finding{{Port: 8080, Check: "jenkins", Severity: severityHigh, Path: "/script", Version: "2.462.3",
+				Details: "script console open to anonymous users; it runs any Groovy code on the controller"}},
+		}},
+	}
+	var b bytes.Buffer
+	if err := writeText(&b, r); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Warning: 1 findings of high severity\n",
+		"Finding: [high] tcp/8080 jenkins 2.462.3: /script script console open to anonymous users; it runs any Groovy code on the controller\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("text report lacks %q:\n%s", want, b.String())
+		}
+	}
+	b.Reset()
+	if err := writeSyslog(&b, r); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), `<12>1 `) || !strings.Contains(b.String(), ` finding [pscanner@32473 host="10.0.0.5" port="8080" check="jenkins" severity="high" path="/script" version="2.462.3"]`) {
+		t.Errorf("syslog output lacks the finding:\n%s", b.String())
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net/netip"
+	"slices"
+)
+
+// crossReference finds the hostnames among hosts that resolve to an
+// address that has a result of its own, as when both www.example.com and
+// its address are targets, and lists each in the SameAs of the other, so
+// that the two are not taken for different machines. A name probed over
+// one family is matched with its addresses of that family alone. Behind a
+// proxy, which resolves the names, nothing is matched.
+func (p *scanPlan) crossReference(ctx context.Context, dns *dnsCache, hosts []HostResult) {
+	if p.proxy != nil || dns == nil {
+		return
+	}
+	byAddr := make(map[netip.Addr][]int)
+	for i := range hosts {
+		hosts[i].SameAs = nil
+		if a, err := netip.ParseAddr(hosts[i].Host); err == nil {
+			byAddr[a.Unmap()] = append(byAddr[a.Unmap()], i)
+		}
+	}
+	if len(byAddr) == 0 {
+		return
+	}
+	for i := range hosts {
+		h := &hosts[i]
+		if _, err := netip.ParseAddr(h.Host); err == nil || h.LookupError != "" {
+			continue
+		}
+		addrs, err := dns.resolve(ctx, h.Host)
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			a = a.Unmap()
+			if h.Family == familyIPv4 && !a.Is4() || h.Family == familyIPv6 && !a.Is6() {
+				continue
+			}
+			for _, j := range byAddr[a] {
+				if !slices.Contains(h.SameAs, hosts[j].Host) {
+					h.SameAs = append(h.SameAs, hosts[j].Host)
+				}
+				if !slices.Contains(hosts[j].SameAs, h.Host) {
+					hosts[j].SameAs = append(hosts[j].SameAs, h.Host)
+				}
+			}
+		}
+	}
+}
+
+// sameHostNames counts the hostnames of hosts that resolve to an address
+// with a result of its own.
+func sameHostNames(hosts []HostResult) int {
+	n := 0
+	for _, h := range hosts {
+		if _, err := netip.ParseAddr(h.Host); err != nil && len(h.SameAs) > 0 {
+			n++
+		}
+	}
+	return n
+}
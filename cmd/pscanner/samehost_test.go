@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCrossReference(t *testing.T) {
+	port := serveTCP(t, func(conn net.Conn) {})
+	dns := newDNSCache("")
+	dns.lookup = func(_ context.Context, host string) ([]netip.Addr, time.Duration, error) {
+		if host == "other.example" {
+			return []netip.Addr{netip.MustParseAddr("127.0.0.9")}, 0, nil
+		}
+		return []netip.Addr{netip.MustParseAddr("::1"), netip.MustParseAddr("127.0.0.1")}, 0, nil
+	}
+	targets, _ := parseTargets("www.example,127.0.0.1,other.example,127.0.0.0/31")
+	p := &scanPlan{targets: targets, numTargets: targets.count(), ports: []int{port}, workers: 2, timeout: time.Second, dns: dns}
+	hosts := p.run(context.Background(), scanHooks{})
+	got := make(map[string][]string)
+	for _, h := range hosts {
+		if h.SameAs != nil {
+			got[h.Host] = h.SameAs
+		}
+	}
+	want := map[string][]string{
+		"www.example": {"127.0.0.1"},
+		"127.0.0.1":   {"www.example"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("same as = %v, want %v", got, want)
+	}
+
+	// Over IPv6 alone the name is another machine.
+	hosts = []HostResult{{Host: "www.example", Family: familyIPv6}, {Host: "127.0.0.1"}}
+	p.crossReference(context.Background(), dns, hosts)
+	if hosts[0].SameAs != nil || hosts[1].SameAs != nil {
+		t.Errorf("IPv6 result matched the IPv4 address: %+v", hosts)
+	}
+
+	r := &Report{
+		Parameters: scanParams{TargetCount: 2, PortCount: 1},
+		Hosts: []HostResult{
+			{Host: "www.example", Ports: []PortResult{{Port: 443, Protocol: "tcp", State: "open"}}, SameAs: []string{"127.0.0.1"}},
+			{Host: "127.0.0.1", Ports: []PortResult{{Port: 443, Protocol: "tcp", State: "open"}}, SameAs: []string{"www.example"}},
+		},
+	}
+	var b bytes.Buffer
+	if err := writeText(&b, r); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Warning: 1 hostnames resolve to addresses scanned as well; the ports of each machine are listed under both\n",
+		"Host: www.example\nSame machine as: 127.0.0.1\n",
+		"Host: 127.0.0.1\nSame machine as: www.example\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("text report lacks %q:\n%s", want, b.String())
+		}
+	}
+}
+
+func TestSameAsset(t *testing.T) {
+	https := PortResult{Port: 443, Protocol: "tcp", State: "open"}
+	ssh := PortResult{Port: 22, Protocol: "tcp", State: "open"}
+	open := map[string][]PortResult{"www.example": {https, ssh}, "192.0.2.7": {https}}
+	name := HostResult{Host: "www.example", SameAs: []string{"192.0.2.7"}}
+	if !sameAsset(name, https, open) {
+		t.Error("port open on the address as well is a new asset")
+	}
+	if sameAsset(name, ssh, open) {
+		t.Error("port open on the name alone is no asset")
+	}
+	if sameAsset(HostResult{Host: "192.0.2.7", SameAs: []string{"www.example"}}, https, open) {
+		t.Error("the address's own port is no asset")
+	}
+}
@@ -0,0 +1,2027 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// scanOptions holds the flags of the "scan" command.
+type scanOptions struct {
+	logFlags
+	host        string
+	ports       string
+	workers     int
+	timeout     time.Duration
+	hostTimeout time.Duration
+	delay       time.Duration
+	retries     int
+	force       bool
+	portTimeout string
+	// calibratePort, minTimeout and maxTimeout pick a timeout for each
+	// host from the round trip of connects to calibratePort.
+	calibratePort int
+	minTimeout    time.Duration
+	maxTimeout    time.Duration
+	snmpHarvest   bool
+	follow        int
+	certNames     bool
+	vhosts        string
+	screenshots   string
+	screenshotCmd string
+	httpPaths     string
+	safeChecks    bool
+	severity      string
+	topPorts      int
+	config        string
+	profile       string
+	dryRun        bool
+	yes           bool
+	override      bool // --override-scope
+	tui           bool
+	watch         bool
+	interval      time.Duration
+	webhook       string
+	webhookOn     string
+	alertPorts    string
+	notify        string
+	chatURLs      map[string]string // --slack-webhook and the like
+	emailTo       string
+	emailFrom     string
+	smtp          string
+	esURL         string
+	esIndex       string
+	publish       []string
+	db            string
+	upload        string
+	output        string
+	outputFile    string
+	errorsFile    string
+	syslogAddr    string
+	proxy         string
+	viaSSH        string
+	sshKey        string
+	iface         string
+	sourceIP      string
+	sourcePort    int
+	imported      map[string][]int // ports per host, for "import"
+	localNet      bool
+	traceroute    bool
+	quic          bool
+	dtls          bool
+	vpn           bool
+	udp           string
+	tcp           string
+	ot            bool
+	otSafe        bool
+	containers    bool
+	openInst      bool
+	endpoints     bool
+	honeypots     bool
+	skipCDN       bool
+	knock         string
+	knockDelay    time.Duration
+	payloads      payloadList
+	script        string
+	scriptDir     string
+	plugin        string
+	pluginDir     string
+	tags          tagList
+	hostOrder     string
+	state         string
+	stats         bool
+	inferFW       bool
+	prefer        string
+	dnsCache      string
+	enrich        string
+	whois         bool
+	coordinate    string
+	shodanKey     string
+	checkpoint    string
+	args          []string    // the arguments of "scan", for --checkpoint
+	resumed       *checkpoint // the interrupted scan, for "resume"
+}
+
+// scanDoc is the long-form documentation of "pscanner scan".
+var scanDoc = &commandDoc{
+	synopsis: "pscanner scan --host <hosts> [--ports 1-1024 | --top-ports N] [--profile name] [options]",
+	description: `Scan TCP ports on a host.
+
+Every port in the port list is probed on every target with a TCP connect. A
+port that accepts the connection is reported open; refused and timed-out
+probes are not reported. Results are printed once all probes have finished.
+
+With --watch the scan repeats until interrupted, for monitoring a perimeter
+over time. The first run is printed in full; after that pscanner prints only
+the ports that opened or closed since the previous run, one per line.`,
+	notes: map[string]string{
+		"host": `Targets are domain names, IP addresses or CIDR blocks, for example
+"example.com,10.0.0.0/24". A block may hold at most 2^24 addresses. Duplicate
+targets and addresses covered by an earlier block are scanned once.
+Link-local IPv6 addresses need a zone naming the interface of their link,
+as in fe80::1%eth0 or fe80::1%2, as the same address may be on every link;
+the zone is kept in the results. Such targets cannot go through --proxy,
+--via-ssh or --coordinate, and link-local blocks are not accepted.`,
+		"local-net": `The targets are the IPv4 networks of every interface that is up and not
+a loopback interface, or of --interface alone, as their addresses and
+netmasks (set by DHCP or by hand) say. The default gateway of each
+interface is read from the routing table on Linux and scanned with its
+network, even when it lies outside it. Point-to-point links are skipped.
+--dry-run lists what was found. A large network asks for confirmation as
+any large scan does.`,
+		"ports": `Ports and ranges may be mixed: "80,443,8080,21-25". Named groups are
+written with @: @web, @db, @mail, @remote, @file and @windows are built in and
+the config file can define more. Service names such as ssh, http or postgres
+resolve to their registered port.`,
+		"top-ports": `N is between 1 and 1059. The first 1000 ports follow Nmap's
+frequency-ranked top-100 and top-1000 lists; the rest are services the
+pscanner authors added, such as Redis, MongoDB and Kubernetes. Cannot be
+combined with --ports.`,
+		"timeout": `Accepts Go durations such as 750ms or 2s; a bare number is milliseconds.`,
+		"port-timeout": `Gives services that are slow to accept, such as SMTP servers that look up
+the client first, more time than the rest of the scan. Entries are
+ports:timeout, where the ports are a port, a range, a service name or an
+@group as for --ports; a later entry wins. The timeouts bound TCP connects
+only, like --timeout, and count in the --dry-run estimate.`,
+		"calibrate-port": `Before the first probe of each host, pscanner connects to this port three
+times, one after the other, and gives the host's probes 4 times the
+slowest round trip as their timeout, within --min-timeout and
+--max-timeout, in place of --timeout. Pick a port known to be open, or
+known to refuse: a refusal times the round trip as well. If none of the
+connects is answered, a warning is logged and the host keeps --timeout.
+--port-timeout still wins for its ports. The timeout of each host is in
+the results.`,
+		"min-timeout": `Keeps calibrated timeouts of nearby hosts from being so short that a
+busy host misses them. Needs --calibrate-port.`,
+		"max-timeout": `Also bounds the calibration connects themselves. Needs --calibrate-port.`,
+		"snmp-harvest": `After the scan, every host with an open port is asked over SNMPv2c, on
+udp/161, whether it routes or switches (sysServices). Those that do are
+read for their interfaces (ifDescr) and their ARP and IPv6 neighbour
+tables, listed under the host. Neighbours that were not targets are then
+scanned on the same ports, once: those in the scope file, or with none,
+internal addresses only, up to 4096. They follow the targets in the
+results, with the host they were learned from. The community is read from
+$PSCANNER_SNMP_COMMUNITY, never from the command line. Not available with
+--proxy, --via-ssh or --coordinate, which cannot carry SNMP.`,
+		"follow": `After the scan, the hosts its results point to are scanned too, on the
+same ports: the names and addresses in the certificates of open TLS ports
+(443, 465, 636, 853, 993, 995, 2376, 5986, 6443, 8443, 9443), the complete
+entries of the scanner's ARP table, which lists neighbours that answer no
+probe (not behind --proxy or --via-ssh), and with --snmp-harvest the
+tables of routers and switches. The hosts found are followed in turn, for
+up to this many rounds (at most 10). A host is scanned once, under the name
+or address that first led to it, and only if the scope file allows it, or
+with none, if all its addresses are internal; up to 4096 in all. Results
+name the host and the way each was learned. Not available with
+--coordinate.`,
+		"vhosts": `After the scan, each open port of @web is asked for / once for every name
+in the file (one per line, "#" comments allowed, up to 5000), with the
+name as the Host header and, over TLS, as the server name. The port is
+also asked with the host's own address and with a name no server knows,
+which get the default site. Names answered differently from both, by
+status, redirect, page title or a length more than a tenth apart, are
+listed as virtual hosts with what they answered. The name is left out of
+the answers before they are compared. Not available with --coordinate.`,
+		"screenshots": `After the scan, each open port of @web is loaded in a headless Chromium
+or Chrome from PATH, over HTTPS if it completes a TLS handshake, and a
+screenshot of / is saved in the directory as HOST_PORT.png, at most 30
+seconds each. Results list the files, and --output html shows them
+linked from the page, so keep the page next to the directory. Not
+available with --proxy, --via-ssh or --coordinate, as the browser
+connects by itself.`,
+		"http-paths": `After the scan, each open port of @web is asked for the paths, over TLS
+first on ports of 443 and x443, along with a random path no site has.
+"default" stands for /robots.txt, /.git/HEAD, /server-status and
+/metrics, whose bodies are checked for what they seem to be: robots
+rules, a Git repository, Apache's server status or Prometheus metrics.
+Paths answered like the random one, by status and a length within a
+tenth, are left out, so a site that answers 200 to anything lists only
+what it really serves. Up to 50 paths; redirects are not followed. Not
+available with --coordinate.`,
+		"safe-checks": `After the scan, the open ports of @web are checked for admin consoles
+and data left open, with GET requests that only read and no credentials
+ever sent:
+  jenkins          the script console, which runs code on the controller
+                   (high), or jobs readable by anonymous users (medium)
+  tomcat-manager   the manager, which deploys WAR files: open (high), or
+                   asking for credentials often left at a default (medium)
+  grafana          anonymous access (medium), or the login page, whose
+                   admin password is admin until changed (low)
+  spring-actuator  the environment endpoint, with the configuration and
+                   often its secrets (high), or the endpoints listed (medium)
+Each is listed in a "findings" entry of the results with its severity; the
+text report warns of those of high severity first, and syslog output sends
+them at warning severity. HTTPS certificates are not verified. Not available with
+--coordinate, as the ports are asked from here.`,
+		"severity": `Gives each open port a severity, high, medium, low or info, by rules
+that say what its being open exposes: "default" for the embedded rules
+alone, or a file of rules checked before them. A rule is a line of
+  <port>[-<port>]/<protocol> <severity> [public|private]
+and the first that matches a port gives its severity; "public" rules match
+only hosts whose address is routed on the internet, "private" ones only
+the others, and neither a hostname:
+  3389/tcp  high    public   # RDP facing the internet
+  3389/tcp  medium
+  8080/tcp  info             # our proxies
+The embedded rules make Telnet high anywhere, RDP, SMB and databases high
+on public addresses, cleartext web and mail low, and HTTPS and SSH inside
+info. Ports no rule matches have none. The severity is in the
+"severity" of each port of the JSON results, after the port in the text
+report, which counts them first, in the syslog messages, the HTML report
+and the database. --host-order risk puts the hosts with the most severe
+ports and findings first.`,
+		"screenshot-command": `Runs this instead of the browser, with {url} replaced by the page and
+{file} by the PNG to write, e.g. "gowitness single --url {url}
+--screenshot-path {file}". It is split at spaces, without quoting; wrap
+anything more in a script.`,
+		"cert-names": `After the scan, a TLS handshake with each open port among 443, 465, 636,
+853, 993, 995, 2376, 5986, 6443, 8443, 9443 reads the names and addresses
+in the server's certificate, listed under the host. The names that were
+not targets, wildcards included, are then listed together as discovered
+hostnames, with the hosts that gave them. --follow reads them too, and
+scans those the scope allows. Not available with --coordinate.`,
+		"host-timeout": `The budget starts at the first probe of a host. Ports not probed by then are skipped and the host is marked as timed out in the results.`,
+		"delay":        `Use with a small --workers value to keep the probe rate low.`,
+		"force": `When the first 100 probes of a host all time out, before any is answered,
+the host appears down or drops everything: pscanner logs a warning, marks
+the host "appears down" in the results and skips its other ports. Check
+such hosts with ping or another ICMP probe. --force probes their other
+ports anyway, as nmap -Pn does, for hosts that drop all but a few ports.
+Hosts with 100 ports or fewer to probe are always probed in full. With
+--coordinate each agent judges the hosts of its own shards.`,
+		"retries": `A probe that gets no answer before --timeout is made again, after
+--delay, up to this many times, for lossy links and rate-limiting
+firewalls. Ports that refuse or are rejected are not retried. Each port is
+reported once, in the state of its last probe; an open port that took
+more than one probe has its "attempts" in the JSON results and the text
+report. At most 10.`,
+		"knock": `Before the first probe of each host, pscanner knocks on the listed ports
+in order, for hosts whose firewall, such as knockd, opens ports only to
+those who knock first. A TCP knock is a connection attempt, which counts
+whatever its outcome; a UDP knock is an empty datagram. --knock-delay
+spaces the knocks and the scan after them. Each run of --watch knocks
+again. UDP knocks are not available with --proxy or --via-ssh, which
+cannot carry them, and knocks not with --coordinate, as they would come
+from here rather than from the agents.`,
+		"profile": `Built-in profiles are quick (top 100 ports, fast timeout), full (all
+ports) and stealth (1-1024, few workers with a delay). Profiles in the config
+file replace built-in ones of the same name. Flags given on the command line
+override profile values.`,
+		"config": `The file is JSON with "profiles", "groups", "confirm_probes" and
+"allow_public_hosts" keys. "audit_log" names a file that every scan appends
+a record to. A missing default file is ignored; a missing file named with
+--config is an error.`,
+		"output": `Structured output records the schema version, the pscanner build and
+the effective scan parameters alongside the results. syslog sends RFC 5424
+messages to --syslog-addr instead of stdout: a "port" message for each open
+port and a "summary" message at the end, with the details as structured
+data. With --output-file the messages are written there, one per line.
+html writes a standalone page, with the --screenshots of web ports.`,
+		"checkpoint": `When the scan is interrupted with Ctrl-C or SIGTERM, its options, the
+results found so far and the probes not yet made are saved to the file, and
+"pscanner resume file" finishes the scan. Nothing is saved of a scan that
+runs to the end. Cannot be combined with --watch, --tui or --coordinate.`,
+		"errors-file": `Every probe that did not find its port open is written to the file as a
+line of JSON, so that a pipeline can tell a port that answered closed from
+one the scan could not judge:
+  {"time":"2026-10-15T09:12:03.5Z","host":"10.0.0.7","port":8443,
+   "category":"timeout","message":"dial tcp 10.0.0.7:8443: i/o timeout",
+   "attempt":1}
+The category is refused (a closed port), timeout or unreachable (a
+filtered one), or permission, fd_limit and other, failures on this
+machine that say nothing of the port. proxy is a probe the proxy could not
+make; skipped and lookup are probes not made at all, for --host-timeout or
+a host that appears down, and for a hostname that could not be looked up,
+with attempt 0. With --retries each timed-out attempt has a line. "family"
+is added for --prefer. The file is replaced, and with --watch it collects
+every run. Not available with --coordinate, as the agents make the probes.`,
+		"syslog-addr": `udp://host[:514], tcp://host[:601] or tls://host[:6514]. Without it
+messages go to the local syslog daemon, which must accept RFC 5424 (rsyslog
+and syslog-ng do). With --watch later runs send "opened" and "closed"
+messages.`,
+		"dry-run": `Prints the expanded target list, the resolved addresses of hostnames,
+the port count, the estimated duration and the timing settings. Nothing is
+sent to the targets, but hostnames are looked up in DNS.`,
+		"tui": `The screen shows a progress gauge, the probe rate over time and a table
+of hosts with their open ports as they are found. Keys: p or space pauses
+and resumes the workers, up and down select a host, enter shows its ports
+and services, esc goes back, and q stops the scan early. Once the scan is
+over, q leaves the screen and the results are written as usual. Needs a
+terminal on stdin and stdout.`,
+		"watch": `A port counts as closed only if the newer run probed it, so a host that
+hits --host-timeout does not report its ports as closed. With --output json
+each run is one line: a "baseline" event holding the first report, then a
+"change" event with "opened" and "closed" lists for each run that found a
+difference. Ctrl-C stops watching; a run in progress is not reported
+unless it is the first.`,
+		"interval": `Measured from the start of one run to the start of the next; a run that
+takes longer is followed by the next straight away. The minimum is 1m.`,
+		"webhook": `The payload holds the report, the change since the previous run for
+--watch, and the open ports on the --alert-ports list. If the
+PSCANNER_WEBHOOK_SECRET environment variable is set, each delivery is signed:
+X-Pscanner-Signature is "sha256=" and the hex HMAC-SHA256 of the
+X-Pscanner-Timestamp value, a dot and the body. A failed delivery is tried up
+to four times in all, with growing pauses.`,
+		"webhook-on": `"finished" posts after every scan, "opened" when a port opened since the
+previous --watch run, "changed" when one opened or closed, and "ports" when
+a port from --alert-ports is open.`,
+		"alert-ports": `Ports as for --ports, for example 3389,445 or @windows. Implies
+--webhook-on ports. Like --webhook-on, it applies to --notify channels as
+well and replaces the conditions set for them in the config file.`,
+		"notify": `Each service posts a short summary, the ports that opened or closed since
+the previous --watch run and any open alert ports to a channel's incoming
+webhook. The URL comes from the matching --slack-webhook, --discord-webhook
+or --teams-webhook flag, which also enables the service, or from
+"notifiers" in the config file, for example
+{"notifiers": {"slack": {"url": "https://hooks.slack.com/...", "on": ["opened"]}}}.`,
+		"elasticsearch": `Works with Elasticsearch 7.8 or later and OpenSearch. Each open port
+becomes a document in the index <prefix>-YYYY.MM.DD, with a summary
+document per scan. The fields follow the Elastic Common Schema
+(destination.ip, destination.port, service.name) plus pscanner.scan_id. An
+index template for <prefix>-* is installed first. Put user:password in the
+URL for basic auth, or set PSCANNER_ELASTICSEARCH_API_KEY.`,
+		"publish": `kafka://host:port/topic publishes to Kafka; list several brokers as
+host:port,host:port, use kafka+tls:// for TLS and user:password@ for
+SASL/PLAIN. nats://[user:password@]host:port/subject publishes to NATS. Each
+open port is a JSON message of type "port", keyed by host, and each scan
+ends with a "summary" message; scan_id ties them together. Repeat the flag
+to publish to several brokers.`,
+		"email-to": `The mail carries the same summary as --notify, with the report attached
+as HTML and JSON. The server comes from --smtp or "email" in the config file,
+for example {"email": {"smtp": "smtp://scanner@mail.example.com:587", "to":
+["soc@example.com"], "on": ["changed"]}}. smtp:// upgrades with STARTTLS
+when the server offers it. A password missing from the URL is read from
+$PSCANNER_SMTP_PASSWORD, and is never sent without TLS. --webhook-on and
+--alert-ports apply here too.`,
+		"proxy": `Every probe is a CONNECT request to the proxy, and host names are
+resolved by the proxy, which suits Tor and pivots into internal networks.
+user:password@ in the URL authenticates. The proxy's answer tells a closed
+or unreachable port apart from a failure of the proxy itself; such failures
+are counted per host as probe errors and do not count as closed ports.
+Raise --timeout for slow proxies such as Tor.
+
+http:// and https:// URLs are HTTP proxies that allow CONNECT, as found in
+corporate networks; https:// speaks TLS to the proxy. A 502, 503 or 504
+answer is taken as a port that is not open. Many such proxies only allow
+CONNECT to port 443 and answer 403 for others, which shows as probe errors.
+
+A comma-separated list of proxies is a chain, as with proxychains: the
+connection to each proxy is tunneled through the ones before it, so
+socks5://a,socks5://b reaches b from a and the targets from b.
+
+"routes" in the config file send some targets another way, so that one
+scan reaches several network segments through their own pivots:
+{"routes": [{"to": "10.0.0.0/8", "via": "socks5://jump1:1080"}, {"to":
+"corp.example", "via": "ssh://scan@jump2"}]}. "to" is a CIDR block, an IP
+address or a domain with its subdomains; "via" is a proxy or chain as for
+--proxy, which may end in an ssh:// jump host, or "direct". The most
+specific route wins. Hostnames are matched by name only, not by address.
+Targets without a route go through --proxy or --via-ssh, or direct.`,
+		"interface": `For scan hosts with several NICs or alias IPs. Probes start from the
+interface's first IPv4 and IPv6 address, or from --source-ip, which must
+be one of its addresses. On Linux the sockets are also bound to the
+interface (SO_BINDTODEVICE), so probes leave through it whatever the
+routing table says; elsewhere the route is the system's choice for that
+source address. With --proxy or --via-ssh, only the connection to the
+proxy or SSH server starts from the chosen source.`,
+		"source-port": `Some firewalls let in anything that comes from port 53 (DNS), 88
+(Kerberos) or 20 (FTP data), taking it for replies; probing from such a
+port finds what they expose. Ports below 1024 need root, and a port a
+local service holds, as a DNS resolver may hold 53, cannot be used; pscanner
+checks both before scanning. Connections to open ports are ended with a
+reset, so the port is free again straight away for the next --watch run.
+Not available with --proxy or --via-ssh.`,
+		"coordinate": `pscanner listens there for "pscanner agent --join" processes and hands
+each of them a share of the probes, 4096 at a time, then reports their
+results together as if it had scanned them itself. The timing options
+apply on every agent. An agent that fails or stops sending heartbeats for
+30 seconds loses its share to the next agent that asks, so a scan finishes
+as long as one agent is left; with none, pscanner waits for one to join.
+Coordinator and agents authenticate each other with the secret in
+$PSCANNER_AGENT_TOKEN. The agents dial the targets from their own
+addresses, so proxy and source options are set on the agents instead.`,
+		"via-ssh": `pscanner logs in once and opens a direct-tcpip channel for every probe,
+the mechanism behind "ssh -W", so nothing is installed on the jump host and
+internal networks are scanned from its point of view. The host key must be
+in ~/.ssh/known_hosts. Keys come from ssh-agent, then from --ssh-key or
+~/.ssh/id_ed25519, id_ecdsa and id_rsa; keys with a passphrase need the
+agent. A port the server could not connect to is not open; other channel
+failures count as probe errors, as for --proxy. With --proxy, the SSH
+connection goes through the proxies. sshd may refuse channels under heavy
+load, so keep --workers moderate.`,
+		"upload": `Each run is written in the --output format to an object named after its
+start time, such as s3://bucket/prefix/2026-10-14T12-00-00Z.json. S3
+credentials come from $AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY or the
+container role, the region from $AWS_REGION or ?region=; add
+?endpoint=https://… for MinIO and other S3-compatible stores. Cloud Storage
+uses $GOOGLE_OAUTH_ACCESS_TOKEN or the instance's service account.`,
+		"enrich": `Each host that is a public IP address is looked up in the services'
+host APIs after the scan, and the organization, AS, hostnames, tags and
+the ports the service has seen open are added to its results under
+"third_party" (in text output, under "Third-party data"). This data comes
+from the service's own crawls, may be days old and is not confirmed by
+the scan. Lookups count against the account's query credits; at most 256
+hosts are looked up per run, and --watch looks each host up once. Censys
+reads its credentials from $CENSYS_API_ID and $CENSYS_API_SECRET. A
+failed lookup is reported and the scan results are kept.`,
+		"stats": `Adds to each host how its probes ended: open, closed (refused with a TCP
+reset), filtered (rejected with an ICMP unreachable or not answered before
+--timeout) or failed here, with the share that failed, and the mean time
+the answered probes took to connect or be refused. The text report warns
+of the hosts that filtered every probe, and of those where more than 10%
+of the probes failed, so that they stand out in a large scan; the JSON
+results have a "stats" object per host. The times include the first
+lookup of a hostname. Not available with --proxy, --via-ssh or
+--coordinate, which only tell open ports from the rest.`,
+		"state": `Which ports the results list: open ones, closed ones that refused
+with a TCP reset, filtered ones that were rejected with an ICMP
+unreachable or not answered before --timeout, or any mix, such as
+"open,closed,filtered" for a firewall audit. Closed and filtered ports are
+listed per host as port ranges, under "closed_ports" and "filtered_ports"
+in JSON. Without open, the open ports are left out of the results, and of
+what is stored or sent, though the probes after the scan still run on
+them. Ports skipped after --host-timeout are in no list. Not available
+with --proxy, --via-ssh or --coordinate, which only tell open ports from
+the rest.`,
+		"infer-firewall": `Every port that is not open ended in one of three ways: refused with a
+TCP reset (closed, nothing in the way), answered by an ICMP unreachable
+(rejected, usually by a firewall) or not answered before --timeout
+(dropped by a filter). pscanner counts them per host and sums them up in a
+"firewall" verdict, such as "default deny, dropping packets; permits 22,
+443". The most common outcome is taken as the policy for ports nobody
+allowed, so scan a wide port range for a meaningful answer. A connect scan
+cannot send the ACK or FIN probes that tell a stateful filter from a
+stateless one, so the verdict does not say which it is. A --timeout too
+short for the host counts as dropping.`,
+		"dns-cache": `Every hostname is looked up once per run, before the first probe and
+many at a time, and the answer reused for all its ports, so a list of
+thousands of subdomains is not resolved again for each probe, and lookups
+do not count against --timeout. Names that fail to resolve are logged up
+front, marked in the results and not scanned. With --dns-cache the
+answers are also kept in the file, and later runs reuse them until their
+TTL runs out. To learn the TTL, pscanner asks the nameservers in
+/etc/resolv.conf directly. Names they do not answer for, and names the
+system would find before asking DNS, such as those in /etc/hosts or .local
+names with mDNS in nsswitch.conf, go to the system resolver and are not
+kept. The scope and public-address checks use the same answers as the
+probes.`,
+		"prefer": `A hostname with both A and AAAA records is normally dialed the way
+browsers do it, racing IPv6 against IPv4 (Happy Eyeballs), and the port is
+reported open over whichever family answered first. ipv4 or ipv6 probes
+the hostname over that family alone. both probes it over each family and
+reports each separately, with a "family" in the results, so a service
+listening or firewalled on only one of them shows. IP address targets are
+unaffected. Not available with --proxy, --via-ssh or --coordinate, which
+resolve hostnames remotely.`,
+		"quic": `After the scan, pscanner sends a QUIC packet to UDP port 443 of every
+host, to find HTTP/3 and other QUIC services, including those whose TCP
+port 443 is filtered. A host that answers gets an open udp/443 port and a
+"quic" entry in the results, with the QUIC versions the server offers and,
+when it offers version 1, the application protocol (ALPN) it agrees to in
+a handshake: h3 for HTTP/3, doq for DNS over QUIC. The server's
+certificate is not checked, and the handshake is left unfinished. Each
+host waits up to --timeout for an answer; no answer leaves the port out,
+as UDP cannot tell filtered from closed. Not available with --proxy,
+--via-ssh, --coordinate or config routes, which carry TCP only.`,
+		"dtls": `After the scan, pscanner sends a DTLS ClientHello to the UDP ports
+where DTLS services usually listen: 443 (VPN gateways such as Cisco
+AnyConnect, OpenConnect, Fortinet and Citrix), 3391 (Remote Desktop
+Gateway), 5246 (CAPWAP wireless controllers), 5349 (TURN servers relaying
+WebRTC) and 5684 (CoAP over DTLS). A port that answers is listed as an open
+UDP port, with a "dtls" entry in the results giving the DTLS version, the
+cipher suite the server picks and the subject of its certificate, or the
+alert it refused the handshake with. A HelloVerifyRequest is answered with
+its cookie; the handshake is left unfinished. Each port waits up to
+--timeout for an answer; no answer leaves the port out, as UDP cannot tell
+filtered from closed. Not available with --proxy, --via-ssh, --coordinate
+or config routes, which carry TCP only.`,
+		"vpn": `After the scan, pscanner looks for VPN endpoints on every host. It
+sends an IKEv2 IKE_SA_INIT request to UDP ports 500 and 4500 (IKE behind
+NAT), proposing common algorithms, and lists the algorithms the server
+picks and the vendor IDs it sends, which often name the product
+(strongSwan, Cisco, Microsoft), or the error it refuses the proposals
+with. A server that only speaks IKEv1 shows as version 1. It also opens an
+OpenVPN session on UDP port 1194; servers using tls-auth or tls-crypt
+ignore it. Ports that answer are listed as open UDP ports labelled ike or
+openvpn, with a "vpn" entry in the results. No IKE SA or OpenVPN session
+is set up. Each port waits up to --timeout for an answer; no answer leaves
+the port out, as UDP cannot tell filtered from closed.
+
+WireGuard answers nothing without the server's key, so it is only guessed
+at: a handshake initiation to UDP 51820 (WireGuard's usual port) and 41641
+(Tailscale's) that gets no answer, from a host that answers a closed port
+(33434, checked before and after) with ICMP port unreachable, is listed as
+"WireGuard?" with medium confidence, or low if the closed port went silent
+once. Such a port is not listed as open. Hosts that drop closed ports get
+no guess. Not available with --proxy, --via-ssh, --coordinate or config
+routes, which carry TCP only.`,
+		"udp": `After the scan, pscanner sends each host the requests of the named UDP
+probes, comma-separated, or of all of them, and lists the services that
+answer as open UDP ports, with a "udp" entry in the results saying what
+the answer showed:
+  ntp   UDP 123: an NTP client request, giving the server's stratum and
+        reference; a server that answers then gets a control query (mode
+        6, as "ntpq -c rv" makes), which gives its version, and which
+        servers should not answer from outside
+  tftp  UDP 69: a read request for a file that does not exist, which
+        servers answer with an error, from a port of their own
+  coap  UDP 5683: a GET of /.well-known/core, giving the response code and
+        the device's resources
+  sip   UDP 5060: a SIP OPTIONS request, giving the status and the name of
+        the server, phone or gateway
+  ipmi  UDP 623: the authentication capabilities of a BMC (IPMI over RMCP),
+        then, for IPMI 2.0, whether it opens sessions with cipher suite 0,
+        which needs no password, and whether RAKP hands out the password
+        hash of a default user (CVE-2013-4786); what it finds is listed
+        among the service's issues. This leaves half-open sessions on the
+        BMC, which it drops after a minute or so
+Each probe waits up to --timeout for an answer; no answer leaves the port
+out, as UDP cannot tell filtered from closed. Not available with --proxy,
+--via-ssh, --coordinate or config routes, which carry TCP only.`,
+		"ot": `After the scan, pscanner asks the industrial devices among the hosts
+who they are, with requests that only read, for an ICS asset inventory:
+  modbus  TCP 502, when open: the basic device identification (function
+          43/14), giving vendor, product code and revision
+  s7      TCP 102, when open: an S7 connection to rack 0, slot 2 or 1, and
+          the module and component identification lists, giving the
+          module type, firmware, order and serial numbers and the PLC's
+          name
+  dnp3    TCP 20000, when open: a link status request to the usual
+          outstation addresses, giving the address that answers
+  bacnet  UDP 47808, on every host: the name, vendor, model and firmware
+          of the device object; an answer is listed as an open UDP port
+Each device is listed in an "ot" entry of the results. Nothing is written
+to a device, and no program or value is read. Scan @ot to include the
+TCP ports. BACnet is left out with --proxy, --via-ssh or config routes,
+which carry TCP only. Not available with --coordinate, as the devices are
+asked from here.`,
+		"ot-safe": `Some controllers stop or reset when probed too fast, or when many
+connections arrive at once. --ot-safe implies --ot and scans them gently:
+one worker, a --delay of 1s between probes, unless --workers or --delay are
+given, and --ot asks one host at a time, pausing --delay between requests.
+Expect a large network to take a long time.`,
+		"tcp": `After the scan, pscanner makes the named TCP probes, comma-separated,
+or all of them, of the open ports they are for, and lists the services
+that answer in a "tcp" entry of the results:
+  sip   TCP 5060: a SIP OPTIONS request, giving the status and the name of
+        the server, phone or gateway
+  rtsp  TCP 554, 8554: an RTSP OPTIONS request, giving the camera's or
+        recorder's server name, then a DESCRIBE of the stream at /, which
+        should need credentials; one described without them is listed
+        among the service's issues, with the realm otherwise
+  smtp  TCP 25, 465, 587: the greeting and EHLO extensions, then STARTTLS
+  imap  TCP 143, 993: the greeting and capabilities, then STARTTLS
+  pop3  TCP 110, 995: the greeting and capabilities, then STLS
+        The mail probes report the TLS version and the certificate of the
+        session, which 465, 993 and 995 start at once. Among the issues
+        are a missing STARTTLS, passwords taken before TLS, TLS older
+        than 1.2, and a self-signed or expired certificate. An SMTP server
+        offering NTLM authentication is asked to start one, as for smb.
+  ldap  TCP 389, 636, 3268, 3269: an anonymous bind and a read of the
+        root DSE, giving the naming contexts and, for Active Directory,
+        the domain controller's name and realm; 636 and 3269 speak TLS
+  kerberos  TCP 88: an AS-REQ for a made-up user in the realm the ldap
+        probe found, or the host's name suggests; the error of a KDC of
+        that realm confirms it
+  telnet  TCP 23, 2323, 2001-2016 (serial lines of terminal servers): the
+        banner, as text, with the option negotiation answered and terminal
+        escapes dropped; Enter is pressed for a console that says nothing.
+        A login prompt, which takes the password in the clear, and a
+        command prompt without one are listed among the issues
+  smb   TCP 445: an SMB2 negotiation, giving the dialect and whether
+        signing is required (not requiring it, which lets NTLM relays
+        through, is an issue), then the start of an NTLM session setup.
+        The server's NTLM challenge tells its NetBIOS and DNS names, its
+        domain and forest, and its Windows version, to anyone
+  mssql  TCP 1433: a TDS PRELOGIN, giving SQL Server's version, then a
+        login with integrated security for the NTLM challenge, as for
+        smb, unless the server requires encryption
+Each conversation is given 5s. Not available with --coordinate, as the
+ports are asked from here.`,
+		"containers": `After the scan, pscanner checks the container platform ports it found
+open for endpoints that answer anyone, the most damaging accidental
+exposures of cloud hosts:
+  docker          TCP 2375, 2376: the Docker API's version and containers
+  kubelet         TCP 10250, 10255 (read-only): the node's pods
+  kube-apiserver  TCP 6443, 8443, 8080 (the old insecure port): the
+                  Kubernetes version and namespaces
+  etcd            TCP 2379: the version, and a count of the keys
+Each endpoint is listed in a "containers" entry of the results, marked
+unauthenticated when it answered, or with the credentials it asked for: a
+client certificate, or authentication. Requests only read, and lists are
+counted rather than kept. HTTPS certificates are not verified. Scan
+@containers to include the ports. Not available with --coordinate, as the
+endpoints are asked from here.`,
+		"open-instances": `After the scan, pscanner checks for search and monitoring instances
+that answer anyone, which leak every document or map the network:
+  elasticsearch  TCP 9200: the cluster's name and version, and a count of
+                 its indices (OpenSearch answers the same)
+  kibana         TCP 5601: its name, version and status
+  prometheus     TCP 9090: its version, and a count of its scrape targets
+The open ports of @web are asked for each of them in turn, as they are
+often published on other ports or behind a proxy. Each instance is listed
+in an "open_instances" entry of the results, marked unauthenticated when it
+answered, or with the credentials it asked for; the text report warns of
+the unauthenticated ones first, and syslog output at warning severity.
+Requests only read, and lists are counted rather than kept. HTTPS
+certificates are not verified. Not available with --coordinate, as the
+instances are asked from here.`,
+		"payload": `After the scan, pscanner connects to each open port that has a payload,
+sends it, and records the answer, to identify a custom protocol without a
+probe of its own. A payload is port:encoding:data, with data in hex, base64,
+or text with Go escapes; repeat the option for more ports, one payload
+each:
+  --payload 9999:hex:deadbeef
+  --payload 7000:text:'HELLO\r\n'
+  --payload 5000:base64:AAECAw==
+The answer is read until the port closes, or pauses for half a second, up
+to 4 KiB and 5s, and listed in a "payloads" entry of the results, in hex
+and as escaped text. Not available with --coordinate, as the ports are
+asked from here.`,
+		"script": `After the scan, pscanner runs each script against the open TCP ports
+it takes, to detect or enumerate what no built-in probe does. A script is a
+Starlark file (a dialect of Python) defining action(host, port), and
+either a list of ports or a portrule(host, port) function that says
+whether to run on a port, or both. Its action is given:
+  connect(host, port, tls=False)  a connection to the scanned host, made
+                                  the way the scan made them
+  conn.send(data)                 sends a string or bytes
+  conn.receive(max=4096)          what the port answers until it pauses,
+                                  closes or 5s pass; "" for nothing
+  conn.close()
+  report(values...)               records a line of results for the port
+and what action returns, unless None, is recorded too. For example:
+  ports = [6379]
+  def action(host, port):
+      c = connect(host, port)
+      c.send("INFO server\r\n")
+      for line in c.receive().split("\r\n"):
+          if line.startswith("redis_version:"):
+              report("Redis", line.split(":")[1])
+Scripts are named by their file name in --script-dir without .star, or
+given as paths; "all" runs every script there. Each run against a port is
+stopped after 30s. The lines are listed in a "scripts" entry of the
+results; what scripts print goes to stderr. Not available with
+--coordinate, as the ports are asked from here.`,
+		"plugin": `Loads WebAssembly plugins, which add --tcp probes or --output formats
+without a rebuild of pscanner. A plugin is a WASI (preview 1) program, in
+any language that compiles to it, run in a sandbox with no access to files
+or the network. Each call runs it afresh with a JSON request on stdin, and
+takes what it writes to stdout:
+  {"call": "info"}  its kind, "probe" or "output", and for a probe its
+                    ports and doc: {"kind": "probe", "ports": [7000]}
+  {"call": "probe", "host": ..., "port": ...}
+                    made of open ports it is for, when --tcp names it:
+                    {"details": ..., "issues": [...]}, or nothing if the
+                    port is not its service. It talks to the port with
+                    send(ptr, len) and recv(ptr, cap) imported from the
+                    "pscanner" module, which return the bytes sent or
+                    received, or -1 on an error
+  {"call": "output", "report": ...}
+                    given the report as --output json writes it, when
+                    --output names it: the output itself
+A plugin is named by its file name in --plugin-dir without .wasm, or
+given as a path; "all" loads every plugin there. Its name cannot be a
+built-in probe's or format's. Each call is stopped after 30s, and may use
+64 MiB of memory; what it writes to stderr goes to stderr.`,
+		"plugin-dir": `Where --plugin finds plugins given by name, by default "plugins" in
+the user config directory, e.g. ~/.config/pscanner/plugins on Linux.`,
+		"script-dir": `Where --script finds scripts given by name, by default "scripts" in
+the user config directory, e.g. ~/.config/pscanner/scripts on Linux.`,
+		"host-order": `The results list each host once per address family, with its open ports
+in numeric order, TCP before UDP, so that the reports of identical scans
+are identical and text diffs of them are meaningful. The hosts come in the
+order of --host (the addresses of a CIDR block in numeric order), or with
+"ip" numerically by address, IPv4 before IPv6 and hostnames last, in the
+order given. With "risk" the hosts whose most severe port (by --severity)
+or finding (by --safe-checks) is most severe come first, and hosts alike
+keep the order of --host.`,
+		"tag": `Labels the scan, so that results from many scans can be told apart,
+filtered and grouped later. A tag is key=value; keys are letters, digits
+and "_.-", values anything but spaces and commas. Repeat the option for
+more tags:
+  --tag engagement=acme --tag env=prod
+The tags are in a "tags" object of the JSON results, on a line of the text
+report, in each syslog message, webhook and chat message, published
+message and Elasticsearch document, and in the database, where "pscanner
+history --tag" and "pscanner query --tag" select by them.`,
+		"skip-cdn": `A target in the edge ranges of Cloudflare, Akamai or Fastly, or a
+hostname that resolves into them, is answered by the CDN rather than the
+origin behind it, and often on every port. Its results are marked with the
+CDN either way; --skip-cdn also probes such targets on 80 and 443 alone,
+the ports the edge serves for every site, and lists what was probed in a
+"probed_ports" entry. The ranges are built in; hostnames are resolved
+before the scan.`,
+		"honeypots": `After the scan, pscanner samples up to 8 open ports of each host with
+at least 4, and looks for the signs of a honeypot, which pretends to run
+everything, or a tarpit such as LaBrea, which holds connections to slow
+scanners and worms down:
+  honeypot  nearly every probed port open, of at least 100; the same
+            greeting on every sampled port
+  tarpit    a TCP window of 100 bytes or less on most sampled ports (on
+            Linux only); connections that take a second or more to be
+            accepted; and, with nearly every port open, connections held
+            without an answer to a blank line
+A host that shows any is given a "honeypot" entry of the results, with its
+verdict and the signs, and the text report counts its open ports rather
+than listing them. Sampled ports are given 5s to accept, and a second to
+greet and another to answer. Not available with --coordinate, as the ports
+are asked from here.`,
+		"endpoints": `After the scan, pscanner classifies the open ports of @web and TCP
+50051 by the application protocol they serve, over TLS or not:
+  grpc       a gRPC health check answered; its status is reported, and the
+             services server reflection lists, if it is enabled
+  websocket  an upgrade was granted at /, /ws, /websocket or the Socket.IO
+             path; the path is reported
+  http       anything else that answers HTTP; its status and Server
+             header are reported
+Each port is listed in an "endpoints" entry of the results. The schemes
+of any WWW-Authenticate challenge, such as NTLM, which suggests IIS or
+Exchange, are listed with their realm under "http_auth", as for the
+other HTTP probes. A port offering NTLM or Negotiate is asked to start
+NTLM authentication once, for the names, domain and Windows version of
+the server. HTTPS and gRPC certificates are not verified. Not available with --coordinate, as the
+ports are asked from here.`,
+		"traceroute": `After the scan, pscanner traces the route to the first open port of
+every host that has one, the way "traceroute -T" does: connection attempts
+to that port leave with a TTL of 1, 2, 3 and so on, and each router where
+one expires reports itself. The path shows where the traffic goes, and
+comparing it with that of a host whose ports seem filtered shows where
+they stop. A hop that does not answer is shown as *; five in a row end the
+trace. Each hop waits up to --timeout. The results gain a "route" list.
+Needs Linux, where the ICMP reports reach the scanning socket without
+root; not available with --proxy, --via-ssh or --coordinate.`,
+		"whois": `The RDAP services of the regional internet registries (RFC 9083) are
+asked, through rdap.org, who holds the network of each public IP address
+in the results: the network's range and name, the registrant, the country
+and the abuse contact, under "whois" in the results. Use it to confirm
+that the targets belong to whom you think. With --dry-run the owner of
+every public target is looked up before anything is scanned; for a CIDR
+block that is the owner of its first address. Each network costs one
+query, and a run stops after 64 queries, as the registries rate-limit
+RDAP.`,
+		"shodan-key": `Prefer $PSCANNER_SHODAN_KEY: a key on the command line is visible to
+other users of the machine in the process list.`,
+		"db": `A PostgreSQL connection URL; the schema is created on first use. Scans
+are only stored when --db is given, so use --db "$PSCANNER_DB" to store into
+the database "pscanner history" and "pscanner query" read. Hosts without
+open ports are not stored individually; the scan's targets are.`,
+		"override-scope": `When /etc/pscanner/scope.yaml exists, pscanner refuses to scan
+any target outside the networks it lists. The file is YAML with an "allow"
+list of CIDR blocks and IP addresses; a CIDR target must lie wholly inside
+one of them, and a host name counts as outside if any of its addresses is
+or if it does not resolve. --override-scope scans anyway and prints a
+warning, unless the file sets "allow_override: false". The scope also binds
+"pscanner serve", "pipe" and "agent".`,
+		"yes": `Without --yes, pscanner asks before scans that exceed confirm_probes
+host:port probes (default 1000000) or that target public addresses. When
+stdin is not a terminal such scans are refused instead.`,
+	},
+	examples: []string{
+		"pscanner scan --host example.com --ports 80,443,8000-8100 --workers 200 --timeout 300ms",
+		"pscanner scan --host example.com --profile quick",
+		"pscanner scan --host example.com --top-ports 1000",
+		"pscanner scan --host 10.0.0.0/24 --ports @web --dry-run",
+		"pscanner scan --host 203.0.113.0/28 --top-ports 100 --watch --interval 1h --yes",
+	},
+}
+
+// flagSet binds the scan flags to o.
+func (o *scanOptions) flagSet() *flag.FlagSet { return o.newFlagSet("scan", scanDoc) }
+
+// newFlagSet binds the flags of the named command to o. "import" takes its
+// targets and ports from files, so it has no --host, --ports or --top-ports.
+func (o *scanOptions) newFlagSet(name string, doc *commandDoc) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	if name == "scan" {
+		fs.StringVar(&o.host, "host", "", "Target hosts: names, IPs or CIDR blocks, comma-separated (required)")
+		fs.StringVar(&o.ports, "ports", "1-1024", "Ports to scan (e.g. 80,443,8080,21-25, 1-65535, @web or ssh,https)")
+		fs.IntVar(&o.topPorts, "top-ports", 0, "Scan the N most common ports instead of --ports")
+		fs.BoolVar(&o.localNet, "local-net", false, "Scan the networks this machine is attached to, instead of --host")
+		fs.StringVar(&o.checkpoint, "checkpoint", "", "On Ctrl-C, save the scan to this `file` for \"pscanner resume\"")
+	}
+	fs.IntVar(&o.workers, "workers", 100, "Number of concurrent workers (goroutines)")
+	durationVar(fs, &o.timeout, "timeout", 500*time.Millisecond, "Dial timeout, e.g. 750ms or 2s (bare numbers are milliseconds)")
+	fs.StringVar(&o.portTimeout, "port-timeout", "", "Dial timeouts of particular ports, overriding --timeout, e.g. `25:3s,1433:2s`")
+	fs.IntVar(&o.calibratePort, "calibrate-port", 0, "Time connects to this known-open `port` of each host to pick its timeout (0 = off)")
+	durationVar(fs, &o.minTimeout, "min-timeout", 100*time.Millisecond, "Shortest timeout --calibrate-port picks")
+	durationVar(fs, &o.maxTimeout, "max-timeout", 0, "Longest timeout --calibrate-port picks (0 = --timeout)")
+	durationVar(fs, &o.hostTimeout, "host-timeout", 0, "Give up on a host after this long (0 = no limit)")
+	durationVar(fs, &o.delay, "delay", 0, "Pause each worker for this long between probes")
+	fs.IntVar(&o.retries, "retries", 0, "Probe a port that does not answer up to this many more times")
+	fs.BoolVar(&o.force, "force", false, "Probe every port of hosts that appear down, instead of skipping them")
+	fs.StringVar(&o.vhosts, "vhosts", "", "Ask the open web ports for each name in `file` and list those served as sites of their own")
+	fs.BoolVar(&o.safeChecks, "safe-checks", false, "Check open web ports for Jenkins, Tomcat, Grafana and Spring consoles left open or at their defaults")
+	fs.StringVar(&o.severity, "severity", "", "Give open ports a severity by the embedded `rules` (default), or by a file of rules checked first")
+	fs.StringVar(&o.httpPaths, "http-paths", "", "Request these `paths` of the open web ports, comma-separated, or default for a few that tell much")
+	fs.StringVar(&o.screenshots, "screenshots", "", "Save a screenshot of each open web port in `dir`")
+	fs.StringVar(&o.screenshotCmd, "screenshot-command", "", "`command` that takes a --screenshots screenshot of {url} into {file} (default: headless Chromium)")
+	fs.BoolVar(&o.certNames, "cert-names", false, "List the hostnames in the certificates of open TLS ports")
+	fs.IntVar(&o.follow, "follow", 0, "Also scan the hosts the results point to, for up to `rounds` rounds (0 = off)")
+	fs.BoolVar(&o.snmpHarvest, "snmp-harvest", false, "Read the interfaces and ARP tables of routers and switches found over SNMP, and scan the new hosts in them")
+	fs.StringVar(&o.knock, "knock", "", "Knock on these ports in order before probing each host, e.g. `7000,8000,9000:udp`")
+	fs.Var(&o.payloads, "payload", "Send a payload to an open `port:encoding:data` and record the answer; encoding is hex, base64 or text (repeatable)")
+	fs.StringVar(&o.script, "script", "", "Run these Starlark `scripts` against the open TCP ports they take: names in --script-dir, paths to .star files, or all")
+	fs.StringVar(&o.scriptDir, "script-dir", "", "Directory of --script scripts (default: scripts in the user config dir)")
+	fs.StringVar(&o.plugin, "plugin", "", "Load these WebAssembly `plugins`, probes for --tcp or formats for --output: names in --plugin-dir, paths to .wasm files, or all")
+	fs.Var(&o.tags, "tag", "Label the results with this `key=value`, e.g. engagement=acme (repeatable)")
+	fs.StringVar(&o.pluginDir, "plugin-dir", "", "Directory of --plugin plugins (default: plugins in the user config dir)")
+	durationVar(fs, &o.knockDelay, "knock-delay", 200*time.Millisecond, "Pause after each --knock, e.g. 500ms")
+	fs.StringVar(&o.config, "config", "", "Path to config file (default: user config dir)")
+	fs.StringVar(&o.profile, "profile", "", "Named scan profile (quick, full, stealth or from config)")
+	fs.StringVar(&o.output, "output", "text", "Output format: text, json, syslog or html")
+	fs.BoolVar(&o.stats, "stats", false, "Count each host's open, closed, filtered and failed probes, and time its connects")
+	fs.StringVar(&o.state, "state", "open", "Port states to list: open, closed, filtered (comma-separated)")
+	fs.StringVar(&o.hostOrder, "host-order", "input", "Order of the hosts in the results: input, ip for numerically by address, or risk for the most severe first")
+	fs.StringVar(&o.outputFile, "output-file", "", "Write results to this file instead of stdout")
+	fs.StringVar(&o.errorsFile, "errors-file", "", "Write each probe that did not find its port open to this `file`, one JSON object per line")
+	fs.StringVar(&o.syslogAddr, "syslog-addr", "", "Syslog receiver for --output syslog (default: local daemon)")
+	fs.StringVar(&o.proxy, "proxy", "", "Connect through this SOCKS5 or HTTP CONNECT proxy `url`, or a comma-separated chain of them")
+	fs.StringVar(&o.iface, "interface", "", "Send probes from this network interface `name`")
+	fs.StringVar(&o.sourceIP, "source-ip", "", "Send probes from this local `address`")
+	fs.IntVar(&o.sourcePort, "source-port", 0, "Send probes from this local `port`, such as 53")
+	fs.StringVar(&o.coordinate, "coordinate", "", "Hand the probes to agents that join at this listen `address` instead of scanning from here")
+	fs.StringVar(&o.viaSSH, "via-ssh", "", "Dial the targets from this SSH server, given as user@host[:port]")
+	fs.StringVar(&o.sshKey, "ssh-key", "", "Private key `file` for --via-ssh (default: ssh-agent and ~/.ssh/id_*)")
+	fs.BoolVar(&o.dryRun, "dry-run", false, "Print the expanded targets and settings without scanning")
+	fs.BoolVar(&o.yes, "yes", false, "Skip confirmation for very large scans or public targets")
+	fs.BoolVar(&o.override, "override-scope", false, "Scan targets outside the scope file, with a warning")
+	fs.BoolVar(&o.tui, "tui", false, "Show live progress and results full-screen while scanning")
+	fs.BoolVar(&o.watch, "watch", false, "Rescan repeatedly and print only the ports that opened or closed")
+	durationVar(fs, &o.interval, "interval", time.Hour, "Time between the starts of --watch runs")
+	fs.StringVar(&o.webhook, "webhook", "", "POST the results as JSON to this `url`")
+	fs.StringVar(&o.webhookOn, "webhook-on", "", "When to call the webhook: finished, opened, changed, ports (default finished)")
+	fs.StringVar(&o.alertPorts, "alert-ports", "", "Call the webhook when any of these ports is open")
+	fs.StringVar(&o.emailTo, "email-to", "", "Mail the report to these addresses (comma-separated)")
+	fs.StringVar(&o.emailFrom, "email-from", "", "Sender address for --email-to (default pscanner@<hostname>)")
+	fs.StringVar(&o.smtp, "smtp", "", "SMTP server `url` for --email-to (smtp://user@host:587 or smtps://…)")
+	fs.StringVar(&o.esURL, "elasticsearch", "", "Index the results into the Elasticsearch or OpenSearch cluster at this `url`")
+	fs.StringVar(&o.esIndex, "elasticsearch-index", "pscanner", "Index name prefix for --elasticsearch")
+	fs.Func("publish", "Publish each result to a Kafka topic or NATS subject at this `url`", func(s string) error {
+		o.publish = append(o.publish, s)
+		return nil
+	})
+	fs.StringVar(&o.upload, "upload", "", "Write the results to object storage at this `url` (s3://bucket/prefix/ or gs://…)")
+	fs.StringVar(&o.enrich, "enrich", "", "Add what these services know about public hosts: shodan, censys (comma-separated)")
+	fs.StringVar(&o.shodanKey, "shodan-key", "", "Shodan API `key` for --enrich shodan (default $PSCANNER_SHODAN_KEY)")
+	fs.BoolVar(&o.inferFW, "infer-firewall", false, "Sum up how each host's closed ports answered: filtered, rejected or refused")
+	fs.BoolVar(&o.traceroute, "traceroute", false, "Trace the route to each host with open ports")
+	fs.BoolVar(&o.quic, "quic", false, "Probe UDP port 443 of each host for QUIC (HTTP/3), reporting versions and ALPN")
+	fs.StringVar(&o.udp, "udp", "", "UDP `probes` to make of each host: ntp, tftp, coap, ipmi, sip or all")
+	fs.StringVar(&o.tcp, "tcp", "", "TCP `probes` to make of the open ports they are for: "+strings.Join(tcpProbeNames(), ", ")+" or all")
+	fs.BoolVar(&o.ot, "ot", false, "Identify industrial devices: Modbus, S7 and DNP3 on their open ports, and BACnet")
+	fs.BoolVar(&o.otSafe, "ot-safe", false, "Like --ot, one host and one probe at a time with a pause between, for fragile controllers")
+	fs.BoolVar(&o.containers, "containers", false, "Check open Docker, kubelet, Kubernetes API and etcd ports for access without credentials")
+	fs.BoolVar(&o.openInst, "open-instances", false, "Check for Elasticsearch, Kibana and Prometheus instances that answer without credentials")
+	fs.BoolVar(&o.endpoints, "endpoints", false, "Classify open web and gRPC ports as gRPC, WebSocket or plain HTTP endpoints")
+	fs.BoolVar(&o.skipCDN, "skip-cdn", false, "Probe targets behind Cloudflare, Akamai or Fastly on 80 and 443 only, as the CDN answers the rest")
+	fs.BoolVar(&o.honeypots, "honeypots", false, "Flag hosts that look like honeypots or tarpits, such as those with every port open")
+	fs.BoolVar(&o.vpn, "vpn", false, "Probe each host for IKE (UDP 500, 4500) and OpenVPN (UDP 1194), and guess at WireGuard")
+	fs.BoolVar(&o.dtls, "dtls", false, "Probe each host's usual DTLS ports (VPN, WebRTC, CoAP), reporting version, cipher and certificate")
+	fs.StringVar(&o.dnsCache, "dns-cache", "", "Keep hostname lookups in this `file` across runs, for as long as their TTL allows")
+	fs.StringVar(&o.prefer, "prefer", "", "Address family to probe hostnames over: ipv4, ipv6 or both (default: the dialer's choice)")
+	fs.BoolVar(&o.whois, "whois", false, "Add the owner and abuse contact of public hosts' networks, from RDAP")
+	fs.StringVar(&o.db, "db", "", "Store the results in the PostgreSQL database at this `url`")
+	fs.StringVar(&o.notify, "notify", "", "Post summaries to chat: slack, discord, teams (comma-separated)")
+	o.chatURLs = make(map[string]string)
+	for _, name := range chatServiceNames() {
+		fs.Func(name+"-webhook", "Incoming webhook `url` for --notify "+name, func(s string) error {
+			o.chatURLs[name] = s
+			return nil
+		})
+	}
+
+	o.bindLog(fs)
+
+	fs.Usage = func() { writeCommandHelp(fs.Output(), name, fs, doc, false) }
+	return fs
+}
+
+// runScan implements the "scan" command.
+func runScan(args []string) {
+	var o scanOptions
+	fs := o.flagSet()
+	_ = fs.Parse(args)
+
+	// Flags given on the command line win over the profile.
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	o.args = args
+	o.execute(fs, set)
+}
+
+// execute runs the scan o describes, for "scan" and "import"; set holds the
+// options given on the command line. It exits the process on error.
+func (o *scanOptions) execute(fs *flag.FlagSet, set map[string]bool) {
+	if err := o.startLog(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(2)
+	}
+	configPath := o.config
+	if configPath == "" {
+		configPath = defaultConfigPath()
+	}
+	cfg, err := loadConfig(configPath, o.config != "")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading config: %v\n", err)
+		os.Exit(2)
+	}
+
+	plan, err := o.plan(cfg, set)
+	if errors.Is(err, errNoPorts) {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		if o.host == "" {
+			fs.Usage()
+		}
+		os.Exit(2)
+	}
+	if !validOutput(o.output) {
+		fmt.Fprintf(os.Stderr, "error: unknown --output %q (want %s)\n", o.output, strings.Join(outputFormats, ", "))
+		os.Exit(2)
+	}
+
+	if o.syslogAddr != "" && o.output != "syslog" {
+		fmt.Fprintln(os.Stderr, "error: --syslog-addr needs --output syslog")
+		os.Exit(2)
+	}
+	if o.watch && o.output == "html" {
+		fmt.Fprintln(os.Stderr, "error: --watch cannot be combined with --output html")
+		os.Exit(2)
+	}
+	if o.watch && o.tui {
+		fmt.Fprintln(os.Stderr, "error: --watch cannot be combined with --tui")
+		os.Exit(2)
+	}
+	if o.watch && o.interval < minInterval {
+		fmt.Fprintf(os.Stderr, "error: --interval %s is shorter than %s\n", o.interval, minInterval)
+		os.Exit(2)
+	}
+
+	if o.resumed != nil {
+		// Only the probes the interrupted scan had not made are left.
+		if plan.hostPorts, err = o.resumed.pendingPorts(); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s: %v\n", o.checkpoint, err)
+			os.Exit(1)
+		}
+	}
+
+	notify, err := o.notifiers(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(2)
+	}
+	enrich, err := newEnrichment(o.enrich, o.shodanKey, o.whois)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(2)
+	}
+
+	if o.tui && !o.dryRun && (!isTerminal(os.Stdin) || !isTerminal(os.Stdout)) {
+		fmt.Fprintln(os.Stderr, "error: --tui needs a terminal on stdin and stdout")
+		os.Exit(2)
+	}
+
+	scope, err := loadScope()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(2)
+	}
+	// With --dns-cache the probes may dial cached addresses, so those are
+	// the ones checked.
+	resolve := net.LookupHost
+	if plan.dnsCache != "" {
+		plan.dns = newDNSCache(plan.dnsCache)
+		resolve = plan.dns.lookupHost
+	}
+	scopeErr := scope.check(plan.targets, o.override, resolve)
+	plan.scope = scope
+	plan.frontCDN(resolve)
+	warnings := scanWarnings(plan.targets, plan.probes(), confirmLimit(cfg.ConfirmProbes), cfg.AllowPublicHosts, resolve)
+
+	audit := newAuditLog(cfg.AuditLog, fs)
+	params := plan.params(o.profile)
+	if o.dryRun {
+		if err := audit.record(auditEntry{Event: auditDryRun, Params: &params}); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		printDryRun(plan, enrich)
+		if o.watch {
+			fmt.Printf("Watch: rescan every %s\n", o.interval)
+		}
+		if scopeErr != nil {
+			fmt.Printf("Refused: %v\n", scopeErr)
+		}
+		for _, w := range warnings {
+			fmt.Printf("Needs confirmation: %s\n", w)
+		}
+		return
+	}
+
+	if scopeErr != nil {
+		if err := audit.record(auditEntry{Event: auditRefused, Params: &params, Reason: scopeErr.Error()}); err != nil {
+			slog.Warn("audit log not written", "err", err)
+		}
+		fmt.Fprintf(os.Stderr, "error: %v\n", scopeErr)
+		os.Exit(2)
+	}
+
+	if len(warnings) > 0 && !o.yes && !confirmScan(os.Stdin, os.Stderr, warnings) {
+		if err := audit.record(auditEntry{Event: auditRefused, Params: &params, Reason: "not confirmed: " + strings.Join(warnings, "; ")}); err != nil {
+			slog.Warn("audit log not written", "err", err)
+		}
+		os.Exit(2)
+	}
+
+	if o.coordinate != "" {
+		token := os.Getenv(agentTokenEnv)
+		if token == "" {
+			fmt.Fprintf(os.Stderr, "error: --coordinate needs a shared secret in $%s\n", agentTokenEnv)
+			os.Exit(2)
+		}
+		lis, err := net.Listen("tcp", o.coordinate)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		plan.coord = newCoordinator(token)
+		srv := &http.Server{Handler: plan.coord.handler(), ReadHeaderTimeout: 10 * time.Second}
+		go srv.Serve(lis)
+		defer srv.Close()
+		slog.Info("coordinator listening for agents", "addr", lis.Addr().String())
+	}
+
+	for _, t := range sshTunnels(plan.proxy) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		_, err := t.connect(ctx)
+		cancel()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s: %v\n", t, err)
+			os.Exit(1)
+		}
+		defer t.Close()
+	}
+
+	var out io.Writer = os.Stdout
+	output := "stdout"
+	switch {
+	case o.outputFile != "":
+		output = o.outputFile
+		f, err := os.Create(o.outputFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		out = f
+	case o.output == "syslog":
+		c, err := dialSyslog(o.syslogAddr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		defer c.Close()
+		out = c
+		output = "syslog"
+	}
+
+	if o.errorsFile != "" {
+		flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if o.resumed != nil {
+			// The interrupted scan wrote the errors of its own probes.
+			flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+		f, err := os.OpenFile(o.errorsFile, flags, 0o666)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		plan.errLog = newErrorLog(f)
+		defer func() {
+			if err := plan.errLog.writeErr(); err != nil {
+				slog.Warn("errors file not fully written", "file", o.errorsFile, "err", err)
+			}
+		}()
+	}
+
+	// The audit log records a hash of everything written to out.
+	sum := sha256.New()
+	out = io.MultiWriter(out, sum)
+	if err := audit.record(auditEntry{Event: auditStart, Params: &params, Tags: plan.tags}); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	finish := func(r *Report) {
+		e := auditEntry{Event: auditFinish, Output: output, OutputSHA256: hex.EncodeToString(sum.Sum(nil))}
+		if r != nil {
+			open := countOpen(r.Hosts)
+			e.OpenPorts, e.Canceled = &open, r.Canceled
+		}
+		if err := audit.record(e); err != nil {
+			slog.Warn("audit log not written", "err", err)
+		}
+	}
+
+	if o.watch {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		fmt.Fprintf(os.Stderr, "Watching %s every %s; press Ctrl-C to stop\n", o.host, o.interval)
+		w := &watcher{plan: plan, profile: o.profile, every: o.interval, out: out, format: o.output, notify: notify, enrich: enrich}
+		err := w.run(ctx)
+		finish(nil)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error writing output: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	started := time.Now()
+	if o.resumed != nil {
+		started = o.resumed.Started
+	}
+	var hosts []HostResult
+	canceled := false
+	if o.tui {
+		hosts, canceled, err = runTUI(plan)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+	} else {
+		ctx := context.Background()
+		if o.checkpoint != "" {
+			var stop context.CancelFunc
+			ctx, stop = signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			plan.tracker = newProbeTracker()
+		}
+		var pf proxyFailures
+		hosts = plan.run(ctx, scanHooks{failed: pf.add})
+		pf.warn()
+		if o.resumed != nil {
+			hosts = o.resumed.merge(hosts)
+		}
+		if ctx.Err() != nil {
+			finish(nil)
+			n, err := saveCheckpoint(o.checkpoint, o.args, started, plan, hosts)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: saving checkpoint: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Fprintf(os.Stderr, "Interrupted with %d probes left; run \"pscanner resume %s\" to finish the scan\n", n, o.checkpoint)
+			os.Exit(130) // as a shell reports a process stopped by SIGINT
+		}
+	}
+	plan.probeHoneypots(context.Background(), hosts)
+	plan.traceRoutes(context.Background(), hosts)
+	plan.probeQUIC(context.Background(), hosts)
+	plan.probeDTLS(context.Background(), hosts)
+	plan.probeVPN(context.Background(), hosts)
+	plan.probeUDP(context.Background(), hosts)
+	plan.probeTCP(context.Background(), hosts)
+	plan.probeOT(context.Background(), hosts)
+	plan.probeContainers(context.Background(), hosts)
+	plan.probeOpenInstances(context.Background(), hosts)
+	plan.probeEndpoints(context.Background(), hosts)
+	plan.probeVHosts(context.Background(), hosts)
+	plan.probeHTTPPaths(context.Background(), hosts)
+	plan.probeSafeChecks(context.Background(), hosts)
+	plan.probeScreenshots(context.Background(), hosts)
+	plan.probePayloads(context.Background(), hosts)
+	plan.probeScripts(context.Background(), hosts)
+	enrich.apply(context.Background(), hosts)
+	report := newReport(plan, o.profile, started, hosts, canceled)
+	err = writeReport(out, o.output, report)
+	finish(report)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error writing output: %v\n", err)
+		os.Exit(1)
+	}
+	if o.resumed != nil {
+		if err := os.Remove(o.checkpoint); err != nil {
+			slog.Warn("checkpoint not removed", "file", o.checkpoint, "err", err)
+		}
+	}
+	for _, n := range notify {
+		n(scanRun{Report: report})
+	}
+}
+
+// notifiers builds the --webhook, --notify, --email-to, --elasticsearch,
+// --publish, --upload and --db sinks.
+func (o *scanOptions) notifiers(cfg *Config) ([]func(scanRun), error) {
+	var on []string
+	if o.webhookOn != "" {
+		on = strings.Split(o.webhookOn, ",")
+	}
+	var notify []func(scanRun)
+	if o.webhook != "" {
+		w, err := newWebhook(Webhook{URL: o.webhook, Secret: os.Getenv("PSCANNER_WEBHOOK_SECRET"), On: on, AlertPorts: o.alertPorts}, cfg.portGroups())
+		if err != nil {
+			return nil, err
+		}
+		notify = append(notify, w.notify)
+	}
+
+	services := make(map[string]bool)
+	for _, s := range strings.Split(o.notify, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			services[s] = true
+		}
+	}
+	for s := range o.chatURLs {
+		services[s] = true
+	}
+	for _, s := range slices.Sorted(maps.Keys(services)) {
+		if _, ok := chatServices[s]; !ok {
+			return nil, fmt.Errorf("unknown --notify service %q (want %s)", s, strings.Join(chatServiceNames(), ", "))
+		}
+		c := cfg.Notifiers[s]
+		if u := o.chatURLs[s]; u != "" {
+			c.URL = u
+		}
+		if c.URL == "" {
+			return nil, fmt.Errorf("--notify %s needs --%s-webhook or a URL under \"notifiers\" in the config file", s, s)
+		}
+		if on != nil {
+			c.On = on
+		}
+		if o.alertPorts != "" {
+			c.AlertPorts = o.alertPorts
+		}
+		w, err := newChatNotifier(s, c, cfg.portGroups())
+		if err != nil {
+			return nil, err
+		}
+		notify = append(notify, w.notify)
+	}
+
+	if o.emailTo != "" || o.smtp != "" {
+		e := Email{}
+		if cfg.Email != nil {
+			e = *cfg.Email
+		}
+		if o.smtp != "" {
+			e.SMTP = o.smtp
+		}
+		if o.emailTo != "" {
+			e.To = strings.Split(o.emailTo, ",")
+		}
+		if o.emailFrom != "" {
+			e.From = o.emailFrom
+		}
+		if on != nil {
+			e.On = on
+		}
+		if o.alertPorts != "" {
+			e.AlertPorts = o.alertPorts
+		}
+		if e.SMTP == "" {
+			return nil, errors.New("--email-to needs --smtp or an \"email\" server in the config file")
+		}
+		x, err := newEmailer(e, cfg.portGroups())
+		if err != nil {
+			return nil, err
+		}
+		notify = append(notify, x.notify)
+	}
+
+	if o.esURL != "" {
+		x, err := newESExporter(Elasticsearch{URL: o.esURL, Index: o.esIndex, APIKey: os.Getenv("PSCANNER_ELASTICSEARCH_API_KEY")})
+		if err != nil {
+			return nil, err
+		}
+		notify = append(notify, x.notify)
+	}
+
+	for _, u := range o.publish {
+		p, err := newPublisher(u)
+		if err != nil {
+			return nil, err
+		}
+		notify = append(notify, p.notify)
+	}
+
+	if o.upload != "" {
+		up, err := newUploader(Upload{URL: o.upload, Formats: []string{o.output}})
+		if err != nil {
+			return nil, err
+		}
+		notify = append(notify, up.notify)
+	}
+
+	if o.db != "" {
+		db, err := openDB(context.Background(), o.db)
+		if err != nil {
+			return nil, fmt.Errorf("database: %v", err)
+		}
+		notify = append(notify, db.notify)
+	}
+
+	if len(notify) == 0 && (o.webhookOn != "" || o.alertPorts != "") {
+		return nil, errors.New("--webhook-on and --alert-ports need --webhook, --notify or --email-to")
+	}
+	return notify, nil
+}
+
+// errNoPorts is returned by plan when the port list expands to nothing.
+var errNoPorts = errors.New("no ports to scan")
+
+// plan applies the profile to every setting not in set, validates the
+// options and resolves the targets and ports into a scan plan.
+func (o *scanOptions) plan(cfg *Config, set map[string]bool) (*scanPlan, error) {
+	if o.profile != "" {
+		prof, err := cfg.profile(o.profile)
+		if err != nil {
+			return nil, err
+		}
+		if !set["ports"] && !set["top-ports"] {
+			if prof.Ports != "" {
+				o.ports = prof.Ports
+			}
+			if prof.TopPorts != 0 {
+				o.topPorts = prof.TopPorts
+			}
+		}
+		if prof.Workers != 0 && !set["workers"] {
+			o.workers = prof.Workers
+		}
+		if prof.Timeout != 0 && !set["timeout"] {
+			o.timeout = time.Duration(prof.Timeout)
+		}
+		if prof.HostTimeout != 0 && !set["host-timeout"] {
+			o.hostTimeout = time.Duration(prof.HostTimeout)
+		}
+		if prof.Delay != 0 && !set["delay"] {
+			o.delay = time.Duration(prof.Delay)
+		}
+		if prof.Output != "" && !set["output"] {
+			o.output = prof.Output
+		}
+	}
+
+	if o.otSafe {
+		o.ot = true
+		if !set["workers"] {
+			o.workers = 1
+		}
+		if !set["delay"] {
+			o.delay = time.Second
+		}
+	}
+
+	var local []localNet
+	if o.localNet {
+		if o.host != "" {
+			return nil, errors.New("--local-net and --host are mutually exclusive")
+		}
+		var err error
+		if local, err = findLocalNets(o.iface); err != nil {
+			return nil, err
+		}
+		o.host = localNetTargets(local)
+	}
+	if o.host == "" {
+		return nil, errors.New("--host is required")
+	}
+	if o.workers <= 0 {
+		return nil, errors.New("--workers must be > 0")
+	}
+	if o.workers > 10000 {
+		return nil, errors.New("--workers too large (max 10000)")
+	}
+	if o.retries < 0 || o.retries > maxRetries {
+		return nil, fmt.Errorf("--retries must be between 0 and %d", maxRetries)
+	}
+	if set["ports"] && set["top-ports"] {
+		return nil, errors.New("--ports and --top-ports are mutually exclusive")
+	}
+
+	var ports []int
+	var err error
+	if o.topPorts != 0 {
+		ports, err = topPorts(o.topPorts)
+	} else {
+		ports, err = parsePorts(o.ports, cfg.portGroups())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing ports: %v", err)
+	}
+	if len(ports) == 0 {
+		return nil, errNoPorts
+	}
+
+	targets, err := parseTargets(o.host)
+	if err != nil {
+		return nil, fmt.Errorf("parsing hosts: %v", err)
+	}
+	numTargets := targets.count()
+	if numTargets == 0 {
+		return nil, errors.New("--host is required")
+	}
+	if err := targets.checkZones(); err != nil {
+		return nil, err
+	}
+	if targets.hasZones() && (o.proxy != "" || o.viaSSH != "" || o.coordinate != "") {
+		// A zone names an interface of this machine.
+		return nil, errors.New("link-local targets with a zone cannot be scanned with --proxy, --via-ssh or --coordinate")
+	}
+	if o.coordinate != "" && (o.proxy != "" || o.viaSSH != "" || o.iface != "" || o.sourceIP != "" || o.sourcePort != 0) {
+		// The agents dial the targets, from where they are.
+		return nil, errors.New("--coordinate cannot be combined with --proxy, --via-ssh or source options; set --interface or --source-ip on the agents")
+	}
+	states, err := parseStates(o.state)
+	if err != nil {
+		return nil, err
+	}
+	notOpen := slices.Contains(states, "closed") || slices.Contains(states, "filtered")
+	if notOpen && (o.proxy != "" || o.viaSSH != "" || o.coordinate != "") {
+		return nil, errors.New("--state closed or filtered cannot be combined with --proxy, --via-ssh or --coordinate")
+	}
+	if o.stats && (o.proxy != "" || o.viaSSH != "" || o.coordinate != "") {
+		return nil, errors.New("--stats cannot be combined with --proxy, --via-ssh or --coordinate")
+	}
+	if o.inferFW && (o.proxy != "" || o.viaSSH != "" || o.coordinate != "") {
+		// Proxies and agents only say whether a port is open.
+		return nil, errors.New("--infer-firewall cannot be combined with --proxy, --via-ssh or --coordinate")
+	}
+	if o.hostOrder != "" && o.hostOrder != "input" && o.hostOrder != "ip" && o.hostOrder != "risk" {
+		return nil, fmt.Errorf("unknown --host-order %q (want input, ip or risk)", o.hostOrder)
+	}
+	prefer, err := parsePrefer(o.prefer)
+	if err != nil {
+		return nil, err
+	}
+	if prefer != "" && (o.proxy != "" || o.viaSSH != "" || o.coordinate != "") {
+		// Proxies and agents resolve hostnames themselves.
+		return nil, errors.New("--prefer cannot be combined with --proxy, --via-ssh or --coordinate")
+	}
+	if o.dnsCache != "" && (o.proxy != "" || o.viaSSH != "" || o.coordinate != "") {
+		return nil, errors.New("--dns-cache cannot be combined with --proxy, --via-ssh or --coordinate")
+	}
+	if o.follow < 0 || o.follow > maxFollow {
+		return nil, fmt.Errorf("--follow must be between 0 and %d", maxFollow)
+	}
+	var vhosts []string
+	if o.vhosts != "" {
+		if o.coordinate != "" {
+			return nil, errors.New("--vhosts cannot be combined with --coordinate")
+		}
+		if vhosts, err = loadVHosts(o.vhosts); err != nil {
+			return nil, fmt.Errorf("--vhosts: %v", err)
+		}
+	}
+	var httpPaths []string
+	if o.httpPaths != "" {
+		if o.coordinate != "" {
+			return nil, errors.New("--http-paths cannot be combined with --coordinate")
+		}
+		if httpPaths, err = parseHTTPPaths(o.httpPaths); err != nil {
+			return nil, fmt.Errorf("--http-paths: %v", err)
+		}
+	}
+	if o.checkpoint != "" && (o.watch || o.tui || o.coordinate != "") {
+		return nil, errors.New("--checkpoint cannot be combined with --watch, --tui or --coordinate")
+	}
+	if o.errorsFile != "" && o.coordinate != "" {
+		return nil, errors.New("--errors-file cannot be combined with --coordinate")
+	}
+	if o.safeChecks && o.coordinate != "" {
+		return nil, errors.New("--safe-checks cannot be combined with --coordinate")
+	}
+	var severity []severityRule
+	if o.severity != "" {
+		if severity, err = loadSeverityRules(o.severity); err != nil {
+			return nil, fmt.Errorf("--severity: %v", err)
+		}
+	}
+	if o.screenshotCmd != "" && o.screenshots == "" {
+		return nil, errors.New("--screenshot-command needs --screenshots")
+	}
+	var screenshotCmd []string
+	if o.screenshots != "" {
+		if o.proxy != "" || o.viaSSH != "" || o.coordinate != "" {
+			return nil, errors.New("--screenshots cannot be combined with --proxy, --via-ssh or --coordinate")
+		}
+		if screenshotCmd, err = screenshotCommand(o.screenshotCmd); err != nil {
+			return nil, err
+		}
+	}
+	if (o.follow > 0 || o.certNames) && o.coordinate != "" {
+		return nil, errors.New("--follow and --cert-names cannot be combined with --coordinate")
+	}
+	var snmpCommunity string
+	if o.snmpHarvest {
+		if o.proxy != "" || o.viaSSH != "" || o.coordinate != "" {
+			return nil, errors.New("--snmp-harvest cannot be combined with --proxy, --via-ssh or --coordinate")
+		}
+		if snmpCommunity = os.Getenv(snmpCommunityEnv); snmpCommunity == "" {
+			return nil, fmt.Errorf("--snmp-harvest needs the SNMP community in $%s", snmpCommunityEnv)
+		}
+	}
+	if o.traceroute {
+		switch {
+		case !tracerouteSupported:
+			return nil, errTraceUnsupported
+		case o.proxy != "" || o.viaSSH != "" || o.coordinate != "":
+			// The route would be this machine's, not that of the probes.
+			return nil, errors.New("--traceroute cannot be combined with --proxy, --via-ssh or --coordinate")
+		}
+	}
+	if o.quic && (o.proxy != "" || o.viaSSH != "" || o.coordinate != "") {
+		// None of them carries UDP.
+		return nil, errors.New("--quic cannot be combined with --proxy, --via-ssh or --coordinate")
+	}
+	if o.dtls && (o.proxy != "" || o.viaSSH != "" || o.coordinate != "") {
+		return nil, errors.New("--dtls cannot be combined with --proxy, --via-ssh or --coordinate")
+	}
+	if o.vpn && (o.proxy != "" || o.viaSSH != "" || o.coordinate != "") {
+		return nil, errors.New("--vpn cannot be combined with --proxy, --via-ssh or --coordinate")
+	}
+	udp, err := parseUDPProbes(o.udp)
+	if err != nil {
+		return nil, err
+	}
+	if udp != nil && (o.proxy != "" || o.viaSSH != "" || o.coordinate != "") {
+		return nil, errors.New("--udp cannot be combined with --proxy, --via-ssh or --coordinate")
+	}
+	if o.ot && o.coordinate != "" {
+		return nil, errors.New("--ot cannot be combined with --coordinate")
+	}
+	var plugins []*wasmPlugin
+	if o.plugin != "" {
+		dir := o.pluginDir
+		if dir == "" {
+			dir = defaultPluginDir()
+		}
+		if plugins, err = loadPlugins(o.plugin, dir); err != nil {
+			return nil, fmt.Errorf("--plugin: %v", err)
+		}
+	}
+	tcp, err := parseTCPProbes(o.tcp)
+	if err != nil {
+		return nil, err
+	}
+	if tcp != nil && o.coordinate != "" {
+		return nil, errors.New("--tcp cannot be combined with --coordinate")
+	}
+	if o.containers && o.coordinate != "" {
+		return nil, errors.New("--containers cannot be combined with --coordinate")
+	}
+	knock, err := parseKnock(o.knock)
+	if err != nil {
+		return nil, fmt.Errorf("--knock: %v", err)
+	}
+	var portTimeouts map[int]time.Duration
+	if o.portTimeout != "" {
+		if portTimeouts, err = parsePortTimeouts(o.portTimeout, cfg.portGroups()); err != nil {
+			return nil, fmt.Errorf("--port-timeout: %v", err)
+		}
+	}
+	if o.calibratePort < 0 || o.calibratePort > 65535 {
+		return nil, errors.New("--calibrate-port must be between 1 and 65535")
+	}
+	if o.calibratePort == 0 && (set["min-timeout"] || set["max-timeout"]) {
+		return nil, errors.New("--min-timeout and --max-timeout need --calibrate-port")
+	}
+	maxTimeout := o.maxTimeout
+	if maxTimeout == 0 {
+		maxTimeout = o.timeout
+	}
+	if o.calibratePort != 0 && (o.minTimeout <= 0 || maxTimeout < o.minTimeout) {
+		return nil, errors.New("--min-timeout must be > 0 and no more than --max-timeout")
+	}
+	// Tags set on the command line are checked as they are parsed; those
+	// of server requests and schedules are checked here.
+	for k, v := range o.tags {
+		if err := checkTag(k, v); err != nil {
+			return nil, fmt.Errorf("--tag: %v", err)
+		}
+	}
+	if len(o.payloads) > 0 && o.coordinate != "" {
+		return nil, errors.New("--payload cannot be combined with --coordinate")
+	}
+	var scripts []*portScript
+	if o.script != "" {
+		if o.coordinate != "" {
+			return nil, errors.New("--script cannot be combined with --coordinate")
+		}
+		dir := o.scriptDir
+		if dir == "" {
+			dir = defaultScriptDir()
+		}
+		if scripts, err = loadScripts(o.script, dir); err != nil {
+			return nil, fmt.Errorf("--script: %v", err)
+		}
+	}
+	if knock != nil && o.coordinate != "" {
+		return nil, errors.New("--knock cannot be combined with --coordinate")
+	}
+	if hasUDPKnock(knock) && (o.proxy != "" || o.viaSSH != "") {
+		return nil, errors.New("UDP knocks cannot be combined with --proxy or --via-ssh")
+	}
+	if o.openInst && o.coordinate != "" {
+		return nil, errors.New("--open-instances cannot be combined with --coordinate")
+	}
+	if o.endpoints && o.coordinate != "" {
+		return nil, errors.New("--endpoints cannot be combined with --coordinate")
+	}
+	if o.honeypots && o.coordinate != "" {
+		return nil, errors.New("--honeypots cannot be combined with --coordinate")
+	}
+	// The source applies to the first connection made: to the targets, the
+	// first proxy or the SSH server.
+	var source *sourceDialer
+	var first contextDialer
+	if o.sourcePort != 0 && (o.proxy != "" || o.viaSSH != "") {
+		// Every connection would go to the same proxy address and port,
+		// which one source port cannot carry at once.
+		return nil, errors.New("--source-port cannot be used with --proxy or --via-ssh")
+	}
+	if o.iface != "" || o.sourceIP != "" || o.sourcePort != 0 {
+		if source, err = newSourceDialer(o.iface, o.sourceIP, o.sourcePort); err != nil {
+			return nil, err
+		}
+		first = source
+	}
+	var proxy contextDialer
+	var proxyURL string
+	if o.proxy != "" {
+		if proxy, err = parseProxy(o.proxy, first); err != nil {
+			return nil, err
+		}
+		proxyURL = redactProxy(o.proxy)
+	} else if source != nil && o.viaSSH == "" {
+		if err := source.check(targets); err != nil {
+			return nil, err
+		}
+	}
+	if o.viaSSH != "" {
+		var forward contextDialer = &net.Dialer{}
+		switch {
+		case proxy != nil:
+			forward = proxy
+		case source != nil:
+			forward = source
+		}
+		t, err := parseSSHTarget(o.viaSSH, forward)
+		if err != nil {
+			return nil, err
+		}
+		if o.sshKey != "" {
+			t.keys = []string{o.sshKey}
+		}
+		proxy = t
+		if proxyURL != "" {
+			proxyURL += ","
+		}
+		proxyURL += t.String()
+	} else if o.sshKey != "" {
+		return nil, errors.New("--ssh-key needs --via-ssh")
+	}
+	var routes []string
+	if len(cfg.Routes) > 0 {
+		fallback := proxy
+		if fallback == nil {
+			fallback = first
+		}
+		if fallback == nil {
+			fallback = &net.Dialer{}
+		}
+		rd, err := newRouteDialer(cfg.Routes, first, fallback)
+		if err != nil {
+			return nil, err
+		}
+		if rd.covers(targets) {
+			if o.coordinate != "" || o.traceroute || o.quic || o.dtls || o.vpn || udp != nil || hasUDPKnock(knock) || o.inferFW || notOpen || o.stats || o.sourcePort != 0 || prefer != "" || o.dnsCache != "" || o.snmpHarvest {
+				return nil, errors.New("targets with a route in the config file cannot be scanned with --coordinate, --traceroute, --quic, --dtls, --vpn, --udp, UDP knocks, --infer-firewall, --state closed or filtered, --stats, --source-port, --prefer, --dns-cache or --snmp-harvest")
+			}
+			proxy = rd
+			for _, r := range rd.routes {
+				routes = append(routes, r.String())
+			}
+		}
+	}
+	p := &scanPlan{
+		targets:       targets,
+		numTargets:    numTargets,
+		ports:         ports,
+		workers:       o.workers,
+		timeout:       o.timeout,
+		hostTimeout:   o.hostTimeout,
+		delay:         o.delay,
+		retries:       o.retries,
+		force:         o.force,
+		portTimeouts:  portTimeouts,
+		calibratePort: o.calibratePort,
+		minTimeout:    o.minTimeout,
+		maxTimeout:    maxTimeout,
+		snmpCommunity: snmpCommunity,
+		follow:        o.follow,
+		certNames:     o.certNames,
+		vhosts:        vhosts,
+		vhostsPath:    o.vhosts,
+		screenshots:   o.screenshots,
+		screenshotCmd: screenshotCmd,
+		httpPaths:     httpPaths,
+		safeChecks:    o.safeChecks,
+		severity:      severity,
+		severityPath:  o.severity,
+		proxy:         proxy,
+		proxyURL:      proxyURL,
+		source:        source,
+		hostPorts:     o.imported,
+		coordinate:    o.coordinate,
+		localNets:     local,
+		traceroute:    o.traceroute,
+		quic:          o.quic,
+		dtls:          o.dtls,
+		vpn:           o.vpn,
+		udpProbes:     udp,
+		ot:            o.ot,
+		otSafe:        o.otSafe,
+		containers:    o.containers,
+		tcpProbes:     tcp,
+		instances:     o.openInst,
+		endpoints:     o.endpoints,
+		honeypots:     o.honeypots,
+		skipCDN:       o.skipCDN,
+		knock:         knock,
+		knockDelay:    o.knockDelay,
+		payloads:      o.payloads,
+		scripts:       scripts,
+		plugins:       plugins,
+		tags:          o.tags,
+		hostOrder:     o.hostOrder,
+		states:        states,
+		stats:         o.stats,
+		inferFirewall: o.inferFW,
+		prefer:        prefer,
+		dnsCache:      o.dnsCache,
+		routes:        routes,
+	}
+	if p.workers > p.probes() {
+		p.workers = p.probes()
+	}
+	return p, nil
+}
+
+// printDryRun reports what a scan would do without probing any target.
+// CIDR blocks are summarised rather than listed address by address.
+// Hostnames are resolved so the operator can check scope, which does send
+// DNS queries to the configured resolver. With --whois the owner of each
+// public target is looked up as well.
+func printDryRun(p *scanPlan, e *enrichment) {
+	whois := func(a netip.Addr) {
+		if e == nil || e.whois == nil || !isPublicAddr(a) {
+			return
+		}
+		switch info, err := e.whois.lookup(context.Background(), a); {
+		case err != nil:
+			fmt.Printf("    whois: (lookup failed: %v)\n", err)
+		case info == nil:
+			fmt.Println("    whois: (no registration found)")
+		default:
+			fmt.Printf("    whois: %s\n", info)
+		}
+	}
+	fmt.Println("Dry run: no probes will be sent.")
+	if len(p.localNets) > 0 {
+		fmt.Println("Local networks:")
+		for _, n := range p.localNets {
+			fmt.Printf("  %s\n", n)
+		}
+	}
+	fmt.Printf("Targets (%d):\n", p.numTargets)
+	for _, t := range p.targets {
+		var ports string
+		if p.hostPorts != nil {
+			ports = " [" + formatPorts(p.hostPorts[t.String()]) + "]"
+		}
+		switch {
+		case t.isBlock():
+			fmt.Printf("  %s (%d addresses: %s - %s)\n", t, t.size(), t.prefix.Addr(), lastAddr(t.prefix))
+			whois(t.prefix.Addr())
+		case t.name == "":
+			fmt.Printf("  %s%s\n", t, ports)
+			whois(t.prefix.Addr())
+		default:
+			ips, err := net.LookupHost(t.name)
+			if err != nil {
+				fmt.Printf("  %s%s -> (unresolved: %v)\n", t, ports, err)
+				continue
+			}
+			fmt.Printf("  %s%s -> %s\n", t, ports, strings.Join(ips, ", "))
+			for _, ip := range ips {
+				if a, err := netip.ParseAddr(ip); err == nil && isPublicAddr(a) {
+					whois(a)
+					break
+				}
+			}
+		}
+	}
+	fmt.Printf("Ports (%d): %s\n", len(p.ports), formatPorts(p.ports))
+	fmt.Printf("Probes: %d\n", p.probes())
+	fmt.Printf("Workers: %d\n", p.workers)
+	fmt.Printf("Timeout: %s\n", p.timeout)
+	if p.portTimeouts != nil {
+		fmt.Printf("Port timeouts: %s\n", formatPortTimeouts(p.portTimeouts))
+	}
+	if p.calibratePort != 0 {
+		fmt.Printf("Calibration: timing port %d of each host for a timeout between %s and %s\n", p.calibratePort, p.minTimeout, p.maxTimeout)
+	}
+	if p.snmpCommunity != "" {
+		fmt.Println("SNMP harvest: reading routers and switches that answer, then scanning the new hosts the scope allows")
+	}
+	if len(p.vhosts) > 0 {
+		fmt.Printf("Virtual hosts: asking the open web ports for %d names from %s\n", len(p.vhosts), p.vhostsPath)
+	}
+	if p.safeChecks {
+		fmt.Println("Safe checks: looking for Jenkins, Tomcat, Grafana and Spring consoles left open on the open web ports")
+	}
+	if len(p.httpPaths) > 0 {
+		fmt.Printf("HTTP paths: requesting %s of the open web ports\n", strings.Join(p.httpPaths, ", "))
+	}
+	if p.screenshots != "" {
+		fmt.Printf("Screenshots: saving the open web ports' pages in %s with %s\n", p.screenshots, p.screenshotCmd[0])
+	}
+	if p.certNames {
+		fmt.Println("Certificate names: reading the certificates of open TLS ports")
+	}
+	if p.follow > 0 {
+		fmt.Printf("Follow: up to %d rounds of the hosts the results point to, as the scope allows\n", p.follow)
+	}
+	if p.severityPath != "" {
+		fmt.Printf("Severity: %d rules from %s\n", len(p.severity), p.severityPath)
+	}
+	if len(p.tags) > 0 {
+		fmt.Printf("Tags: %s\n", formatTags(p.tags))
+	}
+	switch p.hostOrder {
+	case "ip":
+		fmt.Println("Host order: numerically by address")
+	case "risk":
+		fmt.Println("Host order: most severe ports and findings first")
+	}
+	if !slices.Equal(p.states, []string{"open"}) {
+		fmt.Printf("States: listing %s ports\n", strings.Join(p.states, ", "))
+	}
+	if p.stats {
+		fmt.Println("Stats: counting how the probes of each host end, and timing them")
+	}
+	if p.hostTimeout > 0 {
+		fmt.Printf("Host timeout: %s\n", p.hostTimeout)
+	}
+	if p.delay > 0 {
+		fmt.Printf("Delay: %s\n", p.delay)
+	}
+	if p.retries > 0 {
+		fmt.Printf("Retries: up to %d per probe that times out\n", p.retries)
+	}
+	if p.force {
+		fmt.Println("Force: probing every port of hosts that appear down")
+	}
+	if len(p.knock) > 0 {
+		steps := make([]string, len(p.knock))
+		for i, k := range p.knock {
+			steps[i] = k.String()
+		}
+		fmt.Printf("Knock: %s before each host, %s apart\n", strings.Join(steps, ", "), p.knockDelay)
+	}
+	if p.source != nil {
+		fmt.Printf("Source: %s\n", p.source)
+	}
+	if p.proxyURL != "" {
+		fmt.Printf("Proxy: %s\n", p.proxyURL)
+	}
+	if len(p.routes) > 0 {
+		fmt.Println("Routes:")
+		for _, r := range p.routes {
+			fmt.Printf("  %s\n", r)
+		}
+	}
+	if p.dnsCache != "" {
+		fmt.Printf("DNS cache: %s\n", p.dnsCache)
+	}
+	if p.prefer != "" {
+		fmt.Printf("Prefer: %s for hostnames\n", p.prefer)
+	}
+	if p.inferFirewall {
+		fmt.Println("Infer firewall: counting how closed ports answer")
+	}
+	if p.traceroute {
+		fmt.Println("Traceroute: to the first open port of each host")
+	}
+	if p.quic {
+		fmt.Printf("QUIC: probing udp/%d of each host\n", quicPort)
+	}
+	if p.dtls {
+		fmt.Printf("DTLS: probing udp/%s of each host\n", formatPorts(dtlsPorts))
+	}
+	for _, name := range p.udpProbes {
+		fmt.Printf("UDP: probing %s on udp/%d of each host\n", name, udpProbes[name].port)
+	}
+	if p.ot {
+		bacnet := fmt.Sprintf(", BACnet on udp/%d of each host", bacnetPort)
+		if p.proxy != nil {
+			bacnet = ""
+		}
+		safe := ""
+		if p.otSafe {
+			safe = ", one host at a time"
+		}
+		fmt.Printf("OT: identifying Modbus, S7 and DNP3 on their open ports%s%s\n", bacnet, safe)
+	}
+	for _, name := range p.tcpProbes {
+		fmt.Printf("TCP: probing %s on open tcp/%s\n", name, formatPorts(tcpProbes[name].ports))
+	}
+	if p.containers {
+		fmt.Println("Containers: checking open Docker, kubelet, Kubernetes API and etcd ports")
+	}
+	if p.instances {
+		fmt.Println("Open instances: checking open Elasticsearch, Kibana and Prometheus ports, and @web ports for them")
+	}
+	if p.endpoints {
+		fmt.Printf("Endpoints: classifying open @web ports and tcp/%d as gRPC, WebSocket or HTTP\n", grpcPort)
+	}
+	for _, pp := range p.payloads {
+		fmt.Printf("Payload: %d bytes to tcp/%d where open\n", len(pp.data), pp.port)
+	}
+	for _, s := range p.scripts {
+		var takes []string
+		if s.ports != nil {
+			takes = append(takes, "tcp/"+formatPorts(s.ports))
+		}
+		if s.portrule != nil {
+			takes = append(takes, "the ports its portrule takes")
+		}
+		fmt.Printf("Script: %s on open %s\n", s.name, strings.Join(takes, " and "))
+	}
+	for _, w := range p.plugins {
+		if w.info.Kind == "probe" {
+			fmt.Printf("Plugin: %s, a --tcp probe of tcp/%s\n", w.name, formatPorts(w.info.Ports))
+		} else {
+			fmt.Printf("Plugin: %s, an --output format\n", w.name)
+		}
+	}
+	if p.skipCDN {
+		fmt.Printf("Skip CDN: targets behind Cloudflare, Akamai or Fastly probed on %s only, %d probes saved\n", formatPorts(cdnEdgePorts), p.cdnSkipped)
+	}
+	if p.honeypots {
+		fmt.Printf("Honeypots: sampling %d open ports of hosts with %d or more for honeypot and tarpit signs\n", honeypotSample, honeypotMinOpen)
+	}
+	if p.vpn {
+		fmt.Printf("VPN: probing IKE on udp/%d and udp/%d, OpenVPN on udp/%d and WireGuard on udp/%s of each host\n",
+			ikePort, ikeNATTPort, openVPNPort, formatPorts(slices.Sorted(slices.Values(wireGuardPorts))))
+	}
+	if o := p.coordinate; o != "" {
+		fmt.Printf("Coordinate: agents join at %s\n", o)
+	}
+	// Every probe timing out is the worst case; open and closed ports
+	// answer faster. Each worker also pauses for the delay after a probe.
+	// With --calibrate-port, no host's timeout is more than --max-timeout.
+	timeout := p.timeout
+	if p.calibratePort != 0 {
+		timeout = p.maxTimeout
+	}
+	rounds := (p.probes() + p.workers - 1) / p.workers
+	estimate := time.Duration(rounds) * (timeout + p.delay)
+	// Ports with a --port-timeout of their own add the difference, for each
+	// host they are probed on, spread over the workers; so do the
+	// calibration connects of each host.
+	if len(p.ports) > 0 {
+		hosts := p.probes() / len(p.ports)
+		var extra time.Duration
+		for port, t := range p.portTimeouts {
+			if slices.Contains(p.ports, port) {
+				extra += time.Duration(hosts) * (t - timeout)
+			}
+		}
+		if p.calibratePort != 0 {
+			extra += time.Duration(hosts*calibrationProbes) * p.maxTimeout
+		}
+		estimate = max(estimate+extra/time.Duration(p.workers), 0)
+	}
+	fmt.Printf("Estimated duration: up to %s\n", estimate.Round(time.Millisecond))
+}
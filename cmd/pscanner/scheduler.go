@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// scanRun is a finished run of a recurring scan, as passed to notifiers.
+type scanRun struct {
+	Schedule string // empty for scan --watch
+	Report   *Report
+	// Diff compares the run with the previous complete run of the same
+	// schedule or watch; it is nil for the first run.
+	Diff *reportDiff
+}
+
+// scheduleEntry is a schedule with its parsed cadence.
+type scheduleEntry struct {
+	Schedule
+	when cadence
+}
+
+// scheduler starts the scans defined in the config file's "schedules" and
+// reports each finished run to its notifiers.
+type scheduler struct {
+	jobs    *jobManager
+	entries []scheduleEntry
+	notify  []func(scanRun)
+}
+
+// newScheduler checks every schedule up front, so that a mistake in the
+// config file stops the server at startup rather than at the first run.
+func newScheduler(jobs *jobManager, schedules []Schedule) (*scheduler, error) {
+	s := &scheduler{jobs: jobs, notify: []func(scanRun){logScheduleRun}}
+	seen := make(map[string]bool)
+	for _, sc := range schedules {
+		if sc.Name == "" {
+			return nil, errors.New("schedule without a name")
+		}
+		if seen[sc.Name] {
+			return nil, fmt.Errorf("duplicate schedule %q", sc.Name)
+		}
+		seen[sc.Name] = true
+		when, err := parseCadence(sc.When)
+		if err != nil {
+			return nil, fmt.Errorf("schedule %q: %v", sc.Name, err)
+		}
+		if _, _, err := jobs.prepare(&sc.scanRequest); err != nil {
+			return nil, fmt.Errorf("schedule %q: %v", sc.Name, err)
+		}
+		s.entries = append(s.entries, scheduleEntry{sc, when})
+	}
+	return s, nil
+}
+
+// run starts every schedule and returns at once; the schedules stop when
+// ctx is done.
+func (s *scheduler) run(ctx context.Context) {
+	for i := range s.entries {
+		go s.loop(ctx, &s.entries[i])
+	}
+}
+
+func (s *scheduler) loop(ctx context.Context, e *scheduleEntry) {
+	prev := s.lastRun(e.Name)
+	next := firstRun(e.when, prev, time.Now())
+	for {
+		if next.IsZero() {
+			slog.Warn("schedule never matches; not running it", "schedule", e.Name, "when", e.When)
+			return
+		}
+		select {
+		case <-time.After(time.Until(next)):
+		case <-ctx.Done():
+			return
+		}
+
+		started := time.Now()
+		j, err := s.jobs.submitScheduled(&e.scanRequest, e.Name)
+		if err != nil {
+			slog.Error("scheduled scan not started", "schedule", e.Name, "err", err)
+		} else if report, err := j.follow(ctx, func(openPort) error { return nil }); err == nil {
+			run := scanRun{Schedule: e.Name, Report: report}
+			if prev != nil {
+				d := diffReports(prev, report)
+				run.Diff = &d
+			}
+			for _, n := range s.notify {
+				n(run)
+			}
+			if !report.Canceled {
+				prev = report
+			}
+		}
+
+		// Runs never overlap: a scan that outlasts its interval is followed
+		// by the next one straight away.
+		next = e.when.next(started)
+		if now := time.Now(); next.Before(now) {
+			next = now
+		}
+	}
+}
+
+// firstRun picks the first run time after a (re)start. Interval schedules
+// continue from their previous run instead of scanning again on every
+// restart; cron schedules simply wait for their next match.
+func firstRun(when cadence, prev *Report, now time.Time) time.Time {
+	if _, ok := when.(interval); !ok {
+		return when.next(now)
+	}
+	if prev == nil {
+		return now
+	}
+	if next := when.next(prev.StartedAt); next.After(now) {
+		return next
+	}
+	return now
+}
+
+// lastRun returns the newest complete report of the named schedule from
+// the result store, or nil.
+func (s *scheduler) lastRun(name string) *Report {
+	if s.jobs.store == nil {
+		return nil
+	}
+	reports, err := s.jobs.store.list()
+	if err != nil {
+		return nil
+	}
+	for i := len(reports) - 1; i >= 0; i-- {
+		if r := reports[i]; r.Schedule == name && !r.Canceled {
+			return r
+		}
+	}
+	return nil
+}
+
+// logScheduleRun is the built-in notifier: one log line per run.
+func logScheduleRun(run scanRun) {
+	open := 0
+	for _, h := range run.Report.Hosts {
+		open += len(h.Ports)
+	}
+	attrs := []any{"schedule", run.Schedule, "scan", run.Report.ID, "open_ports", open}
+	if run.Report.Canceled {
+		attrs = append(attrs, "canceled", true)
+	}
+	if run.Diff != nil {
+		attrs = append(attrs, "newly_open", len(run.Diff.Opened), "closed", len(run.Diff.Closed))
+	}
+	slog.Info("scheduled scan finished", attrs...)
+}
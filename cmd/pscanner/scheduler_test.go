@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestScheduleConfig(t *testing.T) {
+	var cfg Config
+	err := json.Unmarshal([]byte(`{"schedules": [
+		{"name": "web", "when": "every 6h", "hosts": "10.0.0.0/24", "ports": "@web", "timeout": "1s"}
+	]}`), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := cfg.Schedules[0]
+	if sc.Name != "web" || sc.When != "every 6h" || sc.Hosts != "10.0.0.0/24" || sc.Ports != "@web" || sc.Timeout == nil {
+		t.Errorf("schedule decoded as %+v", sc)
+	}
+}
+
+func TestNewSchedulerValidates(t *testing.T) {
+	jobs := newJobManager(&Config{}, nil, net.LookupHost)
+	ok := Schedule{Name: "a", When: "@daily", scanRequest: scanRequest{Hosts: "10.0.0.1", Ports: "22"}}
+	tests := []struct {
+		schedules []Schedule
+		err       string
+	}{
+		{[]Schedule{ok}, ""},
+		{[]Schedule{ok, ok}, "duplicate"},
+		{[]Schedule{{When: "@daily", scanRequest: ok.scanRequest}}, "without a name"},
+		{[]Schedule{{Name: "b", When: "sometimes", scanRequest: ok.scanRequest}}, "invalid schedule"},
+		{[]Schedule{{Name: "c", When: "@daily"}}, "--host is required"},
+		{[]Schedule{{Name: "d", When: "@daily", scanRequest: scanRequest{Hosts: "8.8.8.8", Ports: "53"}}}, "needs confirmation"},
+	}
+	for _, tt := range tests {
+		_, err := newScheduler(jobs, tt.schedules)
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("newScheduler(%+v) = %v, want error containing %q", tt.schedules, err, tt.err)
+		}
+	}
+}
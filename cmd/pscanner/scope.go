@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// scopePath is the organization's scope file. When it exists, every scan
+// run on this machine must stay inside the networks it lists. It is fixed,
+// so that users cannot point pscanner at a scope of their own; tests set
+// it.
+var scopePath = "/etc/pscanner/scope.yaml"
+
+// scanScope is a parsed scope file.
+type scanScope struct {
+	path  string
+	allow []netip.Prefix
+	// allowOverride lets --override-scope scan outside the scope anyway.
+	allowOverride bool
+}
+
+// loadScope reads the scope file. It returns nil when there is none.
+func loadScope() (*scanScope, error) {
+	data, err := os.ReadFile(scopePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("scope file: %v", err)
+	}
+	s, err := parseScope(data)
+	if err != nil {
+		return nil, fmt.Errorf("scope file %s: %v", scopePath, err)
+	}
+	s.path = scopePath
+	return s, nil
+}
+
+// parseScope parses the small YAML subset scope files are written in:
+//
+//	# Networks we are engaged to test.
+//	allow:
+//	  - 10.20.0.0/16
+//	  - 203.0.113.7
+//	allow_override: false
+//
+// allow may also be a flow list, [10.20.0.0/16, 203.0.113.7].
+func parseScope(data []byte) (*scanScope, error) {
+	s := &scanScope{allowOverride: true}
+	inAllow := false
+	addPrefix := func(line int, v string) error {
+		v = strings.Trim(strings.TrimSpace(v), `"'`)
+		p, err := netip.ParsePrefix(v)
+		if err != nil {
+			a, aerr := netip.ParseAddr(v)
+			if aerr != nil {
+				return fmt.Errorf("line %d: %q is not an IP address or CIDR block", line, v)
+			}
+			p = netip.PrefixFrom(a, a.BitLen())
+		}
+		s.allow = append(s.allow, p.Masked())
+		return nil
+	}
+	for i, raw := range strings.Split(string(data), "\n") {
+		line := i + 1
+		text, _, _ := strings.Cut(raw, "#")
+		text = strings.TrimRight(text, " \t\r")
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		if item, ok := strings.CutPrefix(strings.TrimSpace(text), "- "); ok {
+			if !inAllow {
+				return nil, fmt.Errorf("line %d: list item outside allow", line)
+			}
+			if err := addPrefix(line, item); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(text, ":")
+		if !ok || key != strings.TrimSpace(key) {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", line)
+		}
+		value = strings.TrimSpace(value)
+		inAllow = false
+		switch key {
+		case "allow":
+			if value == "" {
+				inAllow = true
+				continue
+			}
+			list, ok := strings.CutPrefix(value, "[")
+			if list, ok = strings.CutSuffix(list, "]"); !ok {
+				return nil, fmt.Errorf("line %d: allow must be a list", line)
+			}
+			for _, v := range strings.Split(list, ",") {
+				if strings.TrimSpace(v) == "" {
+					continue
+				}
+				if err := addPrefix(line, v); err != nil {
+					return nil, err
+				}
+			}
+		case "allow_override":
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: allow_override must be true or false", line)
+			}
+			s.allowOverride = b
+		default:
+			return nil, fmt.Errorf("line %d: unknown key %q", line, key)
+		}
+	}
+	return s, nil
+}
+
+// contains reports whether every address of p is in the scope.
+func (s *scanScope) contains(p netip.Prefix) bool {
+	if p.Addr().Is4In6() && p.Bits() >= 96 {
+		p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+	}
+	for _, a := range s.allow {
+		if a.Bits() <= p.Bits() && a.Contains(p.Addr()) {
+			return true
+		}
+	}
+	return false
+}
+
+// outside returns the targets that reach beyond the scope. Hostnames are
+// judged by every address resolve returns. A name that does not resolve
+// now is outside too: whatever reaches it later, through a proxy, an SSH
+// jump host or a route, may be anywhere.
+func (s *scanScope) outside(l targetList, resolve func(string) ([]string, error)) []string {
+	var out []string
+	for _, t := range l {
+		if t.name == "" {
+			if !s.contains(t.prefix) {
+				out = append(out, t.String())
+			}
+			continue
+		}
+		ips, err := resolve(t.name)
+		if err != nil {
+			out = append(out, t.name+" (does not resolve)")
+			continue
+		}
+		var hit []string
+		for _, ip := range ips {
+			if a, err := netip.ParseAddr(ip); err == nil && !s.contains(netip.PrefixFrom(a, a.BitLen())) {
+				hit = append(hit, ip)
+			}
+		}
+		if len(hit) > 0 {
+			out = append(out, fmt.Sprintf("%s (%s)", t.name, strings.Join(hit, ", ")))
+		}
+	}
+	return out
+}
+
+// check refuses targets outside the scope. With override, and a scope file
+// that allows it, they are only warned about on stderr. A nil scope allows
+// everything.
+func (s *scanScope) check(l targetList, override bool, resolve func(string) ([]string, error)) error {
+	if s == nil {
+		return nil
+	}
+	out := s.outside(l, resolve)
+	if len(out) == 0 {
+		return nil
+	}
+	listed := out
+	if len(listed) > maxListedPublic {
+		listed = append(listed[:maxListedPublic:maxListedPublic], fmt.Sprintf("and %d more", len(out)-maxListedPublic))
+	}
+	switch {
+	case override && s.allowOverride:
+		slog.Warn("scanning outside the scope", "scope", s.path, "targets", strings.Join(listed, ", "))
+		return nil
+	case override:
+		return fmt.Errorf("targets outside the scope in %s, which does not allow --override-scope: %s", s.path, strings.Join(listed, ", "))
+	}
+	return fmt.Errorf("targets outside the scope in %s: %s", s.path, strings.Join(listed, ", "))
+}
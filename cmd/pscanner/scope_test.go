@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseScope(t *testing.T) {
+	s, err := parseScope([]byte(`# Client networks
+allow:
+  - 10.20.0.0/16   # office
+  - "203.0.113.7"
+- 2001:db8::/48
+allow_override: false
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []netip.Prefix{
+		netip.MustParsePrefix("10.20.0.0/16"),
+		netip.MustParsePrefix("203.0.113.7/32"),
+		netip.MustParsePrefix("2001:db8::/48"),
+	}
+	if !reflect.DeepEqual(s.allow, want) || s.allowOverride {
+		t.Errorf("parseScope = %+v", s)
+	}
+
+	s, err = parseScope([]byte("allow: [10.0.0.0/8, 192.168.1.1]\n"))
+	if err != nil || len(s.allow) != 2 || !s.allowOverride {
+		t.Errorf("flow list: %+v, %v", s, err)
+	}
+
+	for _, bad := range []string{
+		"allow:\n  - example.com\n",
+		"deny:\n  - 10.0.0.0/8\n",
+		"  - 10.0.0.0/8\n",
+		"allow: 10.0.0.0/8\n",
+		"allow_override: maybe\n",
+	} {
+		if _, err := parseScope([]byte(bad)); err == nil {
+			t.Errorf("parseScope(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestScopeCheck(t *testing.T) {
+	s := &scanScope{path: "scope.yaml", allow: []netip.Prefix{
+		netip.MustParsePrefix("10.20.0.0/16"),
+		netip.MustParsePrefix("203.0.113.7/32"),
+	}}
+	resolve := func(name string) ([]string, error) {
+		switch name {
+		case "in.example":
+			return []string{"10.20.1.1"}, nil
+		case "split.example":
+			return []string{"10.20.1.2", "198.51.100.1"}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	targets, _ := parseTargets("10.20.3.0/24,10.20.0.0/15,203.0.113.7,::ffff:203.0.113.7,203.0.113.8,in.example,split.example,gone.example")
+	got := s.outside(targets, resolve)
+	want := []string{"10.20.0.0/15", "203.0.113.8", "split.example (198.51.100.1)", "gone.example (does not resolve)"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("outside = %q, want %q", got, want)
+	}
+
+	if err := s.check(targets, false, resolve); err == nil || !strings.Contains(err.Error(), "203.0.113.8") {
+		t.Errorf("check without override: %v", err)
+	}
+	if err := s.check(targets, true, resolve); err == nil {
+		t.Error("override allowed although the scope file forbids it")
+	}
+	s.allowOverride = true
+	if err := s.check(targets, true, resolve); err != nil {
+		t.Errorf("check with override: %v", err)
+	}
+	var none *scanScope
+	if err := none.check(targets, false, resolve); err != nil {
+		t.Errorf("no scope file: %v", err)
+	}
+}
+
+func TestLoadScope(t *testing.T) {
+	dir := t.TempDir()
+	old := scopePath
+	t.Cleanup(func() { scopePath = old })
+	scopePath = filepath.Join(dir, "scope.yaml")
+
+	if s, err := loadScope(); s != nil || err != nil {
+		t.Errorf("missing scope file: %+v, %v", s, err)
+	}
+	os.WriteFile(scopePath, []byte("allow:\n  - 10.0.0.0/8\n"), 0o644)
+	if s, err := loadScope(); err != nil || s.path != scopePath || len(s.allow) != 1 {
+		t.Errorf("loadScope = %+v, %v", s, err)
+	}
+	// The environment cannot swap in another scope.
+	other := filepath.Join(dir, "other.yaml")
+	os.WriteFile(other, []byte("allow:\n  - 0.0.0.0/0\n"), 0o644)
+	t.Setenv("PSCANNER_SCOPE_FILE", other)
+	if s, err := loadScope(); err != nil || s.path != scopePath {
+		t.Errorf("loadScope with $PSCANNER_SCOPE_FILE = %+v, %v; want %s", s, err, scopePath)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// screenshotParallel bounds the browsers running at once.
+	screenshotParallel = 4
+	// screenshotTimeout bounds each screenshot.
+	screenshotTimeout = 30 * time.Second
+)
+
+// screenshotBrowsers are the headless browsers --screenshots looks for
+// when no --screenshot-command is given.
+var screenshotBrowsers = []string{"chromium", "chromium-browser", "google-chrome", "google-chrome-stable", "chrome"}
+
+// Screenshot is a picture of the page an open web port serves at /, for
+// --screenshots.
+type Screenshot struct {
+	Port int    `json:"port"`
+	URL  string `json:"url"`
+	// File is where it was saved, under the --screenshots directory.
+	File string `json:"file"`
+}
+
+func (s Screenshot) String() string {
+	return fmt.Sprintf("tcp/%d %s saved to %s", s.Port, s.URL, s.File)
+}
+
+// screenshotCommand returns the arguments of the command that takes a
+// screenshot, with {url} and {file} standing for the page and the PNG file
+// to write: command as given, split at spaces, or a headless Chromium or
+// Chrome found in PATH.
+func screenshotCommand(command string) ([]string, error) {
+	if command != "" {
+		args := strings.Fields(command)
+		if !slices.ContainsFunc(args, func(a string) bool { return strings.Contains(a, "{url}") }) ||
+			!slices.ContainsFunc(args, func(a string) bool { return strings.Contains(a, "{file}") }) {
+			return nil, errors.New("--screenshot-command must contain {url} and {file}")
+		}
+		return args, nil
+	}
+	for _, b := range screenshotBrowsers {
+		if path, err := exec.LookPath(b); err == nil {
+			args := []string{path, "--headless", "--disable-gpu", "--hide-scrollbars", "--ignore-certificate-errors",
+				"--window-size=1280,800", "--screenshot={file}", "{url}"}
+			if os.Geteuid() == 0 {
+				// Chromium refuses to run as root inside its sandbox.
+				args = slices.Insert(args, 1, "--no-sandbox")
+			}
+			return args, nil
+		}
+	}
+	return nil, errors.New("--screenshots needs Chromium or Chrome in PATH, or a --screenshot-command")
+}
+
+// probeScreenshots takes a screenshot of each open port of @web of the
+// hosts, over HTTPS where the port completes a TLS handshake, into the
+// --screenshots directory.
+func (p *scanPlan) probeScreenshots(ctx context.Context, hosts []HostResult) {
+	if p.screenshots == "" {
+		return
+	}
+	if err := os.MkdirAll(p.screenshots, 0o755); err != nil {
+		slog.Error("screenshots not taken", "err", err)
+		return
+	}
+	ports, _ := parsePorts(builtinGroups["web"], nil)
+	dns := newDNSCache(p.dnsCache)
+	sem := make(chan struct{}, screenshotParallel)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for i := range hosts {
+		h := &hosts[i]
+		for _, pr := range h.Ports {
+			if pr.Protocol == "udp" || !slices.Contains(ports, pr.Port) {
+				continue
+			}
+			sem <- struct{}{}
+			wg.Go(func() {
+				defer func() { <-sem }()
+				s, err := p.screenshot(ctx, dns, h, pr.Port)
+				if err != nil {
+					slog.Warn("screenshot failed", "host", h.Host, "port", pr.Port, "err", err)
+					return
+				}
+				mu.Lock()
+				h.Screenshots = append(h.Screenshots, s)
+				mu.Unlock()
+			})
+		}
+	}
+	wg.Wait()
+	for i := range hosts {
+		slices.SortFunc(hosts[i].Screenshots, func(a, b Screenshot) int { return a.Port - b.Port })
+	}
+}
+
+// screenshot runs the screenshot command for port of h.
+func (p *scanPlan) screenshot(ctx context.Context, dns *dnsCache, h *HostResult, port int) (Screenshot, error) {
+	scheme := "http"
+	if conn, err := p.probe(ctx, dns, job{host: h.Host, port: port, family: h.Family}); err == nil {
+		conn.SetDeadline(time.Now().Add(p.timeout))
+		if tc, err := clientTLS(conn, h.Host); err == nil {
+			scheme = "https"
+			tc.Close()
+		} else {
+			conn.Close()
+		}
+	}
+	host := h.Host
+	if i := strings.IndexByte(host, '%'); i >= 0 {
+		host = host[:i] + "%25" + host[i+1:]
+	}
+	s := Screenshot{
+		Port: port,
+		URL:  fmt.Sprintf("%s://%s/", scheme, net.JoinHostPort(host, strconv.Itoa(port))),
+		File: filepath.Join(p.screenshots, strings.NewReplacer(":", "_", "%", "_", "/", "_").Replace(h.Host)+"_"+strconv.Itoa(port)+".png"),
+	}
+	args := make([]string, len(p.screenshotCmd))
+	for i, a := range p.screenshotCmd {
+		args[i] = strings.NewReplacer("{url}", s.URL, "{file}", s.File).Replace(a)
+	}
+	ctx, cancel := context.WithTimeout(ctx, screenshotTimeout)
+	defer cancel()
+	os.Remove(s.File)
+	out, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		return s, fmt.Errorf("%v: %s", err, truncate(strings.TrimSpace(string(out)), 200))
+	}
+	if _, err := os.Stat(s.File); err != nil {
+		return s, errors.New("the command wrote no file")
+	}
+	return s, nil
+}
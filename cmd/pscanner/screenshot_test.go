@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScreenshotCommand(t *testing.T) {
+	args, err := screenshotCommand("shoot --url={url}  {file}")
+	if err != nil || strings.Join(args, "|") != "shoot|--url={url}|{file}" {
+		t.Errorf("screenshotCommand = %q, %v", args, err)
+	}
+	for _, bad := range []string{"shoot {url}", "shoot {file}"} {
+		if _, err := screenshotCommand(bad); err == nil {
+			t.Errorf("screenshotCommand accepted %q", bad)
+		}
+	}
+}
+
+func TestScreenshot(t *testing.T) {
+	dir := t.TempDir()
+	// The fake browser writes the URL it was given as the picture.
+	shoot := filepath.Join(dir, "shoot")
+	if err := os.WriteFile(shoot, []byte("#!/bin/sh\nprintf %s \"$1\" > \"$2\"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	plain := httptest.NewServer(http.NotFoundHandler())
+	defer plain.Close()
+	secure := httptest.NewTLSServer(http.NotFoundHandler())
+	defer secure.Close()
+	shots := filepath.Join(dir, "shots")
+	if err := os.Mkdir(shots, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	p := &scanPlan{timeout: time.Second, screenshots: shots, screenshotCmd: []string{shoot, "{url}", "{file}"}}
+	h := &HostResult{Host: "127.0.0.1"}
+	for _, tt := range []struct {
+		port   int
+		scheme string
+	}{
+		{serverPort(t, plain), "http"},
+		{serverPort(t, secure), "https"},
+	} {
+		s, err := p.screenshot(context.Background(), nil, h, tt.port)
+		want := Screenshot{
+			Port: tt.port,
+			URL:  fmt.Sprintf("%s://127.0.0.1:%d/", tt.scheme, tt.port),
+			File: filepath.Join(shots, fmt.Sprintf("127.0.0.1_%d.png", tt.port)),
+		}
+		if err != nil || s != want {
+			t.Errorf("screenshot = %+v, %v; want %+v", s, err, want)
+			continue
+		}
+		if b, _ := os.ReadFile(s.File); string(b) != want.URL {
+			t.Errorf("file holds %q, want %q", b, want.URL)
+		}
+	}
+
+	p.screenshotCmd = []string{"true", "{url}", "{file}"}
+	if _, err := p.screenshot(context.Background(), nil, h, serverPort(t, plain)); err == nil {
+		t.Error("screenshot without a file succeeded")
+	}
+}
+
+func TestHTMLReportScreenshots(t *testing.T) {
+	r := &Report{Hosts: []HostResult{{
+		Host:        "10.0.0.5",
+		Ports:       []PortResult{{Port: 443, Protocol: "tcp", State: "open"}},
+		Screenshots: []Screenshot{{Port: 443, URL: "https://10.0.0.5:443/", File: "shots/10.0.0.5_443.png"}},
+	}}}
+	var b bytes.Buffer
+	if err := writeReport(&b, "html", r); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), `<a href="shots/10.0.0.5_443.png"><img src="shots/10.0.0.5_443.png"`) {
+		t.Errorf("html report does not link the screenshot:\n%s", b.String())
+	}
+	r.Hosts[0].Screenshots = nil
+	b.Reset()
+	if err := writeReport(&b, "html", r); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(b.String(), "Screenshots") {
+		t.Error("html report without screenshots has a Screenshots section")
+	}
+}
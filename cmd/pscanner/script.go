@@ -0,0 +1,439 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// portScript is a --script: a Starlark file with an action to run against
+// each open TCP port it takes, given by a "ports" list or a portrule
+// function, or both.
+type portScript struct {
+	name     string
+	ports    []int
+	portrule starlark.Callable
+	action   starlark.Callable
+}
+
+const (
+	// scriptTimeout bounds one run of an action, against one port.
+	scriptTimeout = 30 * time.Second
+	// scriptMaxSteps stops a script that loops rather than waits.
+	scriptMaxSteps = 100_000_000
+	// scriptMaxReceive bounds what one receive returns.
+	scriptMaxReceive = 64 << 10
+)
+
+// scriptFileOptions are the Starlark dialect scripts are written in, with
+// the while loops and sets protocol parsing wants.
+var scriptFileOptions = &syntax.FileOptions{Set: true, While: true}
+
+// scriptBuiltins are what scripts are given beyond Starlark's own.
+var scriptBuiltins = starlark.StringDict{
+	"connect": starlark.NewBuiltin("connect", scriptConnect),
+	"report":  starlark.NewBuiltin("report", scriptReport),
+}
+
+// defaultScriptDir returns the per-user script location, e.g.
+// ~/.config/pscanner/scripts on Linux.
+func defaultScriptDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "pscanner", "scripts")
+}
+
+// loadScripts loads a --script list: names of scripts in dir, paths to
+// .star files, or "all" for every script in dir.
+func loadScripts(list, dir string) ([]*portScript, error) {
+	paths, err := resolveFiles(list, dir, ".star")
+	if err != nil {
+		return nil, err
+	}
+	var scripts []*portScript
+	for _, path := range paths {
+		s, err := loadScript(strings.TrimSuffix(filepath.Base(path), ".star"), path)
+		if err != nil {
+			return nil, err
+		}
+		scripts = append(scripts, s)
+	}
+	return scripts, nil
+}
+
+// resolveFiles finds the files of a comma-separated list of names of files
+// in dir with the extension ext, paths to such files, or "all" for every
+// one in dir. A name given twice is resolved once.
+func resolveFiles(list, dir, ext string) ([]string, error) {
+	var paths []string
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		switch {
+		case item == "all":
+			if dir == "" {
+				return nil, errors.New("no directory for all")
+			}
+			found, err := filepath.Glob(filepath.Join(dir, "*"+ext))
+			if err != nil {
+				return nil, err
+			}
+			if len(found) == 0 {
+				return nil, fmt.Errorf("no %s files in %s", ext, dir)
+			}
+			paths = append(paths, found...)
+		case strings.HasSuffix(item, ext) || strings.ContainsRune(item, filepath.Separator):
+			paths = append(paths, item)
+		case item == "":
+			return nil, errors.New("empty name")
+		default:
+			if dir == "" {
+				return nil, fmt.Errorf("%s: no directory to find it in", item)
+			}
+			paths = append(paths, filepath.Join(dir, item+ext))
+		}
+	}
+	var unique []string
+	for _, path := range paths {
+		base := filepath.Base(path)
+		if !slices.ContainsFunc(unique, func(u string) bool { return filepath.Base(u) == base }) {
+			unique = append(unique, path)
+		}
+	}
+	return unique, nil
+}
+
+// loadScript runs the top level of the script at path, which must define
+// an action(host, port) function and ports, a portrule(host, port)
+// function, or both.
+func loadScript(name, path string) (*portScript, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	thread := scriptThread(name)
+	globals, err := starlark.ExecFileOptions(scriptFileOptions, thread, path, src, scriptBuiltins)
+	if err != nil {
+		return nil, scriptError(err)
+	}
+	globals.Freeze()
+	s := &portScript{name: name}
+	var ok bool
+	if s.action, ok = globals["action"].(starlark.Callable); !ok {
+		return nil, fmt.Errorf("%s: no action(host, port) function", path)
+	}
+	if v, found := globals["portrule"]; found {
+		if s.portrule, ok = v.(starlark.Callable); !ok {
+			return nil, fmt.Errorf("%s: portrule is not a function", path)
+		}
+	}
+	if v, found := globals["ports"]; found {
+		list, ok := v.(starlark.Iterable)
+		if !ok {
+			return nil, fmt.Errorf("%s: ports is not a list", path)
+		}
+		var port starlark.Value
+		for it := list.Iterate(); it.Next(&port); {
+			n, err := starlark.AsInt32(port)
+			if err != nil || n < 1 || n > 65535 {
+				return nil, fmt.Errorf("%s: invalid port %s", path, port)
+			}
+			s.ports = append(s.ports, n)
+		}
+	}
+	if s.ports == nil && s.portrule == nil {
+		return nil, fmt.Errorf("%s: sets neither ports nor portrule", path)
+	}
+	return s, nil
+}
+
+// scriptThread returns a thread for the named script whose print goes to
+// stderr.
+func scriptThread(name string) *starlark.Thread {
+	thread := &starlark.Thread{
+		Name: name,
+		Print: func(_ *starlark.Thread, msg string) {
+			slog.Info(msg, "script", name)
+		},
+	}
+	thread.SetMaxExecutionSteps(scriptMaxSteps)
+	return thread
+}
+
+// scriptError places a script's failure at the line of the script it came
+// from, rather than at a builtin.
+func scriptError(err error) error {
+	var evalErr *starlark.EvalError
+	if !errors.As(err, &evalErr) {
+		return err
+	}
+	for i := range evalErr.CallStack {
+		if pos := evalErr.CallStack.At(i).Pos; pos.Filename() != "<builtin>" {
+			return fmt.Errorf("%s: %s", pos, evalErr.Msg)
+		}
+	}
+	return errors.New(evalErr.Msg)
+}
+
+// ScriptResult is what a --script reported about an open port.
+type ScriptResult struct {
+	Script string   `json:"script"`
+	Port   int      `json:"port"`
+	Output []string `json:"output"`
+}
+
+// probeScripts runs each --script against the open TCP ports of the hosts
+// it takes, over connections made the way the scan made them.
+func (p *scanPlan) probeScripts(ctx context.Context, hosts []HostResult) {
+	if len(p.scripts) == 0 {
+		return
+	}
+	dns := newDNSCache(p.dnsCache)
+	sem := make(chan struct{}, quicParallel)
+	var wg sync.WaitGroup
+	for i := range hosts {
+		h := &hosts[i]
+		sem <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			for _, s := range p.scripts {
+				for _, pr := range h.Ports {
+					if pr.Protocol != "tcp" {
+						continue
+					}
+					output, err := p.runScript(ctx, dns, h, s, pr.Port)
+					if err != nil {
+						slog.Warn("script failed", "script", s.name, "host", h.Host, "port", pr.Port, "err", err)
+					}
+					if len(output) > 0 {
+						h.Scripts = append(h.Scripts, ScriptResult{Script: s.name, Port: pr.Port, Output: output})
+					}
+				}
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// scriptRun is one run of a script against a port, which its builtins
+// find in their thread.
+type scriptRun struct {
+	ctx    context.Context
+	plan   *scanPlan
+	dns    *dnsCache
+	host   *HostResult
+	output []string
+}
+
+// runScript runs s against port of h if it takes the port, and returns
+// what it reported, which may be some even when it fails. Connections it
+// opened are closed as it returns.
+func (p *scanPlan) runScript(ctx context.Context, dns *dnsCache, h *HostResult, s *portScript, port int) ([]string, error) {
+	runCtx, cancel := context.WithTimeout(ctx, scriptTimeout)
+	defer cancel()
+	thread := scriptThread(s.name)
+	run := &scriptRun{ctx: runCtx, plan: p, dns: dns, host: h}
+	thread.SetLocal("run", run)
+	defer context.AfterFunc(runCtx, func() {
+		reason := fmt.Sprintf("timed out after %s", scriptTimeout)
+		if ctx.Err() != nil {
+			reason = "scan canceled"
+		}
+		thread.Cancel(reason)
+	})()
+	args := starlark.Tuple{starlark.String(h.Host), starlark.MakeInt(port)}
+	if !slices.Contains(s.ports, port) {
+		if s.portrule == nil {
+			return nil, nil
+		}
+		take, err := starlark.Call(thread, s.portrule, args, nil)
+		if err != nil {
+			return nil, scriptError(err)
+		}
+		if !take.Truth() {
+			return nil, nil
+		}
+	}
+	v, err := starlark.Call(thread, s.action, args, nil)
+	if err != nil {
+		return run.output, scriptError(err)
+	}
+	switch v := v.(type) {
+	case starlark.NoneType:
+	case starlark.String:
+		run.output = append(run.output, string(v))
+	default:
+		run.output = append(run.output, v.String())
+	}
+	return run.output, nil
+}
+
+func scriptRunOf(thread *starlark.Thread, fn string) (*scriptRun, error) {
+	run, ok := thread.Local("run").(*scriptRun)
+	if !ok {
+		return nil, fmt.Errorf("%s: only available in action and portrule", fn)
+	}
+	return run, nil
+}
+
+// scriptConnect is connect(host, port, tls=False), which opens a
+// connection to a port of the host the script runs against.
+func scriptConnect(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var host string
+	var port int
+	var useTLS bool
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "host", &host, "port", &port, "tls?", &useTLS); err != nil {
+		return nil, err
+	}
+	run, err := scriptRunOf(thread, b.Name())
+	if err != nil {
+		return nil, err
+	}
+	if host != run.host.Host {
+		// Scripts reach no further than the scan did.
+		return nil, fmt.Errorf("%s: %s is not the host being scanned", b.Name(), host)
+	}
+	if port < 1 || port > 65535 {
+		return nil, fmt.Errorf("%s: invalid port %d", b.Name(), port)
+	}
+	conn, err := run.plan.probe(run.ctx, run.dns, job{host: host, port: port, family: run.host.Family})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	// The run's end, or the scan's, closes what the script left open.
+	context.AfterFunc(run.ctx, func() { conn.Close() })
+	if useTLS {
+		config := &tls.Config{InsecureSkipVerify: true}
+		if net.ParseIP(host) == nil {
+			config.ServerName = host
+		}
+		tc := tls.Client(conn, config)
+		if err := tc.HandshakeContext(run.ctx); err != nil {
+			return nil, fmt.Errorf("%s: %v", b.Name(), err)
+		}
+		conn = tc
+	}
+	return &scriptConn{conn: conn, addr: net.JoinHostPort(host, fmt.Sprint(port))}, nil
+}
+
+// scriptReport is report(*values), which records a line of the script's
+// results for the port, its values separated by spaces as print does.
+func scriptReport(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if len(kwargs) > 0 {
+		return nil, fmt.Errorf("%s: unexpected keyword arguments", b.Name())
+	}
+	run, err := scriptRunOf(thread, b.Name())
+	if err != nil {
+		return nil, err
+	}
+	words := make([]string, len(args))
+	for i, v := range args {
+		if s, ok := starlark.AsString(v); ok {
+			words[i] = s
+		} else {
+			words[i] = v.String()
+		}
+	}
+	run.output = append(run.output, strings.Join(words, " "))
+	return starlark.None, nil
+}
+
+// scriptConn is the connection connect returns, with send, receive and
+// close methods.
+type scriptConn struct {
+	conn net.Conn
+	addr string
+}
+
+var (
+	_ starlark.HasAttrs = (*scriptConn)(nil)
+
+	scriptConnMethods = map[string]*starlark.Builtin{
+		"send":    starlark.NewBuiltin("send", scriptConnSend),
+		"receive": starlark.NewBuiltin("receive", scriptConnReceive),
+		"close":   starlark.NewBuiltin("close", scriptConnClose),
+	}
+)
+
+func (c *scriptConn) String() string        { return fmt.Sprintf("<conn %s>", c.addr) }
+func (c *scriptConn) Type() string          { return "conn" }
+func (c *scriptConn) Freeze()               {}
+func (c *scriptConn) Truth() starlark.Bool  { return true }
+func (c *scriptConn) Hash() (uint32, error) { return 0, errors.New("unhashable type: conn") }
+
+func (c *scriptConn) Attr(name string) (starlark.Value, error) {
+	if m, ok := scriptConnMethods[name]; ok {
+		return m.BindReceiver(c), nil
+	}
+	return nil, nil
+}
+
+func (c *scriptConn) AttrNames() []string {
+	return slices.Sorted(func(yield func(string) bool) {
+		for name := range scriptConnMethods {
+			if !yield(name) {
+				return
+			}
+		}
+	})
+}
+
+// scriptConnSend is conn.send(data), for data as a string or bytes.
+func scriptConnSend(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var data starlark.Value
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &data); err != nil {
+		return nil, err
+	}
+	s, ok := starlark.AsString(data)
+	if !ok {
+		return nil, fmt.Errorf("%s: got %s, want string or bytes", b.Name(), data.Type())
+	}
+	c := b.Receiver().(*scriptConn)
+	c.conn.SetWriteDeadline(time.Now().Add(tcpProbeTimeout))
+	if _, err := io.WriteString(c.conn, s); err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	return starlark.None, nil
+}
+
+// scriptConnReceive is conn.receive(max=4096), which returns what the port
+// answers until it closes or pauses, or "" when it says nothing.
+func scriptConnReceive(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	max := payloadMaxReply
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "max?", &max); err != nil {
+		return nil, err
+	}
+	if max < 1 || max > scriptMaxReceive {
+		return nil, fmt.Errorf("%s: max must be between 1 and %d", b.Name(), scriptMaxReceive)
+	}
+	c := b.Receiver().(*scriptConn)
+	reply, err := readReply(c.conn, max, time.Now().Add(tcpProbeTimeout))
+	if len(reply) == 0 && err != nil && !isTimeout(err) && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	return starlark.String(reply), nil
+}
+
+// scriptConnClose is conn.close(). Connections left open are closed when
+// the run ends.
+func scriptConnClose(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	b.Receiver().(*scriptConn).conn.Close()
+	return starlark.None, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeScripts writes each script into a new directory and returns it.
+func writeScripts(t *testing.T, scripts map[string]string) string {
+	dir := t.TempDir()
+	for name, src := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, name+".star"), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadScripts(t *testing.T) {
+	dir := writeScripts(t, map[string]string{
+		"redis":    "ports = [6379]\ndef action(host, port):\n    pass\n",
+		"anything": "def portrule(host, port):\n    return port > 1024\ndef action(host, port):\n    pass\n",
+	})
+	scripts, err := loadScripts("redis", dir)
+	if err != nil || len(scripts) != 1 || !reflect.DeepEqual(scripts[0].ports, []int{6379}) || scripts[0].portrule != nil {
+		t.Fatalf("loadScripts(redis) = %+v, %v", scripts, err)
+	}
+	scripts, err = loadScripts("all,"+filepath.Join(dir, "redis.star"), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, s := range scripts {
+		names = append(names, s.name)
+	}
+	if !reflect.DeepEqual(names, []string{"anything", "redis"}) {
+		t.Errorf("all = %v", names)
+	}
+
+	for src, want := range map[string]string{
+		"ports = [80]\n":                             "no action",
+		"def action(host, port):\n    pass\n":        "neither ports nor portrule",
+		"ports = [0]\ndef action(h, p):\n    pass\n": "invalid port",
+		"ports = [80]\ndef action(h, p)\n":           "bad.star:3:",
+		"connect('x', 1)\n":                          "only available in action",
+	} {
+		dir := writeScripts(t, map[string]string{"bad": src})
+		if _, err := loadScripts("bad", dir); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("loading %q: %v, want %q", src, err, want)
+		}
+	}
+	if _, err := loadScripts("missing", dir); err == nil {
+		t.Error("a missing script loaded")
+	}
+}
+
+func TestProbeScripts(t *testing.T) {
+	port := serveTCP(t, func(conn net.Conn) {
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil || line != "INFO server\r\n" {
+			return
+		}
+		conn.Write([]byte("$30\r\n# Server\r\nredis_version:7.2.4\r\n"))
+		time.Sleep(time.Second)
+	})
+	dir := writeScripts(t, map[string]string{
+		"redis": `
+ports = [` + strconv.Itoa(port) + `]
+def action(host, port):
+    c = connect(host, port)
+    c.send("INFO server\r\n")
+    for line in c.receive().split("\r\n"):
+        if line.startswith("redis_version:"):
+            report("Redis", line.split(":")[1])
+    c.close()
+`,
+		"every": `
+def portrule(host, port):
+    return True
+def action(host, port):
+    report("seen")
+    return {"port": port}
+`,
+		"stray": `
+ports = [` + strconv.Itoa(port) + `]
+def action(host, port):
+    report("before")
+    connect("192.0.2.1", port)
+    report("after")
+`,
+	})
+	scripts, err := loadScripts("redis,every,stray", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := []HostResult{{Host: "127.0.0.1", Ports: []PortResult{{Port: port, Protocol: "tcp", State: "open"}}}}
+	p := &scanPlan{timeout: time.Second, scripts: scripts}
+	p.probeScripts(context.Background(), hosts)
+	want := []ScriptResult{
+		{Script: "redis", Port: port, Output: []string{"Redis 7.2.4"}},
+		{Script: "every", Port: port, Output: []string{"seen", `{"port": ` + strconv.Itoa(port) + `}`}},
+		// What was reported before connect was refused is kept.
+		{Script: "stray", Port: port, Output: []string{"before"}},
+	}
+	if !reflect.DeepEqual(hosts[0].Scripts, want) {
+		t.Errorf("scripts = %+v, want %+v", hosts[0].Scripts, want)
+	}
+}
+
+func TestScriptTimeout(t *testing.T) {
+	dir := writeScripts(t, map[string]string{
+		"spin": "ports = [1]\ndef action(host, port):\n    while True:\n        pass\n",
+	})
+	scripts, err := loadScripts("spin", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	p := &scanPlan{timeout: time.Second}
+	_, err = p.runScript(ctx, nil, &HostResult{Host: "127.0.0.1"}, scripts[0], 1)
+	if err == nil || !strings.Contains(err.Error(), "canceled") {
+		t.Errorf("runScript = %v, want it canceled", err)
+	}
+}
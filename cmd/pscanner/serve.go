@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+)
+
+// serveOptions holds the flags of the "serve" command.
+type serveOptions struct {
+	logFlags
+	grpcListen string
+	httpListen string
+	resultsDir string
+	config     string
+}
+
+var serveDoc = &commandDoc{
+	synopsis: "pscanner serve [--http-listen 127.0.0.1:8080] [--grpc-listen 127.0.0.1:50051] [--results-dir dir]",
+	description: `Run the scan server.
+
+The server runs scans on behalf of clients and keeps their results. It
+offers a web dashboard with the JSON API it is built on, and the
+pscanner.v1.Scanner gRPC service (proto/pscanner/v1/scanner.proto). Scans
+started from either are visible in both. The server has no authentication
+of its own, so it listens on the loopback interface unless told otherwise.
+
+Scans listed under "schedules" in the config file run on their own, making
+the server a continuous monitor. Each entry has a name, a "when" cadence and
+the settings of a scan request. The cadence is "every 6h", @hourly, @daily,
+@weekly or a five-field cron expression in local time. Each run is compared
+with the previous one of the same schedule and the change is logged, and
+posted to the "webhooks" and chat "notifiers" of the config file and mailed
+as set under "email". When
+configured, results are also indexed into "elasticsearch", published to the
+"publish" brokers, written to object storage under "upload" and stored in
+the "db" database. With both listeners disabled, the server only runs its
+schedules.`,
+	notes: map[string]string{
+		"http-listen": `The dashboard launches scans, shows their progress and results as they
+come in, and compares two finished runs. An empty value disables it. While
+it listens on loopback only requests addressed to a loopback host are
+served, and the API refuses cross-origin requests.`,
+		"grpc-listen": `Anyone who can reach this address can make the server scan on their
+behalf. Put it behind an authenticating proxy before exposing it. An empty
+value disables the gRPC service.`,
+		"results-dir": `Each finished scan is saved there as a JSON report, in the --output json
+format, and listed as history after a restart. Without it, results are kept
+in memory only, for the last 100 scans. Interval schedules also use it to
+pick up where they left off instead of scanning again on every restart.`,
+		"config": `Profiles, port groups, confirm_probes, allow_public_hosts and audit_log
+apply to submitted scans as they do on the command line. A scan that would
+ask for confirmation is rejected unless the request sets confirm.`,
+	},
+	examples: []string{
+		"pscanner serve --results-dir ~/.local/state/pscanner/results",
+		"pscanner serve --http-listen '' --grpc-listen 10.0.0.5:50051",
+		"pscanner serve --http-listen '' --grpc-listen '' --results-dir /var/lib/pscanner",
+	},
+}
+
+func (o *serveOptions) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	fs.StringVar(&o.httpListen, "http-listen", "127.0.0.1:8080", "Address for the web dashboard and its JSON API")
+	fs.StringVar(&o.grpcListen, "grpc-listen", "127.0.0.1:50051", "Address for the gRPC scan service")
+	fs.StringVar(&o.resultsDir, "results-dir", "", "Directory to keep finished scan reports in")
+	fs.StringVar(&o.config, "config", "", "Path to config file (default: user config dir)")
+	o.bindLog(fs)
+	fs.Usage = func() { writeCommandHelp(fs.Output(), "serve", fs, serveDoc, false) }
+	return fs
+}
+
+// runServe implements the "serve" command.
+func runServe(args []string) {
+	var o serveOptions
+	fs := o.flagSet()
+	_ = fs.Parse(args)
+	if err := o.startLog(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(2)
+	}
+
+	configPath := o.config
+	if configPath == "" {
+		configPath = defaultConfigPath()
+	}
+	cfg, err := loadConfig(configPath, o.config != "")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading config: %v\n", err)
+		os.Exit(2)
+	}
+	if o.httpListen == "" && o.grpcListen == "" && len(cfg.Schedules) == 0 {
+		fmt.Fprintln(os.Stderr, "error: nothing to serve (no listeners and no schedules in the config)")
+		os.Exit(2)
+	}
+
+	var store *resultStore
+	if o.resultsDir != "" {
+		if store, err = openResultStore(o.resultsDir); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	jobs := newJobManager(cfg, store, net.LookupHost)
+	jobs.audit = newAuditLog(cfg.AuditLog, fs)
+	if jobs.scope, err = loadScope(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(2)
+	}
+	sched, err := newScheduler(jobs, cfg.Schedules)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error in config: %v\n", err)
+		os.Exit(2)
+	}
+	for _, h := range cfg.Webhooks {
+		w, err := newWebhook(h, cfg.portGroups())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error in config: %v\n", err)
+			os.Exit(2)
+		}
+		sched.notify = append(sched.notify, w.notify)
+	}
+	for _, name := range slices.Sorted(maps.Keys(cfg.Notifiers)) {
+		w, err := newChatNotifier(name, cfg.Notifiers[name], cfg.portGroups())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error in config: %v\n", err)
+			os.Exit(2)
+		}
+		sched.notify = append(sched.notify, w.notify)
+	}
+	if cfg.Email != nil {
+		x, err := newEmailer(*cfg.Email, cfg.portGroups())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error in config: %v\n", err)
+			os.Exit(2)
+		}
+		sched.notify = append(sched.notify, x.notify)
+	}
+	if cfg.Elasticsearch != nil {
+		x, err := newESExporter(*cfg.Elasticsearch)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error in config: %v\n", err)
+			os.Exit(2)
+		}
+		sched.notify = append(sched.notify, x.notify)
+	}
+	if cfg.Upload != nil {
+		up, err := newUploader(*cfg.Upload)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error in config: %v\n", err)
+			os.Exit(2)
+		}
+		sched.notify = append(sched.notify, up.notify)
+	}
+	if cfg.DB != "" {
+		db, err := openDB(context.Background(), cfg.DB)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: database: %v\n", err)
+			os.Exit(1)
+		}
+		sched.notify = append(sched.notify, db.notify)
+	}
+	for _, u := range cfg.Publish {
+		p, err := newPublisher(u)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error in config: %v\n", err)
+			os.Exit(2)
+		}
+		sched.notify = append(sched.notify, p.notify)
+	}
+	sched.run(context.Background())
+	if n := len(cfg.Schedules); n > 0 {
+		slog.Info("running scheduled scans", "schedules", n)
+	}
+
+	errc := make(chan error, 2)
+	if o.grpcListen != "" {
+		lis, err := net.Listen("tcp", o.grpcListen)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		slog.Info("gRPC scan service listening", "addr", lis.Addr().String())
+		go func() { errc <- newGRPCServer(jobs).Serve(lis) }()
+	}
+	if o.httpListen != "" {
+		lis, err := net.Listen("tcp", o.httpListen)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		slog.Info("dashboard listening", "url", "http://"+lis.Addr().String()+"/")
+		srv := &http.Server{
+			Handler:           newWebHandler(jobs, isLoopbackListen(o.httpListen)),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() { errc <- srv.Serve(lis) }()
+	}
+	if err := <-errc; err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
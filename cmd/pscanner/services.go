@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	_ "embed"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//go:embed data/services
+var servicesData string
+
+var (
+	servicesOnce   sync.Once
+	servicesByName map[string]int
+
+	portNamesOnce sync.Once
+	portNames     map[int]string
+)
+
+// serviceByName resolves a TCP service name (or alias) to its port. The
+// embedded table is consulted first, then /etc/services if present.
+func serviceByName(name string) (int, bool) {
+	servicesOnce.Do(func() {
+		var system io.Reader
+		if f, err := os.Open("/etc/services"); err == nil {
+			defer f.Close()
+			system = f
+		}
+		servicesByName = buildServices(system)
+	})
+	port, ok := servicesByName[strings.ToLower(name)]
+	return port, ok
+}
+
+// buildServices merges the system services file (may be nil) with the
+// embedded table. The embedded table is loaded last so it wins on conflicts.
+func buildServices(system io.Reader) map[string]int {
+	byName := make(map[string]int)
+	if system != nil {
+		loadServices(byName, bufio.NewScanner(system))
+	}
+	loadServices(byName, bufio.NewScanner(strings.NewReader(servicesData)))
+	return byName
+}
+
+// loadServices reads /etc/services formatted lines into byName, keeping only
+// TCP entries.
+func loadServices(byName map[string]int, sc *bufio.Scanner) {
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		portProto := strings.SplitN(fields[1], "/", 2)
+		if len(portProto) != 2 || portProto[1] != "tcp" {
+			continue
+		}
+		port, err := strconv.Atoi(portProto[0])
+		if err != nil || port < 1 || port > 65535 {
+			continue
+		}
+		for _, n := range append([]string{fields[0]}, fields[2:]...) {
+			byName[strings.ToLower(n)] = port
+		}
+	}
+}
+
+// serviceName returns the usual name of the TCP service on port, taken
+// from the embedded table, or "" if it has none.
+func serviceName(port int) string {
+	portNamesOnce.Do(func() {
+		portNames = make(map[int]string)
+		sc := bufio.NewScanner(strings.NewReader(servicesData))
+		for sc.Scan() {
+			fields := strings.Fields(sc.Text())
+			if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+				continue
+			}
+			p, ok := strings.CutSuffix(fields[1], "/tcp")
+			if !ok {
+				continue
+			}
+			// The first entry for a port is its primary name.
+			if n, err := strconv.Atoi(p); err == nil && portNames[n] == "" {
+				portNames[n] = fields[0]
+			}
+		}
+	})
+	return portNames[port]
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuildServices(t *testing.T) {
+	system := strings.NewReader(`# comment line
+http        8080/tcp    # conflicts with the embedded table
+customsvc   9999/tcp    custom-alias
+udponly     7777/udp
+broken      notaport/tcp
+`)
+	byName := buildServices(system)
+	tests := []struct {
+		name string
+		port int
+		ok   bool
+	}{
+		{"http", 80, true}, // embedded wins over the system file
+		{"www", 80, true},  // embedded alias
+		{"postgres", 5432, true},
+		{"postgresql", 5432, true},
+		{"customsvc", 9999, true}, // only in the system file
+		{"custom-alias", 9999, true},
+		{"udponly", 0, false}, // UDP entries are ignored
+		{"broken", 0, false},
+	}
+	for _, tt := range tests {
+		port, ok := byName[tt.name]
+		if ok != tt.ok || port != tt.port {
+			t.Errorf("%s = %d, %v; want %d, %v", tt.name, port, ok, tt.port, tt.ok)
+		}
+	}
+}
+
+func TestBuildServicesWithoutSystemFile(t *testing.T) {
+	byName := buildServices(nil)
+	if byName["ssh"] != 22 {
+		t.Errorf("ssh = %d, want 22", byName["ssh"])
+	}
+}
+
+func TestServiceName(t *testing.T) {
+	for port, want := range map[int]string{22: "ssh", 443: "https", 5432: "postgresql", 1: "tcpmux", 65000: ""} {
+		if got := serviceName(port); got != want {
+			t.Errorf("serviceName(%d) = %q, want %q", port, got, want)
+		}
+	}
+}
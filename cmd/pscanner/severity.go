@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+//go:embed data/severity
+var severityData string
+
+// Severities of open ports and findings, from the most severe.
+const (
+	severityHigh   = "high"
+	severityMedium = "medium"
+	severityLow    = "low"
+	severityInfo   = "info"
+)
+
+var severities = []string{severityHigh, severityMedium, severityLow, severityInfo}
+
+// severityRank orders severities: 4 for high down to 1 for info, and 0 for
+// none.
+func severityRank(s string) int {
+	if i := slices.Index(severities, s); i >= 0 {
+		return len(severities) - i
+	}
+	return 0
+}
+
+// severityRule gives the ports first to last of protocol a severity, on
+// public or private addresses alone when scope is set.
+type severityRule struct {
+	first, last int
+	protocol    string
+	severity    string
+	scope       string
+}
+
+// parseSeverityRules reads rules in the format of data/severity: lines of
+// "<port>[-<port>]/<protocol> <severity> [public|private]", with "#"
+// comments.
+func parseSeverityRules(r io.Reader, name string) ([]severityRule, error) {
+	var rules []severityRule
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text, _, _ := strings.Cut(sc.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) > 3 {
+			return nil, fmt.Errorf("%s:%d: want <port>/<protocol> <severity> [public|private]", name, line)
+		}
+		var rule severityRule
+		ports, protocol, ok := strings.Cut(fields[0], "/")
+		if !ok || (protocol != "tcp" && protocol != "udp") {
+			return nil, fmt.Errorf("%s:%d: %q: want <port>/tcp or <port>/udp", name, line, fields[0])
+		}
+		rule.protocol = protocol
+		first, last, isRange := strings.Cut(ports, "-")
+		if !isRange {
+			last = first
+		}
+		var err1, err2 error
+		rule.first, err1 = strconv.Atoi(first)
+		rule.last, err2 = strconv.Atoi(last)
+		if err1 != nil || err2 != nil || rule.first < 1 || rule.last > 65535 || rule.first > rule.last {
+			return nil, fmt.Errorf("%s:%d: %q: invalid port", name, line, fields[0])
+		}
+		if len(fields) < 2 || !slices.Contains(severities, fields[1]) {
+			return nil, fmt.Errorf("%s:%d: want a severity of %s", name, line, strings.Join(severities, ", "))
+		}
+		rule.severity = fields[1]
+		if len(fields) == 3 {
+			if fields[2] != "public" && fields[2] != "private" {
+				return nil, fmt.Errorf("%s:%d: %q: want public or private", name, line, fields[2])
+			}
+			rule.scope = fields[2]
+		}
+		rules = append(rules, rule)
+	}
+	return rules, sc.Err()
+}
+
+// loadSeverityRules reads the rules of --severity: "default" for the
+// embedded ones alone, or a file whose rules come first.
+func loadSeverityRules(spec string) ([]severityRule, error) {
+	builtin, err := parseSeverityRules(strings.NewReader(severityData), "data/severity")
+	if err != nil || spec == "default" {
+		return builtin, err
+	}
+	f, err := os.Open(spec)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rules, err := parseSeverityRules(f, spec)
+	if err != nil {
+		return nil, err
+	}
+	return append(rules, builtin...), nil
+}
+
+// severityOf is the severity the first of rules that matches port pr of
+// host gives it, or "".
+func severityOf(rules []severityRule, host string, pr PortResult) string {
+	scope := ""
+	if a, err := netip.ParseAddr(host); err == nil {
+		scope = "private"
+		if isPublicAddr(a.Unmap()) {
+			scope = "public"
+		}
+	}
+	for _, r := range rules {
+		if r.protocol == pr.Protocol && r.first <= pr.Port && pr.Port <= r.last && (r.scope == "" || r.scope == scope) {
+			return r.severity
+		}
+	}
+	return ""
+}
+
+// classify gives the open ports of hosts their severity by rules.
+func classify(hosts []HostResult, rules []severityRule) {
+	for i := range hosts {
+		h := &hosts[i]
+		for j := range h.Ports {
+			h.Ports[j].Severity = severityOf(rules, h.Host, h.Ports[j])
+		}
+	}
+}
+
+// hostRisk is the rank of the most severe of the open ports and findings
+// of h, for --host-order risk.
+func hostRisk(h HostResult) int {
+	risk := 0
+	for _, pr := range h.Ports {
+		risk = max(risk, severityRank(pr.Severity))
+	}
+	for _, f := range h.Findings {
+		risk = max(risk, severityRank(f.Severity))
+	}
+	return risk
+}
+
+// severityCounts sums up the severities of the open ports of hosts, as
+// "2 high, 1 medium, 5 info", leaving out those with none.
+func severityCounts(hosts []HostResult) string {
+	counts := make(map[string]int)
+	for _, h := range hosts {
+		for _, pr := range h.Ports {
+			counts[pr.Severity]++
+		}
+	}
+	var list []string
+	for _, s := range severities {
+		if counts[s] > 0 {
+			list = append(list, fmt.Sprintf("%d %s", counts[s], s))
+		}
+	}
+	if len(list) == 0 {
+		return "none"
+	}
+	return strings.Join(list, ", ")
+}
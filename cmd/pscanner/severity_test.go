@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSeverityRules(t *testing.T) {
+	rules, err := parseSeverityRules(strings.NewReader("# ours\n\n8080/tcp info  # proxies\n5900-5903/tcp high public\n"), "rules")
+	if err != nil || len(rules) != 2 {
+		t.Fatalf("parseSeverityRules = %+v, %v", rules, err)
+	}
+	if r := rules[1]; r.first != 5900 || r.last != 5903 || r.protocol != "tcp" || r.severity != severityHigh || r.scope != "public" {
+		t.Errorf("range rule = %+v", r)
+	}
+	for _, tt := range []struct{ rule, err string }{
+		{"8080 info", `rules:1: "8080": want <port>/tcp or <port>/udp`},
+		{"8080/sctp info", `rules:1: "8080/sctp": want <port>/tcp or <port>/udp`},
+		{"0/tcp info", `rules:1: "0/tcp": invalid port`},
+		{"90-80/tcp info", `rules:1: "90-80/tcp": invalid port`},
+		{"80/tcp critical", "rules:1: want a severity of high, medium, low, info"},
+		{"80/tcp", "rules:1: want a severity of high, medium, low, info"},
+		{"80/tcp high internet", `rules:1: "internet": want public or private`},
+		{"80/tcp high public extra", "rules:1: want <port>/<protocol> <severity> [public|private]"},
+	} {
+		if _, err := parseSeverityRules(strings.NewReader(tt.rule), "rules"); err == nil || err.Error() != tt.err {
+			t.Errorf("%q: err = %v, want %s", tt.rule, err, tt.err)
+		}
+	}
+}
+
+func TestSeverityOf(t *testing.T) {
+	rules, err := loadSeverityRules("default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		host string
+		port PortResult
+		want string
+	}{
+		{"10.0.0.5", PortResult{Port: 23, Protocol: "tcp"}, severityHigh},
+		{"203.0.113.7", PortResult{Port: 3389, Protocol: "tcp"}, severityMedium}, // documentation range, not public
+		{"8.8.8.8", PortResult{Port: 3389, Protocol: "tcp"}, severityHigh},
+		{"::ffff:8.8.8.8", PortResult{Port: 445, Protocol: "tcp"}, severityHigh},
+		{"10.0.0.5", PortResult{Port: 445, Protocol: "tcp"}, severityLow},
+		{"example.com", PortResult{Port: 3306, Protocol: "tcp"}, severityLow},
+		{"10.0.0.5", PortResult{Port: 443, Protocol: "tcp"}, severityInfo},
+		{"10.0.0.5", PortResult{Port: 161, Protocol: "udp"}, severityMedium},
+		{"10.0.0.5", PortResult{Port: 161, Protocol: "tcp"}, ""},
+		{"10.0.0.5", PortResult{Port: 31337, Protocol: "tcp"}, ""},
+	} {
+		if got := severityOf(rules, tt.host, tt.port); got != tt.want {
+			t.Errorf("severity of %s %d/%s = %q, want %q", tt.host, tt.port.Port, tt.port.Protocol, got, tt.want)
+		}
+	}
+
+	// A file's rules come before the embedded ones.
+	path := filepath.Join(t.TempDir(), "rules")
+	if err := os.WriteFile(path, []byte("23/tcp low private\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if rules, err = loadSeverityRules(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := severityOf(rules, "10.0.0.5", PortResult{Port: 23, Protocol: "tcp"}); got != severityLow {
+		t.Errorf("overridden telnet = %q", got)
+	}
+	if got := severityOf(rules, "8.8.8.8", PortResult{Port: 23, Protocol: "tcp"}); got != severityHigh {
+		t.Errorf("telnet outside the override = %q", got)
+	}
+	if _, err := loadSeverityRules(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("missing rules file loaded")
+	}
+}
+
+func TestSeverityReported(t *testing.T) {
+	rules, err := loadSeverityRules("default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := &scanPlan{severity: rules, severityPath: "default", hostOrder: "risk"}
+	hosts := []HostResult{
+		{Host: "10.0.0.4", Ports: []PortResult{{Port: 443, Protocol: "tcp", State: "open"}}},
+		{Host: "10.0.0.5", Ports: []PortResult{{Port: 443, Protocol: "tcp", State: "open"}, {Port: 23, Protocol: "tcp", State: "open"}}},
+		{Host: "10.0.0.6"},
+		{Host: "10.0.0.7", Ports: []PortResult{{Port: 80, Protocol: "tcp", State: "open"}}, Findings: []Finding{{Port: 80, Severity: severityHigh}}},
+	}
+	r := newReport(plan, "", time.Now(), hosts, false)
+	var order []string
+	for _, h := range r.Hosts {
+		order = append(order, h.Host)
+	}
+	if got := strings.Join(order, " "); got != "10.0.0.5 10.0.0.7 10.0.0.4 10.0.0.6" {
+		t.Errorf("risk order = %s", got)
+	}
+
+	r.Parameters.TargetCount = 4
+	var b bytes.Buffer
+	if err := writeText(&b, r); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Open ports by severity: 1 high, 1 low, 2 info\n",
+		"Host: 10.0.0.5\nOpen ports:\n  23 [high]\n  443 [info]\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("text report lacks %q:\n%s", want, b.String())
+		}
+	}
+
+	b.Reset()
+	if err := writeSyslog(&b, r); err != nil {
+		t.Fatal(err)
+	}
+	line, _, _ := strings.Cut(b.String(), "\n")
+	if !strings.HasPrefix(line, "<12>1 ") || !strings.Contains(line, ` port="23" protocol="tcp" service="telnet"`) || !strings.Contains(line, ` severity="high"]`) {
+		t.Errorf("syslog line of telnet = %s", line)
+	}
+}
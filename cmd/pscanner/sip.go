@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sipRequest is a SIP OPTIONS request to uri over transport (UDP or TCP),
+// which servers, phones and gateways answer with their capabilities and,
+// mostly, their name. Answers go to the address it came from (rport), so
+// its own address is left as a placeholder.
+func sipRequest(transport, uri string) []byte {
+	id := make([]byte, 12)
+	rand.Read(id)
+	branch, tag, callID := hex.EncodeToString(id[:4]), hex.EncodeToString(id[4:8]), hex.EncodeToString(id[8:])
+	return []byte("OPTIONS " + uri + " SIP/2.0\r\n" +
+		"Via: SIP/2.0/" + transport + " pscanner.invalid;branch=z9hG4bK" + branch + ";rport\r\n" +
+		"Max-Forwards: 70\r\n" +
+		"From: <sip:pscanner@pscanner.invalid>;tag=" + tag + "\r\n" +
+		"To: <" + uri + ">\r\n" +
+		"Call-ID: " + callID + "@pscanner.invalid\r\n" +
+		"CSeq: 1 OPTIONS\r\n" +
+		"Accept: application/sdp\r\n" +
+		"Content-Length: 0\r\n\r\n")
+}
+
+// sipUDPRequest is the --udp sip request, which does not know the host.
+func sipUDPRequest() []byte {
+	return sipRequest("UDP", "sip:pscanner.invalid")
+}
+
+// readHeader reads a SIP or RTSP status line and header from r.
+func readHeader(r *bufio.Reader) (status string, header textproto.MIMEHeader, err error) {
+	tp := textproto.NewReader(r)
+	if status, err = tp.ReadLine(); err != nil {
+		return "", nil, err
+	}
+	header, err = tp.ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return "", nil, err
+	}
+	return status, header, nil
+}
+
+// parseSIP reads the answer to a SIP request: its status, and the name the
+// server or device gives.
+func parseSIP(req, resp []byte) (string, bool) {
+	status, header, err := readHeader(bufio.NewReader(bytes.NewReader(resp)))
+	if err != nil {
+		return "", false
+	}
+	return sipAnswer(req, status, header)
+}
+
+// sipAnswer reads the status line and header of an answer to req, as
+// parseSIP does.
+func sipAnswer(req []byte, status string, header textproto.MIMEHeader) (string, bool) {
+	code, reason, ok := strings.Cut(strings.TrimPrefix(status, "SIP/2.0 "), " ")
+	if !ok || !strings.HasPrefix(status, "SIP/2.0 ") {
+		return "", false
+	}
+	_, reqHeader, _ := readHeader(bufio.NewReader(bytes.NewReader(req)))
+	if sipCallID(header) != sipCallID(reqHeader) {
+		return "", false
+	}
+	s := code + " " + reason
+	if code == "100" {
+		// Trying: the answer that counts follows.
+		return "", false
+	}
+	for _, name := range []string{"Server", "User-Agent"} {
+		if v := header.Get(name); v != "" {
+			s += fmt.Sprintf(", %s %q", strings.ToLower(name), v)
+			break
+		}
+	}
+	return s, true
+}
+
+// sipCallID returns the Call-ID of a SIP message, which may be written
+// in its compact form.
+func sipCallID(h textproto.MIMEHeader) string {
+	if id := h.Get("Call-Id"); id != "" {
+		return id
+	}
+	return h.Get("I")
+}
+
+// sipTCPProbe sends a SIP OPTIONS request over TCP.
+func (p *scanPlan) sipTCPProbe(conn net.Conn, h *HostResult, port int) (*TCPService, error) {
+	conn.SetDeadline(time.Now().Add(tcpProbeTimeout))
+	req := sipRequest("TCP", "sip:"+net.JoinHostPort(h.Host, strconv.Itoa(port)))
+	if _, err := conn.Write(req); err != nil {
+		return nil, nil
+	}
+	r := bufio.NewReader(conn)
+	for {
+		status, header, err := readHeader(r)
+		if err != nil {
+			return nil, nil
+		}
+		if details, ok := sipAnswer(req, status, header); ok {
+			return &TCPService{Details: details}, nil
+		}
+		if !strings.HasPrefix(status, "SIP/2.0 1") {
+			return nil, nil
+		}
+		// Skip the body of a provisional answer.
+		if n, _ := strconv.Atoi(header.Get("Content-Length")); n > 0 {
+			io.CopyN(io.Discard, r, int64(n))
+		}
+	}
+}
+
+// rtspProbe asks an RTSP server, mostly an IP camera or recorder, for its
+// methods and then to describe the stream at its root, which should need
+// credentials.
+func (p *scanPlan) rtspProbe(conn net.Conn, h *HostResult, port int) (*TCPService, error) {
+	conn.SetDeadline(time.Now().Add(tcpProbeTimeout))
+	url := "rtsp://" + net.JoinHostPort(h.Host, strconv.Itoa(port)) + "/"
+	r := bufio.NewReader(conn)
+	ask := func(method string, seq int, extra string) (string, textproto.MIMEHeader, []byte, error) {
+		req := fmt.Sprintf("%s %s RTSP/1.0\r\nCSeq: %d\r\nUser-Agent: pscanner\r\n%s\r\n", method, url, seq, extra)
+		if _, err := io.WriteString(conn, req); err != nil {
+			return "", nil, nil, err
+		}
+		status, header, err := readHeader(r)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		if !strings.HasPrefix(status, "RTSP/") {
+			return "", nil, nil, fmt.Errorf("not RTSP: %q", status)
+		}
+		var body []byte
+		if n, _ := strconv.Atoi(header.Get("Content-Length")); n > 0 && n <= 64<<10 {
+			body = make([]byte, n)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return "", nil, nil, err
+			}
+		}
+		_, status, _ = strings.Cut(status, " ")
+		return status, header, body, nil
+	}
+
+	status, header, _, err := ask("OPTIONS", 1, "")
+	if err != nil {
+		return nil, nil
+	}
+	s := &TCPService{Details: status}
+	if server := header.Get("Server"); server != "" {
+		s.Details += fmt.Sprintf(", server %q", server)
+	}
+	status, header, body, err := ask("DESCRIBE", 2, "Accept: application/sdp\r\n")
+	if err != nil {
+		return s, nil
+	}
+	code, _, _ := strings.Cut(status, " ")
+	switch code {
+	case "200":
+		session := "stream"
+		for _, line := range strings.Split(string(body), "\n") {
+			if name, ok := strings.CutPrefix(strings.TrimSpace(line), "s="); ok && name != "" && name != "-" {
+				session = fmt.Sprintf("stream %q", name)
+				break
+			}
+		}
+		s.Issues = append(s.Issues, session+" described without authentication")
+	case "401":
+		s.Details += "; DESCRIBE needs authentication"
+		if _, realm, ok := strings.Cut(header.Get("WWW-Authenticate"), `realm="`); ok {
+			if realm, _, ok := strings.Cut(realm, `"`); ok {
+				s.Details += fmt.Sprintf(", realm %q", realm)
+			}
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"net/textproto"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AlirezaNezami23/pscanner/probe"
+)
+
+// sipServerAnswer answers a SIP request as a PBX does: a provisional answer,
+// then the final one, with the request's Call-ID.
+func sipServerAnswer(req []byte) [][]byte {
+	_, header, err := readHeader(bufio.NewReader(strings.NewReader(string(req))))
+	if err != nil {
+		return nil
+	}
+	answer := func(status string) []byte {
+		return []byte("SIP/2.0 " + status + "\r\n" +
+			"Via: " + header.Get("Via") + ";received=127.0.0.1\r\n" +
+			"i: " + header.Get("Call-Id") + "\r\n" +
+			"CSeq: 1 OPTIONS\r\n" +
+			"Server: Asterisk PBX 18.20.0\r\n" +
+			"Content-Length: 0\r\n\r\n")
+	}
+	return [][]byte{answer("100 Trying"), answer("200 OK")}
+}
+
+func TestSIP(t *testing.T) {
+	// Over UDP, where someone else's answer comes first.
+	port := serveUDP(t, func(req []byte) [][]byte {
+		other := []byte(strings.Replace(string(sipServerAnswer(req)[1]), "@pscanner.invalid", "@elsewhere", 1))
+		return append([][]byte{other}, sipServerAnswer(req)...)
+	}, false)
+	p := &scanPlan{timeout: time.Second}
+	pr := &udpProbe{port: port, request: sipUDPRequest, parse: parseSIP}
+	want := `200 OK, server "Asterisk PBX 18.20.0"`
+	if got, ok, err := p.udpProbe(context.Background(), netip.MustParseAddr("127.0.0.1"), pr); !ok || err != nil || got != want {
+		t.Errorf("SIP over UDP = %q, %v, %v; want %q", got, ok, err, want)
+	}
+
+	// Over TCP.
+	port = serveTCP(t, func(conn net.Conn) {
+		var req []byte
+		buf := make([]byte, 1)
+		for !strings.HasSuffix(string(req), "\r\n\r\n") {
+			if _, err := io.ReadFull(conn, buf); err != nil {
+				return
+			}
+			req = append(req, buf[0])
+		}
+		for _, b := range sipServerAnswer(req) {
+			conn.Write(b)
+		}
+	})
+	s, err := p.tcpProbe(context.Background(), nil, &HostResult{Host: "127.0.0.1"}, port, tcpProbes["sip"])
+	if err != nil || s == nil || s.Details != want {
+		t.Errorf("SIP over TCP = %+v, %v; want %q", s, err, want)
+	}
+}
+
+// serveRTSP answers RTSP requests on a local TCP port: DESCRIBE with
+// status and, for 200, an SDP body.
+func serveRTSP(t *testing.T, describe string) int {
+	return serveTCP(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		for {
+			req, err := textproto.NewReader(r).ReadLine()
+			if err != nil {
+				return
+			}
+			header, _ := textproto.NewReader(r).ReadMIMEHeader()
+			seq := header.Get("Cseq")
+			switch method, _, _ := strings.Cut(req, " "); method {
+			case "OPTIONS":
+				fmt.Fprintf(conn, "RTSP/1.0 200 OK\r\nCSeq: %s\r\nServer: Hipcam RealServer/V1.0\r\nPublic: OPTIONS, DESCRIBE, SETUP, PLAY, TEARDOWN\r\n\r\n", seq)
+			case "DESCRIBE":
+				if describe == "401" {
+					fmt.Fprintf(conn, "RTSP/1.0 401 Unauthorized\r\nCSeq: %s\r\nWWW-Authenticate: Digest realm=\"IP Camera(C6214)\", nonce=\"abc\"\r\n\r\n", seq)
+					continue
+				}
+				sdp := "v=0\r\no=- 1 1 IN IP4 0.0.0.0\r\ns=Media Presentation\r\nm=video 0 RTP/AVP 96\r\n"
+				fmt.Fprintf(conn, "RTSP/1.0 200 OK\r\nCSeq: %s\r\nContent-Type: application/sdp\r\nContent-Length: %d\r\n\r\n%s", seq, len(sdp), sdp)
+			}
+		}
+	})
+}
+
+func TestRTSPProbe(t *testing.T) {
+	p := &scanPlan{timeout: time.Second}
+	h := &HostResult{Host: "127.0.0.1"}
+	for _, tt := range []struct {
+		describe string
+		want     *TCPService
+	}{
+		{"200", &TCPService{Details: `200 OK, server "Hipcam RealServer/V1.0"`, Issues: []string{`stream "Media Presentation" described without authentication`}}},
+		{"401", &TCPService{Details: `200 OK, server "Hipcam RealServer/V1.0"; DESCRIBE needs authentication, realm "IP Camera(C6214)"`}},
+	} {
+		got, err := p.tcpProbe(context.Background(), nil, h, serveRTSP(t, tt.describe), tcpProbes["rtsp"])
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("rtspProbe with DESCRIBE %s = %+v, %v; want %+v", tt.describe, got, err, tt.want)
+		}
+	}
+
+	// A web server is not RTSP.
+	web := serveTCP(t, func(conn net.Conn) {
+		io.WriteString(conn, "HTTP/1.1 400 Bad Request\r\nContent-Length: 0\r\n\r\n")
+	})
+	if got, err := p.tcpProbe(context.Background(), nil, h, web, tcpProbes["rtsp"]); got != nil || err != nil {
+		t.Errorf("rtspProbe of a web server = %+v, %v", got, err)
+	}
+}
+
+func TestParseTCPProbes(t *testing.T) {
+	want := []string{"imap", "kerberos", "ldap", "mssql", "pop3", "rtsp", "sip", "smb", "smtp", "telnet"}
+	// Along with those compiled in by build tags.
+	for _, pr := range probe.Probes() {
+		want = append(want, pr.Name)
+	}
+	slices.Sort(want)
+	if got, err := parseTCPProbes("all"); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("parseTCPProbes(all) = %v, %v", got, err)
+	}
+	if _, err := parseTCPProbes("rtsp,http"); err == nil || !strings.Contains(err.Error(), "unknown TCP probe") {
+		t.Errorf("unknown probe: %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// SMB2 commands and the status that asks for another round of a session
+// setup (MS-SMB2 2.2).
+const (
+	smbNegotiate     = 0
+	smbSessionSetup  = 1
+	smbMoreRequired  = 0xc0000016
+	smbSigningNeeded = 0x02
+)
+
+// smbDialects are the SMB2 dialects offered, 2.0.2 to 3.0.2; 3.1.1 would
+// need negotiate contexts, and servers that speak it take 3.0.2 too.
+var smbDialects = []uint16{0x0202, 0x0210, 0x0300, 0x0302}
+
+// smbProbe negotiates SMB2 and starts an NTLM session setup, giving the
+// dialect, whether signing is required and what the NTLM challenge tells
+// of the server. Signing not required is listed among the issues, as it
+// lets NTLM relays through.
+func (p *scanPlan) smbProbe(conn net.Conn, h *HostResult, port int) (*TCPService, error) {
+	conn.SetDeadline(time.Now().Add(tcpProbeTimeout))
+	body := binary.LittleEndian.AppendUint16(nil, 36)
+	body = binary.LittleEndian.AppendUint16(body, uint16(len(smbDialects)))
+	body = binary.LittleEndian.AppendUint16(body, 1) // signing enabled
+	body = append(body, make([]byte, 2+4+16+8)...)   // reserved, capabilities, client GUID, start time
+	for _, d := range smbDialects {
+		body = binary.LittleEndian.AppendUint16(body, d)
+	}
+	status, resp, err := smbAsk(conn, smbNegotiate, 0, body)
+	if err != nil || status != 0 || len(resp) < 8 || binary.LittleEndian.Uint16(resp) != 65 {
+		// Not SMB2: SMB1 alone, or something else.
+		return nil, nil
+	}
+	securityMode, dialect := binary.LittleEndian.Uint16(resp[2:]), binary.LittleEndian.Uint16(resp[4:])
+	s := &TCPService{Details: fmt.Sprintf("SMB %d.%d.%d", dialect>>8, dialect>>4&0xf, dialect&0xf)}
+	if securityMode&smbSigningNeeded != 0 {
+		s.Details += ", signing required"
+	} else {
+		s.Issues = append(s.Issues, "signing not required")
+	}
+
+	body = binary.LittleEndian.AppendUint16(nil, 25)
+	body = append(body, 0, 1)                            // flags, signing enabled
+	body = append(body, make([]byte, 4+4)...)            // capabilities, channel
+	body = binary.LittleEndian.AppendUint16(body, 64+24) // security buffer offset
+	body = binary.LittleEndian.AppendUint16(body, uint16(len(spnegoNegotiate)))
+	body = append(body, make([]byte, 8)...) // previous session
+	body = append(body, spnegoNegotiate...)
+	status, resp, err = smbAsk(conn, smbSessionSetup, 1, body)
+	if err != nil || status != smbMoreRequired {
+		return s, nil
+	}
+	if info, err := parseNTLMChallenge(resp); err == nil {
+		s.NTLM = info
+		s.Details += "; " + info.String()
+	}
+	return s, nil
+}
+
+// smbAsk sends an SMB2 request of command with body, over NetBIOS session
+// framing, and returns the status and body of the answer.
+func smbAsk(conn net.Conn, command uint16, id uint64, body []byte) (uint32, []byte, error) {
+	msg := make([]byte, 4, 4+64+len(body))
+	binary.BigEndian.PutUint32(msg, uint32(64+len(body)))
+	msg = append(msg, 0xfe, 'S', 'M', 'B')
+	msg = binary.LittleEndian.AppendUint16(msg, 64) // structure size
+	msg = binary.LittleEndian.AppendUint16(msg, 0)  // credit charge
+	msg = binary.LittleEndian.AppendUint32(msg, 0)  // status
+	msg = binary.LittleEndian.AppendUint16(msg, command)
+	msg = binary.LittleEndian.AppendUint16(msg, 1) // credits asked
+	msg = binary.LittleEndian.AppendUint32(msg, 0) // flags
+	msg = binary.LittleEndian.AppendUint32(msg, 0) // next command
+	msg = binary.LittleEndian.AppendUint64(msg, id)
+	msg = append(msg, make([]byte, 4+4+8+16)...) // process, tree, session, signature
+	msg = append(msg, body...)
+	if _, err := conn.Write(msg); err != nil {
+		return 0, nil, err
+	}
+	var head [4]byte
+	if _, err := io.ReadFull(conn, head[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(head[:])
+	if head[0] != 0 || n < 64 || n > 1<<16 {
+		return 0, nil, fmt.Errorf("not an SMB2 answer")
+	}
+	resp := make([]byte, n)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return 0, nil, err
+	}
+	if string(resp[:4]) != "\xfeSMB" || binary.LittleEndian.Uint16(resp[12:]) != command {
+		return 0, nil, fmt.Errorf("not an SMB2 answer")
+	}
+	return binary.LittleEndian.Uint32(resp[8:]), resp[64:], nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeSMB answers SMB2 negotiations with dialect 3.0.2 and securityMode,
+// and NTLM session setups with the challenge of corpDC.
+func fakeSMB(securityMode uint16) func(net.Conn) {
+	return func(conn net.Conn) {
+		for {
+			var head [4]byte
+			if _, err := io.ReadFull(conn, head[:]); err != nil {
+				return
+			}
+			req := make([]byte, binary.BigEndian.Uint32(head[:]))
+			if _, err := io.ReadFull(conn, req); err != nil {
+				return
+			}
+			command := binary.LittleEndian.Uint16(req[12:])
+			var status uint32
+			var body []byte
+			switch command {
+			case smbNegotiate:
+				body = binary.LittleEndian.AppendUint16(nil, 65)
+				body = binary.LittleEndian.AppendUint16(body, securityMode)
+				body = binary.LittleEndian.AppendUint16(body, 0x0302)
+				body = append(body, make([]byte, 58)...)
+			case smbSessionSetup:
+				if !bytes.Contains(req, ntlmNegotiate) {
+					return
+				}
+				challenge := ntlmChallenge(corpDC, 17763)
+				status = smbMoreRequired
+				body = binary.LittleEndian.AppendUint16(nil, 9)
+				body = binary.LittleEndian.AppendUint16(body, 0)
+				body = binary.LittleEndian.AppendUint16(body, 64+8)
+				body = binary.LittleEndian.AppendUint16(body, uint16(len(challenge)))
+				body = append(body, berTLV(0xa1, berTLV(0x30, berTLV(0xa2, berTLV(0x04, challenge))))...)
+			}
+			resp := append([]byte{0xfe, 'S', 'M', 'B', 64, 0, 0, 0}, binary.LittleEndian.AppendUint32(nil, status)...)
+			resp = binary.LittleEndian.AppendUint16(resp, command)
+			resp = append(resp, make([]byte, 64-len(resp))...)
+			resp = append(resp, body...)
+			conn.Write(append(binary.BigEndian.AppendUint32(nil, uint32(len(resp))), resp...))
+		}
+	}
+}
+
+func TestSMBProbe(t *testing.T) {
+	p := &scanPlan{timeout: time.Second}
+	h := &HostResult{Host: "127.0.0.1"}
+	ntlm := "; " + corpDC.String()
+	for _, tt := range []struct {
+		name string
+		port int
+		want *TCPService
+	}{
+		{"signing required", serveTCP(t, fakeSMB(0x03)), &TCPService{Details: "SMB 3.0.2, signing required" + ntlm, NTLM: &corpDC}},
+		{"signing enabled", serveTCP(t, fakeSMB(0x01)), &TCPService{Details: "SMB 3.0.2" + ntlm, Issues: []string{"signing not required"}, NTLM: &corpDC}},
+	} {
+		got, err := p.tcpProbe(context.Background(), nil, h, tt.port, tcpProbes["smb"])
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: smb probe = %+v, %v; want %+v", tt.name, got, err, tt.want)
+		}
+	}
+
+	// A web server is no SMB server.
+	web := serveTCP(t, func(conn net.Conn) {
+		conn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+	})
+	if got, err := p.tcpProbe(context.Background(), nil, h, web, tcpProbes["smb"]); got != nil || err != nil {
+		t.Errorf("smb probe of a web server = %+v, %v", got, err)
+	}
+}
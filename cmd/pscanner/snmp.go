@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// snmpCommunityEnv holds the SNMPv2c community of --snmp-harvest, kept out
+// of the command line, where other users could read it.
+const snmpCommunityEnv = "PSCANNER_SNMP_COMMUNITY"
+
+// Tests point snmpPort at their own agent.
+var snmpPort = 161
+
+const (
+	// snmpMaxRows bounds the rows read from one table of a device.
+	snmpMaxRows = 10000
+	// snmpBulk is how many rows each GetBulk asks for.
+	snmpBulk = 25
+)
+
+// The MIB-2 objects --snmp-harvest reads.
+var (
+	oidSysServices = []uint32{1, 3, 6, 1, 2, 1, 1, 7, 0}
+	// ifDescr of the interfaces table.
+	oidIfDescr = []uint32{1, 3, 6, 1, 2, 1, 2, 2, 1, 2}
+	// ipNetToMediaNetAddress, the IPv4 ARP table.
+	oidARP = []uint32{1, 3, 6, 1, 2, 1, 4, 22, 1, 3}
+	// ipNetToPhysicalPhysAddress, the ARP and IPv6 neighbour table of
+	// newer agents, indexed by ifIndex, address type and address.
+	oidNeighbors = []uint32{1, 3, 6, 1, 2, 1, 4, 35, 1, 4}
+)
+
+// SNMP tag numbers of the PDUs and the values pscanner reads.
+const (
+	snmpGet         = 0xa0
+	snmpResponse    = 0xa2
+	snmpGetBulk     = 0xa5
+	snmpIPAddress   = 0x40
+	snmpEndOfMIB    = 0x82
+	snmpVersion2c   = 1
+	snmpLayerSwitch = 1 << 1 // sysServices: datalink/subnetwork
+	snmpLayerRouter = 1 << 2 // sysServices: internet
+)
+
+// SNMPTables is what --snmp-harvest read from a router or switch.
+type SNMPTables struct {
+	Interfaces []string `json:"interfaces,omitempty"`
+	// Neighbors are the addresses in its ARP and neighbour tables.
+	Neighbors []string `json:"neighbors,omitempty"`
+}
+
+func (t *SNMPTables) String() string {
+	s := fmt.Sprintf("%d interfaces", len(t.Interfaces))
+	if len(t.Interfaces) > 0 {
+		s += " (" + truncate(strings.Join(t.Interfaces, ", "), 60) + ")"
+	}
+	return s + fmt.Sprintf(", %d neighbors", len(t.Neighbors))
+}
+
+// snmpVarBind is an object and its value, as the tag and content of its
+// BER element.
+type snmpVarBind struct {
+	oid   []uint32
+	tag   byte
+	value []byte
+}
+
+// encodeOID encodes oid as the content of a BER OBJECT IDENTIFIER.
+func encodeOID(oid []uint32) []byte {
+	b := []byte{byte(oid[0]*40 + oid[1])}
+	for _, arc := range oid[2:] {
+		// Base 128, most significant group first, all but the last with
+		// the high bit set.
+		enc := []byte{byte(arc & 0x7f)}
+		for arc >>= 7; arc > 0; arc >>= 7 {
+			enc = append(enc, byte(arc&0x7f)|0x80)
+		}
+		slices.Reverse(enc)
+		b = append(b, enc...)
+	}
+	return b
+}
+
+// parseOID reads the content of a BER OBJECT IDENTIFIER.
+func parseOID(b []byte) ([]uint32, bool) {
+	if len(b) == 0 {
+		return nil, false
+	}
+	oid := []uint32{uint32(b[0]) / 40, uint32(b[0]) % 40}
+	var arc uint32
+	for i, c := range b[1:] {
+		if arc > 1<<25 {
+			return nil, false
+		}
+		arc = arc<<7 | uint32(c&0x7f)
+		if c&0x80 == 0 {
+			oid = append(oid, arc)
+			arc = 0
+		} else if i == len(b)-2 {
+			return nil, false
+		}
+	}
+	return oid, true
+}
+
+// snmpRequest encodes an SNMPv2c request of type pdu for oids. For
+// GetBulk, a and b are the non-repeaters and max-repetitions; for others
+// they are zero.
+func snmpRequest(community string, pdu byte, id uint32, a, b uint32, oids ...[]uint32) []byte {
+	var binds [][]byte
+	for _, oid := range oids {
+		binds = append(binds, berTLV(0x30, berTLV(0x06, encodeOID(oid)), []byte{0x05, 0}))
+	}
+	return berTLV(0x30,
+		berInt(0x02, snmpVersion2c),
+		berTLV(0x04, []byte(community)),
+		berTLV(pdu, berInt(0x02, id), berInt(0x02, a), berInt(0x02, b), berTLV(0x30, binds...)))
+}
+
+// errSNMPOtherRequest is the error of a response to an earlier request,
+// such as one that came late.
+var errSNMPOtherRequest = errors.New("answer to another request")
+
+// parseSNMPResponse reads the response to the request id.
+func parseSNMPResponse(msg []byte, id uint32) ([]snmpVarBind, error) {
+	tag, content, _, ok := readBER(msg)
+	if !ok || tag != 0x30 {
+		return nil, errors.New("not an SNMP message")
+	}
+	for range 2 { // version, community
+		if _, _, content, ok = readBER(content); !ok {
+			return nil, errors.New("not an SNMP message")
+		}
+	}
+	tag, pdu, _, ok := readBER(content)
+	if !ok || tag != snmpResponse {
+		return nil, errors.New("not an SNMP response")
+	}
+	var ints [3]int
+	for i := range ints {
+		var f []byte
+		if tag, f, pdu, ok = readBER(pdu); !ok || tag != 0x02 {
+			return nil, errors.New("malformed SNMP response")
+		}
+		if ints[i], ok = berInteger(f); !ok {
+			return nil, errors.New("malformed SNMP response")
+		}
+	}
+	if uint32(ints[0]) != id {
+		return nil, errSNMPOtherRequest
+	}
+	if ints[1] != 0 {
+		return nil, fmt.Errorf("SNMP error status %d", ints[1])
+	}
+	tag, list, _, ok := readBER(pdu)
+	if !ok || tag != 0x30 {
+		return nil, errors.New("malformed SNMP response")
+	}
+	var binds []snmpVarBind
+	for len(list) > 0 {
+		var bind, oidContent []byte
+		var vb snmpVarBind
+		if tag, bind, list, ok = readBER(list); !ok || tag != 0x30 {
+			return nil, errors.New("malformed SNMP variable")
+		}
+		if tag, oidContent, bind, ok = readBER(bind); !ok || tag != 0x06 {
+			return nil, errors.New("malformed SNMP variable")
+		}
+		if vb.oid, ok = parseOID(oidContent); !ok {
+			return nil, errors.New("malformed SNMP variable")
+		}
+		if vb.tag, vb.value, _, ok = readBER(bind); !ok {
+			return nil, errors.New("malformed SNMP variable")
+		}
+		binds = append(binds, vb)
+	}
+	return binds, nil
+}
+
+// snmpClient asks one agent, over UDP, with --timeout for each answer and
+// --retries more tries.
+type snmpClient struct {
+	plan      *scanPlan
+	addr      netip.Addr
+	community string
+}
+
+// ask sends a request of type pdu and returns the variables of the answer.
+func (c *snmpClient) ask(ctx context.Context, pdu byte, a, b uint32, oids ...[]uint32) ([]snmpVarBind, error) {
+	conn, err := c.plan.dialUDP(c.addr, snmpPort)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	defer context.AfterFunc(ctx, func() { conn.Close() })()
+	var idb [4]byte
+	rand.Read(idb[:])
+	id := binary.BigEndian.Uint32(idb[:]) >> 1
+	req := snmpRequest(c.community, pdu, id, a, b, oids...)
+	buf := make([]byte, 65536)
+	for range 1 + c.plan.retries {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(c.plan.timeout))
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				break
+			}
+			if binds, err := parseSNMPResponse(buf[:n], id); !errors.Is(err, errSNMPOtherRequest) {
+				return binds, err
+			}
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+	return nil, errors.New("no answer")
+}
+
+// walk reads the objects under root, in order, up to snmpMaxRows.
+func (c *snmpClient) walk(ctx context.Context, root []uint32) ([]snmpVarBind, error) {
+	var rows []snmpVarBind
+	next := root
+	for len(rows) < snmpMaxRows {
+		binds, err := c.ask(ctx, snmpGetBulk, 0, snmpBulk, next)
+		if err != nil {
+			return rows, err
+		}
+		if len(binds) == 0 {
+			return rows, nil
+		}
+		for _, vb := range binds {
+			if vb.tag == snmpEndOfMIB || !hasOIDPrefix(vb.oid, root) || slices.Compare(vb.oid, next) <= 0 {
+				return rows, nil
+			}
+			rows = append(rows, vb)
+			next = vb.oid
+		}
+	}
+	return rows, nil
+}
+
+func hasOIDPrefix(oid, prefix []uint32) bool {
+	return len(oid) > len(prefix) && slices.Equal(oid[:len(prefix)], prefix)
+}
+
+// snmpTables asks the agent on addr whether it routes or switches, and if
+// so reads its interfaces and neighbours. It returns nil for other hosts
+// and those that do not answer to the community.
+func (p *scanPlan) snmpTables(ctx context.Context, addr netip.Addr) (*SNMPTables, error) {
+	c := &snmpClient{plan: p, addr: addr, community: p.snmpCommunity}
+	binds, err := c.ask(ctx, snmpGet, 0, 0, oidSysServices)
+	if err != nil || len(binds) != 1 || binds[0].tag != 0x02 {
+		return nil, nil
+	}
+	if services, _ := berInteger(binds[0].value); services&(snmpLayerSwitch|snmpLayerRouter) == 0 {
+		return nil, nil
+	}
+	t := new(SNMPTables)
+	ifaces, err := c.walk(ctx, oidIfDescr)
+	for _, vb := range ifaces {
+		if vb.tag == 0x04 && len(vb.value) > 0 {
+			t.Interfaces = append(t.Interfaces, string(vb.value))
+		}
+	}
+	seen := make(map[netip.Addr]bool)
+	addNeighbor := func(a netip.Addr) {
+		if a.IsValid() && !a.IsUnspecified() && !a.IsMulticast() && !seen[a] {
+			seen[a] = true
+			t.Neighbors = append(t.Neighbors, a.String())
+		}
+	}
+	arp, arpErr := c.walk(ctx, oidARP)
+	for _, vb := range arp {
+		if vb.tag == snmpIPAddress && len(vb.value) == 4 {
+			addNeighbor(netip.AddrFrom4([4]byte(vb.value)))
+		}
+	}
+	nd, _ := c.walk(ctx, oidNeighbors)
+	for _, vb := range nd {
+		addNeighbor(neighborAddr(vb.oid[len(oidNeighbors):]))
+	}
+	if err == nil {
+		err = arpErr
+	}
+	return t, err
+}
+
+// neighborAddr reads the address from the index of an
+// ipNetToPhysicalTable row: ifIndex, address type (1 IPv4, 2 IPv6), address
+// length and the address bytes. Zoned addresses, which are reachable from
+// the device's link only, are left out.
+func neighborAddr(index []uint32) netip.Addr {
+	if len(index) < 3 || index[1] != 1 && index[1] != 2 || int(index[2]) != len(index)-3 {
+		return netip.Addr{}
+	}
+	b := make([]byte, len(index)-3)
+	for i, v := range index[3:] {
+		if v > 0xff {
+			return netip.Addr{}
+		}
+		b[i] = byte(v)
+	}
+	a, _ := netip.AddrFromSlice(b)
+	return a
+}
+
+// harvestSNMP asks the hosts that answered, for --snmp-harvest, whether
+// they route or switch, and reads the interfaces and neighbours of those
+// that do into their results.
+func (p *scanPlan) harvestSNMP(ctx context.Context, dns *dnsCache, hosts []HostResult) {
+	if p.snmpCommunity == "" || ctx.Err() != nil {
+		return
+	}
+	sem := make(chan struct{}, quicParallel)
+	var wg sync.WaitGroup
+	for i := range hosts {
+		h := &hosts[i]
+		if len(h.Ports) == 0 || h.SNMP != nil {
+			continue
+		}
+		sem <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			addr, _, err := udpAddr(ctx, dns, h.Host, h.Family)
+			if err != nil {
+				return
+			}
+			t, err := p.snmpTables(ctx, addr)
+			if err != nil && ctx.Err() == nil {
+				slog.Warn("snmp harvest incomplete", "host", h.Host, "err", err)
+			}
+			h.SNMP = t
+		})
+	}
+	wg.Wait()
+}
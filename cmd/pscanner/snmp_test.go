@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestOIDRoundTrip(t *testing.T) {
+	for _, oid := range [][]uint32{
+		{1, 3, 6, 1, 2, 1, 1, 7, 0},
+		{1, 3, 6, 1, 4, 1, 9, 127, 128, 16383, 16384, 1 << 31},
+	} {
+		got, ok := parseOID(encodeOID(oid))
+		if !ok || !slices.Equal(got, oid) {
+			t.Errorf("parseOID(encodeOID(%v)) = %v, %v", oid, got, ok)
+		}
+	}
+	if got := encodeOID([]uint32{1, 3, 6, 1, 4, 1, 311}); !slices.Equal(got, []byte{0x2b, 6, 1, 4, 1, 0x82, 0x37}) {
+		t.Errorf("encodeOID = % x", got)
+	}
+	for _, b := range [][]byte{nil, {0x2b, 0x86}, {0x2b, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}} {
+		if _, ok := parseOID(b); ok {
+			t.Errorf("parseOID(% x) succeeded", b)
+		}
+	}
+}
+
+// snmpAnswer encodes a response to the request id from the community.
+func snmpAnswer(community string, id uint32, status uint32, binds ...snmpVarBind) []byte {
+	var list [][]byte
+	for _, vb := range binds {
+		list = append(list, berTLV(0x30, berTLV(0x06, encodeOID(vb.oid)), berTLV(vb.tag, vb.value)))
+	}
+	return berTLV(0x30,
+		berInt(0x02, snmpVersion2c),
+		berTLV(0x04, []byte(community)),
+		berTLV(snmpResponse, berInt(0x02, id), berInt(0x02, status), berInt(0x02, 0), berTLV(0x30, list...)))
+}
+
+func TestParseSNMPResponse(t *testing.T) {
+	msg := snmpAnswer("public", 7, 0, snmpVarBind{oid: oidSysServices, tag: 0x02, value: []byte{78}})
+	binds, err := parseSNMPResponse(msg, 7)
+	if err != nil || len(binds) != 1 || !slices.Equal(binds[0].oid, oidSysServices) || binds[0].tag != 0x02 || binds[0].value[0] != 78 {
+		t.Fatalf("parseSNMPResponse = %+v, %v", binds, err)
+	}
+	if _, err := parseSNMPResponse(msg, 8); err != errSNMPOtherRequest {
+		t.Errorf("other request: err = %v", err)
+	}
+	if _, err := parseSNMPResponse(snmpAnswer("public", 7, 2), 7); err == nil {
+		t.Error("error status accepted")
+	}
+	if _, err := parseSNMPResponse(msg[:len(msg)-2], 7); err == nil {
+		t.Error("truncated response accepted")
+	}
+}
+
+func TestNeighborAddr(t *testing.T) {
+	for _, tt := range []struct {
+		index []uint32
+		want  string
+	}{
+		{[]uint32{3, 1, 4, 10, 0, 0, 7}, "10.0.0.7"},
+		{[]uint32{3, 2, 16, 0xfd, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}, "fd00::1"},
+		{[]uint32{3, 1, 5, 10, 0, 0, 7}, "invalid IP"},
+		{[]uint32{3, 4, 4, 10, 0, 0, 7}, "invalid IP"},
+		{[]uint32{3, 1, 4, 10, 0, 0, 256}, "invalid IP"},
+	} {
+		if got := neighborAddr(tt.index).String(); got != tt.want {
+			t.Errorf("neighborAddr(%v) = %s, want %s", tt.index, got, tt.want)
+		}
+	}
+}
+
+// fakeSNMPAgent answers Get and GetBulk for the objects of mib, which must
+// be sorted, on a local UDP port that snmpPort points at during the test.
+func fakeSNMPAgent(t *testing.T, community string, mib []snmpVarBind) {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	old := snmpPort
+	snmpPort = pc.LocalAddr().(*net.UDPAddr).Port
+	t.Cleanup(func() { snmpPort = old })
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, msg, _, _ := readBER(buf[:n])
+			_, _, msg, _ = readBER(msg) // version
+			_, comm, msg, _ := readBER(msg)
+			pdu, fields, _, _ := readBER(msg)
+			if string(comm) != community {
+				continue
+			}
+			var ints [3]int
+			for i := range ints {
+				var f []byte
+				_, f, fields, _ = readBER(fields)
+				ints[i], _ = berInteger(f)
+			}
+			_, list, _, _ := readBER(fields)
+			_, bind, _, _ := readBER(list)
+			_, oidContent, _, _ := readBER(bind)
+			oid, _ := parseOID(oidContent)
+			var answer []snmpVarBind
+			switch pdu {
+			case snmpGet:
+				for _, vb := range mib {
+					if slices.Equal(vb.oid, oid) {
+						answer = append(answer, vb)
+					}
+				}
+			case snmpGetBulk:
+				for _, vb := range mib {
+					if slices.Compare(vb.oid, oid) > 0 && len(answer) < ints[2] {
+						answer = append(answer, vb)
+					}
+				}
+				if len(answer) == 0 {
+					answer = append(answer, snmpVarBind{oid: oid, tag: snmpEndOfMIB})
+				}
+			}
+			pc.WriteTo(snmpAnswer(community, uint32(ints[0]), 0, answer...), from)
+		}
+	}()
+}
+
+func under(oid []uint32, arcs ...uint32) []uint32 {
+	return append(slices.Clone(oid), arcs...)
+}
+
+func TestHarvestSNMP(t *testing.T) {
+	fakeSNMPAgent(t, "s3cret", []snmpVarBind{
+		{oid: oidSysServices, tag: 0x02, value: []byte{6}},
+		{oid: under(oidIfDescr, 1), tag: 0x04, value: []byte("lo")},
+		{oid: under(oidIfDescr, 2), tag: 0x04, value: []byte("eth0")},
+		{oid: []uint32{1, 3, 6, 1, 2, 1, 2, 2, 1, 3, 1}, tag: 0x02, value: []byte{24}},
+		{oid: under(oidARP, 2, 127, 0, 0, 1), tag: snmpIPAddress, value: []byte{127, 0, 0, 1}},
+		{oid: under(oidARP, 2, 127, 0, 0, 2), tag: snmpIPAddress, value: []byte{127, 0, 0, 2}},
+		{oid: under(oidARP, 2, 192, 0, 2, 1), tag: snmpIPAddress, value: []byte{192, 0, 2, 1}},
+		{oid: under(oidNeighbors, 2, 1, 4, 127, 0, 0, 2), tag: 0x04, value: []byte{2, 0, 0, 0, 0, 1}},
+		{oid: under(oidNeighbors, 2, 1, 4, 127, 0, 0, 3), tag: 0x04, value: []byte{2, 0, 0, 0, 0, 2}},
+	})
+	port := localPort(t)
+	targets, _ := parseTargets("127.0.0.1")
+	p := &scanPlan{
+		targets: targets, numTargets: 1, ports: []int{port}, workers: 2, timeout: time.Second,
+		snmpCommunity: "s3cret",
+		scope:         &scanScope{allow: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}},
+	}
+	hosts := p.run(context.Background(), scanHooks{})
+	if len(hosts) != 3 {
+		t.Fatalf("got %d hosts, want the target and two learned: %+v", len(hosts), hosts)
+	}
+	tables := hosts[0].SNMP
+	if tables == nil || !slices.Equal(tables.Interfaces, []string{"lo", "eth0"}) || !slices.Equal(tables.Neighbors, []string{"127.0.0.1", "127.0.0.2", "192.0.2.1", "127.0.0.3"}) {
+		t.Fatalf("tables = %+v", tables)
+	}
+	for i, want := range []string{"127.0.0.2", "127.0.0.3"} {
+		if h := hosts[1+i]; h.Host != want || h.LearnedFrom != "127.0.0.1" || h.LearnedBy != "snmp" || h.SNMP != nil {
+			t.Errorf("learned host %d = %+v", i, h)
+		}
+	}
+}
+
+func TestHarvestSNMPWrongCommunity(t *testing.T) {
+	fakeSNMPAgent(t, "s3cret", []snmpVarBind{{oid: oidSysServices, tag: 0x02, value: []byte{6}}})
+	p := &scanPlan{timeout: 50 * time.Millisecond, snmpCommunity: "public"}
+	hosts := []HostResult{{Host: "127.0.0.1", Ports: []PortResult{{Port: 22}}}}
+	p.harvestSNMP(context.Background(), newDNSCache(""), hosts)
+	if hosts[0].SNMP != nil {
+		t.Errorf("hosts = %+v", hosts)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// sourceDialer makes connections that start from a chosen interface,
+// address or port, for scan hosts with several NICs or alias IPs and for
+// testing firewalls that trust some source ports. Where the platform allows
+// (Linux), the sockets are also bound to the interface, so they leave
+// through it whatever the routing table says.
+type sourceDialer struct {
+	iface  string
+	v4, v6 netip.Addr // source address per family; invalid lets the system choose
+	port   int        // 0 lets the system choose
+}
+
+// newSourceDialer checks --interface, --source-ip and --source-port against
+// the host. Without --source-ip, the interface's first address of each
+// family is used.
+func newSourceDialer(iface, sourceIP string, port int) (*sourceDialer, error) {
+	s := &sourceDialer{iface: iface, port: port}
+	if iface != "" || sourceIP != "" {
+		if err := s.findAddrs(iface, sourceIP); err != nil {
+			return nil, err
+		}
+	}
+	if port != 0 {
+		if err := s.checkPort(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *sourceDialer) findAddrs(iface, sourceIP string) error {
+	var addrs []net.Addr
+	var err error
+	where := "this host"
+	if iface != "" {
+		ifi, err := net.InterfaceByName(iface)
+		if err != nil {
+			return fmt.Errorf("no network interface %q", iface)
+		}
+		if addrs, err = ifi.Addrs(); err != nil {
+			return fmt.Errorf("--interface %s: %v", iface, err)
+		}
+		if err := checkBindToDevice(iface); err != nil {
+			return fmt.Errorf("--interface %s: %v", iface, err)
+		}
+		where = iface
+	} else if addrs, err = net.InterfaceAddrs(); err != nil {
+		return err
+	}
+	var local []netip.Addr
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok {
+			if ip, ok := netip.AddrFromSlice(n.IP); ok {
+				local = append(local, ip.Unmap())
+			}
+		}
+	}
+
+	if sourceIP != "" {
+		ip, err := netip.ParseAddr(sourceIP)
+		if err != nil {
+			return fmt.Errorf("invalid --source-ip %q", sourceIP)
+		}
+		ip = ip.Unmap()
+		found := false
+		for _, a := range local {
+			found = found || a == ip
+		}
+		if !found {
+			return fmt.Errorf("--source-ip %s is not an address of %s", ip, where)
+		}
+		if ip.Is4() {
+			s.v4 = ip
+		} else {
+			s.v6 = ip
+		}
+		return nil
+	}
+	// Link-local IPv6 addresses only reach the link, so they make poor
+	// sources for a scan.
+	for _, a := range local {
+		switch {
+		case a.Is4() && !s.v4.IsValid():
+			s.v4 = a
+		case a.Is6() && !a.IsLinkLocalUnicast() && !s.v6.IsValid():
+			s.v6 = a
+		}
+	}
+	if !s.v4.IsValid() && !s.v6.IsValid() {
+		return fmt.Errorf("--interface %s has no usable addresses", iface)
+	}
+	return nil
+}
+
+// checkPort binds the source port once up front. Otherwise a port below
+// 1024 without root, or one a local service holds (such as a DNS resolver
+// on port 53), would fail every probe and look like a host with every port
+// closed.
+func (s *sourceDialer) checkPort() error {
+	if s.port < 1 || s.port > 65535 {
+		return fmt.Errorf("invalid --source-port %d", s.port)
+	}
+	if !fixedSourcePorts {
+		return errors.New("--source-port is not supported on this platform")
+	}
+	hosts := []string{""}
+	if s.v4.IsValid() || s.v6.IsValid() {
+		hosts = nil
+		for _, a := range []netip.Addr{s.v4, s.v6} {
+			if a.IsValid() {
+				hosts = append(hosts, a.String())
+			}
+		}
+	}
+	lc := net.ListenConfig{Control: sockControl(s.iface, true)}
+	for _, h := range hosts {
+		l, err := lc.Listen(context.Background(), "tcp", net.JoinHostPort(h, strconv.Itoa(s.port)))
+		if err != nil {
+			return fmt.Errorf("--source-port %d: %v", s.port, err)
+		}
+		l.Close()
+	}
+	return nil
+}
+
+// check rejects targets that cannot be reached from the source addresses,
+// such as IPv6 targets from an IPv4 --source-ip. Host names are left to
+// resolve to an address of a usable family.
+func (s *sourceDialer) check(targets targetList) error {
+	if !s.v4.IsValid() && !s.v6.IsValid() {
+		return nil
+	}
+	for _, t := range targets {
+		if t.name != "" {
+			continue
+		}
+		if a := t.prefix.Addr(); !s.from(a).IsValid() {
+			family := "IPv6"
+			if a.Unmap().Is4() {
+				family = "IPv4"
+			}
+			return fmt.Errorf("no %s source address for %s", family, t)
+		}
+	}
+	return nil
+}
+
+// from picks the source address for a target; host names use IPv4 when
+// there is an IPv4 source.
+func (s *sourceDialer) from(target netip.Addr) netip.Addr {
+	switch {
+	case target.IsValid() && target.Unmap().Is4():
+		return s.v4
+	case target.IsValid():
+		return s.v6
+	case s.v4.IsValid():
+		return s.v4
+	}
+	return s.v6
+}
+
+func (s *sourceDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	d := net.Dialer{Control: sockControl(s.iface, s.port != 0)}
+	var target netip.Addr
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		target, _ = netip.ParseAddr(host)
+	}
+	if src := s.from(target); src.IsValid() || s.port != 0 {
+		local := &net.TCPAddr{Port: s.port}
+		if src.IsValid() {
+			local.IP = src.AsSlice()
+		}
+		d.LocalAddr = local
+	}
+	return d.DialContext(ctx, network, addr)
+}
+
+// String describes the source for display, as "eth1 (10.0.0.7)" or
+// "10.0.0.7, port 53".
+func (s *sourceDialer) String() string {
+	var ips []string
+	for _, a := range []netip.Addr{s.v4, s.v6} {
+		if a.IsValid() {
+			ips = append(ips, a.String())
+		}
+	}
+	desc := strings.Join(ips, ", ")
+	if s.iface != "" {
+		desc = s.iface + " (" + desc + ")"
+	}
+	if s.port != 0 {
+		if desc != "" {
+			desc += ", "
+		}
+		desc += "port " + strconv.Itoa(s.port)
+	}
+	return desc
+}
@@ -0,0 +1,34 @@
+//go:build darwin || freebsd || netbsd || openbsd || dragonfly
+
+package main
+
+import "syscall"
+
+// fixedSourcePorts reports whether --source-port can be used. The BSDs need
+// SO_REUSEPORT as well as SO_REUSEADDR for concurrent probes to bind the
+// same address and port.
+const fixedSourcePorts = true
+
+// sockControl returns a net.Dialer Control function that sets the reuse
+// options for a fixed source port, or nil. Without SO_BINDTODEVICE,
+// --interface only picks the source address and the routing table picks the
+// way out.
+func sockControl(iface string, reuse bool) func(network, address string, c syscall.RawConn) error {
+	if !reuse {
+		return nil
+	}
+	return func(_, _ string, c syscall.RawConn) error {
+		var err error
+		if cerr := c.Control(func(fd uintptr) {
+			err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+			if err == nil {
+				err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEPORT, 1)
+			}
+		}); cerr != nil {
+			return cerr
+		}
+		return err
+	}
+}
+
+func checkBindToDevice(iface string) error { return nil }
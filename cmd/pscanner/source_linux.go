@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// fixedSourcePorts reports whether --source-port can be used. Linux needs
+// SO_REUSEADDR so that concurrent probes can all bind the port.
+const fixedSourcePorts = true
+
+// sockControl returns a net.Dialer Control function that binds sockets to
+// the interface with SO_BINDTODEVICE and, for a fixed source port, sets
+// SO_REUSEADDR. It returns nil when neither applies.
+func sockControl(iface string, reuse bool) func(network, address string, c syscall.RawConn) error {
+	if iface == "" && !reuse {
+		return nil
+	}
+	return func(_, _ string, c syscall.RawConn) error {
+		var err error
+		if cerr := c.Control(func(fd uintptr) {
+			if iface != "" {
+				err = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, iface)
+			}
+			if err == nil && reuse {
+				err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+			}
+		}); cerr != nil {
+			return cerr
+		}
+		return err
+	}
+}
+
+// checkBindToDevice tries SO_BINDTODEVICE on a throwaway socket, so that a
+// missing permission is reported up front rather than as every port closed.
+// Linux before 5.7 needs CAP_NET_RAW for it.
+func checkBindToDevice(iface string) error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		fd, err = syscall.Socket(syscall.AF_INET6, syscall.SOCK_STREAM, 0)
+	}
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+	if err := syscall.SetsockoptString(fd, syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, iface); err != nil {
+		return fmt.Errorf("binding to the interface: %v", err)
+	}
+	return nil
+}
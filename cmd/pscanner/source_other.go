@@ -0,0 +1,17 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+
+package main
+
+import "syscall"
+
+// fixedSourcePorts is false: without the Unix socket reuse options,
+// concurrent probes cannot share a source port.
+const fixedSourcePorts = false
+
+// sockControl returns nil: --interface only picks the source address and the
+// routing table picks the way out.
+func sockControl(iface string, reuse bool) func(network, address string, c syscall.RawConn) error {
+	return nil
+}
+
+func checkBindToDevice(iface string) error { return nil }
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSourceDialer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	from := make(chan string, 1)
+	go func() {
+		c, err := l.Accept()
+		if err == nil {
+			from <- c.RemoteAddr().(*net.TCPAddr).IP.String()
+			c.Close()
+		}
+	}()
+
+	iface := ""
+	if runtime.GOOS == "linux" {
+		iface = "lo"
+	}
+	s, err := newSourceDialer(iface, "127.0.0.1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	conn, err := s.DialContext(ctx, "tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if got := <-from; got != "127.0.0.1" {
+		t.Errorf("connection came from %s", got)
+	}
+
+	targets, _ := parseTargets("example.com,10.0.0.0/24,::1")
+	if err := s.check(targets); err == nil || !strings.Contains(err.Error(), "::1") {
+		t.Errorf("check with an IPv6 target and an IPv4 source = %v", err)
+	}
+}
+
+func TestNewSourceDialerErrors(t *testing.T) {
+	for _, tt := range []struct{ iface, ip, want string }{
+		{"", "192.0.2.1", "not an address"},
+		{"", "10.0.0", "invalid --source-ip"},
+		{"pscanner-none0", "", "no network interface"},
+	} {
+		if _, err := newSourceDialer(tt.iface, tt.ip, 0); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("newSourceDialer(%q, %q) = %v, want %q", tt.iface, tt.ip, err, tt.want)
+		}
+	}
+}
+
+func TestSourceFrom(t *testing.T) {
+	s := &sourceDialer{v4: netip.MustParseAddr("10.0.0.7"), v6: netip.MustParseAddr("2001:db8::7")}
+	for target, want := range map[string]string{"192.0.2.1": "10.0.0.7", "2001:db8::1": "2001:db8::7", "": "10.0.0.7"} {
+		a, _ := netip.ParseAddr(target)
+		if got := s.from(a); got.String() != want {
+			t.Errorf("from(%q) = %s, want %s", target, got, want)
+		}
+	}
+}
+
+func TestSourcePort(t *testing.T) {
+	if !fixedSourcePorts {
+		t.Skip("no fixed source ports on this platform")
+	}
+	open1, open2 := localPort(t), localPort(t)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	s, err := newSourceDialer("", "", port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets, _ := parseTargets("127.0.0.1")
+	plan := &scanPlan{targets: targets, numTargets: 1, ports: []int{min(open1, open2), max(open1, open2)}, workers: 2, timeout: 2 * time.Second, source: s}
+	// A second run reuses the port straight away, as --watch would.
+	for run := 1; run <= 2; run++ {
+		if h := plan.run(context.Background(), scanHooks{})[0]; len(h.Ports) != 2 {
+			t.Errorf("run %d: open ports %v, want both", run, h.Ports)
+		}
+	}
+
+	held, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer held.Close()
+	if _, err := newSourceDialer("", "127.0.0.1", held.Addr().(*net.TCPAddr).Port); err == nil {
+		t.Error("a source port a listener holds was accepted")
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sshTunnel dials targets from an SSH server through direct-tcpip channels,
+// the mechanism behind "ssh -W" and "ssh -L", so nothing has to be
+// installed on the jump host. The SSH connection itself goes through
+// forward, which may be a --proxy chain.
+type sshTunnel struct {
+	user    string
+	addr    string   // host:port of the SSH server
+	keys    []string // private key files; empty means the usual ~/.ssh ones
+	forward contextDialer
+
+	mu     sync.Mutex
+	client *ssh.Client // nil until connected and after the connection drops
+	err    error       // the last failure to connect
+	failed time.Time
+}
+
+// sshRetryAfter is how long probes fail straight away after a failure to
+// connect, instead of each trying again.
+const sshRetryAfter = 5 * time.Second
+
+// parseSSHTarget reads "[user@]host[:port]" or an ssh:// URL. The user
+// defaults to the local one, as with ssh.
+func parseSSHTarget(spec string, forward contextDialer) (*sshTunnel, error) {
+	spec = strings.TrimPrefix(spec, "ssh://")
+	t := &sshTunnel{forward: forward}
+	host := spec
+	if i := strings.LastIndex(spec, "@"); i >= 0 {
+		t.user, host = spec[:i], spec[i+1:]
+	}
+	if t.user == "" {
+		if u, err := user.Current(); err == nil {
+			t.user = u.Username
+		}
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), "22")
+	}
+	if h, _, _ := net.SplitHostPort(host); h == "" || t.user == "" {
+		return nil, fmt.Errorf("invalid --via-ssh %q (want user@host[:port])", spec)
+	}
+	t.addr = host
+	return t, nil
+}
+
+func (t *sshTunnel) String() string { return "ssh://" + t.user + "@" + t.addr }
+
+// connect returns the SSH connection, opening it first if there is none,
+// as at the start and when the connection dropped between --watch runs.
+func (t *sshTunnel) connect(ctx context.Context) (*ssh.Client, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.client != nil {
+		return t.client, nil
+	}
+	if t.err != nil && time.Since(t.failed) < sshRetryAfter {
+		return nil, t.err
+	}
+	c, err := t.dial(ctx)
+	if err != nil {
+		t.err, t.failed = err, time.Now()
+		return nil, err
+	}
+	t.client, t.err = c, nil
+	go func() {
+		c.Wait()
+		t.mu.Lock()
+		if t.client == c {
+			t.client = nil
+		}
+		t.mu.Unlock()
+	}()
+	return c, nil
+}
+
+func (t *sshTunnel) dial(ctx context.Context) (*ssh.Client, error) {
+	auth, err := t.authMethods()
+	if err != nil {
+		return nil, err
+	}
+	home, _ := os.UserHomeDir()
+	hostKeys, err := knownhosts.New(filepath.Join(home, ".ssh", "known_hosts"))
+	if err != nil {
+		return nil, fmt.Errorf("reading known hosts: %v", err)
+	}
+	config := &ssh.ClientConfig{
+		User:              t.user,
+		Auth:              auth,
+		HostKeyCallback:   hostKeys,
+		HostKeyAlgorithms: knownKeyAlgorithms(hostKeys, t.addr),
+	}
+	conn, err := t.forward.DialContext(ctx, "tcp", t.addr)
+	if err != nil {
+		return nil, err
+	}
+	if d, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(d)
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, t.addr, config)
+	if err != nil {
+		conn.Close()
+		var ke *knownhosts.KeyError
+		if errors.As(err, &ke) && len(ke.Want) == 0 {
+			return nil, fmt.Errorf("%s is not in ~/.ssh/known_hosts; connect once with ssh to check and add its key", t.addr)
+		}
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// authMethods offers the keys of a running ssh-agent, then the key files.
+// Keys with a passphrase need the agent.
+func (t *sshTunnel) authMethods() ([]ssh.AuthMethod, error) {
+	var signers []ssh.Signer
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			if s, err := agent.NewClient(conn).Signers(); err == nil {
+				signers = append(signers, s...)
+			}
+		}
+	}
+	keys := t.keys
+	if len(keys) == 0 {
+		home, _ := os.UserHomeDir()
+		for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+			keys = append(keys, filepath.Join(home, ".ssh", name))
+		}
+	}
+	for _, file := range keys {
+		pem, err := os.ReadFile(file)
+		if err != nil {
+			if len(t.keys) > 0 {
+				return nil, err
+			}
+			continue
+		}
+		s, err := ssh.ParsePrivateKey(pem)
+		var missing *ssh.PassphraseMissingError
+		switch {
+		case errors.As(err, &missing) && len(t.keys) == 0:
+			continue
+		case errors.As(err, &missing):
+			return nil, fmt.Errorf("%s has a passphrase; add it to ssh-agent instead", file)
+		case err != nil:
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		signers = append(signers, s)
+	}
+	if len(signers) == 0 {
+		return nil, errors.New("no SSH keys (start ssh-agent or pass --ssh-key)")
+	}
+	return []ssh.AuthMethod{ssh.PublicKeys(signers...)}, nil
+}
+
+// knownKeyAlgorithms lists the host key algorithms known_hosts has keys of
+// for addr. Without this the server may present a key type that is not on
+// file, such as RSA when only the Ed25519 key was recorded, and fail the
+// check.
+func knownKeyAlgorithms(hostKeys ssh.HostKeyCallback, addr string) []string {
+	pub, _, _ := ed25519.GenerateKey(nil)
+	probe, _ := ssh.NewPublicKey(pub)
+	var ke *knownhosts.KeyError
+	if !errors.As(hostKeys(knownhosts.Normalize(addr), &net.TCPAddr{}, probe), &ke) {
+		return nil
+	}
+	var algos []string
+	for _, k := range ke.Want {
+		switch typ := k.Key.Type(); typ {
+		case ssh.KeyAlgoRSA:
+			algos = append(algos, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA)
+		default:
+			algos = append(algos, typ)
+		}
+	}
+	return algos
+}
+
+func (t *sshTunnel) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	fail := func(err error) error { return &proxyError{t.String(), err} }
+	client, err := t.connect(ctx)
+	if err != nil {
+		return nil, fail(err)
+	}
+	conn, err := client.DialContext(ctx, network, addr)
+	var oce *ssh.OpenChannelError
+	switch {
+	case err == nil:
+		return conn, nil
+	case errors.As(err, &oce) && oce.Reason == ssh.ConnectionFailed:
+		// The server tried and could not connect: refused or unreachable.
+		return nil, fmt.Errorf("%w: %s", errPortNotOpen, oce.Message)
+	case errors.Is(err, context.DeadlineExceeded):
+		return nil, errPortNotOpen
+	}
+	return nil, fail(err)
+}
+
+// Close ends the SSH connection.
+func (t *sshTunnel) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.client == nil {
+		return nil
+	}
+	return t.client.Close()
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/pem"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// fakeSSHServer serves direct-tcpip channels for the given client key,
+// connecting to the targets itself. Channels to "denied.test" are refused
+// by policy.
+func fakeSSHServer(t *testing.T, hostKey ssh.Signer, clientKey ssh.PublicKey) string {
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if !bytes.Equal(key.Marshal(), clientKey.Marshal()) {
+				return nil, io.EOF
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, chans, reqs, err := ssh.NewServerConn(c, config)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				for nc := range chans {
+					var dest struct {
+						Host     string
+						Port     uint32
+						OrigHost string
+						OrigPort uint32
+					}
+					if nc.ChannelType() != "direct-tcpip" || ssh.Unmarshal(nc.ExtraData(), &dest) != nil {
+						nc.Reject(ssh.UnknownChannelType, "no")
+						continue
+					}
+					if dest.Host == "denied.test" {
+						nc.Reject(ssh.Prohibited, "administratively prohibited")
+						continue
+					}
+					target, err := net.Dial("tcp", net.JoinHostPort(dest.Host, strconv.Itoa(int(dest.Port))))
+					if err != nil {
+						nc.Reject(ssh.ConnectionFailed, err.Error())
+						continue
+					}
+					ch, creqs, err := nc.Accept()
+					if err != nil {
+						target.Close()
+						continue
+					}
+					go ssh.DiscardRequests(creqs)
+					go func() {
+						defer ch.Close()
+						defer target.Close()
+						go io.Copy(target, ch)
+						io.Copy(ch, target)
+					}()
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// sshHome makes a home directory with the client key and, when known is
+// set, a known_hosts entry for the server.
+func sshHome(t *testing.T, addr string, hostKey ssh.PublicKey, clientKey ed25519.PrivateKey, known bool) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("SSH_AUTH_SOCK", "")
+	os.Mkdir(filepath.Join(home, ".ssh"), 0o700)
+	block, err := ssh.MarshalPrivateKey(clientKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(home, ".ssh", "id_ed25519"), pem.EncodeToMemory(block), 0o600)
+	line := ""
+	if known {
+		line = knownhosts.Line([]string{knownhosts.Normalize(addr)}, hostKey) + "\n"
+	}
+	os.WriteFile(filepath.Join(home, ".ssh", "known_hosts"), []byte(line), 0o600)
+}
+
+// sshSetup starts a server and makes a home directory that can log in to
+// it, returning the server address.
+func sshSetup(t *testing.T, known bool) string {
+	_, hostPriv, _ := ed25519.GenerateKey(nil)
+	hostKey, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientPub, clientPriv, _ := ed25519.GenerateKey(nil)
+	clientKey, _ := ssh.NewPublicKey(clientPub)
+	addr := fakeSSHServer(t, hostKey, clientKey)
+	sshHome(t, addr, hostKey.PublicKey(), clientPriv, known)
+	return addr
+}
+
+func TestSSHTunnelScan(t *testing.T) {
+	open := localPort(t)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	closed := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	addr := sshSetup(t, true)
+	tunnel, err := parseSSHTarget("scanner@"+addr, &net.Dialer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tunnel.Close()
+	targets, _ := parseTargets("127.0.0.1,denied.test")
+	plan := &scanPlan{targets: targets, numTargets: 2, ports: []int{min(open, closed), max(open, closed)}, workers: 2, timeout: 5 * time.Second, proxy: tunnel}
+	hosts := plan.run(context.Background(), scanHooks{})
+	if h := hosts[0]; len(h.Ports) != 1 || h.Ports[0].Port != open || h.ProbeErrors != 0 {
+		t.Errorf("127.0.0.1: ports %v, %d probe errors; want only %d open", h.Ports, h.ProbeErrors, open)
+	}
+	if h := hosts[1]; len(h.Ports) != 0 || h.ProbeErrors != 2 {
+		t.Errorf("denied host: ports %v, %d probe errors; want 2 errors", h.Ports, h.ProbeErrors)
+	}
+}
+
+func TestSSHTunnelUnknownHost(t *testing.T) {
+	addr := sshSetup(t, false)
+
+	tunnel, _ := parseSSHTarget("scanner@"+addr, &net.Dialer{})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := tunnel.connect(ctx); err == nil || !strings.Contains(err.Error(), "known_hosts") {
+		t.Errorf("connect to an unknown host = %v", err)
+	}
+}
+
+func TestParseSSHTarget(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"admin@bastion", "ssh://admin@bastion:22"},
+		{"ssh://admin@bastion:2222", "ssh://admin@bastion:2222"},
+		{"admin@[2001:db8::1]", "ssh://admin@[2001:db8::1]:22"},
+	}
+	for _, tt := range tests {
+		tunnel, err := parseSSHTarget(tt.in, nil)
+		if err != nil || tunnel.String() != tt.want {
+			t.Errorf("parseSSHTarget(%q) = %v, %v; want %s", tt.in, tunnel, err, tt.want)
+		}
+	}
+	if _, err := parseSSHTarget("admin@", nil); err == nil {
+		t.Error("parseSSHTarget without a host succeeded")
+	}
+}
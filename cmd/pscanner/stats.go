@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// statsErrorRate is the share of failed probes above which the text report
+// warns of a host, for --stats.
+const statsErrorRate = 0.1
+
+// HostStats sums up how the probes of a host ended, for --stats.
+type HostStats struct {
+	Probed   int `json:"probed"`
+	Open     int `json:"open"`
+	Closed   int `json:"closed"`   // refused with a TCP reset
+	Filtered int `json:"filtered"` // rejected with ICMP or not answered
+	// Errors are the probes that ended in neither way, such as those that
+	// a proxy failed or that ran out of file descriptors.
+	Errors int `json:"errors"`
+	// Latency is the mean time the probes that were answered, open or
+	// refused, took to connect or be refused.
+	Latency Duration `json:"avg_latency"`
+}
+
+// ErrorRate is the share of the probes that failed.
+func (s *HostStats) ErrorRate() float64 {
+	if s.Probed == 0 {
+		return 0
+	}
+	return float64(s.Errors) / float64(s.Probed)
+}
+
+// AllFiltered says whether every probe of the host was filtered.
+func (s *HostStats) AllFiltered() bool {
+	return s.Probed > 0 && s.Filtered == s.Probed
+}
+
+func (s *HostStats) String() string {
+	out := fmt.Sprintf("%d probes: %d open, %d closed, %d filtered, %d errors (%.1f%%)",
+		s.Probed, s.Open, s.Closed, s.Filtered, s.Errors, 100*s.ErrorRate())
+	if s.Latency > 0 {
+		out += fmt.Sprintf("; answered in %s on average", time.Duration(s.Latency).Round(10*time.Microsecond))
+	}
+	return out
+}
+
+// hostTally counts the probes of a host as they end.
+type hostTally struct {
+	HostStats
+	answered int
+	latency  time.Duration
+}
+
+func (t *hostTally) add(j job) {
+	t.Probed++
+	switch {
+	case j.failed || j.errored:
+		t.Errors++
+		return
+	case j.refusal == refusedRST:
+		t.Closed++
+	case j.refusal == refusedICMP:
+		t.Filtered++
+	case j.refusal == refusedSilent:
+		t.Filtered++
+		return
+	default:
+		t.Open++
+	}
+	t.answered++
+	t.latency += j.latency
+}
+
+func (t *hostTally) stats() *HostStats {
+	s := t.HostStats
+	if t.answered > 0 {
+		s.Latency = Duration(t.latency / time.Duration(t.answered))
+	}
+	return &s
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunStats(t *testing.T) {
+	open, closed := localPort(t), closedPort(t)
+	targets, _ := parseTargets("127.0.0.1")
+	p := &scanPlan{targets: targets, numTargets: 1, ports: []int{open, closed}, workers: 2, timeout: time.Second, stats: true}
+	hosts := p.run(context.Background(), scanHooks{})
+	s := hosts[0].Stats
+	if s == nil {
+		t.Fatalf("host = %+v, want stats", hosts[0])
+	}
+	if s.Probed != 2 || s.Open != 1 || s.Closed != 1 || s.Filtered != 0 || s.Errors != 0 || s.Latency <= 0 {
+		t.Errorf("stats = %+v", *s)
+	}
+	if hosts[0].Closed != "" {
+		t.Errorf("closed ports %q listed without --state", hosts[0].Closed)
+	}
+}
+
+func TestHostStats(t *testing.T) {
+	var tally hostTally
+	for _, j := range []job{
+		{refusal: refusedSilent}, {refusal: refusedSilent},
+		{refusal: refusedICMP, latency: 2 * time.Millisecond},
+		{refusal: refusedRST, latency: 4 * time.Millisecond},
+		{errored: true},
+	} {
+		tally.add(j)
+	}
+	s := tally.stats()
+	if s.Probed != 5 || s.Filtered != 3 || s.Closed != 1 || s.Errors != 1 || s.Latency != Duration(3*time.Millisecond) {
+		t.Errorf("stats = %+v", *s)
+	}
+	if s.ErrorRate() != 0.2 || s.AllFiltered() {
+		t.Errorf("error rate %v, all filtered %v", s.ErrorRate(), s.AllFiltered())
+	}
+	const want = "5 probes: 0 open, 1 closed, 3 filtered, 1 errors (20.0%); answered in 3ms on average"
+	if got := s.String(); got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+
+	r := &Report{Hosts: []HostResult{
+		{Host: "192.0.2.1", Stats: s},
+		{Host: "192.0.2.2", Stats: &HostStats{Probed: 3, Filtered: 3}},
+	}}
+	var b strings.Builder
+	if err := writeText(&b, r); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, want := range []string{"Warning: 1 hosts filtered every probe\n", "Warning: more than 10% of the probes failed on 1 hosts\n", "Stats: 3 probes: 0 open, 0 closed, 3 filtered, 0 errors (0.0%)\n"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("text report lacks %q:\n%s", want, out)
+		}
+	}
+}
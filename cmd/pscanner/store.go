@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// resultStore keeps finished scan reports as JSON files, one per scan and
+// named after the scan ID, in a directory. The files use the same format
+// as --output json.
+type resultStore struct {
+	dir string
+}
+
+// validScanID matches the IDs generated by newJobID, which keeps IDs taken
+// from requests from escaping the store directory.
+var validScanID = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+func openResultStore(dir string) (*resultStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &resultStore{dir: dir}, nil
+}
+
+func (s *resultStore) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// save writes r under its ID. The file is renamed into place so readers
+// never see a partial report.
+func (s *resultStore) save(r *Report) error {
+	if !validScanID.MatchString(r.ID) {
+		return fmt.Errorf("invalid scan id %q", r.ID)
+	}
+	f, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := writeReport(f, "json", r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.path(r.ID))
+}
+
+func (s *resultStore) load(id string) (*Report, error) {
+	if !validScanID.MatchString(id) {
+		return nil, errNoScan
+	}
+	data, err := os.ReadFile(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errNoScan
+	}
+	if err != nil {
+		return nil, err
+	}
+	var r Report
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("%s: %v", s.path(id), err)
+	}
+	return &r, nil
+}
+
+// list returns every stored report, oldest first. Files that cannot be
+// read are skipped so one damaged report does not hide the others.
+func (s *resultStore) list() ([]*Report, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var reports []*Report
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || e.IsDir() {
+			continue
+		}
+		if r, err := s.load(id); err == nil {
+			reports = append(reports, r)
+		}
+	}
+	sort.Slice(reports, func(a, b int) bool { return reports[a].StartedAt.Before(reports[b].StartedAt) })
+	return reports, nil
+}
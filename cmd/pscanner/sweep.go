@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The stages "discover --recursive" adds to the multicast protocols: a
+// sweep of the attached networks and the ARP table it fills.
+
+// sweepPorts are the TCP ports the sweep connects to, in turn, until a
+// host accepts or refuses one.
+var sweepPorts = []int{80, 443, 22, 445}
+
+const (
+	// sweepParallel bounds the connects in flight.
+	sweepParallel = 256
+	// sweepTimeout bounds each connect.
+	sweepTimeout = time.Second
+)
+
+// askSweep connects to sweepPorts of every address of the attached
+// networks of li, as NetBIOS asks them: a TCP ping sweep, which needs no
+// privileges. A host that accepts or refuses a connect is alive. Each
+// connect also makes the kernel ask for the address over ARP, which
+// askARP then reads.
+func askSweep(ctx context.Context, li lanInterface, f *lanFinds) error {
+	var addrs []netip.Addr
+	for _, n := range li.nets {
+		if 1<<(32-n.Bits()) > netbiosMaxHosts {
+			continue
+		}
+		network := n.Masked()
+		last := lastAddr(network)
+		for a := network.Addr().Next(); a.IsValid() && a.Less(last); a = a.Next() {
+			if a != n.Addr() {
+				addrs = append(addrs, a)
+			}
+		}
+	}
+	var alive sync.Map
+	sem := make(chan struct{}, sweepParallel)
+	var wg sync.WaitGroup
+	d := net.Dialer{Timeout: sweepTimeout}
+	for _, port := range sweepPorts {
+		for _, a := range addrs {
+			if _, ok := alive.Load(a); ok {
+				continue
+			}
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				wg.Wait()
+				return nil
+			}
+			wg.Go(func() {
+				defer func() { <-sem }()
+				conn, err := d.DialContext(ctx, "tcp4", net.JoinHostPort(a.String(), strconv.Itoa(port)))
+				if err == nil {
+					conn.Close()
+				}
+				if fail := classifyDial(err); fail == dialOK || fail == dialRefused {
+					alive.Store(a, true)
+					f.add(a, "sweep", nil, nil)
+				}
+			})
+		}
+		wg.Wait()
+	}
+	return nil
+}
+
+// askARP waits until the discovery ends, then lists the hosts of li's
+// networks in the complete entries of the ARP table: those the sweep or
+// other traffic reached, even if they answer nothing else.
+func askARP(ctx context.Context, li lanInterface, f *lanFinds) error {
+	<-ctx.Done()
+	for _, a := range readARPTable() {
+		for _, n := range li.nets {
+			if n.Contains(a) {
+				f.add(a, "arp", nil, nil)
+				break
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAskSweepAndARP(t *testing.T) {
+	old := sweepPorts
+	sweepPorts = []int{localPort(t)}
+	t.Cleanup(func() { sweepPorts = old })
+	fakeARPTable(t, "127.0.0.2", "0x2", "10.9.9.9", "0x2")
+	// The loopback network accepts or refuses every connect: its own
+	// address is left out, the rest are alive.
+	li := lanInterface{ifi: &net.Interface{Name: "lo"}, nets: []netip.Prefix{netip.MustParsePrefix("127.0.0.1/30")}}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	var f lanFinds
+	if err := askSweep(ctx, li, &f); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if err := askARP(ctx, li, &f); err != nil {
+		t.Fatal(err)
+	}
+	want := []lanDevice{{Address: "127.0.0.2", Sources: []string{"arp", "sweep"}}}
+	if got := f.list(); !reflect.DeepEqual(got, want) {
+		t.Errorf("list = %+v, want %+v", got, want)
+	}
+}
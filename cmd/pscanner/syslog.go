@@ -0,0 +1,286 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Syslog severities used by pscanner (RFC 5424, section 6.2.1).
+const (
+	syslogWarning = 4 // an instance open to anyone, a finding of high severity
+	syslogNotice  = 5 // an open port, opened or closed
+	syslogInfo    = 6 // a scan summary
+)
+
+// syslogFacility is "user-level messages". SIEM rules usually match on
+// APP-NAME and MSGID rather than on the facility.
+const syslogFacility = 1
+
+// syslogSDID identifies pscanner's structured data. 32473 is the private
+// enterprise number reserved for documentation and examples (RFC 5612).
+const syslogSDID = "pscanner@32473"
+
+var syslogHost = sync.OnceValue(func() string {
+	h, err := os.Hostname()
+	if err != nil || h == "" {
+		return "-"
+	}
+	return h
+})
+
+// sdParam is one PARAM-NAME="value" pair of structured data.
+type sdParam struct{ name, value string }
+
+// syslogLine formats one RFC 5424 message, terminated by a newline.
+func syslogLine(severity int, t time.Time, msgID string, params []sdParam, msg string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<%d>1 %s %s pscanner %d %s [%s", syslogFacility*8+severity,
+		t.UTC().Format("2006-01-02T15:04:05.000000Z"), syslogHost(), os.Getpid(), msgID, syslogSDID)
+	for _, p := range params {
+		b.WriteString(" " + p.name + `="`)
+		// PARAM-VALUE must escape ", \ and ].
+		for _, r := range p.value {
+			if r == '"' || r == '\\' || r == ']' {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(r)
+		}
+		b.WriteByte('"')
+	}
+	b.WriteString("] " + msg + "\n")
+	return b.String()
+}
+
+// portParams describes a port in structured data. started ties the port
+// events of one scan to its summary.
+func portParams(r *Report, c portChange) []sdParam {
+	params := []sdParam{
+		{"host", c.Host},
+		{"port", strconv.Itoa(c.Port)},
+		{"protocol", c.Protocol},
+	}
+	if c.Family != "" {
+		params = append(params, sdParam{"family", c.Family})
+	}
+	if name := serviceName(c.Port); name != "" {
+		params = append(params, sdParam{"service", name})
+	}
+	return append(tagParams(params, r), sdParam{"started", r.StartedAt.Format(time.RFC3339)})
+}
+
+// tagParams appends the tags of r to params, as one "tags" parameter.
+func tagParams(params []sdParam, r *Report) []sdParam {
+	if len(r.Tags) == 0 {
+		return params
+	}
+	return append(params, sdParam{"tags", formatTags(r.Tags)})
+}
+
+func portText(c portChange) string {
+	s := c.addr()
+	if name := serviceName(c.Port); name != "" {
+		s += " (" + name + ")"
+	}
+	return s
+}
+
+// writeSyslog writes one "port" message per open port, at warning severity
+// when --severity makes it high, an "open-instance" warning per
+// --open-instances instance that answers anyone, a "finding" message per
+// --safe-checks finding, and a closing "summary" message. Each message is
+// a single Write, so w may send every write as a datagram.
+func writeSyslog(w io.Writer, r *Report) error {
+	open, hosts := 0, 0
+	for _, h := range r.Hosts {
+		if len(h.Ports) > 0 {
+			hosts++
+		}
+		for _, p := range h.Ports {
+			open++
+			c := portOf(h, p)
+			params, severity := portParams(r, c), syslogNotice
+			if p.Severity != "" {
+				params = append(params, sdParam{"severity", p.Severity})
+			}
+			if p.Severity == severityHigh {
+				severity = syslogWarning
+			}
+			if _, err := io.WriteString(w, syslogLine(severity, r.FinishedAt, "port", params, "open port "+portText(c))); err != nil {
+				return err
+			}
+		}
+		for _, o := range h.OpenInstances {
+			if !o.Unauthenticated {
+				continue
+			}
+			params := []sdParam{{"host", h.Host}, {"port", strconv.Itoa(o.Port)}, {"service", o.Service}}
+			if o.Version != "" {
+				params = append(params, sdParam{"version", o.Version})
+			}
+			params = tagParams(params, r)
+			msg := fmt.Sprintf("%s %s answers without credentials", o.Service, net.JoinHostPort(h.Host, strconv.Itoa(o.Port)))
+			if _, err := io.WriteString(w, syslogLine(syslogWarning, r.FinishedAt, "open-instance", params, msg)); err != nil {
+				return err
+			}
+		}
+		for _, f := range h.Findings {
+			params := []sdParam{{"host", h.Host}, {"port", strconv.Itoa(f.Port)}, {"check", f.Check}, {"severity", f.Severity}, {"path", f.Path}}
+			if f.Version != "" {
+				params = append(params, sdParam{"version", f.Version})
+			}
+			params = tagParams(params, r)
+			severity := syslogNotice
+			if f.Severity == severityHigh {
+				severity = syslogWarning
+			}
+			msg := fmt.Sprintf("%s %s: %s", f.Check, net.JoinHostPort(h.Host, strconv.Itoa(f.Port)), f.Details)
+			if _, err := io.WriteString(w, syslogLine(severity, r.FinishedAt, "finding", params, msg)); err != nil {
+				return err
+			}
+		}
+	}
+	p := r.Parameters
+	params := []sdParam{
+		{"targets", strings.Join(p.Targets, ",")},
+		{"target_count", strconv.Itoa(p.TargetCount)},
+		{"ports", p.Ports},
+		{"open", strconv.Itoa(open)},
+		{"started", r.StartedAt.Format(time.RFC3339)},
+		{"finished", r.FinishedAt.Format(time.RFC3339)},
+	}
+	if r.ID != "" {
+		params = append(params, sdParam{"id", r.ID})
+	}
+	if r.Schedule != "" {
+		params = append(params, sdParam{"schedule", r.Schedule})
+	}
+	params = tagParams(params, r)
+	state := "finished"
+	if r.Canceled {
+		state = "stopped early"
+		params = append(params, sdParam{"canceled", "true"})
+	}
+	msg := fmt.Sprintf("scan %s: %d open ports on %d of %d hosts", state, open, hosts, p.TargetCount)
+	_, err := io.WriteString(w, syslogLine(syslogInfo, r.FinishedAt, "summary", params, msg))
+	return err
+}
+
+// writeSyslogChanges writes one "opened" or "closed" message per changed
+// port, for --watch.
+func writeSyslogChanges(w io.Writer, r *Report, d reportDiff) error {
+	for _, list := range []struct {
+		what  string
+		ports []portChange
+	}{{"opened", d.Opened}, {"closed", d.Closed}} {
+		for _, c := range list.ports {
+			line := syslogLine(syslogNotice, r.FinishedAt, list.what, portParams(r, c), "port "+list.what+" "+portText(c))
+			if _, err := io.WriteString(w, line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// syslogConn sends each Write as one message to a syslog daemon.
+type syslogConn struct {
+	addr string
+	conn net.Conn
+	// framing separates messages on stream sockets: "octets" prefixes each
+	// with its length (RFC 6587), as remote TCP and TLS receivers expect;
+	// "newline" ends each with a newline, as local daemons expect. Datagrams
+	// need neither.
+	framing string
+}
+
+// localSyslogSockets are where syslog daemons listen on Linux, macOS and
+// the BSDs.
+var localSyslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// dialSyslog connects to the daemon at addr: udp://host[:514],
+// tcp://host[:601] or tls://host[:6514]. An empty addr means the local
+// daemon.
+func dialSyslog(addr string) (*syslogConn, error) {
+	if addr == "" {
+		for _, path := range localSyslogSockets {
+			for _, network := range []string{"unixgram", "unix"} {
+				if c, err := net.Dial(network, path); err == nil {
+					framing := ""
+					if network == "unix" {
+						framing = "newline"
+					}
+					return &syslogConn{conn: c, framing: framing}, nil
+				}
+			}
+		}
+		return nil, errors.New("no local syslog daemon found (set --syslog-addr)")
+	}
+	u, err := url.Parse(addr)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid --syslog-addr %q (want udp://, tcp:// or tls://host:port)", addr)
+	}
+	host := u.Host
+	defaultPort := map[string]string{"udp": "514", "tcp": "601", "tls": "6514"}[u.Scheme]
+	if defaultPort == "" {
+		return nil, fmt.Errorf("invalid --syslog-addr %q (want udp://, tcp:// or tls://host:port)", addr)
+	}
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), defaultPort)
+	}
+	d := &net.Dialer{Timeout: 10 * time.Second}
+	var c net.Conn
+	switch u.Scheme {
+	case "tls":
+		c, err = tls.DialWithDialer(d, "tcp", host, &tls.Config{ServerName: u.Hostname()})
+	default:
+		c, err = d.Dial(u.Scheme, host)
+	}
+	if err != nil {
+		return nil, err
+	}
+	framing := "octets"
+	if u.Scheme == "udp" {
+		framing = ""
+	}
+	return &syslogConn{addr: addr, conn: c, framing: framing}, nil
+}
+
+func (s *syslogConn) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	switch s.framing {
+	case "octets":
+		msg = strconv.Itoa(len(msg)) + " " + msg
+	case "newline":
+		msg += "\n"
+	}
+	if err := s.send(msg); err != nil {
+		// A --watch run can outlive a TCP connection; reconnect once.
+		s.conn.Close()
+		c, derr := dialSyslog(s.addr)
+		if derr != nil {
+			return 0, err
+		}
+		s.conn = c.conn
+		if err := s.send(msg); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (s *syslogConn) send(msg string) error {
+	_ = s.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := io.WriteString(s.conn, msg)
+	return err
+}
+
+func (s *syslogConn) Close() error { return s.conn.Close() }
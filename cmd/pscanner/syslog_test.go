@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslogLine(t *testing.T) {
+	at := time.Date(2026, 10, 14, 12, 0, 0, 250000000, time.UTC)
+	got := syslogLine(syslogNotice, at, "port", []sdParam{{"host", `a"b]c\d`}, {"port", "22"}}, "open port a:22/tcp")
+	want := fmt.Sprintf(`<13>1 2026-10-14T12:00:00.250000Z %s pscanner %d port [pscanner@32473 host="a\"b\]c\\d" port="22"] open port a:22/tcp`+"\n",
+		syslogHost(), os.Getpid())
+	if got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+}
+
+func TestWriteSyslog(t *testing.T) {
+	r := testReport("22,80", hostWith("10.0.0.5", 22, 80), hostWith("10.0.0.6"))
+	r.Parameters.Targets = []string{"10.0.0.5", "10.0.0.6"}
+	r.Parameters.TargetCount = 2
+	r.Schedule = "office"
+	r.Tags = map[string]string{"site": "hq", "env": "prod"}
+	var b bytes.Buffer
+	if err := writeSyslog(&b, r); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("%d messages:\n%s", len(lines), b.String())
+	}
+	for _, want := range []string{` port [pscanner@32473 host="10.0.0.5" port="22" protocol="tcp" service="ssh" tags="env=prod,site=hq" `, "] open port 10.0.0.5:22/tcp (ssh)"} {
+		if !strings.Contains(lines[0], want) {
+			t.Errorf("port message %q lacks %q", lines[0], want)
+		}
+	}
+	for _, want := range []string{"<14>1 ", ` summary [pscanner@32473 targets="10.0.0.5,10.0.0.6"`, ` open="2"`, ` schedule="office" tags="env=prod,site=hq"`, "] scan finished: 2 open ports on 1 of 2 hosts"} {
+		if !strings.Contains(lines[2], want) {
+			t.Errorf("summary message %q lacks %q", lines[2], want)
+		}
+	}
+}
+
+func TestSyslogTCPFraming(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	got := make(chan string, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		var n int
+		r := bufio.NewReader(c)
+		if _, err := fmt.Fscanf(r, "%d ", &n); err != nil {
+			got <- err.Error()
+			return
+		}
+		msg := make([]byte, n)
+		_, _ = r.Read(msg)
+		got <- string(msg)
+	}()
+
+	c, err := dialSyslog("tcp://" + l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Write([]byte("<14>1 - - - - - - hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	if msg := <-got; msg != "<14>1 - - - - - - hello" {
+		t.Errorf("received %q", msg)
+	}
+
+	for _, addr := range []string{"syslog.example.com", "http://syslog.example.com"} {
+		if _, err := dialSyslog(addr); err == nil {
+			t.Errorf("dialSyslog(%q) succeeded", addr)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// parseTag reads a --tag of the form key=value.
+func parseTag(s string) (key, value string, err error) {
+	key, value, ok := strings.Cut(s, "=")
+	if !ok {
+		return "", "", fmt.Errorf("%q: expected key=value", s)
+	}
+	return key, value, checkTag(key, value)
+}
+
+// checkTag says whether key=value is a valid tag. Keys are letters, digits
+// and "_.-"; values anything printable but spaces and commas, so that a
+// list of tags reads back unambiguously.
+func checkTag(key, value string) error {
+	s := key + "=" + value
+	if key == "" || value == "" {
+		return fmt.Errorf("%q: expected key=value", s)
+	}
+	for _, r := range key {
+		if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || strings.ContainsRune("_.-", r)) {
+			return fmt.Errorf("%q: a key may hold letters, digits, _, . and - only", s)
+		}
+	}
+	for _, r := range value {
+		if r <= ' ' || r == ',' || r == 0x7f {
+			return fmt.Errorf("%q: a value may not hold spaces, commas or control characters", s)
+		}
+	}
+	return nil
+}
+
+// tagList is the repeatable --tag flag.
+type tagList map[string]string
+
+func (l *tagList) Set(s string) error {
+	key, value, err := parseTag(s)
+	if err != nil {
+		return err
+	}
+	if _, ok := (*l)[key]; ok {
+		return fmt.Errorf("%q: tag %s given twice", s, key)
+	}
+	if *l == nil {
+		*l = make(tagList)
+	}
+	(*l)[key] = value
+	return nil
+}
+
+func (l *tagList) String() string { return formatTags(*l) }
+
+// formatTags lists tags as "env=prod,team=red", by key.
+func formatTags(tags map[string]string) string {
+	var list []string
+	for _, k := range slices.Sorted(maps.Keys(tags)) {
+		list = append(list, k+"="+tags[k])
+	}
+	return strings.Join(list, ",")
+}
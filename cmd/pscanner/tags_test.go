@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTagList(t *testing.T) {
+	var l tagList
+	for _, s := range []string{"engagement=acme", "env=prod", "ticket.id=SEC-1042"} {
+		if err := l.Set(s); err != nil {
+			t.Fatalf("Set(%q): %v", s, err)
+		}
+	}
+	if got := l.String(); got != "engagement=acme,env=prod,ticket.id=SEC-1042" {
+		t.Errorf("tags = %q", got)
+	}
+	if err := l.Set("env=dev"); err == nil || !strings.Contains(err.Error(), "given twice") {
+		t.Errorf("second env tag: %v", err)
+	}
+	for _, s := range []string{"env", "=prod", "env=", "my env=prod", "env=prod,dev", "env=two words", "env=\x01"} {
+		if _, _, err := parseTag(s); err == nil {
+			t.Errorf("parseTag(%q) succeeded", s)
+		}
+	}
+	if err := checkTag("env=x", "prod"); err == nil {
+		t.Error(`checkTag accepted the key "env=x"`)
+	}
+}
+
+func TestTextReportTags(t *testing.T) {
+	r := testReport("22", hostWith("10.0.0.5", 22))
+	r.Tags = map[string]string{"engagement": "acme", "env": "prod"}
+	var b strings.Builder
+	if err := writeText(&b, r); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "\nTags: engagement=acme,env=prod\n") {
+		t.Errorf("text report lacks the tags:\n%s", b.String())
+	}
+}
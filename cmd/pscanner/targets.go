@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// maxBlockBits bounds the host bits of a single CIDR block (at most 2^24
+// addresses), so a typo such as 10.0.0.0/1 fails fast.
+const maxBlockBits = 24
+
+// maxTargets bounds how many addresses a whole --host list may expand to.
+// Items are counted before overlap is removed, so this is an upper bound.
+const maxTargets = 1 << 26
+
+// targetSpec is one item of a --host list: either a hostname or an address
+// block. A single IP is stored as a full-length prefix.
+type targetSpec struct {
+	name   string       // hostname; empty for IPs and CIDR blocks
+	prefix netip.Prefix // valid for IPs and CIDR blocks
+	// zone is the interface of a link-local IPv6 address, as in
+	// fe80::1%eth0; the prefix holds the address without it.
+	zone string
+}
+
+func (t targetSpec) String() string {
+	if t.name != "" {
+		return t.name
+	}
+	if t.prefix.IsSingleIP() {
+		return t.prefix.Addr().WithZone(t.zone).String()
+	}
+	return t.prefix.String()
+}
+
+// isBlock reports whether t is a CIDR block of more than one address.
+func (t targetSpec) isBlock() bool {
+	return t.name == "" && !t.prefix.IsSingleIP()
+}
+
+// size is the number of addresses t covers.
+func (t targetSpec) size() int {
+	if t.name != "" {
+		return 1
+	}
+	return 1 << (t.prefix.Addr().BitLen() - t.prefix.Bits())
+}
+
+// targetList is a parsed --host list. Addresses are produced lazily by walk,
+// so large CIDR blocks are never materialised as strings up front.
+type targetList []targetSpec
+
+// parseTargets parses a comma-separated list of hostnames, IP addresses and
+// CIDR blocks, preserving input order and dropping repeated items.
+func parseTargets(spec string) (targetList, error) {
+	var list targetList
+	seen := make(map[string]struct{})
+	total := 0
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		var t targetSpec
+		switch {
+		case strings.Contains(item, "/"):
+			p, err := netip.ParsePrefix(item)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR: %s", item)
+			}
+			if p.Addr().BitLen()-p.Bits() > maxBlockBits {
+				return nil, fmt.Errorf("CIDR %s is too large (max %d addresses)", item, 1<<maxBlockBits)
+			}
+			t.prefix = p.Masked()
+		default:
+			if a, err := netip.ParseAddr(item); err == nil {
+				if a.Zone() != "" && !a.IsLinkLocalUnicast() {
+					return nil, fmt.Errorf("%s: only link-local IPv6 addresses take a zone", item)
+				}
+				t.prefix = netip.PrefixFrom(a, a.BitLen())
+				t.zone = a.Zone()
+			} else {
+				t.name = item
+			}
+		}
+		key := t.String()
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		if total += t.size(); total > maxTargets {
+			return nil, fmt.Errorf("--host expands to more than %d addresses", maxTargets)
+		}
+		list = append(list, t)
+	}
+	return list, nil
+}
+
+// walk calls fn for every target in input order: the name for hostnames, the
+// address for IPs and CIDR members, with its zone if it has one. Addresses
+// already covered by an earlier item are skipped; the same link-local
+// address on two interfaces is two targets. walk stops early when fn
+// returns false.
+func (l targetList) walk(fn func(name string, addr netip.Addr) bool) {
+	for i, t := range l {
+		if t.name != "" {
+			if !fn(t.name, netip.Addr{}) {
+				return
+			}
+			continue
+		}
+		// Only earlier blocks that overlap this one can cover its addresses.
+		var earlier []netip.Prefix
+		for _, e := range l[:i] {
+			if e.name == "" && e.zone == t.zone && e.prefix.Overlaps(t.prefix) {
+				earlier = append(earlier, e.prefix)
+			}
+		}
+	addrs:
+		for a := t.prefix.Addr(); t.prefix.Contains(a); a = a.Next() {
+			for _, e := range earlier {
+				if e.Contains(a) {
+					continue addrs
+				}
+			}
+			if !fn("", a.WithZone(t.zone)) {
+				return
+			}
+		}
+	}
+}
+
+// each calls fn with every target host as a dialable string.
+func (l targetList) each(fn func(host string) bool) {
+	l.walk(func(name string, addr netip.Addr) bool {
+		if name != "" {
+			return fn(name)
+		}
+		return fn(addr.String())
+	})
+}
+
+// count returns the number of distinct targets.
+func (l targetList) count() int {
+	n := 0
+	l.walk(func(string, netip.Addr) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// checkZones makes sure the link-local IPv6 targets can be dialed. The
+// same link-local address may be on every link, so each needs a zone, and
+// the zone must name an interface of this machine, by name or index.
+func (l targetList) checkZones() error {
+	for _, t := range l {
+		if t.name != "" || !t.prefix.Addr().IsLinkLocalUnicast() || !t.prefix.Addr().Is6() {
+			continue
+		}
+		if t.zone == "" {
+			if t.isBlock() {
+				return fmt.Errorf("%s is link-local; list its addresses with a zone naming their interface, as in %s%%eth0", t, t.prefix.Addr())
+			}
+			return fmt.Errorf("%s is link-local and needs a zone naming its interface, as in %s%%eth0", t, t)
+		}
+		var err error
+		if i, aerr := strconv.Atoi(t.zone); aerr == nil {
+			_, err = net.InterfaceByIndex(i)
+		} else {
+			_, err = net.InterfaceByName(t.zone)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: no interface %q on this machine", t, t.zone)
+		}
+	}
+	return nil
+}
+
+// hasZones reports whether any target carries a zone.
+func (l targetList) hasZones() bool {
+	for _, t := range l {
+		if t.zone != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// lastAddr returns the highest address in p.
+func lastAddr(p netip.Prefix) netip.Addr {
+	b := p.Masked().Addr().AsSlice()
+	for i := p.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 0x80 >> (i % 8)
+	}
+	a, _ := netip.AddrFromSlice(b)
+	return a
+}
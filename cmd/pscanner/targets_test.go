@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func expand(l targetList) []string {
+	var hosts []string
+	l.each(func(h string) bool {
+		hosts = append(hosts, h)
+		return true
+	})
+	return hosts
+}
+
+func TestParseTargets(t *testing.T) {
+	tests := []struct {
+		spec string
+		want []string
+	}{
+		{"example.com", []string{"example.com"}},
+		{" b.example , a.example ,, b.example", []string{"b.example", "a.example"}},
+		{"10.0.0.0/30", []string{"10.0.0.0", "10.0.0.1", "10.0.0.2", "10.0.0.3"}},
+		{"10.0.0.5/30", []string{"10.0.0.4", "10.0.0.5", "10.0.0.6", "10.0.0.7"}},
+		// Input order is kept; addresses covered by earlier items are dropped.
+		{"10.0.0.2,10.0.0.0/30,10.0.0.1", []string{"10.0.0.2", "10.0.0.0", "10.0.0.1", "10.0.0.3"}},
+		{"10.0.0.0/30,10.0.0.0/31,10.0.0.2/31", []string{"10.0.0.0", "10.0.0.1", "10.0.0.2", "10.0.0.3"}},
+		{"10.0.0.1,10.0.0.1/32", []string{"10.0.0.1"}},
+		{"2001:db8::/127,::1", []string{"2001:db8::", "2001:db8::1", "::1"}},
+		// The same link-local address on two links is two targets.
+		{"fe80::1%eth0,fe80::1%eth1,fe80::1%eth0", []string{"fe80::1%eth0", "fe80::1%eth1"}},
+	}
+	for _, tt := range tests {
+		l, err := parseTargets(tt.spec)
+		if err != nil {
+			t.Errorf("parseTargets(%q) unexpected error: %v", tt.spec, err)
+			continue
+		}
+		got := expand(l)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseTargets(%q) = %v, want %v", tt.spec, got, tt.want)
+		}
+		if n := l.count(); n != len(tt.want) {
+			t.Errorf("parseTargets(%q).count() = %d, want %d", tt.spec, n, len(tt.want))
+		}
+	}
+}
+
+func TestParseTargetsErrors(t *testing.T) {
+	tests := []struct {
+		spec    string
+		wantErr string
+	}{
+		{"10.0.0.0/33", "invalid CIDR"},
+		{"10.0.0.0/7", "too large"},
+		{"2001:db8::/100", "too large"},
+		{"2001:db8::1%eth0", "only link-local"},
+		// Each block is within the per-block cap but the total is not.
+		{"10.0.0.0/8,11.0.0.0/8,12.0.0.0/8,13.0.0.0/8,14.0.0.1", "more than"},
+	}
+	for _, tt := range tests {
+		_, err := parseTargets(tt.spec)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("parseTargets(%q) error = %v, want %q", tt.spec, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCheckZones(t *testing.T) {
+	ifaces, err := net.Interfaces()
+	if err != nil || len(ifaces) == 0 {
+		t.Skip("no interfaces")
+	}
+	name, index := ifaces[0].Name, strconv.Itoa(ifaces[0].Index)
+	for spec, wantErr := range map[string]string{
+		"fe80::1%" + name + ",10.0.0.1": "",
+		"fe80::1%" + index:              "",
+		"fe80::1":                       "needs a zone",
+		"fe80::/126":                    "list its addresses with a zone",
+		"fe80::1%nosuch0":               "no interface",
+	} {
+		l, err := parseTargets(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := l.checkZones(); wantErr == "" && err != nil || wantErr != "" && (err == nil || !strings.Contains(err.Error(), wantErr)) {
+			t.Errorf("checkZones(%q) = %v, want %q", spec, err, wantErr)
+		}
+	}
+}
+
+// The zone of a link-local target reaches the dialer.
+func TestRunDialsZone(t *testing.T) {
+	targets, _ := parseTargets("fe80::1%eth0")
+	d := &deadlineDialer{left: make(map[string]time.Duration)}
+	p := &scanPlan{targets: targets, numTargets: 1, ports: []int{22}, workers: 1, timeout: time.Second, proxy: d}
+	hosts := p.run(context.Background(), scanHooks{})
+	if _, ok := d.left["[fe80::1%eth0]:22"]; !ok {
+		t.Errorf("dialed %v, want [fe80::1%%eth0]:22", d.left)
+	}
+	if hosts[0].Host != "fe80::1%eth0" {
+		t.Errorf("host = %q", hosts[0].Host)
+	}
+}
+
+func TestTargetsEachStops(t *testing.T) {
+	l, err := parseTargets("10.0.0.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	l.each(func(string) bool {
+		n++
+		return n < 3
+	})
+	if n != 3 {
+		t.Errorf("each visited %d targets after stop, want 3", n)
+	}
+}
+
+func TestLastAddr(t *testing.T) {
+	tests := map[string]string{
+		"10.0.0.0/8":      "10.255.255.255",
+		"192.168.1.64/26": "192.168.1.127",
+		"10.0.0.1/32":     "10.0.0.1",
+		"2001:db8::/120":  "2001:db8::ff",
+	}
+	for in, want := range tests {
+		if got := lastAddr(netip.MustParsePrefix(in)).String(); got != want {
+			t.Errorf("lastAddr(%s) = %s, want %s", in, got, want)
+		}
+	}
+}
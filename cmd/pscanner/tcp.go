@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TCPService is a service that a --tcp probe identified on an open port.
+type TCPService struct {
+	Port int `json:"port"`
+	// Service is the name of the probe that identified it.
+	Service string `json:"service"`
+	// Details is what the answer showed, such as the server's name.
+	Details string `json:"details,omitempty"`
+	// Issues are the weaknesses the probe found, such as a camera stream
+	// anyone can play.
+	Issues []string `json:"issues,omitempty"`
+	// NTLM is what the server told of itself when asked to start NTLM
+	// authentication.
+	NTLM *NTLMInfo `json:"ntlm,omitempty"`
+	// realm is the Kerberos realm an ldap probe found, for the kerberos
+	// probe of the same host.
+	realm string
+}
+
+func (s TCPService) String() string {
+	str := fmt.Sprintf("tcp/%d %s", s.Port, s.Service)
+	if s.Details != "" {
+		str += ": " + s.Details
+	}
+	if len(s.Issues) > 0 {
+		str += " [" + strings.Join(s.Issues, "; ") + "]"
+	}
+	return str
+}
+
+// tcpProbe is a --tcp probe: a conversation with the service usually on
+// some ports, made when the scan finds one of them open.
+type tcpProbe struct {
+	ports []int
+	// probe talks to the service on conn, connected to port of h, and
+	// returns what it found, or nil if it is not that service.
+	probe func(p *scanPlan, conn net.Conn, h *HostResult, port int) (*TCPService, error)
+	// after names the probe whose findings on the same host this one
+	// uses, which is made first.
+	after string
+}
+
+// tcpProbeTimeout bounds each --tcp conversation, past the --timeout of
+// its connection.
+const tcpProbeTimeout = 5 * time.Second
+
+// tcpProbes are the --tcp probes by name.
+var tcpProbes = map[string]*tcpProbe{
+	"sip":    {ports: []int{5060}, probe: (*scanPlan).sipTCPProbe},
+	"rtsp":   {ports: []int{554, 8554}, probe: (*scanPlan).rtspProbe},
+	"smtp":   {ports: []int{25, 465, 587}, probe: (*scanPlan).smtpProbe},
+	"imap":   {ports: []int{143, 993}, probe: (*scanPlan).imapProbe},
+	"pop3":   {ports: []int{110, 995}, probe: (*scanPlan).pop3Probe},
+	"ldap":   {ports: []int{389, 636, 3268, 3269}, probe: (*scanPlan).ldapProbe},
+	"telnet": {ports: telnetPorts, probe: (*scanPlan).telnetProbe},
+	"smb":    {ports: []int{445}, probe: (*scanPlan).smbProbe},
+	"mssql":  {ports: []int{1433}, probe: (*scanPlan).mssqlProbe},
+	// kerberos asks about the realm the ldap probe found.
+	"kerberos": {ports: []int{88}, probe: (*scanPlan).kerberosProbe, after: "ldap"},
+}
+
+// tcpProbeNames are the names of the --tcp probes, sorted.
+func tcpProbeNames() []string {
+	names := make([]string, 0, len(tcpProbes))
+	for name := range tcpProbes {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// parseTCPProbes reads the --tcp list of probe names, where "all" stands
+// for every probe.
+func parseTCPProbes(s string) ([]string, error) {
+	return parseProbeNames(s, "TCP", tcpProbeNames())
+}
+
+// probeTCP makes the --tcp probes of the open ports they are for, over
+// connections made the way the scan made them, and records the services
+// that answer.
+func (p *scanPlan) probeTCP(ctx context.Context, hosts []HostResult) {
+	if len(p.tcpProbes) == 0 {
+		return
+	}
+	dns := newDNSCache(p.dnsCache)
+	sem := make(chan struct{}, quicParallel)
+	var wg sync.WaitGroup
+	for i := range hosts {
+		h := &hosts[i]
+		sem <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			// The probes that use the findings of others go last.
+			for _, late := range []bool{false, true} {
+				for _, pr := range h.Ports {
+					if pr.Protocol == "udp" {
+						continue
+					}
+					for _, name := range p.tcpProbes {
+						probe := tcpProbes[name]
+						if (probe.after != "") != late || !slices.Contains(probe.ports, pr.Port) {
+							continue
+						}
+						s, err := p.tcpProbe(ctx, dns, h, pr.Port, probe)
+						if err != nil {
+							slog.Warn("tcp probe failed", "host", h.Host, "port", pr.Port, "probe", name, "err", err)
+						}
+						if s != nil {
+							s.Port, s.Service = pr.Port, name
+							h.TCP = append(h.TCP, *s)
+						}
+					}
+				}
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// tcpProbe connects to port of h and lets probe talk to it.
+func (p *scanPlan) tcpProbe(ctx context.Context, dns *dnsCache, h *HostResult, port int, probe *tcpProbe) (*TCPService, error) {
+	conn, err := p.probe(ctx, dns, job{host: h.Host, port: port, family: h.Family})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	defer context.AfterFunc(ctx, func() { conn.Close() })()
+	return probe.probe(p, conn, h, port)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// telnetPorts are Telnet's port, its usual alternative, and the ports
+// terminal servers give their serial lines, 2000 plus the line.
+var telnetPorts = func() []int {
+	ports := []int{23, 2323}
+	for line := 1; line <= 16; line++ {
+		ports = append(ports, 2000+line)
+	}
+	return ports
+}()
+
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWILL = 251
+	telnetWONT = 252
+	telnetDO   = 253
+	telnetDONT = 254
+	telnetIAC  = 255
+
+	telnetEcho            = 1
+	telnetSuppressGoAhead = 3
+)
+
+const (
+	// telnetWait is how long a Telnet server is given to say something,
+	// and telnetQuiet how long it may pause before what it said is taken
+	// as the whole banner.
+	telnetWait  = 2 * time.Second
+	telnetQuiet = 500 * time.Millisecond
+	// telnetMaxBanner bounds what is kept of a banner, in bytes read and
+	// in characters reported.
+	telnetMaxBanner = 4096
+	telnetMaxShown  = 200
+)
+
+// telnetSession reads what a Telnet server or serial console prints,
+// answering its option negotiation as a plain terminal.
+type telnetSession struct {
+	conn net.Conn
+	r    *bufio.Reader
+	// data is what it printed, without the negotiation.
+	data []byte
+	// negotiated is set once it has sent a Telnet command.
+	negotiated bool
+	answered   map[[2]byte]bool
+}
+
+// read reads until the server has been quiet for a while, or deadline.
+func (t *telnetSession) read(deadline time.Time) {
+	wait := telnetWait
+	for len(t.data) < telnetMaxBanner {
+		until := time.Now().Add(wait)
+		if until.After(deadline) {
+			until = deadline
+		}
+		t.conn.SetReadDeadline(until)
+		b, err := t.r.ReadByte()
+		if err != nil {
+			return
+		}
+		if b != telnetIAC {
+			t.data = append(t.data, b)
+			wait = telnetQuiet
+			continue
+		}
+		if err := t.command(); err != nil {
+			return
+		}
+	}
+}
+
+// command reads a Telnet command, the IAC of which has been read, and
+// answers its negotiation: the server may echo and drop go-aheads, as it
+// must for a login prompt, and every other option is refused.
+func (t *telnetSession) command() error {
+	cmd, err := t.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	t.negotiated = true
+	switch cmd {
+	case telnetIAC:
+		// An escaped 255 among the data, which is no text.
+	case telnetWILL, telnetDO:
+		opt, err := t.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if t.answered[[2]byte{cmd, opt}] {
+			return nil
+		}
+		t.answered[[2]byte{cmd, opt}] = true
+		reply := byte(telnetWONT)
+		switch {
+		case cmd == telnetWILL && (opt == telnetEcho || opt == telnetSuppressGoAhead):
+			reply = telnetDO
+		case cmd == telnetWILL:
+			reply = telnetDONT
+		}
+		_, err = t.conn.Write([]byte{telnetIAC, reply, opt})
+		return err
+	case telnetWONT, telnetDONT:
+		// Refusals need no answer.
+		_, err := t.r.ReadByte()
+		return err
+	case telnetSB:
+		// Subnegotiation of an option, which was refused: skip to its end.
+		for {
+			b, err := t.r.ReadByte()
+			if err != nil {
+				return err
+			}
+			if b != telnetIAC {
+				continue
+			}
+			if b, err = t.r.ReadByte(); err != nil || b == telnetSE {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// telnetBanner makes readable text of what a terminal was sent: terminal
+// escape sequences and control characters are dropped, and the lines left
+// are joined with " | ".
+func telnetBanner(data []byte) string {
+	var lines []string
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		var b strings.Builder
+		for i := 0; i < len(line); {
+			r, size := utf8.DecodeRune(line[i:])
+			switch {
+			case r == 0x1b && i+1 < len(line) && line[i+1] == '[':
+				// A CSI sequence, such as a color, ends with a letter.
+				i += 2
+				for i < len(line) && (line[i] < 0x40 || line[i] > 0x7e) {
+					i++
+				}
+				size = 1
+			case r == '\t':
+				b.WriteByte(' ')
+			case r >= 0x20 && r != 0x7f && r != utf8.RuneError:
+				b.WriteRune(r)
+			}
+			i += size
+		}
+		if s := strings.TrimSpace(b.String()); s != "" {
+			lines = append(lines, s)
+		}
+	}
+	banner := strings.Join(lines, " | ")
+	if utf8.RuneCountInString(banner) > telnetMaxShown {
+		banner = string([]rune(banner)[:telnetMaxShown]) + "..."
+	}
+	return banner
+}
+
+// telnetProbe captures the banner of a Telnet server or of a serial
+// console on a terminal server, pressing Enter if it says nothing, as
+// consoles wait for a key. It notes a login over Telnet, which sends the
+// password in the clear, and a command prompt that asks for none.
+func (p *scanPlan) telnetProbe(conn net.Conn, h *HostResult, port int) (*TCPService, error) {
+	deadline := time.Now().Add(tcpProbeTimeout)
+	t := &telnetSession{conn: conn, r: bufio.NewReader(conn), answered: map[[2]byte]bool{}}
+	t.read(deadline)
+	if telnetBanner(t.data) == "" && time.Now().Before(deadline) {
+		conn.SetWriteDeadline(deadline)
+		if _, err := conn.Write([]byte("\r\n")); err == nil {
+			t.read(deadline)
+		}
+	}
+	banner := telnetBanner(t.data)
+	switch {
+	case !t.negotiated && banner == "":
+		return nil, nil
+	case !t.negotiated && strings.HasPrefix(banner, "SSH-"):
+		return nil, nil
+	case banner == "":
+		return &TCPService{Details: "no banner"}, nil
+	}
+	s := &TCPService{Details: fmt.Sprintf("banner %q", banner)}
+	lower := strings.ToLower(banner)
+	switch {
+	case strings.Contains(lower, "login") || strings.Contains(lower, "username") || strings.Contains(lower, "password"):
+		s.Issues = append(s.Issues, plaintextLogin)
+	case strings.HasSuffix(banner, "#") || strings.HasSuffix(banner, ">") || strings.HasSuffix(banner, "$"):
+		s.Issues = append(s.Issues, "command prompt without login")
+	}
+	return s, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTelnetProbe(t *testing.T) {
+	p := &scanPlan{timeout: time.Second}
+	h := &HostResult{Host: "127.0.0.1"}
+	replies := make(chan []byte, 1)
+	for _, tt := range []struct {
+		name   string
+		server func(net.Conn)
+		want   *TCPService
+	}{
+		{"login", func(conn net.Conn) {
+			conn.Write([]byte{
+				telnetIAC, telnetDO, 24, // terminal type
+				telnetIAC, telnetWILL, telnetEcho,
+				telnetIAC, telnetWILL, telnetSuppressGoAhead,
+				telnetIAC, telnetSB, 24, 1, telnetIAC, telnetSE,
+			})
+			conn.Write([]byte("\x1b[1mUbuntu 22.04.3 LTS\x1b[0m\r\n\r\nweb01 login: "))
+			got := make([]byte, 9)
+			io.ReadFull(conn, got)
+			replies <- got
+		}, &TCPService{Details: `banner "Ubuntu 22.04.3 LTS | web01 login:"`, Issues: []string{plaintextLogin}}},
+		{"serial console", func(conn net.Conn) {
+			line, _ := bufio.NewReader(conn).ReadString('\n')
+			if line == "\r\n" {
+				conn.Write([]byte("\r\nswitch01>"))
+			}
+			time.Sleep(time.Second)
+		}, &TCPService{Details: `banner "switch01>"`, Issues: []string{"command prompt without login"}}},
+		{"SSH", func(conn net.Conn) {
+			conn.Write([]byte("SSH-2.0-OpenSSH_9.6\r\n"))
+			time.Sleep(time.Second)
+		}, nil},
+	} {
+		got, err := p.tcpProbe(context.Background(), nil, h, serveTCP(t, tt.server), tcpProbes["telnet"])
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("telnet probe of %s = %+v, %v; want %+v", tt.name, got, err, tt.want)
+		}
+	}
+	want := []byte{telnetIAC, telnetWONT, 24, telnetIAC, telnetDO, telnetEcho, telnetIAC, telnetDO, telnetSuppressGoAhead}
+	if got := <-replies; !bytes.Equal(got, want) {
+		t.Errorf("negotiation answered with % x, want % x", got, want)
+	}
+}
+
+func TestTelnetBanner(t *testing.T) {
+	for _, tt := range []struct{ data, want string }{
+		{"\r\n\x1b[2J\x1b[H\x1b[32mUser Access Verification\x1b[0m\r\n\r\nUsername: ", "User Access Verification | Username:"},
+		{"line\x00one\tend\x07\r\n", "lineone end"},
+		{"\r\n\r\n", ""},
+	} {
+		if got := telnetBanner([]byte(tt.data)); got != tt.want {
+			t.Errorf("telnetBanner(%q) = %q, want %q", tt.data, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// isTerminal reports whether f looks like an interactive terminal. Without a
+// terminal ioctl this falls back to the character-device check, which also
+// accepts devices such as the null device.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+func makeRaw(f *os.File) (restore func(), err error) {
+	return nil, errors.New("raw terminal mode is not supported on this platform")
+}
+
+func terminalSize(f *os.File) (width, height int) { return 80, 24 }
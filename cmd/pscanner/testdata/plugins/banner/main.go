@@ -0,0 +1,44 @@
+// Command banner is a test --plugin probe: it sends HELLO and reports the
+// greeting it gets back.
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"unsafe"
+)
+
+//go:wasmimport pscanner send
+func send(ptr unsafe.Pointer, n uint32) int32
+
+//go:wasmimport pscanner recv
+func recv(ptr unsafe.Pointer, capacity uint32) int32
+
+func main() {
+	var req struct {
+		Call string `json:"call"`
+		Host string `json:"host"`
+		Port int    `json:"port"`
+	}
+	if err := json.NewDecoder(os.Stdin).Decode(&req); err != nil {
+		os.Exit(1)
+	}
+	out := json.NewEncoder(os.Stdout)
+	switch req.Call {
+	case "info":
+		out.Encode(map[string]any{"kind": "probe", "ports": []int{7000}, "doc": "the greeting"})
+	case "probe":
+		hello := []byte("HELLO\r\n")
+		if send(unsafe.Pointer(&hello[0]), uint32(len(hello))) != int32(len(hello)) {
+			os.Exit(1)
+		}
+		buf := make([]byte, 256)
+		n := recv(unsafe.Pointer(&buf[0]), uint32(len(buf)))
+		greeting, ok := strings.CutPrefix(strings.TrimSpace(string(buf[:max(n, 0)])), "WELCOME ")
+		if !ok {
+			return
+		}
+		out.Encode(map[string]any{"details": greeting + " on " + req.Host, "issues": []string{"no login"}})
+	}
+}
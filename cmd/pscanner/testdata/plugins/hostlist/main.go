@@ -0,0 +1,38 @@
+// Command hostlist is a test --plugin output format: a line of each host
+// and its open ports.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+func main() {
+	var req struct {
+		Call   string `json:"call"`
+		Report struct {
+			Hosts []struct {
+				Host  string `json:"host"`
+				Ports []struct {
+					Port int `json:"port"`
+				} `json:"ports"`
+			} `json:"hosts"`
+		} `json:"report"`
+	}
+	if err := json.NewDecoder(os.Stdin).Decode(&req); err != nil {
+		os.Exit(1)
+	}
+	switch req.Call {
+	case "info":
+		fmt.Println(`{"kind": "output"}`)
+	case "output":
+		for _, h := range req.Report.Hosts {
+			fmt.Print(h.Host)
+			for _, p := range h.Ports {
+				fmt.Print(" ", p.Port)
+			}
+			fmt.Println()
+		}
+	}
+}
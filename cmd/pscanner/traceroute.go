@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceHop is one step on the way to a host, found by --traceroute.
+type TraceHop struct {
+	TTL  int      `json:"ttl"`
+	Addr string   `json:"addr,omitempty"` // empty when nothing answered
+	RTT  Duration `json:"rtt,omitempty"`
+}
+
+const (
+	// maxHops is the highest TTL tried.
+	maxHops = 30
+	// maxSilentHops ends a trace after that many hops in a row that did
+	// not answer; whatever lies beyond drops the probes.
+	maxSilentHops = 5
+	// traceParallel is the number of hosts traced at once.
+	traceParallel = 16
+)
+
+// errTraceUnsupported is returned by tcpHop where the system offers no
+// way to read ICMP errors without raw sockets.
+var errTraceUnsupported = errors.New("--traceroute is only supported on Linux")
+
+// traceRoutes records the route to the first open port of every host
+// that has one. The probes are TCP connection attempts with a rising TTL,
+// so they take the way the scan's probes took and pass the same
+// firewalls; routers on the way report themselves by ICMP. A trace that
+// fails is reported on stderr and leaves the host without a route.
+func (p *scanPlan) traceRoutes(ctx context.Context, hosts []HostResult) {
+	if !p.traceroute {
+		return
+	}
+	sem := make(chan struct{}, traceParallel)
+	var wg sync.WaitGroup
+	for i := range hosts {
+		h := &hosts[i]
+		if len(h.Ports) == 0 {
+			continue
+		}
+		sem <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			route, err := p.trace(ctx, h.Host, h.Family, h.Ports[0].Port)
+			if err != nil {
+				slog.Warn("traceroute failed", "host", h.Host, "err", err)
+				return
+			}
+			h.Route = route
+		})
+	}
+	wg.Wait()
+}
+
+// trace finds the hops to host:port, over family for a hostname.
+func (p *scanPlan) trace(ctx context.Context, host, family string, port int) ([]TraceHop, error) {
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		network := "ip"
+		switch family {
+		case familyIPv4:
+			network = "ip4"
+		case familyIPv6:
+			network = "ip6"
+		}
+		addrs, err := net.DefaultResolver.LookupNetIP(ctx, network, host)
+		if err != nil {
+			return nil, err
+		}
+		addr = addrs[0]
+	}
+	dst := netip.AddrPortFrom(addr.Unmap(), uint16(port))
+	var src netip.Addr
+	if p.source != nil {
+		src = p.source.from(dst.Addr())
+	}
+	var route []TraceHop
+	silent := 0
+	for ttl := 1; ttl <= maxHops && ctx.Err() == nil; ttl++ {
+		start := time.Now()
+		hop, reached, err := tcpHop(src, dst, ttl, p.timeout)
+		if err != nil {
+			return nil, err
+		}
+		h := TraceHop{TTL: ttl}
+		if hop.IsValid() {
+			h.Addr = hop.String()
+			h.RTT = Duration(time.Since(start).Round(10 * time.Microsecond))
+			silent = 0
+		} else if silent++; silent == maxSilentHops {
+			return route, nil
+		}
+		route = append(route, h)
+		if reached {
+			break
+		}
+	}
+	return route, nil
+}
+
+// formatRoute writes a route on one line, as text output shows it.
+func formatRoute(route []TraceHop) string {
+	hops := make([]string, len(route))
+	for i, h := range route {
+		if h.Addr == "" {
+			hops[i] = "*"
+		} else {
+			hops[i] = fmt.Sprintf("%s (%s)", h.Addr, time.Duration(h.RTT))
+		}
+	}
+	return strings.Join(hops, " > ")
+}
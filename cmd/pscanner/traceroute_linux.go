@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"net/netip"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// tracerouteSupported reports whether --traceroute can be used. Linux
+// queues the ICMP errors a TCP connection attempt provokes on the socket
+// when IP_RECVERR is set, which needs no privileges.
+const tracerouteSupported = true
+
+// tcpHop sends a connection attempt to dst that expires after ttl hops.
+// It returns the address that answered, if any, and whether that was dst
+// itself. Nothing answering within timeout is not an error.
+func tcpHop(src netip.Addr, dst netip.AddrPort, ttl int, timeout time.Duration) (netip.Addr, bool, error) {
+	v4 := dst.Addr().Is4()
+	family, level, ttlOpt, recvErr := unix.AF_INET6, unix.IPPROTO_IPV6, unix.IPV6_UNICAST_HOPS, unix.IPV6_RECVERR
+	if v4 {
+		family, level, ttlOpt, recvErr = unix.AF_INET, unix.IPPROTO_IP, unix.IP_TTL, unix.IP_RECVERR
+	}
+	fd, err := unix.Socket(family, unix.SOCK_STREAM|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return netip.Addr{}, false, err
+	}
+	defer unix.Close(fd)
+	if err := unix.SetsockoptInt(fd, level, ttlOpt, ttl); err != nil {
+		return netip.Addr{}, false, err
+	}
+	if err := unix.SetsockoptInt(fd, level, recvErr, 1); err != nil {
+		return netip.Addr{}, false, err
+	}
+	if src.IsValid() {
+		if err := unix.Bind(fd, sockaddr(netip.AddrPortFrom(src, 0))); err != nil {
+			return netip.Addr{}, false, err
+		}
+	}
+
+	switch err := unix.Connect(fd, sockaddr(dst)); {
+	case err == nil || errors.Is(err, unix.ECONNREFUSED):
+		return dst.Addr(), true, nil
+	case !errors.Is(err, unix.EINPROGRESS):
+		return netip.Addr{}, false, err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return netip.Addr{}, false, nil
+		}
+		fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLOUT}}
+		n, err := unix.Poll(fds, int(wait.Milliseconds())+1)
+		if errors.Is(err, unix.EINTR) {
+			continue
+		}
+		if err != nil {
+			return netip.Addr{}, false, err
+		}
+		if n > 0 {
+			break
+		}
+	}
+	soErr, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_ERROR)
+	if err != nil {
+		return netip.Addr{}, false, err
+	}
+	if soErr == 0 || unix.Errno(soErr) == unix.ECONNREFUSED {
+		return dst.Addr(), true, nil
+	}
+	hop := icmpOffender(fd, level, recvErr)
+	return hop, hop == dst.Addr(), nil
+}
+
+// icmpOffender reads the address of the router that sent the ICMP error
+// queued on fd.
+func icmpOffender(fd, level, recvErr int) netip.Addr {
+	oob := make([]byte, 512)
+	_, oobn, _, _, err := unix.Recvmsg(fd, make([]byte, 64), oob, unix.MSG_ERRQUEUE)
+	if err != nil {
+		return netip.Addr{}
+	}
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return netip.Addr{}
+	}
+	for _, m := range msgs {
+		if int(m.Header.Level) != level || int(m.Header.Type) != recvErr {
+			continue
+		}
+		// struct sock_extended_err is followed by the offender's
+		// sockaddr_in or sockaddr_in6.
+		off := m.Data[sizeofSockExtendedErr:]
+		switch {
+		case level == unix.IPPROTO_IP && len(off) >= 8:
+			return netip.AddrFrom4([4]byte(off[4:8]))
+		case level == unix.IPPROTO_IPV6 && len(off) >= 24:
+			return netip.AddrFrom16([16]byte(off[8:24]))
+		}
+	}
+	return netip.Addr{}
+}
+
+// sizeofSockExtendedErr is the size of struct sock_extended_err.
+const sizeofSockExtendedErr = 16
+
+func sockaddr(ap netip.AddrPort) unix.Sockaddr {
+	if ap.Addr().Is4() {
+		return &unix.SockaddrInet4{Port: int(ap.Port()), Addr: ap.Addr().As4()}
+	}
+	return &unix.SockaddrInet6{Port: int(ap.Port()), Addr: ap.Addr().As16()}
+}
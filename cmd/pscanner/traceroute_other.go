@@ -0,0 +1,16 @@
+//go:build !linux
+
+package main
+
+import (
+	"net/netip"
+	"time"
+)
+
+// tracerouteSupported is false: only Linux hands ICMP errors to TCP
+// sockets (IP_RECVERR), and pscanner does not use raw sockets.
+const tracerouteSupported = false
+
+func tcpHop(src netip.Addr, dst netip.AddrPort, ttl int, timeout time.Duration) (netip.Addr, bool, error) {
+	return netip.Addr{}, false, errTraceUnsupported
+}
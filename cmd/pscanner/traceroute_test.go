@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTraceRoutes(t *testing.T) {
+	if !tracerouteSupported {
+		t.Skip(errTraceUnsupported)
+	}
+	port := localPort(t)
+	p := &scanPlan{timeout: time.Second, traceroute: true}
+	hosts := []HostResult{hostWith("127.0.0.1", port), hostWith("127.0.0.2")}
+	p.traceRoutes(context.Background(), hosts)
+	if r := hosts[0].Route; len(r) != 1 || r[0].TTL != 1 || r[0].Addr != "127.0.0.1" {
+		t.Errorf("route = %+v", r)
+	}
+	if hosts[1].Route != nil {
+		t.Errorf("route to a host without open ports: %+v", hosts[1].Route)
+	}
+}
+
+func TestFormatRoute(t *testing.T) {
+	route := []TraceHop{
+		{TTL: 1, Addr: "192.168.1.1", RTT: Duration(1200 * time.Microsecond)},
+		{TTL: 2},
+		{TTL: 3, Addr: "203.0.113.5", RTT: Duration(12 * time.Millisecond)},
+	}
+	if got := formatRoute(route); got != "192.168.1.1 (1.2ms) > * > 203.0.113.5 (12ms)" {
+		t.Errorf("formatRoute = %q", got)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// portSeen is when an open port of a host was first and last seen, as
+// "pscanner history --by-port" lists it.
+type portSeen struct {
+	Port      int       `json:"port"`
+	Protocol  string    `json:"protocol"`
+	Service   string    `json:"service,omitempty"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	// Scans counts the scans that found it open, of Probed that probed
+	// it. UDP ports count as probed by every scan.
+	Scans  int `json:"scans"`
+	Probed int `json:"probed"`
+	// Open is set when the latest scan that probed it found it open.
+	Open bool `json:"open"`
+}
+
+func (s portSeen) key() string { return fmt.Sprintf("%d/%s", s.Port, s.Protocol) }
+
+// scanChange is a scan that found TCP ports of a host opened or closed
+// since the scans before, with ports as "3389/tcp".
+type scanChange struct {
+	ScanID string    `json:"scan_id"`
+	Time   time.Time `json:"time"`
+	Opened []string  `json:"opened,omitempty"`
+	Closed []string  `json:"closed,omitempty"`
+}
+
+// hostTrend sums up the stored scans of a host.
+type hostTrend struct {
+	Host  string    `json:"host"`
+	Scans int       `json:"scans"`
+	From  time.Time `json:"from,omitzero"`
+	To    time.Time `json:"to,omitzero"`
+	// OpenFirst and OpenLast count the open ports of the first and the
+	// latest scan, and MinOpen and MaxOpen bound them over all scans.
+	OpenFirst int          `json:"open_first"`
+	OpenLast  int          `json:"open_last"`
+	MinOpen   int          `json:"min_open"`
+	MaxOpen   int          `json:"max_open"`
+	Ports     []portSeen   `json:"ports"`
+	Changes   []scanChange `json:"changes"`
+}
+
+// summarizeHost follows the open ports of host through its scans, oldest
+// first: when each port was first and last seen, and what opened and
+// closed from scan to scan. As with diff, a TCP port counts as closed only
+// in a scan that probed it, and as opened only once a scan before has
+// probed it, since a scan of other ports says nothing of it; UDP ports,
+// whose scans are not stored, are in the list but not the changes.
+func summarizeHost(host string, scans []hostScan) hostTrend {
+	t := hostTrend{Host: host, Scans: len(scans), Ports: []portSeen{}, Changes: []scanChange{}}
+	if len(scans) == 0 {
+		return t
+	}
+	t.From, t.To = scans[0].StartedAt, scans[len(scans)-1].StartedAt
+	t.OpenFirst, t.OpenLast = len(scans[0].Ports), len(scans[len(scans)-1].Ports)
+	t.MinOpen, t.MaxOpen = t.OpenFirst, t.OpenFirst
+	seen := make(map[string]*portSeen)
+	var order []string
+	probedBefore := make(map[int]bool)
+	for _, s := range scans {
+		t.MinOpen, t.MaxOpen = min(t.MinOpen, len(s.Ports)), max(t.MaxOpen, len(s.Ports))
+		probed := portSet(s.Probed)
+		open := make(map[string]bool)
+		change := scanChange{ScanID: s.ID, Time: s.StartedAt}
+		for _, pr := range s.Ports {
+			ps := portSeen{Port: pr.Port, Protocol: pr.Protocol}
+			k := ps.key()
+			open[k] = true
+			if seen[k] == nil {
+				ps.Service, ps.FirstSeen = pr.service(), s.StartedAt
+				seen[k] = &ps
+				order = append(order, k)
+			} else if pr.Protocol == "tcp" && !seen[k].Open {
+				change.Opened = append(change.Opened, k)
+			}
+			if pr.Protocol == "tcp" && seen[k].Scans == 0 && probedBefore[pr.Port] {
+				change.Opened = append(change.Opened, k)
+			}
+			seen[k].LastSeen = s.StartedAt
+			seen[k].Scans++
+		}
+		for _, k := range order {
+			ps := seen[k]
+			switch {
+			case open[k]:
+				ps.Probed++
+				ps.Open = true
+			case ps.Protocol == "udp":
+				ps.Probed++
+				ps.Open = false
+			case probed[ps.Port]:
+				ps.Probed++
+				if ps.Open {
+					change.Closed = append(change.Closed, k)
+				}
+				ps.Open = false
+			}
+		}
+		for port := range probed {
+			probedBefore[port] = true
+		}
+		if change.Opened != nil || change.Closed != nil {
+			t.Changes = append(t.Changes, change)
+		}
+	}
+	for _, k := range order {
+		t.Ports = append(t.Ports, *seen[k])
+	}
+	// The ports still open first, by port; then the others, the latest
+	// seen first.
+	slices.SortStableFunc(t.Ports, func(a, b portSeen) int {
+		switch {
+		case a.Open != b.Open:
+			if a.Open {
+				return -1
+			}
+			return 1
+		case !a.Open && !a.LastSeen.Equal(b.LastSeen):
+			return b.LastSeen.Compare(a.LastSeen)
+		case a.Protocol != b.Protocol:
+			return strings.Compare(a.Protocol, b.Protocol)
+		}
+		return a.Port - b.Port
+	})
+	return t
+}
+
+func writeHostTrend(w io.Writer, t hostTrend) {
+	if t.Scans == 0 {
+		fmt.Fprintf(w, "No complete scans of %s stored\n", t.Host)
+		return
+	}
+	const when = "2006-01-02 15:04"
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "PORT\tSERVICE\tFIRST SEEN\tLAST SEEN\tSCANS")
+	for _, s := range t.Ports {
+		scans := fmt.Sprintf("%d/%d", s.Scans, s.Probed)
+		if !s.Open {
+			scans += " (closed)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", s.key(), orDash(s.Service),
+			s.FirstSeen.Local().Format(when), s.LastSeen.Local().Format(when), scans)
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "\n%d scans from %s to %s: ", t.Scans, t.From.Local().Format(when), t.To.Local().Format(when))
+	switch {
+	case t.MinOpen == t.MaxOpen:
+		fmt.Fprintf(w, "%d open ports throughout\n", t.OpenLast)
+	default:
+		fmt.Fprintf(w, "%d open ports, now %d (between %d and %d)\n", t.OpenFirst, t.OpenLast, t.MinOpen, t.MaxOpen)
+	}
+	tw = tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, c := range t.Changes {
+		var what []string
+		if c.Opened != nil {
+			what = append(what, "opened "+strings.Join(c.Opened, ", "))
+		}
+		if c.Closed != nil {
+			what = append(what, "closed "+strings.Join(c.Closed, ", "))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", c.Time.Local().Format(when), c.ScanID, strings.Join(what, "; "))
+	}
+	tw.Flush()
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSummarizeHost(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, 10, d, 2, 0, 0, 0, time.UTC) }
+	tcp := func(port int) PortResult { return PortResult{Port: port, Protocol: "tcp", State: "open"} }
+	scans := []hostScan{
+		{ID: "s1", StartedAt: day(1), Probed: "1-1024", Ports: []PortResult{tcp(21), tcp(22), {Port: 161, Protocol: "udp", State: "open"}}},
+		{ID: "s2", StartedAt: day(2), Probed: "1-1024", Ports: []PortResult{tcp(22)}},
+		// 3389 was never probed before, so it is not counted as opened,
+		// and 22, not probed, is not counted as closed.
+		{ID: "s3", StartedAt: day(3), Probed: "3389", Ports: []PortResult{tcp(3389)}},
+		{ID: "s4", StartedAt: day(4), Probed: "1-1024,3389", Ports: []PortResult{tcp(21), tcp(22), tcp(3389)}},
+	}
+	got := summarizeHost("10.0.0.7", scans)
+	want := hostTrend{
+		Host: "10.0.0.7", Scans: 4, From: day(1), To: day(4),
+		OpenFirst: 3, OpenLast: 3, MinOpen: 1, MaxOpen: 3,
+		Ports: []portSeen{
+			{Port: 21, Protocol: "tcp", Service: "ftp", FirstSeen: day(1), LastSeen: day(4), Scans: 2, Probed: 3, Open: true},
+			{Port: 22, Protocol: "tcp", Service: "ssh", FirstSeen: day(1), LastSeen: day(4), Scans: 3, Probed: 3, Open: true},
+			{Port: 3389, Protocol: "tcp", Service: serviceName(3389), FirstSeen: day(3), LastSeen: day(4), Scans: 2, Probed: 2, Open: true},
+			{Port: 161, Protocol: "udp", FirstSeen: day(1), LastSeen: day(1), Scans: 1, Probed: 4},
+		},
+		Changes: []scanChange{
+			{ScanID: "s2", Time: day(2), Closed: []string{"21/tcp"}},
+			{ScanID: "s4", Time: day(4), Opened: []string{"21/tcp"}},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("summarizeHost =\n%+v\nwant\n%+v", got, want)
+	}
+
+	var b strings.Builder
+	writeHostTrend(&b, got)
+	for _, line := range []string{"21/tcp ", "2/3", "161/udp", "1/4 (closed)", "4 scans from ", "3 open ports, now 3 (between 1 and 3)", "s2  closed 21/tcp"} {
+		if !strings.Contains(b.String(), line) {
+			t.Errorf("trend lacks %q:\n%s", line, b.String())
+		}
+	}
+
+	if got := summarizeHost("10.0.0.8", nil); got.Scans != 0 || got.Ports == nil {
+		t.Errorf("no scans: %+v", got)
+	}
+}
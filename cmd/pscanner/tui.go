@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// tuiKey is a key press the TUI reacts to.
+type tuiKey int
+
+const (
+	keyNone tuiKey = iota
+	keyQuit
+	keyPause
+	keyUp
+	keyDown
+	keyEnter
+	keyBack
+)
+
+// parseKey maps the bytes of one terminal read to a key.
+func parseKey(b []byte) tuiKey {
+	switch string(b) {
+	case "q", "Q", "\x03": // Ctrl-C arrives as a byte in raw mode
+		return keyQuit
+	case "p", "P", " ":
+		return keyPause
+	case "\x1b[A", "\x1bOA", "k":
+		return keyUp
+	case "\x1b[B", "\x1bOB", "j":
+		return keyDown
+	case "\r", "\n":
+		return keyEnter
+	case "\x1b", "\x7f", "\b", "b":
+		return keyBack
+	}
+	return keyNone
+}
+
+// rateSamples is how many per-second probe rates the TUI remembers.
+const rateSamples = 120
+
+// tui is the live view of a scan started with --tui.
+type tui struct {
+	plan   *scanPlan
+	out    io.Writer
+	start  time.Time
+	probed atomic.Int64
+	pause  pauser
+
+	mu         sync.Mutex
+	hosts      []string // hosts with open ports, in the order they were found
+	open       map[string][]int
+	rates      []int // probes completed in each of the last seconds
+	lastProbed int64
+	selected   int
+	detail     bool
+	done       bool
+}
+
+// runTUI runs plan while showing its progress full-screen on the terminal.
+// It returns the results and whether the user stopped the scan early.
+func runTUI(plan *scanPlan) ([]HostResult, bool, error) {
+	restore, err := makeRaw(os.Stdin)
+	if err != nil {
+		return nil, false, err
+	}
+	// Alternate screen and hidden cursor; both are undone on the way out.
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer func() {
+		fmt.Print("\x1b[?25h\x1b[?1049l")
+		restore()
+	}()
+
+	t := &tui{plan: plan, out: os.Stdout, start: time.Now(), open: make(map[string][]int)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	keys := make(chan tuiKey)
+	go func() {
+		buf := make([]byte, 16)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				return
+			}
+			if k := parseKey(buf[:n]); k != keyNone {
+				keys <- k
+			}
+		}
+	}()
+
+	results := make(chan []HostResult, 1)
+	go func() {
+		results <- plan.run(ctx, scanHooks{
+			found:  t.found,
+			probed: func() { t.probed.Add(1) },
+			pause:  &t.pause,
+		})
+	}()
+
+	redraw := time.NewTicker(250 * time.Millisecond)
+	defer redraw.Stop()
+	sample := time.NewTicker(time.Second)
+	defer sample.Stop()
+
+	var hosts []HostResult
+	for {
+		t.draw()
+		select {
+		case hosts = <-results:
+			t.mu.Lock()
+			t.done = true
+			t.mu.Unlock()
+		case <-redraw.C:
+		case <-sample.C:
+			t.sample()
+		case k := <-keys:
+			if k == keyQuit {
+				if hosts == nil {
+					cancel()
+					return <-results, true, nil
+				}
+				return hosts, false, nil
+			}
+			t.key(k)
+		}
+	}
+}
+
+func (t *tui) found(host string, r PortResult) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.open[host]; !ok {
+		t.hosts = append(t.hosts, host)
+	}
+	t.open[host] = append(t.open[host], r.Port)
+	sort.Ints(t.open[host])
+}
+
+func (t *tui) sample() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done {
+		return
+	}
+	n := t.probed.Load()
+	t.rates = append(t.rates, int(n-t.lastProbed))
+	if len(t.rates) > rateSamples {
+		t.rates = t.rates[1:]
+	}
+	t.lastProbed = n
+}
+
+func (t *tui) key(k tuiKey) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch k {
+	case keyPause:
+		if !t.done {
+			t.pause.set(!t.pause.isPaused())
+		}
+	case keyUp:
+		if t.selected > 0 {
+			t.selected--
+		}
+	case keyDown:
+		if t.selected < len(t.hosts)-1 {
+			t.selected++
+		}
+	case keyEnter:
+		t.detail = len(t.hosts) > 0
+	case keyBack:
+		t.detail = false
+	}
+}
+
+// draw repaints the screen from the current state.
+func (t *tui) draw() {
+	width, height := terminalSize(os.Stdout)
+	t.mu.Lock()
+	lines := t.render(width, height)
+	t.mu.Unlock()
+
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	for _, l := range lines {
+		b.WriteString(truncate(l, width))
+		b.WriteString("\x1b[K\r\n")
+	}
+	b.WriteString("\x1b[J")
+	io.WriteString(t.out, b.String())
+}
+
+// render lays out the screen; t.mu must be held.
+func (t *tui) render(width, height int) []string {
+	total := t.plan.probes()
+	probed := int(t.probed.Load())
+	state := "running"
+	switch {
+	case t.done:
+		state = "finished"
+	case t.pause.isPaused():
+		state = "paused"
+	}
+	elapsed := time.Since(t.start).Round(time.Second)
+
+	lines := []string{
+		fmt.Sprintf("pscanner  %d hosts x %d ports  workers %d  [%s]", t.plan.numTargets, len(t.plan.ports), t.plan.workers, state),
+		fmt.Sprintf("Progress %s %3d%%  %d/%d probes  %s elapsed%s",
+			progressBar(probed, total, 30), percent(probed, total), probed, total, elapsed, t.eta(probed, total)),
+	}
+	rate := 0
+	if len(t.rates) > 0 {
+		rate = t.rates[len(t.rates)-1]
+	}
+	graphWidth := width - 25
+	if graphWidth > rateSamples {
+		graphWidth = rateSamples
+	}
+	lines = append(lines, fmt.Sprintf("Rate     %s %d probes/s", sparkline(t.rates, graphWidth), rate), "")
+
+	if t.detail && t.selected < len(t.hosts) {
+		host := t.hosts[t.selected]
+		lines = append(lines, "Host "+host, "", fmt.Sprintf("  %-8s %-16s %s", "PORT", "SERVICE", "STATE"))
+		for _, p := range t.open[host] {
+			lines = append(lines, fmt.Sprintf("  %-8s %-16s %s", strconv.Itoa(p)+"/tcp", serviceName(p), "open"))
+		}
+		lines = append(lines, "")
+		return append(lines, "esc back  p pause/resume  q quit")
+	}
+
+	hostWidth := 16
+	for _, h := range t.hosts {
+		if len(h)+2 > hostWidth {
+			hostWidth = len(h) + 2
+		}
+	}
+	lines = append(lines, fmt.Sprintf("  %-*s%s", hostWidth, "HOST", "OPEN PORTS"))
+	if len(t.hosts) == 0 {
+		lines = append(lines, "  (no open ports yet)")
+	}
+	// Keep the selected host visible when the list is taller than the screen.
+	rows := height - len(lines) - 2
+	first := 0
+	if rows > 0 && t.selected >= rows {
+		first = t.selected - rows + 1
+	}
+	for i := first; i < len(t.hosts) && i-first < rows; i++ {
+		h := t.hosts[i]
+		ports := make([]string, len(t.open[h]))
+		for j, p := range t.open[h] {
+			ports[j] = strconv.Itoa(p)
+		}
+		row := fmt.Sprintf("  %-*s%s", hostWidth, h, strings.Join(ports, " "))
+		if i == t.selected {
+			row = "\x1b[7m>" + row[1:] + "\x1b[0m"
+		}
+		lines = append(lines, row)
+	}
+	lines = append(lines, "")
+	help := "up/down select  enter details  p pause/resume  q stop"
+	if t.done {
+		help = "up/down select  enter details  q exit and print results"
+	}
+	return append(lines, help)
+}
+
+// eta estimates the remaining time from the recent probe rate.
+func (t *tui) eta(probed, total int) string {
+	if t.done || len(t.rates) == 0 {
+		return ""
+	}
+	recent := t.rates
+	if len(recent) > 5 {
+		recent = recent[len(recent)-5:]
+	}
+	sum := 0
+	for _, r := range recent {
+		sum += r
+	}
+	if sum == 0 {
+		return ""
+	}
+	remaining := time.Duration(float64(total-probed) / (float64(sum) / float64(len(recent))) * float64(time.Second))
+	return "  ETA " + remaining.Round(time.Second).String()
+}
+
+func percent(n, total int) int {
+	if total == 0 {
+		return 100
+	}
+	return n * 100 / total
+}
+
+// progressBar draws n out of total as a bar width cells wide.
+func progressBar(n, total, width int) string {
+	filled := width
+	if total > 0 {
+		filled = n * width / total
+	}
+	return strings.Repeat("█", filled) + strings.Repeat("░", width-filled)
+}
+
+// sparkline draws the last width values as a bar graph scaled to their
+// maximum.
+func sparkline(values []int, width int) string {
+	const bars = "▁▂▃▄▅▆▇█"
+	if width <= 0 {
+		return ""
+	}
+	if len(values) > width {
+		values = values[len(values)-width:]
+	}
+	max := 0
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+	levels := []rune(bars)
+	var b strings.Builder
+	for _, v := range values {
+		i := 0
+		if max > 0 {
+			i = v * (len(levels) - 1) / max
+		}
+		b.WriteRune(levels[i])
+	}
+	return b.String()
+}
+
+// truncate cuts s to width visible runes, ignoring escape sequences.
+func truncate(s string, width int) string {
+	var b strings.Builder
+	visible := 0
+	inEscape := false
+	for _, r := range s {
+		switch {
+		case inEscape:
+			b.WriteRune(r)
+			if r >= '@' && r <= '~' && r != '[' {
+				inEscape = false
+			}
+			continue
+		case r == '\x1b':
+			inEscape = true
+			b.WriteRune(r)
+			continue
+		}
+		if visible == width {
+			continue
+		}
+		b.WriteRune(r)
+		visible++
+	}
+	return b.String()
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseKey(t *testing.T) {
+	tests := map[string]tuiKey{
+		"q": keyQuit, "\x03": keyQuit, " ": keyPause, "p": keyPause,
+		"\x1b[A": keyUp, "\x1bOB": keyDown, "j": keyDown, "\r": keyEnter,
+		"\x1b": keyBack, "\x7f": keyBack, "x": keyNone, "\x1b[C": keyNone,
+	}
+	for in, want := range tests {
+		if got := parseKey([]byte(in)); got != want {
+			t.Errorf("parseKey(%q) = %v, want %v", in, got, want)
+		}
+	}
+}
+
+func TestProgressBar(t *testing.T) {
+	for _, tt := range []struct {
+		n, total int
+		want     string
+	}{
+		{0, 10, "░░░░░"},
+		{5, 10, "██░░░"},
+		{10, 10, "█████"},
+		{0, 0, "█████"},
+	} {
+		if got := progressBar(tt.n, tt.total, 5); got != tt.want {
+			t.Errorf("progressBar(%d, %d) = %q, want %q", tt.n, tt.total, got, tt.want)
+		}
+	}
+}
+
+func TestSparkline(t *testing.T) {
+	if got := sparkline([]int{0, 7, 14}, 10); got != "▁▄█" {
+		t.Errorf("sparkline = %q", got)
+	}
+	if got := sparkline([]int{1, 2, 3, 4}, 2); got != "▆█" {
+		t.Errorf("sparkline keeps the latest values: %q", got)
+	}
+	if got := sparkline([]int{0, 0}, 5); got != "▁▁" {
+		t.Errorf("all-zero sparkline = %q", got)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if got := truncate("\x1b[7mhello\x1b[0m", 3); got != "\x1b[7mhel\x1b[0m" {
+		t.Errorf("truncate kept %q", got)
+	}
+	if got := truncate("ab", 5); got != "ab" {
+		t.Errorf("truncate(short) = %q", got)
+	}
+}
+
+func TestTUIRender(t *testing.T) {
+	targets, _ := parseTargets("10.0.0.0/30")
+	ui := &tui{start: time.Now(), plan: &scanPlan{targets: targets, numTargets: 4, ports: []int{22, 80}, workers: 8}, open: make(map[string][]int)}
+	ui.found("10.0.0.2", PortResult{Port: 80})
+	ui.found("10.0.0.2", PortResult{Port: 22})
+	ui.found("10.0.0.1", PortResult{Port: 22})
+	ui.probed.Store(4)
+
+	screen := strings.Join(ui.render(80, 24), "\n")
+	for _, want := range []string{"4 hosts x 2 ports", " 50%", "4/8 probes", "10.0.0.2        22 80", "10.0.0.1        22", "q stop"} {
+		if !strings.Contains(screen, want) {
+			t.Errorf("list view lacks %q:\n%s", want, screen)
+		}
+	}
+
+	ui.key(keyEnter)
+	screen = strings.Join(ui.render(80, 24), "\n")
+	if !strings.Contains(screen, "Host 10.0.0.2") || !strings.Contains(screen, "22/tcp   ssh") {
+		t.Errorf("detail view:\n%s", screen)
+	}
+}
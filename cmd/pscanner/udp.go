@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UDPService is a UDP service that a --udp probe found.
+type UDPService struct {
+	Port int `json:"port"`
+	// Service is the name of the probe that found it.
+	Service string `json:"service"`
+	// Details is what the answer showed, such as an NTP server's stratum.
+	Details string `json:"details,omitempty"`
+	// Issues are the weaknesses the probe found, such as an IPMI BMC that
+	// lets sessions in without a password.
+	Issues []string `json:"issues,omitempty"`
+}
+
+func (s UDPService) String() string {
+	str := fmt.Sprintf("udp/%d %s", s.Port, s.Service)
+	if s.Details != "" {
+		str += ": " + s.Details
+	}
+	if len(s.Issues) > 0 {
+		str += " [" + strings.Join(s.Issues, "; ") + "]"
+	}
+	return str
+}
+
+// udpProbe is a --udp probe: a request to a service's well-known port and
+// a reading of the answer.
+type udpProbe struct {
+	port    int
+	request func() []byte
+	// parse returns what the answer to req shows, or false if resp is not
+	// one, or not yet the one that counts.
+	parse func(req, resp []byte) (string, bool)
+	// anyPort takes answers from any port of the host, as TFTP servers
+	// answer from a port of their own.
+	anyPort bool
+	// then is a probe made once this one is answered, whose details are
+	// added to its own.
+	then *udpProbe
+	// converse, for services that take a conversation rather than one
+	// request, replaces request and parse. It returns the details and
+	// issues of the service, or nil if nothing answered.
+	converse func(p *scanPlan, ctx context.Context, addr netip.Addr, port int) (*UDPService, error)
+}
+
+// udpProbes are the --udp probes by name. Tests point their ports at their
+// own servers.
+var udpProbes = map[string]*udpProbe{
+	"ntp":  {port: 123, request: ntpRequest, parse: parseNTP, then: &udpProbe{port: 123, request: ntpControlRequest, parse: parseNTPControl}},
+	"tftp": {port: 69, request: tftpRequest, parse: parseTFTP, anyPort: true},
+	"coap": {port: 5683, request: coapRequest, parse: parseCoAP},
+	"ipmi": {port: 623, converse: (*scanPlan).ipmiProbe},
+	"sip":  {port: 5060, request: sipUDPRequest, parse: parseSIP},
+}
+
+// udpProbeNames are the names of the --udp probes, sorted.
+func udpProbeNames() []string {
+	names := make([]string, 0, len(udpProbes))
+	for name := range udpProbes {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// parseUDPProbes reads the --udp list of probe names, where "all" stands
+// for every probe.
+func parseUDPProbes(s string) ([]string, error) {
+	return parseProbeNames(s, "UDP", udpProbeNames())
+}
+
+// parseProbeNames reads a comma-separated list of the probe names of kind
+// in known, or "all" of them, into a sorted list.
+func parseProbeNames(s, kind string, known []string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if s == "all" {
+		return known, nil
+	}
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if !slices.Contains(known, name) {
+			return nil, fmt.Errorf("unknown %s probe %q (available: %s, all)", kind, name, strings.Join(known, ", "))
+		}
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// probeUDP makes the --udp probes of every host and records the services
+// that answer, with an open UDP port. It waits up to --timeout for each
+// answer, and a port that does not answer is left out: UDP gives no way
+// to tell a filtered port from one nothing listens on.
+func (p *scanPlan) probeUDP(ctx context.Context, hosts []HostResult) {
+	if len(p.udpProbes) == 0 {
+		return
+	}
+	dns := newDNSCache(p.dnsCache)
+	sem := make(chan struct{}, quicParallel)
+	var wg sync.WaitGroup
+	for i := range hosts {
+		h := &hosts[i]
+		sem <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			addr, _, err := udpAddr(ctx, dns, h.Host, h.Family)
+			if err != nil {
+				slog.Warn("udp probe failed", "host", h.Host, "err", err)
+				return
+			}
+			found := make([]*UDPService, len(p.udpProbes))
+			var probes sync.WaitGroup
+			for j, name := range p.udpProbes {
+				probes.Go(func() {
+					pr := udpProbes[name]
+					details, ok, err := p.udpProbe(ctx, addr, pr)
+					if err != nil {
+						slog.Warn("udp probe failed", "host", h.Host, "probe", name, "err", err)
+					}
+					if !ok {
+						return
+					}
+					if pr.then != nil {
+						if more, ok, _ := p.udpProbe(ctx, addr, pr.then); ok {
+							details += "; " + more
+						}
+					}
+					found[j] = &UDPService{Port: pr.port, Service: name, Details: details}
+				})
+			}
+			probes.Wait()
+			for _, s := range found {
+				if s != nil {
+					h.UDP = append(h.UDP, *s)
+					addUDPPort(h, s.Port, s.Service)
+				}
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// udpProbe sends the request of pr to addr and returns what the answer
+// shows, if one comes before --timeout.
+func (p *scanPlan) udpProbe(ctx context.Context, addr netip.Addr, pr *udpProbe) (string, bool, error) {
+	// An unconnected socket, to hear answers from other ports.
+	var src netip.Addr
+	if p.source != nil {
+		src = p.source.from(addr)
+	}
+	network := "udp4"
+	if addr.Is6() {
+		network = "udp6"
+	}
+	conn, err := net.ListenUDP(network, net.UDPAddrFromAddrPort(netip.AddrPortFrom(src, 0)))
+	if err != nil {
+		return "", false, err
+	}
+	defer conn.Close()
+	defer context.AfterFunc(ctx, func() { conn.Close() })()
+
+	dst := netip.AddrPortFrom(addr, uint16(pr.port))
+	req := pr.request()
+	if _, err := conn.WriteToUDPAddrPort(req, dst); err != nil {
+		return "", false, err
+	}
+	buf := make([]byte, 65536)
+	deadline := time.Now().Add(p.timeout)
+	for {
+		conn.SetReadDeadline(deadline)
+		n, from, err := conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			return "", false, nil
+		}
+		if from.Addr().Unmap() != addr || !pr.anyPort && from.Port() != dst.Port() {
+			continue
+		}
+		if details, ok := pr.parse(req, buf[:n]); ok {
+			return details, true, nil
+		}
+	}
+}
+
+// ntpRequest is an NTP version 4 client request (mode 3), with a random
+// transmit timestamp that the server's answer echoes.
+func ntpRequest() []byte {
+	req := make([]byte, 48)
+	req[0] = 4<<3 | 3
+	rand.Read(req[40:])
+	return req
+}
+
+// parseNTP reads a server's answer (mode 4) to an NTP request.
+func parseNTP(req, resp []byte) (string, bool) {
+	if len(resp) < 48 || resp[0]&7 != 4 || !bytes.Equal(resp[24:32], req[40:48]) {
+		return "", false
+	}
+	s := fmt.Sprintf("NTPv%d", resp[0]>>3&7)
+	ref := resp[12:16]
+	switch stratum := resp[1]; {
+	case stratum == 0:
+		// A kiss-o'-death, such as RATE or DENY.
+		s += fmt.Sprintf(", kiss code %s", bytes.TrimRight(ref, "\x00"))
+	case stratum == 1:
+		// A reference clock, such as GPS or PPS.
+		s += fmt.Sprintf(" stratum 1, reference %s", bytes.TrimRight(ref, "\x00"))
+	case stratum >= 16:
+		s += " unsynchronized"
+	default:
+		s += fmt.Sprintf(" stratum %d, reference %s", stratum, netip.AddrFrom4([4]byte(ref)))
+	}
+	return s, true
+}
+
+// ntpControlRequest is an NTP control message (mode 6) asking for the
+// server's system variables, as "ntpq -c rv" does. Servers should not
+// answer it from the internet.
+func ntpControlRequest() []byte {
+	return []byte{2<<3 | 6, 2, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0} // read variables, sequence 1
+}
+
+// parseNTPControl reads the answer to an NTP control message, with the
+// server's version if it says.
+func parseNTPControl(req, resp []byte) (string, bool) {
+	if len(resp) < 12 || resp[0]&7 != 6 || resp[1]&0x80 == 0 || resp[1]&0x1f != 2 || !bytes.Equal(resp[2:4], req[2:4]) {
+		return "", false
+	}
+	s := "answers control queries (mode 6)"
+	data := resp[12:]
+	if n := int(binary.BigEndian.Uint16(resp[10:])); n < len(data) {
+		data = data[:n]
+	}
+	if _, v, ok := strings.Cut(string(data), `version="`); ok {
+		if v, _, ok := strings.Cut(v, `"`); ok {
+			s += fmt.Sprintf(", version %q", v)
+		}
+	}
+	return s, true
+}
+
+// tftpRequest is a TFTP read request for a file no server has.
+func tftpRequest() []byte {
+	name := make([]byte, 8)
+	rand.Read(name)
+	req := []byte{0, 1}
+	req = append(req, "pscanner-"+hex.EncodeToString(name)...)
+	return append(req, "\x00octet\x00"...)
+}
+
+// parseTFTP reads the answer to a TFTP read request: mostly an error,
+// whose message tells something of the server.
+func parseTFTP(req, resp []byte) (string, bool) {
+	if len(resp) < 4 || resp[0] != 0 {
+		return "", false
+	}
+	switch resp[1] {
+	case 3: // DATA
+		return "read requests allowed", true
+	case 5: // ERROR
+		msg, _, _ := bytes.Cut(resp[4:], []byte{0})
+		return fmt.Sprintf("error %d %q", binary.BigEndian.Uint16(resp[2:]), msg), true
+	case 6: // OACK, options acknowledged
+		return "read requests allowed", true
+	}
+	return "", false
+}
+
+// coapRequest is a confirmable CoAP GET of /.well-known/core, the list of
+// a device's resources (RFC 6690).
+func coapRequest() []byte {
+	req := []byte{1<<6 | 0<<4 | 4, 0x01} // version 1, confirmable, 4-byte token; GET
+	id := make([]byte, 6)
+	rand.Read(id)
+	req = append(req, id...) // message ID and token
+	req = append(req, 0xbb)  // Uri-Path (option 11) of 11 bytes
+	req = append(req, ".well-known"...)
+	req = append(req, 0x04) // Uri-Path again, of 4 bytes
+	return append(req, "core"...)
+}
+
+// parseCoAP reads the answer to a CoAP request: its response code and,
+// for a resource list, the resources. An empty acknowledgement, which
+// says the answer follows separately, is not the answer. The separate
+// answer goes unacknowledged, so the device sends it a few times.
+func parseCoAP(req, resp []byte) (string, bool) {
+	if len(resp) < 4 || resp[0]>>6 != 1 || resp[1] == 0 {
+		return "", false
+	}
+	tkl := int(resp[0] & 0xf)
+	if tkl != 4 || len(resp) < 4+tkl || !bytes.Equal(resp[4:8], req[4:8]) {
+		return "", false
+	}
+	code := resp[1]
+	s := fmt.Sprintf("%d.%02d", code>>5, code&0x1f)
+	_, payload, _ := bytes.Cut(skipCoAPOptions(resp[4+tkl:]), []byte{0xff})
+	if code == 2<<5|5 && len(payload) > 0 {
+		var resources []string
+		for _, link := range strings.Split(string(payload), ",") {
+			if target, _, ok := strings.Cut(link, ">"); ok {
+				resources = append(resources, target+">")
+			}
+		}
+		if len(resources) > 10 {
+			resources = append(resources[:10], fmt.Sprintf("and %d more", len(resources)-10))
+		}
+		s += " resources " + strings.Join(resources, ", ")
+	}
+	return s, true
+}
+
+// skipCoAPOptions returns b from the payload marker on, past the options.
+func skipCoAPOptions(b []byte) []byte {
+	for len(b) > 0 && b[0] != 0xff {
+		delta, length := int(b[0]>>4), int(b[0]&0xf)
+		b = b[1:]
+		for _, v := range []*int{&delta, &length} {
+			switch *v {
+			case 13:
+				if len(b) < 1 {
+					return nil
+				}
+				*v = 13 + int(b[0])
+				b = b[1:]
+			case 14:
+				if len(b) < 2 {
+					return nil
+				}
+				*v = 269 + int(binary.BigEndian.Uint16(b))
+				b = b[2:]
+			case 15:
+				return nil
+			}
+		}
+		if len(b) < length {
+			return nil
+		}
+		b = b[length:]
+	}
+	return b
+}
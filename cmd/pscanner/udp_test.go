@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// serveUDP answers the datagrams of a local UDP socket with those answer
+// returns, sent from the socket itself or, for TFTP, from another.
+func serveUDP(t *testing.T, answer func(req []byte) [][]byte, otherPort bool) int {
+	pc := listenUDP(t)
+	reply := pc
+	if otherPort {
+		reply = listenUDP(t)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 2048)
+		for {
+			n, from, err := pc.ReadFromUDPAddrPort(buf)
+			if err != nil {
+				return
+			}
+			for _, resp := range answer(buf[:n]) {
+				reply.WriteToUDPAddrPort(resp, from)
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		pc.Close()
+		reply.Close()
+		<-done
+	})
+	return pc.LocalAddr().(*net.UDPAddr).Port
+}
+
+func ntpServer(req []byte) [][]byte {
+	if req[0]&7 == 6 {
+		data := `version="ntpd 4.2.8p15@1.3728-o", processor="x86_64", stratum=2`
+		resp := []byte{2<<3 | 6, 0x82, req[2], req[3], 0, 0, 0, 0, 0, 0}
+		resp = binary.BigEndian.AppendUint16(resp, uint16(len(data)))
+		return [][]byte{append(resp, data...)}
+	}
+	resp := make([]byte, 48)
+	resp[0], resp[1] = 4<<3|4, 2
+	copy(resp[12:], []byte{192, 0, 2, 1})
+	copy(resp[24:], req[40:48])
+	// Someone else's answer first, which does not count.
+	other := append([]byte(nil), resp...)
+	other[24] ^= 0xff
+	return [][]byte{other, resp}
+}
+
+func tftpServer(req []byte) [][]byte {
+	return [][]byte{append([]byte{0, 5, 0, 1}, "File not found\x00"...)}
+}
+
+func coapServer(req []byte) [][]byte {
+	// An empty ACK, then the answer on its own, with the request's token.
+	ack := []byte{1<<6 | 2<<4, 0, req[2], req[3]}
+	resp := []byte{1<<6 | 0<<4 | 4, 2<<5 | 5, 0x12, 0x34}
+	resp = append(resp, req[4:8]...)
+	resp = append(resp, 0xc1, 40) // Content-Format (12): application/link-format
+	resp = append(resp, 0xff)
+	resp = append(resp, `</sensors/temp>;rt="temperature";if="sensor",</light>;rt="light-lux"`...)
+	return [][]byte{ack, resp}
+}
+
+func TestProbeUDP(t *testing.T) {
+	old := udpProbes
+	defer func() { udpProbes = old }()
+	ntp := serveUDP(t, ntpServer, false)
+	tftp := serveUDP(t, tftpServer, true)
+	coap := serveUDP(t, coapServer, false)
+	udpProbes = map[string]*udpProbe{
+		"ntp":  {port: ntp, request: ntpRequest, parse: parseNTP, then: &udpProbe{port: ntp, request: ntpControlRequest, parse: parseNTPControl}},
+		"tftp": {port: tftp, request: tftpRequest, parse: parseTFTP, anyPort: true},
+		"coap": {port: coap, request: coapRequest, parse: parseCoAP},
+	}
+
+	p := &scanPlan{udpProbes: []string{"coap", "ntp", "tftp"}, timeout: time.Second}
+	hosts := []HostResult{{Host: "127.0.0.1", Ports: []PortResult{}}}
+	p.probeUDP(context.Background(), hosts)
+	want := []UDPService{
+		{Port: coap, Service: "coap", Details: "2.05 resources </sensors/temp>, </light>"},
+		{Port: ntp, Service: "ntp", Details: `NTPv4 stratum 2, reference 192.0.2.1; answers control queries (mode 6), version "ntpd 4.2.8p15@1.3728-o"`},
+		{Port: tftp, Service: "tftp", Details: `error 1 "File not found"`},
+	}
+	if !reflect.DeepEqual(hosts[0].UDP, want) {
+		t.Errorf("UDP = %+v\nwant %+v", hosts[0].UDP, want)
+	}
+	wantPorts := []PortResult{
+		{Port: coap, Protocol: "udp", State: "open", Service: "coap"},
+		{Port: ntp, Protocol: "udp", State: "open", Service: "ntp"},
+		{Port: tftp, Protocol: "udp", State: "open", Service: "tftp"},
+	}
+	if !reflect.DeepEqual(hosts[0].Ports, wantPorts) {
+		t.Errorf("ports = %+v, want %+v", hosts[0].Ports, wantPorts)
+	}
+}
+
+// A TFTP answer from another port is only taken by probes that expect
+// one.
+func TestUDPProbeOtherPort(t *testing.T) {
+	port := serveUDP(t, tftpServer, true)
+	p := &scanPlan{timeout: 200 * time.Millisecond}
+	pr := &udpProbe{port: port, request: tftpRequest, parse: parseTFTP}
+	if _, ok, err := p.udpProbe(context.Background(), netip.MustParseAddr("127.0.0.1"), pr); ok || err != nil {
+		t.Errorf("answer from another port taken: %v, %v", ok, err)
+	}
+}
+
+func TestParseNTP(t *testing.T) {
+	req := ntpRequest()
+	resp := make([]byte, 48)
+	copy(resp[24:], req[40:])
+	for _, tt := range []struct {
+		first, stratum byte
+		ref            string
+		want           string
+	}{
+		{4<<3 | 4, 1, "GPS\x00", "NTPv4 stratum 1, reference GPS"},
+		{3<<3 | 4, 0, "RATE", "NTPv3, kiss code RATE"},
+		{4<<3 | 4, 16, "\x00\x00\x00\x00", "NTPv4 unsynchronized"},
+	} {
+		resp[0], resp[1] = tt.first, tt.stratum
+		copy(resp[12:], tt.ref)
+		if got, ok := parseNTP(req, resp); !ok || got != tt.want {
+			t.Errorf("parseNTP = %q, %v; want %q", got, ok, tt.want)
+		}
+	}
+	resp[0] = 4<<3 | 3 // a client's request, not an answer
+	if _, ok := parseNTP(req, resp); ok {
+		t.Error("a request taken for an answer")
+	}
+}
+
+func TestParseUDPProbes(t *testing.T) {
+	for in, want := range map[string][]string{
+		"":               nil,
+		"all":            {"coap", "ipmi", "ntp", "sip", "tftp"},
+		"tftp, ntp,tftp": {"ntp", "tftp"},
+	} {
+		if got, err := parseUDPProbes(in); err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("parseUDPProbes(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	if _, err := parseUDPProbes("ntp,snmp"); err == nil {
+		t.Error("unknown probe accepted")
+	}
+}
+
+func TestSkipCoAPOptions(t *testing.T) {
+	// Option deltas and lengths of 13 and 14 take extra bytes.
+	b := []byte{0xd1, 0x02, 'x', 0x1e, 0x00, 0x01}
+	b = append(b, make([]byte, 270)...)
+	b = append(b, 0xff, 'p')
+	if got := skipCoAPOptions(b); string(got) != "\xffp" {
+		t.Errorf("skipCoAPOptions = %q", got)
+	}
+	if got := skipCoAPOptions([]byte{0x05, 'a'}); got != nil {
+		t.Errorf("short option = %q, want nil", got)
+	}
+}
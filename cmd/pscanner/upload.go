@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Upload is an object storage location that every finished scan is written
+// to, for scans running in containers whose disk does not outlive them.
+type Upload struct {
+	// URL is s3://bucket/prefix/ or gs://bucket/prefix/.
+	URL string `json:"url"`
+	// Formats are the output formats to write, json by default.
+	Formats []string `json:"formats,omitempty"`
+}
+
+// uploadExts are the object name extensions of the output formats.
+var uploadExts = map[string]string{
+	"text":   ".txt",
+	"json":   ".json",
+	"syslog": ".log",
+	"html":   ".html",
+}
+
+// objectStore writes whole objects.
+type objectStore interface {
+	put(key, contentType string, body []byte) error
+}
+
+type uploader struct {
+	prefix  string
+	formats []string
+	store   objectStore
+}
+
+// newUploader checks the URL and formats. Credentials are looked up on the
+// first upload so that a missing role does not stop the server from
+// starting.
+func newUploader(u Upload) (*uploader, error) {
+	dest, err := url.Parse(u.URL)
+	if err != nil {
+		return nil, fmt.Errorf("upload %q: %v", u.URL, err)
+	}
+	if dest.Host == "" {
+		return nil, fmt.Errorf("upload %q: no bucket", u.URL)
+	}
+	formats := u.Formats
+	if len(formats) == 0 {
+		formats = []string{"json"}
+	}
+	for _, f := range formats {
+		if _, ok := uploadExts[f]; !ok {
+			return nil, fmt.Errorf("upload %q: unknown format %q (want %s)", u.URL, f, strings.Join(outputFormats, ", "))
+		}
+	}
+	up := &uploader{prefix: strings.TrimPrefix(dest.Path, "/"), formats: formats}
+	q := dest.Query()
+	switch dest.Scheme {
+	case "s3":
+		up.store = newS3Store(dest.Host, q.Get("region"), q.Get("endpoint"))
+	case "gs":
+		up.store = newGCSStore(dest.Host, q.Get("endpoint"))
+	default:
+		return nil, fmt.Errorf("upload %q: unsupported scheme (want s3:// or gs://)", u.URL)
+	}
+	return up, nil
+}
+
+// key names the object for a report: the prefix, the schedule if any, and
+// the start time in UTC, so that listing a prefix sorts the runs in order.
+func (up *uploader) key(r *Report, format string) string {
+	name := r.StartedAt.UTC().Format("2006-01-02T15-04-05Z")
+	if r.ID != "" {
+		name += "-" + r.ID
+	}
+	name += uploadExts[format]
+	if r.Schedule != "" {
+		name = r.Schedule + "/" + name
+	}
+	return up.prefix + name
+}
+
+// notify uploads run in every format. Failures are logged and do not stop
+// the scans.
+func (up *uploader) notify(run scanRun) {
+	for _, f := range up.formats {
+		var b bytes.Buffer
+		if err := writeReport(&b, f, run.Report); err != nil {
+			slog.Error("upload failed", "format", f, "err", err)
+			continue
+		}
+		ct := "text/plain; charset=utf-8"
+		switch f {
+		case "json":
+			ct = "application/json"
+		case "html":
+			ct = "text/html; charset=utf-8"
+		}
+		key := up.key(run.Report, f)
+		if err := up.store.put(key, ct, b.Bytes()); err != nil {
+			slog.Error("upload failed", "key", key, "err", err)
+		}
+	}
+}
+
+// awsCredentials are static keys or the temporary ones of a role.
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	Token           string
+	Expiration      time.Time
+}
+
+// s3Store puts objects with SigV4-signed requests. Without an endpoint it
+// talks to AWS with virtual-hosted URLs; with one (MinIO, R2, Ceph) it uses
+// path-style URLs, which those accept everywhere.
+type s3Store struct {
+	bucket   string
+	region   string
+	endpoint string
+	client   *http.Client
+	attempts int
+	backoff  time.Duration
+	now      func() time.Time
+
+	mu    sync.Mutex
+	creds *awsCredentials
+}
+
+func newS3Store(bucket, region, endpoint string) *s3Store {
+	if region == "" {
+		region = cmp.Or(os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1")
+	}
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL_S3")
+	}
+	return &s3Store{
+		bucket:   bucket,
+		region:   region,
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		client:   &http.Client{Timeout: time.Minute},
+		attempts: 4,
+		backoff:  time.Second,
+		now:      time.Now,
+	}
+}
+
+func (s *s3Store) objectURL(key string) string {
+	escaped := (&url.URL{Path: "/" + key}).EscapedPath()
+	if s.endpoint != "" {
+		return s.endpoint + "/" + s.bucket + escaped
+	}
+	return "https://" + s.bucket + ".s3." + s.region + ".amazonaws.com" + escaped
+}
+
+func (s *s3Store) put(key, contentType string, body []byte) error {
+	creds, err := s.credentials()
+	if err != nil {
+		return err
+	}
+	_, err = retryRequest(s.client, s.attempts, s.backoff, func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPut, s.objectURL(key), bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", contentType)
+		sum := sha256.Sum256(body)
+		req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+		signV4(req, sum[:], creds, s.region, "s3", s.now())
+		return req, nil
+	})
+	return err
+}
+
+// credentials returns keys from the environment or, in ECS and EKS
+// containers, from the container credentials endpoint. Temporary keys are
+// cached until shortly before they expire.
+func (s *s3Store) credentials() (*awsCredentials, error) {
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		return &awsCredentials{AccessKeyID: id, SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"), Token: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.creds != nil && s.now().Before(s.creds.Expiration.Add(-5*time.Minute)) {
+		return s.creds, nil
+	}
+	endpoint := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if rel := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); endpoint == "" && rel != "" {
+		endpoint = "http://169.254.170.2" + rel
+	}
+	if endpoint == "" {
+		return nil, errors.New("no AWS credentials (set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, or run with a container role)")
+	}
+	body, err := retryRequest(s.client, s.attempts, s.backoff, func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+		if file := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); file != "" {
+			b, err := os.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			token = strings.TrimSpace(string(b))
+		}
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		return req, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fetching container credentials: %v", err)
+	}
+	var creds awsCredentials
+	if err := json.Unmarshal(body, &creds); err != nil || creds.AccessKeyID == "" {
+		return nil, errors.New("unexpected container credentials response")
+	}
+	s.creds = &creds
+	return s.creds, nil
+}
+
+// signV4 adds an AWS Signature Version 4 Authorization header to req,
+// signing the host and every header already set. payloadHash is the
+// SHA-256 of the body.
+func signV4(req *http.Request, payloadHash []byte, creds *awsCredentials, region, service string, now time.Time) {
+	stamp := now.UTC().Format("20060102T150405Z")
+	day := stamp[:8]
+	req.Header.Set("X-Amz-Date", stamp)
+	if creds.Token != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.Token)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.Join(v, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + strings.TrimSpace(headers[k]) + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signed,
+		hex.EncodeToString(payloadHash),
+	}, "\n")
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{day, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signed, hex.EncodeToString(hmacSHA256(key, toSign))))
+}
+
+func canonicalQuery(q url.Values) string {
+	var parts []string
+	for _, k := range slices.Sorted(maps.Keys(q)) {
+		vs := slices.Clone(q[k])
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, awsEscape(k)+"="+awsEscape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// awsEscape percent-encodes everything but the unreserved characters, as
+// SigV4 requires; url.QueryEscape would turn spaces into +.
+func awsEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(s))
+	return mac.Sum(nil)
+}
+
+// gcsStore puts objects with the Cloud Storage JSON API, authenticated with
+// $GOOGLE_OAUTH_ACCESS_TOKEN or the service account of the instance, as
+// on GKE and Cloud Run.
+type gcsStore struct {
+	bucket   string
+	endpoint string
+	metadata string
+	client   *http.Client
+	attempts int
+	backoff  time.Duration
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func newGCSStore(bucket, endpoint string) *gcsStore {
+	if endpoint == "" {
+		endpoint = "https://storage.googleapis.com"
+	}
+	return &gcsStore{
+		bucket:   bucket,
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		metadata: "http://metadata.google.internal",
+		client:   &http.Client{Timeout: time.Minute},
+		attempts: 4,
+		backoff:  time.Second,
+	}
+}
+
+func (g *gcsStore) put(key, contentType string, body []byte) error {
+	token, err := g.accessToken()
+	if err != nil {
+		return err
+	}
+	u := g.endpoint + "/upload/storage/v1/b/" + url.PathEscape(g.bucket) + "/o?uploadType=media&name=" + url.QueryEscape(key)
+	_, err = retryRequest(g.client, g.attempts, g.backoff, func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return req, nil
+	})
+	return err
+}
+
+func (g *gcsStore) accessToken() (string, error) {
+	if t := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); t != "" {
+		return t, nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.token != "" && time.Now().Before(g.expires) {
+		return g.token, nil
+	}
+	body, err := retryRequest(g.client, g.attempts, g.backoff, func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodGet, g.metadata+"/computeMetadata/v1/instance/service-accounts/default/token", nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Metadata-Flavor", "Google")
+		return req, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("no Google credentials (set GOOGLE_OAUTH_ACCESS_TOKEN or run with a service account): %v", err)
+	}
+	var resp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil || resp.AccessToken == "" {
+		return "", errors.New("unexpected metadata server token response")
+	}
+	g.token = resp.AccessToken
+	g.expires = time.Now().Add(time.Duration(resp.ExpiresIn)*time.Second - time.Minute)
+	return g.token, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSignV4 checks the signer against the get-vanilla case of the AWS
+// Signature Version 4 test suite.
+func TestSignV4(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	empty := sha256.Sum256(nil)
+	creds := &awsCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	signV4(req, empty[:], creds, "us-east-1", "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization = %s\nwant %s", got, want)
+	}
+}
+
+func TestUploadKey(t *testing.T) {
+	up, err := newUploader(Upload{URL: "s3://results/pscanner/", Formats: []string{"json", "text"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := testReport("22")
+	r.StartedAt = time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	if got := up.key(r, "json"); got != "pscanner/2026-10-14T12-00-00Z.json" {
+		t.Errorf("key = %q", got)
+	}
+	r.ID, r.Schedule = "a1b2", "nightly"
+	if got := up.key(r, "text"); got != "pscanner/nightly/2026-10-14T12-00-00Z-a1b2.txt" {
+		t.Errorf("scheduled key = %q", got)
+	}
+
+	for _, u := range []Upload{{URL: "s3:///prefix"}, {URL: "ftp://host/x"}, {URL: "gs://b/", Formats: []string{"pdf"}}} {
+		if _, err := newUploader(u); err == nil {
+			t.Errorf("newUploader(%v) succeeded", u)
+		}
+	}
+}
+
+func TestUploadS3(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	var path, auth, body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		path, auth, body = r.Method+" "+r.URL.Path, r.Header.Get("Authorization"), string(b)
+	}))
+	defer srv.Close()
+
+	up, err := newUploader(Upload{URL: "s3://results/scans/?region=eu-west-1&endpoint=" + srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := testReport("22", hostWith("10.0.0.5", 22))
+	up.notify(scanRun{Report: r})
+	if want := "PUT /results/scans/" + r.StartedAt.UTC().Format("2006-01-02T15-04-05Z") + ".json"; path != want {
+		t.Errorf("request %q, want %q", path, want)
+	}
+	if !strings.Contains(auth, "/eu-west-1/s3/aws4_request") || !strings.Contains(auth, "x-amz-content-sha256") {
+		t.Errorf("Authorization = %q", auth)
+	}
+	if !strings.Contains(body, `"host": "10.0.0.5"`) {
+		t.Errorf("uploaded %q", body)
+	}
+}
+
+func TestUploadGCS(t *testing.T) {
+	t.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "")
+	var got *http.Request
+	mux := http.NewServeMux()
+	mux.HandleFunc("/computeMetadata/v1/instance/service-accounts/default/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			http.Error(w, "missing header", http.StatusForbidden)
+			return
+		}
+		io.WriteString(w, `{"access_token":"ya29.test","expires_in":3599,"token_type":"Bearer"}`)
+	})
+	mux.HandleFunc("/upload/storage/v1/b/results/o", func(w http.ResponseWriter, r *http.Request) {
+		got = r
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	up, err := newUploader(Upload{URL: "gs://results/scans/?endpoint=" + srv.URL, Formats: []string{"text"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	up.store.(*gcsStore).metadata = srv.URL
+	r := testReport("22", hostWith("10.0.0.5", 22))
+	up.notify(scanRun{Report: r})
+	if got == nil {
+		t.Fatal("nothing uploaded")
+	}
+	if name := got.URL.Query().Get("name"); !strings.HasPrefix(name, "scans/") || !strings.HasSuffix(name, ".txt") {
+		t.Errorf("object name %q", name)
+	}
+	if a := got.Header.Get("Authorization"); a != "Bearer ya29.test" {
+		t.Errorf("Authorization = %q", a)
+	}
+}
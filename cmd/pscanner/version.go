@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build metadata, injected at build time:
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse --short HEAD) -X main.date=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./cmd/pscanner
+//
+// When they are left unset, the values recorded by the Go toolchain (module
+// version for "go install", VCS revision for builds in a checkout) are used.
+var (
+	version = ""
+	commit  = ""
+	date    = ""
+)
+
+// buildInfo describes the running binary.
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Date      string `json:"date"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+}
+
+func currentBuild() buildInfo {
+	b := buildInfo{
+		Version:   version,
+		Commit:    commit,
+		Date:      date,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if b.Version == "" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			b.Version = info.Main.Version
+		}
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if b.Commit == "" {
+					b.Commit = s.Value
+					if len(b.Commit) > 12 {
+						b.Commit = b.Commit[:12]
+					}
+				}
+			case "vcs.time":
+				if b.Date == "" {
+					b.Date = s.Value
+				}
+			case "vcs.modified":
+				if s.Value == "true" && commit == "" && b.Commit != "" {
+					b.Commit += "-dirty"
+				}
+			}
+		}
+	}
+	if b.Version == "" {
+		b.Version = "dev"
+	}
+	if b.Commit == "" {
+		b.Commit = "unknown"
+	}
+	if b.Date == "" {
+		b.Date = "unknown"
+	}
+	return b
+}
+
+func (b buildInfo) String() string {
+	return fmt.Sprintf("pscanner %s\n  commit: %s\n  built:  %s\n  go:     %s %s", b.Version, b.Commit, b.Date, b.GoVersion, b.Platform)
+}
+
+// versionDoc is the long-form documentation of "pscanner version".
+var versionDoc = &commandDoc{
+	synopsis: "pscanner version",
+	description: `Print version and build information.
+
+Release builds carry the version, commit and build date set at link time.
+Other builds report the module version and VCS revision recorded by the Go
+toolchain, if any.`,
+}
+
+// runVersion implements "pscanner version" and "pscanner --version".
+func runVersion(args []string) {
+	fmt.Println(currentBuild())
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCurrentBuild(t *testing.T) {
+	b := currentBuild()
+	if b.Version == "" || b.Commit == "" || b.Date == "" || !strings.HasPrefix(b.GoVersion, "go") || !strings.Contains(b.Platform, "/") {
+		t.Errorf("currentBuild() = %+v, want every field set", b)
+	}
+
+	// Values injected with -ldflags win over what the toolchain recorded.
+	defer func(v, c, d string) { version, commit, date = v, c, d }(version, commit, date)
+	version, commit, date = "v1.2.3", "abc1234", "2026-01-02T03:04:05Z"
+	b = currentBuild()
+	if b.Version != "v1.2.3" || b.Commit != "abc1234" || b.Date != "2026-01-02T03:04:05Z" {
+		t.Errorf("currentBuild() with ldflags = %+v", b)
+	}
+	if s := b.String(); !strings.HasPrefix(s, "pscanner v1.2.3\n") || !strings.Contains(s, "abc1234") {
+		t.Errorf("String() = %q", s)
+	}
+}
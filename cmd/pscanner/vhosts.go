@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// maxVHosts bounds the names of a --vhosts file.
+const maxVHosts = 5000
+
+// VHost is a name that an open web port answers differently from its
+// default virtual host, for --vhosts.
+type VHost struct {
+	Port   int    `json:"port"`
+	Name   string `json:"name"`
+	TLS    bool   `json:"tls,omitempty"`
+	Status int    `json:"status"`
+	Title  string `json:"title,omitempty"`
+	// Location is where a redirect points.
+	Location string `json:"location,omitempty"`
+	Length   int    `json:"length"`
+}
+
+func (v VHost) String() string {
+	s := fmt.Sprintf("tcp/%d %s: %d", v.Port, v.Name, v.Status)
+	if v.TLS {
+		s = fmt.Sprintf("tcp/%d %s over TLS: %d", v.Port, v.Name, v.Status)
+	}
+	if v.Location != "" {
+		s += " to " + v.Location
+	}
+	if v.Title != "" {
+		s += fmt.Sprintf(", title %q", truncate(v.Title, 60))
+	}
+	return s + fmt.Sprintf(", %d bytes", v.Length)
+}
+
+// loadVHosts reads a --vhosts file: one name per line, with blank lines
+// and "#" comments ignored.
+func loadVHosts(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var names []string
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		text, _, _ := strings.Cut(sc.Text(), "#")
+		name := strings.ToLower(strings.TrimSuffix(strings.TrimSpace(text), "."))
+		switch {
+		case name == "":
+			continue
+		case strings.ContainsAny(name, " \t/:@") || strings.Contains(name, "*"):
+			return nil, fmt.Errorf("%s:%d: %q is not a hostname", path, line, name)
+		case slices.Contains(names, name):
+			continue
+		case len(names) == maxVHosts:
+			return nil, fmt.Errorf("%s: more than %d names", path, maxVHosts)
+		}
+		names = append(names, name)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%s: no names", path)
+	}
+	return names, nil
+}
+
+// probeVHosts asks the open ports of @web for each --vhosts name, and
+// lists the names answered differently from both the host's own address
+// and a name no server knows, which get the default virtual host.
+func (p *scanPlan) probeVHosts(ctx context.Context, hosts []HostResult) {
+	if len(p.vhosts) == 0 {
+		return
+	}
+	ports, _ := parsePorts(builtinGroups["web"], nil)
+	dns := newDNSCache(p.dnsCache)
+	sem := make(chan struct{}, quicParallel)
+	var wg sync.WaitGroup
+	for i := range hosts {
+		h := &hosts[i]
+		sem <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			for _, pr := range h.Ports {
+				if pr.Protocol == "udp" || !slices.Contains(ports, pr.Port) {
+					continue
+				}
+				found, err := p.vhostProbe(ctx, dns, h, pr.Port)
+				if err != nil {
+					slog.Warn("vhost probe failed", "host", h.Host, "port", pr.Port, "err", err)
+				}
+				h.VHosts = append(h.VHosts, found...)
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// vhostAnswer is what tells the answers to different Host headers apart.
+// The keys and length leave out the name asked for.
+type vhostAnswer struct {
+	status                int
+	location, title       string
+	locationKey, titleKey string
+	length                int
+}
+
+// differs reports whether a and b look like different sites. Lengths
+// within a tenth of each other count as the same, for pages that vary
+// from one request to the next.
+func (a vhostAnswer) differs(b vhostAnswer) bool {
+	if a.status != b.status || a.locationKey != b.locationKey || a.titleKey != b.titleKey {
+		return true
+	}
+	return max(a.length, b.length)-min(a.length, b.length) > max(a.length, b.length)/10+32
+}
+
+var titleRE = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+// vhostProbe asks port of h for each name, over TLS first on ports of 443
+// and x443, and the other way if the server speaks it instead.
+func (p *scanPlan) vhostProbe(ctx context.Context, dns *dnsCache, h *HostResult, port int) ([]VHost, error) {
+	client := &http.Client{
+		Timeout: httpRequestTimeout,
+		Transport: &http.Transport{
+			// Every name is asked of this host, whatever it resolves to.
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return p.probe(ctx, dns, job{host: h.Host, port: port, family: h.Family})
+			},
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			DisableKeepAlives: true,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	var b [8]byte
+	rand.Read(b[:])
+	unknown := "pscanner-" + hex.EncodeToString(b[:]) + ".invalid"
+
+	useTLS := port%1000 == 443
+	var c *httpEndpoint
+	var own vhostAnswer
+	var err error
+	for _, tryTLS := range []bool{useTLS, !useTLS} {
+		c = &httpEndpoint{client: client}
+		if own, err = c.vhostAsk(ctx, tryTLS, h.Host, port); !c.wrongScheme {
+			useTLS = tryTLS
+			break
+		}
+	}
+	if err != nil {
+		return nil, ignoreHTTPGone(err)
+	}
+	dflt, err := c.vhostAsk(ctx, useTLS, unknown, port)
+	if err != nil {
+		return nil, ignoreHTTPGone(err)
+	}
+	var found []VHost
+	for _, name := range p.vhosts {
+		if ctx.Err() != nil {
+			break
+		}
+		a, err := c.vhostAsk(ctx, useTLS, name, port)
+		if err != nil || !a.differs(own) || !a.differs(dflt) {
+			continue
+		}
+		found = append(found, VHost{Port: port, Name: name, TLS: useTLS, Status: a.status, Title: a.title, Location: a.location, Length: a.length})
+	}
+	return found, nil
+}
+
+// vhostAsk requests / of port with name as the Host header, and over TLS
+// as the server name.
+func (c *httpEndpoint) vhostAsk(ctx context.Context, useTLS bool, name string, port int) (vhostAnswer, error) {
+	scheme := "http"
+	if useTLS {
+		scheme = "https"
+	}
+	host := name
+	if a, err := netip.ParseAddr(name); err == nil && a.Is6() {
+		name = a.WithZone("").String()
+		host = "[" + name + "]"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s://%s:%d/", scheme, host, port), nil)
+	if err != nil {
+		return vhostAnswer{}, err
+	}
+	req.Host = host
+	resp, body, err := c.roundTrip(req)
+	if err != nil {
+		return vhostAnswer{}, err
+	}
+	a := vhostAnswer{status: resp.StatusCode, location: resp.Header.Get("Location")}
+	if m := titleRE.FindSubmatch(body); m != nil {
+		a.title = strings.Join(strings.Fields(html.UnescapeString(string(m[1]))), " ")
+	}
+	a.locationKey = strings.ReplaceAll(a.location, name, "")
+	a.titleKey = strings.ReplaceAll(a.title, name, "")
+	a.length = len(body) - bytes.Count(body, []byte(name))*len(name)
+	return a, nil
+}
+
+// ignoreHTTPGone drops the errors of a port that does not answer HTTP in
+// time, or hangs up, which only say it serves something else.
+func ignoreHTTPGone(err error) error {
+	if isTimeout(err) || errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadVHosts(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		path := filepath.Join(dir, "vhosts.txt")
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	names, err := loadVHosts(write("# staging\nIntranet.corp.\n\n  git.corp  # forge\nintranet.corp\n"))
+	if want := []string{"intranet.corp", "git.corp"}; err != nil || !slices.Equal(names, want) {
+		t.Errorf("loadVHosts = %q, %v; want %q", names, err, want)
+	}
+	for _, bad := range []string{"", "# nothing\n", "http://intranet.corp\n", "*.corp\n"} {
+		if _, err := loadVHosts(write(bad)); err == nil {
+			t.Errorf("loadVHosts accepted %q", bad)
+		}
+	}
+}
+
+// vhostSite serves a default page that echoes the Host header, like many
+// default sites, and pages of their own for intranet.corp and git.corp.
+func vhostSite(w http.ResponseWriter, r *http.Request) {
+	switch r.Host {
+	case "intranet.corp":
+		fmt.Fprint(w, "<html><title>Intranet</title>welcome</html>")
+	case "git.corp":
+		http.Redirect(w, r, "/user/login", http.StatusFound)
+	default:
+		fmt.Fprintf(w, "<html><title>It works</title>%s is not configured %s</html>", r.Host, strings.Repeat(".", 200))
+	}
+}
+
+func TestVHostProbe(t *testing.T) {
+	plain := httptest.NewServer(http.HandlerFunc(vhostSite))
+	defer plain.Close()
+	secure := httptest.NewTLSServer(http.HandlerFunc(vhostSite))
+	defer secure.Close()
+	p := &scanPlan{timeout: time.Second, vhosts: []string{"www.corp", "intranet.corp", "git.corp"}}
+	h := &HostResult{Host: "127.0.0.1"}
+	for _, tt := range []struct {
+		name   string
+		port   int
+		useTLS bool
+	}{
+		{"HTTP", serverPort(t, plain), false},
+		{"HTTPS", serverPort(t, secure), true},
+	} {
+		got, err := p.vhostProbe(context.Background(), nil, h, tt.port)
+		want := []VHost{
+			{Port: tt.port, Name: "intranet.corp", TLS: tt.useTLS, Status: 200, Title: "Intranet", Length: 43},
+			{Port: tt.port, Name: "git.corp", TLS: tt.useTLS, Status: 302, Location: "/user/login", Length: 34},
+		}
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: vhostProbe = %+v, %v; want %+v", tt.name, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,504 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VPNInfo is what --vpn found out about a VPN endpoint.
+type VPNInfo struct {
+	Port int `json:"port"`
+	// Service is "ike" or "openvpn".
+	Service string `json:"service"`
+	// Version is the IKE version the server answered in: "2", or "1" for
+	// a server that rejects IKEv2.
+	Version string `json:"version,omitempty"`
+	// Transforms are the algorithms the IKE server chose from the probe's
+	// proposals: encryption, PRF, integrity and Diffie-Hellman group.
+	Transforms []string `json:"transforms,omitempty"`
+	// VendorIDs are the vendor ID payloads the IKE server sent, named
+	// when they are known.
+	VendorIDs []string `json:"vendor_ids,omitempty"`
+	// Notify is the error the IKE server refused the probe with, such as
+	// NO_PROPOSAL_CHOSEN.
+	Notify string `json:"notify,omitempty"`
+	// Confidence is set for a guess rather than a detection, as for
+	// WireGuard: "medium" or "low".
+	Confidence string `json:"confidence,omitempty"`
+}
+
+func (v VPNInfo) String() string {
+	switch v.Service {
+	case "openvpn":
+		return fmt.Sprintf("udp/%d OpenVPN", v.Port)
+	case "wireguard":
+		return fmt.Sprintf("udp/%d WireGuard? (%s confidence: silent where closed ports answer)", v.Port, v.Confidence)
+	}
+	s := fmt.Sprintf("udp/%d IKEv%s", v.Port, v.Version)
+	if len(v.Transforms) > 0 {
+		s += " " + strings.Join(v.Transforms, "/")
+	}
+	if v.Notify != "" {
+		s += " (" + v.Notify + ")"
+	}
+	if len(v.VendorIDs) > 0 {
+		s += "; vendor IDs: " + strings.Join(v.VendorIDs, ", ")
+	}
+	return s
+}
+
+// The UDP ports --vpn probes: IKE, IKE behind NAT (RFC 3948) and OpenVPN.
+// Tests point them at their own servers.
+var (
+	ikePort     = 500
+	ikeNATTPort = 4500
+	openVPNPort = 1194
+)
+
+// probeVPN sends an IKEv2 IKE_SA_INIT to the IKE ports and an OpenVPN
+// session reset to the OpenVPN port of every host, and records the ports
+// that answer, as open UDP ports. It waits up to --timeout for each
+// answer, and a port that does not answer is left out: UDP gives no way
+// to tell a filtered port from one nothing listens on. WireGuard, which
+// answers nothing, is only guessed at, and its ports are not listed.
+func (p *scanPlan) probeVPN(ctx context.Context, hosts []HostResult) {
+	if !p.vpn {
+		return
+	}
+	probes := []struct {
+		port  int
+		probe func(ctx context.Context, addr netip.Addr, port int) (*VPNInfo, error)
+	}{
+		{ikePort, p.ikeProbe},
+		{ikeNATTPort, p.ikeProbe},
+		{openVPNPort, p.openVPNProbe},
+	}
+	dns := newDNSCache(p.dnsCache)
+	sem := make(chan struct{}, quicParallel)
+	var wg sync.WaitGroup
+	for i := range hosts {
+		h := &hosts[i]
+		sem <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			addr, _, err := udpAddr(ctx, dns, h.Host, h.Family)
+			if err != nil {
+				slog.Warn("vpn probe failed", "host", h.Host, "err", err)
+				return
+			}
+			found := make([]*VPNInfo, len(probes))
+			var ports sync.WaitGroup
+			for j, pr := range probes {
+				ports.Go(func() {
+					info, err := pr.probe(ctx, addr, pr.port)
+					if err != nil {
+						slog.Warn("vpn probe failed", "host", h.Host, "port", pr.port, "err", err)
+					}
+					found[j] = info
+				})
+			}
+			ports.Wait()
+			for _, info := range found {
+				if info != nil {
+					h.VPN = append(h.VPN, *info)
+					addUDPPort(h, info.Port, info.Service)
+				}
+			}
+			// After the other probes, so that the closed ports they hit
+			// do not use up the host's ICMP rate limit.
+			guesses, err := p.wireGuardProbe(ctx, addr)
+			if err != nil {
+				slog.Warn("wireguard probe failed", "host", h.Host, "err", err)
+			}
+			h.VPN = append(h.VPN, guesses...)
+		})
+	}
+	wg.Wait()
+}
+
+// IKEv2 payload types, exchange type and notify message types (RFC 7296).
+const (
+	ikePayloadSA     = 33
+	ikePayloadKE     = 34
+	ikePayloadNonce  = 40
+	ikePayloadNotify = 41
+	ikePayloadVendor = 43
+
+	ikeSAInit = 34
+
+	ikeInvalidKE = 17
+	ikeCookie    = 16390
+)
+
+// ikeGroups are the Diffie-Hellman groups the probe proposes, with the
+// size of their public values; the first is the one its first KE payload
+// is for.
+var ikeGroups = []struct {
+	id   uint16
+	size int
+}{
+	{19, 64}, {20, 96}, {21, 132}, {31, 32}, {14, 256}, {15, 384}, {16, 512}, {2, 128}, {5, 192},
+}
+
+// ikeTransformNames names transform IDs by transform type: encryption,
+// PRF, integrity and Diffie-Hellman group.
+var ikeTransformNames = map[byte]map[uint16]string{
+	1: {3: "3DES", 12: "AES_CBC", 13: "AES_CTR", 18: "AES_GCM_8", 19: "AES_GCM_12", 20: "AES_GCM_16", 28: "CHACHA20_POLY1305"},
+	2: {1: "PRF_HMAC_MD5", 2: "PRF_HMAC_SHA1", 4: "PRF_AES128_XCBC", 5: "PRF_HMAC_SHA2_256", 6: "PRF_HMAC_SHA2_384", 7: "PRF_HMAC_SHA2_512"},
+	3: {1: "HMAC_MD5_96", 2: "HMAC_SHA1_96", 5: "AES_XCBC_96", 12: "HMAC_SHA2_256_128", 13: "HMAC_SHA2_384_192", 14: "HMAC_SHA2_512_256"},
+	4: {2: "MODP_1024", 5: "MODP_1536", 14: "MODP_2048", 15: "MODP_3072", 16: "MODP_4096", 19: "ECP_256", 20: "ECP_384", 21: "ECP_521", 31: "CURVE_25519"},
+}
+
+// ikeNotifyNames names the notify errors a server refuses IKE_SA_INIT
+// with.
+var ikeNotifyNames = map[uint16]string{
+	1:  "UNSUPPORTED_CRITICAL_PAYLOAD",
+	5:  "INVALID_MAJOR_VERSION",
+	7:  "INVALID_SYNTAX",
+	14: "NO_PROPOSAL_CHOSEN",
+	17: "INVALID_KE_PAYLOAD",
+	24: "AUTHENTICATION_FAILED",
+}
+
+// ikeVendorIDs names well-known vendor IDs by the hex of their start.
+var ikeVendorIDs = []struct{ prefix, name string }{
+	{"882fe56d6fd20dbc2251613b2ebe5beb", "strongSwan"},
+	{"4048b7d56ebce88525e7de7f00d6c2d3", "IKE fragmentation"},
+	{"afcad71368a1f1c96b8696fc7757", "Dead Peer Detection"},
+	{"4a131c81070358455c5728f20e95452f", "RFC 3947 NAT-T"},
+	{"12f5f28c457168a9702d9fe274cc", "Cisco Unity"},
+	{"09002689dfd6b712", "XAUTH"},
+	{"1e2b516905991c7d7c96fcbfb587e461", "Microsoft Windows"},
+}
+
+// ikeProbe sends an IKE_SA_INIT request to port of addr and returns what
+// the answer shows, or nil when nothing answers. A server that asks for a
+// cookie or for another Diffie-Hellman group gets the request again as it
+// wants it, once each. No IKE SA is set up: the server drops its half-open
+// one after a while.
+func (p *scanPlan) ikeProbe(ctx context.Context, addr netip.Addr, port int) (*VPNInfo, error) {
+	conn, err := p.dialUDP(addr, port)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	defer context.AfterFunc(ctx, func() { conn.Close() })()
+
+	spi := make([]byte, 8)
+	rand.Read(spi)
+	group := ikeGroups[0].id
+	var cookie []byte
+	retries := 0
+	buf := make([]byte, 65536)
+	for {
+		pkt, err := ikeSAInitRequest(spi, cookie, group)
+		if err != nil {
+			return nil, err
+		}
+		if port == ikeNATTPort {
+			pkt = append([]byte{0, 0, 0, 0}, pkt...) // the non-ESP marker
+		}
+		if _, err := conn.Write(pkt); err != nil {
+			return nil, err
+		}
+		var info *VPNInfo
+		var resend bool
+		deadline := time.Now().Add(p.timeout)
+		for info == nil {
+			conn.SetReadDeadline(deadline)
+			n, err := conn.Read(buf)
+			if err != nil {
+				return nil, nil // nothing answered, or ICMP port unreachable
+			}
+			b := buf[:n]
+			if port == ikeNATTPort && len(b) >= 4 && binary.BigEndian.Uint32(b) == 0 {
+				b = b[4:]
+			}
+			info, cookie, group, resend = parseIKEResponse(b, spi, cookie, group)
+		}
+		info.Port = port
+		if !resend || retries == 2 {
+			return info, nil
+		}
+		retries++
+	}
+}
+
+// ikeSAInitRequest builds an IKE_SA_INIT request with proposals for
+// common algorithms and a key exchange for group, after a COOKIE notify
+// if the server sent one.
+func ikeSAInitRequest(spi, cookie []byte, group uint16) ([]byte, error) {
+	transform := func(typ byte, id uint16, keyLen uint16) []byte {
+		t := []byte{3, 0, 0, 0, typ, 0}
+		t = binary.BigEndian.AppendUint16(t, id)
+		if keyLen != 0 {
+			t = append(t, 0x80, 0x0e) // key length attribute
+			t = binary.BigEndian.AppendUint16(t, keyLen)
+		}
+		binary.BigEndian.PutUint16(t[2:], uint16(len(t)))
+		return t
+	}
+	proposal := func(num byte, last bool, transforms ...[]byte) []byte {
+		pr := []byte{2, 0, 0, 0, num, 1, 0, byte(len(transforms))} // protocol IKE, no SPI
+		if last {
+			pr[0] = 0
+		}
+		for _, t := range transforms {
+			pr = append(pr, t...)
+		}
+		pr[len(pr)-len(transforms[len(transforms)-1])] = 0 // no more transforms
+		binary.BigEndian.PutUint16(pr[2:], uint16(len(pr)))
+		return pr
+	}
+	var prfs, groups [][]byte
+	for _, id := range []uint16{5, 6, 7, 2} {
+		prfs = append(prfs, transform(2, id, 0))
+	}
+	for _, g := range ikeGroups {
+		groups = append(groups, transform(4, g.id, 0))
+	}
+	cbc := [][]byte{transform(1, 12, 256), transform(1, 12, 128), transform(1, 3, 0)}
+	cbc = append(cbc, prfs...)
+	cbc = append(cbc, transform(3, 12, 0), transform(3, 13, 0), transform(3, 14, 0), transform(3, 2, 0))
+	cbc = append(cbc, groups...)
+	// AEAD ciphers go in a proposal of their own, without integrity.
+	gcm := [][]byte{transform(1, 20, 256), transform(1, 20, 128)}
+	gcm = append(gcm, prfs...)
+	gcm = append(gcm, groups...)
+	sa := append(proposal(1, false, cbc...), proposal(2, true, gcm...)...)
+
+	ke, err := ikeKeyExchange(group)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, 32)
+	rand.Read(nonce)
+	types := []byte{ikePayloadSA, ikePayloadKE, ikePayloadNonce}
+	bodies := [][]byte{sa, ke, nonce}
+	if cookie != nil {
+		n := binary.BigEndian.AppendUint16([]byte{0, 0}, ikeCookie)
+		types = append([]byte{ikePayloadNotify}, types...)
+		bodies = append([][]byte{append(n, cookie...)}, bodies...)
+	}
+	// Each payload header names the type of the payload after it.
+	var payloads []byte
+	for i, body := range bodies {
+		next := byte(0)
+		if i+1 < len(types) {
+			next = types[i+1]
+		}
+		payloads = append(payloads, next, 0)
+		payloads = binary.BigEndian.AppendUint16(payloads, uint16(4+len(body)))
+		payloads = append(payloads, body...)
+	}
+
+	hdr := append([]byte(nil), spi...)
+	hdr = append(hdr, make([]byte, 8)...) // the responder's SPI, not yet known
+	hdr = append(hdr, types[0], 0x20, ikeSAInit, 0x08, 0, 0, 0, 0)
+	hdr = binary.BigEndian.AppendUint32(hdr, uint32(28+len(payloads)))
+	return append(hdr, payloads...), nil
+}
+
+// ikeKeyExchange builds a KE payload body with a public value for group:
+// a real key for the elliptic curves, which servers check, and a random
+// number below the prime for the MODP groups.
+func ikeKeyExchange(group uint16) ([]byte, error) {
+	ke := binary.BigEndian.AppendUint16(nil, group)
+	ke = append(ke, 0, 0)
+	var curve ecdh.Curve
+	switch group {
+	case 19:
+		curve = ecdh.P256()
+	case 20:
+		curve = ecdh.P384()
+	case 21:
+		curve = ecdh.P521()
+	case 31:
+		curve = ecdh.X25519()
+	}
+	if curve != nil {
+		key, err := curve.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		pub := key.PublicKey().Bytes()
+		if group != 31 {
+			pub = pub[1:] // IKE leaves out the uncompressed point prefix
+		}
+		return append(ke, pub...), nil
+	}
+	for _, g := range ikeGroups {
+		if g.id == group {
+			v := make([]byte, g.size)
+			rand.Read(v)
+			v[0] &= 0x7f // the MODP primes start with all bits set
+			v[len(v)-1] |= 2
+			return append(ke, v...), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown Diffie-Hellman group %d", group)
+}
+
+// parseIKEResponse reads an answer to the IKE_SA_INIT request of spi. It
+// returns nil for anything else. resend is set, with the cookie and group
+// to use, when the server asks for the request again.
+func parseIKEResponse(b, spi, cookie []byte, group uint16) (info *VPNInfo, newCookie []byte, newGroup uint16, resend bool) {
+	if len(b) < 28 || string(b[:8]) != string(spi) {
+		return nil, cookie, group, false
+	}
+	info = &VPNInfo{Service: "ike", Version: fmt.Sprint(b[17] >> 4)}
+	if b[17]>>4 != 2 {
+		return info, cookie, group, false // IKEv1, rejecting the IKEv2 request
+	}
+	if b[18] != ikeSAInit || b[19]&0x20 == 0 {
+		return nil, cookie, group, false
+	}
+	next, body := b[16], b[28:]
+	for next != 0 && len(body) >= 4 {
+		n := int(binary.BigEndian.Uint16(body[2:]))
+		if n < 4 || n > len(body) {
+			break
+		}
+		typ, data := next, body[4:n]
+		next, body = body[0], body[n:]
+		switch typ {
+		case ikePayloadSA:
+			info.Transforms = parseIKEProposal(data)
+		case ikePayloadVendor:
+			info.VendorIDs = append(info.VendorIDs, ikeVendorName(data))
+		case ikePayloadNotify:
+			if len(data) < 4 || len(data) < 4+int(data[1]) {
+				continue
+			}
+			msg, value := binary.BigEndian.Uint16(data[2:]), data[4+int(data[1]):]
+			switch {
+			case msg == ikeCookie && cookie == nil:
+				newCookie, resend = append([]byte(nil), value...), true
+			case msg == ikeInvalidKE && len(value) == 2 && binary.BigEndian.Uint16(value) != group:
+				group, resend = binary.BigEndian.Uint16(value), true
+				info.Notify = "INVALID_KE_PAYLOAD"
+			case msg < 16384:
+				info.Notify = ikeNotifyNames[msg]
+				if info.Notify == "" {
+					info.Notify = fmt.Sprintf("notify %d", msg)
+				}
+			}
+		}
+	}
+	if newCookie == nil {
+		newCookie = cookie
+	}
+	return info, newCookie, group, resend
+}
+
+// parseIKEProposal names the transforms of the proposal a server chose.
+func parseIKEProposal(b []byte) []string {
+	if len(b) < 8 || len(b) < 8+int(b[6]) {
+		return nil
+	}
+	var names []string
+	for t := b[8+int(b[6]):]; len(t) >= 8; {
+		n := int(binary.BigEndian.Uint16(t[2:]))
+		if n < 8 || n > len(t) {
+			break
+		}
+		typ, id := t[4], binary.BigEndian.Uint16(t[6:])
+		name := ikeTransformNames[typ][id]
+		if name == "" {
+			name = fmt.Sprintf("%d:%d", typ, id)
+		}
+		// A key length attribute, as AES has.
+		if n >= 12 && binary.BigEndian.Uint16(t[8:]) == 0x800e {
+			name += fmt.Sprintf("_%d", binary.BigEndian.Uint16(t[10:]))
+		}
+		names = append(names, name)
+		t = t[n:]
+	}
+	return names
+}
+
+// ikeVendorName names a vendor ID: from the table of known ones, as text
+// if it is printable, or else in hex.
+func ikeVendorName(vid []byte) string {
+	h := hex.EncodeToString(vid)
+	for _, v := range ikeVendorIDs {
+		if strings.HasPrefix(h, v.prefix) {
+			return v.name
+		}
+	}
+	printable := len(vid) > 0
+	for _, c := range vid {
+		if c < 0x20 || c > 0x7e {
+			printable = false
+		}
+	}
+	if printable {
+		return fmt.Sprintf("%q", vid)
+	}
+	return h
+}
+
+// OpenVPN opcodes (the top five bits of a packet's first byte).
+const (
+	openVPNHardResetClientV2 = 7
+	openVPNHardResetServerV2 = 8
+)
+
+// openVPNProbe sends the packet that opens an OpenVPN session to port of
+// addr and returns the endpoint if the server answers with its own. A
+// server with tls-auth or tls-crypt ignores packets without its key, and
+// so looks like nothing listens.
+func (p *scanPlan) openVPNProbe(ctx context.Context, addr netip.Addr, port int) (*VPNInfo, error) {
+	conn, err := p.dialUDP(addr, port)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	defer context.AfterFunc(ctx, func() { conn.Close() })()
+
+	session := make([]byte, 8)
+	rand.Read(session)
+	pkt := []byte{openVPNHardResetClientV2 << 3}
+	pkt = append(pkt, session...)
+	pkt = append(pkt, 0, 0, 0, 0, 0) // no ACKs; packet ID 0
+	if _, err := conn.Write(pkt); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 2048)
+	deadline := time.Now().Add(p.timeout)
+	for {
+		conn.SetReadDeadline(deadline)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, nil
+		}
+		if isOpenVPNReset(buf[:n], session) {
+			return &VPNInfo{Port: port, Service: "openvpn"}, nil
+		}
+	}
+}
+
+// isOpenVPNReset reports whether b is a server's session reset that
+// acknowledges the client's reset of session.
+func isOpenVPNReset(b, session []byte) bool {
+	// Opcode, the server's session ID and the ACKs, then the session ID
+	// they are for.
+	if len(b) < 10 || b[0]>>3 != openVPNHardResetServerV2 {
+		return false
+	}
+	acks := int(b[9])
+	if acks == 0 {
+		return false
+	}
+	off := 10 + 4*acks
+	return len(b) >= off+8 && string(b[off:off+8]) == string(session)
+}
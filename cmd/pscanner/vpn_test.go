@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"net/netip"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+type ikePayload struct {
+	typ  byte
+	data []byte
+}
+
+// ikeTestPayloads splits an IKEv2 message into its payloads.
+func ikeTestPayloads(t *testing.T, b []byte) []ikePayload {
+	t.Helper()
+	if len(b) < 28 || int(binary.BigEndian.Uint32(b[24:])) != len(b) {
+		t.Fatalf("IKE message of %d bytes says it has %d", len(b), binary.BigEndian.Uint32(b[24:]))
+	}
+	var ps []ikePayload
+	for next, body := b[16], b[28:]; next != 0; {
+		n := int(binary.BigEndian.Uint16(body[2:]))
+		ps = append(ps, ikePayload{next, body[4:n]})
+		next, body = body[0], body[n:]
+	}
+	return ps
+}
+
+func ikeTestMessage(spi []byte, payloads ...ikePayload) []byte {
+	var body []byte
+	for i, p := range payloads {
+		next := byte(0)
+		if i+1 < len(payloads) {
+			next = payloads[i+1].typ
+		}
+		body = append(body, next, 0)
+		body = binary.BigEndian.AppendUint16(body, uint16(4+len(p.data)))
+		body = append(body, p.data...)
+	}
+	first := byte(0)
+	if len(payloads) > 0 {
+		first = payloads[0].typ
+	}
+	m := append(append([]byte(nil), spi...), "respondr"...)
+	m = append(m, first, 0x20, ikeSAInit, 0x20, 0, 0, 0, 0)
+	m = binary.BigEndian.AppendUint32(m, uint32(28+len(body)))
+	return append(m, body...)
+}
+
+func ikeTestNotify(msg uint16, value []byte) ikePayload {
+	n := binary.BigEndian.AppendUint16([]byte{0, 0}, msg)
+	return ikePayload{ikePayloadNotify, append(n, value...)}
+}
+
+// ikeTestSA is an SA payload with one proposal of the given transforms,
+// as type and ID pairs, AES with a 256-bit key.
+func ikeTestSA(transforms ...uint16) ikePayload {
+	pr := []byte{0, 0, 0, 0, 1, 1, 0, byte(len(transforms) / 2)}
+	for i := 0; i < len(transforms); i += 2 {
+		t := []byte{3, 0, 0, 8, byte(transforms[i]), 0}
+		t = binary.BigEndian.AppendUint16(t, transforms[i+1])
+		if transforms[i] == 1 {
+			t = append(t, 0x80, 0x0e, 1, 0)
+			t[3] = 12
+		}
+		if i+2 == len(transforms) {
+			t[0] = 0
+		}
+		pr = append(pr, t...)
+	}
+	binary.BigEndian.PutUint16(pr[2:], uint16(len(pr)))
+	return ikePayload{ikePayloadSA, pr}
+}
+
+// startIKEServer answers IKE_SA_INIT requests on a local UDP port the way
+// respond says, given the request's payloads and how many came before.
+func startIKEServer(t *testing.T, natt bool, respond func(req []ikePayload, n int) []ikePayload) int {
+	pc := listenUDP(t)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 4096)
+		for n := 0; ; n++ {
+			size, from, err := pc.ReadFromUDPAddrPort(buf)
+			if err != nil {
+				return
+			}
+			b := buf[:size]
+			if natt {
+				if binary.BigEndian.Uint32(b) != 0 {
+					t.Errorf("NAT-T request without the non-ESP marker")
+					return
+				}
+				b = b[4:]
+			}
+			resp := ikeTestMessage(b[:8], respond(ikeTestPayloads(t, b), n)...)
+			if natt {
+				resp = append([]byte{0, 0, 0, 0}, resp...)
+			}
+			pc.WriteToUDPAddrPort(resp, from)
+		}
+	}()
+	t.Cleanup(func() {
+		pc.Close()
+		<-done
+	})
+	return pc.LocalAddr().(*net.UDPAddr).Port
+}
+
+func listenUDP(t *testing.T) *net.UDPConn {
+	t.Helper()
+	pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pc
+}
+
+func payloadTypes(ps []ikePayload) []byte {
+	var types []byte
+	for _, p := range ps {
+		types = append(types, p.typ)
+	}
+	return types
+}
+
+func TestIKESAInitRequest(t *testing.T) {
+	spi := []byte("initiatr")
+	b, err := ikeSAInitRequest(spi, []byte("cookie"), 14)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps := ikeTestPayloads(t, b)
+	if want := []byte{ikePayloadNotify, ikePayloadSA, ikePayloadKE, ikePayloadNonce}; !reflect.DeepEqual(payloadTypes(ps), want) {
+		t.Fatalf("payloads = %v, want %v", payloadTypes(ps), want)
+	}
+	if string(ps[0].data[4:]) != "cookie" || binary.BigEndian.Uint16(ps[0].data[2:]) != ikeCookie {
+		t.Errorf("notify = %x, want the cookie", ps[0].data)
+	}
+	if g := binary.BigEndian.Uint16(ps[2].data); g != 14 || len(ps[2].data) != 4+256 {
+		t.Errorf("KE for group %d with %d bytes, want MODP_2048's 256", g, len(ps[2].data)-4)
+	}
+
+	// Two proposals, the second the last, CBC with integrity and GCM
+	// without.
+	sa := ps[1].data
+	first := int(binary.BigEndian.Uint16(sa[2:]))
+	if sa[0] != 2 || sa[first] != 0 || first+int(binary.BigEndian.Uint16(sa[first+2:])) != len(sa) {
+		t.Fatalf("SA payload is not two proposals: %x", sa)
+	}
+	cbc, gcm := parseIKEProposal(sa[:first]), parseIKEProposal(sa[first:])
+	for _, want := range []string{"AES_CBC_256", "AES_CBC_128", "PRF_HMAC_SHA2_256", "HMAC_SHA2_256_128", "ECP_256", "MODP_2048"} {
+		if !slices.Contains(cbc, want) {
+			t.Errorf("first proposal %v lacks %s", cbc, want)
+		}
+	}
+	if !slices.Contains(gcm, "AES_GCM_16_256") || slices.Contains(gcm, "HMAC_SHA2_256_128") {
+		t.Errorf("second proposal = %v", gcm)
+	}
+
+	// An elliptic curve group gets a point without the 0x04 prefix.
+	b, _ = ikeSAInitRequest(spi, nil, 19)
+	if ps := ikeTestPayloads(t, b); ps[0].typ != ikePayloadSA || len(ps[1].data) != 4+64 {
+		t.Errorf("payloads %v, KE of %d bytes", payloadTypes(ps), len(ps[1].data))
+	}
+}
+
+func TestProbeVPN(t *testing.T) {
+	accept := []ikePayload{
+		ikeTestSA(1, 12, 2, 5, 3, 12, 4, 19),
+		{ikePayloadKE, make([]byte, 68)},
+		{ikePayloadNonce, make([]byte, 32)},
+		{ikePayloadVendor, mustHex(t, "882fe56d6fd20dbc2251613b2ebe5beb")},
+		{ikePayloadVendor, []byte("FLEXVPN-SUPPORTED")},
+	}
+	acceptVIDs := []string{"strongSwan", `"FLEXVPN-SUPPORTED"`}
+	acceptTransforms := []string{"AES_CBC_256", "PRF_HMAC_SHA2_256", "HMAC_SHA2_256_128", "ECP_256"}
+	tests := []struct {
+		name    string
+		respond func(t *testing.T, req []ikePayload, n int) []ikePayload
+		want    VPNInfo
+	}{
+		{
+			"accept",
+			func(t *testing.T, req []ikePayload, n int) []ikePayload { return accept },
+			VPNInfo{Service: "ike", Version: "2", Transforms: acceptTransforms, VendorIDs: acceptVIDs},
+		},
+		{
+			"cookie",
+			func(t *testing.T, req []ikePayload, n int) []ikePayload {
+				if n == 0 {
+					return []ikePayload{ikeTestNotify(ikeCookie, []byte("c00kie"))}
+				}
+				if req[0].typ != ikePayloadNotify || string(req[0].data[4:]) != "c00kie" {
+					t.Errorf("second request starts with %x, want the cookie", req[0].data)
+				}
+				return accept
+			},
+			VPNInfo{Service: "ike", Version: "2", Transforms: acceptTransforms, VendorIDs: acceptVIDs},
+		},
+		{
+			"group",
+			func(t *testing.T, req []ikePayload, n int) []ikePayload {
+				ke := req[1].data
+				if n == 0 {
+					return []ikePayload{ikeTestNotify(ikeInvalidKE, []byte{0, 14})}
+				}
+				if g := binary.BigEndian.Uint16(ke); g != 14 {
+					t.Errorf("second KE for group %d, want 14", g)
+				}
+				return []ikePayload{ikeTestSA(1, 12, 2, 5, 3, 12, 4, 14), {ikePayloadKE, make([]byte, 260)}, {ikePayloadNonce, make([]byte, 32)}}
+			},
+			VPNInfo{Service: "ike", Version: "2", Transforms: []string{"AES_CBC_256", "PRF_HMAC_SHA2_256", "HMAC_SHA2_256_128", "MODP_2048"}},
+		},
+		{
+			"no proposal",
+			func(t *testing.T, req []ikePayload, n int) []ikePayload {
+				return []ikePayload{ikeTestNotify(14, nil)}
+			},
+			VPNInfo{Service: "ike", Version: "2", Notify: "NO_PROPOSAL_CHOSEN"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ike := startIKEServer(t, false, func(req []ikePayload, n int) []ikePayload { return tt.respond(t, req, n) })
+			natt := startIKEServer(t, true, func(req []ikePayload, n int) []ikePayload { return accept })
+			quiet := listenUDP(t)
+			defer quiet.Close()
+			defer func(ike, natt, ovpn int, wg []int) {
+				ikePort, ikeNATTPort, openVPNPort, wireGuardPorts = ike, natt, ovpn, wg
+			}(ikePort, ikeNATTPort, openVPNPort, wireGuardPorts)
+			wireGuardPorts = nil
+			ikePort, ikeNATTPort, openVPNPort = ike, natt, quiet.LocalAddr().(*net.UDPAddr).Port
+
+			p := &scanPlan{vpn: true, timeout: 500 * time.Millisecond}
+			hosts := []HostResult{{Host: "127.0.0.1", Ports: []PortResult{}}}
+			p.probeVPN(context.Background(), hosts)
+			tt.want.Port = ike
+			want := []VPNInfo{tt.want, {Port: natt, Service: "ike", Version: "2", Transforms: acceptTransforms, VendorIDs: acceptVIDs}}
+			if !reflect.DeepEqual(hosts[0].VPN, want) {
+				t.Errorf("VPN = %+v\nwant %+v", hosts[0].VPN, want)
+			}
+			wantPorts := []PortResult{{Port: ike, Protocol: "udp", State: "open", Service: "ike"}, {Port: natt, Protocol: "udp", State: "open", Service: "ike"}}
+			if !reflect.DeepEqual(hosts[0].Ports, wantPorts) {
+				t.Errorf("ports = %+v, want %+v", hosts[0].Ports, wantPorts)
+			}
+		})
+	}
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// A server that only speaks IKEv1 answers in version 1.
+func TestParseIKEResponseV1(t *testing.T) {
+	spi := []byte("initiatr")
+	m := ikeTestMessage(spi)
+	m[17] = 0x10
+	info, _, _, resend := parseIKEResponse(m, spi, nil, 19)
+	if info == nil || info.Version != "1" || resend {
+		t.Errorf("info = %+v, resend %v", info, resend)
+	}
+	if info, _, _, _ := parseIKEResponse(m, []byte("someone!"), nil, 19); info != nil {
+		t.Error("answer to another initiator accepted")
+	}
+}
+
+func TestProbeOpenVPN(t *testing.T) {
+	pc := listenUDP(t)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 2048)
+		n, from, err := pc.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			return
+		}
+		if n != 14 || buf[0] != openVPNHardResetClientV2<<3 {
+			t.Errorf("client reset = %x", buf[:n])
+		}
+		// The server's reset: its session ID, an ACK of packet 0 of the
+		// client's session, then its own packet ID.
+		resp := append([]byte{openVPNHardResetServerV2 << 3}, "serverid"...)
+		resp = append(resp, 1, 0, 0, 0, 0)
+		resp = append(resp, buf[1:9]...)
+		resp = append(resp, 0, 0, 0, 0)
+		pc.WriteToUDPAddrPort(resp, from)
+	}()
+	defer func() {
+		pc.Close()
+		<-done
+	}()
+
+	p := &scanPlan{timeout: time.Second}
+	port := pc.LocalAddr().(*net.UDPAddr).Port
+	info, err := p.openVPNProbe(context.Background(), netip.MustParseAddr("127.0.0.1"), port)
+	if want := (&VPNInfo{Port: port, Service: "openvpn"}); err != nil || !reflect.DeepEqual(info, want) {
+		t.Errorf("openVPNProbe = %+v, %v", info, err)
+	}
+}
+
+func TestVPNInfoString(t *testing.T) {
+	for _, tt := range []struct {
+		v    VPNInfo
+		want string
+	}{
+		{VPNInfo{Port: 1194, Service: "openvpn"}, "udp/1194 OpenVPN"},
+		{VPNInfo{Port: 51820, Service: "wireguard", Confidence: "low"}, "udp/51820 WireGuard? (low confidence: silent where closed ports answer)"},
+		{VPNInfo{Port: 500, Service: "ike", Version: "2", Transforms: []string{"AES_CBC_256", "ECP_256"}, VendorIDs: []string{"strongSwan"}},
+			"udp/500 IKEv2 AES_CBC_256/ECP_256; vendor IDs: strongSwan"},
+		{VPNInfo{Port: 4500, Service: "ike", Version: "2", Notify: "NO_PROPOSAL_CHOSEN"}, "udp/4500 IKEv2 (NO_PROPOSAL_CHOSEN)"},
+	} {
+		if got := tt.v.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
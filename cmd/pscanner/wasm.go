@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+
+	"github.com/AlirezaNezami23/pscanner/probe"
+)
+
+// wasmPlugin is a --plugin: a WebAssembly module, built for WASI preview
+// 1, that is a --tcp probe or an --output format. Each call runs the
+// module afresh with a JSON request on its stdin, and takes its stdout as
+// the answer; a probe talks to its port through the send and recv
+// functions of the "pscanner" host module.
+type wasmPlugin struct {
+	name   string
+	info   wasmInfo
+	module wazero.CompiledModule
+}
+
+// wasmInfo is what a plugin answers to {"call": "info"}.
+type wasmInfo struct {
+	// Kind is "probe" or "output".
+	Kind string `json:"kind"`
+	// Ports are those a probe is for.
+	Ports []int  `json:"ports,omitempty"`
+	Doc   string `json:"doc,omitempty"`
+}
+
+const (
+	// wasmTimeout bounds one call of a plugin.
+	wasmTimeout = 30 * time.Second
+	// wasmMemoryPages bounds the memory of a plugin, at 64 KiB a page.
+	wasmMemoryPages = 1024
+)
+
+var (
+	wasmOnce    sync.Once
+	wasmRuntime wazero.Runtime
+	wasmErr     error
+
+	wasmMu sync.Mutex
+	// wasmLoaded holds the plugins loaded, by path, as each is loaded
+	// and registered once.
+	wasmLoaded = make(map[string]*wasmPlugin)
+	// outputPlugins are the --output formats of plugins, by name.
+	outputPlugins = make(map[string]*wasmPlugin)
+)
+
+// defaultPluginDir returns the per-user plugin location, e.g.
+// ~/.config/pscanner/plugins on Linux.
+func defaultPluginDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "pscanner", "plugins")
+}
+
+// pluginRuntime returns the runtime plugins share, with WASI and the
+// pscanner host module.
+func pluginRuntime() (wazero.Runtime, error) {
+	wasmOnce.Do(func() {
+		ctx := context.Background()
+		config := wazero.NewRuntimeConfig().
+			WithCloseOnContextDone(true).
+			WithMemoryLimitPages(wasmMemoryPages)
+		r := wazero.NewRuntimeWithConfig(ctx, config)
+		if _, err := wasi_snapshot_preview1.Instantiate(ctx, r); err != nil {
+			wasmErr = err
+			return
+		}
+		_, wasmErr = r.NewHostModuleBuilder("pscanner").
+			NewFunctionBuilder().WithFunc(wasmSend).Export("send").
+			NewFunctionBuilder().WithFunc(wasmRecv).Export("recv").
+			Instantiate(ctx)
+		wasmRuntime = r
+	})
+	return wasmRuntime, wasmErr
+}
+
+// loadPlugins loads a --plugin list: names of plugins in dir, paths to
+// .wasm files, or "all" for every plugin in dir. Probes join the --tcp
+// probes and output formats the --output ones.
+func loadPlugins(list, dir string) ([]*wasmPlugin, error) {
+	paths, err := resolveFiles(list, dir, ".wasm")
+	if err != nil {
+		return nil, err
+	}
+	var plugins []*wasmPlugin
+	for _, path := range paths {
+		w, err := loadPlugin(path)
+		if err != nil {
+			return nil, err
+		}
+		plugins = append(plugins, w)
+	}
+	return plugins, nil
+}
+
+func loadPlugin(path string) (*wasmPlugin, error) {
+	wasmMu.Lock()
+	defer wasmMu.Unlock()
+	if w, ok := wasmLoaded[path]; ok {
+		return w, nil
+	}
+	r, err := pluginRuntime()
+	if err != nil {
+		return nil, err
+	}
+	bin, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	module, err := r.CompileModule(context.Background(), bin)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	w := &wasmPlugin{name: strings.TrimSuffix(filepath.Base(path), ".wasm"), module: module}
+	out, err := w.call(context.Background(), map[string]string{"call": "info"}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(out, &w.info); err != nil {
+		return nil, fmt.Errorf("%s: info: %v", path, err)
+	}
+	switch w.info.Kind {
+	case "probe":
+		if _, ok := tcpProbes[w.name]; ok {
+			return nil, fmt.Errorf("%s: there is a --tcp probe %s already", path, w.name)
+		}
+		if len(w.info.Ports) == 0 || slices.ContainsFunc(w.info.Ports, func(port int) bool { return port < 1 || port > 65535 }) {
+			return nil, fmt.Errorf("%s: a probe needs ports from 1 to 65535", path)
+		}
+		tcpProbes[w.name] = registeredTCPProbe(probe.Probe{Name: w.name, Ports: w.info.Ports, Doc: w.info.Doc, Probe: w.probe})
+	case "output":
+		if slices.Contains(outputFormats, w.name) {
+			return nil, fmt.Errorf("%s: there is an --output %s already", path, w.name)
+		}
+		outputFormats = append(outputFormats, w.name)
+		outputPlugins[w.name] = w
+	default:
+		return nil, fmt.Errorf("%s: kind must be probe or output, not %q", path, w.info.Kind)
+	}
+	wasmLoaded[path] = w
+	return w, nil
+}
+
+// wasmConnKey holds, in the context of a probe's call, its connection.
+type wasmConnKey struct{}
+
+// call runs the plugin with req on its stdin, and returns its stdout. conn
+// is the connection of a probe, or nil.
+func (w *wasmPlugin) call(ctx context.Context, req any, conn net.Conn) ([]byte, error) {
+	in, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	r, err := pluginRuntime()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, wasmTimeout)
+	defer cancel()
+	if conn != nil {
+		ctx = context.WithValue(ctx, wasmConnKey{}, conn)
+	}
+	var out bytes.Buffer
+	config := wazero.NewModuleConfig().
+		WithName("").
+		WithArgs(w.name).
+		WithStdin(bytes.NewReader(in)).
+		WithStdout(&out).
+		WithStderr(os.Stderr).
+		WithSysWalltime().
+		WithSysNanotime().
+		WithSysNanosleep().
+		WithRandSource(rand.Reader)
+	mod, err := r.InstantiateModule(ctx, w.module, config)
+	if mod != nil {
+		mod.Close(context.Background())
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("plugin %s: %v", w.name, ctx.Err())
+		}
+		return nil, fmt.Errorf("plugin %s: %v", w.name, err)
+	}
+	return out.Bytes(), nil
+}
+
+// probe asks the plugin about the open port t, on conn. An answer of
+// nothing means the port is not the plugin's service.
+func (w *wasmPlugin) probe(conn net.Conn, t probe.Target) (*probe.Service, error) {
+	req := map[string]any{"call": "probe", "host": t.Host, "port": t.Port}
+	out, err := w.call(context.Background(), req, conn)
+	if err != nil || len(bytes.TrimSpace(out)) == 0 {
+		return nil, err
+	}
+	var s struct {
+		Details string   `json:"details"`
+		Issues  []string `json:"issues"`
+	}
+	if err := json.Unmarshal(out, &s); err != nil {
+		return nil, fmt.Errorf("plugin %s: %v", w.name, err)
+	}
+	return &probe.Service{Details: s.Details, Issues: s.Issues}, nil
+}
+
+// writeOutput writes r in the plugin's format.
+func (w *wasmPlugin) writeOutput(out io.Writer, r *Report) error {
+	b, err := w.call(context.Background(), map[string]any{"call": "output", "report": r}, nil)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(b)
+	return err
+}
+
+// wasmSend is pscanner.send(ptr, len), which sends len bytes of memory at
+// ptr on the probe's connection, and returns the count sent, or -1.
+func wasmSend(ctx context.Context, m api.Module, ptr, n uint32) int32 {
+	conn, ok := ctx.Value(wasmConnKey{}).(net.Conn)
+	if !ok {
+		return -1
+	}
+	data, ok := m.Memory().Read(ptr, n)
+	if !ok {
+		return -1
+	}
+	conn.SetWriteDeadline(time.Now().Add(tcpProbeTimeout))
+	sent, err := conn.Write(data)
+	if err != nil {
+		return -1
+	}
+	return int32(sent)
+}
+
+// wasmRecv is pscanner.recv(ptr, cap), which reads into memory at ptr what
+// the probe's port answers, up to cap bytes, until it closes or pauses,
+// and returns the count read: 0 when it said nothing, -1 on an error.
+func wasmRecv(ctx context.Context, m api.Module, ptr, capacity uint32) int32 {
+	conn, ok := ctx.Value(wasmConnKey{}).(net.Conn)
+	if !ok || capacity == 0 {
+		return -1
+	}
+	reply, err := readReply(conn, int(min(capacity, scriptMaxReceive)), time.Now().Add(tcpProbeTimeout))
+	if len(reply) == 0 && err != nil && !isTimeout(err) && !errors.Is(err, io.EOF) {
+		return -1
+	}
+	if !m.Memory().Write(ptr, reply) {
+		return -1
+	}
+	return int32(len(reply))
+}
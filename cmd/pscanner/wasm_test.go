@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// buildPlugins builds the plugins of testdata/plugins for WASI and returns
+// the directory they are in. Their probes and formats are unregistered
+// when the test ends.
+func buildPlugins(t *testing.T, names ...string) string {
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("no go tool to build the plugins with")
+	}
+	dir := t.TempDir()
+	for _, name := range names {
+		cmd := exec.Command(goTool, "build", "-o", filepath.Join(dir, name+".wasm"), "./testdata/plugins/"+name)
+		cmd.Env = append(cmd.Environ(), "GOOS=wasip1", "GOARCH=wasm")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("building %s: %v\n%s", name, err, out)
+		}
+	}
+	t.Cleanup(func() {
+		wasmMu.Lock()
+		defer wasmMu.Unlock()
+		for path, w := range wasmLoaded {
+			if filepath.Dir(path) == dir {
+				delete(tcpProbes, w.name)
+				delete(outputPlugins, w.name)
+				outputFormats = slices.DeleteFunc(outputFormats, func(f string) bool { return f == w.name })
+				delete(wasmLoaded, path)
+			}
+		}
+	})
+	return dir
+}
+
+func TestWasmPlugins(t *testing.T) {
+	dir := buildPlugins(t, "banner", "hostlist")
+	plugins, err := loadPlugins("all", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plugins) != 2 || plugins[0].info.Kind != "probe" || plugins[1].info.Kind != "output" {
+		t.Fatalf("plugins = %+v", plugins)
+	}
+	if got, err := parseTCPProbes("banner"); err != nil || !reflect.DeepEqual(got, []string{"banner"}) {
+		t.Errorf("parseTCPProbes(banner) = %v, %v", got, err)
+	}
+	if !validOutput("hostlist") {
+		t.Error("hostlist is not an --output format")
+	}
+
+	port := serveTCP(t, func(conn net.Conn) {
+		if line, _ := bufio.NewReader(conn).ReadString('\n'); line == "HELLO\r\n" {
+			conn.Write([]byte("WELCOME acme 2.1\r\n"))
+			time.Sleep(time.Second)
+		}
+	})
+	h := &HostResult{Host: "127.0.0.1"}
+	p := &scanPlan{timeout: time.Second}
+	s, err := p.tcpProbe(context.Background(), nil, h, port, tcpProbes["banner"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (&TCPService{Details: "acme 2.1 on 127.0.0.1", Issues: []string{"no login"}}); !reflect.DeepEqual(s, want) {
+		t.Errorf("banner probe = %+v, want %+v", s, want)
+	}
+
+	var out strings.Builder
+	r := &Report{Hosts: []HostResult{{Host: "10.0.0.1", Ports: []PortResult{{Port: 22}, {Port: 80}}}}}
+	if err := writeReport(&out, "hostlist", r); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "10.0.0.1 22 80\n" {
+		t.Errorf("hostlist output = %q", out.String())
+	}
+
+	// A second load of the same file is the first.
+	if again, err := loadPlugins(filepath.Join(dir, "banner.wasm"), ""); err != nil || again[0] != plugins[0] {
+		t.Errorf("reloading banner = %v, %v", again, err)
+	}
+}
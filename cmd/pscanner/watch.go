@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// watchEvent is one line of scan --watch output with --output json: the
+// full report of the first run, then the changes found by each later run.
+type watchEvent struct {
+	Event  string       `json:"event"` // "baseline" or "change"
+	Time   time.Time    `json:"time"`
+	Report *Report      `json:"report,omitempty"`
+	Opened []portChange `json:"opened,omitempty"`
+	Closed []portChange `json:"closed,omitempty"`
+}
+
+// watcher rescans the same plan at a fixed interval and reports what
+// changed between runs.
+type watcher struct {
+	plan    *scanPlan
+	profile string
+	every   time.Duration
+	out     io.Writer
+	format  string
+	notify  []func(scanRun)
+	enrich  *enrichment
+}
+
+// run scans until ctx is done. The first run is written in full; after
+// that only runs that opened or closed a port produce output. Runs never
+// overlap: one that outlasts the interval is followed by the next at once.
+func (w *watcher) run(ctx context.Context) error {
+	var prev *Report
+	for {
+		started := time.Now()
+		hosts := w.plan.run(ctx, scanHooks{})
+		w.plan.probeHoneypots(ctx, hosts)
+		w.plan.traceRoutes(ctx, hosts)
+		w.plan.probeQUIC(ctx, hosts)
+		w.plan.probeDTLS(ctx, hosts)
+		w.plan.probeVPN(ctx, hosts)
+		w.plan.probeUDP(ctx, hosts)
+		w.plan.probeTCP(ctx, hosts)
+		w.plan.probeOT(ctx, hosts)
+		w.plan.probeContainers(ctx, hosts)
+		w.plan.probeOpenInstances(ctx, hosts)
+		w.plan.probeEndpoints(ctx, hosts)
+		w.plan.probeVHosts(ctx, hosts)
+		w.plan.probeHTTPPaths(ctx, hosts)
+		w.plan.probeSafeChecks(ctx, hosts)
+		w.plan.probeScreenshots(ctx, hosts)
+		w.plan.probePayloads(ctx, hosts)
+		w.plan.probeScripts(ctx, hosts)
+		w.enrich.apply(ctx, hosts)
+		report := newReport(w.plan, w.profile, started, hosts, ctx.Err() != nil)
+		// A run cut short by Ctrl-C says nothing about closed ports, so
+		// only a partial first run is worth writing out.
+		if report.Canceled && prev != nil {
+			return nil
+		}
+
+		run := scanRun{Report: report}
+		var err error
+		if prev == nil {
+			err = w.baseline(report)
+		} else {
+			d := diffReports(prev, report)
+			run.Diff = &d
+			err = w.changes(report, d)
+		}
+		if err != nil {
+			return err
+		}
+		for _, n := range w.notify {
+			n(run)
+		}
+		if report.Canceled {
+			return nil
+		}
+		prev = report
+
+		select {
+		case <-time.After(time.Until(started.Add(w.every))):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (w *watcher) baseline(r *Report) error {
+	switch w.format {
+	case "json":
+		return json.NewEncoder(w.out).Encode(watchEvent{Event: "baseline", Time: r.FinishedAt, Report: r})
+	case "syslog":
+		return writeSyslog(w.out, r)
+	}
+	return writeText(w.out, r)
+}
+
+func (w *watcher) changes(r *Report, d reportDiff) error {
+	if len(d.Opened) == 0 && len(d.Closed) == 0 {
+		return nil
+	}
+	switch w.format {
+	case "json":
+		return json.NewEncoder(w.out).Encode(watchEvent{Event: "change", Time: r.FinishedAt, Opened: d.Opened, Closed: d.Closed})
+	case "syslog":
+		return writeSyslogChanges(w.out, r, d)
+	}
+	at := r.FinishedAt.Local().Format("2006-01-02 15:04:05")
+	for _, list := range []struct {
+		what  string
+		ports []portChange
+	}{{"opened", d.Opened}, {"closed", d.Closed}} {
+		for _, c := range list.ports {
+			line := fmt.Sprintf("%s  %-6s  %s", at, list.what, c.addr())
+			if name := serviceName(c.Port); name != "" {
+				line += "  " + name
+			}
+			if _, err := fmt.Fprintln(w.out, line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
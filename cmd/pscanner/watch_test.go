@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+// lineWriter passes every write on a channel; JSON encoders write one
+// event per call.
+type lineWriter chan string
+
+func (w lineWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
+
+func TestWatcherReportsChanges(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	port := l.Addr().(*net.TCPAddr).Port
+	targets, _ := parseTargets("127.0.0.1")
+	plan := &scanPlan{targets: targets, numTargets: 1, ports: []int{port}, workers: 1, timeout: time.Second}
+
+	out := make(lineWriter)
+	var runs []scanRun
+	w := &watcher{plan: plan, every: 10 * time.Millisecond, out: out, format: "json",
+		notify: []func(scanRun){func(r scanRun) { runs = append(runs, r) }}}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- w.run(ctx) }()
+
+	next := func() watchEvent {
+		t.Helper()
+		var ev watchEvent
+		select {
+		case line := <-out:
+			if err := json.Unmarshal([]byte(line), &ev); err != nil {
+				t.Fatalf("%q: %v", line, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no output from watcher")
+		}
+		return ev
+	}
+
+	if ev := next(); ev.Event != "baseline" || ev.Report == nil || len(ev.Report.Hosts[0].Ports) != 1 {
+		t.Fatalf("first event = %+v", ev)
+	}
+	// Unchanged runs print nothing; the next event is the closed port.
+	l.Close()
+	ev := next()
+	if ev.Event != "change" || len(ev.Opened) != 0 || len(ev.Closed) != 1 || ev.Closed[0].Port != port {
+		t.Fatalf("change event = %+v", ev)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) < 2 || runs[0].Diff != nil || runs[len(runs)-1].Diff == nil {
+		t.Errorf("notifiers saw %d runs: %+v", len(runs), runs)
+	}
+}
+
+func TestWatcherTextChanges(t *testing.T) {
+	var b bytes.Buffer
+	w := &watcher{out: &b, format: "text"}
+	r := &Report{FinishedAt: time.Date(2026, 10, 14, 12, 0, 0, 0, time.Local)}
+	if err := w.changes(r, reportDiff{Opened: changes("::1", 22), Closed: changes("10.0.0.5", 49999)}); err != nil {
+		t.Fatal(err)
+	}
+	want := "2026-10-14 12:00:00  opened  [::1]:22/tcp  ssh\n" +
+		"2026-10-14 12:00:00  closed  10.0.0.5:49999/tcp\n"
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+
+	b.Reset()
+	if err := w.changes(r, reportDiff{}); err != nil || b.Len() != 0 {
+		t.Errorf("no changes wrote %q, %v", b.String(), err)
+	}
+}
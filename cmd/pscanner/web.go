@@ -0,0 +1,209 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// webFiles is the dashboard served at / by "pscanner serve".
+//
+//go:embed web
+var webFiles embed.FS
+
+// webServer serves the dashboard and the JSON API it uses.
+type webServer struct {
+	jobs *jobManager
+	// loopback is set when the listener only accepts local connections;
+	// requests must then name a loopback host, which defeats DNS rebinding.
+	loopback bool
+}
+
+func newWebHandler(jobs *jobManager, loopback bool) http.Handler {
+	s := &webServer{jobs: jobs, loopback: loopback}
+	static, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		panic(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /", http.FileServer(http.FS(static)))
+	mux.HandleFunc("GET /api/profiles", s.profiles)
+	mux.HandleFunc("GET /api/scans", s.listScans)
+	mux.HandleFunc("POST /api/scans", s.submitScan)
+	mux.HandleFunc("GET /api/scans/{id}", s.getScan)
+	mux.HandleFunc("POST /api/scans/{id}/cancel", s.cancelScan)
+	mux.HandleFunc("GET /api/diff", s.diff)
+	return s.guard(mux)
+}
+
+// isLoopbackHost reports whether host names the local machine.
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// isLoopbackListen reports whether the listen address addr only accepts
+// local connections.
+func isLoopbackListen(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	return err == nil && isLoopbackHost(host)
+}
+
+// guard rejects requests that may have been made by another web site on
+// the user's behalf. The API starts scans and has no authentication, so a
+// page elsewhere must not be able to reach it through the browser.
+func (s *webServer) guard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.loopback {
+			host, _, err := net.SplitHostPort(r.Host)
+			if err != nil {
+				host = strings.Trim(r.Host, "[]")
+			}
+			if !isLoopbackHost(host) {
+				httpError(w, http.StatusForbidden, "host not allowed")
+				return
+			}
+		}
+		if origin := r.Header.Get("Origin"); origin != "" {
+			u, err := url.Parse(origin)
+			if err != nil || u.Host != r.Host {
+				httpError(w, http.StatusForbidden, "cross-origin request refused")
+				return
+			}
+		}
+		if r.Method == http.MethodPost {
+			// Forms cannot send JSON, so this also blocks cross-site form posts.
+			if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct != "application/json" {
+				httpError(w, http.StatusUnsupportedMediaType, "want Content-Type: application/json")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func httpError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
+
+func (s *webServer) profiles(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.jobs.cfg.profileNames())
+}
+
+func (s *webServer) listScans(w http.ResponseWriter, r *http.Request) {
+	list, err := s.jobs.list()
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *webServer) submitScan(w http.ResponseWriter, r *http.Request) {
+	var req scanRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	j, err := s.jobs.submit(&req)
+	var ce *confirmError
+	switch {
+	case errors.As(err, &ce):
+		writeJSON(w, http.StatusConflict, map[string]any{"error": err.Error(), "warnings": ce.warnings})
+		return
+	case errors.Is(err, errNotAudited):
+		httpError(w, http.StatusInternalServerError, err.Error())
+		return
+	case err != nil:
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, j.summary())
+}
+
+// scanStatus is the response of GET /api/scans/{id}. Ports holds the open
+// ports found after the first "since" ones, so a client can poll for new
+// results; Report is set once the scan has finished.
+type scanStatus struct {
+	scanSummary
+	Ports  []openPort `json:"ports"`
+	Next   int        `json:"next"`
+	Report *Report    `json:"report,omitempty"`
+}
+
+func (s *webServer) getScan(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	since, _ := strconv.Atoi(r.URL.Query().Get("since"))
+	if since < 0 {
+		since = 0
+	}
+	if j, ok := s.jobs.get(id); ok {
+		st := scanStatus{scanSummary: j.summary()}
+		st.Ports, _, _ = j.snapshot(since)
+		st.Next = since + len(st.Ports)
+		j.mu.Lock()
+		st.Report = j.report
+		j.mu.Unlock()
+		writeJSON(w, http.StatusOK, st)
+		return
+	}
+	rep, err := s.jobs.report(id)
+	if err != nil {
+		s.reportError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, scanStatus{scanSummary: reportSummary(rep), Ports: []openPort{}, Report: rep})
+}
+
+func (s *webServer) cancelScan(w http.ResponseWriter, r *http.Request) {
+	j, ok := s.jobs.get(r.PathValue("id"))
+	if !ok {
+		httpError(w, http.StatusNotFound, errNoScan.Error())
+		return
+	}
+	j.cancel()
+	writeJSON(w, http.StatusAccepted, j.summary())
+}
+
+func (s *webServer) diff(w http.ResponseWriter, r *http.Request) {
+	from, err := s.jobs.report(r.URL.Query().Get("from"))
+	if err != nil {
+		s.reportError(w, err)
+		return
+	}
+	to, err := s.jobs.report(r.URL.Query().Get("to"))
+	if err != nil {
+		s.reportError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, diffReports(from, to))
+}
+
+func (s *webServer) reportError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errNoScan):
+		httpError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, errScanRunning):
+		httpError(w, http.StatusConflict, err.Error())
+	default:
+		httpError(w, http.StatusInternalServerError, err.Error())
+	}
+}
@@ -0,0 +1,224 @@
+// Dashboard for "pscanner serve". It talks to the JSON API under /api and
+// polls while scans are running.
+"use strict";
+
+const $ = (sel) => document.querySelector(sel);
+
+async function api(method, path, body) {
+  const opts = { method, headers: {} };
+  if (body !== undefined) {
+    opts.headers["Content-Type"] = "application/json";
+    opts.body = JSON.stringify(body);
+  }
+  const resp = await fetch(path, opts);
+  const data = await resp.json();
+  if (!resp.ok) {
+    const err = new Error(data.error || resp.statusText);
+    err.status = resp.status;
+    err.data = data;
+    throw err;
+  }
+  return data;
+}
+
+function cell(row, text, cls) {
+  const td = row.insertCell();
+  td.textContent = text;
+  if (cls) td.className = cls;
+  return td;
+}
+
+// ---- New scan ----
+
+let pending = null; // request waiting for confirmation
+
+function scanRequest(form) {
+  const req = { hosts: form.hosts.value.trim() };
+  for (const name of ["ports", "profile", "timeout", "host_timeout", "delay"]) {
+    const v = form[name].value.trim();
+    if (v) req[name] = v;
+  }
+  for (const name of ["top_ports", "workers"]) {
+    const v = form[name].value.trim();
+    if (v) req[name] = Number(v);
+  }
+  return req;
+}
+
+async function submit(req) {
+  $("#form-error").textContent = "";
+  try {
+    const scan = await api("POST", "/api/scans", req);
+    $("#confirm").hidden = true;
+    pending = null;
+    await refresh();
+    show(scan.id);
+  } catch (err) {
+    if (err.status === 409 && err.data.warnings) {
+      pending = req;
+      const list = $("#warnings");
+      list.replaceChildren(...err.data.warnings.map((w) => {
+        const li = document.createElement("li");
+        li.textContent = w;
+        return li;
+      }));
+      $("#confirm").hidden = false;
+      return;
+    }
+    $("#form-error").textContent = err.message;
+  }
+}
+
+$("#scan-form").addEventListener("submit", (ev) => {
+  ev.preventDefault();
+  submit(scanRequest(ev.target));
+});
+$("#confirm-yes").addEventListener("click", () => submit({ ...pending, confirm: true }));
+$("#confirm-no").addEventListener("click", () => {
+  pending = null;
+  $("#confirm").hidden = true;
+});
+
+// ---- Scan list ----
+
+async function cancel(id) {
+  await api("POST", `/api/scans/${id}/cancel`, {});
+  refresh();
+}
+
+function renderScans(scans) {
+  const rows = $("#scan-rows");
+  rows.replaceChildren();
+  for (const s of scans) {
+    const row = rows.insertRow();
+    row.className = "selectable";
+    row.addEventListener("click", () => show(s.id));
+    cell(row, new Date(s.started_at).toLocaleString());
+    const tags = Object.entries(s.tags || {}).map(([k, v]) => `${k}=${v}`).sort();
+    cell(row, (s.schedule ? `[${s.schedule}] ` : "") + s.targets.join(", ") +
+      (tags.length ? ` (${tags.join(", ")})` : ""));
+    cell(row, s.ports);
+    cell(row, s.state);
+    const bar = document.createElement("progress");
+    bar.max = s.probes;
+    bar.value = s.probed;
+    cell(row, "").append(bar, ` ${s.probed}/${s.probes}`);
+    cell(row, s.open_ports);
+    const actions = cell(row, "");
+    if (s.state === "running") {
+      const b = document.createElement("button");
+      b.textContent = "Cancel";
+      b.className = "secondary";
+      b.addEventListener("click", (ev) => {
+        ev.stopPropagation();
+        cancel(s.id);
+      });
+      actions.append(b);
+    }
+  }
+
+  const finished = scans.filter((s) => s.state !== "running");
+  for (const sel of [$("#diff-form").from, $("#diff-form").to]) {
+    const keep = sel.value;
+    sel.replaceChildren(...finished.map((s) => {
+      const o = document.createElement("option");
+      o.value = s.id;
+      o.textContent = `${new Date(s.started_at).toLocaleString()} – ${s.targets.join(", ")}`;
+      return o;
+    }));
+    if (keep) sel.value = keep;
+  }
+  if (!$("#diff-form").from.value && finished.length > 1) {
+    // Default to comparing the two most recent runs, oldest first.
+    $("#diff-form").from.value = finished[1].id;
+  }
+}
+
+async function refresh() {
+  const scans = await api("GET", "/api/scans");
+  renderScans(scans);
+  return scans;
+}
+
+// ---- Scan detail ----
+
+let current = null; // { id, next, timer }
+
+async function show(id) {
+  if (current) clearTimeout(current.timer);
+  current = { id, next: 0 };
+  $("#detail").hidden = false;
+  $("#detail-id").textContent = id;
+  $("#detail-rows").replaceChildren();
+  poll();
+}
+
+function addPort(p) {
+  const row = $("#detail-rows").insertRow();
+  cell(row, p.host);
+  cell(row, p.port);
+  cell(row, p.protocol);
+  cell(row, p.state);
+}
+
+async function poll() {
+  const c = current;
+  const st = await api("GET", `/api/scans/${c.id}?since=${c.next}`);
+  if (c !== current) return;
+  if (st.report) {
+    // Finished: show the final, ordered results.
+    $("#detail-rows").replaceChildren();
+    for (const h of st.report.hosts) {
+      for (const p of h.ports) addPort({ host: h.host, ...p });
+    }
+    const timedOut = st.report.hosts.filter((h) => h.host_timeout).map((h) => h.host);
+    $("#detail-meta").textContent = `${st.state}, ${st.open_ports} open ports` +
+      (timedOut.length ? `; host timeout reached for ${timedOut.join(", ")}` : "");
+    refresh();
+    return;
+  }
+  st.ports.forEach(addPort);
+  c.next = st.next;
+  $("#detail-meta").textContent = `${st.state}, ${st.probed}/${st.probes} probes, ${st.open_ports} open ports`;
+  c.timer = setTimeout(poll, 1000);
+  refresh();
+}
+
+// ---- Diff ----
+
+$("#diff-form").addEventListener("submit", async (ev) => {
+  ev.preventDefault();
+  $("#diff-error").textContent = "";
+  try {
+    const f = ev.target;
+    const d = await api("GET", `/api/diff?from=${encodeURIComponent(f.from.value)}&to=${encodeURIComponent(f.to.value)}`);
+    const rows = $("#diff-rows");
+    rows.replaceChildren();
+    for (const [kind, list] of [["opened", d.opened], ["closed", d.closed]]) {
+      for (const p of list) {
+        const row = rows.insertRow();
+        cell(row, kind, kind);
+        cell(row, p.host);
+        cell(row, p.port);
+        cell(row, p.protocol);
+      }
+    }
+    if (!rows.rows.length) cell(rows.insertRow(), "No changes");
+    $("#diff-table").hidden = false;
+  } catch (err) {
+    $("#diff-error").textContent = err.message;
+  }
+});
+
+// ---- Start ----
+
+(async () => {
+  const profiles = await api("GET", "/api/profiles");
+  const sel = $("#scan-form").profile;
+  for (const p of profiles) {
+    const o = document.createElement("option");
+    o.value = o.textContent = p;
+    sel.append(o);
+  }
+  await refresh();
+})();
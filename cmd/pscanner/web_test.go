@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebGuard(t *testing.T) {
+	h := newWebHandler(newJobManager(&Config{}, nil, net.LookupHost), true)
+	tests := []struct {
+		name, method, host, origin, ctype string
+		code                              int
+	}{
+		{"loopback", "GET", "127.0.0.1:8080", "", "", http.StatusOK},
+		{"localhost", "GET", "localhost:8080", "", "", http.StatusOK},
+		{"ipv6 loopback", "GET", "[::1]:8080", "", "", http.StatusOK},
+		{"rebound name", "GET", "evil.example:8080", "", "", http.StatusForbidden},
+		{"same origin", "GET", "127.0.0.1:8080", "http://127.0.0.1:8080", "", http.StatusOK},
+		{"cross origin", "GET", "127.0.0.1:8080", "http://evil.example", "", http.StatusForbidden},
+		{"form post", "POST", "127.0.0.1:8080", "", "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/api/scans", strings.NewReader("hosts=x"))
+		req.Host = tt.host
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		if tt.ctype != "" {
+			req.Header.Set("Content-Type", tt.ctype)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.code {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.code)
+		}
+	}
+}
+
+func TestWebScanHistoryAndDiff(t *testing.T) {
+	store, err := openResultStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(newWebHandler(newJobManager(&Config{}, store, net.LookupHost), true))
+	defer srv.Close()
+	port := localPort(t)
+
+	call := func(method, path, body string, code int, v any) {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != code {
+			t.Fatalf("%s %s: status %d, want %d", method, path, resp.StatusCode, code)
+		}
+		if v != nil {
+			if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	wait := func(id string) *Report {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			var st scanStatus
+			call("GET", "/api/scans/"+id, "", http.StatusOK, &st)
+			if st.Report != nil {
+				return st.Report
+			}
+		}
+		t.Fatal("scan did not finish")
+		return nil
+	}
+
+	call("POST", "/api/scans", `{"hosts": "8.8.8.8", "ports": "53"}`, http.StatusConflict, nil)
+	call("POST", "/api/scans", `{"ports": "53"}`, http.StatusBadRequest, nil)
+
+	body := `{"hosts": "127.0.0.1", "ports": "` + strconv.Itoa(port) + `", "timeout": "1s"}`
+	var first, second scanSummary
+	call("POST", "/api/scans", body, http.StatusCreated, &first)
+	if r := wait(first.ID); len(r.Hosts) != 1 || len(r.Hosts[0].Ports) != 1 {
+		t.Fatalf("unexpected report: %+v", r)
+	}
+	call("POST", "/api/scans", body, http.StatusCreated, &second)
+	wait(second.ID)
+
+	var list []scanSummary
+	call("GET", "/api/scans", "", http.StatusOK, &list)
+	if len(list) != 2 || list[0].ID != second.ID || list[1].OpenPorts != 1 {
+		t.Errorf("unexpected scan list: %+v", list)
+	}
+	if _, err := store.load(first.ID); err != nil {
+		t.Errorf("finished scan not stored: %v", err)
+	}
+
+	var d reportDiff
+	call("GET", "/api/diff?from="+first.ID+"&to="+second.ID, "", http.StatusOK, &d)
+	if len(d.Opened) != 0 || len(d.Closed) != 0 {
+		t.Errorf("identical runs differ: %+v", d)
+	}
+	call("GET", "/api/diff?from="+first.ID+"&to=../../etc/passwd", "", http.StatusNotFound, nil)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Webhook is an HTTP endpoint that receives a JSON payload when a scan
+// finishes, either after every scan or only when one of its conditions is
+// met.
+type Webhook struct {
+	URL string `json:"url"`
+	// Secret, when set, signs every delivery with HMAC-SHA256.
+	Secret string `json:"secret,omitempty"`
+	// On lists the conditions that trigger a delivery: "finished" (every
+	// scan), "opened" (a port opened since the previous run), "changed" (a
+	// port opened or closed) and "ports" (one of AlertPorts is open). Empty
+	// means "finished", or "ports" when AlertPorts is set.
+	On []string `json:"on,omitempty"`
+	// AlertPorts is a port list as for --ports, such as "3389,445".
+	AlertPorts string `json:"alert_ports,omitempty"`
+}
+
+// webhookConditions are the values accepted in Webhook.On.
+var webhookConditions = []string{"finished", "opened", "changed", "ports"}
+
+// webhookPayload is the JSON body of a delivery.
+type webhookPayload struct {
+	// Reasons lists the conditions the run met, in webhookConditions order.
+	Reasons  []string    `json:"reasons"`
+	Schedule string      `json:"schedule,omitempty"`
+	Report   *Report     `json:"report"`
+	Diff     *reportDiff `json:"diff,omitempty"`
+	// Alerts are the open ports that are on the alert list.
+	Alerts []portChange `json:"alerts,omitempty"`
+}
+
+const (
+	webhookAttempts = 4
+	webhookBackoff  = time.Second // doubled after every failed attempt
+)
+
+// triggers decides which runs a sink reports, from the conditions of a
+// Webhook. Email uses the same conditions.
+type triggers struct {
+	on    map[string]bool
+	alert map[int]bool
+}
+
+func newTriggers(on []string, alertPorts string, groups map[string]string) (triggers, error) {
+	t := triggers{on: make(map[string]bool)}
+	for _, c := range on {
+		if !slices.Contains(webhookConditions, c) {
+			return t, fmt.Errorf("unknown condition %q (want %s)", c, strings.Join(webhookConditions, ", "))
+		}
+		t.on[c] = true
+	}
+	if alertPorts != "" {
+		ports, err := parsePorts(alertPorts, groups)
+		if err != nil {
+			return t, fmt.Errorf("alert ports: %v", err)
+		}
+		t.alert = make(map[int]bool, len(ports))
+		for _, p := range ports {
+			t.alert[p] = true
+		}
+		t.on["ports"] = true
+	}
+	if t.on["ports"] && t.alert == nil {
+		return t, fmt.Errorf("condition \"ports\" needs alert ports")
+	}
+	if len(t.on) == 0 {
+		t.on["finished"] = true
+	}
+	return t, nil
+}
+
+// check returns the conditions run meets, in webhookConditions order, and
+// the open ports on the alert list.
+func (t triggers) check(run scanRun) (reasons []string, alerts []portChange) {
+	for _, h := range run.Report.Hosts {
+		for _, r := range h.Ports {
+			if t.alert[r.Port] {
+				alerts = append(alerts, portOf(h, r))
+			}
+		}
+	}
+	d := run.Diff
+	met := map[string]bool{
+		"finished": true,
+		"opened":   d != nil && len(d.Opened) > 0,
+		"changed":  d != nil && len(d.Opened)+len(d.Closed) > 0,
+		"ports":    len(alerts) > 0,
+	}
+	for _, c := range webhookConditions {
+		if t.on[c] && met[c] {
+			reasons = append(reasons, c)
+		}
+	}
+	return reasons, alerts
+}
+
+// webhook delivers scan runs to one Webhook.
+type webhook struct {
+	Webhook
+	triggers
+	name     string // for error messages; the URL may embed a token
+	encode   func(webhookPayload) ([]byte, error)
+	client   *http.Client
+	attempts int
+	backoff  time.Duration
+}
+
+func newWebhook(h Webhook, groups map[string]string) (*webhook, error) {
+	u, err := url.Parse(h.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid webhook URL %q (want http:// or https://)", h.URL)
+	}
+	t, err := newTriggers(h.On, h.AlertPorts, groups)
+	if err != nil {
+		return nil, fmt.Errorf("webhook: %v", err)
+	}
+	return &webhook{
+		Webhook:  h,
+		triggers: t,
+		name:     "webhook " + u.Host,
+		encode:   func(p webhookPayload) ([]byte, error) { return json.Marshal(p) },
+		client:   &http.Client{Timeout: 10 * time.Second},
+		attempts: webhookAttempts,
+		backoff:  webhookBackoff,
+	}, nil
+}
+
+// payload builds the delivery for run; ok is false when none of the
+// webhook's conditions are met.
+func (w *webhook) payload(run scanRun) (p webhookPayload, ok bool) {
+	p = webhookPayload{Schedule: run.Schedule, Report: run.Report, Diff: run.Diff}
+	p.Reasons, p.Alerts = w.check(run)
+	return p, len(p.Reasons) > 0
+}
+
+// notify delivers run if it meets a condition. Failures are logged rather
+// than returned: a webhook that is down must not stop the scans.
+func (w *webhook) notify(run scanRun) {
+	p, ok := w.payload(run)
+	if !ok {
+		return
+	}
+	body, err := w.encode(p)
+	if err == nil {
+		err = w.deliver(body)
+	}
+	if err != nil {
+		slog.Error("webhook delivery failed", "to", w.name, "err", err)
+	}
+}
+
+// deliver posts body to the webhook.
+func (w *webhook) deliver(body []byte) error {
+	_, err := retryRequest(w.client, w.attempts, w.backoff, func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if w.Secret != "" {
+			ts := strconv.FormatInt(time.Now().Unix(), 10)
+			req.Header.Set("X-Pscanner-Timestamp", ts)
+			req.Header.Set("X-Pscanner-Signature", "sha256="+signWebhook(w.Secret, ts, body))
+		}
+		return req, nil
+	})
+	return err
+}
+
+// statusError is the error of a request the server answered with an
+// unsuccessful status.
+type statusError struct {
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string { return "server answered " + e.status }
+
+// retryRequest sends the request built by newReq, building it afresh for
+// every attempt, and retries with exponential backoff after network errors,
+// 5xx and 429 answers. It returns the body of the successful response.
+func retryRequest(client *http.Client, attempts int, backoff time.Duration, newReq func() (*http.Request, error)) ([]byte, error) {
+	for attempt := 1; ; attempt++ {
+		req, err := newReq()
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("User-Agent", "pscanner/"+currentBuild().Version)
+		resp, err := client.Do(req)
+		retry := true
+		if err == nil {
+			var body []byte
+			body, err = io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+			resp.Body.Close()
+			if err == nil && resp.StatusCode >= 200 && resp.StatusCode < 300 {
+				return body, nil
+			}
+			if err == nil {
+				err = &statusError{code: resp.StatusCode, status: resp.Status}
+				retry = resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+			}
+		}
+		if !retry {
+			return nil, err
+		}
+		if attempt == attempts {
+			return nil, fmt.Errorf("giving up after %d attempts: %v", attempt, err)
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// signWebhook computes the signature of a delivery. The timestamp is part
+// of the signed message so that a captured delivery cannot be replayed
+// later with a fresh timestamp.
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
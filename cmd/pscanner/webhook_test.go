@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewWebhookValidates(t *testing.T) {
+	for _, h := range []Webhook{
+		{URL: "ftp://example.com/hook"},
+		{URL: "example.com/hook"},
+		{URL: "https://example.com/hook", On: []string{"always"}},
+		{URL: "https://example.com/hook", On: []string{"ports"}},
+		{URL: "https://example.com/hook", AlertPorts: "@nope"},
+	} {
+		if _, err := newWebhook(h, nil); err == nil {
+			t.Errorf("newWebhook(%+v) succeeded", h)
+		}
+	}
+}
+
+func TestWebhookConditions(t *testing.T) {
+	run := scanRun{Report: testReport("1-1024", hostWith("a", 22, 445))}
+	opened := run
+	opened.Diff = &reportDiff{Opened: changes("a", 445), Closed: []portChange{}}
+	closed := run
+	closed.Diff = &reportDiff{Opened: []portChange{}, Closed: changes("a", 80)}
+
+	tests := []struct {
+		hook    Webhook
+		run     scanRun
+		reasons []string
+	}{
+		{Webhook{}, run, []string{"finished"}},
+		{Webhook{On: []string{"opened"}}, run, nil},
+		{Webhook{On: []string{"opened"}}, opened, []string{"opened"}},
+		{Webhook{On: []string{"changed"}}, run, nil},
+		{Webhook{On: []string{"opened", "changed"}}, closed, []string{"changed"}},
+		{Webhook{On: []string{"opened", "changed"}}, opened, []string{"opened", "changed"}},
+		{Webhook{AlertPorts: "3389"}, opened, nil},
+		{Webhook{AlertPorts: "3389,445"}, run, []string{"ports"}},
+		{Webhook{On: []string{"opened", "finished"}, AlertPorts: "445"}, opened, []string{"finished", "opened", "ports"}},
+	}
+	for _, tt := range tests {
+		tt.hook.URL = "http://example.com/"
+		w, err := newWebhook(tt.hook, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, ok := w.payload(tt.run)
+		if ok != (tt.reasons != nil) || !reflect.DeepEqual(p.Reasons, tt.reasons) {
+			t.Errorf("%+v: reasons %v, %v; want %v", tt.hook, p.Reasons, ok, tt.reasons)
+		}
+		if w.alert[445] && !reflect.DeepEqual(p.Alerts, changes("a", 445)) {
+			t.Errorf("%+v: alerts %v", tt.hook, p.Alerts)
+		}
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	var calls atomic.Int32
+	got := make(chan webhookPayload, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		ts := r.Header.Get("X-Pscanner-Timestamp")
+		if sig := r.Header.Get("X-Pscanner-Signature"); sig != "sha256="+signWebhook("s3cret", ts, body) {
+			t.Errorf("bad signature %q", sig)
+		}
+		var p webhookPayload
+		if err := json.Unmarshal(body, &p); err != nil {
+			t.Error(err)
+		}
+		got <- p
+	}))
+	defer srv.Close()
+
+	w, err := newWebhook(Webhook{URL: srv.URL, Secret: "s3cret"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.backoff = time.Millisecond
+	w.notify(scanRun{Schedule: "nightly", Report: testReport("22", hostWith("a", 22))})
+
+	select {
+	case p := <-got:
+		if p.Schedule != "nightly" || len(p.Report.Hosts) != 1 || !reflect.DeepEqual(p.Reasons, []string{"finished"}) {
+			t.Errorf("payload = %+v", p)
+		}
+	default:
+		t.Fatal("nothing delivered")
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("%d attempts, want 2", n)
+	}
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Error(w, "no", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	w, _ := newWebhook(Webhook{URL: srv.URL}, nil)
+	if err := w.deliver([]byte("{}")); err == nil {
+		t.Error("deliver succeeded")
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("client errors were retried: %d attempts", n)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// wireGuardPorts are the UDP ports --vpn looks for WireGuard on: its usual
+// port and Tailscale's. wireGuardControlPort is a port taken to be closed,
+// the first traceroute port, to learn how a host answers closed UDP ports.
+// Tests point them at their own sockets.
+var (
+	wireGuardPorts       = []int{51820, 41641}
+	wireGuardControlPort = 33434
+)
+
+// udpAnswer is how a UDP port answered a datagram.
+type udpAnswer int
+
+const (
+	udpSilent  udpAnswer = iota // nothing before --timeout
+	udpRefused                  // ICMP port unreachable: closed
+	udpReplied                  // a datagram came back
+)
+
+// wireGuardProbe guesses whether WireGuard listens on the wireGuardPorts
+// of addr. WireGuard stays silent towards a handshake initiation it cannot
+// authenticate, which takes the server's public key, so all a probe can
+// tell is a silent port from a closed one. That only means something on a
+// host that reports its closed ports, checked on the control port before
+// and after: a silent port is then a guess of medium confidence, or of low
+// confidence if the control port went silent once, as rate limits on ICMP
+// make it. A host that answers no closed port gets no guess, and neither
+// does a port that is closed or answers.
+func (p *scanPlan) wireGuardProbe(ctx context.Context, addr netip.Addr) ([]VPNInfo, error) {
+	if len(wireGuardPorts) == 0 {
+		return nil, nil
+	}
+	// A handshake initiation (type 1) of the right size, whose keys and
+	// MACs are random.
+	initiation := make([]byte, 148)
+	rand.Read(initiation[4:])
+	initiation[0] = 1
+	clear(initiation[116:]) // no cookie, so no MAC2
+
+	before, err := p.udpAnswer(ctx, addr, wireGuardControlPort, initiation)
+	if err != nil || before != udpRefused {
+		return nil, err
+	}
+	answers := make([]udpAnswer, len(wireGuardPorts))
+	for i, port := range wireGuardPorts {
+		if answers[i], err = p.udpAnswer(ctx, addr, port, initiation); err != nil {
+			return nil, err
+		}
+	}
+	after, err := p.udpAnswer(ctx, addr, wireGuardControlPort, initiation)
+	if err != nil {
+		return nil, err
+	}
+	confidence := "medium"
+	if after != udpRefused {
+		confidence = "low"
+	}
+	var guesses []VPNInfo
+	for i, port := range wireGuardPorts {
+		if answers[i] == udpSilent {
+			guesses = append(guesses, VPNInfo{Port: port, Service: "wireguard", Confidence: confidence})
+		}
+	}
+	return guesses, nil
+}
+
+// udpAnswer sends payload to port of addr and waits up to --timeout for
+// an answer.
+func (p *scanPlan) udpAnswer(ctx context.Context, addr netip.Addr, port int, payload []byte) (udpAnswer, error) {
+	conn, err := p.dialUDP(addr, port)
+	if err != nil {
+		return udpSilent, err
+	}
+	defer conn.Close()
+	defer context.AfterFunc(ctx, func() { conn.Close() })()
+
+	if _, err := conn.Write(payload); err != nil {
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			return udpRefused, nil
+		}
+		return udpSilent, err
+	}
+	conn.SetReadDeadline(time.Now().Add(p.timeout))
+	_, err = conn.Read(make([]byte, 1500))
+	switch {
+	case err == nil:
+		return udpReplied, nil
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return udpRefused, nil
+	}
+	return udpSilent, nil
+}
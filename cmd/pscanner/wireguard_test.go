@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// freeUDPPort returns a local UDP port nothing listens on, which answers
+// with ICMP port unreachable.
+func freeUDPPort(t *testing.T) int {
+	pc := listenUDP(t)
+	defer pc.Close()
+	return pc.LocalAddr().(*net.UDPAddr).Port
+}
+
+func TestWireGuardProbe(t *testing.T) {
+	silent := listenUDP(t) // bound, never read: how WireGuard looks
+	defer silent.Close()
+	echo := listenUDP(t)
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, from, err := echo.ReadFromUDPAddrPort(buf)
+			if err != nil {
+				return
+			}
+			echo.WriteToUDPAddrPort(buf[:n], from)
+		}
+	}()
+	silentPort := silent.LocalAddr().(*net.UDPAddr).Port
+	echoPort := echo.LocalAddr().(*net.UDPAddr).Port
+	closed := freeUDPPort(t)
+
+	defer func(ports []int, control int) { wireGuardPorts, wireGuardControlPort = ports, control }(wireGuardPorts, wireGuardControlPort)
+	p := &scanPlan{timeout: 200 * time.Millisecond}
+	addr := netip.MustParseAddr("127.0.0.1")
+
+	// Of a silent, an answering and a closed port, only the silent one
+	// may be WireGuard.
+	wireGuardPorts, wireGuardControlPort = []int{silentPort, echoPort, closed}, freeUDPPort(t)
+	got, err := p.wireGuardProbe(context.Background(), addr)
+	want := []VPNInfo{{Port: silentPort, Service: "wireguard", Confidence: "medium"}}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("wireGuardProbe = %+v, %v; want %+v", got, err, want)
+	}
+
+	// A host whose closed ports are as silent gets no guess.
+	quiet := listenUDP(t)
+	defer quiet.Close()
+	wireGuardControlPort = quiet.LocalAddr().(*net.UDPAddr).Port
+	if got, err := p.wireGuardProbe(context.Background(), addr); err != nil || got != nil {
+		t.Errorf("wireGuardProbe with a silent control port = %+v, %v; want no guess", got, err)
+	}
+}
+
+func TestUDPAnswer(t *testing.T) {
+	silent := listenUDP(t)
+	defer silent.Close()
+	p := &scanPlan{timeout: 100 * time.Millisecond}
+	addr := netip.MustParseAddr("127.0.0.1")
+	if a, err := p.udpAnswer(context.Background(), addr, freeUDPPort(t), []byte("x")); err != nil || a != udpRefused {
+		t.Errorf("closed port: %v, %v", a, err)
+	}
+	if a, err := p.udpAnswer(context.Background(), addr, silent.LocalAddr().(*net.UDPAddr).Port, []byte("x")); err != nil || a != udpSilent {
+		t.Errorf("silent port: %v, %v", a, err)
+	}
+}
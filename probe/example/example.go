@@ -0,0 +1,42 @@
+// Package example registers a memcached probe with package probe, as an
+// example of probes compiled into pscanner. Build pscanner with
+// "-tags pscanner_example" to include it.
+package example
+
+import (
+	"bufio"
+	"net"
+	"strings"
+
+	"github.com/AlirezaNezami23/pscanner/probe"
+)
+
+func init() {
+	probe.Register(probe.Probe{
+		Name:  "memcached",
+		Ports: []int{11211},
+		Doc: `the version, asked with "version"; memcached takes commands from
+anyone, which is listed among the issues`,
+		Probe: memcachedProbe,
+	})
+}
+
+// memcachedProbe asks for the version, which memcached gives without a
+// login.
+func memcachedProbe(conn net.Conn, _ probe.Target) (*probe.Service, error) {
+	if _, err := conn.Write([]byte("version\r\n")); err != nil {
+		return nil, err
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	version, ok := strings.CutPrefix(strings.TrimSpace(line), "VERSION ")
+	if !ok {
+		return nil, nil
+	}
+	return &probe.Service{
+		Details: "memcached " + version,
+		Issues:  []string{"commands taken without authentication"},
+	}, nil
+}
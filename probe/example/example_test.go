@@ -0,0 +1,24 @@
+package example
+
+import (
+	"bufio"
+	"net"
+	"testing"
+
+	"github.com/AlirezaNezami23/pscanner/probe"
+)
+
+func TestMemcachedProbe(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		if line, _ := bufio.NewReader(server).ReadString('\n'); line == "version\r\n" {
+			server.Write([]byte("VERSION 1.6.21\r\n"))
+		}
+	}()
+	s, err := memcachedProbe(client, probe.Target{Host: "127.0.0.1", Port: 11211})
+	if err != nil || s == nil || s.Details != "memcached 1.6.21" || len(s.Issues) != 1 {
+		t.Errorf("memcachedProbe = %+v, %v", s, err)
+	}
+}
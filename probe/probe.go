@@ -0,0 +1,113 @@
+// Package probe lets a build of pscanner carry TCP probes of its own, made
+// with the --tcp option alongside the built-in ones.
+//
+// A package registers its probes in an init function:
+//
+//	package acmeprobes
+//
+//	import "github.com/AlirezaNezami23/pscanner/probe"
+//
+//	func init() {
+//		probe.Register(probe.Probe{
+//			Name:  "acme-agent",
+//			Ports: []int{7441},
+//			Doc:   "the agent's version, from its HELLO",
+//			Probe: helloProbe,
+//		})
+//	}
+//
+// and a file in cmd/pscanner, built with a tag of your own, links it in:
+//
+//	//go:build acme
+//
+//	package main
+//
+//	import _ "acme.example/acmeprobes"
+//
+// so that "go build -tags acme ./cmd/pscanner" gives a pscanner with
+// --tcp acme-agent. cmd/pscanner/plugin_example.go does this for the
+// probes of package example, under the pscanner_example tag.
+package probe
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Target is the open port a probe talks to.
+type Target struct {
+	// Host is the host as it was scanned: a name or an address.
+	Host string
+	Port int
+}
+
+// Service is what a probe found on a port.
+type Service struct {
+	// Details is what the answer showed, such as the server's name and
+	// version.
+	Details string
+	// Issues are the weaknesses the probe found, such as a console that
+	// takes commands without a login.
+	Issues []string
+}
+
+// Probe is a TCP probe: a conversation with the service usually on some
+// ports, made when a scan with --tcp naming it finds one of them open.
+type Probe struct {
+	// Name is what --tcp calls the probe. It may not be a built-in
+	// probe's, or "all".
+	Name  string
+	Ports []int
+	// Doc describes what the probe reports, for --help.
+	Doc string
+	// Probe talks to the service on conn, connected to t the way the scan
+	// connected, and returns what it found, or nil if it is not that
+	// service. conn has a deadline, and is closed when Probe returns or
+	// the scan is canceled.
+	Probe func(conn net.Conn, t Target) (*Service, error)
+}
+
+var (
+	mu     sync.Mutex
+	probes = make(map[string]Probe)
+)
+
+// Register makes p available to --tcp. It panics if p is incomplete or
+// its name is taken, as registration happens in init functions, where
+// there is no one to return an error to.
+func Register(p Probe) {
+	switch {
+	case p.Name == "" || p.Name == "all" || strings.ContainsAny(p.Name, ", \t"):
+		panic(fmt.Sprintf("probe: invalid probe name %q", p.Name))
+	case len(p.Ports) == 0:
+		panic(fmt.Sprintf("probe: %s has no ports", p.Name))
+	case p.Probe == nil:
+		panic(fmt.Sprintf("probe: %s has no Probe function", p.Name))
+	}
+	for _, port := range p.Ports {
+		if port < 1 || port > 65535 {
+			panic(fmt.Sprintf("probe: %s: invalid port %d", p.Name, port))
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := probes[p.Name]; ok {
+		panic(fmt.Sprintf("probe: %s registered twice", p.Name))
+	}
+	probes[p.Name] = p
+}
+
+// Probes returns the registered probes, sorted by name.
+func Probes() []Probe {
+	mu.Lock()
+	defer mu.Unlock()
+	list := make([]Probe, 0, len(probes))
+	for _, p := range probes {
+		list = append(list, p)
+	}
+	slices.SortFunc(list, func(a, b Probe) int { return strings.Compare(a.Name, b.Name) })
+	return list
+}
@@ -0,0 +1,39 @@
+package probe
+
+import (
+	"net"
+	"testing"
+)
+
+func nop(net.Conn, Target) (*Service, error) { return nil, nil }
+
+func TestRegister(t *testing.T) {
+	Register(Probe{Name: "test-b", Ports: []int{2}, Probe: nop})
+	Register(Probe{Name: "test-a", Ports: []int{1}, Probe: nop})
+	var names []string
+	for _, p := range Probes() {
+		names = append(names, p.Name)
+	}
+	if len(names) != 2 || names[0] != "test-a" || names[1] != "test-b" {
+		t.Errorf("Probes() = %v, want test-a, test-b", names)
+	}
+
+	for _, p := range []Probe{
+		{Name: "test-a", Ports: []int{1}, Probe: nop},
+		{Name: "", Ports: []int{1}, Probe: nop},
+		{Name: "all", Ports: []int{1}, Probe: nop},
+		{Name: "a,b", Ports: []int{1}, Probe: nop},
+		{Name: "test-c", Probe: nop},
+		{Name: "test-c", Ports: []int{70000}, Probe: nop},
+		{Name: "test-c", Ports: []int{1}},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Register(%+v) did not panic", p)
+				}
+			}()
+			Register(p)
+		}()
+	}
+}
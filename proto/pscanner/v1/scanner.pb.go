@@ -0,0 +1,865 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: pscanner/v1/scanner.proto
+
+// Package pscanner.v1 is the gRPC interface of "pscanner serve". Scans are
+// submitted, then their results are streamed as they are found.
+
+package pscannerv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type ScanState int32
+
+const (
+	ScanState_SCAN_STATE_UNSPECIFIED ScanState = 0
+	ScanState_SCAN_STATE_RUNNING     ScanState = 1
+	ScanState_SCAN_STATE_COMPLETED   ScanState = 2
+	ScanState_SCAN_STATE_CANCELED    ScanState = 3
+)
+
+// Enum value maps for ScanState.
+var (
+	ScanState_name = map[int32]string{
+		0: "SCAN_STATE_UNSPECIFIED",
+		1: "SCAN_STATE_RUNNING",
+		2: "SCAN_STATE_COMPLETED",
+		3: "SCAN_STATE_CANCELED",
+	}
+	ScanState_value = map[string]int32{
+		"SCAN_STATE_UNSPECIFIED": 0,
+		"SCAN_STATE_RUNNING":     1,
+		"SCAN_STATE_COMPLETED":   2,
+		"SCAN_STATE_CANCELED":    3,
+	}
+)
+
+func (x ScanState) Enum() *ScanState {
+	p := new(ScanState)
+	*p = x
+	return p
+}
+
+func (x ScanState) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (ScanState) Descriptor() protoreflect.EnumDescriptor {
+	return file_pscanner_v1_scanner_proto_enumTypes[0].Descriptor()
+}
+
+func (ScanState) Type() protoreflect.EnumType {
+	return &file_pscanner_v1_scanner_proto_enumTypes[0]
+}
+
+func (x ScanState) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use ScanState.Descriptor instead.
+func (ScanState) EnumDescriptor() ([]byte, []int) {
+	return file_pscanner_v1_scanner_proto_rawDescGZIP(), []int{0}
+}
+
+// SubmitScanRequest mirrors the flags of "pscanner scan". Unset fields take
+// the profile value, then the scan default.
+type SubmitScanRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Comma-separated names, IPs and CIDR blocks, as for --host.
+	Hosts string `protobuf:"bytes,1,opt,name=hosts,proto3" json:"hosts,omitempty"`
+	// Port list as for --ports, e.g. "80,443,@web". Exclusive with top_ports.
+	Ports       string               `protobuf:"bytes,2,opt,name=ports,proto3" json:"ports,omitempty"`
+	TopPorts    int32                `protobuf:"varint,3,opt,name=top_ports,json=topPorts,proto3" json:"top_ports,omitempty"`
+	Profile     string               `protobuf:"bytes,4,opt,name=profile,proto3" json:"profile,omitempty"`
+	Workers     int32                `protobuf:"varint,5,opt,name=workers,proto3" json:"workers,omitempty"`
+	Timeout     *durationpb.Duration `protobuf:"bytes,6,opt,name=timeout,proto3" json:"timeout,omitempty"`
+	HostTimeout *durationpb.Duration `protobuf:"bytes,7,opt,name=host_timeout,json=hostTimeout,proto3" json:"host_timeout,omitempty"`
+	Delay       *durationpb.Duration `protobuf:"bytes,8,opt,name=delay,proto3" json:"delay,omitempty"`
+	// Scans that exceed the server's confirm_probes limit or target public
+	// addresses are rejected unless confirm is set, like --yes on the CLI.
+	Confirm       bool `protobuf:"varint,9,opt,name=confirm,proto3" json:"confirm,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubmitScanRequest) Reset() {
+	*x = SubmitScanRequest{}
+	mi := &file_pscanner_v1_scanner_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubmitScanRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubmitScanRequest) ProtoMessage() {}
+
+func (x *SubmitScanRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pscanner_v1_scanner_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubmitScanRequest.ProtoReflect.Descriptor instead.
+func (*SubmitScanRequest) Descriptor() ([]byte, []int) {
+	return file_pscanner_v1_scanner_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *SubmitScanRequest) GetHosts() string {
+	if x != nil {
+		return x.Hosts
+	}
+	return ""
+}
+
+func (x *SubmitScanRequest) GetPorts() string {
+	if x != nil {
+		return x.Ports
+	}
+	return ""
+}
+
+func (x *SubmitScanRequest) GetTopPorts() int32 {
+	if x != nil {
+		return x.TopPorts
+	}
+	return 0
+}
+
+func (x *SubmitScanRequest) GetProfile() string {
+	if x != nil {
+		return x.Profile
+	}
+	return ""
+}
+
+func (x *SubmitScanRequest) GetWorkers() int32 {
+	if x != nil {
+		return x.Workers
+	}
+	return 0
+}
+
+func (x *SubmitScanRequest) GetTimeout() *durationpb.Duration {
+	if x != nil {
+		return x.Timeout
+	}
+	return nil
+}
+
+func (x *SubmitScanRequest) GetHostTimeout() *durationpb.Duration {
+	if x != nil {
+		return x.HostTimeout
+	}
+	return nil
+}
+
+func (x *SubmitScanRequest) GetDelay() *durationpb.Duration {
+	if x != nil {
+		return x.Delay
+	}
+	return nil
+}
+
+func (x *SubmitScanRequest) GetConfirm() bool {
+	if x != nil {
+		return x.Confirm
+	}
+	return false
+}
+
+type SubmitScanResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ScanId        string                 `protobuf:"bytes,1,opt,name=scan_id,json=scanId,proto3" json:"scan_id,omitempty"`
+	Parameters    *ScanParameters        `protobuf:"bytes,2,opt,name=parameters,proto3" json:"parameters,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubmitScanResponse) Reset() {
+	*x = SubmitScanResponse{}
+	mi := &file_pscanner_v1_scanner_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubmitScanResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubmitScanResponse) ProtoMessage() {}
+
+func (x *SubmitScanResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_pscanner_v1_scanner_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubmitScanResponse.ProtoReflect.Descriptor instead.
+func (*SubmitScanResponse) Descriptor() ([]byte, []int) {
+	return file_pscanner_v1_scanner_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *SubmitScanResponse) GetScanId() string {
+	if x != nil {
+		return x.ScanId
+	}
+	return ""
+}
+
+func (x *SubmitScanResponse) GetParameters() *ScanParameters {
+	if x != nil {
+		return x.Parameters
+	}
+	return nil
+}
+
+// ScanParameters are the effective settings after profiles and defaults.
+type ScanParameters struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Targets       []string               `protobuf:"bytes,1,rep,name=targets,proto3" json:"targets,omitempty"`
+	TargetCount   int64                  `protobuf:"varint,2,opt,name=target_count,json=targetCount,proto3" json:"target_count,omitempty"`
+	Ports         string                 `protobuf:"bytes,3,opt,name=ports,proto3" json:"ports,omitempty"`
+	PortCount     int32                  `protobuf:"varint,4,opt,name=port_count,json=portCount,proto3" json:"port_count,omitempty"`
+	Workers       int32                  `protobuf:"varint,5,opt,name=workers,proto3" json:"workers,omitempty"`
+	Timeout       *durationpb.Duration   `protobuf:"bytes,6,opt,name=timeout,proto3" json:"timeout,omitempty"`
+	HostTimeout   *durationpb.Duration   `protobuf:"bytes,7,opt,name=host_timeout,json=hostTimeout,proto3" json:"host_timeout,omitempty"`
+	Delay         *durationpb.Duration   `protobuf:"bytes,8,opt,name=delay,proto3" json:"delay,omitempty"`
+	Profile       string                 `protobuf:"bytes,9,opt,name=profile,proto3" json:"profile,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ScanParameters) Reset() {
+	*x = ScanParameters{}
+	mi := &file_pscanner_v1_scanner_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ScanParameters) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ScanParameters) ProtoMessage() {}
+
+func (x *ScanParameters) ProtoReflect() protoreflect.Message {
+	mi := &file_pscanner_v1_scanner_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ScanParameters.ProtoReflect.Descriptor instead.
+func (*ScanParameters) Descriptor() ([]byte, []int) {
+	return file_pscanner_v1_scanner_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ScanParameters) GetTargets() []string {
+	if x != nil {
+		return x.Targets
+	}
+	return nil
+}
+
+func (x *ScanParameters) GetTargetCount() int64 {
+	if x != nil {
+		return x.TargetCount
+	}
+	return 0
+}
+
+func (x *ScanParameters) GetPorts() string {
+	if x != nil {
+		return x.Ports
+	}
+	return ""
+}
+
+func (x *ScanParameters) GetPortCount() int32 {
+	if x != nil {
+		return x.PortCount
+	}
+	return 0
+}
+
+func (x *ScanParameters) GetWorkers() int32 {
+	if x != nil {
+		return x.Workers
+	}
+	return 0
+}
+
+func (x *ScanParameters) GetTimeout() *durationpb.Duration {
+	if x != nil {
+		return x.Timeout
+	}
+	return nil
+}
+
+func (x *ScanParameters) GetHostTimeout() *durationpb.Duration {
+	if x != nil {
+		return x.HostTimeout
+	}
+	return nil
+}
+
+func (x *ScanParameters) GetDelay() *durationpb.Duration {
+	if x != nil {
+		return x.Delay
+	}
+	return nil
+}
+
+func (x *ScanParameters) GetProfile() string {
+	if x != nil {
+		return x.Profile
+	}
+	return ""
+}
+
+type StreamResultsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ScanId        string                 `protobuf:"bytes,1,opt,name=scan_id,json=scanId,proto3" json:"scan_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamResultsRequest) Reset() {
+	*x = StreamResultsRequest{}
+	mi := &file_pscanner_v1_scanner_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamResultsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamResultsRequest) ProtoMessage() {}
+
+func (x *StreamResultsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pscanner_v1_scanner_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamResultsRequest.ProtoReflect.Descriptor instead.
+func (*StreamResultsRequest) Descriptor() ([]byte, []int) {
+	return file_pscanner_v1_scanner_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *StreamResultsRequest) GetScanId() string {
+	if x != nil {
+		return x.ScanId
+	}
+	return ""
+}
+
+type ScanEvent struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Event:
+	//
+	//	*ScanEvent_Port
+	//	*ScanEvent_Finished
+	Event         isScanEvent_Event `protobuf_oneof:"event"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ScanEvent) Reset() {
+	*x = ScanEvent{}
+	mi := &file_pscanner_v1_scanner_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ScanEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ScanEvent) ProtoMessage() {}
+
+func (x *ScanEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_pscanner_v1_scanner_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ScanEvent.ProtoReflect.Descriptor instead.
+func (*ScanEvent) Descriptor() ([]byte, []int) {
+	return file_pscanner_v1_scanner_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ScanEvent) GetEvent() isScanEvent_Event {
+	if x != nil {
+		return x.Event
+	}
+	return nil
+}
+
+func (x *ScanEvent) GetPort() *PortResult {
+	if x != nil {
+		if x, ok := x.Event.(*ScanEvent_Port); ok {
+			return x.Port
+		}
+	}
+	return nil
+}
+
+func (x *ScanEvent) GetFinished() *ScanFinished {
+	if x != nil {
+		if x, ok := x.Event.(*ScanEvent_Finished); ok {
+			return x.Finished
+		}
+	}
+	return nil
+}
+
+type isScanEvent_Event interface {
+	isScanEvent_Event()
+}
+
+type ScanEvent_Port struct {
+	Port *PortResult `protobuf:"bytes,1,opt,name=port,proto3,oneof"`
+}
+
+type ScanEvent_Finished struct {
+	Finished *ScanFinished `protobuf:"bytes,2,opt,name=finished,proto3,oneof"`
+}
+
+func (*ScanEvent_Port) isScanEvent_Event() {}
+
+func (*ScanEvent_Finished) isScanEvent_Event() {}
+
+type PortResult struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Host          string                 `protobuf:"bytes,1,opt,name=host,proto3" json:"host,omitempty"`
+	Port          int32                  `protobuf:"varint,2,opt,name=port,proto3" json:"port,omitempty"`
+	Protocol      string                 `protobuf:"bytes,3,opt,name=protocol,proto3" json:"protocol,omitempty"`
+	State         string                 `protobuf:"bytes,4,opt,name=state,proto3" json:"state,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PortResult) Reset() {
+	*x = PortResult{}
+	mi := &file_pscanner_v1_scanner_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PortResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PortResult) ProtoMessage() {}
+
+func (x *PortResult) ProtoReflect() protoreflect.Message {
+	mi := &file_pscanner_v1_scanner_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PortResult.ProtoReflect.Descriptor instead.
+func (*PortResult) Descriptor() ([]byte, []int) {
+	return file_pscanner_v1_scanner_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *PortResult) GetHost() string {
+	if x != nil {
+		return x.Host
+	}
+	return ""
+}
+
+func (x *PortResult) GetPort() int32 {
+	if x != nil {
+		return x.Port
+	}
+	return 0
+}
+
+func (x *PortResult) GetProtocol() string {
+	if x != nil {
+		return x.Protocol
+	}
+	return ""
+}
+
+func (x *PortResult) GetState() string {
+	if x != nil {
+		return x.State
+	}
+	return ""
+}
+
+type ScanFinished struct {
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	State      ScanState              `protobuf:"varint,1,opt,name=state,proto3,enum=pscanner.v1.ScanState" json:"state,omitempty"`
+	StartedAt  *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=started_at,json=startedAt,proto3" json:"started_at,omitempty"`
+	FinishedAt *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=finished_at,json=finishedAt,proto3" json:"finished_at,omitempty"`
+	OpenPorts  int32                  `protobuf:"varint,4,opt,name=open_ports,json=openPorts,proto3" json:"open_ports,omitempty"`
+	// Hosts abandoned because host_timeout was reached.
+	TimedOutHosts []string `protobuf:"bytes,5,rep,name=timed_out_hosts,json=timedOutHosts,proto3" json:"timed_out_hosts,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ScanFinished) Reset() {
+	*x = ScanFinished{}
+	mi := &file_pscanner_v1_scanner_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ScanFinished) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ScanFinished) ProtoMessage() {}
+
+func (x *ScanFinished) ProtoReflect() protoreflect.Message {
+	mi := &file_pscanner_v1_scanner_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ScanFinished.ProtoReflect.Descriptor instead.
+func (*ScanFinished) Descriptor() ([]byte, []int) {
+	return file_pscanner_v1_scanner_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ScanFinished) GetState() ScanState {
+	if x != nil {
+		return x.State
+	}
+	return ScanState_SCAN_STATE_UNSPECIFIED
+}
+
+func (x *ScanFinished) GetStartedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.StartedAt
+	}
+	return nil
+}
+
+func (x *ScanFinished) GetFinishedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.FinishedAt
+	}
+	return nil
+}
+
+func (x *ScanFinished) GetOpenPorts() int32 {
+	if x != nil {
+		return x.OpenPorts
+	}
+	return 0
+}
+
+func (x *ScanFinished) GetTimedOutHosts() []string {
+	if x != nil {
+		return x.TimedOutHosts
+	}
+	return nil
+}
+
+type CancelScanRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ScanId        string                 `protobuf:"bytes,1,opt,name=scan_id,json=scanId,proto3" json:"scan_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CancelScanRequest) Reset() {
+	*x = CancelScanRequest{}
+	mi := &file_pscanner_v1_scanner_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CancelScanRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CancelScanRequest) ProtoMessage() {}
+
+func (x *CancelScanRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pscanner_v1_scanner_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CancelScanRequest.ProtoReflect.Descriptor instead.
+func (*CancelScanRequest) Descriptor() ([]byte, []int) {
+	return file_pscanner_v1_scanner_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *CancelScanRequest) GetScanId() string {
+	if x != nil {
+		return x.ScanId
+	}
+	return ""
+}
+
+type CancelScanResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	State         ScanState              `protobuf:"varint,1,opt,name=state,proto3,enum=pscanner.v1.ScanState" json:"state,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CancelScanResponse) Reset() {
+	*x = CancelScanResponse{}
+	mi := &file_pscanner_v1_scanner_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CancelScanResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CancelScanResponse) ProtoMessage() {}
+
+func (x *CancelScanResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_pscanner_v1_scanner_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CancelScanResponse.ProtoReflect.Descriptor instead.
+func (*CancelScanResponse) Descriptor() ([]byte, []int) {
+	return file_pscanner_v1_scanner_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *CancelScanResponse) GetState() ScanState {
+	if x != nil {
+		return x.State
+	}
+	return ScanState_SCAN_STATE_UNSPECIFIED
+}
+
+var File_pscanner_v1_scanner_proto protoreflect.FileDescriptor
+
+const file_pscanner_v1_scanner_proto_rawDesc = "" +
+	"\n" +
+	"\x19pscanner/v1/scanner.proto\x12\vpscanner.v1\x1a\x1egoogle/protobuf/duration.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\xce\x02\n" +
+	"\x11SubmitScanRequest\x12\x14\n" +
+	"\x05hosts\x18\x01 \x01(\tR\x05hosts\x12\x14\n" +
+	"\x05ports\x18\x02 \x01(\tR\x05ports\x12\x1b\n" +
+	"\ttop_ports\x18\x03 \x01(\x05R\btopPorts\x12\x18\n" +
+	"\aprofile\x18\x04 \x01(\tR\aprofile\x12\x18\n" +
+	"\aworkers\x18\x05 \x01(\x05R\aworkers\x123\n" +
+	"\atimeout\x18\x06 \x01(\v2\x19.google.protobuf.DurationR\atimeout\x12<\n" +
+	"\fhost_timeout\x18\a \x01(\v2\x19.google.protobuf.DurationR\vhostTimeout\x12/\n" +
+	"\x05delay\x18\b \x01(\v2\x19.google.protobuf.DurationR\x05delay\x12\x18\n" +
+	"\aconfirm\x18\t \x01(\bR\aconfirm\"j\n" +
+	"\x12SubmitScanResponse\x12\x17\n" +
+	"\ascan_id\x18\x01 \x01(\tR\x06scanId\x12;\n" +
+	"\n" +
+	"parameters\x18\x02 \x01(\v2\x1b.pscanner.v1.ScanParametersR\n" +
+	"parameters\"\xda\x02\n" +
+	"\x0eScanParameters\x12\x18\n" +
+	"\atargets\x18\x01 \x03(\tR\atargets\x12!\n" +
+	"\ftarget_count\x18\x02 \x01(\x03R\vtargetCount\x12\x14\n" +
+	"\x05ports\x18\x03 \x01(\tR\x05ports\x12\x1d\n" +
+	"\n" +
+	"port_count\x18\x04 \x01(\x05R\tportCount\x12\x18\n" +
+	"\aworkers\x18\x05 \x01(\x05R\aworkers\x123\n" +
+	"\atimeout\x18\x06 \x01(\v2\x19.google.protobuf.DurationR\atimeout\x12<\n" +
+	"\fhost_timeout\x18\a \x01(\v2\x19.google.protobuf.DurationR\vhostTimeout\x12/\n" +
+	"\x05delay\x18\b \x01(\v2\x19.google.protobuf.DurationR\x05delay\x12\x18\n" +
+	"\aprofile\x18\t \x01(\tR\aprofile\"/\n" +
+	"\x14StreamResultsRequest\x12\x17\n" +
+	"\ascan_id\x18\x01 \x01(\tR\x06scanId\"|\n" +
+	"\tScanEvent\x12-\n" +
+	"\x04port\x18\x01 \x01(\v2\x17.pscanner.v1.PortResultH\x00R\x04port\x127\n" +
+	"\bfinished\x18\x02 \x01(\v2\x19.pscanner.v1.ScanFinishedH\x00R\bfinishedB\a\n" +
+	"\x05event\"f\n" +
+	"\n" +
+	"PortResult\x12\x12\n" +
+	"\x04host\x18\x01 \x01(\tR\x04host\x12\x12\n" +
+	"\x04port\x18\x02 \x01(\x05R\x04port\x12\x1a\n" +
+	"\bprotocol\x18\x03 \x01(\tR\bprotocol\x12\x14\n" +
+	"\x05state\x18\x04 \x01(\tR\x05state\"\xfb\x01\n" +
+	"\fScanFinished\x12,\n" +
+	"\x05state\x18\x01 \x01(\x0e2\x16.pscanner.v1.ScanStateR\x05state\x129\n" +
+	"\n" +
+	"started_at\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\tstartedAt\x12;\n" +
+	"\vfinished_at\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"finishedAt\x12\x1d\n" +
+	"\n" +
+	"open_ports\x18\x04 \x01(\x05R\topenPorts\x12&\n" +
+	"\x0ftimed_out_hosts\x18\x05 \x03(\tR\rtimedOutHosts\",\n" +
+	"\x11CancelScanRequest\x12\x17\n" +
+	"\ascan_id\x18\x01 \x01(\tR\x06scanId\"B\n" +
+	"\x12CancelScanResponse\x12,\n" +
+	"\x05state\x18\x01 \x01(\x0e2\x16.pscanner.v1.ScanStateR\x05state*r\n" +
+	"\tScanState\x12\x1a\n" +
+	"\x16SCAN_STATE_UNSPECIFIED\x10\x00\x12\x16\n" +
+	"\x12SCAN_STATE_RUNNING\x10\x01\x12\x18\n" +
+	"\x14SCAN_STATE_COMPLETED\x10\x02\x12\x17\n" +
+	"\x13SCAN_STATE_CANCELED\x10\x032\xf5\x01\n" +
+	"\aScanner\x12M\n" +
+	"\n" +
+	"SubmitScan\x12\x1e.pscanner.v1.SubmitScanRequest\x1a\x1f.pscanner.v1.SubmitScanResponse\x12L\n" +
+	"\rStreamResults\x12!.pscanner.v1.StreamResultsRequest\x1a\x16.pscanner.v1.ScanEvent0\x01\x12M\n" +
+	"\n" +
+	"CancelScan\x12\x1e.pscanner.v1.CancelScanRequest\x1a\x1f.pscanner.v1.CancelScanResponseBBZ@github.com/AlirezaNezami23/pscanner/proto/pscanner/v1;pscannerv1b\x06proto3"
+
+var (
+	file_pscanner_v1_scanner_proto_rawDescOnce sync.Once
+	file_pscanner_v1_scanner_proto_rawDescData []byte
+)
+
+func file_pscanner_v1_scanner_proto_rawDescGZIP() []byte {
+	file_pscanner_v1_scanner_proto_rawDescOnce.Do(func() {
+		file_pscanner_v1_scanner_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_pscanner_v1_scanner_proto_rawDesc), len(file_pscanner_v1_scanner_proto_rawDesc)))
+	})
+	return file_pscanner_v1_scanner_proto_rawDescData
+}
+
+var file_pscanner_v1_scanner_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_pscanner_v1_scanner_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
+var file_pscanner_v1_scanner_proto_goTypes = []any{
+	(ScanState)(0),                // 0: pscanner.v1.ScanState
+	(*SubmitScanRequest)(nil),     // 1: pscanner.v1.SubmitScanRequest
+	(*SubmitScanResponse)(nil),    // 2: pscanner.v1.SubmitScanResponse
+	(*ScanParameters)(nil),        // 3: pscanner.v1.ScanParameters
+	(*StreamResultsRequest)(nil),  // 4: pscanner.v1.StreamResultsRequest
+	(*ScanEvent)(nil),             // 5: pscanner.v1.ScanEvent
+	(*PortResult)(nil),            // 6: pscanner.v1.PortResult
+	(*ScanFinished)(nil),          // 7: pscanner.v1.ScanFinished
+	(*CancelScanRequest)(nil),     // 8: pscanner.v1.CancelScanRequest
+	(*CancelScanResponse)(nil),    // 9: pscanner.v1.CancelScanResponse
+	(*durationpb.Duration)(nil),   // 10: google.protobuf.Duration
+	(*timestamppb.Timestamp)(nil), // 11: google.protobuf.Timestamp
+}
+var file_pscanner_v1_scanner_proto_depIdxs = []int32{
+	10, // 0: pscanner.v1.SubmitScanRequest.timeout:type_name -> google.protobuf.Duration
+	10, // 1: pscanner.v1.SubmitScanRequest.host_timeout:type_name -> google.protobuf.Duration
+	10, // 2: pscanner.v1.SubmitScanRequest.delay:type_name -> google.protobuf.Duration
+	3,  // 3: pscanner.v1.SubmitScanResponse.parameters:type_name -> pscanner.v1.ScanParameters
+	10, // 4: pscanner.v1.ScanParameters.timeout:type_name -> google.protobuf.Duration
+	10, // 5: pscanner.v1.ScanParameters.host_timeout:type_name -> google.protobuf.Duration
+	10, // 6: pscanner.v1.ScanParameters.delay:type_name -> google.protobuf.Duration
+	6,  // 7: pscanner.v1.ScanEvent.port:type_name -> pscanner.v1.PortResult
+	7,  // 8: pscanner.v1.ScanEvent.finished:type_name -> pscanner.v1.ScanFinished
+	0,  // 9: pscanner.v1.ScanFinished.state:type_name -> pscanner.v1.ScanState
+	11, // 10: pscanner.v1.ScanFinished.started_at:type_name -> google.protobuf.Timestamp
+	11, // 11: pscanner.v1.ScanFinished.finished_at:type_name -> google.protobuf.Timestamp
+	0,  // 12: pscanner.v1.CancelScanResponse.state:type_name -> pscanner.v1.ScanState
+	1,  // 13: pscanner.v1.Scanner.SubmitScan:input_type -> pscanner.v1.SubmitScanRequest
+	4,  // 14: pscanner.v1.Scanner.StreamResults:input_type -> pscanner.v1.StreamResultsRequest
+	8,  // 15: pscanner.v1.Scanner.CancelScan:input_type -> pscanner.v1.CancelScanRequest
+	2,  // 16: pscanner.v1.Scanner.SubmitScan:output_type -> pscanner.v1.SubmitScanResponse
+	5,  // 17: pscanner.v1.Scanner.StreamResults:output_type -> pscanner.v1.ScanEvent
+	9,  // 18: pscanner.v1.Scanner.CancelScan:output_type -> pscanner.v1.CancelScanResponse
+	16, // [16:19] is the sub-list for method output_type
+	13, // [13:16] is the sub-list for method input_type
+	13, // [13:13] is the sub-list for extension type_name
+	13, // [13:13] is the sub-list for extension extendee
+	0,  // [0:13] is the sub-list for field type_name
+}
+
+func init() { file_pscanner_v1_scanner_proto_init() }
+func file_pscanner_v1_scanner_proto_init() {
+	if File_pscanner_v1_scanner_proto != nil {
+		return
+	}
+	file_pscanner_v1_scanner_proto_msgTypes[4].OneofWrappers = []any{
+		(*ScanEvent_Port)(nil),
+		(*ScanEvent_Finished)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_pscanner_v1_scanner_proto_rawDesc), len(file_pscanner_v1_scanner_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   9,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_pscanner_v1_scanner_proto_goTypes,
+		DependencyIndexes: file_pscanner_v1_scanner_proto_depIdxs,
+		EnumInfos:         file_pscanner_v1_scanner_proto_enumTypes,
+		MessageInfos:      file_pscanner_v1_scanner_proto_msgTypes,
+	}.Build()
+	File_pscanner_v1_scanner_proto = out.File
+	file_pscanner_v1_scanner_proto_goTypes = nil
+	file_pscanner_v1_scanner_proto_depIdxs = nil
+}
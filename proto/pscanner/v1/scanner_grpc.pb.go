@@ -0,0 +1,214 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: pscanner/v1/scanner.proto
+
+// Package pscanner.v1 is the gRPC interface of "pscanner serve". Scans are
+// submitted, then their results are streamed as they are found.
+
+package pscannerv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Scanner_SubmitScan_FullMethodName    = "/pscanner.v1.Scanner/SubmitScan"
+	Scanner_StreamResults_FullMethodName = "/pscanner.v1.Scanner/StreamResults"
+	Scanner_CancelScan_FullMethodName    = "/pscanner.v1.Scanner/CancelScan"
+)
+
+// ScannerClient is the client API for Scanner service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ScannerClient interface {
+	// SubmitScan validates the request and starts the scan in the background.
+	SubmitScan(ctx context.Context, in *SubmitScanRequest, opts ...grpc.CallOption) (*SubmitScanResponse, error)
+	// StreamResults sends every open port found so far, then new ones as they
+	// are found, and ends with a ScanFinished event. Streams may be opened
+	// while the scan runs or after it has finished.
+	StreamResults(ctx context.Context, in *StreamResultsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ScanEvent], error)
+	// CancelScan stops a running scan. Results found so far are kept.
+	CancelScan(ctx context.Context, in *CancelScanRequest, opts ...grpc.CallOption) (*CancelScanResponse, error)
+}
+
+type scannerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewScannerClient(cc grpc.ClientConnInterface) ScannerClient {
+	return &scannerClient{cc}
+}
+
+func (c *scannerClient) SubmitScan(ctx context.Context, in *SubmitScanRequest, opts ...grpc.CallOption) (*SubmitScanResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SubmitScanResponse)
+	err := c.cc.Invoke(ctx, Scanner_SubmitScan_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *scannerClient) StreamResults(ctx context.Context, in *StreamResultsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ScanEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Scanner_ServiceDesc.Streams[0], Scanner_StreamResults_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[StreamResultsRequest, ScanEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Scanner_StreamResultsClient = grpc.ServerStreamingClient[ScanEvent]
+
+func (c *scannerClient) CancelScan(ctx context.Context, in *CancelScanRequest, opts ...grpc.CallOption) (*CancelScanResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CancelScanResponse)
+	err := c.cc.Invoke(ctx, Scanner_CancelScan_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ScannerServer is the server API for Scanner service.
+// All implementations must embed UnimplementedScannerServer
+// for forward compatibility.
+type ScannerServer interface {
+	// SubmitScan validates the request and starts the scan in the background.
+	SubmitScan(context.Context, *SubmitScanRequest) (*SubmitScanResponse, error)
+	// StreamResults sends every open port found so far, then new ones as they
+	// are found, and ends with a ScanFinished event. Streams may be opened
+	// while the scan runs or after it has finished.
+	StreamResults(*StreamResultsRequest, grpc.ServerStreamingServer[ScanEvent]) error
+	// CancelScan stops a running scan. Results found so far are kept.
+	CancelScan(context.Context, *CancelScanRequest) (*CancelScanResponse, error)
+	mustEmbedUnimplementedScannerServer()
+}
+
+// UnimplementedScannerServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedScannerServer struct{}
+
+func (UnimplementedScannerServer) SubmitScan(context.Context, *SubmitScanRequest) (*SubmitScanResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method SubmitScan not implemented")
+}
+func (UnimplementedScannerServer) StreamResults(*StreamResultsRequest, grpc.ServerStreamingServer[ScanEvent]) error {
+	return status.Error(codes.Unimplemented, "method StreamResults not implemented")
+}
+func (UnimplementedScannerServer) CancelScan(context.Context, *CancelScanRequest) (*CancelScanResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method CancelScan not implemented")
+}
+func (UnimplementedScannerServer) mustEmbedUnimplementedScannerServer() {}
+func (UnimplementedScannerServer) testEmbeddedByValue()                 {}
+
+// UnsafeScannerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ScannerServer will
+// result in compilation errors.
+type UnsafeScannerServer interface {
+	mustEmbedUnimplementedScannerServer()
+}
+
+func RegisterScannerServer(s grpc.ServiceRegistrar, srv ScannerServer) {
+	// If the following call panics, it indicates UnimplementedScannerServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Scanner_ServiceDesc, srv)
+}
+
+func _Scanner_SubmitScan_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SubmitScanRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ScannerServer).SubmitScan(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Scanner_SubmitScan_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ScannerServer).SubmitScan(ctx, req.(*SubmitScanRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Scanner_StreamResults_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamResultsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ScannerServer).StreamResults(m, &grpc.GenericServerStream[StreamResultsRequest, ScanEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Scanner_StreamResultsServer = grpc.ServerStreamingServer[ScanEvent]
+
+func _Scanner_CancelScan_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelScanRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ScannerServer).CancelScan(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Scanner_CancelScan_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ScannerServer).CancelScan(ctx, req.(*CancelScanRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Scanner_ServiceDesc is the grpc.ServiceDesc for Scanner service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Scanner_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "pscanner.v1.Scanner",
+	HandlerType: (*ScannerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "SubmitScan",
+			Handler:    _Scanner_SubmitScan_Handler,
+		},
+		{
+			MethodName: "CancelScan",
+			Handler:    _Scanner_CancelScan_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamResults",
+			Handler:       _Scanner_StreamResults_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "pscanner/v1/scanner.proto",
+}